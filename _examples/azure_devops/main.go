@@ -19,7 +19,7 @@ func main() {
 	Info("git clone %s %s", url, directory)
 
 	// Azure DevOps requires capabilities multi_ack / multi_ack_detailed,
-	// which are not fully implemented and by default are included in
+	// multi_ack not being implemented and by default being included in
 	// transport.UnsupportedCapabilities.
 	//
 	// The initial clone operations require a full download of the repository,
@@ -0,0 +1,744 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+var (
+	// ErrPatchDoesNotApply is returned when a patch can't be applied to the
+	// files it changes.
+	ErrPatchDoesNotApply = errors.New("patch does not apply")
+	// ErrPatchConflicts is returned when a patch is applied with a three-way
+	// merge which conflicts.
+	ErrPatchConflicts = errors.New("patch applied with conflicts")
+)
+
+// ApplyToTree applies patch to tree, as git apply --cached does to the
+// index, and returns the resulting tree, whose objects are written to the
+// storer of the repository. A nil tree is an empty one. The Index and Cached
+// options are ignored, with Check tree is returned when the patch applies,
+// and the conflicts of a three-way merge make it fail.
+func (r *Repository) ApplyToTree(tree *object.Tree, patch fdiff.Patch, o *ApplyOptions) (*object.Tree, error) {
+	if o == nil {
+		o = &ApplyOptions{}
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	if tree == nil {
+		tree = &object.Tree{}
+	}
+
+	p, err := toParsedPatch(patch, o)
+	if err != nil {
+		return nil, err
+	}
+
+	a := newPatchApplier(func(path string) ([]byte, filemode.FileMode, error) {
+		return readTreeFile(tree, path)
+	})
+
+	a.fuzz = o.Fuzz
+	if o.ThreeWay {
+		a.blob = r.blobByPrefix
+	}
+
+	if err := a.applyPatch(p); err != nil {
+		return nil, err
+	}
+
+	if err := a.conflictsError(); err != nil {
+		return nil, err
+	}
+
+	if o.Check {
+		return tree, nil
+	}
+
+	idx := &index.Index{Version: 2}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, e, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if e.Mode == filemode.Dir {
+			continue
+		}
+
+		ie := idx.Add(name)
+		ie.Hash, ie.Mode = e.Hash, e.Mode
+	}
+
+	if err := writeAppliedIndex(r.Storer, idx, a.changes()); err != nil {
+		return nil, err
+	}
+
+	h := &buildTreeHelper{s: r.Storer}
+	hash, err := h.BuildTree(idx, &CommitOptions{AllowEmptyCommits: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return r.TreeObject(hash)
+}
+
+// writeAppliedIndex writes the changed files to the storer and updates their
+// entries in idx. The stat info of the entries is left empty, for their
+// content to be compared with the worktree ones.
+func writeAppliedIndex(s storer.EncodedObjectStorer, idx *index.Index, files []*appliedFile) error {
+	for _, f := range files {
+		if f.deleted {
+			if _, err := idx.Remove(f.path); err != nil && err != index.ErrEntryNotFound {
+				return err
+			}
+
+			continue
+		}
+
+		h, err := writeBlob(s, f.content)
+		if err != nil {
+			return err
+		}
+
+		e, err := idx.Entry(f.path)
+		if err == index.ErrEntryNotFound {
+			e, err = idx.Add(f.path), nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		*e = index.Entry{Name: f.path, Hash: h, Mode: f.mode}
+		if f.mode.IsRegular() {
+			e.Size = uint32(len(f.content))
+		}
+	}
+
+	return nil
+}
+
+// toParsedPatch returns the patch to apply, decoding the encoded patch if it
+// isn't a parsed one, and reversing it if requested.
+func toParsedPatch(patch fdiff.Patch, o *ApplyOptions) (*fdiff.ParsedPatch, error) {
+	p, ok := patch.(*fdiff.ParsedPatch)
+	if !ok {
+		buf := bytes.NewBuffer(nil)
+		ue := fdiff.NewUnifiedEncoder(buf, fdiff.DefaultContextLines).SetBinary(true)
+		if err := ue.Encode(patch); err != nil {
+			return nil, err
+		}
+
+		p = &fdiff.ParsedPatch{}
+		if err := fdiff.NewDecoder(buf).Decode(p); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.Reverse {
+		p = p.Reverse()
+	}
+
+	return p, nil
+}
+
+func readTreeFile(tree *object.Tree, path string) ([]byte, filemode.FileMode, error) {
+	f, err := tree.File(path)
+	if err == object.ErrFileNotFound || err == object.ErrDirectoryNotFound {
+		return nil, filemode.Empty, os.ErrNotExist
+	}
+
+	if err != nil {
+		return nil, filemode.Empty, err
+	}
+
+	content, err := readBlob(&f.Blob)
+	return content, f.Mode, err
+}
+
+func readBlob(b *object.Blob) (content []byte, err error) {
+	r, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	buf := bytes.NewBuffer(nil)
+	_, err = io.Copy(buf, r)
+	return buf.Bytes(), err
+}
+
+func writeBlob(s storer.EncodedObjectStorer, content []byte) (plumbing.Hash, error) {
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if _, err := w.Write(content); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return s.SetEncodedObject(obj)
+}
+
+// blobByPrefix returns the content of the blob whose hash starts with the
+// given abbreviated hash.
+func (r *Repository) blobByPrefix(abbrev string) ([]byte, error) {
+	for _, h := range r.resolveHashPrefix(abbrev) {
+		b, err := r.BlobObject(h)
+		if err == plumbing.ErrObjectNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return readBlob(b)
+	}
+
+	return nil, plumbing.ErrObjectNotFound
+}
+
+// appliedFile is the content of a file after applying a patch.
+type appliedFile struct {
+	path    string
+	content []byte
+	mode    filemode.FileMode
+	// deleted is true if the file is removed by the patch, or doesn't exist.
+	deleted bool
+	// changed is true if the file is changed by the patch.
+	changed bool
+	// conflict is true if the file was merged with conflicts.
+	conflict bool
+}
+
+// patchApplier applies the file patches of a patch to the files returned by
+// read, which returns an error satisfying os.IsNotExist for missing files.
+// The files are changed in memory, every file patch seeing the changes of
+// the previous ones.
+type patchApplier struct {
+	read  func(path string) ([]byte, filemode.FileMode, error)
+	files map[string]*appliedFile
+
+	// fuzz is the number of context lines of every side of the hunks which
+	// can be ignored.
+	fuzz int
+	// blob returns the content of the blob with the given abbreviated hash.
+	// If set, the text file patches which don't apply are merged with a
+	// three-way merge from the blob they were made from.
+	blob func(abbrev string) ([]byte, error)
+}
+
+func newPatchApplier(read func(path string) ([]byte, filemode.FileMode, error)) *patchApplier {
+	return &patchApplier{read: read, files: make(map[string]*appliedFile)}
+}
+
+// file returns the current state of the file at path.
+func (a *patchApplier) file(path string) (*appliedFile, error) {
+	if f, ok := a.files[path]; ok {
+		return f, nil
+	}
+
+	content, mode, err := a.read(path)
+	f := &appliedFile{path: path, content: content, mode: mode}
+	if os.IsNotExist(err) {
+		f.deleted = true
+	} else if err != nil {
+		return nil, err
+	}
+
+	a.files[path] = f
+	return f, nil
+}
+
+// changes returns the files changed by the applied patches, the deleted ones
+// first.
+func (a *patchApplier) changes() []*appliedFile {
+	var changes []*appliedFile
+	for _, f := range a.files {
+		if f.changed {
+			changes = append(changes, f)
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].deleted != changes[j].deleted {
+			return changes[i].deleted
+		}
+
+		return changes[i].path < changes[j].path
+	})
+
+	return changes
+}
+
+// conflictsError returns an ErrPatchConflicts error listing the files merged
+// with conflicts, if any.
+func (a *patchApplier) conflictsError() error {
+	var paths []string
+	for _, f := range a.changes() {
+		if f.conflict {
+			paths = append(paths, f.path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrPatchConflicts, strings.Join(paths, ", "))
+}
+
+func (a *patchApplier) applyPatch(p *fdiff.ParsedPatch) error {
+	for _, fp := range p.Files {
+		if err := a.applyFilePatch(fp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *patchApplier) applyFilePatch(fp *fdiff.ParsedFilePatch) error {
+	var src *appliedFile
+	var content []byte
+	mode := filemode.Regular
+	if fp.From != nil {
+		var err error
+		src, err = a.file(fp.From.Path())
+		if err != nil {
+			return err
+		}
+
+		if src.deleted {
+			return fmt.Errorf("%w: %s: does not exist", ErrPatchDoesNotApply, fp.From.Path())
+		}
+
+		content, mode = src.content, src.mode
+	}
+
+	if fp.To != nil && (fp.From == nil || fp.From.Path() != fp.To.Path()) {
+		dst, err := a.file(fp.To.Path())
+		if err != nil {
+			return err
+		}
+
+		if !dst.deleted {
+			return fmt.Errorf("%w: %s: already exists", ErrPatchDoesNotApply, fp.To.Path())
+		}
+	}
+
+	conflict := false
+	result, err := applyFileContent(fp, content, a.fuzz)
+	if err != nil {
+		result, conflict, err = a.merge(fp, content, err)
+		if err != nil {
+			return err
+		}
+	}
+
+	if fp.From != nil && (fp.To == nil || (fp.From.Path() != fp.To.Path() && !fp.Copy)) {
+		if fp.To == nil && len(result) != 0 {
+			return fmt.Errorf("%w: %s: removed file is not empty", ErrPatchDoesNotApply, fp.From.Path())
+		}
+
+		a.files[src.path] = &appliedFile{path: src.path, deleted: true, changed: true}
+	}
+
+	if fp.To == nil {
+		return nil
+	}
+
+	if m := fp.To.Mode(); m != filemode.Empty {
+		mode = m
+	}
+
+	a.files[fp.To.Path()] = &appliedFile{
+		path:     fp.To.Path(),
+		content:  result,
+		mode:     mode,
+		changed:  true,
+		conflict: conflict,
+	}
+
+	return nil
+}
+
+// merge applies a text file patch which doesn't apply to content with a
+// three-way merge, if enabled and the preimage blob is known, returning
+// applyErr otherwise.
+func (a *patchApplier) merge(fp *fdiff.ParsedFilePatch, content []byte, applyErr error) ([]byte, bool, error) {
+	if a.blob == nil || fp.Binary || fp.From == nil || fp.To == nil {
+		return nil, false, applyErr
+	}
+
+	base, err := a.blob(fp.From.AbbreviatedHash())
+	if err != nil {
+		return nil, false, applyErr
+	}
+
+	theirs, err := applyFileContent(fp, base, 0)
+	if err != nil {
+		return nil, false, applyErr
+	}
+
+	result, conflict := merge3(string(base), string(content), string(theirs))
+	return []byte(result), conflict, nil
+}
+
+// applyFileContent returns the content of the file patched by fp.
+func applyFileContent(fp *fdiff.ParsedFilePatch, content []byte, fuzz int) ([]byte, error) {
+	path := fp.To
+	if path == nil {
+		path = fp.From
+	}
+
+	if !fp.Binary {
+		result, err := applyHunks(string(content), fp.Hunks, fuzz)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %s", ErrPatchDoesNotApply, path.Path(), err)
+		}
+
+		return []byte(result), nil
+	}
+
+	if fp.BinaryPatch == nil || fp.BinaryPatch.Forward == nil {
+		return nil, fmt.Errorf("%w: %s: binary patch without data", ErrPatchDoesNotApply, path.Path())
+	}
+
+	if fp.From != nil && !matchesHash(content, fp.From.AbbreviatedHash()) {
+		return nil, fmt.Errorf("%w: %s: binary file does not match the patch", ErrPatchDoesNotApply, path.Path())
+	}
+
+	result, err := fp.BinaryPatch.Forward.Apply(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %s", ErrPatchDoesNotApply, path.Path(), err)
+	}
+
+	if fp.To != nil && !matchesHash(result, fp.To.AbbreviatedHash()) {
+		return nil, fmt.Errorf("%w: %s: binary patch result does not match", ErrPatchDoesNotApply, path.Path())
+	}
+
+	return result, nil
+}
+
+// matchesHash returns true if the blob hash of content starts with the given
+// abbreviated hash. An empty or zero hash matches an empty content.
+func matchesHash(content []byte, abbrev string) bool {
+	if abbrev == "" {
+		return true
+	}
+
+	if strings.Trim(abbrev, "0") == "" {
+		return len(content) == 0
+	}
+
+	h := plumbing.ComputeHash(plumbing.BlobObject, content)
+	return strings.HasPrefix(h.String(), abbrev)
+}
+
+// applyHunks applies the hunks to content. Every hunk is searched from its
+// position, moving away from it line by line. When it isn't found, its
+// leading and trailing context lines are ignored one at a time, up to fuzz
+// lines on every side, as git apply does.
+func applyHunks(content string, hunks []*fdiff.Hunk, fuzz int) (string, error) {
+	lines := splitLines(content)
+
+	var sb strings.Builder
+	pos, offset := 0, 0
+	for _, h := range hunks {
+		var pre, post []string
+		leading, trailing := 0, 0
+		changed := false
+		for _, l := range h.Lines {
+			if l.Op != fdiff.Add {
+				pre = append(pre, l.Text)
+			}
+
+			if l.Op != fdiff.Delete {
+				post = append(post, l.Text)
+			}
+
+			if l.Op == fdiff.Equal {
+				trailing++
+				if !changed {
+					leading++
+				}
+			} else {
+				trailing = 0
+				changed = true
+			}
+		}
+
+		start := h.FromLine - 1
+		if h.FromCount == 0 {
+			start = h.FromLine
+		}
+
+		// A hunk starting at the beginning of the file or without trailing
+		// context must be applied at the beginning or the end of the file.
+		matchBeginning := h.FromLine <= 1
+		matchEnd := trailing == 0
+
+		minLeading, minTrailing := leading-fuzz, trailing-fuzz
+		at := -1
+		for {
+			at = findHunk(lines, pre, pos, start+offset, matchBeginning, matchEnd)
+			if at >= 0 || (leading <= minLeading && trailing <= minTrailing) {
+				break
+			}
+
+			if matchBeginning || matchEnd {
+				matchBeginning, matchEnd = false, false
+				continue
+			}
+
+			if leading > minLeading && (leading >= trailing || trailing <= minTrailing) {
+				pre, post = pre[1:], post[1:]
+				leading--
+				start++
+			} else {
+				pre, post = pre[:len(pre)-1], post[:len(post)-1]
+				trailing--
+			}
+		}
+
+		if at < 0 {
+			return "", fmt.Errorf("hunk at line %d does not match", h.FromLine)
+		}
+
+		for _, l := range lines[pos:at] {
+			sb.WriteString(l)
+		}
+
+		for _, l := range post {
+			sb.WriteString(l)
+		}
+
+		pos = at + len(pre)
+		offset = at - start
+	}
+
+	for _, l := range lines[pos:] {
+		sb.WriteString(l)
+	}
+
+	return sb.String(), nil
+}
+
+// findHunk returns the line of lines, not before min, where the preimage of
+// a hunk is found nearest to expected, or -1 if it isn't found.
+func findHunk(lines, pre []string, min, expected int, matchBeginning, matchEnd bool) int {
+	max := len(lines) - len(pre)
+	matches := func(at int) bool {
+		if at < min || at > max ||
+			(matchBeginning && at != 0) || (matchEnd && at != max) {
+			return false
+		}
+
+		for i, l := range pre {
+			if lines[at+i] != l {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	for d := 0; expected-d >= min || expected+d <= max; d++ {
+		if matches(expected - d) {
+			return expected - d
+		}
+
+		if d > 0 && matches(expected+d) {
+			return expected + d
+		}
+	}
+
+	return -1
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// mergeEdit is the replacement of the lines [start, end) of a base text.
+type mergeEdit struct {
+	start, end int
+	lines      []string
+}
+
+// lineEdits returns the edits turning base into other.
+func lineEdits(base, other string) []mergeEdit {
+	var edits []mergeEdit
+	pos, open := 0, false
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			pos += len(lines)
+			open = false
+			continue
+		}
+
+		if !open {
+			edits = append(edits, mergeEdit{start: pos, end: pos})
+			open = true
+		}
+
+		e := &edits[len(edits)-1]
+		if d.Type == diffmatchpatch.DiffDelete {
+			pos += len(lines)
+			e.end = pos
+		} else {
+			e.lines = append(e.lines, lines...)
+		}
+	}
+
+	return edits
+}
+
+// merge3 merges the changes made to base by ours and theirs, line by line.
+// The changes of both sides touching the same lines, if different, are
+// written between conflict markers, and conflict is true.
+func merge3(base, ours, theirs string) (result string, conflict bool) {
+	lines := splitLines(base)
+	a, b := lineEdits(base, ours), lineEdits(base, theirs)
+
+	var sb strings.Builder
+	pos := 0
+	for len(a) > 0 || len(b) > 0 {
+		start := 0
+		switch {
+		case len(a) == 0:
+			start = b[0].start
+		case len(b) == 0 || a[0].start <= b[0].start:
+			start = a[0].start
+		default:
+			start = b[0].start
+		}
+
+		// Edits touching the lines of the current group are merged in it.
+		end, i, j := start, 0, 0
+		for {
+			if i < len(a) && a[i].start <= end {
+				if a[i].end > end {
+					end = a[i].end
+				}
+
+				i++
+				continue
+			}
+
+			if j < len(b) && b[j].start <= end {
+				if b[j].end > end {
+					end = b[j].end
+				}
+
+				j++
+				continue
+			}
+
+			break
+		}
+
+		writeLines(&sb, lines[pos:start])
+		switch {
+		case j == 0:
+			writeLines(&sb, applyEdits(lines, start, end, a[:i]))
+		case i == 0:
+			writeLines(&sb, applyEdits(lines, start, end, b[:j]))
+		default:
+			o := applyEdits(lines, start, end, a[:i])
+			t := applyEdits(lines, start, end, b[:j])
+			if strings.Join(o, "") == strings.Join(t, "") {
+				writeLines(&sb, o)
+				break
+			}
+
+			conflict = true
+			sb.WriteString("<<<<<<< ours\n")
+			writeConflictSide(&sb, o)
+			sb.WriteString("=======\n")
+			writeConflictSide(&sb, t)
+			sb.WriteString(">>>>>>> theirs\n")
+		}
+
+		pos = end
+		a, b = a[i:], b[j:]
+	}
+
+	writeLines(&sb, lines[pos:])
+	return sb.String(), conflict
+}
+
+// applyEdits returns the lines [start, end) of base changed by edits.
+func applyEdits(base []string, start, end int, edits []mergeEdit) []string {
+	var result []string
+	pos := start
+	for _, e := range edits {
+		result = append(result, base[pos:e.start]...)
+		result = append(result, e.lines...)
+		pos = e.end
+	}
+
+	return append(result, base[pos:end]...)
+}
+
+func writeLines(sb *strings.Builder, lines []string) {
+	for _, l := range lines {
+		sb.WriteString(l)
+	}
+}
+
+func writeConflictSide(sb *strings.Builder, lines []string) {
+	writeLines(sb, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		sb.WriteByte('\n')
+	}
+}
@@ -0,0 +1,315 @@
+package git
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type ApplySuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ApplySuite{})
+
+func (s *ApplySuite) TestApplyHunks(c *C) {
+	hunk := &diff.Hunk{
+		FromLine: 2, FromCount: 3, ToLine: 2, ToCount: 3,
+		Lines: []*diff.HunkLine{
+			{Op: diff.Equal, Text: "b\n"},
+			{Op: diff.Delete, Text: "c\n"},
+			{Op: diff.Add, Text: "C\n"},
+			{Op: diff.Equal, Text: "d\n"},
+		},
+	}
+
+	for _, t := range []struct {
+		content, expected string
+	}{
+		{"a\nb\nc\nd\ne\n", "a\nb\nC\nd\ne\n"},
+		{"x\ny\na\nb\nc\nd\ne\n", "x\ny\na\nb\nC\nd\ne\n"},
+		{"b\nc\nd\ne\n", "b\nC\nd\ne\n"},
+		{"a\nb\nx\nd\ne\n", ""},
+	} {
+		result, err := applyHunks(t.content, []*diff.Hunk{hunk}, 0)
+		if t.expected == "" {
+			c.Assert(err, NotNil)
+			continue
+		}
+
+		c.Assert(err, IsNil)
+		c.Assert(result, Equals, t.expected)
+	}
+
+	// Without trailing context, the hunk must match the end of the file.
+	end := &diff.Hunk{
+		FromLine: 2, FromCount: 1, ToLine: 2, ToCount: 1,
+		Lines: []*diff.HunkLine{
+			{Op: diff.Delete, Text: "b\n"},
+			{Op: diff.Add, Text: "B"},
+		},
+	}
+
+	result, err := applyHunks("a\nb\n", []*diff.Hunk{end}, 0)
+	c.Assert(err, IsNil)
+	c.Assert(result, Equals, "a\nB")
+
+	_, err = applyHunks("a\nb\nc\n", []*diff.Hunk{end}, 0)
+	c.Assert(err, NotNil)
+}
+
+func (s *ApplySuite) TestApplyHunksFuzz(c *C) {
+	hunk := &diff.Hunk{
+		FromLine: 2, FromCount: 5, ToLine: 2, ToCount: 5,
+		Lines: []*diff.HunkLine{
+			{Op: diff.Equal, Text: "b\n"},
+			{Op: diff.Equal, Text: "c\n"},
+			{Op: diff.Delete, Text: "d\n"},
+			{Op: diff.Add, Text: "D\n"},
+			{Op: diff.Equal, Text: "e\n"},
+			{Op: diff.Equal, Text: "f\n"},
+		},
+	}
+
+	for _, t := range []struct {
+		content  string
+		fuzz     int
+		expected string
+	}{
+		{"a\nx\nc\nd\ne\ny\ng\n", 0, ""},
+		{"a\nx\nc\nd\ne\ny\ng\n", 1, "a\nx\nc\nD\ne\ny\ng\n"},
+		{"a\nx\nx\nd\ne\nf\ng\n", 1, ""},
+		{"a\nx\nx\nd\ne\nf\ng\n", 2, "a\nx\nx\nD\ne\nf\ng\n"},
+		{"a\nb\nc\nd\nx\nx\ng\n", 2, "a\nb\nc\nD\nx\nx\ng\n"},
+		{"a\nx\nx\nx\nx\nf\ng\n", 2, ""},
+	} {
+		result, err := applyHunks(t.content, []*diff.Hunk{hunk}, t.fuzz)
+		if t.expected == "" {
+			c.Assert(err, NotNil, Commentf("%q with fuzz %d", t.content, t.fuzz))
+			continue
+		}
+
+		c.Assert(err, IsNil, Commentf("%q with fuzz %d", t.content, t.fuzz))
+		c.Assert(result, Equals, t.expected)
+	}
+}
+
+func (s *ApplySuite) TestMerge3(c *C) {
+	base := "a\nb\nc\nd\ne\n"
+	for _, t := range []struct {
+		ours, theirs, expected string
+		conflict               bool
+	}{
+		{"A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", false},
+		{"a\nb\nC\nd\ne\n", "a\nb\nC\nd\ne\n", "a\nb\nC\nd\ne\n", false},
+		{"a\nb\nd\ne\n", "a\nb\nc\nd\ne\nf\n", "a\nb\nd\ne\nf\n", false},
+		{
+			"a\nb\nX\nd\ne\n", "a\nb\nY\nd\ne\n",
+			"a\nb\n<<<<<<< ours\nX\n=======\nY\n>>>>>>> theirs\nd\ne\n", true,
+		},
+	} {
+		result, conflict := merge3(base, t.ours, t.theirs)
+		c.Assert(result, Equals, t.expected)
+		c.Assert(conflict, Equals, t.conflict)
+	}
+}
+
+func (s *ApplySuite) TestApplyToTree(c *C) {
+	r := s.NewRepository(fixtures.Basic().One())
+
+	// A text and a binary file.
+	for _, h := range []string{
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"35e85108805c84807bc66a02d91535e1e24b38b9",
+	} {
+		commit, err := r.CommitObject(plumbing.NewHash(h))
+		c.Assert(err, IsNil)
+
+		parent, err := commit.Parent(0)
+		c.Assert(err, IsNil)
+
+		patch, err := parent.Patch(commit)
+		c.Assert(err, IsNil)
+
+		from, err := parent.Tree()
+		c.Assert(err, IsNil)
+
+		tree, err := r.ApplyToTree(from, patch, nil)
+		c.Assert(err, IsNil)
+		c.Assert(tree.Hash, Equals, commit.TreeHash)
+
+		to, err := commit.Tree()
+		c.Assert(err, IsNil)
+
+		tree, err = r.ApplyToTree(to, patch, &ApplyOptions{Reverse: true})
+		c.Assert(err, IsNil)
+		c.Assert(tree.Hash, Equals, parent.TreeHash)
+	}
+}
+
+func (s *ApplySuite) TestApplyToTreeCheck(c *C) {
+	r := s.NewRepository(fixtures.Basic().One())
+
+	commit, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+
+	parent, err := commit.Parent(0)
+	c.Assert(err, IsNil)
+
+	patch, err := parent.Patch(commit)
+	c.Assert(err, IsNil)
+
+	tree, err := commit.Tree()
+	c.Assert(err, IsNil)
+
+	_, err = r.ApplyToTree(tree, patch, &ApplyOptions{Check: true})
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+
+	from, err := parent.Tree()
+	c.Assert(err, IsNil)
+
+	checked, err := r.ApplyToTree(from, patch, &ApplyOptions{Check: true})
+	c.Assert(err, IsNil)
+	c.Assert(checked, Equals, from)
+}
+
+func (s *ApplySuite) TestApplyToTreeThreeWay(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	base := "a\nb\nc\nd\ne\nf\ng\n"
+	tree, err := r.ApplyToTree(nil, decodePatch(c, `diff --git a/file b/file
+new file mode 100644
+--- /dev/null
++++ b/file
+@@ -0,0 +1,7 @@
++a
++b
++c
++d
++e
++f
++g
+`), nil)
+	c.Assert(err, IsNil)
+
+	patch := decodePatch(c, `diff --git a/file b/file
+index `+plumbing.ComputeHash(plumbing.BlobObject, []byte(base)).String()+`..0000000 100644
+--- a/file
++++ b/file
+@@ -1,7 +1,7 @@
+ a
+ b
+ c
+-d
++D
+ e
+ f
+ g
+`)
+
+	for _, t := range []struct {
+		change, expected string
+		conflict         bool
+	}{
+		{"-a\n+A\n", "A\nb\nc\nD\ne\nf\ng\n", false},
+		{"-d\n+X\n", "", true},
+	} {
+		line := strings.SplitN(t.change, "\n", 2)[0][1:]
+		hunk := strings.Replace(" a\n b\n c\n d\n e\n f\n g\n", " "+line+"\n", t.change, 1)
+		ours, err := r.ApplyToTree(tree, decodePatch(c, "--- a/file\n+++ b/file\n@@ -1,7 +1,7 @@\n"+hunk), nil)
+		c.Assert(err, IsNil)
+
+		_, err = r.ApplyToTree(ours, patch, nil)
+		c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+
+		merged, err := r.ApplyToTree(ours, patch, &ApplyOptions{ThreeWay: true})
+		if t.conflict {
+			c.Assert(errors.Is(err, ErrPatchConflicts), Equals, true)
+			c.Assert(err, ErrorMatches, ".*: file")
+			continue
+		}
+
+		c.Assert(err, IsNil)
+
+		f, err := merged.File("file")
+		c.Assert(err, IsNil)
+
+		content, err := f.Contents()
+		c.Assert(err, IsNil)
+		c.Assert(content, Equals, t.expected)
+	}
+}
+
+func (s *ApplySuite) TestApplyToTreeFuzz(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	// the context lines b and f of the hunk are x and y in the file, which
+	// has two more lines at the top
+	tree, err := r.ApplyToTree(nil, decodePatch(c, `diff --git a/file b/file
+new file mode 100644
+--- /dev/null
++++ b/file
+@@ -0,0 +1,9 @@
++0
++1
++a
++x
++c
++d
++e
++y
++g
+`), nil)
+	c.Assert(err, IsNil)
+
+	patch := decodePatch(c, `--- a/file
++++ b/file
+@@ -2,5 +2,5 @@
+ b
+ c
+-d
++D
+ e
+ f
+`)
+
+	_, err = r.ApplyToTree(tree, patch, nil)
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+
+	applied, err := r.ApplyToTree(tree, patch, &ApplyOptions{Fuzz: 1})
+	c.Assert(err, IsNil)
+
+	f, err := applied.File("file")
+	c.Assert(err, IsNil)
+
+	content, err := f.Contents()
+	c.Assert(err, IsNil)
+	c.Assert(content, Equals, "0\n1\na\nx\nc\nD\ne\ny\ng\n")
+}
+
+func (s *ApplySuite) TestApplyOptionsValidate(c *C) {
+	c.Assert((&ApplyOptions{Fuzz: -1}).Validate(), Equals, ErrInvalidApplyFuzz)
+	c.Assert((&ApplyOptions{Index: true, Cached: true}).Validate(), Equals, ErrApplyIndexAndCached)
+
+	o := &ApplyOptions{ThreeWay: true}
+	c.Assert(o.Validate(), IsNil)
+	c.Assert(o.Index, Equals, true)
+
+	o = &ApplyOptions{ThreeWay: true, Cached: true}
+	c.Assert(o.Validate(), IsNil)
+	c.Assert(o.Index, Equals, false)
+}
+
+func decodePatch(c *C, patch string) *diff.ParsedPatch {
+	p := &diff.ParsedPatch{}
+	c.Assert(diff.NewDecoder(strings.NewReader(patch)).Decode(p), IsNil)
+	return p
+}
@@ -0,0 +1,79 @@
+package git
+
+import (
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/archive"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Archive writes the tree of treeish to w as an archive, as git archive
+// does. The treeish is a revision resolving to a commit, a tree hash, or a
+// revision followed by a colon and the path of a directory. When the tree
+// comes from a commit, the files get the time of its committer and the hash
+// of the commit is stored in the archive. The files and directories with the
+// export-ignore gitattribute are left out, and the placeholders of the files
+// with the export-subst one are expanded.
+func (r *Repository) Archive(w io.Writer, treeish plumbing.Revision, o *ArchiveOptions) error {
+	if o == nil {
+		o = &ArchiveOptions{}
+	}
+
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	tree, commit, err := r.resolveTreeish(treeish)
+	if err != nil {
+		return err
+	}
+
+	return archive.NewEncoder(w, o.archiveOptions()).Encode(tree, commit)
+}
+
+// resolveTreeish returns the tree of a treeish, and the commit it comes from
+// if any.
+func (r *Repository) resolveTreeish(treeish plumbing.Revision) (*object.Tree, *object.Commit, error) {
+	rev, dir := string(treeish), ""
+	i := strings.IndexByte(rev, ':')
+	if i >= 0 {
+		rev, dir = rev[:i], rev[i+1:]
+	}
+
+	var tree *object.Tree
+	var commit *object.Commit
+	h, err := r.ResolveRevision(plumbing.Revision(rev))
+	if err == nil {
+		if commit, err = r.CommitObject(*h); err != nil {
+			return nil, nil, err
+		}
+
+		if tree, err = commit.Tree(); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		for _, h := range r.resolveHashPrefix(rev) {
+			if tree, err = r.TreeObject(h); err == nil {
+				break
+			}
+		}
+
+		if tree == nil {
+			return nil, nil, plumbing.ErrReferenceNotFound
+		}
+	}
+
+	// As git does, the tree of "<rev>:<path>" doesn't come from a commit.
+	if i < 0 {
+		return tree, commit, nil
+	}
+
+	if dir = strings.Trim(dir, "/"); dir == "" {
+		return tree, nil, nil
+	}
+
+	tree, err = tree.Tree(dir)
+	return tree, nil, err
+}
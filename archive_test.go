@@ -0,0 +1,111 @@
+package git
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	. "gopkg.in/check.v1"
+)
+
+type ArchiveSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ArchiveSuite{})
+
+func (s *ArchiveSuite) newRepository(c *C) (*Repository, plumbing.Hash) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	for name, content := range map[string]string{
+		".gitattributes":      "secret export-ignore\nVERSION export-subst\n",
+		"VERSION":             "$Format:%H %an$\n",
+		"README":              "readme\n",
+		"secret":              "hidden\n",
+		"docs/.gitattributes": "*.tmp export-ignore\n",
+		"docs/guide":          "guide\n",
+		"docs/draft.tmp":      "draft\n",
+	} {
+		c.Assert(util.WriteFile(fs, name, []byte(content), 0644), IsNil)
+	}
+
+	c.Assert(w.AddWithOptions(&AddOptions{All: true}), IsNil)
+	h, err := w.Commit("Initial\n", &CommitOptions{Author: &object.Signature{
+		Name:  "Foo",
+		Email: "foo@foo.foo",
+		When:  time.Unix(1600000000, 0),
+	}})
+	c.Assert(err, IsNil)
+
+	return r, h
+}
+
+func readTarFiles(c *C, r io.Reader) map[string]string {
+	files := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+
+		c.Assert(err, IsNil)
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		c.Assert(err, IsNil)
+		files[h.Name] = string(content)
+	}
+}
+
+func (s *ArchiveSuite) TestArchive(c *C) {
+	r, h := s.newRepository(c)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(r.Archive(buf, "HEAD", nil), IsNil)
+	c.Assert(readTarFiles(c, buf), DeepEquals, map[string]string{
+		".gitattributes":      "secret export-ignore\nVERSION export-subst\n",
+		"README":              "readme\n",
+		"VERSION":             h.String() + " Foo\n",
+		"docs/":               "",
+		"docs/.gitattributes": "*.tmp export-ignore\n",
+		"docs/guide":          "guide\n",
+	})
+}
+
+func (s *ArchiveSuite) TestArchiveTree(c *C) {
+	r, h := s.newRepository(c)
+
+	commit, err := r.CommitObject(h)
+	c.Assert(err, IsNil)
+
+	// The placeholders aren't expanded without a commit.
+	buf := bytes.NewBuffer(nil)
+	c.Assert(r.Archive(buf, plumbing.Revision(commit.TreeHash.String()[:10]), nil), IsNil)
+	c.Assert(readTarFiles(c, buf)["VERSION"], Equals, "$Format:%H %an$\n")
+
+	buf.Reset()
+	c.Assert(r.Archive(buf, "master:docs", &ArchiveOptions{Prefix: "docs-"}), IsNil)
+	c.Assert(readTarFiles(c, buf), DeepEquals, map[string]string{
+		"docs-.gitattributes": "*.tmp export-ignore\n",
+		"docs-guide":          "guide\n",
+	})
+
+	c.Assert(r.Archive(buf, "missing", nil), NotNil)
+	c.Assert(r.Archive(buf, "HEAD", &ArchiveOptions{Format: "rar"}), NotNil)
+}
@@ -0,0 +1,647 @@
+package git
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	blameSection           = "blame"
+	blameIgnoreRevsFileKey = "ignoreRevsFile"
+)
+
+// BlameEntry is a group of consecutive lines of the blamed file attributed to
+// the same commit, as reported by BlameOptions.Incremental.
+type BlameEntry struct {
+	// Commit is the commit the lines are attributed to.
+	Commit *object.Commit
+	// Path is the path of the file in Commit, it may differ from the blamed
+	// one after a rename, a move or a copy.
+	Path string
+	// SourceLine is the number of the first line in the file of Commit.
+	SourceLine int
+	// Line is the number of the first line in the blamed file.
+	Line int
+	// Count is the number of lines.
+	Count int
+}
+
+// BlameWithOptions returns a BlameResult with the information about the last
+// author of each line from file `path` at commit `c`.
+//
+// Unlike Blame, the whole history of the file is walked, merges included, and
+// the lines are attributed the way git blame does: each commit passes the
+// lines it didn't change to its parents. Only the lines in the ranges given
+// in the options are returned.
+func BlameWithOptions(c *object.Commit, path string, o *BlameOptions) (*BlameResult, error) {
+	if o == nil {
+		o = &BlameOptions{}
+	}
+
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+
+	b := &blameWalk{
+		o:       o,
+		ignored: make(map[plumbing.Hash]struct{}, len(o.IgnoreRevs)),
+		origins: make(map[blameOriginKey]*blameOrigin),
+	}
+
+	for _, h := range o.IgnoreRevs {
+		b.ignored[h] = struct{}{}
+	}
+
+	final, err := b.origin(c, path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := final.load(); err != nil {
+		return nil, err
+	}
+
+	ranges, err := blameRanges(o.Lines, len(final.lines))
+	if err != nil {
+		return nil, err
+	}
+
+	b.result = make([]*object.Commit, len(final.lines))
+	for _, r := range ranges {
+		final.chunks = append(final.chunks, blameChunk{final: r[0], source: r[0], count: r[1] - r[0]})
+	}
+
+	b.push(final)
+	for len(b.queue) > 0 {
+		o := b.queue[0]
+		b.queue = b.queue[1:]
+		delete(b.origins, o.key())
+
+		if err := b.process(o); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &BlameResult{Path: path, Rev: c.Hash, Lines: make([]*Line, 0, len(final.lines))}
+	for _, r := range ranges {
+		for i := r[0]; i < r[1]; i++ {
+			commit := o.Mailmap.Commit(b.result[i])
+			result.Lines = append(result.Lines, newLine(
+				commit.Author.Email, commit.Author.Name, strings.TrimSuffix(final.lines[i], "\n"),
+				commit.Author.When, commit.Hash,
+			))
+		}
+	}
+
+	return result, nil
+}
+
+// blameRanges converts the line ranges to sorted and merged 0-based
+// half-open ranges.
+func blameRanges(lines []BlameLineRange, n int) ([][2]int, error) {
+	if len(lines) == 0 {
+		return [][2]int{{0, n}}, nil
+	}
+
+	var ranges [][2]int
+	for _, l := range lines {
+		end := l.End
+		if end == 0 {
+			end = n
+		}
+
+		if l.Start > n || end > n {
+			return nil, fmt.Errorf("%w: %d,%d: file has only %d lines",
+				ErrInvalidBlameLineRange, l.Start, l.End, n)
+		}
+
+		ranges = append(ranges, [2]int{l.Start - 1, end})
+	}
+
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i][0] < ranges[j][0] })
+
+	merged := ranges[:1]
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if r[0] <= last[1] {
+			last[1] = max(last[1], r[1])
+			continue
+		}
+
+		merged = append(merged, r)
+	}
+
+	return merged, nil
+}
+
+// blameChunk is a group of consecutive lines of the blamed file, still to be
+// attributed, with their position in the file of an origin.
+type blameChunk struct {
+	// final is the first line in the blamed file, 0-based.
+	final int
+	// source is the first line in the file of the origin, 0-based.
+	source int
+	count  int
+}
+
+type blameOriginKey struct {
+	commit plumbing.Hash
+	path   string
+}
+
+// blameOrigin is a file of a commit suspected of having introduced the lines
+// of its chunks.
+type blameOrigin struct {
+	commit *object.Commit
+	path   string
+	hash   plumbing.Hash
+	lines  []string
+	loaded bool
+	chunks []blameChunk
+}
+
+func (o *blameOrigin) key() blameOriginKey {
+	return blameOriginKey{o.commit.Hash, o.path}
+}
+
+func (o *blameOrigin) load() error {
+	if o.loaded {
+		return nil
+	}
+
+	file, err := o.commit.File(o.path)
+	if err != nil {
+		return err
+	}
+
+	content, err := file.Contents()
+	if err != nil {
+		return err
+	}
+
+	o.lines = splitBlameLines(content)
+	o.loaded = true
+	return nil
+}
+
+func (o *blameOrigin) content() string {
+	return strings.Join(o.lines, "")
+}
+
+func splitBlameLines(content string) []string {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// blameWalk holds the state of BlameWithOptions.
+type blameWalk struct {
+	o       *BlameOptions
+	ignored map[plumbing.Hash]struct{}
+	// queue are the origins with chunks to attribute, sorted from the most
+	// recent commit to the oldest one.
+	queue   []*blameOrigin
+	origins map[blameOriginKey]*blameOrigin
+	// result is the commit of every line of the blamed file.
+	result []*object.Commit
+}
+
+// origin returns the origin of path in commit c, or nil if there isn't such
+// a file.
+func (b *blameWalk) origin(c *object.Commit, path string) (*blameOrigin, error) {
+	if o, ok := b.origins[blameOriginKey{c.Hash, path}]; ok {
+		return o, nil
+	}
+
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := tree.FindEntry(path)
+	if err != nil {
+		if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	if !e.Mode.IsFile() {
+		return nil, nil
+	}
+
+	return &blameOrigin{commit: c, path: path, hash: e.Hash}, nil
+}
+
+// push adds chunks to the origin o and queues it if needed.
+func (b *blameWalk) push(o *blameOrigin, chunks ...blameChunk) {
+	o.chunks = append(o.chunks, chunks...)
+	if _, ok := b.origins[o.key()]; ok {
+		return
+	}
+
+	b.origins[o.key()] = o
+	when := o.commit.Committer.When
+	i := sort.Search(len(b.queue), func(i int) bool {
+		return b.queue[i].commit.Committer.When.Before(when)
+	})
+
+	b.queue = append(b.queue, nil)
+	copy(b.queue[i+1:], b.queue[i:])
+	b.queue[i] = o
+}
+
+// process passes the chunks of o to the parents of its commit, and attributes
+// the remaining ones to the commit.
+func (b *blameWalk) process(o *blameOrigin) error {
+	var parents []*object.Commit
+	err := o.commit.Parents().ForEach(func(p *object.Commit) error {
+		parents = append(parents, p)
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	var porigins []*blameOrigin
+	for _, p := range parents {
+		po, err := b.parentOrigin(o, p)
+		if err != nil {
+			return err
+		}
+
+		if po == nil {
+			continue
+		}
+
+		// The file is unchanged, all the chunks come from the parent.
+		if po.hash == o.hash {
+			b.push(po, o.chunks...)
+			return nil
+		}
+
+		porigins = append(porigins, po)
+	}
+
+	remaining := o.chunks
+	if len(porigins) != 0 {
+		if err := o.load(); err != nil {
+			return err
+		}
+	}
+
+	_, ignored := b.ignored[o.commit.Hash]
+	for i, po := range porigins {
+		if err := po.load(); err != nil {
+			return err
+		}
+
+		unchanged, guessed := blameDiffMapping(po, o)
+		var passed []blameChunk
+		passed, remaining = splitBlameChunks(remaining, unchanged)
+		b.pushPassed(po, passed)
+
+		if ignored && i == 0 {
+			passed, remaining = splitBlameChunks(remaining, guessed)
+			b.pushPassed(po, passed)
+		}
+	}
+
+	if len(remaining) != 0 && len(parents) != 0 && (b.o.DetectMoves || b.o.DetectCopies) {
+		if remaining, err = b.passMovesAndCopies(o, parents, porigins, remaining); err != nil {
+			return err
+		}
+	}
+
+	return b.attribute(o, remaining)
+}
+
+func (b *blameWalk) pushPassed(o *blameOrigin, chunks []blameChunk) {
+	if len(chunks) != 0 {
+		b.push(o, chunks...)
+	}
+}
+
+// parentOrigin returns the origin of the file of o in the parent p, following
+// renames if requested.
+func (b *blameWalk) parentOrigin(o *blameOrigin, p *object.Commit) (*blameOrigin, error) {
+	po, err := b.origin(p, o.path)
+	if err != nil || po != nil || !b.o.FollowRenames {
+		return po, err
+	}
+
+	from, err := p.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	to, err := o.commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), from, to, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ch := range changes {
+		if ch.To.Name == o.path && ch.From.Name != "" && ch.From.Name != o.path {
+			return b.origin(p, ch.From.Name)
+		}
+	}
+
+	return nil, nil
+}
+
+// blameDiffMapping returns, for every line of dst, the line of src it is
+// unchanged from, or -1. The second mapping guesses the origin of the changed
+// lines, pairing them with the lines of src they replaced.
+func blameDiffMapping(src, dst *blameOrigin) (unchanged, guessed []int) {
+	unchanged = make([]int, len(dst.lines))
+	guessed = make([]int, len(dst.lines))
+	for i := range guessed {
+		unchanged[i], guessed[i] = -1, -1
+	}
+
+	sl, dl := 0, 0
+	hunkSrc, hunkDst := -1, -1
+	for _, d := range diff.Do(src.content(), dst.content()) {
+		n := countLines(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for i := 0; i < n; i++ {
+				unchanged[dl+i] = sl + i
+			}
+
+			sl += n
+			dl += n
+			hunkSrc, hunkDst = -1, -1
+			continue
+		case diffmatchpatch.DiffDelete:
+			if hunkSrc < 0 {
+				hunkSrc = sl
+			}
+
+			sl += n
+		case diffmatchpatch.DiffInsert:
+			if hunkDst < 0 {
+				hunkDst = dl
+			}
+
+			dl += n
+		}
+
+		if hunkSrc >= 0 && hunkDst >= 0 {
+			for i := hunkDst; i < dl && hunkSrc+i-hunkDst < sl; i++ {
+				guessed[i] = hunkSrc + i - hunkDst
+			}
+		}
+	}
+
+	return unchanged, guessed
+}
+
+// splitBlameChunks splits chunks according to mapping, the line each line of
+// the origin comes from or -1. It returns the chunks moved to the mapped
+// lines, and the remaining ones.
+func splitBlameChunks(chunks []blameChunk, mapping []int) (passed, remaining []blameChunk) {
+	for _, c := range chunks {
+		for i := 0; i < c.count; {
+			m := mapping[c.source+i]
+			j := i + 1
+			for j < c.count && (m < 0) == (mapping[c.source+j] < 0) &&
+				(m < 0 || mapping[c.source+j] == m+j-i) {
+				j++
+			}
+
+			chunk := blameChunk{final: c.final + i, source: c.source + i, count: j - i}
+			if m < 0 {
+				remaining = append(remaining, chunk)
+			} else {
+				chunk.source = m
+				passed = append(passed, chunk)
+			}
+
+			i = j
+		}
+	}
+
+	return passed, remaining
+}
+
+// passMovesAndCopies looks for the remaining chunks in the file of the
+// parents, and in the other files modified by the commit if copies are
+// detected, and passes the lines found to the matching origins.
+func (b *blameWalk) passMovesAndCopies(
+	o *blameOrigin, parents []*object.Commit, porigins []*blameOrigin, remaining []blameChunk,
+) ([]blameChunk, error) {
+	if err := o.load(); err != nil {
+		return nil, err
+	}
+
+	candidates := porigins
+	if b.o.DetectCopies {
+		to, err := o.commit.Tree()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, p := range parents {
+			from, err := p.Tree()
+			if err != nil {
+				return nil, err
+			}
+
+			changes, err := object.DiffTree(from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, ch := range changes {
+				if ch.From.Name == "" || ch.From.Name == o.path {
+					continue
+				}
+
+				co, err := b.origin(p, ch.From.Name)
+				if err != nil {
+					return nil, err
+				}
+
+				if co != nil {
+					candidates = append(candidates, co)
+				}
+			}
+		}
+	}
+
+	for _, co := range candidates {
+		if len(remaining) == 0 {
+			break
+		}
+
+		if err := co.load(); err != nil {
+			return nil, err
+		}
+
+		var passed []blameChunk
+		passed, remaining = splitBlameChunks(remaining, b.moveMapping(o, co, remaining))
+		b.pushPassed(co, passed)
+	}
+
+	return remaining, nil
+}
+
+// moveMapping maps the lines of the chunks of o to the longest runs of
+// identical lines found in the file of the candidate origin co.
+func (b *blameWalk) moveMapping(o, co *blameOrigin, chunks []blameChunk) []int {
+	positions := make(map[string][]int)
+	for i, l := range co.lines {
+		positions[l] = append(positions[l], i)
+	}
+
+	mapping := make([]int, len(o.lines))
+	for i := range mapping {
+		mapping[i] = -1
+	}
+
+	for _, c := range chunks {
+		for i := c.source; i < c.source+c.count; {
+			best, bestLen := -1, 0
+			for _, j := range positions[o.lines[i]] {
+				n := 0
+				for i+n < c.source+c.count && j+n < len(co.lines) && o.lines[i+n] == co.lines[j+n] {
+					n++
+				}
+
+				if n > bestLen {
+					best, bestLen = j, n
+				}
+			}
+
+			if best < 0 || alnumCount(o.lines[i:i+bestLen]) < b.o.MoveCopyScore {
+				i++
+				continue
+			}
+
+			for n := 0; n < bestLen; n++ {
+				mapping[i+n] = best + n
+			}
+
+			i += bestLen
+		}
+	}
+
+	return mapping
+}
+
+func alnumCount(lines []string) int {
+	n := 0
+	for _, l := range lines {
+		for _, r := range l {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				n++
+			}
+		}
+	}
+
+	return n
+}
+
+// attribute blames the commit of o for the chunks.
+func (b *blameWalk) attribute(o *blameOrigin, chunks []blameChunk) error {
+	sort.Slice(chunks, func(i, j int) bool { return chunks[i].final < chunks[j].final })
+	for _, c := range chunks {
+		for i := c.final; i < c.final+c.count; i++ {
+			b.result[i] = o.commit
+		}
+
+		if b.o.Incremental == nil {
+			continue
+		}
+
+		err := b.o.Incremental(&BlameEntry{
+			Commit:     b.o.Mailmap.Commit(o.commit),
+			Path:       o.path,
+			SourceLine: c.source + 1,
+			Line:       c.final + 1,
+			Count:      c.count,
+		})
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BlameIgnoreRevs returns the commits listed in the file set in
+// blame.ignoreRevsFile, relative to the worktree, to be used as
+// BlameOptions.IgnoreRevs. Nothing is returned if the option isn't set.
+func (r *Repository) BlameIgnoreRevs() ([]plumbing.Hash, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	path := cfg.Raw.Section(blameSection).Options.Get(blameIgnoreRevsFileKey)
+	if path == "" {
+		return nil, nil
+	}
+
+	if r.wt == nil {
+		return nil, ErrIsBareRepository
+	}
+
+	f, err := r.wt.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return ParseIgnoreRevs(f)
+}
+
+// ParseIgnoreRevs parses a list of commits to ignore by blame, in the format
+// of blame.ignoreRevsFile: one full commit hash per line, "#" starting a
+// comment.
+func ParseIgnoreRevs(r io.Reader) ([]plumbing.Hash, error) {
+	var hashes []plumbing.Hash
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		h := plumbing.NewHash(line)
+		if len(line) != len(h)*2 || h.String() != strings.ToLower(line) {
+			return nil, fmt.Errorf("invalid object name: %q", line)
+		}
+
+		hashes = append(hashes, h)
+	}
+
+	return hashes, s.Err()
+}
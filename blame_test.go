@@ -1,9 +1,17 @@
 package git
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
 
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
 )
@@ -65,6 +73,229 @@ func (s *BlameSuite) TestBlame(c *C) {
 	}
 }
 
+func (s *BlameSuite) TestBlameWithOptions(c *C) {
+	for _, t := range blameTests {
+		r := s.NewRepositoryFromPackfile(fixtures.ByURL(t.repo).One())
+
+		exp := s.mockBlame(c, t, r)
+		commit, err := r.CommitObject(plumbing.NewHash(t.rev))
+		c.Assert(err, IsNil)
+
+		obt, err := BlameWithOptions(commit, t.path, nil)
+		c.Assert(err, IsNil)
+		c.Assert(obt, DeepEquals, exp)
+	}
+}
+
+// blameHistory builds a history where a.txt is renamed to c.txt, then
+// reformatted, and finally some of its lines are moved and the lines of b.txt
+// copied into it. It returns the commits, from the oldest to the newest.
+func (s *BlameSuite) blameHistory(c *C) (*Repository, []*object.Commit) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	a := []string{
+		"the first line of the original file",
+		"the second line of the original file",
+		"the third line of the original file",
+		"the fourth line of the original file",
+		"the fifth line of the original file",
+	}
+	b := []string{
+		"the first line of the copied file",
+		"the second line of the copied file",
+		"the third line of the copied file",
+	}
+
+	var commits []*object.Commit
+	commit := func(files map[string][]string) {
+		for name, lines := range files {
+			if lines == nil {
+				_, err := w.Remove(name)
+				c.Assert(err, IsNil)
+				continue
+			}
+
+			content := strings.Join(lines, "\n") + "\n"
+			c.Assert(util.WriteFile(fs, name, []byte(content), 0644), IsNil)
+			_, err := w.Add(name)
+			c.Assert(err, IsNil)
+		}
+
+		sig := &object.Signature{
+			Name:  "foo",
+			Email: fmt.Sprintf("foo%d@foo.foo", len(commits)),
+			When:  time.Unix(1600000000+int64(len(commits))*60, 0),
+		}
+
+		h, err := w.Commit("foo", &CommitOptions{Author: sig})
+		c.Assert(err, IsNil)
+		co, err := r.CommitObject(h)
+		c.Assert(err, IsNil)
+		commits = append(commits, co)
+	}
+
+	commit(map[string][]string{"a.txt": a, "b.txt": b})
+
+	a[2] = "the third line of the renamed file"
+	commit(map[string][]string{"a.txt": nil, "c.txt": a})
+
+	a[0] = "THE FIRST LINE OF THE ORIGINAL FILE"
+	commit(map[string][]string{"c.txt": a})
+
+	moved := append(append([]string{a[3], a[4]}, a[:3]...), b...)
+	commit(map[string][]string{"c.txt": moved, "b.txt": b[:1]})
+
+	return r, commits
+}
+
+func (s *BlameSuite) assertBlame(c *C, result *BlameResult, commits []*object.Commit, expected ...int) {
+	c.Assert(result.Lines, HasLen, len(expected))
+	for i, e := range expected {
+		c.Assert(result.Lines[i].Hash, Equals, commits[e].Hash, Commentf("line %d", i+1))
+	}
+}
+
+func (s *BlameSuite) TestBlameWithOptionsRenames(c *C) {
+	_, commits := s.blameHistory(c)
+	head := commits[len(commits)-1]
+
+	result, err := BlameWithOptions(head, "c.txt", nil)
+	c.Assert(err, IsNil)
+	s.assertBlame(c, result, commits, 3, 3, 2, 1, 1, 3, 3, 3)
+	c.Assert(result.Lines[2].Text, Equals, "THE FIRST LINE OF THE ORIGINAL FILE")
+
+	result, err = BlameWithOptions(head, "c.txt", &BlameOptions{FollowRenames: true})
+	c.Assert(err, IsNil)
+	s.assertBlame(c, result, commits, 3, 3, 2, 0, 1, 3, 3, 3)
+}
+
+func (s *BlameSuite) TestBlameWithOptionsMovesAndCopies(c *C) {
+	_, commits := s.blameHistory(c)
+	head := commits[len(commits)-1]
+
+	result, err := BlameWithOptions(head, "c.txt", &BlameOptions{
+		FollowRenames: true,
+		DetectMoves:   true,
+	})
+	c.Assert(err, IsNil)
+	s.assertBlame(c, result, commits, 0, 0, 2, 0, 1, 3, 3, 3)
+
+	result, err = BlameWithOptions(head, "c.txt", &BlameOptions{
+		FollowRenames: true,
+		DetectCopies:  true,
+	})
+	c.Assert(err, IsNil)
+	s.assertBlame(c, result, commits, 0, 0, 2, 0, 1, 0, 0, 0)
+
+	// The copied lines are too short to be detected.
+	result, err = BlameWithOptions(head, "c.txt", &BlameOptions{
+		FollowRenames: true,
+		DetectCopies:  true,
+		MoveCopyScore: 100,
+	})
+	c.Assert(err, IsNil)
+	s.assertBlame(c, result, commits, 3, 3, 2, 0, 1, 3, 3, 3)
+}
+
+func (s *BlameSuite) TestBlameWithOptionsIgnoreRevs(c *C) {
+	_, commits := s.blameHistory(c)
+	head := commits[len(commits)-1]
+
+	result, err := BlameWithOptions(head, "c.txt", &BlameOptions{
+		FollowRenames: true,
+		IgnoreRevs:    []plumbing.Hash{commits[2].Hash},
+	})
+	c.Assert(err, IsNil)
+	s.assertBlame(c, result, commits, 3, 3, 0, 0, 1, 3, 3, 3)
+}
+
+func (s *BlameSuite) TestBlameIgnoreRevs(c *C) {
+	r, commits := s.blameHistory(c)
+
+	revs, err := r.BlameIgnoreRevs()
+	c.Assert(err, IsNil)
+	c.Assert(revs, HasLen, 0)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("blame").SetOption("ignoreRevsFile", ".git-blame-ignore-revs")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	content := "# reformatting\n" + commits[2].Hash.String() + " # comment\n\n" + commits[1].Hash.String() + "\n"
+	c.Assert(util.WriteFile(r.wt, ".git-blame-ignore-revs", []byte(content), 0644), IsNil)
+
+	revs, err = r.BlameIgnoreRevs()
+	c.Assert(err, IsNil)
+	c.Assert(revs, DeepEquals, []plumbing.Hash{commits[2].Hash, commits[1].Hash})
+
+	_, err = ParseIgnoreRevs(strings.NewReader("e8d3ffa\n"))
+	c.Assert(err, NotNil)
+}
+
+func (s *BlameSuite) TestBlameWithOptionsLines(c *C) {
+	_, commits := s.blameHistory(c)
+	head := commits[len(commits)-1]
+
+	result, err := BlameWithOptions(head, "c.txt", &BlameOptions{
+		FollowRenames: true,
+		Lines:         []BlameLineRange{{Start: 7}, {Start: 2, End: 3}, {Start: 3, End: 4}},
+	})
+	c.Assert(err, IsNil)
+	s.assertBlame(c, result, commits, 3, 2, 0, 3, 3)
+	c.Assert(result.Lines[0].Text, Equals, "the fifth line of the original file")
+
+	_, err = BlameWithOptions(head, "c.txt", &BlameOptions{Lines: []BlameLineRange{{Start: 5, End: 9}}})
+	c.Assert(errors.Is(err, ErrInvalidBlameLineRange), Equals, true)
+
+	_, err = BlameWithOptions(head, "c.txt", &BlameOptions{Lines: []BlameLineRange{{Start: 5, End: 4}}})
+	c.Assert(errors.Is(err, ErrInvalidBlameLineRange), Equals, true)
+}
+
+func (s *BlameSuite) TestBlameWithOptionsIncremental(c *C) {
+	_, commits := s.blameHistory(c)
+	head := commits[len(commits)-1]
+
+	var entries []*BlameEntry
+	result, err := BlameWithOptions(head, "c.txt", &BlameOptions{
+		FollowRenames: true,
+		Incremental: func(e *BlameEntry) error {
+			entries = append(entries, e)
+			return nil
+		},
+	})
+	c.Assert(err, IsNil)
+
+	lines := 0
+	for _, e := range entries {
+		for i := 0; i < e.Count; i++ {
+			c.Assert(result.Lines[e.Line-1+i].Hash, Equals, e.Commit.Hash)
+		}
+
+		lines += e.Count
+	}
+
+	c.Assert(lines, Equals, 8)
+
+	// The most recent commits are reported first.
+	c.Assert(entries[0].Commit.Hash, Equals, head.Hash)
+	last := entries[len(entries)-1]
+	c.Assert(last.Commit.Hash, Equals, commits[0].Hash)
+	c.Assert(last.Path, Equals, "a.txt")
+	c.Assert(last.Line, Equals, 4)
+	c.Assert(last.SourceLine, Equals, 2)
+	c.Assert(last.Count, Equals, 1)
+
+	stop := errors.New("stop")
+	_, err = BlameWithOptions(head, "c.txt", &BlameOptions{
+		Incremental: func(*BlameEntry) error { return stop },
+	})
+	c.Assert(err, Equals, stop)
+}
+
 func (s *BlameSuite) mockBlame(c *C, t blameTest, r *Repository) (blame *BlameResult) {
 	commit, err := r.CommitObject(plumbing.NewHash(t.rev))
 	c.Assert(err, IsNil, Commentf("%v: repo=%s, rev=%s", err, t.repo, t.rev))
@@ -81,10 +312,11 @@ func (s *BlameSuite) mockBlame(c *C, t blameTest, r *Repository) (blame *BlameRe
 		commit, err := r.CommitObject(plumbing.NewHash(t.blames[i]))
 		c.Assert(err, IsNil)
 		l := &Line{
-			Author: commit.Author.Email,
-			Text:   lines[i],
-			Date:   commit.Author.When,
-			Hash:   commit.Hash,
+			Author:     commit.Author.Email,
+			AuthorName: commit.Author.Name,
+			Text:       lines[i],
+			Date:       commit.Author.When,
+			Hash:       commit.Hash,
 		}
 		blamedLines = append(blamedLines, l)
 	}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/transport/git"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+)
+
+type CmdDaemon struct {
+	cmd
+
+	BasePath        string   `long:"base-path" required:"true" description:"Remap all the path requests as relative to the given path"`
+	ExportAll       bool     `long:"export-all" description:"Serve the repositories without a git-daemon-export-ok file"`
+	Enable          []string `long:"enable" choice:"receive-pack" choice:"upload-archive" description:"Enable a service"`
+	Listen          string   `long:"listen" description:"Listen on a specific IP address or hostname"`
+	Port            int      `long:"port" default:"9418" description:"Listen on an alternative port"`
+	MaxConnections  int      `long:"max-connections" description:"Maximum number of concurrent clients, unlimited if zero"`
+	InitTimeout     int      `long:"init-timeout" description:"Timeout in seconds between the connection and the request"`
+	Timeout         int      `long:"timeout" description:"Timeout in seconds of the reads and writes of a connection, unlimited if zero"`
+	ShutdownTimeout int      `long:"shutdown-timeout" default:"30" description:"Time in seconds to wait for the connections to finish on shutdown"`
+}
+
+func (CmdDaemon) Usage() string {
+	return fmt.Sprintf("usage: %s daemon [options]", os.Args[0])
+}
+
+func (c *CmdDaemon) Execute(args []string) error {
+	d := &git.Daemon{
+		ExportAll:      c.ExportAll,
+		MaxConnections: c.MaxConnections,
+		InitTimeout:    time.Duration(c.InitTimeout) * time.Second,
+		Timeout:        time.Duration(c.Timeout) * time.Second,
+		Loader:         server.NewFilesystemLoader(osfs.New(c.BasePath)),
+		ErrorLog:       log.New(os.Stderr, "", log.LstdFlags),
+	}
+
+	for _, service := range c.Enable {
+		switch service {
+		case "receive-pack":
+			d.ReceivePack = true
+		case "upload-archive":
+			d.UploadArchive = true
+		}
+	}
+
+	// On a signal, the daemon stops accepting connections and the command
+	// waits for the ones being served before exiting.
+	stopped := make(chan struct{})
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		defer close(stopped)
+		<-sig
+		ctx, cancel := context.WithTimeout(context.Background(),
+			time.Duration(c.ShutdownTimeout)*time.Second,
+		)
+
+		defer cancel()
+		if err := d.Shutdown(ctx); err != nil {
+			_ = d.Close()
+		}
+	}()
+
+	addr := net.JoinHostPort(c.Listen, strconv.Itoa(c.Port))
+	if err := d.ListenAndServe(addr); err != git.ErrDaemonClosed {
+		fmt.Fprintln(os.Stderr, "ERR:", err)
+		os.Exit(128)
+	}
+
+	<-stopped
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/file"
+)
+
+type CmdUploadArchive struct {
+	cmd
+
+	Args struct {
+		GitDir string `positional-arg-name:"git-dir" required:"true"`
+	} `positional-args:"yes"`
+}
+
+func (CmdUploadArchive) Usage() string {
+	return fmt.Sprintf("usage: %s <git-dir>", os.Args[0])
+}
+
+func (c *CmdUploadArchive) Execute(args []string) error {
+	gitDir, err := filepath.Abs(c.Args.GitDir)
+	if err != nil {
+		return err
+	}
+
+	if err := file.ServeUploadArchive(gitDir); err != nil {
+		fmt.Fprintln(os.Stderr, "ERR:", err)
+		os.Exit(128)
+	}
+
+	return nil
+}
@@ -89,6 +89,13 @@ type Config struct {
 		Window uint
 	}
 
+	Extensions struct {
+		// RefStorage is the format used to store the references, empty for
+		// loose references and packed-refs, "reftable" for the reftable
+		// format.
+		RefStorage string
+	}
+
 	Init struct {
 		// DefaultBranch Allows overriding the default branch name
 		// e.g. when initializing a new repository or when cloning
@@ -235,6 +242,7 @@ const (
 	authorSection    = "author"
 	committerSection = "committer"
 	initSection      = "init"
+	extensionSection = "extensions"
 	urlSection       = "url"
 	fetchKey         = "fetch"
 	urlKey           = "url"
@@ -248,6 +256,8 @@ const (
 	emailKey         = "email"
 	descriptionKey   = "description"
 	defaultBranchKey = "defaultBranch"
+	refStorageKey    = "refStorage"
+	formatVersionKey = "repositoryformatversion"
 
 	// DefaultPackWindow holds the number of previous objects used to
 	// generate deltas. The value 10 is the same used by git command.
@@ -267,6 +277,7 @@ func (c *Config) Unmarshal(b []byte) error {
 	c.unmarshalCore()
 	c.unmarshalUser()
 	c.unmarshalInit()
+	c.unmarshalExtensions()
 	if err := c.unmarshalPack(); err != nil {
 		return err
 	}
@@ -388,6 +399,11 @@ func (c *Config) unmarshalInit() {
 	c.Init.DefaultBranch = s.Options.Get(defaultBranchKey)
 }
 
+func (c *Config) unmarshalExtensions() {
+	s := c.Raw.Section(extensionSection)
+	c.Extensions.RefStorage = s.Options.Get(refStorageKey)
+}
+
 // Marshal returns Config encoded as a git-config file.
 func (c *Config) Marshal() ([]byte, error) {
 	c.marshalCore()
@@ -398,6 +414,7 @@ func (c *Config) Marshal() ([]byte, error) {
 	c.marshalBranches()
 	c.marshalURLs()
 	c.marshalInit()
+	c.marshalExtensions()
 
 	buf := bytes.NewBuffer(nil)
 	if err := format.NewEncoder(buf).Encode(c.Raw); err != nil {
@@ -542,6 +559,16 @@ func (c *Config) marshalInit() {
 	}
 }
 
+func (c *Config) marshalExtensions() {
+	if c.Extensions.RefStorage == "" {
+		return
+	}
+
+	// Extensions are only honored by git in version 1 repositories.
+	c.Raw.Section(coreSection).SetOption(formatVersionKey, "1")
+	c.Raw.Section(extensionSection).SetOption(refStorageKey, c.Extensions.RefStorage)
+}
+
 // RemoteConfig contains the configuration for a given remote repository.
 type RemoteConfig struct {
 	// Name of the remote
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+// URLOptions returns the options of a section which apply to a URL, as git
+// does for the http.<url>.* options: the options of the section, followed by
+// the ones of the subsections named after a URL matching the given one, from
+// the least to the most specific match. Hence, as with format.Options.Get,
+// the last value of an option is the one to use.
+//
+// A subsection URL matches if it has the same scheme, host, port and user, if
+// any, and its path is a prefix of the one of the URL. Each element of its
+// host may be "*" to match any element. A match is more specific than another
+// if its host is exactly the same, its path is longer or, with the same path,
+// it has a user.
+func URLOptions(s *format.Section, rawURL string) format.Options {
+	opts := append(format.Options(nil), s.Options...)
+
+	u, err := url.Parse(rawURL)
+	if err != nil || !u.IsAbs() {
+		return opts
+	}
+
+	type match struct {
+		opts  format.Options
+		score urlMatchScore
+	}
+
+	var matches []match
+	for _, ss := range s.Subsections {
+		score, ok := matchURL(ss.Name, u)
+		if ok {
+			matches = append(matches, match{ss.Options, score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score.less(matches[j].score)
+	})
+
+	for _, m := range matches {
+		opts = append(opts, m.opts...)
+	}
+
+	return opts
+}
+
+type urlMatchScore struct {
+	exactHost bool
+	pathLen   int
+	user      bool
+}
+
+func (s urlMatchScore) less(o urlMatchScore) bool {
+	if s.exactHost != o.exactHost {
+		return !s.exactHost
+	}
+
+	if s.pathLen != o.pathLen {
+		return s.pathLen < o.pathLen
+	}
+
+	return !s.user && o.user
+}
+
+func matchURL(pattern string, u *url.URL) (urlMatchScore, bool) {
+	var score urlMatchScore
+	p, err := url.Parse(pattern)
+	if err != nil || !p.IsAbs() || !strings.EqualFold(p.Scheme, u.Scheme) {
+		return score, false
+	}
+
+	if p.User != nil {
+		if u.User == nil || p.User.Username() != u.User.Username() {
+			return score, false
+		}
+
+		score.user = true
+	}
+
+	exact, ok := matchHost(p.Hostname(), u.Hostname())
+	if !ok || urlPort(p) != urlPort(u) {
+		return score, false
+	}
+
+	score.exactHost = exact
+
+	path := strings.TrimSuffix(p.Path, "/")
+	if path != "" && u.Path != path && !strings.HasPrefix(u.Path, path+"/") {
+		return score, false
+	}
+
+	score.pathLen = len(path)
+	return score, true
+}
+
+// matchHost matches a host with a pattern whose elements may be "*",
+// returning whether it matched exactly.
+func matchHost(pattern, host string) (exact, ok bool) {
+	if strings.EqualFold(pattern, host) {
+		return true, true
+	}
+
+	pe := strings.Split(pattern, ".")
+	he := strings.Split(host, ".")
+	if len(pe) != len(he) {
+		return false, false
+	}
+
+	for i := range pe {
+		if pe[i] != "*" && !strings.EqualFold(pe[i], he[i]) {
+			return false, false
+		}
+	}
+
+	return false, true
+}
+
+var defaultURLPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"git":   "9418",
+	"ssh":   "22",
+}
+
+func urlPort(u *url.URL) string {
+	if port := u.Port(); port != "" {
+		return port
+	}
+
+	return defaultURLPorts[strings.ToLower(u.Scheme)]
+}
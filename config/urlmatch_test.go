@@ -0,0 +1,50 @@
+package config
+
+import (
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+
+	. "gopkg.in/check.v1"
+)
+
+type URLMatchSuite struct{}
+
+var _ = Suite(&URLMatchSuite{})
+
+func (s *URLMatchSuite) TestURLOptions(c *C) {
+	cfg := format.New()
+	cfg.Section("http").SetOption("proxy", "default")
+	cfg.Section("http").Subsection("https://example.com/org").SetOption("proxy", "org")
+	cfg.Section("http").Subsection("https://*.example.com").SetOption("proxy", "wildcard")
+	cfg.Section("http").Subsection("https://example.com").SetOption("proxy", "host")
+	cfg.Section("http").Subsection("https://user@example.com/org").SetOption("proxy", "user")
+	cfg.Section("http").Subsection("https://example.com:8443").SetOption("proxy", "port")
+	cfg.Section("http").Subsection("http://example.com").SetOption("proxy", "http")
+
+	for url, expected := range map[string]string{
+		"https://example.com/repo.git":          "host",
+		"https://example.com:443/repo.git":      "host",
+		"https://example.com/org/repo.git":      "org",
+		"https://example.com/organization.git":  "host",
+		"https://user@example.com/org/repo.git": "user",
+		"https://other@example.com/org/r.git":   "org",
+		"https://git.example.com/repo.git":      "wildcard",
+		"https://a.git.example.com/repo.git":    "default",
+		"https://example.com:8443/repo.git":     "port",
+		"http://example.com/repo.git":           "http",
+		"https://example.org/repo.git":          "default",
+		"not a url":                             "default",
+	} {
+		opts := URLOptions(cfg.Section("http"), url)
+		c.Assert(opts.Get("proxy"), Equals, expected, Commentf("%s", url))
+	}
+}
+
+func (s *URLMatchSuite) TestURLOptionsExactHost(c *C) {
+	cfg := format.New()
+	cfg.Section("http").Subsection("https://example.com").SetOption("proxy", "host")
+	cfg.Section("http").Subsection("https://*.com/org").SetOption("proxy", "wildcard")
+
+	opts := URLOptions(cfg.Section("http"), "https://example.com/org/repo.git")
+	c.Assert(opts.Get("proxy"), Equals, "host")
+	c.Assert(opts.GetAll("proxy"), DeepEquals, []string{"wildcard", "host"})
+}
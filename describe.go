@@ -0,0 +1,549 @@
+package git
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// describeCandidates is the number of tags found walking the history among
+// which the nearest one is chosen, as git describe does by default.
+const describeCandidates = 10
+
+// ErrNoDescribeTag is returned by Describe when no tag can describe a commit.
+var ErrNoDescribeTag = errors.New("no tag can describe the commit")
+
+type describeTag struct {
+	name      string
+	annotated bool
+	when      time.Time
+}
+
+// better returns true if t should be preferred to other when both tag the
+// same commit: annotated tags first, then the most recent one.
+func (t *describeTag) better(other *describeTag) bool {
+	if t.annotated != other.annotated {
+		return t.annotated
+	}
+
+	if !t.when.Equal(other.when) {
+		return t.when.After(other.when)
+	}
+
+	return t.name < other.name
+}
+
+// Describe returns a human readable name of a commit based on the nearest
+// tag reachable from it, in the "<tag>-<n>-g<abbrev>" form used by git
+// describe, where n is the number of commits on top of the tag and abbrev the
+// shortest unique prefix of the commit hash. The tag name alone is returned
+// if the commit is tagged, unless Long is set.
+func (r *Repository) Describe(c *object.Commit, o *DescribeOptions) (string, error) {
+	if o == nil {
+		o = &DescribeOptions{}
+	}
+
+	if err := o.Validate(); err != nil {
+		return "", err
+	}
+
+	tags, err := r.describeTags(o)
+	if err != nil {
+		return "", err
+	}
+
+	desc, err := r.describe(c, o, tags)
+	if err != nil {
+		return "", err
+	}
+
+	if !o.Dirty {
+		return desc, nil
+	}
+
+	w, err := r.Worktree()
+	if err != nil {
+		return "", err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return "", err
+	}
+
+	if !status.IsClean() {
+		desc += o.DirtyMark
+	}
+
+	return desc, nil
+}
+
+// describeTags returns the best tag of every tagged commit, among the tags
+// selected by the options.
+func (r *Repository) describeTags(o *DescribeOptions) (map[plumbing.Hash]*describeTag, error) {
+	iter, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[plumbing.Hash]*describeTag)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		if !describeMatch(name, o) {
+			return nil
+		}
+
+		t := &describeTag{name: name}
+		h := ref.Hash()
+		for {
+			obj, err := r.Object(plumbing.AnyObject, h)
+			if err != nil {
+				return err
+			}
+
+			tag, ok := obj.(*object.Tag)
+			if !ok {
+				if _, ok := obj.(*object.Commit); !ok {
+					return nil
+				}
+
+				break
+			}
+
+			if !t.annotated {
+				t.annotated = true
+				t.when = tag.Tagger.When
+			}
+
+			h = tag.Target
+		}
+
+		if !t.annotated && !o.Tags {
+			return nil
+		}
+
+		if current, ok := tags[h]; !ok || t.better(current) {
+			tags[h] = t
+		}
+
+		return nil
+	})
+
+	return tags, err
+}
+
+func describeMatch(name string, o *DescribeOptions) bool {
+	for _, p := range o.Exclude {
+		if ok, _ := wildmatch(p, name); ok {
+			return false
+		}
+	}
+
+	if len(o.Match) == 0 {
+		return true
+	}
+
+	for _, p := range o.Match {
+		if ok, _ := wildmatch(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wildmatch reports whether name matches the shell pattern, the way git
+// matches the tag names given to describe. Unlike path.Match, "*" and "?"
+// also match "/", bracket expressions may be negated with "^" as well as
+// "!" and accept the POSIX character classes such as "[:alpha:]".
+// path.ErrBadPattern is returned if the pattern is malformed.
+func wildmatch(pattern, name string) (bool, error) {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+
+			if len(pattern) == 0 {
+				return true, nil
+			}
+
+			for i := 0; i <= len(name); i++ {
+				ok, err := wildmatch(pattern, name[i:])
+				if ok || err != nil {
+					return ok, err
+				}
+			}
+
+			return false, nil
+		case '?':
+			if len(name) == 0 {
+				return false, nil
+			}
+
+			pattern, name = pattern[1:], name[1:]
+		case '[':
+			ok, rest, err := matchBracket(pattern[1:], name)
+			if err != nil || !ok {
+				return false, err
+			}
+
+			pattern, name = rest, name[1:]
+		default:
+			if pattern[0] == '\\' {
+				if len(pattern) == 1 {
+					return false, path.ErrBadPattern
+				}
+
+				pattern = pattern[1:]
+			}
+
+			if len(name) == 0 || pattern[0] != name[0] {
+				return false, nil
+			}
+
+			pattern, name = pattern[1:], name[1:]
+		}
+	}
+
+	return len(name) == 0, nil
+}
+
+// checkWildmatch returns path.ErrBadPattern if pattern is malformed.
+func checkWildmatch(pattern string) error {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '\\':
+			if len(pattern) == 1 {
+				return path.ErrBadPattern
+			}
+
+			pattern = pattern[2:]
+		case '[':
+			_, rest, err := matchBracket(pattern[1:], "")
+			if err != nil {
+				return err
+			}
+
+			pattern = rest
+		default:
+			pattern = pattern[1:]
+		}
+	}
+
+	return nil
+}
+
+var wildmatchClasses = map[string]func(byte) bool{
+	"alnum":  func(c byte) bool { return isAlpha(c) || isDigit(c) },
+	"alpha":  isAlpha,
+	"blank":  func(c byte) bool { return c == ' ' || c == '\t' },
+	"cntrl":  func(c byte) bool { return c < 0x20 || c == 0x7f },
+	"digit":  isDigit,
+	"graph":  func(c byte) bool { return c > 0x20 && c < 0x7f },
+	"lower":  func(c byte) bool { return c >= 'a' && c <= 'z' },
+	"print":  func(c byte) bool { return c >= 0x20 && c < 0x7f },
+	"punct":  func(c byte) bool { return c > 0x20 && c < 0x7f && !isAlpha(c) && !isDigit(c) },
+	"space":  func(c byte) bool { return c == ' ' || (c >= '\t' && c <= '\r') },
+	"upper":  func(c byte) bool { return c >= 'A' && c <= 'Z' },
+	"xdigit": func(c byte) bool { return isDigit(c) || (c|0x20 >= 'a' && c|0x20 <= 'f') },
+}
+
+func isAlpha(c byte) bool { return c|0x20 >= 'a' && c|0x20 <= 'z' }
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// matchBracket matches the first byte of name against the bracket expression
+// at the start of pattern, right after its "[", returning the rest of the
+// pattern after the closing "]". A malformed expression is an error even if
+// name is empty, so patterns can be validated.
+func matchBracket(pattern, name string) (ok bool, rest string, err error) {
+	negated := len(pattern) > 0 && (pattern[0] == '!' || pattern[0] == '^')
+	if negated {
+		pattern = pattern[1:]
+	}
+
+	var c byte
+	if len(name) > 0 {
+		c = name[0]
+	}
+
+	for i := 0; ; i++ {
+		if len(pattern) == 0 {
+			return false, "", path.ErrBadPattern
+		}
+
+		if pattern[0] == ']' && i > 0 {
+			pattern = pattern[1:]
+			break
+		}
+
+		if strings.HasPrefix(pattern, "[:") {
+			end := strings.Index(pattern[2:], ":]")
+			if end < 0 {
+				return false, "", path.ErrBadPattern
+			}
+
+			class, found := wildmatchClasses[pattern[2:2+end]]
+			if !found {
+				return false, "", path.ErrBadPattern
+			}
+
+			ok = ok || class(c)
+			pattern = pattern[2+end+2:]
+			continue
+		}
+
+		lo, tail, err := bracketChar(pattern)
+		if err != nil {
+			return false, "", err
+		}
+
+		hi := lo
+		if len(tail) > 1 && tail[0] == '-' && tail[1] != ']' {
+			hi, tail, err = bracketChar(tail[1:])
+			if err != nil {
+				return false, "", err
+			}
+		}
+
+		ok = ok || (lo <= c && c <= hi)
+		pattern = tail
+	}
+
+	if len(name) == 0 {
+		return false, pattern, nil
+	}
+
+	return ok != negated, pattern, nil
+}
+
+func bracketChar(pattern string) (byte, string, error) {
+	if pattern[0] == '\\' {
+		if len(pattern) == 1 {
+			return 0, "", path.ErrBadPattern
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return pattern[0], pattern[1:], nil
+}
+
+func (r *Repository) describe(c *object.Commit, o *DescribeOptions, tags map[plumbing.Hash]*describeTag) (string, error) {
+	if t, ok := tags[c.Hash]; ok && (!o.Long || o.NoAbbrev) {
+		return t.name, nil
+	}
+
+	w := &describeWalk{r: r, firstParent: o.FirstParent, commits: make(map[plumbing.Hash]*describeCommit)}
+	best, err := w.nearest(c, tags)
+	if err != nil {
+		return "", err
+	}
+
+	if best == nil {
+		return "", fmt.Errorf("%w: %s", ErrNoDescribeTag, c.Hash)
+	}
+
+	if o.NoAbbrev {
+		return best.tag.name, nil
+	}
+
+	return fmt.Sprintf("%s-%d-g%s", best.tag.name, best.depth, r.abbreviateHash(c.Hash, o.Abbrev)), nil
+}
+
+// abbreviateHash returns the shortest prefix of h, of at least min digits,
+// which doesn't match any other object of the repository.
+func (r *Repository) abbreviateHash(h plumbing.Hash, min int) string {
+	s := h.String()
+	n := min
+	for _, other := range r.resolveHashPrefix(s[:min]) {
+		if other == h {
+			continue
+		}
+
+		o := other.String()
+		for n < len(s) && o[:n] == s[:n] {
+			n++
+		}
+	}
+
+	return s[:n]
+}
+
+// describeCandidate is a tagged commit found walking the history. Its depth
+// is the number of commits walked which aren't reachable from it.
+type describeCandidate struct {
+	tag   *describeTag
+	depth int
+	// flag is the bit set on the commits reachable from the candidate.
+	flag uint32
+}
+
+type describeCommit struct {
+	commit *object.Commit
+	// flags has the flag of every candidate the commit is reachable from.
+	flags uint32
+	seq   int
+}
+
+// describeWalk walks the history in commit date order, as git describe does,
+// propagating the flags of the candidates to their ancestors, so the depth of
+// every candidate is counted in a single walk.
+type describeWalk struct {
+	r           *Repository
+	firstParent bool
+	commits     map[plumbing.Hash]*describeCommit
+	queue       describeQueue
+}
+
+// nearest returns the candidate with the fewest commits on top of it among
+// the first describeCandidates tagged commits found, or nil if there is none.
+func (w *describeWalk) nearest(c *object.Commit, tags map[plumbing.Hash]*describeTag) (*describeCandidate, error) {
+	w.push(c, 0)
+
+	var candidates []*describeCandidate
+	var gaveUp *describeCommit
+	walked := 0
+	for w.queue.Len() > 0 {
+		dc := heap.Pop(&w.queue).(*describeCommit)
+		walked++
+
+		if t, ok := tags[dc.commit.Hash]; ok {
+			if len(candidates) == describeCandidates {
+				gaveUp = dc
+				break
+			}
+
+			cand := &describeCandidate{tag: t, depth: walked - 1, flag: 1 << uint(len(candidates))}
+			candidates = append(candidates, cand)
+			dc.flags |= cand.flag
+		}
+
+		for _, cand := range candidates {
+			if dc.flags&cand.flag == 0 {
+				cand.depth++
+			}
+		}
+
+		if err := w.pushParents(dc); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].depth < candidates[j].depth
+	})
+
+	best := candidates[0]
+	if gaveUp == nil {
+		return best, nil
+	}
+
+	// The walk stopped before reaching every commit not reachable from the
+	// best candidate, its depth is completed until only commits reachable
+	// from it are left.
+	heap.Push(&w.queue, gaveUp)
+	for w.queue.Len() > 0 {
+		dc := heap.Pop(&w.queue).(*describeCommit)
+		if dc.flags&best.flag == 0 {
+			best.depth++
+		} else if w.queue.all(best.flag) {
+			break
+		}
+
+		if err := w.pushParents(dc); err != nil {
+			return nil, err
+		}
+	}
+
+	return best, nil
+}
+
+func (w *describeWalk) push(c *object.Commit, flags uint32) {
+	dc := &describeCommit{commit: c, flags: flags}
+	w.commits[c.Hash] = dc
+	heap.Push(&w.queue, dc)
+}
+
+// pushParents propagates the flags of dc to its parents, queueing the ones
+// not seen yet.
+func (w *describeWalk) pushParents(dc *describeCommit) error {
+	parents := dc.commit.ParentHashes
+	if w.firstParent && len(parents) > 1 {
+		parents = parents[:1]
+	}
+
+	for _, h := range parents {
+		if p, ok := w.commits[h]; ok {
+			p.flags |= dc.flags
+			continue
+		}
+
+		c, err := w.r.CommitObject(h)
+		if err != nil {
+			return err
+		}
+
+		w.push(c, dc.flags)
+	}
+
+	return nil
+}
+
+// describeQueue is a priority queue of commits, the newest first, or the
+// first pushed for the same commit time.
+type describeQueue struct {
+	entries []*describeCommit
+	seq     int
+}
+
+func (q *describeQueue) Len() int { return len(q.entries) }
+
+func (q *describeQueue) Less(i, j int) bool {
+	a, b := q.entries[i], q.entries[j]
+	if !a.commit.Committer.When.Equal(b.commit.Committer.When) {
+		return a.commit.Committer.When.After(b.commit.Committer.When)
+	}
+
+	return a.seq < b.seq
+}
+
+func (q *describeQueue) Swap(i, j int) { q.entries[i], q.entries[j] = q.entries[j], q.entries[i] }
+
+func (q *describeQueue) Push(x interface{}) {
+	dc := x.(*describeCommit)
+	dc.seq = q.seq
+	q.seq++
+	q.entries = append(q.entries, dc)
+}
+
+func (q *describeQueue) Pop() interface{} {
+	n := len(q.entries) - 1
+	dc := q.entries[n]
+	q.entries[n] = nil
+	q.entries = q.entries[:n]
+	return dc
+}
+
+// all returns true if every queued commit has the given flag.
+func (q *describeQueue) all(flag uint32) bool {
+	for _, dc := range q.entries {
+		if dc.flags&flag == 0 {
+			return false
+		}
+	}
+
+	return true
+}
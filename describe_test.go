@@ -0,0 +1,223 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type DescribeSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&DescribeSuite{})
+
+// newRepository returns the basic fixture with the annotated tags v1.0 on
+// b029517 and v1.1 on b8e471f, and the lightweight tag light on af2d6a6,
+// besides the existing lightweight tag v1.0.0 on HEAD.
+func (s *DescribeSuite) newRepository(c *C) *Repository {
+	r := s.NewRepository(fixtures.Basic().One())
+
+	when := time.Unix(1600000000, 0)
+	for name, h := range map[string]string{
+		"v1.0": "b029517f6300c2da0f4b651b8642506cd6aaf45d",
+		"v1.1": "b8e471f58bcbca63b07bda20e428190409c2db47",
+	} {
+		when = when.Add(time.Minute)
+		_, err := r.CreateTag(name, plumbing.NewHash(h), &CreateTagOptions{
+			Tagger:  &object.Signature{Name: "foo", Email: "foo@foo.foo", When: when},
+			Message: name,
+		})
+		c.Assert(err, IsNil)
+	}
+
+	_, err := r.CreateTag("light", plumbing.NewHash("af2d6a6954d532f8ffb47615169c8fdf9d383a1a"), nil)
+	c.Assert(err, IsNil)
+	return r
+}
+
+func (s *DescribeSuite) describe(c *C, r *Repository, h string, o *DescribeOptions) string {
+	commit, err := r.CommitObject(plumbing.NewHash(h))
+	c.Assert(err, IsNil)
+
+	desc, err := r.Describe(commit, o)
+	c.Assert(err, IsNil)
+	return desc
+}
+
+func (s *DescribeSuite) TestDescribe(c *C) {
+	r := s.newRepository(c)
+
+	head := "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"
+	for _, t := range []struct {
+		hash     string
+		opts     *DescribeOptions
+		expected string
+	}{
+		{head, nil, "v1.1-6-g6ecf0ef"},
+		{head, &DescribeOptions{Tags: true}, "v1.0.0"},
+		{head, &DescribeOptions{FirstParent: true}, "v1.0-5-g6ecf0ef"},
+		{head, &DescribeOptions{Match: []string{"v1.0"}}, "v1.0-7-g6ecf0ef"},
+		{head, &DescribeOptions{Exclude: []string{"v1.1"}}, "v1.0-7-g6ecf0ef"},
+		{head, &DescribeOptions{Tags: true, Exclude: []string{"v1.0*"}}, "light-2-g6ecf0ef"},
+		{head, &DescribeOptions{Abbrev: 10}, "v1.1-6-g6ecf0ef2c2"},
+		{head, &DescribeOptions{Abbrev: 2}, "v1.1-6-g6ecf"},
+		{head, &DescribeOptions{NoAbbrev: true}, "v1.1"},
+		{head, &DescribeOptions{Tags: true, Long: true, NoAbbrev: true}, "v1.0.0"},
+		{head, &DescribeOptions{Tags: true, Long: true}, "v1.0.0-0-g6ecf0ef"},
+		{"b8e471f58bcbca63b07bda20e428190409c2db47", nil, "v1.1"},
+		{"b8e471f58bcbca63b07bda20e428190409c2db47", &DescribeOptions{Long: true}, "v1.1-0-gb8e471f"},
+		{"e8d3ffab552895c19b9fcf7aa264d277cde33881", &DescribeOptions{Tags: true}, "light-2-ge8d3ffa"},
+	} {
+		c.Assert(s.describe(c, r, t.hash, t.opts), Equals, t.expected, Commentf("%+v", t.opts))
+	}
+}
+
+func (s *DescribeSuite) TestDescribeNoTag(c *C) {
+	r := s.newRepository(c)
+
+	commit, err := r.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+
+	_, err = r.Describe(commit, &DescribeOptions{Match: []string{"v2.*"}})
+	c.Assert(errors.Is(err, ErrNoDescribeTag), Equals, true)
+
+	_, err = r.Describe(commit, &DescribeOptions{Match: []string{"["}})
+	c.Assert(errors.Is(err, ErrInvalidDescribePattern), Equals, true)
+}
+
+func (s *DescribeSuite) TestDescribeUniqueAbbrev(c *C) {
+	r := s.newRepository(c)
+
+	// a blob sharing the first four digits of the hash of the commit
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	var content []byte
+	for i := 0; ; i++ {
+		content = []byte(fmt.Sprint(i))
+		h := plumbing.ComputeHash(plumbing.BlobObject, content).String()
+		if strings.HasPrefix(h, "6ecf") && !strings.HasPrefix(h, "6ecf0") {
+			break
+		}
+	}
+
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write(content)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	_, err = r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.describe(c, r, head.String(), &DescribeOptions{Abbrev: 4}), Equals, "v1.1-6-g6ecf0")
+	c.Assert(s.describe(c, r, head.String(), nil), Equals, "v1.1-6-g6ecf0ef")
+
+	commit, err := r.CommitObject(head)
+	c.Assert(err, IsNil)
+	_, err = r.Describe(commit, &DescribeOptions{Abbrev: -1})
+	c.Assert(errors.Is(err, ErrInvalidDescribeAbbrev), Equals, true)
+}
+
+func (s *DescribeSuite) TestDescribeDirty(c *C) {
+	fs := memfs.New()
+	r, err := Clone(memory.NewStorage(), fs, &CloneOptions{
+		URL: s.GetBasicLocalRepositoryURL(),
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	commit, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+
+	o := &DescribeOptions{Tags: true, Dirty: true}
+	desc, err := r.Describe(commit, o)
+	c.Assert(err, IsNil)
+	c.Assert(desc, Equals, "v1.0.0")
+
+	c.Assert(util.WriteFile(fs, "CHANGELOG", []byte("foo"), 0644), IsNil)
+
+	desc, err = r.Describe(commit, o)
+	c.Assert(err, IsNil)
+	c.Assert(desc, Equals, "v1.0.0-dirty")
+
+	desc, err = r.Describe(commit, &DescribeOptions{Tags: true, Dirty: true, DirtyMark: "+"})
+	c.Assert(err, IsNil)
+	c.Assert(desc, Equals, "v1.0.0+")
+}
+
+func (s *DescribeSuite) TestDescribeManyCandidates(c *C) {
+	r, err := Init(memory.NewStorage(), memfs.New())
+	c.Assert(err, IsNil)
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	// a linear history of 15 commits, all but the last one tagged, more
+	// than the candidates considered
+	var head plumbing.Hash
+	for i := 0; i < 15; i++ {
+		head, err = w.Commit(fmt.Sprintf("commit %d", i), &CommitOptions{
+			AllowEmptyCommits: true,
+			Author:            &object.Signature{Name: "foo", When: time.Unix(int64(1600000000+i), 0)},
+		})
+		c.Assert(err, IsNil)
+
+		if i < 14 {
+			_, err = r.CreateTag(fmt.Sprintf("t%d", i), head, nil)
+			c.Assert(err, IsNil)
+		}
+	}
+
+	c.Assert(s.describe(c, r, head.String(), &DescribeOptions{Tags: true}), Equals, "t13-1-g"+head.String()[:7])
+	c.Assert(s.describe(c, r, head.String(), &DescribeOptions{Tags: true, Match: []string{"t1", "t2"}}), Equals, "t2-12-g"+head.String()[:7])
+}
+
+func (s *DescribeSuite) TestDescribeMatchSlash(c *C) {
+	r := s.newRepository(c)
+
+	_, err := r.CreateTag("release/v2", plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"), nil)
+	c.Assert(err, IsNil)
+
+	desc := s.describe(c, r, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5", &DescribeOptions{Tags: true, Match: []string{"rel*2"}})
+	c.Assert(desc, Equals, "release/v2-1-g6ecf0ef")
+}
+
+func (s *DescribeSuite) TestWildmatch(c *C) {
+	for _, t := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"v1.*", "v1.0", true},
+		{"v1.*", "v2.0", false},
+		{"*", "release/v1", true},
+		{"release?v1", "release/v1", true},
+		{"v[0-9].[!0]", "v1.1", true},
+		{"v[0-9].[^0]", "v1.0", false},
+		{"v[[:digit:]]", "v7", true},
+		{"v[[:alpha:]]", "v7", false},
+		{"v[]]", "v]", true},
+		{"v\\*", "v*", true},
+		{"v\\*", "v1", false},
+		{"*-rc*", "v1.0-rc1", true},
+		{"", "", true},
+	} {
+		ok, err := wildmatch(t.pattern, t.name)
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, t.match, Commentf("%q %q", t.pattern, t.name))
+	}
+
+	for _, p := range []string{"[", "v[1", "v\\", "[[:foo:]]", "a*[b"} {
+		c.Assert(checkWildmatch(p), NotNil, Commentf("%q", p))
+	}
+}
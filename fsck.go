@@ -0,0 +1,680 @@
+package git
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	stdioutil "io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/commitgraph"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const (
+	objectsDir      = "objects"
+	packDir         = "pack"
+	commitGraphPath = "objects/info/commit-graph"
+)
+
+var (
+	// ErrFsckHashMismatch is reported when the content of an object doesn't
+	// match its hash.
+	ErrFsckHashMismatch = errors.New("hash mismatch")
+	// ErrFsckZlib is reported when the zlib stream of an object is corrupt.
+	ErrFsckZlib = errors.New("corrupt zlib stream")
+	// ErrFsckMalformedObject is reported when an object can't be read or
+	// decoded.
+	ErrFsckMalformedObject = errors.New("malformed object")
+	// ErrFsckMissingObject is reported when an object references an object
+	// which doesn't exist.
+	ErrFsckMissingObject = errors.New("missing object")
+	// ErrFsckBadTreeMode is reported when a tree entry has an invalid mode.
+	ErrFsckBadTreeMode = errors.New("bad tree entry mode")
+	// ErrFsckDuplicateTreeEntry is reported when a tree contains the same
+	// name more than once.
+	ErrFsckDuplicateTreeEntry = errors.New("duplicate tree entry")
+	// ErrFsckDotGitTreeEntry is reported when a tree entry is named ".git".
+	ErrFsckDotGitTreeEntry = errors.New("tree entry named .git")
+	// ErrFsckBadTreeEntryName is reported when a tree entry name is empty,
+	// "." or "..", or contains a slash.
+	ErrFsckBadTreeEntryName = errors.New("bad tree entry name")
+	// ErrFsckBadIdxChecksum is reported when an idx file is missing, corrupt
+	// or doesn't match its packfile.
+	ErrFsckBadIdxChecksum = errors.New("bad idx file checksum")
+	// ErrFsckBadPackChecksum is reported when the trailer of a packfile
+	// doesn't match its content.
+	ErrFsckBadPackChecksum = errors.New("bad packfile checksum")
+	// ErrFsckCorruptPack is reported when a packfile can't be opened or read.
+	ErrFsckCorruptPack = errors.New("corrupt packfile")
+	// ErrFsckBadCommitGraph is reported when the commit-graph file is corrupt
+	// or doesn't match the commits.
+	ErrFsckBadCommitGraph = errors.New("bad commit-graph")
+	// ErrFsckDanglingRef is reported when a reference points to an object
+	// which doesn't exist.
+	ErrFsckDanglingRef = errors.New("dangling reference")
+)
+
+// FsckOptions describes how a repository check should be performed.
+type FsckOptions struct {
+	// ConnectivityOnly only checks that the objects referenced by other
+	// objects and by the references exist, skipping the verification of the
+	// object contents, packfiles and commit-graph.
+	ConnectivityOnly bool
+	// Strict also reports the tree entries using the deprecated group
+	// writable file mode.
+	Strict bool
+}
+
+// FsckError is a problem found by Fsck. Err is one of the ErrFsck* errors,
+// so errors.Is can be used to tell the kind of problem.
+type FsckError struct {
+	Err error
+	// Hash is the object or packfile concerned, if any.
+	Hash plumbing.Hash
+	// Reference is the reference concerned, if any.
+	Reference plumbing.ReferenceName
+	// Path is the file or the tree entry concerned, if any.
+	Path string
+	// Details gives more information about the problem.
+	Details string
+}
+
+func (e *FsckError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	if !e.Hash.IsZero() {
+		b.WriteString(" " + e.Hash.String())
+	}
+
+	if e.Reference != "" {
+		b.WriteString(" " + e.Reference.String())
+	}
+
+	if e.Path != "" {
+		fmt.Fprintf(&b, " %q", e.Path)
+	}
+
+	if e.Details != "" {
+		b.WriteString(": " + e.Details)
+	}
+
+	return b.String()
+}
+
+func (e *FsckError) Unwrap() error {
+	return e.Err
+}
+
+// Fsck verifies the connectivity and validity of the objects in the
+// repository. Every object is checked: its hash and its zlib stream, the
+// existence of the objects it references, but for the parents of the shallow
+// commits, and the well-formedness of trees. The idx files, packfiles and the commit-graph file are verified as well as
+// the references.
+//
+// Problems found don't stop the check, they are all returned as FsckErrors.
+// The error is only set when the check itself can't be performed.
+func (r *Repository) Fsck(o FsckOptions) ([]*FsckError, error) {
+	f := &fsck{
+		r:        r,
+		o:        o,
+		objects:  make(map[plumbing.Hash]struct{}),
+		verified: make(map[plumbing.Hash]struct{}),
+		broken:   make(map[plumbing.Hash]struct{}),
+	}
+
+	if err := f.run(); err != nil {
+		return nil, err
+	}
+
+	return f.errs, nil
+}
+
+type fsck struct {
+	r *Repository
+	o FsckOptions
+
+	// objects is the set of all the objects of the repository.
+	objects map[plumbing.Hash]struct{}
+	// verified is the set of objects whose hash has been verified.
+	verified map[plumbing.Hash]struct{}
+	// broken is the set of objects already reported as unreadable.
+	broken map[plumbing.Hash]struct{}
+	// shallow is the set of the shallow commits, whose parents are missing.
+	shallow map[plumbing.Hash]struct{}
+
+	errs []*FsckError
+}
+
+func (f *fsck) report(err error, h plumbing.Hash, path, details string) {
+	f.errs = append(f.errs, &FsckError{Err: err, Hash: h, Path: path, Details: details})
+}
+
+func (f *fsck) run() error {
+	shallow, err := f.r.Storer.Shallow()
+	if err != nil {
+		return err
+	}
+
+	f.shallow = make(map[plumbing.Hash]struct{}, len(shallow))
+	for _, h := range shallow {
+		f.shallow[h] = struct{}{}
+	}
+
+	fs, ok := f.r.Storer.(interface{ Filesystem() billy.Filesystem })
+	if ok {
+		if err := f.checkLooseObjects(fs.Filesystem()); err != nil {
+			return err
+		}
+
+		if err := f.checkPacks(fs.Filesystem()); err != nil {
+			return err
+		}
+	} else if err := f.collectObjects(); err != nil {
+		return err
+	}
+
+	hashes := make([]plumbing.Hash, 0, len(f.objects))
+	for h := range f.objects {
+		hashes = append(hashes, h)
+	}
+
+	plumbing.HashesSort(hashes)
+	for _, h := range hashes {
+		if err := f.checkObject(h); err != nil {
+			return err
+		}
+	}
+
+	if ok && !f.o.ConnectivityOnly {
+		if err := f.checkCommitGraph(fs.Filesystem()); err != nil {
+			return err
+		}
+	}
+
+	return f.checkReferences()
+}
+
+// collectObjects records the objects of storers without direct access to
+// the object files.
+func (f *fsck) collectObjects() error {
+	iter, err := f.r.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return err
+	}
+
+	return iter.ForEach(func(obj plumbing.EncodedObject) error {
+		f.objects[obj.Hash()] = struct{}{}
+		return nil
+	})
+}
+
+// checkLooseObjects records the loose objects, verifying their zlib stream
+// and their hash.
+func (f *fsck) checkLooseObjects(fs billy.Filesystem) error {
+	los, ok := f.r.Storer.(storer.LooseObjectStorer)
+	if !ok {
+		return nil
+	}
+
+	return los.ForEachObjectHash(func(h plumbing.Hash) error {
+		f.objects[h] = struct{}{}
+		if f.o.ConnectivityOnly {
+			return nil
+		}
+
+		hex := h.String()
+		path := fs.Join(objectsDir, hex[0:2], hex[2:])
+		return f.checkLooseObject(fs, h, path)
+	})
+}
+
+func (f *fsck) checkLooseObject(fs billy.Filesystem, h plumbing.Hash, path string) (err error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		f.broken[h] = struct{}{}
+		f.report(ErrFsckMalformedObject, h, path, err.Error())
+		return nil
+	}
+
+	defer ioutil.CheckClose(file, &err)
+
+	r, err := objfile.NewReader(file)
+	if err != nil {
+		f.broken[h] = struct{}{}
+		f.report(ErrFsckZlib, h, path, err.Error())
+		return nil
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	if _, _, err := r.Header(); err != nil {
+		f.broken[h] = struct{}{}
+		f.report(ErrFsckMalformedObject, h, path, err.Error())
+		return nil
+	}
+
+	if _, err := io.Copy(stdioutil.Discard, r); err != nil {
+		f.broken[h] = struct{}{}
+		f.report(ErrFsckZlib, h, path, err.Error())
+		return nil
+	}
+
+	f.verified[h] = struct{}{}
+	if actual := r.Hash(); actual != h {
+		f.broken[h] = struct{}{}
+		f.report(ErrFsckHashMismatch, h, path, "content hashes to "+actual.String())
+	}
+
+	return nil
+}
+
+// checkPacks records the packed objects, verifying the idx files and the
+// packfiles.
+func (f *fsck) checkPacks(fs billy.Filesystem) error {
+	pos, ok := f.r.Storer.(storer.PackedObjectStorer)
+	if !ok {
+		return nil
+	}
+
+	packs, err := pos.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	for _, p := range packs {
+		base := fs.Join(objectsDir, packDir, fmt.Sprintf("pack-%s", p))
+		idx, err := f.checkIdxFile(fs, p, base+".idx")
+		if err != nil {
+			return err
+		}
+
+		if idx == nil {
+			continue
+		}
+
+		entries, err := idx.Entries()
+		if err != nil {
+			return err
+		}
+
+		crcs := make(map[int64]uint32)
+		for {
+			e, err := entries.Next()
+			if err == io.EOF {
+				break
+			}
+
+			if err != nil {
+				return err
+			}
+
+			f.objects[e.Hash] = struct{}{}
+			crcs[int64(e.Offset)] = e.CRC32
+		}
+
+		if err := entries.Close(); err != nil {
+			return err
+		}
+
+		if f.o.ConnectivityOnly {
+			continue
+		}
+
+		if err := f.checkPackfile(fs, p, base+".pack", idx, crcs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkIdxFile decodes and verifies the checksum of an idx file. A nil index
+// is returned if it is missing or corrupt.
+func (f *fsck) checkIdxFile(fs billy.Filesystem, p plumbing.Hash, path string) (idx *idxfile.MemoryIndex, err error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		f.report(ErrFsckBadIdxChecksum, p, path, err.Error())
+		return nil, nil
+	}
+
+	defer ioutil.CheckClose(file, &err)
+
+	sum := newTrailerHasher()
+	r := io.TeeReader(file, sum)
+	idx = idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(r).Decode(idx); err != nil {
+		f.report(ErrFsckBadIdxChecksum, p, path, err.Error())
+		return nil, nil
+	}
+
+	if f.o.ConnectivityOnly {
+		return idx, nil
+	}
+
+	if _, err := io.Copy(stdioutil.Discard, r); err != nil {
+		f.report(ErrFsckBadIdxChecksum, p, path, err.Error())
+		return nil, nil
+	}
+
+	if !bytes.Equal(sum.Sum(), idx.IdxChecksum[:]) {
+		f.report(ErrFsckBadIdxChecksum, p, path, "")
+	}
+
+	return idx, nil
+}
+
+// checkPackfile verifies the trailer of a packfile, and scans its objects
+// checking their zlib stream and their CRC32 against the idx file.
+func (f *fsck) checkPackfile(
+	fs billy.Filesystem, p plumbing.Hash, path string, idx *idxfile.MemoryIndex, crcs map[int64]uint32,
+) (err error) {
+	file, err := fs.Open(path)
+	if err != nil {
+		f.report(ErrFsckCorruptPack, p, path, err.Error())
+		return nil
+	}
+
+	defer ioutil.CheckClose(file, &err)
+
+	sum := newTrailerHasher()
+	if _, err := io.Copy(sum, file); err != nil {
+		f.report(ErrFsckCorruptPack, p, path, err.Error())
+		return nil
+	}
+
+	if len(sum.trailer) < sha1.Size {
+		f.report(ErrFsckCorruptPack, p, path, "file too short")
+		return nil
+	}
+
+	if !bytes.Equal(sum.Sum(), sum.trailer) {
+		f.report(ErrFsckBadPackChecksum, p, path, "")
+	}
+
+	if !bytes.Equal(sum.trailer, idx.PackfileChecksum[:]) {
+		f.report(ErrFsckBadIdxChecksum, p, path, "idx file doesn't match the packfile")
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	s := packfile.NewScanner(file)
+	_, count, err := s.Header()
+	if err != nil {
+		f.report(ErrFsckCorruptPack, p, path, err.Error())
+		return nil
+	}
+
+	for i := uint32(0); i < count; i++ {
+		oh, err := s.NextObjectHeader()
+		if err != nil {
+			f.report(ErrFsckCorruptPack, p, path, err.Error())
+			return nil
+		}
+
+		_, crc, err := s.NextObject(stdioutil.Discard)
+		if err != nil {
+			f.report(ErrFsckZlib, p, path, fmt.Sprintf("object at offset %d: %s", oh.Offset, err))
+			return nil
+		}
+
+		expected, ok := crcs[oh.Offset]
+		if !ok {
+			f.report(ErrFsckBadIdxChecksum, p, path, fmt.Sprintf("object at offset %d not indexed", oh.Offset))
+			continue
+		}
+
+		if crc != expected {
+			f.report(ErrFsckCorruptPack, p, path, fmt.Sprintf("CRC32 mismatch of object at offset %d", oh.Offset))
+		}
+	}
+
+	return nil
+}
+
+// trailerHasher hashes everything written to it except the last sha1.Size
+// bytes, the trailer of the idx, pack and commit-graph files, which is kept
+// aside to be compared with the sum.
+type trailerHasher struct {
+	h       hash.Hash
+	trailer []byte
+}
+
+func newTrailerHasher() *trailerHasher {
+	return &trailerHasher{h: sha1.New()}
+}
+
+func (t *trailerHasher) Write(p []byte) (int, error) {
+	t.trailer = append(t.trailer, p...)
+	if n := len(t.trailer) - sha1.Size; n > 0 {
+		t.h.Write(t.trailer[:n])
+		t.trailer = append(t.trailer[:0], t.trailer[n:]...)
+	}
+
+	return len(p), nil
+}
+
+// Sum returns the hash of everything but the trailer.
+func (t *trailerHasher) Sum() []byte {
+	return t.h.Sum(nil)
+}
+
+// checkObject verifies the hash of an object and the objects it references.
+func (f *fsck) checkObject(h plumbing.Hash) error {
+	if _, ok := f.broken[h]; ok {
+		return nil
+	}
+
+	obj, err := f.r.Storer.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		f.report(ErrFsckMalformedObject, h, "", err.Error())
+		return nil
+	}
+
+	if _, ok := f.verified[h]; !ok && !f.o.ConnectivityOnly {
+		if err := f.verifyHash(obj); err != nil {
+			return nil
+		}
+	}
+
+	decoded, err := object.DecodeObject(f.r.Storer, obj)
+	if err != nil {
+		if err == plumbing.ErrInvalidType {
+			return nil
+		}
+
+		f.report(ErrFsckMalformedObject, h, "", err.Error())
+		return nil
+	}
+
+	switch o := decoded.(type) {
+	case *object.Commit:
+		f.checkExists(h, o.TreeHash, "tree")
+		if _, ok := f.shallow[h]; ok {
+			break
+		}
+
+		for _, p := range o.ParentHashes {
+			f.checkExists(h, p, "parent")
+		}
+	case *object.Tree:
+		f.checkTree(o)
+	case *object.Tag:
+		f.checkExists(h, o.Target, "target")
+	}
+
+	return nil
+}
+
+// verifyHash reports and returns an error if the content of obj can't be
+// read or doesn't match its hash.
+func (f *fsck) verifyHash(obj plumbing.EncodedObject) (err error) {
+	defer func() {
+		if err != nil {
+			f.broken[obj.Hash()] = struct{}{}
+		}
+	}()
+
+	r, err := obj.Reader()
+	if err != nil {
+		f.report(ErrFsckMalformedObject, obj.Hash(), "", err.Error())
+		return err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	hasher := plumbing.NewHasher(obj.Type(), obj.Size())
+	if _, err := io.Copy(hasher, r); err != nil {
+		f.report(ErrFsckZlib, obj.Hash(), "", err.Error())
+		return err
+	}
+
+	if actual := hasher.Sum(); actual != obj.Hash() {
+		f.report(ErrFsckHashMismatch, obj.Hash(), "", "content hashes to "+actual.String())
+		return ErrFsckHashMismatch
+	}
+
+	return nil
+}
+
+func (f *fsck) checkExists(from, h plumbing.Hash, what string) {
+	if _, ok := f.objects[h]; !ok {
+		f.report(ErrFsckMissingObject, h, "", fmt.Sprintf("%s of %s", what, from))
+	}
+}
+
+func (f *fsck) checkTree(t *object.Tree) {
+	names := make(map[string]struct{}, len(t.Entries))
+	for _, e := range t.Entries {
+		if _, ok := names[e.Name]; ok {
+			f.report(ErrFsckDuplicateTreeEntry, t.Hash, e.Name, "")
+		}
+
+		names[e.Name] = struct{}{}
+
+		switch {
+		case strings.EqualFold(e.Name, ".git"):
+			f.report(ErrFsckDotGitTreeEntry, t.Hash, e.Name, "")
+		case e.Name == "", e.Name == ".", e.Name == "..", strings.Contains(e.Name, "/"):
+			f.report(ErrFsckBadTreeEntryName, t.Hash, e.Name, "")
+		}
+
+		if e.Mode.IsMalformed() || (f.o.Strict && e.Mode == filemode.Deprecated) {
+			f.report(ErrFsckBadTreeMode, t.Hash, e.Name, e.Mode.String())
+			continue
+		}
+
+		if e.Mode != filemode.Submodule {
+			f.checkExists(t.Hash, e.Hash, fmt.Sprintf("entry %q", e.Name))
+		}
+	}
+}
+
+// checkCommitGraph verifies the checksum of the commit-graph file and that
+// its data matches the commits.
+func (f *fsck) checkCommitGraph(fs billy.Filesystem) (err error) {
+	file, err := fs.Open(commitGraphPath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			f.report(ErrFsckBadCommitGraph, plumbing.ZeroHash, commitGraphPath, err.Error())
+		}
+
+		return nil
+	}
+
+	defer ioutil.CheckClose(file, &err)
+
+	sum := newTrailerHasher()
+	if _, err := io.Copy(sum, file); err != nil {
+		f.report(ErrFsckBadCommitGraph, plumbing.ZeroHash, commitGraphPath, err.Error())
+		return nil
+	}
+
+	if len(sum.trailer) < sha1.Size {
+		f.report(ErrFsckBadCommitGraph, plumbing.ZeroHash, commitGraphPath, "file too short")
+		return nil
+	}
+
+	if !bytes.Equal(sum.Sum(), sum.trailer) {
+		f.report(ErrFsckBadCommitGraph, plumbing.ZeroHash, commitGraphPath, "checksum mismatch")
+		return nil
+	}
+
+	idx, err := commitgraph.OpenFileIndex(file)
+	if err != nil {
+		f.report(ErrFsckBadCommitGraph, plumbing.ZeroHash, commitGraphPath, err.Error())
+		return nil
+	}
+
+	for i, h := range idx.Hashes() {
+		data, err := idx.GetCommitDataByIndex(i)
+		if err != nil {
+			f.report(ErrFsckBadCommitGraph, h, commitGraphPath, err.Error())
+			continue
+		}
+
+		if _, ok := f.broken[h]; ok {
+			continue
+		}
+
+		commit, err := f.r.CommitObject(h)
+		if err != nil {
+			f.report(ErrFsckBadCommitGraph, h, commitGraphPath, err.Error())
+			continue
+		}
+
+		if data.TreeHash != commit.TreeHash || !equalHashes(data.ParentHashes, commit.ParentHashes) {
+			f.report(ErrFsckBadCommitGraph, h, commitGraphPath, "commit data doesn't match the commit")
+		}
+	}
+
+	return nil
+}
+
+func equalHashes(a, b []plumbing.Hash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkReferences reports the references pointing to missing objects.
+func (f *fsck) checkReferences() error {
+	iter, err := f.r.Storer.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	return iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		if _, ok := f.objects[ref.Hash()]; !ok {
+			f.errs = append(f.errs, &FsckError{Err: ErrFsckDanglingRef, Hash: ref.Hash(), Reference: ref.Name()})
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,316 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type FsckSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&FsckSuite{})
+
+func (s *FsckSuite) open(c *C, f *fixtures.Fixture) (*Repository, billy.Filesystem) {
+	fs := f.DotGit()
+	if f.Is("commit-graph") {
+		// The objects of this fixture are stored in a separate packfile.
+		copyFixturePack(c, f, fs)
+	}
+
+	r, err := Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
+	c.Assert(err, IsNil)
+	return r, fs
+}
+
+func copyFixturePack(c *C, f *fixtures.Fixture, fs billy.Filesystem) {
+	for ext, file := range map[string]billy.File{"pack": f.Packfile(), "idx": f.Idx()} {
+		b, err := ioutil.ReadAll(file)
+		c.Assert(err, IsNil)
+		c.Assert(file.Close(), IsNil)
+
+		path := fmt.Sprintf("objects/pack/pack-%s.%s", f.PackfileHash, ext)
+		c.Assert(util.WriteFile(fs, path, b, 0644), IsNil)
+	}
+}
+
+func (s *FsckSuite) fsck(c *C, r *Repository, o FsckOptions) []*FsckError {
+	errs, err := r.Fsck(o)
+	c.Assert(err, IsNil)
+	return errs
+}
+
+func countFsckErrors(errs []*FsckError, target error) int {
+	n := 0
+	for _, err := range errs {
+		if errors.Is(err, target) {
+			n++
+		}
+	}
+
+	return n
+}
+
+func corruptFile(c *C, fs billy.Filesystem, path string, offset int) {
+	b, err := util.ReadFile(fs, path)
+	c.Assert(err, IsNil)
+	if offset < 0 {
+		offset += len(b)
+	}
+
+	b[offset] ^= 0xff
+	c.Assert(util.WriteFile(fs, path, b, 0644), IsNil)
+}
+
+func (s *FsckSuite) TestFsck(c *C) {
+	for _, f := range []*fixtures.Fixture{
+		fixtures.Basic().ByTag(".git").One(),
+		fixtures.ByTag("unpacked").One(),
+		fixtures.ByTag("commit-graph").One(),
+	} {
+		r, _ := s.open(c, f)
+		c.Assert(s.fsck(c, r, FsckOptions{}), HasLen, 0)
+	}
+}
+
+func (s *FsckSuite) TestFsckMemory(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	missing := plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	c.Assert(r.Storer.SetReference(plumbing.NewHashReference(plumbing.Master, missing)), IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrFsckDanglingRef), Equals, true)
+	c.Assert(errs[0].Reference, Equals, plumbing.Master)
+}
+
+func (s *FsckSuite) TestFsckLooseHashMismatch(c *C) {
+	r, fs := s.open(c, fixtures.ByTag("unpacked").One())
+
+	blob := r.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	c.Assert(err, IsNil)
+	_, err = w.Write([]byte("foo"))
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	h, err := r.Storer.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	// Move the object to the path of another hash.
+	other := plumbing.NewHash("1111111111111111111111111111111111111111")
+	from := fmt.Sprintf("objects/%s/%s", h.String()[:2], h.String()[2:])
+	to := fmt.Sprintf("objects/%s/%s", other.String()[:2], other.String()[2:])
+	c.Assert(fs.MkdirAll("objects/11", 0755), IsNil)
+	c.Assert(fs.Rename(from, to), IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrFsckHashMismatch), Equals, true)
+	c.Assert(errs[0].Hash, Equals, other)
+
+	c.Assert(s.fsck(c, r, FsckOptions{ConnectivityOnly: true}), HasLen, 0)
+}
+
+func (s *FsckSuite) TestFsckLooseZlib(c *C) {
+	r, fs := s.open(c, fixtures.ByTag("unpacked").One())
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	hex := head.Hash().String()
+	c.Assert(util.WriteFile(fs, fmt.Sprintf("objects/%s/%s", hex[:2], hex[2:]), []byte("garbage"), 0644), IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(countFsckErrors(errs, ErrFsckZlib), Equals, 1)
+	c.Assert(errs[0].Hash, Equals, head.Hash())
+}
+
+func (s *FsckSuite) TestFsckMissingObject(c *C) {
+	r, _ := s.open(c, fixtures.ByTag("unpacked").One())
+
+	missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+	tree := &object.Tree{Entries: []object.TreeEntry{
+		{Name: "foo", Mode: filemode.Regular, Hash: missing},
+	}}
+
+	obj := r.Storer.NewEncodedObject()
+	c.Assert(tree.Encode(obj), IsNil)
+	th, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrFsckMissingObject), Equals, true)
+	c.Assert(errs[0].Hash, Equals, missing)
+	c.Assert(errs[0].Error(), Equals, "missing object "+missing.String()+`: entry "foo" of `+th.String())
+}
+
+func (s *FsckSuite) TestFsckShallow(c *C) {
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:          s.GetBasicLocalRepositoryURL(),
+		Depth:        1,
+		SingleBranch: true,
+	})
+	c.Assert(err, IsNil)
+
+	shallow, err := r.Storer.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, HasLen, 1)
+
+	c.Assert(s.fsck(c, r, FsckOptions{}), HasLen, 0)
+
+	// the parents of the other commits are still checked
+	c.Assert(r.Storer.SetShallow(nil), IsNil)
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(countFsckErrors(errs, ErrFsckMissingObject), Equals, 1)
+	c.Assert(errs[0].Details, Equals, "parent of "+shallow[0].String())
+}
+
+func (s *FsckSuite) TestFsckMalformedTree(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	blob := r.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	bh, err := r.Storer.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	tree := &object.Tree{Entries: []object.TreeEntry{
+		{Name: ".GIT", Mode: filemode.Regular, Hash: bh},
+		{Name: "bar", Mode: filemode.FileMode(0100666), Hash: bh},
+		{Name: "baz", Mode: filemode.Deprecated, Hash: bh},
+		{Name: "foo", Mode: filemode.Regular, Hash: bh},
+		{Name: "foo", Mode: filemode.Regular, Hash: bh},
+		{Name: "sub", Mode: filemode.Submodule, Hash: plumbing.NewHash("1111111111111111111111111111111111111111")},
+	}}
+
+	obj := r.Storer.NewEncodedObject()
+	c.Assert(tree.Encode(obj), IsNil)
+	th, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(errs, HasLen, 3)
+	for _, err := range errs {
+		c.Assert(err.Hash, Equals, th)
+	}
+
+	c.Assert(errors.Is(errs[0], ErrFsckDotGitTreeEntry), Equals, true)
+	c.Assert(errors.Is(errs[1], ErrFsckBadTreeMode), Equals, true)
+	c.Assert(errs[1].Path, Equals, "bar")
+	c.Assert(errors.Is(errs[2], ErrFsckDuplicateTreeEntry), Equals, true)
+	c.Assert(errs[2].Path, Equals, "foo")
+
+	errs = s.fsck(c, r, FsckOptions{Strict: true})
+	c.Assert(countFsckErrors(errs, ErrFsckBadTreeMode), Equals, 2)
+}
+
+func (s *FsckSuite) TestFsckPackChecksum(c *C) {
+	f := fixtures.Basic().ByTag(".git").One()
+	r, fs := s.open(c, f)
+
+	path := fmt.Sprintf("objects/pack/pack-%s.pack", f.PackfileHash)
+	corruptFile(c, fs, path, -1)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(countFsckErrors(errs, ErrFsckBadPackChecksum), Equals, 1)
+	c.Assert(countFsckErrors(errs, ErrFsckBadIdxChecksum), Equals, 1)
+}
+
+func (s *FsckSuite) TestFsckPackObject(c *C) {
+	f := fixtures.Basic().ByTag(".git").One()
+	r, fs := s.open(c, f)
+
+	// Corrupt the zlib stream of the first object.
+	path := fmt.Sprintf("objects/pack/pack-%s.pack", f.PackfileHash)
+	corruptFile(c, fs, path, 20)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(countFsckErrors(errs, ErrFsckBadPackChecksum), Equals, 1)
+	c.Assert(countFsckErrors(errs, ErrFsckZlib) > 0, Equals, true)
+}
+
+func (s *FsckSuite) TestFsckIdxChecksum(c *C) {
+	f := fixtures.Basic().ByTag(".git").One()
+	r, fs := s.open(c, f)
+
+	path := fmt.Sprintf("objects/pack/pack-%s.idx", f.PackfileHash)
+	corruptFile(c, fs, path, -1)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrFsckBadIdxChecksum), Equals, true)
+	c.Assert(errs[0].Hash, Equals, plumbing.NewHash(f.PackfileHash))
+}
+
+func (s *FsckSuite) TestFsckMissingIdx(c *C) {
+	f := fixtures.Basic().ByTag(".git").One()
+	r, fs := s.open(c, f)
+
+	path := fmt.Sprintf("objects/pack/pack-%s.idx", f.PackfileHash)
+	c.Assert(fs.Remove(path), IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(countFsckErrors(errs, ErrFsckBadIdxChecksum), Equals, 1)
+	c.Assert(errs[0].Path, Equals, path)
+
+	// the check goes on with the references, whose objects are now missing
+	c.Assert(countFsckErrors(errs, ErrFsckDanglingRef) > 0, Equals, true)
+}
+
+func (s *FsckSuite) TestFsckUnreadableLooseObject(c *C) {
+	r, fs := s.open(c, fixtures.ByTag("unpacked").One())
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	hex := head.Hash().String()
+	path := fmt.Sprintf("objects/%s/%s", hex[:2], hex[2:])
+	c.Assert(fs.Remove(path), IsNil)
+	c.Assert(fs.Symlink("missing", path), IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(countFsckErrors(errs, ErrFsckMalformedObject), Equals, 1)
+	c.Assert(errs[0].Hash, Equals, head.Hash())
+	c.Assert(errs[0].Path, Equals, path)
+}
+
+func (s *FsckSuite) TestFsckCommitGraph(c *C) {
+	r, fs := s.open(c, fixtures.ByTag("commit-graph").One())
+
+	corruptFile(c, fs, commitGraphPath, -1)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrFsckBadCommitGraph), Equals, true)
+
+	c.Assert(s.fsck(c, r, FsckOptions{ConnectivityOnly: true}), HasLen, 0)
+}
+
+func (s *FsckSuite) TestFsckDanglingRef(c *C) {
+	r, _ := s.open(c, fixtures.Basic().ByTag(".git").One())
+
+	missing := plumbing.NewHash("1111111111111111111111111111111111111111")
+	ref := plumbing.NewHashReference("refs/heads/broken", missing)
+	c.Assert(r.Storer.SetReference(ref), IsNil)
+
+	errs := s.fsck(c, r, FsckOptions{})
+	c.Assert(errs, HasLen, 1)
+	c.Assert(errors.Is(errs[0], ErrFsckDanglingRef), Equals, true)
+	c.Assert(errs[0].Reference, Equals, ref.Name())
+	c.Assert(errs[0].Error(), Equals, "dangling reference "+missing.String()+" refs/heads/broken")
+}
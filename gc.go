@@ -0,0 +1,579 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/reftable"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const (
+	// DefaultGCAuto is the number of loose objects above which GC runs when
+	// GCOptions.Auto is set, unless overridden by gc.auto.
+	DefaultGCAuto = 6700
+	// DefaultGCAutoPackLimit is the number of packs above which GC runs when
+	// GCOptions.Auto is set, unless overridden by gc.autoPackLimit.
+	DefaultGCAutoPackLimit = 50
+	// DefaultGCPruneExpire is the age of the unreachable objects pruned by
+	// GC, unless overridden by gc.pruneExpire.
+	DefaultGCPruneExpire = 14 * 24 * time.Hour
+
+	gcSection          = "gc"
+	gcAutoKey          = "auto"
+	gcAutoPackLimitKey = "autoPackLimit"
+	gcPruneExpireKey   = "pruneExpire"
+	worktreesDir       = "worktrees"
+)
+
+// ErrInvalidPruneExpire is returned by GC when gc.pruneExpire can't be parsed.
+var ErrInvalidPruneExpire = errors.New("invalid gc.pruneExpire value")
+
+// GCOptions describes how a garbage collection should be performed.
+type GCOptions struct {
+	// Auto only runs the collection if the repository has more loose objects
+	// than gc.auto or more packs than gc.autoPackLimit.
+	Auto bool
+	// PruneExpire is the time before which unreachable objects are pruned.
+	// If zero, gc.pruneExpire is used, which defaults to two weeks ago.
+	PruneExpire time.Time
+	// NoPrune keeps all the unreachable objects.
+	NoPrune bool
+	// UseRefDeltas configures whether the packfile encoder will use
+	// reference deltas. By default OFSDeltaObject is used.
+	UseRefDeltas bool
+}
+
+// refLogStorer is implemented by the storers giving access to reflogs.
+type refLogStorer interface {
+	RefLog(plumbing.ReferenceName) ([]*reftable.LogRecord, error)
+}
+
+// GC cleans up the repository: every reachable object, and the unreachable
+// objects too recent to be pruned, are written to a single new pack, while
+// loose objects and the previous packs are deleted. Packs with a ".keep"
+// file are left untouched. References are packed as well.
+//
+// Objects are reachable from the references, their reflogs, the index and
+// the HEAD and index of linked worktrees. The parents of the shallow commits
+// are not walked, as they are missing from a shallow repository.
+func (r *Repository) GC(o GCOptions) error {
+	pos, ok := r.Storer.(storer.PackedObjectStorer)
+	if !ok {
+		return ErrPackedObjectsNotSupported
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return err
+	}
+
+	if o.Auto {
+		needed, err := r.gcNeeded(cfg, pos)
+		if err != nil || !needed {
+			return err
+		}
+	}
+
+	prune := !o.NoPrune
+	expire := o.PruneExpire
+	if prune && expire.IsZero() {
+		expire, prune, err = gcPruneExpire(cfg, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	ow := newObjectWalker(r.Storer)
+	if err := ow.skipShallowParents(); err != nil {
+		return err
+	}
+
+	if err := r.walkGCRoots(ow); err != nil {
+		return err
+	}
+
+	packs, err := pos.ObjectPacks()
+	if err != nil {
+		return err
+	}
+
+	pi, _ := r.Storer.(storer.PackedObjectInspector)
+	kept := make(map[plumbing.Hash]bool)
+	keptObjects := make(map[plumbing.Hash]struct{})
+	pack := make(map[plumbing.Hash]struct{}, len(ow.seen))
+	for h := range ow.seen {
+		pack[h] = struct{}{}
+	}
+
+	if pi != nil {
+		for _, p := range packs {
+			if err := r.gcInspectPack(pi, p, ow, prune, expire, kept, keptObjects, pack); err != nil {
+				return err
+			}
+		}
+	}
+
+	objs := make([]plumbing.Hash, 0, len(pack))
+	for h := range pack {
+		if _, ok := keptObjects[h]; !ok {
+			objs = append(objs, h)
+		}
+	}
+
+	var nh plumbing.Hash
+	if len(objs) > 0 {
+		nh, err = r.writeGCPack(cfg, objs, o.UseRefDeltas)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := r.gcLooseObjects(ow, pack, keptObjects, prune, expire); err != nil {
+		return err
+	}
+
+	for _, h := range packs {
+		if h == nh || kept[h] {
+			continue
+		}
+
+		if err := pos.DeleteOldObjectPackAndIndex(h, time.Time{}); err != nil {
+			return err
+		}
+	}
+
+	return r.Storer.PackRefs()
+}
+
+// gcNeeded returns true if the repository has more loose objects or packs
+// than allowed by the gc.auto and gc.autoPackLimit thresholds.
+func (r *Repository) gcNeeded(cfg *config.Config, pos storer.PackedObjectStorer) (bool, error) {
+	s := cfg.Raw.Section(gcSection)
+	auto, err := gcIntOption(s, gcAutoKey, DefaultGCAuto)
+	if err != nil || auto <= 0 {
+		return false, err
+	}
+
+	packLimit, err := gcIntOption(s, gcAutoPackLimitKey, DefaultGCAutoPackLimit)
+	if err != nil {
+		return false, err
+	}
+
+	if los, ok := r.Storer.(storer.LooseObjectStorer); ok {
+		count := 0
+		err := los.ForEachObjectHash(func(plumbing.Hash) error {
+			count++
+			if count > auto {
+				return storer.ErrStop
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return false, err
+		}
+
+		if count > auto {
+			return true, nil
+		}
+	}
+
+	if packLimit <= 0 {
+		return false, nil
+	}
+
+	packs, err := pos.ObjectPacks()
+	if err != nil {
+		return false, err
+	}
+
+	count := len(packs)
+	if pi, ok := r.Storer.(storer.PackedObjectInspector); ok {
+		for _, p := range packs {
+			kept, err := pi.IsObjectPackKept(p)
+			if err != nil {
+				return false, err
+			}
+
+			if kept {
+				count--
+			}
+		}
+	}
+
+	return count > packLimit, nil
+}
+
+func gcIntOption(s *format.Section, key string, def int) (int, error) {
+	v := s.Options.Get(key)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid gc.%s value: %q", key, v)
+	}
+
+	return n, nil
+}
+
+// gcPruneExpire returns the time before which unreachable objects can be
+// pruned according to gc.pruneExpire, and whether they can be pruned at all.
+func gcPruneExpire(cfg *config.Config, now time.Time) (time.Time, bool, error) {
+	v := cfg.Raw.Section(gcSection).Options.Get(gcPruneExpireKey)
+	if v == "" {
+		return now.Add(-DefaultGCPruneExpire), true, nil
+	}
+
+	return parsePruneExpire(v, now)
+}
+
+// parsePruneExpire parses the values of gc.pruneExpire supported: "now",
+// "never", relative dates such as "2.weeks.ago" and absolute dates.
+func parsePruneExpire(v string, now time.Time) (time.Time, bool, error) {
+	switch v {
+	case "never":
+		return time.Time{}, false, nil
+	case "now":
+		return now, true, nil
+	}
+
+	fields := strings.Fields(strings.Replace(v, ".", " ", -1))
+	if len(fields) == 3 && fields[2] == "ago" {
+		n, err := strconv.Atoi(fields[0])
+		if err != nil || n < 0 {
+			return time.Time{}, false, fmt.Errorf("%w: %q", ErrInvalidPruneExpire, v)
+		}
+
+		switch strings.TrimSuffix(fields[1], "s") {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), true, nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), true, nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), true, nil
+		case "day":
+			return now.AddDate(0, 0, -n), true, nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), true, nil
+		case "month":
+			return now.AddDate(0, -n, 0), true, nil
+		case "year":
+			return now.AddDate(-n, 0, 0), true, nil
+		}
+
+		return time.Time{}, false, fmt.Errorf("%w: %q", ErrInvalidPruneExpire, v)
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, v, time.Local); err == nil {
+			return t, true, nil
+		}
+	}
+
+	return time.Time{}, false, fmt.Errorf("%w: %q", ErrInvalidPruneExpire, v)
+}
+
+// walkGCRoots walks all the objects reachable from the references, their
+// reflogs, the index and the linked worktrees.
+func (r *Repository) walkGCRoots(ow *objectWalker) error {
+	if err := ow.walkAllRefs(); err != nil {
+		return err
+	}
+
+	if err := r.walkRefLogs(ow); err != nil {
+		return err
+	}
+
+	idx, err := r.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	r.walkIndex(ow, idx)
+
+	fs, ok := r.Storer.(interface{ Filesystem() billy.Filesystem })
+	if !ok {
+		return nil
+	}
+
+	return r.walkWorktrees(ow, fs.Filesystem())
+}
+
+// walkRoot walks the objects reachable from h, ignoring missing roots such
+// as the old values of expired reflog entries.
+func (r *Repository) walkRoot(ow *objectWalker, h plumbing.Hash) error {
+	if h.IsZero() || ow.isSeen(h) {
+		return nil
+	}
+
+	if err := r.Storer.HasEncodedObject(h); err != nil {
+		if err == plumbing.ErrObjectNotFound {
+			return nil
+		}
+
+		return err
+	}
+
+	return ow.walkObjectTree(h)
+}
+
+func (r *Repository) walkRefLogs(ow *objectWalker) error {
+	rls, ok := r.Storer.(refLogStorer)
+	if !ok {
+		return nil
+	}
+
+	names := []plumbing.ReferenceName{plumbing.HEAD}
+	iter, err := r.Storer.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != plumbing.HEAD {
+			names = append(names, ref.Name())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		logs, err := rls.RefLog(name)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range logs {
+			if err := r.walkRoot(ow, l.Old); err != nil {
+				return err
+			}
+
+			if err := r.walkRoot(ow, l.New); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (r *Repository) walkIndex(ow *objectWalker, idx *index.Index) {
+	for _, e := range idx.Entries {
+		if e.Mode == filemode.Submodule {
+			continue
+		}
+
+		if r.Storer.HasEncodedObject(e.Hash) == nil {
+			ow.add(e.Hash)
+		}
+	}
+}
+
+// walkWorktrees walks the objects reachable from the HEAD and the index of
+// every linked worktree.
+func (r *Repository) walkWorktrees(ow *objectWalker, fs billy.Filesystem) error {
+	fis, err := fs.ReadDir(worktreesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+
+		head, err := readWorktreeFile(fs, fs.Join(worktreesDir, fi.Name(), "HEAD"))
+		if err != nil {
+			return err
+		}
+
+		// Symbolic HEADs point to references already walked.
+		head = strings.TrimSpace(head)
+		if head != "" && !strings.HasPrefix(head, "ref: ") {
+			if err := r.walkRoot(ow, plumbing.NewHash(head)); err != nil {
+				return err
+			}
+		}
+
+		if err := r.walkWorktreeIndex(ow, fs, fs.Join(worktreesDir, fi.Name(), "index")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readWorktreeFile(fs billy.Filesystem, path string) (content string, err error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	b := make([]byte, 256)
+	n, err := f.Read(b)
+	if err != nil && n == 0 {
+		return "", nil
+	}
+
+	return string(b[:n]), nil
+}
+
+func (r *Repository) walkWorktreeIndex(ow *objectWalker, fs billy.Filesystem, path string) (err error) {
+	f, err := fs.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	idx := &index.Index{}
+	if err := index.NewDecoder(f).Decode(idx); err != nil {
+		return err
+	}
+
+	r.walkIndex(ow, idx)
+	return nil
+}
+
+// gcInspectPack records whether pack p is kept, along with its objects, and
+// adds its unreachable objects to the new pack when they are too recent to
+// be pruned.
+func (r *Repository) gcInspectPack(
+	pi storer.PackedObjectInspector, p plumbing.Hash, ow *objectWalker, prune bool,
+	expire time.Time, kept map[plumbing.Hash]bool, keptObjects, pack map[plumbing.Hash]struct{},
+) error {
+	isKept, err := pi.IsObjectPackKept(p)
+	if err != nil {
+		return err
+	}
+
+	hashes, err := pi.ObjectPackHashes(p)
+	if err != nil {
+		return err
+	}
+
+	if isKept {
+		kept[p] = true
+		for _, h := range hashes {
+			keptObjects[h] = struct{}{}
+		}
+
+		return nil
+	}
+
+	if prune {
+		t, err := pi.ObjectPackTime(p)
+		if err != nil {
+			return err
+		}
+
+		if t.Before(expire) {
+			return nil
+		}
+	}
+
+	for _, h := range hashes {
+		if !ow.isSeen(h) {
+			pack[h] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// writeGCPack writes the given objects to a new pack.
+func (r *Repository) writeGCPack(cfg *config.Config, objs []plumbing.Hash, useRefDeltas bool) (h plumbing.Hash, err error) {
+	pfw, ok := r.Storer.(storer.PackfileWriter)
+	if !ok {
+		return h, fmt.Errorf("Repository storer is not a storer.PackfileWriter")
+	}
+
+	wc, err := pfw.PackfileWriter()
+	if err != nil {
+		return h, err
+	}
+
+	defer ioutil.CheckClose(wc, &err)
+	enc := packfile.NewEncoder(wc, r.Storer, useRefDeltas)
+	return enc.Encode(objs, cfg.Pack.Window)
+}
+
+// gcLooseObjects deletes the loose objects which were packed, and the
+// unreachable ones older than expire if prune is set.
+func (r *Repository) gcLooseObjects(
+	ow *objectWalker, pack, keptObjects map[plumbing.Hash]struct{}, prune bool, expire time.Time,
+) error {
+	los, ok := r.Storer.(storer.LooseObjectStorer)
+	if !ok {
+		return nil
+	}
+
+	var remove []plumbing.Hash
+	err := los.ForEachObjectHash(func(h plumbing.Hash) error {
+		_, packed := pack[h]
+		_, inKept := keptObjects[h]
+		if packed || inKept {
+			remove = append(remove, h)
+			return nil
+		}
+
+		if !prune {
+			return nil
+		}
+
+		// Errors here are non-fatal, the object may have been deleted
+		// concurrently.
+		t, err := los.LooseObjectTime(h)
+		if err == nil && t.Before(expire) {
+			remove = append(remove, h)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, h := range remove {
+		if err := los.DeleteLooseObject(h); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
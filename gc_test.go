@@ -0,0 +1,283 @@
+package git
+
+import (
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type GCSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&GCSuite{})
+
+func (s *GCSuite) openUnpacked(c *C) (*Repository, *filesystem.Storage, billy.Filesystem) {
+	fs := fixtures.ByTag("unpacked").One().DotGit()
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	r, err := Open(sto, nil)
+	c.Assert(err, IsNil)
+	return r, sto, fs
+}
+
+// addUnreachableCommit writes a new loose commit not reachable from any
+// reference.
+func (s *GCSuite) addUnreachableCommit(c *C, r *Repository) plumbing.Hash {
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	parent, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+
+	sig := object.Signature{Name: "foo", Email: "foo@foo.foo", When: time.Now()}
+	commit := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      "unreachable",
+		TreeHash:     parent.TreeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	}
+
+	obj := r.Storer.NewEncodedObject()
+	c.Assert(commit.Encode(obj), IsNil)
+	h, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	return h
+}
+
+func countLooseObjects(c *C, los storer.LooseObjectStorer) int {
+	count := 0
+	err := los.ForEachObjectHash(func(plumbing.Hash) error {
+		count++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	return count
+}
+
+func (s *GCSuite) assertReachable(c *C, r *Repository) {
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+
+	iter, err := r.Log(&LogOptions{From: head.Hash()})
+	c.Assert(err, IsNil)
+	err = iter.ForEach(func(commit *object.Commit) error {
+		files, err := commit.Files()
+		c.Assert(err, IsNil)
+		return files.ForEach(func(*object.File) error { return nil })
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *GCSuite) TestGC(c *C) {
+	r, sto, fs := s.openUnpacked(c)
+	unreachable := s.addUnreachableCommit(c, r)
+
+	err := r.GC(GCOptions{PruneExpire: time.Now().Add(time.Hour)})
+	c.Assert(err, IsNil)
+
+	c.Assert(countLooseObjects(c, sto), Equals, 0)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+
+	c.Assert(sto.HasEncodedObject(unreachable), Equals, plumbing.ErrObjectNotFound)
+	s.assertReachable(c, r)
+
+	_, err = fs.Stat("packed-refs")
+	c.Assert(err, IsNil)
+	n, err := sto.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *GCSuite) TestGCNoPrune(c *C) {
+	r, sto, _ := s.openUnpacked(c)
+	unreachable := s.addUnreachableCommit(c, r)
+
+	err := r.GC(GCOptions{NoPrune: true})
+	c.Assert(err, IsNil)
+
+	c.Assert(countLooseObjects(c, sto), Equals, 1)
+	c.Assert(sto.HasEncodedObject(unreachable), IsNil)
+	s.assertReachable(c, r)
+}
+
+func (s *GCSuite) TestGCPruneExpireNever(c *C) {
+	r, sto, _ := s.openUnpacked(c)
+	unreachable := s.addUnreachableCommit(c, r)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("gc").SetOption("pruneExpire", "never")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	c.Assert(r.GC(GCOptions{}), IsNil)
+	c.Assert(sto.HasEncodedObject(unreachable), IsNil)
+}
+
+func (s *GCSuite) TestGCPruneExpireDefault(c *C) {
+	r, sto, _ := s.openUnpacked(c)
+	unreachable := s.addUnreachableCommit(c, r)
+
+	// The unreachable commit is too recent to be pruned.
+	c.Assert(r.GC(GCOptions{}), IsNil)
+	c.Assert(sto.HasEncodedObject(unreachable), IsNil)
+}
+
+func (s *GCSuite) TestGCRefLogIsRoot(c *C) {
+	r, sto, fs := s.openUnpacked(c)
+	unreachable := s.addUnreachableCommit(c, r)
+
+	err := util.WriteFile(fs, "logs/HEAD", []byte(
+		"0000000000000000000000000000000000000000 "+unreachable.String()+
+			" John Doe <john@doe.com> 1600000000 +0200\tcheckout: moving to v4\n",
+	), 0644)
+	c.Assert(err, IsNil)
+
+	c.Assert(r.GC(GCOptions{PruneExpire: time.Now().Add(time.Hour)}), IsNil)
+	c.Assert(sto.HasEncodedObject(unreachable), IsNil)
+	c.Assert(countLooseObjects(c, sto), Equals, 0)
+}
+
+func (s *GCSuite) TestGCKeepPack(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	r, err := Open(sto, nil)
+	c.Assert(err, IsNil)
+
+	packs, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(packs, HasLen, 1)
+
+	keep := "objects/pack/pack-" + packs[0].String() + ".keep"
+	c.Assert(util.WriteFile(fs, keep, nil, 0644), IsNil)
+
+	c.Assert(r.GC(GCOptions{PruneExpire: time.Now().Add(time.Hour)}), IsNil)
+
+	after, err := sto.ObjectPacks()
+	c.Assert(err, IsNil)
+	c.Assert(after, DeepEquals, packs)
+	s.assertReachable(c, r)
+}
+
+func (s *GCSuite) TestGCSubmodule(c *C) {
+	r, sto, _ := s.openUnpacked(c)
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	parent, err := r.CommitObject(head.Hash())
+	c.Assert(err, IsNil)
+
+	// The commit of a submodule belongs to another repository, it isn't
+	// stored in this one.
+	tree := &object.Tree{Entries: []object.TreeEntry{{
+		Name: "submodule",
+		Mode: filemode.Submodule,
+		Hash: plumbing.NewHash("1234567890123456789012345678901234567890"),
+	}}}
+	obj := sto.NewEncodedObject()
+	c.Assert(tree.Encode(obj), IsNil)
+	treeHash, err := sto.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	sig := object.Signature{Name: "foo", Email: "foo@foo.foo", When: time.Now()}
+	commit := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      "add submodule",
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{parent.Hash},
+	}
+	obj = sto.NewEncodedObject()
+	c.Assert(commit.Encode(obj), IsNil)
+	h, err := sto.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+	c.Assert(sto.SetReference(plumbing.NewHashReference("refs/heads/submodule", h)), IsNil)
+
+	c.Assert(r.GC(GCOptions{PruneExpire: time.Now().Add(time.Hour)}), IsNil)
+	c.Assert(sto.HasEncodedObject(h), IsNil)
+	c.Assert(sto.HasEncodedObject(treeHash), IsNil)
+}
+
+func (s *GCSuite) TestGCShallow(c *C) {
+	fs, clean := s.TemporalFilesystem()
+	defer clean()
+
+	r, err := PlainClone(fs.Root(), true, &CloneOptions{
+		URL:          s.GetBasicLocalRepositoryURL(),
+		Depth:        1,
+		SingleBranch: true,
+	})
+	c.Assert(err, IsNil)
+
+	shallow, err := r.Storer.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, HasLen, 1)
+
+	err = r.GC(GCOptions{PruneExpire: time.Now().Add(time.Hour)})
+	c.Assert(err, IsNil)
+
+	after, err := r.Storer.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(after, DeepEquals, shallow)
+
+	commit, err := r.CommitObject(shallow[0])
+	c.Assert(err, IsNil)
+	files, err := commit.Files()
+	c.Assert(err, IsNil)
+	c.Assert(files.ForEach(func(*object.File) error { return nil }), IsNil)
+}
+
+func (s *GCSuite) TestGCAuto(c *C) {
+	r, sto, _ := s.openUnpacked(c)
+	count := countLooseObjects(c, sto)
+
+	c.Assert(r.GC(GCOptions{Auto: true}), IsNil)
+	c.Assert(countLooseObjects(c, sto), Equals, count)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("gc").SetOption("auto", "1")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	c.Assert(r.GC(GCOptions{Auto: true, PruneExpire: time.Now().Add(time.Hour)}), IsNil)
+	c.Assert(countLooseObjects(c, sto), Equals, 0)
+}
+
+func (s *GCSuite) TestParsePruneExpire(c *C) {
+	now := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	for v, expected := range map[string]time.Time{
+		"now":            now,
+		"2.weeks.ago":    now.AddDate(0, 0, -14),
+		"1 day ago":      now.AddDate(0, 0, -1),
+		"3.months.ago":   now.AddDate(0, -3, 0),
+		"90.minutes.ago": now.Add(-90 * time.Minute),
+	} {
+		t, prune, err := parsePruneExpire(v, now)
+		c.Assert(err, IsNil)
+		c.Assert(prune, Equals, true)
+		c.Assert(t.Equal(expected), Equals, true, Commentf("%s", v))
+	}
+
+	_, prune, err := parsePruneExpire("never", now)
+	c.Assert(err, IsNil)
+	c.Assert(prune, Equals, false)
+
+	t, _, err := parsePruneExpire("2019-01-02", now)
+	c.Assert(err, IsNil)
+	c.Assert(t.Year(), Equals, 2019)
+
+	_, _, err = parsePruneExpire("2.fortnights.ago", now)
+	c.Assert(err, NotNil)
+}
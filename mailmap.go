@@ -0,0 +1,165 @@
+package git
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/mailmap"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	mailmapFile        = ".mailmap"
+	mailmapSection     = "mailmap"
+	mailmapFileKey     = "file"
+	mailmapBlobKey     = "blob"
+	defaultMailmapBlob = "HEAD:" + mailmapFile
+	mailmapHomePrefix  = "~/"
+)
+
+// Mailmap returns the mailmap of the repository, as git does, reading in
+// order the .mailmap file of the worktree, the blob of the mailmap.blob
+// config, "HEAD:.mailmap" by default in bare repositories, and the file of
+// the mailmap.file config. The missing files and blobs are ignored.
+func (r *Repository) Mailmap() (*mailmap.Mailmap, error) {
+	m := mailmap.New()
+	if r.wt != nil {
+		f, err := r.wt.Open(mailmapFile)
+		if err == nil {
+			err = m.Parse(f)
+			f.Close()
+		}
+
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	blob, err := r.mailmapOption(mailmapBlobKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if blob == "" && r.wt == nil {
+		blob = defaultMailmapBlob
+	}
+
+	if blob != "" {
+		if err := r.readMailmapBlob(m, blob); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := r.mailmapOption(mailmapFileKey)
+	if err != nil || file == "" {
+		return m, err
+	}
+
+	if strings.HasPrefix(file, mailmapHomePrefix) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		file = filepath.Join(home, file[len(mailmapHomePrefix):])
+	}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+	return m, m.Parse(f)
+}
+
+// mailmapOption returns an option of the mailmap section of the local,
+// global or system config, the first one set.
+func (r *Repository) mailmapOption(key string) (string, error) {
+	cfg, err := r.Config()
+	if err != nil {
+		return "", err
+	}
+
+	v := cfg.Raw.Section(mailmapSection).Options.Get(key)
+	for _, scope := range []config.Scope{config.GlobalScope, config.SystemScope} {
+		if v != "" {
+			break
+		}
+
+		if cfg, err = config.LoadConfig(scope); err != nil {
+			return "", err
+		}
+
+		v = cfg.Raw.Section(mailmapSection).Options.Get(key)
+	}
+
+	return v, nil
+}
+
+// readMailmapBlob parses the mailmap of a "<rev>:<path>" or a blob hash,
+// ignoring it if it doesn't exist.
+func (r *Repository) readMailmapBlob(m *mailmap.Mailmap, spec string) error {
+	var blob *object.Blob
+	if i := strings.IndexByte(spec, ':'); i >= 0 {
+		tree, _, err := r.resolveTreeish(plumbing.Revision(spec[:i]))
+		if err != nil {
+			return nil
+		}
+
+		f, err := tree.File(strings.Trim(spec[i+1:], "/"))
+		if err != nil {
+			return nil
+		}
+
+		blob = &f.Blob
+	} else {
+		for _, h := range r.resolveHashPrefix(spec) {
+			if b, err := r.BlobObject(h); err == nil {
+				blob = b
+				break
+			}
+		}
+
+		if blob == nil {
+			return nil
+		}
+	}
+
+	rd, err := blob.Reader()
+	if err != nil {
+		return err
+	}
+
+	defer rd.Close()
+	return m.Parse(rd)
+}
+
+// mailmapCommitIter maps the authors and committers of the commits of an
+// iterator to their canonical identity.
+type mailmapCommitIter struct {
+	object.CommitIter
+	m *mailmap.Mailmap
+}
+
+func (i *mailmapCommitIter) Next() (*object.Commit, error) {
+	c, err := i.CommitIter.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	return i.m.Commit(c), nil
+}
+
+func (i *mailmapCommitIter) ForEach(cb func(*object.Commit) error) error {
+	return i.CommitIter.ForEach(func(c *object.Commit) error {
+		return cb(i.m.Commit(c))
+	})
+}
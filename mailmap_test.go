@@ -0,0 +1,150 @@
+package git
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/mailmap"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type MailmapSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&MailmapSuite{})
+
+const basicMailmap = "Máximo Cuadros Ortiz <mcuadros@gmail.com>\n"
+
+func (s *MailmapSuite) TestMailmapWorktreeAndBare(c *C) {
+	fs := memfs.New()
+	st := memory.NewStorage()
+	r, err := Init(st, fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	util.WriteFile(fs, ".mailmap", []byte("Foo <foo@foo> <bar@bar>\n"), 0644)
+	_, err = w.Add(".mailmap")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("mailmap\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	util.WriteFile(fs, ".mailmap", []byte("Baz <baz@baz> <bar@bar>\n"), 0644)
+
+	m, err := r.Mailmap()
+	c.Assert(err, IsNil)
+	name, email, _ := m.Map("bar", "bar@bar")
+	c.Assert(name, Equals, "Baz")
+	c.Assert(email, Equals, "baz@baz")
+
+	// A bare repository reads HEAD:.mailmap by default.
+	bare, err := Open(st, nil)
+	c.Assert(err, IsNil)
+
+	m, err = bare.Mailmap()
+	c.Assert(err, IsNil)
+	name, email, _ = m.Map("bar", "bar@bar")
+	c.Assert(name, Equals, "Foo")
+	c.Assert(email, Equals, "foo@foo")
+}
+
+func (s *MailmapSuite) TestMailmapEmpty(c *C) {
+	r, err := Init(memory.NewStorage(), nil)
+	c.Assert(err, IsNil)
+
+	m, err := r.Mailmap()
+	c.Assert(err, IsNil)
+	_, _, mapped := m.Map("foo", "foo@foo")
+	c.Assert(mapped, Equals, false)
+}
+
+func (s *MailmapSuite) TestMailmapBlobAndFile(c *C) {
+	r := s.NewRepository(fixtures.Basic().One())
+
+	obj := r.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	wr, err := obj.Writer()
+	c.Assert(err, IsNil)
+	_, err = wr.Write([]byte("Foo <foo@foo> <bar@bar>\n<qux@qux> <baz@baz>\n"))
+	c.Assert(err, IsNil)
+	c.Assert(wr.Close(), IsNil)
+	h, err := r.Storer.SetEncodedObject(obj)
+	c.Assert(err, IsNil)
+
+	dir, clean := s.TemporalDir()
+	defer clean()
+	file := filepath.Join(dir, "mailmap")
+	c.Assert(ioutil.WriteFile(file, []byte("Baz <baz@baz>\n"), 0644), IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("mailmap").
+		SetOption("blob", h.String()[:10]).
+		SetOption("file", file)
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	m, err := r.Mailmap()
+	c.Assert(err, IsNil)
+
+	name, email, _ := m.Map("bar", "bar@bar")
+	c.Assert(name, Equals, "Foo")
+	c.Assert(email, Equals, "foo@foo")
+
+	name, email, _ = m.Map("x", "baz@baz")
+	c.Assert(name, Equals, "Baz")
+	c.Assert(email, Equals, "qux@qux")
+}
+
+func (s *MailmapSuite) TestLogMailmap(c *C) {
+	m, err := mailmap.Read(strings.NewReader(basicMailmap))
+	c.Assert(err, IsNil)
+
+	it, err := s.Repository.Log(&LogOptions{Mailmap: m})
+	c.Assert(err, IsNil)
+
+	names := map[string]int{}
+	c.Assert(it.ForEach(func(commit *object.Commit) error {
+		names[commit.Author.Name]++
+		names[commit.Committer.Name]++
+		return nil
+	}), IsNil)
+
+	c.Assert(names, DeepEquals, map[string]int{
+		"Máximo Cuadros Ortiz": 14,
+		"Daniel Ripolles":      2,
+	})
+}
+
+func (s *MailmapSuite) TestBlameMailmap(c *C) {
+	m := mailmap.New()
+	m.Add("Daniel", "daniel@example.com", "", "daniel@lordran.local")
+
+	commit, err := s.Repository.CommitObject(plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+
+	var entries []*BlameEntry
+	result, err := BlameWithOptions(commit, "CHANGELOG", &BlameOptions{
+		Mailmap: m,
+		Incremental: func(e *BlameEntry) error {
+			entries = append(entries, e)
+			return nil
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(result.Lines, HasLen, 1)
+	c.Assert(result.Lines[0].Author, Equals, "daniel@example.com")
+	c.Assert(result.Lines[0].AuthorName, Equals, "Daniel")
+	c.Assert(entries, HasLen, 1)
+	c.Assert(entries[0].Commit.Author.Name, Equals, "Daniel")
+	c.Assert(entries[0].Commit.Hash, Equals, result.Lines[0].Hash)
+}
@@ -15,10 +15,29 @@ type objectWalker struct {
 	// seen map can become huge if walking over large
 	// repos. Thus using struct{} as the value type.
 	seen map[plumbing.Hash]struct{}
+	// shallow is the set of the commits whose parents are not walked, the
+	// boundary of a shallow repository.
+	shallow map[plumbing.Hash]struct{}
 }
 
 func newObjectWalker(s storage.Storer) *objectWalker {
-	return &objectWalker{s, map[plumbing.Hash]struct{}{}}
+	return &objectWalker{Storer: s, seen: map[plumbing.Hash]struct{}{}}
+}
+
+// skipShallowParents makes the walk stop at the shallow commits of the
+// repository, since their parents are missing from it.
+func (p *objectWalker) skipShallowParents() error {
+	shallow, err := p.Storer.Shallow()
+	if err != nil {
+		return err
+	}
+
+	p.shallow = make(map[plumbing.Hash]struct{}, len(shallow))
+	for _, h := range shallow {
+		p.shallow[h] = struct{}{}
+	}
+
+	return nil
 }
 
 // walkAllRefs walks all (hash) references from the repo.
@@ -69,6 +88,9 @@ func (p *objectWalker) walkObjectTree(hash plumbing.Hash) error {
 		if err != nil {
 			return err
 		}
+		if _, ok := p.shallow[hash]; ok {
+			return nil
+		}
 		for _, h := range obj.ParentHashes {
 			err = p.walkObjectTree(h)
 			if err != nil {
@@ -88,6 +110,10 @@ func (p *objectWalker) walkObjectTree(hash plumbing.Hash) error {
 				p.add(obj.Entries[i].Hash)
 				continue
 			}
+			// Submodule commits belong to other repositories.
+			if obj.Entries[i].Mode == filemode.Submodule {
+				continue
+			}
 			// Normal walk for sub-trees (and symlinks etc).
 			err = p.walkObjectTree(obj.Entries[i].Hash)
 			if err != nil {
@@ -10,6 +10,8 @@ import (
 	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/archive"
+	"github.com/go-git/go-git/v5/plumbing/format/mailmap"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/transport"
@@ -30,7 +32,9 @@ const (
 )
 
 var (
-	ErrMissingURL = errors.New("URL field is required")
+	ErrMissingURL             = errors.New("URL field is required")
+	ErrShallowOptionExclusive = errors.New("Depth, Deepen, Unshallow and ShallowSince or ShallowExclude are mutually exclusive")
+	ErrMissingSignKey         = errors.New("SignKey is required to sign a push")
 )
 
 // CloneOptions describes how a clone should be performed.
@@ -38,6 +42,8 @@ type CloneOptions struct {
 	// The (possibly remote) repository URL to clone from.
 	URL string
 	// Auth credentials, if required, to use with the remote repository.
+	// If nil, the credential helpers of the config are asked for the
+	// credentials of the HTTP(S) remotes requiring them.
 	Auth transport.AuthMethod
 	// Name of the remote to be added, by default `origin`.
 	RemoteName string
@@ -49,6 +55,11 @@ type CloneOptions struct {
 	NoCheckout bool
 	// Limit fetching to the specified number of commits.
 	Depth int
+	// ShallowSince limits fetching to the commits newer than the given time.
+	ShallowSince time.Time
+	// ShallowExclude limits fetching to the commits not reachable from the
+	// given remote branches or tags.
+	ShallowExclude []string
 	// RecurseSubmodules after the clone is created, initialize all submodules
 	// within, using their default settings. This option is ignored if the
 	// cloned repository does not have a worktree.
@@ -64,6 +75,9 @@ type CloneOptions struct {
 	InsecureSkipTLS bool
 	// CABundle specify additional ca bundle with system cert pool
 	CABundle []byte
+	// ProxyOptions provides info required for connecting to a proxy. If
+	// empty, the http.proxy and http.<url>.proxy config are used for HTTP(S).
+	ProxyOptions transport.ProxyOptions
 }
 
 // Validate validates the fields and sets the default values.
@@ -84,7 +98,11 @@ func (o *CloneOptions) Validate() error {
 		o.Tags = AllTags
 	}
 
-	return nil
+	if err := validateShallow(o.Depth, 0, false, o.ShallowSince, o.ShallowExclude); err != nil {
+		return err
+	}
+
+	return o.ProxyOptions.Validate()
 }
 
 // PullOptions describes how a pull should be performed.
@@ -99,7 +117,20 @@ type PullOptions struct {
 	SingleBranch bool
 	// Limit fetching to the specified number of commits.
 	Depth int
+	// Deepen fetches the specified number of commits more from the shallow
+	// boundaries of the repository, instead of from the tips.
+	Deepen int
+	// Unshallow fetches all the history of a shallow repository, making it
+	// complete.
+	Unshallow bool
+	// ShallowSince limits fetching to the commits newer than the given time.
+	ShallowSince time.Time
+	// ShallowExclude limits fetching to the commits not reachable from the
+	// given remote branches or tags.
+	ShallowExclude []string
 	// Auth credentials, if required, to use with the remote repository.
+	// If nil, the credential helpers of the config are asked for the
+	// credentials of the HTTP(S) remotes requiring them.
 	Auth transport.AuthMethod
 	// RecurseSubmodules controls if new commits of all populated submodules
 	// should be fetched too.
@@ -115,6 +146,9 @@ type PullOptions struct {
 	InsecureSkipTLS bool
 	// CABundle specify additional ca bundle with system cert pool
 	CABundle []byte
+	// ProxyOptions provides info required for connecting to a proxy. If
+	// empty, the http.proxy and http.<url>.proxy config are used for HTTP(S).
+	ProxyOptions transport.ProxyOptions
 }
 
 // Validate validates the fields and sets the default values.
@@ -127,6 +161,32 @@ func (o *PullOptions) Validate() error {
 		o.ReferenceName = plumbing.HEAD
 	}
 
+	if err := validateShallow(o.Depth, o.Deepen, o.Unshallow, o.ShallowSince, o.ShallowExclude); err != nil {
+		return err
+	}
+
+	return o.ProxyOptions.Validate()
+}
+
+// validateShallow checks that at most one of depth, deepen and unshallow is
+// set, and not along with since or exclude, as the server doesn't allow it.
+func validateShallow(depth, deepen int, unshallow bool, since time.Time, exclude []string) error {
+	var n int
+	for _, set := range []bool{
+		depth != 0,
+		deepen != 0,
+		unshallow,
+		!since.IsZero() || len(exclude) != 0,
+	} {
+		if set {
+			n++
+		}
+	}
+
+	if n > 1 {
+		return ErrShallowOptionExclusive
+	}
+
 	return nil
 }
 
@@ -155,7 +215,20 @@ type FetchOptions struct {
 	// Depth limit fetching to the specified number of commits from the tip of
 	// each remote branch history.
 	Depth int
+	// Deepen fetches the specified number of commits more from the shallow
+	// boundaries of the repository, instead of from the tips.
+	Deepen int
+	// Unshallow fetches all the history of a shallow repository, making it
+	// complete.
+	Unshallow bool
+	// ShallowSince limits fetching to the commits newer than the given time.
+	ShallowSince time.Time
+	// ShallowExclude limits fetching to the commits not reachable from the
+	// given remote branches or tags.
+	ShallowExclude []string
 	// Auth credentials, if required, to use with the remote repository.
+	// If nil, the credential helpers of the config are asked for the
+	// credentials of the HTTP(S) remotes requiring them.
 	Auth transport.AuthMethod
 	// Progress is where the human readable information sent by the server is
 	// stored, if nil nothing is stored and the capability (if supported)
@@ -171,6 +244,9 @@ type FetchOptions struct {
 	InsecureSkipTLS bool
 	// CABundle specify additional ca bundle with system cert pool
 	CABundle []byte
+	// ProxyOptions provides info required for connecting to a proxy. If
+	// empty, the http.proxy and http.<url>.proxy config are used for HTTP(S).
+	ProxyOptions transport.ProxyOptions
 }
 
 // Validate validates the fields and sets the default values.
@@ -189,7 +265,11 @@ func (o *FetchOptions) Validate() error {
 		}
 	}
 
-	return nil
+	if err := validateShallow(o.Depth, o.Deepen, o.Unshallow, o.ShallowSince, o.ShallowExclude); err != nil {
+		return err
+	}
+
+	return o.ProxyOptions.Validate()
 }
 
 // PushOptions describes how a push should be performed.
@@ -208,6 +288,8 @@ type PushOptions struct {
 	// A refspec with empty src can be used to delete a reference.
 	RefSpecs []config.RefSpec
 	// Auth credentials, if required, to use with the remote repository.
+	// If nil, the credential helpers of the config are asked for the
+	// credentials of the HTTP(S) remotes requiring them.
 	Auth transport.AuthMethod
 	// Progress is where the human readable information sent by the server is
 	// stored, if nil nothing is stored.
@@ -222,6 +304,9 @@ type PushOptions struct {
 	InsecureSkipTLS bool
 	// CABundle specify additional ca bundle with system cert pool
 	CABundle []byte
+	// ProxyOptions provides info required for connecting to a proxy. If
+	// empty, the http.proxy and http.<url>.proxy config are used for HTTP(S).
+	ProxyOptions transport.ProxyOptions
 	// RequireRemoteRefs only allows a remote ref to be updated if its current
 	// value is the one specified here.
 	RequireRemoteRefs []config.RefSpec
@@ -234,8 +319,28 @@ type PushOptions struct {
 	Options map[string]string
 	// Atomic sets option to be an atomic push
 	Atomic bool
+	// Sign defines if the push is signed with a push certificate, recording
+	// who pushed what on the server.
+	Sign PushSignMode
+	// SignKey denotes the key the push certificate is signed with, required
+	// to sign the push.
+	SignKey *openpgp.Entity
 }
 
+// PushSignMode defines if a push is signed, as the --signed flag of git push.
+type PushSignMode int
+
+const (
+	// PushSignNever doesn't sign the push.
+	PushSignNever PushSignMode = iota
+	// PushSignIfAsked signs the push if the server supports push
+	// certificates, pushing unsigned otherwise.
+	PushSignIfAsked
+	// PushSignAlways signs the push, failing with ErrPushCertNotSupported if
+	// the server doesn't support push certificates.
+	PushSignAlways
+)
+
 // ForceWithLease sets fields on the lease
 // If neither RefName nor Hash are set, ForceWithLease protects
 // all refs in the refspec by ensuring the ref of the remote in the local repsitory
@@ -267,7 +372,11 @@ func (o *PushOptions) Validate() error {
 		}
 	}
 
-	return nil
+	if o.Sign != PushSignNever && o.SignKey == nil {
+		return ErrMissingSignKey
+	}
+
+	return o.ProxyOptions.Validate()
 }
 
 // SubmoduleUpdateOptions describes how a submodule update should be performed.
@@ -423,6 +532,46 @@ type LogOptions struct {
 	// Show commits older than a specific date.
 	// It is equivalent to running `git log --until <date>` or `git log --before <date>`.
 	Until *time.Time
+
+	// Mailmap, if not nil, maps the authors and committers of the commits to
+	// their canonical identity. It is equivalent to running `git log
+	// --use-mailmap` with the mailmap returned by Repository.Mailmap.
+	Mailmap *mailmap.Mailmap
+}
+
+// ShortlogOptions describes how a shortlog operation should be performed.
+type ShortlogOptions struct {
+	// Log are the options selecting the commits, HEAD and its ancestors in
+	// committer time order by default.
+	Log *LogOptions
+	// Committer groups the commits by committer instead of author.
+	Committer bool
+	// Email groups the commits by name and email instead of name only.
+	Email bool
+	// Numbered sorts the groups by number of commits instead of by name.
+	Numbered bool
+	// Mailmap maps the authors and committers to their canonical identity,
+	// by default the one returned by Repository.Mailmap. An empty mailmap,
+	// from mailmap.New, disables the mapping.
+	Mailmap *mailmap.Mailmap
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ShortlogOptions) Validate(r *Repository) error {
+	if o.Log == nil {
+		o.Log = &LogOptions{Order: LogOrderCommitterTime}
+	}
+
+	if o.Mailmap == nil {
+		m, err := r.Mailmap()
+		if err != nil {
+			return err
+		}
+
+		o.Mailmap = m
+	}
+
+	return nil
 }
 
 var (
@@ -475,6 +624,13 @@ type CommitOptions struct {
 	// commit will not be signed. The private key must be present and already
 	// decrypted.
 	SignKey *openpgp.Entity
+	// Trailers are added to the trailers of the commit message, as git
+	// interpret-trailers does.
+	Trailers []object.Trailer
+	// TrailerOptions describes how the Trailers are added. If nil and there
+	// are Trailers, it's read from the trailer section of the repository
+	// config.
+	TrailerOptions *object.TrailerOptions
 }
 
 // Validate validates the fields and sets the default values.
@@ -500,6 +656,17 @@ func (o *CommitOptions) Validate(r *Repository) error {
 		}
 	}
 
+	if len(o.Trailers) != 0 && o.TrailerOptions == nil {
+		cfg, err := r.Config()
+		if err != nil {
+			return err
+		}
+
+		if o.TrailerOptions, err = object.NewTrailerOptions(cfg.Raw); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -610,11 +777,16 @@ func (o *CreateTagOptions) loadConfigTagger(r *Repository) error {
 // ListOptions describes how a remote list should be performed.
 type ListOptions struct {
 	// Auth credentials, if required, to use with the remote repository.
+	// If nil, the credential helpers of the config are asked for the
+	// credentials of the HTTP(S) remotes requiring them.
 	Auth transport.AuthMethod
 	// InsecureSkipTLS skips ssl verify if protocol is https
 	InsecureSkipTLS bool
 	// CABundle specify additional ca bundle with system cert pool
 	CABundle []byte
+	// ProxyOptions provides info required for connecting to a proxy. If
+	// empty, the http.proxy and http.<url>.proxy config are used for HTTP(S).
+	ProxyOptions transport.ProxyOptions
 }
 
 // CleanOptions describes how a clean should be performed.
@@ -672,3 +844,252 @@ type PlainOpenOptions struct {
 
 // Validate validates the fields and sets the default values.
 func (o *PlainOpenOptions) Validate() error { return nil }
+
+const (
+	// DefaultDescribeAbbrev is the default minimum number of hexadecimal
+	// digits of the abbreviated commit hash returned by Describe.
+	DefaultDescribeAbbrev = 7
+	// MinDescribeAbbrev is the smallest number of hexadecimal digits of the
+	// abbreviated commit hash returned by Describe, as in git.
+	MinDescribeAbbrev = 4
+	// DefaultDescribeDirtyMark is the default suffix appended by Describe to
+	// the description of a modified worktree.
+	DefaultDescribeDirtyMark = "-dirty"
+)
+
+var (
+	ErrInvalidDescribePattern = errors.New("invalid describe pattern")
+	ErrInvalidDescribeAbbrev  = errors.New("invalid describe abbrev")
+)
+
+// DescribeOptions describes how a commit description should be computed.
+type DescribeOptions struct {
+	// Tags considers the lightweight tags as well, not only the annotated
+	// ones.
+	Tags bool
+	// Match only considers the tags whose name matches any of the given glob
+	// patterns. As in git, "*" and "?" also match "/".
+	Match []string
+	// Exclude ignores the tags whose name matches any of the given glob
+	// patterns.
+	Exclude []string
+	// Dirty appends DirtyMark to the description if the worktree has local
+	// modifications.
+	Dirty bool
+	// DirtyMark is the suffix appended when Dirty is set and the worktree is
+	// modified. If empty, DefaultDescribeDirtyMark is used.
+	DirtyMark string
+	// Long always returns the long format, even when the commit is tagged.
+	Long bool
+	// Abbrev is the minimum number of hexadecimal digits of the abbreviated
+	// commit hash, which as in git is made longer when needed to be unique in
+	// the repository. If zero, DefaultDescribeAbbrev is used.
+	Abbrev int
+	// NoAbbrev suppresses the long format, only the name of the closest tag
+	// is returned, as git describe --abbrev=0 does.
+	NoAbbrev bool
+	// FirstParent only follows the first parent of merge commits.
+	FirstParent bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *DescribeOptions) Validate() error {
+	if o.Abbrev < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidDescribeAbbrev, o.Abbrev)
+	}
+
+	if o.Abbrev == 0 {
+		o.Abbrev = DefaultDescribeAbbrev
+	}
+
+	if o.Abbrev < MinDescribeAbbrev {
+		o.Abbrev = MinDescribeAbbrev
+	}
+
+	if o.Abbrev > len(plumbing.ZeroHash)*2 {
+		o.Abbrev = len(plumbing.ZeroHash) * 2
+	}
+
+	if o.DirtyMark == "" {
+		o.DirtyMark = DefaultDescribeDirtyMark
+	}
+
+	for _, p := range append(append([]string{}, o.Match...), o.Exclude...) {
+		if err := checkWildmatch(p); err != nil {
+			return fmt.Errorf("%w: %q", ErrInvalidDescribePattern, p)
+		}
+	}
+
+	return nil
+}
+
+// DefaultBlameMoveCopyScore is the default minimum number of alphanumeric
+// characters of the lines detected as moved or copied by a blame.
+const DefaultBlameMoveCopyScore = 20
+
+var (
+	ErrInvalidBlameLineRange = errors.New("invalid blame line range")
+)
+
+// BlameLineRange is a range of lines to blame. Start and End are 1-based and
+// inclusive, a zero End means the end of the file.
+type BlameLineRange struct {
+	Start, End int
+}
+
+// BlameOptions describes how a blame should be performed.
+type BlameOptions struct {
+	// Lines restricts the blame to the given ranges of lines, like -L does.
+	// By default the whole file is blamed.
+	Lines []BlameLineRange
+	// IgnoreRevs are commits whose changes are ignored: the lines they
+	// changed are attributed to the previous commit changing them, when it
+	// can be guessed. Repository.BlameIgnoreRevs reads them from the file
+	// set in blame.ignoreRevsFile.
+	IgnoreRevs []plumbing.Hash
+	// FollowRenames follows the file across renames.
+	FollowRenames bool
+	// DetectMoves attributes the lines moved or copied within the file to
+	// the commit where they were originally written, like -M does.
+	DetectMoves bool
+	// DetectCopies also attributes the lines moved or copied from other files
+	// modified in the same commit, like -C does.
+	DetectCopies bool
+	// MoveCopyScore is the minimum number of alphanumeric characters of a
+	// group of lines to be detected as moved or copied. If zero,
+	// DefaultBlameMoveCopyScore is used.
+	MoveCopyScore int
+	// Incremental, if set, is called with every group of lines as soon as
+	// it is attributed, like --incremental does. If it returns an error the
+	// blame is stopped and the error returned.
+	Incremental func(*BlameEntry) error
+	// Mailmap, if not nil, maps the authors of the lines to their canonical
+	// identity, like git blame does with the mailmap returned by
+	// Repository.Mailmap.
+	Mailmap *mailmap.Mailmap
+}
+
+// Validate validates the fields and sets the default values.
+func (o *BlameOptions) Validate() error {
+	if o.MoveCopyScore == 0 {
+		o.MoveCopyScore = DefaultBlameMoveCopyScore
+	}
+
+	for _, l := range o.Lines {
+		if l.Start < 1 || (l.End != 0 && l.End < l.Start) {
+			return fmt.Errorf("%w: %d,%d", ErrInvalidBlameLineRange, l.Start, l.End)
+		}
+	}
+
+	return nil
+}
+
+// ApplyMailboxOptions describes how the patches of a mailbox are applied.
+type ApplyMailboxOptions struct {
+	// Committer is the committer's signature of the created commits. If
+	// Committer is nil the Name and Email are read from the config, and
+	// time.Now is used as When, or the author of every patch is used if the
+	// config doesn't set them.
+	Committer *object.Signature
+	// SignKey denotes a key to sign the commits with. A nil value here means
+	// the commits will not be signed. The private key must be present and
+	// already decrypted.
+	SignKey *openpgp.Entity
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ApplyMailboxOptions) Validate(r *Repository) error {
+	if o.Committer != nil {
+		return nil
+	}
+
+	co := &CommitOptions{}
+	if err := co.loadConfigAuthorAndCommitter(r); err != nil && err != ErrMissingAuthor {
+		return err
+	}
+
+	o.Committer = co.Committer
+	if o.Committer == nil {
+		o.Committer = co.Author
+	}
+
+	return nil
+}
+
+var (
+	ErrInvalidApplyFuzz    = errors.New("fuzz must not be negative")
+	ErrApplyIndexAndCached = errors.New("Index and Cached are mutually exclusive")
+)
+
+// ApplyOptions describes how a patch is applied.
+type ApplyOptions struct {
+	// Check only checks that the patch applies, without changing anything.
+	Check bool
+	// Reverse applies the patch in reverse, undoing it.
+	Reverse bool
+	// Fuzz is the number of leading and trailing context lines of the
+	// hunks which can be ignored when they don't match.
+	Fuzz int
+	// ThreeWay falls back to a three-way merge when a file patch doesn't
+	// apply, using the blobs named by the index lines of the patch. The
+	// conflicts are written with markers and make Apply return
+	// ErrPatchConflicts. ThreeWay implies Index, unless Cached is set.
+	ThreeWay bool
+	// Index applies the patch to both the index and the worktree, which
+	// must match the index for the changed files.
+	Index bool
+	// Cached applies the patch to the index only, leaving the worktree
+	// untouched.
+	Cached bool
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ApplyOptions) Validate() error {
+	if o.Fuzz < 0 {
+		return ErrInvalidApplyFuzz
+	}
+
+	if o.Index && o.Cached {
+		return ErrApplyIndexAndCached
+	}
+
+	if o.ThreeWay && !o.Cached {
+		o.Index = true
+	}
+
+	return nil
+}
+
+// ArchiveOptions describes how an archive is written.
+type ArchiveOptions struct {
+	// Format is the format of the archive, archive.Tar by default.
+	Format archive.Format
+	// Prefix is prepended to the paths of the files, it usually ends with a
+	// slash.
+	Prefix string
+	// Paths restricts the archive to the given files and directories.
+	Paths []string
+	// CompressionLevel is the compression level, from 1 for the fastest to
+	// 9 for the best, or 0 for the default one.
+	CompressionLevel int
+}
+
+// Validate validates the fields and sets the default values.
+func (o *ArchiveOptions) Validate() error {
+	ao := o.archiveOptions()
+	if err := ao.Validate(); err != nil {
+		return err
+	}
+
+	o.Format = ao.Format
+	return nil
+}
+
+func (o *ArchiveOptions) archiveOptions() *archive.Options {
+	return &archive.Options{
+		Format:           o.Format,
+		Prefix:           o.Prefix,
+		Paths:            o.Paths,
+		CompressionLevel: o.CompressionLevel,
+	}
+}
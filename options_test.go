@@ -2,6 +2,7 @@ package git
 
 import (
 	"os"
+	"time"
 
 	"github.com/go-git/go-billy/v5/util"
 	"github.com/go-git/go-git/v5/config"
@@ -90,6 +91,27 @@ func (s *OptionsSuite) TestCreateTagOptionsLoadGlobal(c *C) {
 	c.Assert(o.Tagger.Email, Equals, "foo@foo.com")
 }
 
+func (s *OptionsSuite) TestFetchOptionsShallowExclusive(c *C) {
+	now := time.Now()
+	for _, o := range []*FetchOptions{
+		{Depth: 1, Deepen: 1},
+		{Depth: 1, Unshallow: true},
+		{Deepen: 1, ShallowSince: now},
+		{Unshallow: true, ShallowExclude: []string{"foo"}},
+	} {
+		c.Assert(o.Validate(), Equals, ErrShallowOptionExclusive)
+	}
+
+	o := &FetchOptions{ShallowSince: now, ShallowExclude: []string{"foo"}}
+	c.Assert(o.Validate(), IsNil)
+
+	co := &CloneOptions{URL: "foo", Depth: 1, ShallowSince: now}
+	c.Assert(co.Validate(), Equals, ErrShallowOptionExclusive)
+
+	po := &PullOptions{Deepen: 1, Unshallow: true}
+	c.Assert(po.Validate(), Equals, ErrShallowOptionExclusive)
+}
+
 func (s *OptionsSuite) writeGlobalConfig(c *C, cfg *config.Config) func() {
 	fs, clean := s.TemporalFilesystem()
 
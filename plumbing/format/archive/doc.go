@@ -0,0 +1,4 @@
+// Package archive implements writing trees as tar, gzipped tar and zip
+// archives, as git archive does, honoring the export-ignore and export-subst
+// gitattributes.
+package archive
@@ -0,0 +1,351 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const (
+	exportIgnoreAttr = "export-ignore"
+	exportSubstAttr  = "export-subst"
+
+	gitattributesFile = ".gitattributes"
+)
+
+var (
+	// ErrUnknownFormat is returned for an unknown archive format.
+	ErrUnknownFormat = errors.New("unknown archive format")
+	// ErrInvalidCompressionLevel is returned for a compression level out of
+	// the 0 to 9 range.
+	ErrInvalidCompressionLevel = errors.New("invalid compression level")
+	// ErrPathNotFound is returned when one of the paths to archive isn't in
+	// the tree.
+	ErrPathNotFound = errors.New("path not found in tree")
+)
+
+// Format is the format of an archive.
+type Format string
+
+const (
+	// Tar is the tar format, with a pax global header holding the commit
+	// hash.
+	Tar Format = "tar"
+	// TarGzip is the tar format compressed with gzip.
+	TarGzip Format = "tar.gz"
+	// Zip is the zip format, with the commit hash as comment.
+	Zip Format = "zip"
+)
+
+// FormatFromName returns the format with the given name, tgz being an alias
+// of tar.gz, or the format of an archive file with the given name, from its
+// extension.
+func FormatFromName(name string) (Format, error) {
+	switch name {
+	case "tar":
+		return Tar, nil
+	case "tar.gz", "tgz":
+		return TarGzip, nil
+	case "zip":
+		return Zip, nil
+	}
+
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return FormatFromName(ext[1:])
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownFormat, name)
+}
+
+// Options describes how an archive is written.
+type Options struct {
+	// Format is the format of the archive, Tar by default.
+	Format Format
+	// Prefix is prepended to the paths of the files, it usually ends with a
+	// slash.
+	Prefix string
+	// Paths restricts the archive to the given files and directories.
+	Paths []string
+	// CompressionLevel is the compression level, from 1 for the fastest to
+	// 9 for the best, or 0 for the default one.
+	CompressionLevel int
+	// ModTime is the modification time of the files when the archived tree
+	// doesn't come from a commit, time.Now by default. The time of the
+	// committer is used otherwise.
+	ModTime time.Time
+	// Attributes are gitattributes patterns applied besides the ones of the
+	// .gitattributes files of the tree, with a higher priority, as the ones
+	// of $GIT_DIR/info/attributes.
+	Attributes []gitattributes.MatchAttribute
+}
+
+// Validate validates the fields and sets the default values.
+func (o *Options) Validate() error {
+	if o.Format == "" {
+		o.Format = Tar
+	}
+
+	if _, err := FormatFromName(string(o.Format)); err != nil {
+		return err
+	}
+
+	if o.CompressionLevel < 0 || o.CompressionLevel > 9 {
+		return fmt.Errorf("%w: %d", ErrInvalidCompressionLevel, o.CompressionLevel)
+	}
+
+	return nil
+}
+
+// Encoder writes trees as archives.
+type Encoder struct {
+	w io.Writer
+	o *Options
+}
+
+// NewEncoder returns a new Encoder writing to w with the given options.
+func NewEncoder(w io.Writer, o *Options) *Encoder {
+	if o == nil {
+		o = &Options{}
+	}
+
+	return &Encoder{w: w, o: o}
+}
+
+// entry is a file or a directory of an archive.
+type entry struct {
+	path    string
+	mode    filemode.FileMode
+	content []byte
+}
+
+// archiveWriter writes the entries of an archive in a specific format.
+type archiveWriter interface {
+	writeEntry(e *entry) error
+	Close() error
+}
+
+// Encode writes tree as an archive. The commit the tree comes from, if not
+// nil, gives the modification time of the files, the hash stored in the
+// archive and the expansion of the export-subst placeholders.
+func (e *Encoder) Encode(tree *object.Tree, c *object.Commit) (err error) {
+	if err := e.o.Validate(); err != nil {
+		return err
+	}
+
+	for _, p := range e.o.Paths {
+		if _, err := tree.FindEntry(cleanPath(p)); err != nil {
+			return fmt.Errorf("%w: %s", ErrPathNotFound, p)
+		}
+	}
+
+	modTime := e.o.ModTime
+	if c != nil {
+		modTime = c.Committer.When
+	} else if modTime.IsZero() {
+		modTime = time.Now()
+	}
+
+	comment := ""
+	if c != nil {
+		comment = c.Hash.String()
+	}
+
+	var aw archiveWriter
+	switch e.o.Format {
+	case Zip:
+		aw, err = newZipWriter(e.w, modTime, comment, e.o.CompressionLevel)
+	case TarGzip:
+		aw, err = newTarGzipWriter(e.w, modTime, comment, e.o.CompressionLevel)
+	default:
+		aw, err = newTarWriter(e.w, modTime, comment)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(aw, &err)
+
+	attrs, err := readTreeAttributes(tree, nil)
+	if err != nil {
+		return err
+	}
+
+	w := &treeArchiver{
+		aw:      aw,
+		o:       e.o,
+		commit:  c,
+		matcher: gitattributes.NewMatcher(append(attrs, e.o.Attributes...)),
+	}
+
+	if strings.HasSuffix(e.o.Prefix, "/") {
+		if err := aw.writeEntry(&entry{path: e.o.Prefix, mode: filemode.Dir}); err != nil {
+			return err
+		}
+	}
+
+	return w.writeTree(tree, nil)
+}
+
+// readTreeAttributes returns the patterns of the .gitattributes files of a
+// tree, by ascending order of priority.
+func readTreeAttributes(tree *object.Tree, dir []string) ([]gitattributes.MatchAttribute, error) {
+	var attrs []gitattributes.MatchAttribute
+	for _, te := range tree.Entries {
+		if te.Name != gitattributesFile || !te.Mode.IsFile() {
+			continue
+		}
+
+		f, err := tree.TreeEntryFile(&te)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := f.Contents()
+		if err != nil {
+			return nil, err
+		}
+
+		attrs, err = gitattributes.ReadAttributes(strings.NewReader(content), dir, len(dir) == 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, te := range tree.Entries {
+		if te.Mode != filemode.Dir {
+			continue
+		}
+
+		sub, err := tree.Tree(te.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		subAttrs, err := readTreeAttributes(sub, append(dir[:len(dir):len(dir)], te.Name))
+		if err != nil {
+			return nil, err
+		}
+
+		attrs = append(attrs, subAttrs...)
+	}
+
+	return attrs, nil
+}
+
+// treeArchiver writes the entries of a tree to an archive.
+type treeArchiver struct {
+	aw      archiveWriter
+	o       *Options
+	commit  *object.Commit
+	matcher gitattributes.Matcher
+}
+
+func (a *treeArchiver) writeTree(tree *object.Tree, dir []string) error {
+	for _, te := range tree.Entries {
+		parts := append(dir[:len(dir):len(dir)], te.Name)
+		name := path.Join(parts...)
+
+		included, descend := a.selected(name, te.Mode == filemode.Dir)
+		if !included && !descend {
+			continue
+		}
+
+		attrs, _ := a.matcher.Match(parts, []string{exportIgnoreAttr, exportSubstAttr})
+		if attr, ok := attrs[exportIgnoreAttr]; ok && attr.IsSet() {
+			continue
+		}
+
+		var err error
+		switch te.Mode {
+		case filemode.Dir:
+			err = a.writeDir(tree, te, parts)
+		case filemode.Submodule:
+			err = a.aw.writeEntry(&entry{path: a.o.Prefix + name + "/", mode: filemode.Dir})
+		default:
+			err = a.writeFile(tree, te, name, attrs[exportSubstAttr])
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *treeArchiver) writeDir(tree *object.Tree, te object.TreeEntry, parts []string) error {
+	err := a.aw.writeEntry(&entry{path: a.o.Prefix + path.Join(parts...) + "/", mode: filemode.Dir})
+	if err != nil {
+		return err
+	}
+
+	sub, err := tree.Tree(te.Name)
+	if err != nil {
+		return err
+	}
+
+	return a.writeTree(sub, parts)
+}
+
+func (a *treeArchiver) writeFile(tree *object.Tree, te object.TreeEntry, name string, subst gitattributes.Attribute) error {
+	f, err := tree.TreeEntryFile(&te)
+	if err != nil {
+		return err
+	}
+
+	r, err := f.Reader()
+	if err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	_, err = io.Copy(buf, r)
+	ioutil.CheckClose(r, &err)
+	if err != nil {
+		return err
+	}
+
+	content := buf.Bytes()
+	if subst != nil && subst.IsSet() && a.commit != nil && te.Mode != filemode.Symlink {
+		content = []byte(expandSubst(string(content), a.commit))
+	}
+
+	return a.aw.writeEntry(&entry{path: a.o.Prefix + name, mode: te.Mode, content: content})
+}
+
+// selected returns whether the entry at name is selected by the paths of the
+// options, and if it's a directory containing selected paths.
+func (a *treeArchiver) selected(name string, isDir bool) (included, descend bool) {
+	if len(a.o.Paths) == 0 {
+		return true, false
+	}
+
+	for _, p := range a.o.Paths {
+		p = cleanPath(p)
+		if p == "" || name == p || strings.HasPrefix(name, p+"/") {
+			return true, false
+		}
+
+		if isDir && strings.HasPrefix(p, name+"/") {
+			descend = true
+		}
+	}
+
+	return false, descend
+}
+
+func cleanPath(p string) string {
+	return strings.Trim(path.Clean("/"+p), "/")
+}
@@ -0,0 +1,204 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type EncoderSuite struct {
+	fixtures.Suite
+	commit *object.Commit
+}
+
+var _ = Suite(&EncoderSuite{})
+
+func (s *EncoderSuite) SetUpTest(c *C) {
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+
+	var err error
+	s.commit, err = object.GetCommit(sto, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(err, IsNil)
+}
+
+func (s *EncoderSuite) encode(c *C, o *Options, withCommit bool) []byte {
+	tree, err := s.commit.Tree()
+	c.Assert(err, IsNil)
+
+	commit := s.commit
+	if !withCommit {
+		commit = nil
+	}
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(NewEncoder(buf, o).Encode(tree, commit), IsNil)
+	return buf.Bytes()
+}
+
+type tarEntry struct {
+	name    string
+	mode    int64
+	content string
+}
+
+func readTar(c *C, r io.Reader) (global *tar.Header, entries []tarEntry) {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return global, entries
+		}
+
+		c.Assert(err, IsNil)
+		if h.Typeflag == tar.TypeXGlobalHeader {
+			global = h
+			continue
+		}
+
+		content, err := ioutil.ReadAll(tr)
+		c.Assert(err, IsNil)
+		entries = append(entries, tarEntry{h.Name, h.Mode, string(content)})
+	}
+}
+
+func (s *EncoderSuite) TestEncodeTar(c *C) {
+	data := s.encode(c, &Options{Prefix: "basic/"}, true)
+	c.Assert(len(data)%tarRecordSize, Equals, 0)
+
+	global, entries := readTar(c, bytes.NewReader(data))
+	c.Assert(global, NotNil)
+	c.Assert(global.PAXRecords["comment"], Equals, s.commit.Hash.String())
+
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.name)
+	}
+
+	c.Assert(names, DeepEquals, []string{
+		"basic/",
+		"basic/.gitignore",
+		"basic/CHANGELOG",
+		"basic/LICENSE",
+		"basic/binary.jpg",
+		"basic/go/",
+		"basic/go/example.go",
+		"basic/json/",
+		"basic/json/long.json",
+		"basic/json/short.json",
+		"basic/php/",
+		"basic/php/crappy.php",
+		"basic/vendor/",
+		"basic/vendor/foo.go",
+	})
+
+	c.Assert(entries[0].mode, Equals, int64(0775))
+	c.Assert(entries[2].mode, Equals, int64(0664))
+	c.Assert(entries[2].content, Equals, "Initial changelog\n")
+	c.Assert(entries[4].content, HasLen, 76110)
+
+	tr := tar.NewReader(bytes.NewReader(data))
+	_, err := tr.Next()
+	c.Assert(err, IsNil)
+	h, err := tr.Next()
+	c.Assert(err, IsNil)
+	c.Assert(h.ModTime.Equal(s.commit.Committer.When), Equals, true)
+}
+
+func (s *EncoderSuite) TestEncodeTarWithoutCommit(c *C) {
+	when := time.Unix(1600000000, 0)
+	global, entries := readTar(c, bytes.NewReader(s.encode(c, &Options{
+		Paths:   []string{"go", "CHANGELOG"},
+		ModTime: when,
+	}, false)))
+
+	c.Assert(global, IsNil)
+	c.Assert(entries, HasLen, 3)
+	c.Assert(entries[0].name, Equals, "CHANGELOG")
+	c.Assert(entries[1].name, Equals, "go/")
+	c.Assert(entries[2].name, Equals, "go/example.go")
+}
+
+func (s *EncoderSuite) TestEncodeTarGzip(c *C) {
+	data := s.encode(c, &Options{Format: TarGzip, CompressionLevel: 9}, true)
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+
+	_, entries := readTar(c, zr)
+	c.Assert(entries, HasLen, 13)
+}
+
+func (s *EncoderSuite) TestEncodeZip(c *C) {
+	data := s.encode(c, &Options{Format: Zip, Paths: []string{"vendor"}}, true)
+
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	c.Assert(err, IsNil)
+	c.Assert(zr.Comment, Equals, s.commit.Hash.String())
+	c.Assert(zr.File, HasLen, 2)
+	c.Assert(zr.File[0].Name, Equals, "vendor/")
+	c.Assert(zr.File[0].Mode().IsDir(), Equals, true)
+	c.Assert(zr.File[1].Name, Equals, "vendor/foo.go")
+	c.Assert(zr.File[1].Mode().Perm(), Equals, os.FileMode(0664))
+	c.Assert(zr.File[1].Modified.Equal(s.commit.Committer.When), Equals, true)
+
+	r, err := zr.File[1].Open()
+	c.Assert(err, IsNil)
+
+	content, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Matches, "(?s)package main.*")
+}
+
+func (s *EncoderSuite) TestEncodeErrors(c *C) {
+	tree, err := s.commit.Tree()
+	c.Assert(err, IsNil)
+
+	for _, o := range []*Options{
+		{Format: "rar"},
+		{CompressionLevel: 10},
+		{Paths: []string{"missing"}},
+	} {
+		buf := bytes.NewBuffer(nil)
+		err := NewEncoder(buf, o).Encode(tree, nil)
+		c.Assert(err, NotNil)
+		c.Assert(buf.Len(), Equals, 0)
+	}
+
+	err = NewEncoder(ioutil.Discard, &Options{Paths: []string{"missing"}}).Encode(tree, nil)
+	c.Assert(errors.Is(err, ErrPathNotFound), Equals, true)
+}
+
+func (s *EncoderSuite) TestFormatFromName(c *C) {
+	for name, f := range map[string]Format{
+		"tar":            Tar,
+		"tgz":            TarGzip,
+		"tar.gz":         TarGzip,
+		"zip":            Zip,
+		"release.tar.gz": TarGzip,
+		"release.zip":    Zip,
+	} {
+		got, err := FormatFromName(name)
+		c.Assert(err, IsNil)
+		c.Assert(got, Equals, f)
+	}
+
+	_, err := FormatFromName("release.rar")
+	c.Assert(errors.Is(err, ErrUnknownFormat), Equals, true)
+}
@@ -0,0 +1,179 @@
+package archive
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const (
+	substStart = "$Format:"
+	substEnd   = "$"
+
+	// abbrevLength is the length of the abbreviated hashes.
+	abbrevLength = 7
+
+	defaultDate = "Mon Jan 2 15:04:05 2006 -0700"
+	rfc2822Date = "Mon, 2 Jan 2006 15:04:05 -0700"
+	isoDate     = "2006-01-02 15:04:05 -0700"
+	isoStrict   = "2006-01-02T15:04:05-07:00"
+	shortDate   = "2006-01-02"
+)
+
+// expandSubst replaces the $Format:<format>$ placeholders of content by the
+// format expanded for c, as the export-subst attribute does.
+func expandSubst(content string, c *object.Commit) string {
+	var sb strings.Builder
+	for {
+		start := strings.Index(content, substStart)
+		if start < 0 {
+			break
+		}
+
+		end := strings.Index(content[start+len(substStart):], substEnd)
+		if end < 0 {
+			break
+		}
+
+		end += start + len(substStart)
+		sb.WriteString(content[:start])
+		sb.WriteString(FormatCommit(content[start+len(substStart):end], c))
+		content = content[end+len(substEnd):]
+	}
+
+	sb.WriteString(content)
+	return sb.String()
+}
+
+// FormatCommit expands the placeholders of a git log --pretty=format string
+// for c. The hashes (%H, %h, %T, %t, %P and %p), the names, emails and dates
+// of the author and the committer (%an, %ae, %ad, %aD, %ai, %aI, %at, %as and
+// their %c counterparts), the parts of the message (%s, %b and %B), %n, %%
+// and %xNN are supported, the other placeholders are left as is.
+func FormatCommit(format string, c *object.Commit) string {
+	var sb strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			sb.WriteByte(format[i])
+			continue
+		}
+
+		value, n := formatPlaceholder(format[i+1:], c)
+		if n == 0 {
+			sb.WriteByte('%')
+			continue
+		}
+
+		sb.WriteString(value)
+		i += n
+	}
+
+	return sb.String()
+}
+
+// formatPlaceholder returns the expansion of the placeholder at the start of
+// s, without its %, and its length, 0 if it isn't supported.
+func formatPlaceholder(s string, c *object.Commit) (string, int) {
+	switch s[0] {
+	case '%':
+		return "%", 1
+	case 'n':
+		return "\n", 1
+	case 'H':
+		return c.Hash.String(), 1
+	case 'h':
+		return c.Hash.String()[:abbrevLength], 1
+	case 'T':
+		return c.TreeHash.String(), 1
+	case 't':
+		return c.TreeHash.String()[:abbrevLength], 1
+	case 'P', 'p':
+		parents := make([]string, len(c.ParentHashes))
+		for i, h := range c.ParentHashes {
+			parents[i] = h.String()
+			if s[0] == 'p' {
+				parents[i] = parents[i][:abbrevLength]
+			}
+		}
+
+		return strings.Join(parents, " "), 1
+	case 's':
+		subject, _ := splitMessage(c.Message)
+		return subject, 1
+	case 'b':
+		_, body := splitMessage(c.Message)
+		return body, 1
+	case 'B':
+		return c.Message, 1
+	case 'a', 'c':
+		if len(s) < 2 {
+			return "", 0
+		}
+
+		sig := c.Author
+		if s[0] == 'c' {
+			sig = c.Committer
+		}
+
+		if v, ok := formatSignature(s[1], sig); ok {
+			return v, 2
+		}
+	case 'x':
+		if len(s) < 3 {
+			return "", 0
+		}
+
+		if b, err := strconv.ParseUint(s[1:3], 16, 8); err == nil {
+			return string([]byte{byte(b)}), 3
+		}
+	}
+
+	return "", 0
+}
+
+func formatSignature(field byte, sig object.Signature) (string, bool) {
+	switch field {
+	case 'n':
+		return sig.Name, true
+	case 'e':
+		return sig.Email, true
+	case 't':
+		return strconv.FormatInt(sig.When.Unix(), 10), true
+	}
+
+	layout := ""
+	switch field {
+	case 'd':
+		layout = defaultDate
+	case 'D':
+		layout = rfc2822Date
+	case 'i':
+		layout = isoDate
+	case 'I':
+		layout = isoStrict
+	case 's':
+		layout = shortDate
+	default:
+		return "", false
+	}
+
+	return sig.When.Format(layout), true
+}
+
+// splitMessage returns the first paragraph of a commit message joined in a
+// single line, and the rest of the message.
+func splitMessage(msg string) (subject, body string) {
+	paragraphs := strings.SplitN(strings.TrimLeft(msg, "\n"), "\n\n", 2)
+	lines := strings.Split(strings.TrimRight(paragraphs[0], "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+
+	subject = strings.Join(lines, " ")
+	if len(paragraphs) == 2 {
+		body = strings.TrimLeft(paragraphs[1], "\n")
+	}
+
+	return subject, body
+}
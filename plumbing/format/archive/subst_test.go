@@ -0,0 +1,36 @@
+package archive
+
+import (
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	. "gopkg.in/check.v1"
+)
+
+type SubstSuite struct{}
+
+var _ = Suite(&SubstSuite{})
+
+func (s *SubstSuite) TestExpandSubst(c *C) {
+	when := time.Date(2020, 10, 14, 12, 3, 4, 0, time.FixedZone("", 2*60*60))
+	commit := &object.Commit{
+		Hash:         plumbing.NewHash("19225c74fb150c68f6d6919254504185c8046feb"),
+		TreeHash:     plumbing.NewHash("a8d315b2b1c615d43042c3a62402b8a54288cf5c"),
+		ParentHashes: []plumbing.Hash{plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9")},
+		Author:       object.Signature{Name: "A U", Email: "a@b.c", When: when},
+		Committer:    object.Signature{Name: "C", Email: "c@d.e", When: when},
+		Message:      "Subject line\n continued\n\nBody here\n",
+	}
+
+	content := "v: $Format:%H %h %t %p$\n" +
+		"$Format:%an <%ae> %ad|%aD|%ai|%aI|%at|%as$\n" +
+		"$Format:%cn%n%s%x41%%%z$ $Format:%b$\n" +
+		"$Format: unterminated"
+
+	c.Assert(expandSubst(content, commit), Equals, "v: 19225c74fb150c68f6d6919254504185c8046feb 19225c7 a8d315b 35e8510\n"+
+		"A U <a@b.c> Wed Oct 14 12:03:04 2020 +0200|Wed, 14 Oct 2020 12:03:04 +0200|2020-10-14 12:03:04 +0200|2020-10-14T12:03:04+02:00|1602669784|2020-10-14\n"+
+		"C\nSubject line  continuedA%%z Body here\n\n"+
+		"$Format: unterminated")
+}
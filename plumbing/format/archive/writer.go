@@ -0,0 +1,235 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+const (
+	// umask is the umask applied to the modes of the entries, the default
+	// tar.umask of git.
+	umask = 0002
+
+	tarBlockSize  = 512
+	tarRecordSize = 20 * tarBlockSize
+
+	paxGlobalHeader = "pax_global_header"
+	paxComment      = "comment"
+)
+
+// entryMode returns the permissions of an entry, as written by git.
+func entryMode(m filemode.FileMode) os.FileMode {
+	switch m {
+	case filemode.Dir:
+		return os.ModeDir | 0777&^umask
+	case filemode.Symlink:
+		return os.ModeSymlink | 0777
+	case filemode.Executable:
+		return 0777 &^ umask
+	default:
+		return 0666 &^ umask
+	}
+}
+
+type tarWriter struct {
+	w       *countingWriter
+	tw      *tar.Writer
+	modTime time.Time
+	closer  io.Closer
+}
+
+func newTarWriter(w io.Writer, modTime time.Time, comment string) (*tarWriter, error) {
+	cw := &countingWriter{w: w}
+	tw := &tarWriter{w: cw, tw: tar.NewWriter(cw), modTime: modTime}
+	if comment == "" {
+		return tw, nil
+	}
+
+	return tw, writePaxGlobalHeader(cw, modTime, comment)
+}
+
+// writePaxGlobalHeader writes a pax global header holding comment, named
+// pax_global_header as git does, which archive/tar doesn't allow.
+func writePaxGlobalHeader(w io.Writer, modTime time.Time, comment string) error {
+	record := paxRecord(paxComment, comment)
+
+	var h [tarBlockSize]byte
+	copy(h[0:100], paxGlobalHeader)
+	copy(h[100:108], fmt.Sprintf("%07o", 0666))
+	copy(h[108:116], fmt.Sprintf("%07o", 0))
+	copy(h[116:124], fmt.Sprintf("%07o", 0))
+	copy(h[124:136], fmt.Sprintf("%011o", len(record)))
+	copy(h[136:148], fmt.Sprintf("%011o", modTime.Unix()))
+	h[156] = tar.TypeXGlobalHeader
+	copy(h[257:265], "ustar\x0000")
+	copy(h[265:297], "root")
+	copy(h[297:329], "root")
+	copy(h[329:337], fmt.Sprintf("%07o", 0))
+	copy(h[337:345], fmt.Sprintf("%07o", 0))
+
+	// The checksum is computed with the checksum field made of spaces.
+	copy(h[148:156], "        ")
+	sum := 0
+	for _, b := range h {
+		sum += int(b)
+	}
+
+	copy(h[148:156], fmt.Sprintf("%07o\x00", sum))
+
+	data := make([]byte, (len(record)+tarBlockSize-1)/tarBlockSize*tarBlockSize)
+	copy(data, record)
+	if _, err := w.Write(h[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+// paxRecord returns a pax extended header record, prefixed by its length.
+func paxRecord(key, value string) string {
+	record := fmt.Sprintf(" %s=%s\n", key, value)
+	size := len(record)
+	for size < len(strconv.Itoa(size))+len(record) {
+		size = len(strconv.Itoa(size)) + len(record)
+	}
+
+	return strconv.Itoa(size) + record
+}
+
+func newTarGzipWriter(w io.Writer, modTime time.Time, comment string, level int) (*tarWriter, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	gw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+
+	tw, err := newTarWriter(gw, modTime, comment)
+	if tw != nil {
+		tw.closer = gw
+	}
+
+	return tw, err
+}
+
+func (w *tarWriter) writeEntry(e *entry) error {
+	mode := entryMode(e.mode)
+	h := &tar.Header{
+		Name:    e.path,
+		Mode:    int64(mode.Perm()),
+		ModTime: w.modTime,
+		Uname:   "root",
+		Gname:   "root",
+	}
+
+	switch e.mode {
+	case filemode.Dir:
+		h.Typeflag = tar.TypeDir
+	case filemode.Symlink:
+		h.Typeflag = tar.TypeSymlink
+		h.Linkname = string(e.content)
+	default:
+		h.Typeflag = tar.TypeReg
+		h.Size = int64(len(e.content))
+	}
+
+	if err := w.tw.WriteHeader(h); err != nil {
+		return err
+	}
+
+	if h.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	_, err := w.tw.Write(e.content)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+
+	// The archive is padded to a whole record, as git and tar do.
+	if n := w.w.n % tarRecordSize; n != 0 {
+		if _, err := w.w.Write(make([]byte, tarRecordSize-n)); err != nil {
+			return err
+		}
+	}
+
+	if w.closer != nil {
+		return w.closer.Close()
+	}
+
+	return nil
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+type zipWriter struct {
+	zw      *zip.Writer
+	modTime time.Time
+	store   bool
+}
+
+func newZipWriter(w io.Writer, modTime time.Time, comment string, level int) (*zipWriter, error) {
+	zw := zip.NewWriter(w)
+	if err := zw.SetComment(comment); err != nil {
+		return nil, err
+	}
+
+	if level != 0 {
+		zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+
+	return &zipWriter{zw: zw, modTime: modTime}, nil
+}
+
+func (w *zipWriter) writeEntry(e *entry) error {
+	h := &zip.FileHeader{
+		Name:     e.path,
+		Method:   zip.Deflate,
+		Modified: w.modTime,
+	}
+
+	h.SetMode(entryMode(e.mode))
+	if e.mode == filemode.Dir {
+		h.Method = zip.Store
+	}
+
+	fw, err := w.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = fw.Write(e.content)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	return w.zw.Close()
+}
@@ -0,0 +1,126 @@
+package diff
+
+import (
+	"errors"
+	"strings"
+)
+
+// base85Alphabet is the alphabet of the base85 encoding of the binary patches
+// of git.
+const base85Alphabet = "0123456789" +
+	"ABCDEFGHIJKLMNOPQRSTUVWXYZ" +
+	"abcdefghijklmnopqrstuvwxyz" +
+	"!#$%&()*+-;<=>?@^_`{|}~"
+
+// binaryLineSize is the maximum number of bytes encoded in a line of a binary
+// patch.
+const binaryLineSize = 52
+
+var (
+	errInvalidBase85 = errors.New("invalid base85 data")
+
+	base85Values [256]int
+)
+
+func init() {
+	for i := range base85Values {
+		base85Values[i] = -1
+	}
+
+	for i := 0; i < len(base85Alphabet); i++ {
+		base85Values[base85Alphabet[i]] = i
+	}
+}
+
+// encodeBase85Lines writes data as lines of base85, every one prefixed by a
+// char giving the number of bytes it encodes: 'A' to 'Z' for 1 to 26 and 'a'
+// to 'z' for 27 to 52.
+func encodeBase85Lines(sb *strings.Builder, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > binaryLineSize {
+			n = binaryLineSize
+		}
+
+		if n <= 26 {
+			sb.WriteByte(byte('A' + n - 1))
+		} else {
+			sb.WriteByte(byte('a' + n - 27))
+		}
+
+		encodeBase85(sb, data[:n])
+		sb.WriteByte('\n')
+		data = data[n:]
+	}
+}
+
+// encodeBase85 writes every group of 4 bytes of data, padded with zeros, as 5
+// chars.
+func encodeBase85(sb *strings.Builder, data []byte) {
+	var chars [5]byte
+	for len(data) > 0 {
+		var acc uint32
+		for i := 0; i < 4; i++ {
+			acc <<= 8
+			if i < len(data) {
+				acc |= uint32(data[i])
+			}
+		}
+
+		for i := 4; i >= 0; i-- {
+			chars[i] = base85Alphabet[acc%85]
+			acc /= 85
+		}
+
+		sb.Write(chars[:])
+		if len(data) < 4 {
+			return
+		}
+
+		data = data[4:]
+	}
+}
+
+// decodeBase85Line decodes a line written by encodeBase85Lines, without its
+// newline.
+func decodeBase85Line(line string) ([]byte, error) {
+	if line == "" {
+		return nil, errInvalidBase85
+	}
+
+	var n int
+	switch c := line[0]; {
+	case c >= 'A' && c <= 'Z':
+		n = int(c-'A') + 1
+	case c >= 'a' && c <= 'z':
+		n = int(c-'a') + 27
+	default:
+		return nil, errInvalidBase85
+	}
+
+	line = line[1:]
+	if len(line) != (n+3)/4*5 {
+		return nil, errInvalidBase85
+	}
+
+	data := make([]byte, 0, len(line)/5*4)
+	for ; line != ""; line = line[5:] {
+		var acc uint64
+		for i := 0; i < 5; i++ {
+			v := base85Values[line[i]]
+			if v < 0 {
+				return nil, errInvalidBase85
+			}
+
+			acc = acc*85 + uint64(v)
+		}
+
+		if acc > 0xffffffff {
+			return nil, errInvalidBase85
+		}
+
+		data = append(data, byte(acc>>24), byte(acc>>16), byte(acc>>8), byte(acc))
+	}
+
+	return data[:n], nil
+}
@@ -0,0 +1,134 @@
+package diff
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+)
+
+// BinaryPatch is the full patch of a binary file, made of the fragment
+// turning the source file into the destination one, and optionally the
+// fragment doing the opposite.
+type BinaryPatch struct {
+	Forward *BinaryFragment
+	Reverse *BinaryFragment
+}
+
+// BinaryFragment is a part of a BinaryPatch.
+type BinaryFragment struct {
+	// Delta is true if Data is a delta to apply to the source file, in the
+	// format of the packfiles, instead of the content of the destination file.
+	Delta bool
+	// Data is the uncompressed data of the fragment.
+	Data []byte
+}
+
+// Apply returns the result of applying f to src.
+func (f *BinaryFragment) Apply(src []byte) ([]byte, error) {
+	if !f.Delta {
+		return f.Data, nil
+	}
+
+	return packfile.PatchDelta(src, f.Data)
+}
+
+// isFullBinary returns true if the filePatch is encoded as a full binary
+// patch, which requires the content of its files.
+func (e *UnifiedEncoder) isFullBinary(filePatch FilePatch) bool {
+	if !e.binary || !filePatch.IsBinary() {
+		return false
+	}
+
+	from, to := filePatch.Files()
+	if from != nil && to != nil && from.Hash() == to.Hash() {
+		return false
+	}
+
+	for _, f := range []File{from, to} {
+		if _, ok := f.(ContentFile); f != nil && !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// writeBinaryPatch writes the literal fragments of both directions of the
+// filePatch, following the "GIT binary patch" line.
+func writeBinaryPatch(sb *strings.Builder, filePatch FilePatch) error {
+	from, to := filePatch.Files()
+	src, err := fileContent(from)
+	if err != nil {
+		return err
+	}
+
+	dst, err := fileContent(to)
+	if err != nil {
+		return err
+	}
+
+	if err := writeBinaryLiteral(sb, dst); err != nil {
+		return err
+	}
+
+	return writeBinaryLiteral(sb, src)
+}
+
+func fileContent(f File) ([]byte, error) {
+	if f == nil {
+		return nil, nil
+	}
+
+	return f.(ContentFile).Content()
+}
+
+func writeBinaryLiteral(sb *strings.Builder, data []byte) error {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return err
+	}
+
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(sb, "literal %d\n", len(data))
+	encodeBase85Lines(sb, buf.Bytes())
+	sb.WriteByte('\n')
+	return nil
+}
+
+// decodeBinaryFragment decodes the fragment of the given kind, "literal" or
+// "delta", from the base85 lines, checking its uncompressed size.
+func decodeBinaryFragment(kind string, size int64, lines []string) (*BinaryFragment, error) {
+	var compressed []byte
+	for _, line := range lines {
+		data, err := decodeBase85Line(line)
+		if err != nil {
+			return nil, err
+		}
+
+		compressed = append(compressed, data...)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) != size {
+		return nil, fmt.Errorf("binary fragment size mismatch: expected %d, got %d", size, len(data))
+	}
+
+	return &BinaryFragment{Delta: kind == "delta", Data: data}, nil
+}
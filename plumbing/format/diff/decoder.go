@@ -0,0 +1,699 @@
+package diff
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+)
+
+// ErrMalformedPatch is returned by a Decoder when the patch can't be parsed.
+var ErrMalformedPatch = errors.New("malformed patch")
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@ ?(.*)$`)
+
+// ParsedPatch is a Patch decoded from its textual representation.
+type ParsedPatch struct {
+	// Header is the text preceding the first file patch.
+	Header string
+	// Files are the patches of every file.
+	Files []*ParsedFilePatch
+}
+
+// FilePatches returns the patches of every file.
+func (p *ParsedPatch) FilePatches() []FilePatch {
+	filePatches := make([]FilePatch, len(p.Files))
+	for i, fp := range p.Files {
+		filePatches[i] = fp
+	}
+
+	return filePatches
+}
+
+// Message returns the text preceding the first file patch.
+func (p *ParsedPatch) Message() string {
+	return p.Header
+}
+
+// Reverse returns the patch undoing p, with the file patches in the reverse
+// order. The binary patches without their reverse fragment can't be undone,
+// their Forward fragment being nil.
+func (p *ParsedPatch) Reverse() *ParsedPatch {
+	r := &ParsedPatch{Header: p.Header}
+	for i := len(p.Files) - 1; i >= 0; i-- {
+		r.Files = append(r.Files, p.Files[i].Reverse())
+	}
+
+	return r
+}
+
+// ParsedFilePatch is a FilePatch decoded from its textual representation. It
+// only knows the lines of the file included in its hunks.
+type ParsedFilePatch struct {
+	// From and To are the source and destination files, From being nil if
+	// the file is created and To being nil if it's deleted.
+	From, To *ParsedFile
+	// Copy is true if To is a copy of From instead of a rename of it.
+	Copy bool
+	// Similarity is the similarity index of a renamed or copied file, in
+	// percent.
+	Similarity int
+	// Binary is true if the file is a binary one.
+	Binary bool
+	// BinaryPatch is the full patch of a binary file, nil if it isn't
+	// included.
+	BinaryPatch *BinaryPatch
+	// Hunks are the changed parts of a text file.
+	Hunks []*Hunk
+}
+
+// IsBinary returns true if the patch is about a binary file.
+func (p *ParsedFilePatch) IsBinary() bool {
+	return p.Binary
+}
+
+// Files returns the source and destination files.
+func (p *ParsedFilePatch) Files() (from, to File) {
+	if p.From != nil {
+		from = p.From
+	}
+
+	if p.To != nil {
+		to = p.To
+	}
+
+	return from, to
+}
+
+// Reverse returns the file patch undoing p.
+func (p *ParsedFilePatch) Reverse() *ParsedFilePatch {
+	r := &ParsedFilePatch{
+		From:       p.To,
+		To:         p.From,
+		Copy:       p.Copy,
+		Similarity: p.Similarity,
+		Binary:     p.Binary,
+	}
+
+	if p.BinaryPatch != nil {
+		r.BinaryPatch = &BinaryPatch{
+			Forward: p.BinaryPatch.Reverse,
+			Reverse: p.BinaryPatch.Forward,
+		}
+	}
+
+	for _, h := range p.Hunks {
+		rh := &Hunk{
+			FromLine:  h.ToLine,
+			FromCount: h.ToCount,
+			ToLine:    h.FromLine,
+			ToCount:   h.FromCount,
+			Section:   h.Section,
+		}
+
+		for _, l := range h.Lines {
+			op := l.Op
+			switch op {
+			case Add:
+				op = Delete
+			case Delete:
+				op = Add
+			}
+
+			rh.Lines = append(rh.Lines, &HunkLine{Op: op, Text: l.Text})
+		}
+
+		r.Hunks = append(r.Hunks, rh)
+	}
+
+	return r
+}
+
+// Chunks returns the lines of the hunks, merged by operation.
+func (p *ParsedFilePatch) Chunks() []Chunk {
+	var chunks []Chunk
+	var last *parsedChunk
+	for _, h := range p.Hunks {
+		for _, l := range h.Lines {
+			if last != nil && last.op == l.Op {
+				last.content += l.Text
+				continue
+			}
+
+			last = &parsedChunk{content: l.Text, op: l.Op}
+			chunks = append(chunks, last)
+		}
+
+		last = nil
+	}
+
+	return chunks
+}
+
+type parsedChunk struct {
+	content string
+	op      Operation
+}
+
+func (c *parsedChunk) Content() string { return c.content }
+func (c *parsedChunk) Type() Operation { return c.op }
+
+// ParsedFile is a File decoded from a patch.
+type ParsedFile struct {
+	path string
+	mode filemode.FileMode
+	hash string
+}
+
+// Path returns the path of the file, without the prefix of the patch.
+func (f *ParsedFile) Path() string {
+	return f.path
+}
+
+// Mode returns the mode of the file, filemode.Empty if the patch doesn't
+// tell it.
+func (f *ParsedFile) Mode() filemode.FileMode {
+	return f.mode
+}
+
+// Hash returns the hash of the file, plumbing.ZeroHash if the patch only
+// contains an abbreviation of it.
+func (f *ParsedFile) Hash() plumbing.Hash {
+	if len(f.hash) != len(plumbing.ZeroHash)*2 {
+		return plumbing.ZeroHash
+	}
+
+	return plumbing.NewHash(f.hash)
+}
+
+// AbbreviatedHash returns the hash of the file as found in the patch, maybe
+// abbreviated, or an empty string if the patch doesn't tell it.
+func (f *ParsedFile) AbbreviatedHash() string {
+	return f.hash
+}
+
+// Hunk is a changed part of a text file.
+type Hunk struct {
+	// FromLine and FromCount are the position and the number of lines of
+	// the hunk in the source file, FromLine being the line preceding the
+	// hunk when FromCount is zero.
+	FromLine, FromCount int
+	// ToLine and ToCount are the same in the destination file.
+	ToLine, ToCount int
+	// Section is the text following the hunk header, usually the heading of
+	// the section of the file containing the hunk.
+	Section string
+	// Lines are the lines of the hunk.
+	Lines []*HunkLine
+}
+
+// HunkLine is a line of a Hunk.
+type HunkLine struct {
+	// Op is the operation of the line.
+	Op Operation
+	// Text is the content of the line, including its newline unless it's
+	// the last line of a file without one.
+	Text string
+}
+
+// Decoder reads and decodes patches in the unified format, as written by
+// git diff and the UnifiedEncoder, from an input stream.
+type Decoder struct {
+	r *bufio.Reader
+
+	lines []string
+	pos   int
+}
+
+// NewDecoder returns a new decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the whole stream and stores the decoded patch in p. The text
+// preceding the first file patch is kept as its header, the text between the
+// file patches which isn't part of them is ignored.
+func (d *Decoder) Decode(p *ParsedPatch) error {
+	if err := d.readLines(); err != nil {
+		return err
+	}
+
+	var header strings.Builder
+	for d.pos < len(d.lines) {
+		line := d.lines[d.pos]
+		var fp *ParsedFilePatch
+		var err error
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			fp, err = d.decodeGitFilePatch()
+		case strings.HasPrefix(line, "--- ") && strings.HasPrefix(d.peek(1), "+++ ") &&
+			strings.HasPrefix(d.peek(2), "@@ -"):
+			fp, err = d.decodeUnifiedFilePatch()
+		default:
+			if len(p.Files) == 0 {
+				header.WriteString(line)
+			}
+
+			d.pos++
+			continue
+		}
+
+		if err != nil {
+			return err
+		}
+
+		p.Files = append(p.Files, fp)
+	}
+
+	p.Header = header.String()
+	return nil
+}
+
+func (d *Decoder) readLines() error {
+	for {
+		line, err := d.r.ReadString('\n')
+		if line != "" {
+			d.lines = append(d.lines, line)
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// peek returns the n-th line after the current one, without its newline, or
+// an empty string at the end of the input.
+func (d *Decoder) peek(n int) string {
+	if d.pos+n >= len(d.lines) {
+		return ""
+	}
+
+	return strings.TrimSuffix(d.lines[d.pos+n], "\n")
+}
+
+func (d *Decoder) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: line %d: %s", ErrMalformedPatch, d.pos+1, fmt.Sprintf(format, args...))
+}
+
+// decodeGitFilePatch decodes a file patch starting with a "diff --git" line
+// followed by the extended header lines of git.
+func (d *Decoder) decodeGitFilePatch() (*ParsedFilePatch, error) {
+	fromPath, toPath := parseGitHeaderPaths(strings.TrimPrefix(d.peek(0), "diff --git "))
+	from := &ParsedFile{path: fromPath}
+	to := &ParsedFile{path: toPath}
+	fp := &ParsedFilePatch{From: from, To: to}
+	created, deleted := false, false
+
+	d.pos++
+	for d.pos < len(d.lines) {
+		line := d.peek(0)
+		var err error
+		switch {
+		case strings.HasPrefix(line, "old mode "):
+			from.mode, err = parseMode(strings.TrimPrefix(line, "old mode "))
+		case strings.HasPrefix(line, "new mode "):
+			to.mode, err = parseMode(strings.TrimPrefix(line, "new mode "))
+		case strings.HasPrefix(line, "deleted file mode "):
+			deleted = true
+			from.mode, err = parseMode(strings.TrimPrefix(line, "deleted file mode "))
+		case strings.HasPrefix(line, "new file mode "):
+			created = true
+			to.mode, err = parseMode(strings.TrimPrefix(line, "new file mode "))
+		case strings.HasPrefix(line, "rename from "):
+			from.path, err = parsePath(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			to.path, err = parsePath(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy from "):
+			fp.Copy = true
+			from.path, err = parsePath(strings.TrimPrefix(line, "copy from "))
+		case strings.HasPrefix(line, "copy to "):
+			fp.Copy = true
+			to.path, err = parsePath(strings.TrimPrefix(line, "copy to "))
+		case strings.HasPrefix(line, "similarity index "):
+			fp.Similarity, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(line, "similarity index "), "%"))
+		case strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, "index "):
+			err = parseIndexLine(strings.TrimPrefix(line, "index "), from, to)
+		default:
+			if err := d.decodeFilePatchBody(fp, from, to); err != nil {
+				return nil, err
+			}
+
+			return finishFilePatch(fp, created, deleted), nil
+		}
+
+		if err != nil {
+			return nil, d.errorf("%s", err)
+		}
+
+		d.pos++
+	}
+
+	return finishFilePatch(fp, created, deleted), nil
+}
+
+func finishFilePatch(fp *ParsedFilePatch, created, deleted bool) *ParsedFilePatch {
+	if fp.From.mode == filemode.Empty {
+		fp.From.mode = fp.To.mode
+	}
+
+	if fp.To.mode == filemode.Empty {
+		fp.To.mode = fp.From.mode
+	}
+
+	if created {
+		fp.From = nil
+	}
+
+	if deleted {
+		fp.To = nil
+	}
+
+	return fp
+}
+
+// decodeFilePatchBody decodes the part of a git file patch following its
+// extended header lines, if any.
+func (d *Decoder) decodeFilePatchBody(fp *ParsedFilePatch, from, to *ParsedFile) error {
+	line := d.peek(0)
+	switch {
+	case strings.HasPrefix(line, "--- ") && strings.HasPrefix(d.peek(1), "+++ "):
+		fromPath, err := parsePath(strings.TrimPrefix(line, "--- "))
+		if err != nil {
+			return d.errorf("%s", err)
+		}
+
+		toPath, err := parsePath(strings.TrimPrefix(d.peek(1), "+++ "))
+		if err != nil {
+			return d.errorf("%s", err)
+		}
+
+		if fromPath != "/dev/null" && from.path == "" {
+			from.path = stripPathPrefix(fromPath)
+		}
+
+		if toPath != "/dev/null" && to.path == "" {
+			to.path = stripPathPrefix(toPath)
+		}
+
+		d.pos += 2
+		return d.decodeHunks(fp)
+	case strings.HasPrefix(line, "Binary files ") && strings.HasSuffix(line, " differ"):
+		fp.Binary = true
+		d.pos++
+	case line == "GIT binary patch":
+		fp.Binary = true
+		d.pos++
+		return d.decodeBinaryPatch(fp)
+	}
+
+	return nil
+}
+
+// decodeUnifiedFilePatch decodes a file patch without the header lines of git,
+// starting with its "---" and "+++" lines.
+func (d *Decoder) decodeUnifiedFilePatch() (*ParsedFilePatch, error) {
+	fromPath, err := parsePath(strings.TrimPrefix(d.peek(0), "--- "))
+	if err != nil {
+		return nil, d.errorf("%s", err)
+	}
+
+	toPath, err := parsePath(strings.TrimPrefix(d.peek(1), "+++ "))
+	if err != nil {
+		return nil, d.errorf("%s", err)
+	}
+
+	fp := &ParsedFilePatch{}
+	if fromPath != "/dev/null" {
+		fp.From = &ParsedFile{path: stripPathPrefix(fromPath)}
+	}
+
+	if toPath != "/dev/null" {
+		fp.To = &ParsedFile{path: stripPathPrefix(toPath)}
+	}
+
+	d.pos += 2
+	return fp, d.decodeHunks(fp)
+}
+
+func (d *Decoder) decodeHunks(fp *ParsedFilePatch) error {
+	for d.pos < len(d.lines) {
+		m := hunkHeaderRegexp.FindStringSubmatch(d.peek(0))
+		if m == nil {
+			return nil
+		}
+
+		h := &Hunk{Section: m[5]}
+		h.FromLine, h.FromCount = parseRange(m[1], m[2])
+		h.ToLine, h.ToCount = parseRange(m[3], m[4])
+		d.pos++
+
+		if err := d.decodeHunkLines(h); err != nil {
+			return err
+		}
+
+		fp.Hunks = append(fp.Hunks, h)
+	}
+
+	return nil
+}
+
+func parseRange(start, count string) (int, int) {
+	s, _ := strconv.Atoi(start)
+	if count == "" {
+		return s, 1
+	}
+
+	c, _ := strconv.Atoi(count)
+	return s, c
+}
+
+// decodeHunkLines decodes the lines of h, as many as told by its header. An
+// empty line is taken as a context one, its leading space being often
+// removed by mailers.
+func (d *Decoder) decodeHunkLines(h *Hunk) error {
+	from, to := h.FromCount, h.ToCount
+	for from > 0 || to > 0 || strings.HasPrefix(d.peek(0), "\\") {
+		if d.pos >= len(d.lines) {
+			return d.errorf("truncated hunk")
+		}
+
+		line := d.lines[d.pos]
+		l := &HunkLine{}
+		switch line[0] {
+		case ' ', '\n':
+			l.Op = Equal
+			from--
+			to--
+		case '-':
+			l.Op = Delete
+			from--
+		case '+':
+			l.Op = Add
+			to--
+		case '\\':
+			if len(h.Lines) == 0 {
+				return d.errorf("unexpected %q", strings.TrimSuffix(line, "\n"))
+			}
+
+			last := h.Lines[len(h.Lines)-1]
+			last.Text = strings.TrimSuffix(last.Text, "\n")
+			d.pos++
+			continue
+		default:
+			return d.errorf("unexpected %q in hunk", strings.TrimSuffix(line, "\n"))
+		}
+
+		if from < 0 || to < 0 {
+			return d.errorf("hunk longer than its header")
+		}
+
+		if line != "\n" {
+			line = line[1:]
+		}
+
+		l.Text = line
+		h.Lines = append(h.Lines, l)
+		d.pos++
+	}
+
+	return nil
+}
+
+// decodeBinaryPatch decodes the fragments following a "GIT binary patch"
+// line.
+func (d *Decoder) decodeBinaryPatch(fp *ParsedFilePatch) error {
+	forward, err := d.decodeBinaryFragment()
+	if err != nil {
+		return err
+	}
+
+	if forward == nil {
+		return d.errorf("missing binary patch data")
+	}
+
+	reverse, err := d.decodeBinaryFragment()
+	if err != nil {
+		return err
+	}
+
+	fp.BinaryPatch = &BinaryPatch{Forward: forward, Reverse: reverse}
+	return nil
+}
+
+// decodeBinaryFragment decodes a "literal" or "delta" fragment, ended by an
+// empty line. It returns nil if there is none at the current line.
+func (d *Decoder) decodeBinaryFragment() (*BinaryFragment, error) {
+	fields := strings.Fields(d.peek(0))
+	if len(fields) != 2 || (fields[0] != "literal" && fields[0] != "delta") {
+		return nil, nil
+	}
+
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil, d.errorf("%s", err)
+	}
+
+	start := d.pos
+	d.pos++
+
+	var lines []string
+	for ; d.pos < len(d.lines) && d.peek(0) != ""; d.pos++ {
+		lines = append(lines, d.peek(0))
+	}
+
+	if d.pos >= len(d.lines) {
+		return nil, d.errorf("truncated binary patch")
+	}
+
+	d.pos++
+	f, err := decodeBinaryFragment(fields[0], size, lines)
+	if err != nil {
+		d.pos = start
+		return nil, d.errorf("%s", err)
+	}
+
+	return f, nil
+}
+
+// parseGitHeaderPaths returns the paths of the "diff --git" line, without
+// their prefix. Unquoted paths containing spaces are only found if both are
+// the same, the other header lines giving them otherwise.
+func parseGitHeaderPaths(s string) (from, to string) {
+	if strings.HasPrefix(s, `"`) {
+		q, err := quotedPrefix(s)
+		if err != nil {
+			return "", ""
+		}
+
+		from, _ = strconv.Unquote(q)
+		to, _ = parsePath(strings.TrimPrefix(s[len(q):], " "))
+		return stripPathPrefix(from), stripPathPrefix(to)
+	}
+
+	if i := strings.Index(s, ` "`); i >= 0 {
+		to, _ = parsePath(s[i+1:])
+		return stripPathPrefix(s[:i]), stripPathPrefix(to)
+	}
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			continue
+		}
+
+		from, to = stripPathPrefix(s[:i]), stripPathPrefix(s[i+1:])
+		if from == to {
+			return from, to
+		}
+	}
+
+	return "", ""
+}
+
+// parsePath returns the path of a header line, unquoting it if needed and
+// removing the trailing timestamp of the ---/+++ lines of GNU diff.
+func parsePath(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		q, err := quotedPrefix(s)
+		if err != nil {
+			return "", err
+		}
+
+		return strconv.Unquote(q)
+	}
+
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+
+	return s, nil
+}
+
+// quotedPrefix returns the quoted string at the beginning of s.
+func quotedPrefix(s string) (string, error) {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return s[:i+1], nil
+		}
+	}
+
+	return "", fmt.Errorf("unterminated quoted path %s", s)
+}
+
+// stripPathPrefix removes the first component of path, as the a/ and b/
+// prefixes.
+func stripPathPrefix(path string) string {
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		return path[i+1:]
+	}
+
+	return path
+}
+
+func parseMode(s string) (filemode.FileMode, error) {
+	m, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil {
+		return filemode.Empty, err
+	}
+
+	return filemode.FileMode(m), nil
+}
+
+// parseIndexLine parses the hashes and the optional mode of an index line.
+func parseIndexLine(s string, from, to *ParsedFile) error {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("invalid index line %q", s)
+	}
+
+	hashes := strings.SplitN(fields[0], "..", 2)
+	if len(hashes) != 2 {
+		return fmt.Errorf("invalid index line %q", s)
+	}
+
+	from.hash, to.hash = hashes[0], hashes[1]
+	if len(fields) == 2 {
+		mode, err := parseMode(fields[1])
+		if err != nil {
+			return err
+		}
+
+		from.mode, to.mode = mode, mode
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+
+	. "gopkg.in/check.v1"
+)
+
+type DecoderTestSuite struct{}
+
+var _ = Suite(&DecoderTestSuite{})
+
+const decoderGitPatch = `Some header
+---
+diff --git a/a.txt b/a.txt
+index 2019eda..b91241c 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,7 +1,7 @@ section
+ one
+-two
++2
+ three
+ four
+ five
+ six
+-seven
++seven
+\ No newline at end of file
+diff --git a/bin b/bin
+index 88768efdf77ec78c9a995f94881793be6a41752b..7e0b112495bc3ee327e8d333557467775a85739c 100644
+GIT binary patch
+literal 6
+NcmZQzO3KXp4*&$N0y_Wz
+
+literal 5
+McmZQzOv=my00M6TI{*Lx
+
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index abaddc0..0000000
+--- a/gone.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-del
+diff --git a/old.txt b/new.txt
+similarity index 100%
+rename from old.txt
+rename to new.txt
+diff --git a/sp ace.txt b/sp ace.txt
+old mode 100644
+new mode 100755
+diff --git "a/\303\251.txt" "b/\303\251.txt"
+new file mode 100644
+index 0000000..45a6154
+--- /dev/null
++++ "b/\303\251.txt"
+@@ -0,0 +1 @@
++hé
+-- 
+2.39.5
+`
+
+func (s *DecoderTestSuite) TestDecodeGitPatch(c *C) {
+	var p ParsedPatch
+	err := NewDecoder(strings.NewReader(decoderGitPatch)).Decode(&p)
+	c.Assert(err, IsNil)
+	c.Assert(p.Message(), Equals, "Some header\n---\n")
+	c.Assert(p.Files, HasLen, 6)
+
+	text := p.Files[0]
+	c.Assert(text.From.Path(), Equals, "a.txt")
+	c.Assert(text.To.Path(), Equals, "a.txt")
+	c.Assert(text.From.Mode(), Equals, filemode.Regular)
+	c.Assert(text.From.AbbreviatedHash(), Equals, "2019eda")
+	c.Assert(text.From.Hash(), Equals, plumbing.ZeroHash)
+	c.Assert(text.Hunks, HasLen, 1)
+
+	h := text.Hunks[0]
+	c.Assert(h.FromLine, Equals, 1)
+	c.Assert(h.FromCount, Equals, 7)
+	c.Assert(h.ToLine, Equals, 1)
+	c.Assert(h.ToCount, Equals, 7)
+	c.Assert(h.Section, Equals, "section")
+	c.Assert(h.Lines, HasLen, 9)
+	c.Assert(h.Lines[1], DeepEquals, &HunkLine{Op: Delete, Text: "two\n"})
+	c.Assert(h.Lines[8], DeepEquals, &HunkLine{Op: Add, Text: "seven"})
+
+	chunks := text.Chunks()
+	c.Assert(chunks, HasLen, 6)
+	c.Assert(chunks[3].Content(), Equals, "three\nfour\nfive\nsix\n")
+
+	bin := p.Files[1]
+	c.Assert(bin.IsBinary(), Equals, true)
+	c.Assert(bin.From.Hash(), Equals, plumbing.NewHash("88768efdf77ec78c9a995f94881793be6a41752b"))
+	c.Assert(bin.BinaryPatch.Forward, DeepEquals, &BinaryFragment{Data: []byte("\x00\x02bin\xff")})
+	c.Assert(bin.BinaryPatch.Reverse, DeepEquals, &BinaryFragment{Data: []byte("\x00\x01bin")})
+
+	gone := p.Files[2]
+	c.Assert(gone.To, IsNil)
+	c.Assert(gone.From.Path(), Equals, "gone.txt")
+	c.Assert(gone.From.Mode(), Equals, filemode.Regular)
+	from, to := gone.Files()
+	c.Assert(from, NotNil)
+	c.Assert(to, IsNil)
+
+	rename := p.Files[3]
+	c.Assert(rename.From.Path(), Equals, "old.txt")
+	c.Assert(rename.To.Path(), Equals, "new.txt")
+	c.Assert(rename.Similarity, Equals, 100)
+	c.Assert(rename.Copy, Equals, false)
+	c.Assert(rename.Hunks, HasLen, 0)
+
+	mode := p.Files[4]
+	c.Assert(mode.From.Path(), Equals, "sp ace.txt")
+	c.Assert(mode.From.Mode(), Equals, filemode.Regular)
+	c.Assert(mode.To.Mode(), Equals, filemode.Executable)
+
+	created := p.Files[5]
+	c.Assert(created.From, IsNil)
+	c.Assert(created.To.Path(), Equals, "é.txt")
+	c.Assert(created.To.Mode(), Equals, filemode.Regular)
+	c.Assert(created.Hunks[0].FromLine, Equals, 0)
+	c.Assert(created.Hunks[0].FromCount, Equals, 0)
+	c.Assert(created.Hunks[0].Lines, DeepEquals, []*HunkLine{{Op: Add, Text: "hé\n"}})
+}
+
+func (s *DecoderTestSuite) TestDecodeUnifiedPatch(c *C) {
+	var p ParsedPatch
+	err := NewDecoder(strings.NewReader(`--- a/file	2020-01-01 00:00:00
++++ b/file	2020-01-01 00:00:00
+@@ -1,3 +1,3 @@
+ a
+
+-c
++d
+`)).Decode(&p)
+	c.Assert(err, IsNil)
+	c.Assert(p.Files, HasLen, 1)
+	c.Assert(p.Files[0].From.Path(), Equals, "file")
+	c.Assert(p.Files[0].From.Mode(), Equals, filemode.Empty)
+	c.Assert(p.Files[0].Hunks[0].Lines, DeepEquals, []*HunkLine{
+		{Op: Equal, Text: "a\n"},
+		{Op: Equal, Text: "\n"},
+		{Op: Delete, Text: "c\n"},
+		{Op: Add, Text: "d\n"},
+	})
+}
+
+func (s *DecoderTestSuite) TestDecodeTruncatedHunk(c *C) {
+	var p ParsedPatch
+	err := NewDecoder(strings.NewReader(`diff --git a/file b/file
+--- a/file
++++ b/file
+@@ -1,3 +1,3 @@
+ a
+-c
+`)).Decode(&p)
+	c.Assert(err, ErrorMatches, "malformed patch: line 7: truncated hunk")
+}
+
+func (s *DecoderTestSuite) TestDecodeInvalidBinaryPatch(c *C) {
+	var p ParsedPatch
+	err := NewDecoder(strings.NewReader(`diff --git a/bin b/bin
+GIT binary patch
+literal 6
+NcmZQzO3KXp5*&$N0y_Wz
+
+`)).Decode(&p)
+	c.Assert(err, ErrorMatches, "malformed patch: line 3: .*")
+}
+
+func (s *DecoderTestSuite) TestEncodeDecode(c *C) {
+	for _, f := range fixtures {
+		if f.color != nil {
+			continue
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		err := NewUnifiedEncoder(buffer, f.context).Encode(f.patch)
+		c.Assert(err, IsNil)
+
+		var p ParsedPatch
+		err = NewDecoder(bytes.NewReader(buffer.Bytes())).Decode(&p)
+		c.Assert(err, IsNil, Commentf("%s", f.desc))
+
+		for i, fp := range f.patch.FilePatches() {
+			added, deleted := countLines(fp.Chunks())
+			parsedAdded, parsedDeleted := countLines(p.Files[i].Chunks())
+			c.Assert(parsedAdded, Equals, added, Commentf("%s", f.desc))
+			c.Assert(parsedDeleted, Equals, deleted, Commentf("%s", f.desc))
+		}
+	}
+}
+
+func countLines(chunks []Chunk) (added, deleted int) {
+	for _, c := range chunks {
+		switch c.Type() {
+		case Add:
+			added += len(splitLines(c.Content()))
+		case Delete:
+			deleted += len(splitLines(c.Content()))
+		}
+	}
+
+	return added, deleted
+}
+
+func (s *DecoderTestSuite) TestReverse(c *C) {
+	var p ParsedPatch
+	err := NewDecoder(strings.NewReader(decoderGitPatch)).Decode(&p)
+	c.Assert(err, IsNil)
+
+	r := p.Reverse()
+	c.Assert(r.Files, HasLen, 6)
+
+	created := r.Files[0]
+	c.Assert(created.To, IsNil)
+	c.Assert(created.From.Path(), Equals, "é.txt")
+	c.Assert(created.Hunks[0].FromCount, Equals, 1)
+	c.Assert(created.Hunks[0].ToCount, Equals, 0)
+	c.Assert(created.Hunks[0].Lines, DeepEquals, []*HunkLine{{Op: Delete, Text: "hé\n"}})
+
+	rename := r.Files[2]
+	c.Assert(rename.From.Path(), Equals, "new.txt")
+	c.Assert(rename.To.Path(), Equals, "old.txt")
+
+	bin := r.Files[4]
+	c.Assert(bin.BinaryPatch.Forward.Data, DeepEquals, []byte("\x00\x01bin"))
+	c.Assert(bin.BinaryPatch.Reverse.Data, DeepEquals, []byte("\x00\x02bin\xff"))
+
+	c.Assert(r.Reverse(), DeepEquals, &p)
+}
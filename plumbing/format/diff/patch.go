@@ -56,3 +56,20 @@ type Chunk interface {
 	// Type contains the Operation to do with this Chunk.
 	Type() Operation
 }
+
+// IgnorableChunk is a Chunk which can be left out of a patch representation,
+// as the changes made only of blank lines when blank lines are ignored. Such
+// chunks are only shown when they are near other changes.
+type IgnorableChunk interface {
+	Chunk
+	// Ignorable returns true if the Chunk can be left out.
+	Ignorable() bool
+}
+
+// ContentFile is a File which provides its content, needed to encode the full
+// binary patches of the binary files.
+type ContentFile interface {
+	File
+	// Content returns the content of the File.
+	Content() ([]byte, error)
+}
@@ -0,0 +1,488 @@
+package diff
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultStatWidth is the default width of the lines written in the
+	// Stat format.
+	DefaultStatWidth = 80
+	// DefaultDirStatLimit is the default percentage of the changed lines a
+	// directory must reach to be shown in the DirStat format.
+	DefaultDirStatLimit = 3.0
+)
+
+// StatFormat is the format of the statistics written by a StatEncoder.
+type StatFormat int
+
+const (
+	// Stat is the format of git diff --stat: a line per file with its number
+	// of changed lines and a histogram, followed by a summary line.
+	Stat StatFormat = iota
+	// NumStat is the format of git diff --numstat: a line per file with its
+	// number of added and deleted lines, meant to be parsed.
+	NumStat
+	// DirStat is the format of git diff --dirstat=lines: the percentage of
+	// the changed lines belonging to every directory.
+	DirStat
+)
+
+// StatEncoder encodes the statistics of the changed lines of a patch into the
+// provided Writer. The sizes of the binary files are only shown when their
+// files implement ContentFile, and the DirStat format counts every binary
+// file as a single changed line.
+type StatEncoder struct {
+	io.Writer
+
+	// format is the format of the statistics.
+	format StatFormat
+
+	// width is the maximum width of the lines of the Stat format.
+	width int
+
+	// dirStatLimit is the minimum per mille of the changed lines a directory
+	// must reach to be shown by the DirStat format.
+	dirStatLimit int
+
+	// cumulative is whether the changes of the shown subdirectories are
+	// counted in their parent directories by the DirStat format.
+	cumulative bool
+
+	// colorConfig is the color configuration. The default is no color.
+	color ColorConfig
+}
+
+// NewStatEncoder returns a new StatEncoder that writes to w.
+func NewStatEncoder(w io.Writer, format StatFormat) *StatEncoder {
+	return &StatEncoder{
+		Writer:       w,
+		format:       format,
+		width:        DefaultStatWidth,
+		dirStatLimit: int(DefaultDirStatLimit * 10),
+	}
+}
+
+// SetWidth sets the maximum width of the lines of the Stat format and returns
+// e.
+func (e *StatEncoder) SetWidth(width int) *StatEncoder {
+	e.width = width
+	return e
+}
+
+// SetDirStatLimit sets the minimum percentage of the changed lines a
+// directory must reach to be shown by the DirStat format, and returns e.
+func (e *StatEncoder) SetDirStatLimit(percent float64) *StatEncoder {
+	e.dirStatLimit = int(percent * 10)
+	return e
+}
+
+// SetCumulative sets whether the changes of the subdirectories shown by the
+// DirStat format are also counted in their parent directories, and returns e.
+func (e *StatEncoder) SetCumulative(cumulative bool) *StatEncoder {
+	e.cumulative = cumulative
+	return e
+}
+
+// SetColor sets e's color configuration and returns e.
+func (e *StatEncoder) SetColor(colorConfig ColorConfig) *StatEncoder {
+	e.color = colorConfig
+	return e
+}
+
+// Encode encodes the statistics of patch.
+func (e *StatEncoder) Encode(patch Patch) error {
+	stats := newFileStats(patch)
+
+	sb := &strings.Builder{}
+	switch e.format {
+	case NumStat:
+		e.writeNumStat(sb, stats)
+	case DirStat:
+		e.writeDirStat(sb, stats)
+	default:
+		e.writeStat(sb, stats)
+	}
+
+	_, err := e.Write([]byte(sb.String()))
+	return err
+}
+
+// fileStat is the number of changed lines of a file.
+type fileStat struct {
+	// name is the name shown, with both paths in case of rename.
+	name string
+	// path is the path of the file, the destination one in case of rename.
+	path           string
+	added, deleted int
+	binary         bool
+	// sizes is the text describing the sizes of a binary file, if known.
+	sizes string
+	// interesting is true if the file is shown even without changed lines.
+	interesting bool
+}
+
+func newFileStats(patch Patch) []*fileStat {
+	var stats []*fileStat
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		if from == nil && to == nil {
+			continue
+		}
+
+		s := &fileStat{binary: fp.IsBinary(), interesting: true}
+		if s.binary {
+			s.sizes = binarySizes(from, to)
+		}
+
+		switch {
+		case from == nil:
+			s.name, s.path = to.Path(), to.Path()
+		case to == nil:
+			s.name, s.path = from.Path(), from.Path()
+		default:
+			s.name, s.path = renameName(from.Path(), to.Path()), to.Path()
+			s.interesting = s.binary || from.Path() != to.Path() || from.Mode() != to.Mode()
+		}
+
+		for _, c := range fp.Chunks() {
+			switch c.Type() {
+			case Add:
+				s.added += len(splitLines(c.Content()))
+			case Delete:
+				s.deleted += len(splitLines(c.Content()))
+			}
+		}
+
+		stats = append(stats, s)
+	}
+
+	return stats
+}
+
+// binarySizes returns the sizes of both files as " 1 -> 2 bytes", or an
+// empty string if they are unknown.
+func binarySizes(from, to File) string {
+	var sizes [2]int
+	for i, f := range []File{from, to} {
+		if f == nil {
+			continue
+		}
+
+		cf, ok := f.(ContentFile)
+		if !ok {
+			return ""
+		}
+
+		content, err := cf.Content()
+		if err != nil {
+			return ""
+		}
+
+		sizes[i] = len(content)
+	}
+
+	return fmt.Sprintf(" %d -> %d bytes", sizes[0], sizes[1])
+}
+
+// renameName returns the name of a renamed file, with the common leading and
+// trailing directories of both paths shown once, as "dir/{a => b}/file". See
+// https://github.com/git/git/blob/v2.26.2/diff.c#L2180-L2253.
+func renameName(a, b string) string {
+	if a == b {
+		return a
+	}
+
+	prefix := 0
+	for i := 0; i < len(a) && i < len(b) && a[i] == b[i]; i++ {
+		if a[i] == '/' {
+			prefix = i + 1
+		}
+	}
+
+	// When there is a common prefix, the loop runs one char into it to see
+	// its trailing slash.
+	adjust := 0
+	if prefix > 0 {
+		adjust = 1
+	}
+
+	suffix := 0
+	for i, j := len(a)-1, len(b)-1; i >= prefix-adjust && j >= prefix-adjust && a[i] == b[j]; i, j = i-1, j-1 {
+		if a[i] == '/' {
+			suffix = len(a) - i
+		}
+	}
+
+	aMid := len(a) - prefix - suffix
+	if aMid < 0 {
+		aMid = 0
+	}
+
+	bMid := len(b) - prefix - suffix
+	if bMid < 0 {
+		bMid = 0
+	}
+
+	name := a[prefix:prefix+aMid] + " => " + b[prefix:prefix+bMid]
+	if prefix+suffix == 0 {
+		return name
+	}
+
+	return a[:prefix] + "{" + name + "}" + a[len(a)-suffix:]
+}
+
+func (e *StatEncoder) writeNumStat(sb *strings.Builder, stats []*fileStat) {
+	for _, s := range stats {
+		if s.binary {
+			fmt.Fprintf(sb, "-\t-\t%s\n", s.name)
+			continue
+		}
+
+		fmt.Fprintf(sb, "%d\t%d\t%s\n", s.added, s.deleted, s.name)
+	}
+}
+
+// writeStat writes the stats with the layout of git. See
+// https://github.com/git/git/blob/v2.26.2/diff.c#L2427-L2700.
+func (e *StatEncoder) writeStat(sb *strings.Builder, stats []*fileStat) {
+	var shown []*fileStat
+	var maxLen, maxChange, numberWidth int
+	for _, s := range stats {
+		if !s.interesting && s.added+s.deleted == 0 {
+			continue
+		}
+
+		shown = append(shown, s)
+		if n := utf8.RuneCountInString(s.name); n > maxLen {
+			maxLen = n
+		}
+
+		if s.binary {
+			numberWidth = len("Bin")
+			continue
+		}
+
+		if c := s.added + s.deleted; c > maxChange {
+			maxChange = c
+		}
+	}
+
+	if w := len(strconv.Itoa(maxChange)); w > numberWidth {
+		numberWidth = w
+	}
+
+	width := e.width
+	if width < 16+6+numberWidth {
+		width = 16 + 6 + numberWidth
+	}
+
+	graphWidth, nameWidth := maxChange, maxLen
+	if nameWidth+numberWidth+6+graphWidth > width {
+		if graphWidth > width*3/8-numberWidth-6 {
+			graphWidth = width*3/8 - numberWidth - 6
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+
+		if nameWidth > width-numberWidth-6-graphWidth {
+			nameWidth = width - numberWidth - 6 - graphWidth
+		} else {
+			graphWidth = width - numberWidth - 6 - nameWidth
+		}
+	}
+
+	var insertions, deletions int
+	for _, s := range shown {
+		prefix, name := "", s.name
+		n := nameWidth
+		if nameWidth < utf8.RuneCountInString(name) {
+			prefix = "..."
+			n -= 3
+			if n < 0 {
+				n = 0
+			}
+
+			for utf8.RuneCountInString(name) > n {
+				_, size := utf8.DecodeRuneInString(name)
+				name = name[size:]
+			}
+
+			if i := strings.IndexByte(name, '/'); i >= 0 {
+				name = name[i:]
+			}
+		}
+
+		padding := n - utf8.RuneCountInString(name)
+		if padding < 0 {
+			padding = 0
+		}
+
+		if s.binary {
+			fmt.Fprintf(sb, " %s%s%*s | %*s%s\n", prefix, name, padding, "", numberWidth, "Bin", s.sizes)
+			continue
+		}
+
+		insertions += s.added
+		deletions += s.deleted
+
+		add, del := s.added, s.deleted
+		if graphWidth <= maxChange {
+			total := scaleLinear(add+del, graphWidth, maxChange)
+			if total < 2 && add > 0 && del > 0 {
+				total = 2
+			}
+
+			if add < del {
+				add = scaleLinear(add, graphWidth, maxChange)
+				del = total - add
+			} else {
+				del = scaleLinear(del, graphWidth, maxChange)
+				add = total - del
+			}
+		}
+
+		fmt.Fprintf(sb, " %s%s%*s | %*d", prefix, name, padding, "", numberWidth, s.added+s.deleted)
+		if s.added+s.deleted != 0 {
+			sb.WriteByte(' ')
+		}
+
+		e.writeGraph(sb, '+', add, New)
+		e.writeGraph(sb, '-', del, Old)
+		sb.WriteByte('\n')
+	}
+
+	writeStatSummary(sb, len(shown), insertions, deletions)
+}
+
+func scaleLinear(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+
+	return 1 + n*(width-1)/max
+}
+
+func (e *StatEncoder) writeGraph(sb *strings.Builder, c byte, n int, key ColorKey) {
+	if n <= 0 {
+		return
+	}
+
+	sb.WriteString(e.color[key])
+	sb.WriteString(strings.Repeat(string(c), n))
+	sb.WriteString(e.color.Reset(key))
+}
+
+func writeStatSummary(sb *strings.Builder, files, insertions, deletions int) {
+	if files == 0 {
+		sb.WriteString(" 0 files changed\n")
+		return
+	}
+
+	fmt.Fprintf(sb, " %d %s changed", files, plural(files, "file", "files"))
+	if insertions != 0 || deletions == 0 {
+		fmt.Fprintf(sb, ", %d %s(+)", insertions, plural(insertions, "insertion", "insertions"))
+	}
+
+	if deletions != 0 || insertions == 0 {
+		fmt.Fprintf(sb, ", %d %s(-)", deletions, plural(deletions, "deletion", "deletions"))
+	}
+
+	sb.WriteByte('\n')
+}
+
+func plural(n int, one, other string) string {
+	if n == 1 {
+		return one
+	}
+
+	return other
+}
+
+type dirStatFile struct {
+	path    string
+	changes int
+}
+
+// dirStat walks the changed files sorted by path, gathering the changes of
+// every directory.
+type dirStat struct {
+	files []dirStatFile
+	total int
+	sb    *strings.Builder
+	e     *StatEncoder
+}
+
+func (e *StatEncoder) writeDirStat(sb *strings.Builder, stats []*fileStat) {
+	d := &dirStat{sb: sb, e: e}
+	for _, s := range stats {
+		changes := s.added + s.deleted
+		if s.binary {
+			changes = 1
+		}
+
+		if changes == 0 {
+			continue
+		}
+
+		d.files = append(d.files, dirStatFile{s.path, changes})
+		d.total += changes
+	}
+
+	if d.total == 0 {
+		return
+	}
+
+	sort.Slice(d.files, func(i, j int) bool {
+		return d.files[i].path < d.files[j].path
+	})
+
+	d.gather("")
+}
+
+// gather consumes the files under base, writing the directories above the
+// limit, and returns the number of their changed lines not yet reported. See
+// https://github.com/git/git/blob/v2.26.2/diff.c#L2894-L2950.
+func (d *dirStat) gather(base string) int {
+	changes, sources := 0, 0
+	for len(d.files) > 0 {
+		f := d.files[0]
+		if !strings.HasPrefix(f.path, base) {
+			break
+		}
+
+		if i := strings.IndexByte(f.path[len(base):], '/'); i >= 0 {
+			changes += d.gather(f.path[:len(base)+i+1])
+			sources++
+			continue
+		}
+
+		changes += f.changes
+		d.files = d.files[1:]
+		sources += 2
+	}
+
+	// The top level and the directories whose changes all come from a
+	// single subdirectory are not shown.
+	if base == "" || sources == 1 || changes == 0 {
+		return changes
+	}
+
+	permille := changes * 1000 / d.total
+	if permille < d.e.dirStatLimit {
+		return changes
+	}
+
+	fmt.Fprintf(d.sb, "%4d.%01d%% %s\n", permille/10, permille%10, base)
+	if d.e.cumulative {
+		return changes
+	}
+
+	return 0
+}
@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+
+	. "gopkg.in/check.v1"
+)
+
+type StatEncoderTestSuite struct{}
+
+var _ = Suite(&StatEncoderTestSuite{})
+
+func statFilePatch(from, to string, added, deleted int) testFilePatch {
+	fp := testFilePatch{chunks: []testChunk{{content: "same\n", op: Equal}}}
+	if from != "" {
+		fp.from = &testFile{mode: filemode.Regular, path: from, seed: from}
+	}
+
+	if to != "" {
+		fp.to = &testFile{mode: filemode.Regular, path: to, seed: to}
+	}
+
+	if added > 0 {
+		fp.chunks = append(fp.chunks, testChunk{content: strings.Repeat("+\n", added), op: Add})
+	}
+
+	if deleted > 0 {
+		fp.chunks = append(fp.chunks, testChunk{content: strings.Repeat("-\n", deleted), op: Delete})
+	}
+
+	return fp
+}
+
+var statPatch = testPatch{
+	filePatches: []testFilePatch{
+		statFilePatch("a/b/f1", "a/b/f1", 5, 0),
+		statFilePatch("a/f2", "a/f2", 0, 2),
+		{
+			from: &testFile{mode: filemode.Regular, path: "bin", seed: "foo"},
+			to:   &testFile{mode: filemode.Regular, path: "bin", seed: "bar"},
+		},
+		statFilePatch("c/f3", "c/f3", 1, 0),
+		statFilePatch("ren", "c/ren2", 1, 0),
+		statFilePatch("", "newfile", 1, 0),
+		statFilePatch("same", "same", 0, 0),
+	},
+}
+
+func (s *StatEncoderTestSuite) encode(c *C, p Patch, e *StatEncoder, buffer *bytes.Buffer) string {
+	c.Assert(e.Encode(p), IsNil)
+	return buffer.String()
+}
+
+func (s *StatEncoderTestSuite) TestStat(c *C) {
+	buffer := bytes.NewBuffer(nil)
+	c.Assert(s.encode(c, statPatch, NewStatEncoder(buffer, Stat), buffer), Equals, ""+
+		" a/b/f1        |   5 +++++\n"+
+		" a/f2          |   2 --\n"+
+		" bin           | Bin 3 -> 3 bytes\n"+
+		" c/f3          |   1 +\n"+
+		" ren => c/ren2 |   1 +\n"+
+		" newfile       |   1 +\n"+
+		" 6 files changed, 8 insertions(+), 2 deletions(-)\n")
+}
+
+func (s *StatEncoderTestSuite) TestStatWidth(c *C) {
+	p := testPatch{
+		filePatches: []testFilePatch{
+			statFilePatch("a/b/f1", "a/b/f1", 175, 0),
+			statFilePatch("a/f2", "a/f2", 60, 8),
+			statFilePatch("c/f3", "c/d/f3", 0, 0),
+		},
+	}
+
+	for width, expected := range map[int]string{
+		DefaultStatWidth: "" +
+			" a/b/f1       | 175 +++++++++++++++++++++++++++++++++++++++++++++++++++++++++++\n" +
+			" a/f2         |  68 ++++++++++++++++++++---\n" +
+			" c/{ => d}/f3 |   0\n" +
+			" 3 files changed, 235 insertions(+), 8 deletions(-)\n",
+		40: "" +
+			" a/b/f1       | 175 +++++++++++++++++++\n" +
+			" a/f2         |  68 ++++++-\n" +
+			" c/{ => d}/f3 |   0\n" +
+			" 3 files changed, 235 insertions(+), 8 deletions(-)\n",
+	} {
+		buffer := bytes.NewBuffer(nil)
+		c.Assert(s.encode(c, p, NewStatEncoder(buffer, Stat).SetWidth(width), buffer), Equals, expected)
+	}
+}
+
+func (s *StatEncoderTestSuite) TestNumStat(c *C) {
+	buffer := bytes.NewBuffer(nil)
+	c.Assert(s.encode(c, statPatch, NewStatEncoder(buffer, NumStat), buffer), Equals, ""+
+		"5\t0\ta/b/f1\n"+
+		"0\t2\ta/f2\n"+
+		"-\t-\tbin\n"+
+		"1\t0\tc/f3\n"+
+		"1\t0\tren => c/ren2\n"+
+		"1\t0\tnewfile\n"+
+		"0\t0\tsame\n")
+}
+
+func (s *StatEncoderTestSuite) TestDirStat(c *C) {
+	buffer := bytes.NewBuffer(nil)
+	c.Assert(s.encode(c, statPatch, NewStatEncoder(buffer, DirStat), buffer), Equals, ""+
+		"  45.4% a/b/\n"+
+		"  18.1% a/\n"+
+		"  18.1% c/\n")
+
+	buffer.Reset()
+	e := NewStatEncoder(buffer, DirStat).SetCumulative(true).SetDirStatLimit(20)
+	c.Assert(s.encode(c, statPatch, e, buffer), Equals, ""+
+		"  45.4% a/b/\n"+
+		"  63.6% a/\n")
+}
+
+func (s *StatEncoderTestSuite) TestRenameName(c *C) {
+	for _, t := range []struct {
+		from, to, expected string
+	}{
+		{"foo", "foo", "foo"},
+		{"foo", "bar", "foo => bar"},
+		{"a/b/c", "a/d/c", "a/{b => d}/c"},
+		{"a/b", "a/c", "a/{b => c}"},
+		{"a/c", "b/c", "{a => b}/c"},
+		{"c/f3", "c/d/f3", "c/{ => d}/f3"},
+		{"ren", "c/ren2", "ren => c/ren2"},
+	} {
+		c.Assert(renameName(t.from, t.to), Equals, t.expected)
+	}
+}
@@ -44,6 +44,9 @@ type UnifiedEncoder struct {
 
 	// colorConfig is the color configuration. The default is no color.
 	color ColorConfig
+
+	// binary is whether the full patches of the binary files are encoded.
+	binary bool
 }
 
 // NewUnifiedEncoder returns a new UnifiedEncoder that writes to w.
@@ -74,19 +77,30 @@ func (e *UnifiedEncoder) SetDstPrefix(prefix string) *UnifiedEncoder {
 	return e
 }
 
+// SetBinary sets whether the binary files are encoded as full binary patches,
+// as the --binary option of git diff, and returns e. Only the files
+// implementing ContentFile can be encoded, the others are described as
+// differing binary files.
+func (e *UnifiedEncoder) SetBinary(binary bool) *UnifiedEncoder {
+	e.binary = binary
+	return e
+}
+
 // Encode encodes patch.
 func (e *UnifiedEncoder) Encode(patch Patch) error {
 	sb := &strings.Builder{}
-
-	if message := patch.Message(); message != "" {
-		sb.WriteString(message)
-		if !strings.HasSuffix(message, "\n") {
-			sb.WriteByte('\n')
-		}
-	}
+	writeMessage(sb, patch)
 
 	for _, filePatch := range patch.FilePatches() {
 		e.writeFilePatchHeader(sb, filePatch)
+		if e.isFullBinary(filePatch) {
+			if err := writeBinaryPatch(sb, filePatch); err != nil {
+				return err
+			}
+
+			continue
+		}
+
 		g := newHunksGenerator(filePatch.Chunks(), e.contextLines)
 		for _, hunk := range g.Generate() {
 			hunk.writeTo(sb, e.color)
@@ -97,12 +111,20 @@ func (e *UnifiedEncoder) Encode(patch Patch) error {
 	return err
 }
 
+func writeMessage(sb *strings.Builder, patch Patch) {
+	if message := patch.Message(); message != "" {
+		sb.WriteString(message)
+		if !strings.HasSuffix(message, "\n") {
+			sb.WriteByte('\n')
+		}
+	}
+}
+
 func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch FilePatch) {
 	from, to := filePatch.Files()
 	if from == nil && to == nil {
 		return
 	}
-	isBinary := filePatch.IsBinary()
 
 	var lines []string
 	switch {
@@ -134,7 +156,7 @@ func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch Fil
 			)
 		}
 		if !hashEquals {
-			lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), e.dstPrefix+to.Path(), isBinary)
+			lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), e.dstPrefix+to.Path(), filePatch)
 		}
 	case from == nil:
 		lines = append(lines,
@@ -142,14 +164,14 @@ func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch Fil
 			fmt.Sprintf("new file mode %o", to.Mode()),
 			fmt.Sprintf("index %s..%s", plumbing.ZeroHash, to.Hash()),
 		)
-		lines = e.appendPathLines(lines, "/dev/null", e.dstPrefix+to.Path(), isBinary)
+		lines = e.appendPathLines(lines, "/dev/null", e.dstPrefix+to.Path(), filePatch)
 	case to == nil:
 		lines = append(lines,
 			fmt.Sprintf("diff --git %s %s", e.srcPrefix+from.Path(), e.dstPrefix+from.Path()),
 			fmt.Sprintf("deleted file mode %o", from.Mode()),
 			fmt.Sprintf("index %s..%s", from.Hash(), plumbing.ZeroHash),
 		)
-		lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), "/dev/null", isBinary)
+		lines = e.appendPathLines(lines, e.srcPrefix+from.Path(), "/dev/null", filePatch)
 	}
 
 	sb.WriteString(e.color[Meta])
@@ -162,8 +184,12 @@ func (e *UnifiedEncoder) writeFilePatchHeader(sb *strings.Builder, filePatch Fil
 	sb.WriteByte('\n')
 }
 
-func (e *UnifiedEncoder) appendPathLines(lines []string, fromPath, toPath string, isBinary bool) []string {
-	if isBinary {
+func (e *UnifiedEncoder) appendPathLines(lines []string, fromPath, toPath string, filePatch FilePatch) []string {
+	if e.isFullBinary(filePatch) {
+		return append(lines, "GIT binary patch")
+	}
+
+	if filePatch.IsBinary() {
 		return append(lines,
 			fmt.Sprintf("Binary files %s and %s differ", fromPath, toPath),
 		)
@@ -190,17 +216,72 @@ func newHunksGenerator(chunks []Chunk, ctxLines int) *hunksGenerator {
 	}
 }
 
+// ignored returns true if the i-th chunk is an IgnorableChunk too far from
+// any other change to be part of its hunk.
+func (g *hunksGenerator) ignored(i int) bool {
+	if c, ok := g.chunks[i].(IgnorableChunk); !ok || !c.Ignorable() {
+		return false
+	}
+
+	return !g.nearChange(i, -1) && !g.nearChange(i, 1)
+}
+
+// nearChange returns true if a not ignorable change is found walking the
+// chunks from i in the given direction, with less equal lines in between than
+// the context lines.
+func (g *hunksGenerator) nearChange(i, dir int) bool {
+	equals := 0
+	for j := i + dir; j >= 0 && j < len(g.chunks); j += dir {
+		c := g.chunks[j]
+		if c.Type() == Equal {
+			equals += len(splitLines(c.Content()))
+			if equals >= g.ctxLines {
+				return false
+			}
+
+			continue
+		}
+
+		if ic, ok := c.(IgnorableChunk); !ok || !ic.Ignorable() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// skip discards the lines of an ignored chunk, closing the current hunk.
+func (g *hunksGenerator) skip(lines []string, op Operation) {
+	switch op {
+	case Delete:
+		g.fromLine += len(lines)
+	case Add:
+		g.toLine += len(lines)
+	}
+
+	g.beforeContext = nil
+	if g.current == nil {
+		return
+	}
+
+	g.current.trimContext(g.ctxLines)
+	g.hunks = append(g.hunks, g.current)
+	g.current = nil
+}
+
 func (g *hunksGenerator) Generate() []*hunk {
 	for i, chunk := range g.chunks {
 		lines := splitLines(chunk.Content())
 		nLines := len(lines)
 
-		switch chunk.Type() {
-		case Equal:
+		switch {
+		case g.ignored(i):
+			g.skip(lines, chunk.Type())
+		case chunk.Type() == Equal:
 			g.fromLine += nLines
 			g.toLine += nLines
 			g.processEqualsLines(lines, i)
-		case Delete:
+		case chunk.Type() == Delete:
 			if nLines != 0 {
 				g.fromLine++
 			}
@@ -208,7 +289,7 @@ func (g *hunksGenerator) Generate() []*hunk {
 			g.processHunk(i, chunk.Type())
 			g.fromLine += nLines - 1
 			g.current.AddOp(chunk.Type(), lines...)
-		case Add:
+		case chunk.Type() == Add:
 			if nLines != 0 {
 				g.toLine++
 			}
@@ -259,11 +340,7 @@ func (g *hunksGenerator) addLineNumbers(la, lb int, linesBefore int, i int, op O
 	// we need to search for a reference for the next diff
 	switch {
 	case linesBefore != 0 && g.ctxLines != 0:
-		if lb > g.ctxLines {
-			clb = lb - g.ctxLines + 1
-		} else {
-			clb = 1
-		}
+		clb = lb - linesBefore + 1
 	case g.ctxLines == 0:
 		clb = lb
 	case i != len(g.chunks)-1:
@@ -321,6 +398,13 @@ type hunk struct {
 }
 
 func (h *hunk) writeTo(sb *strings.Builder, color ColorConfig) {
+	h.writeHeader(sb, color)
+	for _, op := range h.ops {
+		op.writeTo(sb, color)
+	}
+}
+
+func (h *hunk) writeHeader(sb *strings.Builder, color ColorConfig) {
 	sb.WriteString(color[Frag])
 	sb.WriteString("@@ -")
 
@@ -353,10 +437,6 @@ func (h *hunk) writeTo(sb *strings.Builder, color ColorConfig) {
 	}
 
 	sb.WriteByte('\n')
-
-	for _, op := range h.ops {
-		op.writeTo(sb, color)
-	}
 }
 
 func (h *hunk) AddOp(t Operation, ss ...string) {
@@ -376,6 +456,20 @@ func (h *hunk) AddOp(t Operation, ss ...string) {
 	}
 }
 
+// trimContext removes the trailing context lines in excess of n.
+func (h *hunk) trimContext(n int) {
+	trailing := 0
+	for i := len(h.ops) - 1; i >= 0 && h.ops[i].t == Equal; i-- {
+		trailing++
+	}
+
+	for ; trailing > n; trailing-- {
+		h.ops = h.ops[:len(h.ops)-1]
+		h.fromCount--
+		h.toCount--
+	}
+}
+
 type op struct {
 	text string
 	t    Operation
@@ -2,6 +2,8 @@ package diff
 
 import (
 	"bytes"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/go-git/go-git/v5/plumbing"
@@ -52,6 +54,42 @@ Binary files a/binary and b/binary differ
 `)
 }
 
+func (s *UnifiedEncoderTestSuite) TestBinaryFileFull(c *C) {
+	buffer := bytes.NewBuffer(nil)
+	e := NewUnifiedEncoder(buffer, 1).SetBinary(true)
+	p := testPatch{
+		filePatches: []testFilePatch{{
+			from: &testFile{mode: filemode.Regular, path: "binary", seed: "something"},
+			to:   &testFile{mode: filemode.Regular, path: "binary", seed: "otherthing"},
+		}, {
+			to: &testFile{mode: filemode.Regular, path: "new", seed: "\x00data"},
+		}},
+	}
+
+	err := e.Encode(p)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(buffer.String(), `diff --git a/binary b/binary
+index a459bc245bdbc45e1bca99e7fe61731da5c48da4..6879395eacf3cc7e5634064ccb617ac7aa62be7d 100644
+GIT binary patch
+literal 10
+`), Equals, true)
+
+	var decoded ParsedPatch
+	err = NewDecoder(buffer).Decode(&decoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded.Files, HasLen, 2)
+
+	bp := decoded.Files[0].BinaryPatch
+	c.Assert(bp, NotNil)
+	c.Assert(string(bp.Forward.Data), Equals, "otherthing")
+	c.Assert(string(bp.Reverse.Data), Equals, "something")
+
+	c.Assert(decoded.Files[1].From, IsNil)
+	bp = decoded.Files[1].BinaryPatch
+	c.Assert(string(bp.Forward.Data), Equals, "\x00data")
+	c.Assert(bp.Reverse.Data, HasLen, 0)
+}
+
 func (s *UnifiedEncoderTestSuite) TestCustomSrcDstPrefix(c *C) {
 	buffer := bytes.NewBuffer(nil)
 	e := NewUnifiedEncoder(buffer, 1).SetSrcPrefix("source/prefix/").SetDstPrefix("dest/prefix/")
@@ -94,6 +132,46 @@ func (s *UnifiedEncoderTestSuite) TestEncode(c *C) {
 	}
 }
 
+func (s *UnifiedEncoderTestSuite) TestEncodeIgnorableChunks(c *C) {
+	p := testPatch{
+		filePatches: []testFilePatch{{
+			from: &testFile{
+				mode: filemode.Regular,
+				path: "blank.txt",
+				seed: "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n",
+			},
+			to: &testFile{
+				mode: filemode.Regular,
+				path: "blank.txt",
+				seed: "a\n\nb\nc\nd\ne\nf\ng\nh\ni\nJ\nk\n\nl\n",
+			},
+			chunks: []testChunk{
+				{content: "a\n", op: Equal},
+				{content: "\n", op: Add, ignorable: true},
+				{content: "b\nc\nd\ne\nf\ng\nh\ni\n", op: Equal},
+				{content: "j\n", op: Delete},
+				{content: "J\n", op: Add},
+				{content: "k\n", op: Equal},
+				{content: "\n", op: Add, ignorable: true},
+				{content: "l\n", op: Equal},
+			},
+		}},
+	}
+
+	from, to := p.filePatches[0].Files()
+	header := fmt.Sprintf("diff --git a/blank.txt b/blank.txt\n"+
+		"index %s..%s 100644\n--- a/blank.txt\n+++ b/blank.txt\n", from.Hash(), to.Hash())
+
+	for ctx, hunks := range map[int]string{
+		3: "@@ -7,6 +8,7 @@ f\n g\n h\n i\n-j\n+J\n k\n+\n l\n",
+		1: "@@ -9,3 +10,3 @@ h\n i\n-j\n+J\n k\n",
+	} {
+		buffer := bytes.NewBuffer(nil)
+		c.Assert(NewUnifiedEncoder(buffer, ctx).Encode(p), IsNil)
+		c.Assert(buffer.String(), Equals, header+hunks)
+	}
+}
+
 var oneChunkPatch Patch = testPatch{
 	message: "",
 	filePatches: []testFilePatch{{
@@ -1060,9 +1138,14 @@ func (t testFile) Path() string {
 	return t.path
 }
 
+func (t testFile) Content() ([]byte, error) {
+	return []byte(t.seed), nil
+}
+
 type testChunk struct {
-	content string
-	op      Operation
+	content   string
+	op        Operation
+	ignorable bool
 }
 
 func (t testChunk) Content() string {
@@ -1073,6 +1156,10 @@ func (t testChunk) Type() Operation {
 	return t.op
 }
 
+func (t testChunk) Ignorable() bool {
+	return t.ignorable
+}
+
 type fixture struct {
 	desc    string
 	context int
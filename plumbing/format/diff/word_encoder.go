@@ -0,0 +1,399 @@
+package diff
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// WordDiffMode is the way a WordEncoder marks the changed words, as the
+// --word-diff option of git diff.
+type WordDiffMode int
+
+const (
+	// WordDiffPlain marks the removed words as [-removed-] and the added
+	// ones as {+added+}.
+	WordDiffPlain WordDiffMode = iota
+	// WordDiffPorcelain writes every word on its own line, prefixed by the
+	// operation char, a line with a single tilde marking the newlines of the
+	// original text. It's meant to be parsed by scripts.
+	WordDiffPorcelain
+	// WordDiffColor marks the changed words with colors only. The default
+	// colors are used if none is set.
+	WordDiffColor
+)
+
+type wordStyle struct {
+	prefix, suffix string
+	key            ColorKey
+}
+
+type wordDiffStyle struct {
+	old, new, ctx wordStyle
+	newline       string
+}
+
+// wordDiffStyles are the styles of every mode. See
+// https://github.com/git/git/blob/v2.26.2/diff.c#L1909-L1913.
+var wordDiffStyles = map[WordDiffMode]wordDiffStyle{
+	WordDiffPlain: {
+		old:     wordStyle{"[-", "-]", Old},
+		new:     wordStyle{"{+", "+}", New},
+		ctx:     wordStyle{"", "", Context},
+		newline: "\n",
+	},
+	WordDiffPorcelain: {
+		old:     wordStyle{"-", "\n", Old},
+		new:     wordStyle{"+", "\n", New},
+		ctx:     wordStyle{" ", "\n", Context},
+		newline: "~\n",
+	},
+	WordDiffColor: {
+		old:     wordStyle{"", "", Old},
+		new:     wordStyle{"", "", New},
+		ctx:     wordStyle{"", "", Context},
+		newline: "\n",
+	},
+}
+
+// WordEncoder encodes a diff into the provided Writer showing the changed
+// words inside the changed lines, as git diff --word-diff does. The hunks are
+// the ones of the UnifiedEncoder.
+type WordEncoder struct {
+	io.Writer
+
+	// mode is the way the changed words are marked.
+	mode WordDiffMode
+
+	// wordRegexp matches the words, by default the sequences of non
+	// whitespace characters.
+	wordRegexp *regexp.Regexp
+
+	// contextLines is the count of unchanged lines that will appear surrounding
+	// a change.
+	contextLines int
+
+	// srcPrefix and dstPrefix are prepended to file paths when encoding a diff.
+	srcPrefix string
+	dstPrefix string
+
+	// colorConfig is the color configuration. The default is no color.
+	color ColorConfig
+}
+
+// NewWordEncoder returns a new WordEncoder that writes to w.
+func NewWordEncoder(w io.Writer, mode WordDiffMode, contextLines int) *WordEncoder {
+	return &WordEncoder{
+		Writer:       w,
+		mode:         mode,
+		srcPrefix:    "a/",
+		dstPrefix:    "b/",
+		contextLines: contextLines,
+	}
+}
+
+// SetWordRegexp sets the regular expression matching the words, like the
+// --word-diff-regex option, and returns e. The characters not matched are
+// ignored when comparing the texts. A nil regexp restores the default.
+func (e *WordEncoder) SetWordRegexp(re *regexp.Regexp) *WordEncoder {
+	e.wordRegexp = re
+	return e
+}
+
+// SetColor sets e's color configuration and returns e.
+func (e *WordEncoder) SetColor(colorConfig ColorConfig) *WordEncoder {
+	e.color = colorConfig
+	return e
+}
+
+// SetSrcPrefix sets e's srcPrefix and returns e.
+func (e *WordEncoder) SetSrcPrefix(prefix string) *WordEncoder {
+	e.srcPrefix = prefix
+	return e
+}
+
+// SetDstPrefix sets e's dstPrefix and returns e.
+func (e *WordEncoder) SetDstPrefix(prefix string) *WordEncoder {
+	e.dstPrefix = prefix
+	return e
+}
+
+// Encode encodes patch.
+func (e *WordEncoder) Encode(patch Patch) error {
+	color := e.color
+	if e.mode == WordDiffColor && len(color) == 0 {
+		color = NewColorConfig()
+	}
+
+	ue := &UnifiedEncoder{
+		srcPrefix: e.srcPrefix,
+		dstPrefix: e.dstPrefix,
+		color:     color,
+	}
+
+	sb := &strings.Builder{}
+	writeMessage(sb, patch)
+
+	for _, filePatch := range patch.FilePatches() {
+		ue.writeFilePatchHeader(sb, filePatch)
+		g := newHunksGenerator(filePatch.Chunks(), e.contextLines)
+		for _, hunk := range g.Generate() {
+			hunk.writeHeader(sb, color)
+			e.writeHunk(sb, hunk, color)
+		}
+	}
+
+	_, err := e.Write([]byte(sb.String()))
+	return err
+}
+
+func (e *WordEncoder) writeHunk(sb *strings.Builder, h *hunk, color ColorConfig) {
+	w := &wordDiffWriter{
+		sb:    sb,
+		style: wordDiffStyles[e.mode],
+		color: color,
+		re:    e.wordRegexp,
+	}
+
+	for _, o := range h.ops {
+		text := o.text
+		if !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+
+		switch o.t {
+		case Delete:
+			w.minus.WriteString(text)
+		case Add:
+			w.plus.WriteString(text)
+		case Equal:
+			w.flush()
+			w.writeContext(text)
+		}
+	}
+
+	w.flush()
+}
+
+// wordDiffWriter accumulates the removed and added lines of a hunk, writing
+// the changed words when a context line is found.
+type wordDiffWriter struct {
+	sb    *strings.Builder
+	style wordDiffStyle
+	color ColorConfig
+	re    *regexp.Regexp
+
+	minus, plus strings.Builder
+}
+
+func (w *wordDiffWriter) writeContext(line string) {
+	if w.style.ctx.prefix == "" {
+		w.write(w.style.ctx, line)
+		return
+	}
+
+	w.sb.WriteString(w.color[Context])
+	w.sb.WriteString(w.style.ctx.prefix)
+	w.sb.WriteString(strings.TrimSuffix(line, "\n"))
+	w.sb.WriteString(w.color.Reset(Context))
+	w.sb.WriteByte('\n')
+	w.sb.WriteString(w.style.newline)
+}
+
+// write writes text with the given style, line by line, replacing the
+// newlines by the newline of the mode.
+func (w *wordDiffWriter) write(st wordStyle, text string) {
+	for text != "" {
+		line := text
+		i := strings.IndexByte(text, '\n')
+		if i >= 0 {
+			line = text[:i]
+		}
+
+		if line != "" {
+			w.sb.WriteString(w.color[st.key])
+			w.sb.WriteString(st.prefix)
+			w.sb.WriteString(line)
+			w.sb.WriteString(st.suffix)
+			w.sb.WriteString(w.color.Reset(st.key))
+		}
+
+		if i < 0 {
+			return
+		}
+
+		w.sb.WriteString(w.style.newline)
+		text = text[i+1:]
+	}
+}
+
+// flush writes the changed words of the accumulated lines. The unchanged text
+// between them is taken from the added lines.
+func (w *wordDiffWriter) flush() {
+	minus, plus := w.minus.String(), w.plus.String()
+	w.minus.Reset()
+	w.plus.Reset()
+
+	if plus == "" {
+		w.write(w.style.old, minus)
+		return
+	}
+
+	minusWords, plusWords := splitWords(minus, w.re), splitWords(plus, w.re)
+	current := 0
+	for _, c := range diffWords(minus, plus, minusWords, plusWords) {
+		minusBegin, minusEnd := wordsSpan(minusWords, c.minusFirst, c.minusLen)
+		plusBegin, plusEnd := wordsSpan(plusWords, c.plusFirst, c.plusLen)
+
+		if current != plusBegin {
+			w.write(w.style.ctx, plus[current:plusBegin])
+		}
+
+		if minusBegin != minusEnd {
+			w.write(w.style.old, minus[minusBegin:minusEnd])
+		}
+
+		if plusBegin != plusEnd {
+			w.write(w.style.new, plus[plusBegin:plusEnd])
+		}
+
+		current = plusEnd
+	}
+
+	w.write(w.style.ctx, plus[current:])
+}
+
+// word is the position of a word in a text.
+type word struct {
+	begin, end int
+}
+
+// splitWords returns the words of text, the matches of re or, if re is nil,
+// the sequences of non whitespace characters. A word never contains a
+// newline.
+func splitWords(text string, re *regexp.Regexp) []word {
+	var words []word
+	if re == nil {
+		begin := -1
+		for i := 0; i <= len(text); i++ {
+			space := i == len(text) || isSpace(text[i])
+			switch {
+			case space && begin >= 0:
+				words = append(words, word{begin, i})
+				begin = -1
+			case !space && begin < 0:
+				begin = i
+			}
+		}
+
+		return words
+	}
+
+	for pos := 0; pos < len(text); {
+		loc := re.FindStringIndex(text[pos:])
+		if loc == nil {
+			break
+		}
+
+		begin, end := pos+loc[0], pos+loc[1]
+		if i := strings.IndexByte(text[begin:end], '\n'); i >= 0 {
+			end = begin + i
+		}
+
+		if begin >= end {
+			break
+		}
+
+		words = append(words, word{begin, end})
+		pos = end
+	}
+
+	return words
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+
+	return false
+}
+
+// wordsSpan returns the bounds of n words starting at first. If n is zero,
+// the empty span at the end of the previous word is returned.
+func wordsSpan(words []word, first, n int) (begin, end int) {
+	if n > 0 {
+		return words[first].begin, words[first+n-1].end
+	}
+
+	if first == 0 {
+		return 0, 0
+	}
+
+	return words[first-1].end, words[first-1].end
+}
+
+// wordChange is a sequence of removed and added words.
+type wordChange struct {
+	minusFirst, minusLen int
+	plusFirst, plusLen   int
+}
+
+// diffWords returns the changes between the words of both texts.
+func diffWords(minus, plus string, minusWords, plusWords []word) []wordChange {
+	keys := make(map[string]rune)
+	toRunes := func(text string, words []word) []rune {
+		runes := make([]rune, len(words))
+		for i, w := range words {
+			r, ok := keys[text[w.begin:w.end]]
+			if !ok {
+				r = rune(len(keys) + 1)
+				if r >= 0xD800 {
+					r += 0x800
+				}
+
+				keys[text[w.begin:w.end]] = r
+			}
+
+			runes[i] = r
+		}
+
+		return runes
+	}
+
+	dmp := diffmatchpatch.New()
+	diffs := dmp.DiffMainRunes(toRunes(minus, minusWords), toRunes(plus, plusWords), false)
+
+	var changes []wordChange
+	var current *wordChange
+	i, j := 0, 0
+	for _, d := range diffs {
+		n := utf8.RuneCountInString(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			current = nil
+			i += n
+			j += n
+			continue
+		}
+
+		if current == nil {
+			changes = append(changes, wordChange{minusFirst: i, plusFirst: j})
+			current = &changes[len(changes)-1]
+		}
+
+		switch d.Type {
+		case diffmatchpatch.DiffDelete:
+			current.minusLen += n
+			i += n
+		case diffmatchpatch.DiffInsert:
+			current.plusLen += n
+			j += n
+		}
+	}
+
+	return changes
+}
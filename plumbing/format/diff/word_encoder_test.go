@@ -0,0 +1,120 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/color"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+
+	. "gopkg.in/check.v1"
+)
+
+type WordEncoderTestSuite struct{}
+
+var _ = Suite(&WordEncoderTestSuite{})
+
+var wordPatch = testPatch{
+	filePatches: []testFilePatch{{
+		from: &testFile{
+			mode: filemode.Regular,
+			path: "words.txt",
+			seed: "foo bar baz\nsame line\nhello world\nremoved line\n",
+		},
+		to: &testFile{
+			mode: filemode.Regular,
+			path: "words.txt",
+			seed: "foo qux baz\nsame line\nhello  there world\n",
+		},
+		chunks: []testChunk{
+			{content: "foo bar baz\n", op: Delete},
+			{content: "foo qux baz\n", op: Add},
+			{content: "same line\n", op: Equal},
+			{content: "hello world\nremoved line\n", op: Delete},
+			{content: "hello  there world\n", op: Add},
+		},
+	}},
+}
+
+func wordPatchHeader() string {
+	from, to := wordPatch.filePatches[0].Files()
+	return fmt.Sprintf("diff --git a/words.txt b/words.txt\n"+
+		"index %s..%s 100644\n--- a/words.txt\n+++ b/words.txt\n", from.Hash(), to.Hash())
+}
+
+func (s *WordEncoderTestSuite) encode(c *C, p Patch, e func(*bytes.Buffer) *WordEncoder) string {
+	buffer := bytes.NewBuffer(nil)
+	c.Assert(e(buffer).Encode(p), IsNil)
+	return buffer.String()
+}
+
+func (s *WordEncoderTestSuite) TestPlain(c *C) {
+	out := s.encode(c, wordPatch, func(b *bytes.Buffer) *WordEncoder {
+		return NewWordEncoder(b, WordDiffPlain, DefaultContextLines)
+	})
+
+	c.Assert(out, Equals, wordPatchHeader()+
+		"@@ -1,4 +1,3 @@\n"+
+		"foo [-bar-]{+qux+} baz\n"+
+		"same line\n"+
+		"hello  {+there+} world[-removed line-]\n")
+}
+
+func (s *WordEncoderTestSuite) TestPorcelain(c *C) {
+	out := s.encode(c, wordPatch, func(b *bytes.Buffer) *WordEncoder {
+		return NewWordEncoder(b, WordDiffPorcelain, DefaultContextLines)
+	})
+
+	c.Assert(out, Equals, wordPatchHeader()+
+		"@@ -1,4 +1,3 @@\n"+
+		" foo \n-bar\n+qux\n  baz\n~\n"+
+		" same line\n~\n"+
+		" hello  \n+there\n  world\n-removed line\n~\n")
+}
+
+func (s *WordEncoderTestSuite) TestColor(c *C) {
+	out := s.encode(c, wordPatch, func(b *bytes.Buffer) *WordEncoder {
+		return NewWordEncoder(b, WordDiffColor, DefaultContextLines)
+	})
+
+	from, to := wordPatch.filePatches[0].Files()
+	c.Assert(out, Equals, color.Bold+"diff --git a/words.txt b/words.txt\n"+
+		fmt.Sprintf("index %s..%s 100644\n", from.Hash(), to.Hash())+
+		"--- a/words.txt\n+++ b/words.txt"+color.Reset+"\n"+
+		color.Cyan+"@@ -1,4 +1,3 @@"+color.Reset+"\n"+
+		"foo "+color.Red+"bar"+color.Reset+color.Green+"qux"+color.Reset+" baz\n"+
+		"same line\n"+
+		"hello  "+color.Green+"there"+color.Reset+" world"+color.Red+"removed line"+color.Reset+"\n")
+}
+
+func (s *WordEncoderTestSuite) TestWordRegexp(c *C) {
+	p := testPatch{
+		filePatches: []testFilePatch{{
+			from: &testFile{mode: filemode.Regular, path: "regexp.txt", seed: "foo(bar)\n"},
+			to:   &testFile{mode: filemode.Regular, path: "regexp.txt", seed: "foo(baz)\n"},
+			chunks: []testChunk{
+				{content: "foo(bar)\n", op: Delete},
+				{content: "foo(baz)\n", op: Add},
+			},
+		}},
+	}
+
+	for re, expected := range map[string]string{
+		"":    "[-foo(bar)-]{+foo(baz)+}\n",
+		".":   "foo(ba[-r-]{+z+})\n",
+		`\w+`: "foo([-bar-]{+baz+})\n",
+	} {
+		out := s.encode(c, p, func(b *bytes.Buffer) *WordEncoder {
+			e := NewWordEncoder(b, WordDiffPlain, DefaultContextLines)
+			if re != "" {
+				e.SetWordRegexp(regexp.MustCompile(re))
+			}
+
+			return e
+		})
+
+		c.Assert(strings.HasSuffix(out, "\n@@ -1 +1 @@\n"+expected), Equals, true, Commentf("%q", out))
+	}
+}
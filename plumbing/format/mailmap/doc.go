@@ -0,0 +1,17 @@
+// Package mailmap implements the parsing of the .mailmap files, mapping the
+// names and emails of the authors and committers to their canonical identity.
+// The lines of a mailmap have one of these formats, the names and the emails
+// being compared ignoring the case:
+//
+//	Proper Name <commit@email.xx>
+//
+//	<proper@email.xx> <commit@email.xx>
+//
+//	Proper Name <proper@email.xx> <commit@email.xx>
+//
+//	Proper Name <proper@email.xx> Commit Name <commit@email.xx>
+//
+// The first three forms map any name with the commit email, the last one
+// only maps the given commit name and email. The lines starting with # are
+// comments, as the text following the emails.
+package mailmap
@@ -0,0 +1,174 @@
+package mailmap
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const commentPrefix = "#"
+
+// Mailmap maps the names and emails of the signatures to their canonical
+// identity.
+type Mailmap struct {
+	// entries are indexed by the lowercased commit email.
+	entries map[string]*entry
+}
+
+// entry is the mapping of a commit email, for any name and for specific
+// names, the latter being indexed by the lowercased commit name.
+type entry struct {
+	identity
+	names map[string]*identity
+}
+
+// identity is a proper name and email, each one being unset if empty.
+type identity struct {
+	name, email string
+}
+
+// New returns an empty Mailmap.
+func New() *Mailmap {
+	return &Mailmap{entries: make(map[string]*entry)}
+}
+
+// Read returns the Mailmap read from a .mailmap file.
+func Read(r io.Reader) (*Mailmap, error) {
+	m := New()
+	if err := m.Parse(r); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Parse adds the mappings of a .mailmap file to m, overriding the existing
+// ones for the same commit names and emails.
+func (m *Mailmap) Parse(r io.Reader) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		m.parseLine(s.Text())
+	}
+
+	return s.Err()
+}
+
+func (m *Mailmap) parseLine(line string) {
+	if strings.HasPrefix(line, commentPrefix) {
+		return
+	}
+
+	name1, email1, rest, ok := parseNameAndEmail(line, false)
+	if !ok {
+		return
+	}
+
+	// With a single name and email, the email is the commit one.
+	name2, email2, _, ok := parseNameAndEmail(rest, true)
+	if !ok {
+		m.Add(name1, "", "", email1)
+		return
+	}
+
+	m.Add(name1, email1, name2, email2)
+}
+
+// parseNameAndEmail returns the name and the email of a "Name <email>" text,
+// and the text following it.
+func parseNameAndEmail(s string, allowEmptyEmail bool) (name, email, rest string, ok bool) {
+	left := strings.IndexByte(s, '<')
+	if left < 0 {
+		return "", "", "", false
+	}
+
+	right := strings.IndexByte(s[left+1:], '>')
+	if right < 0 || (right == 0 && !allowEmptyEmail) {
+		return "", "", "", false
+	}
+
+	right += left + 1
+	return strings.TrimSpace(s[:left]), s[left+1 : right], s[right+1:], true
+}
+
+// Add adds a mapping from a commit name and email to a proper name and
+// email. An empty commit name maps any name with the commit email, an empty
+// proper name or email keeps the commit one.
+func (m *Mailmap) Add(properName, properEmail, commitName, commitEmail string) {
+	key := strings.ToLower(commitEmail)
+	e, ok := m.entries[key]
+	if !ok {
+		e = &entry{}
+		m.entries[key] = e
+	}
+
+	if commitName == "" {
+		if properName != "" {
+			e.name = properName
+		}
+
+		if properEmail != "" {
+			e.email = properEmail
+		}
+
+		return
+	}
+
+	if e.names == nil {
+		e.names = make(map[string]*identity)
+	}
+
+	e.names[strings.ToLower(commitName)] = &identity{name: properName, email: properEmail}
+}
+
+// Map returns the canonical name and email of a commit name and email, and
+// whether they are mapped.
+func (m *Mailmap) Map(name, email string) (string, string, bool) {
+	if m == nil {
+		return name, email, false
+	}
+
+	e, ok := m.entries[strings.ToLower(email)]
+	if !ok {
+		return name, email, false
+	}
+
+	id := &e.identity
+	if n, ok := e.names[strings.ToLower(name)]; ok {
+		id = n
+	}
+
+	if id.name == "" && id.email == "" {
+		return name, email, false
+	}
+
+	if id.name != "" {
+		name = id.name
+	}
+
+	if id.email != "" {
+		email = id.email
+	}
+
+	return name, email, true
+}
+
+// Signature returns s with its canonical name and email.
+func (m *Mailmap) Signature(s object.Signature) object.Signature {
+	s.Name, s.Email, _ = m.Map(s.Name, s.Email)
+	return s
+}
+
+// Commit returns a copy of c with the canonical identities of its author
+// and committer, or c if they aren't mapped.
+func (m *Mailmap) Commit(c *object.Commit) *object.Commit {
+	author, committer := m.Signature(c.Author), m.Signature(c.Committer)
+	if author == c.Author && committer == c.Committer {
+		return c
+	}
+
+	mapped := *c
+	mapped.Author, mapped.Committer = author, committer
+	return &mapped
+}
@@ -0,0 +1,108 @@
+package mailmap
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MailmapSuite struct{}
+
+var _ = Suite(&MailmapSuite{})
+
+const mailmap = `# comment
+Joe Developer <joe@example.com>
+<jane@example.com> <jane@laptop.(none)>
+Other Author <other@author.xx> nick2 <bugs@company.xx>
+Other Author <other@author.xx> <nick1@company.xx>
+Santa Claus <santa.claus@northpole.xx> <me@company.xx>
+Santa Claus <santa.claus@northpole.xx> Santa <ME@company.xx>  # trailing
+ Spaced   Name   <spaced@x>
+bad line <unterminated
+`
+
+func (s *MailmapSuite) TestMap(c *C) {
+	m, err := Read(strings.NewReader(mailmap))
+	c.Assert(err, IsNil)
+
+	for _, t := range []struct {
+		name, email        string
+		properName, proper string
+		mapped             bool
+	}{
+		{"joe", "joe@example.com", "Joe Developer", "joe@example.com", true},
+		{"X", "JOE@Example.com", "Joe Developer", "JOE@Example.com", true},
+		{"Jane", "jane@laptop.(none)", "Jane", "jane@example.com", true},
+		{"nick2", "bugs@company.xx", "Other Author", "other@author.xx", true},
+		{"nick3", "bugs@company.xx", "nick3", "bugs@company.xx", false},
+		{"A", "nick1@company.xx", "Other Author", "other@author.xx", true},
+		{"me", "me@company.xx", "Santa Claus", "santa.claus@northpole.xx", true},
+		{"SANTA", "me@company.xx", "Santa Claus", "santa.claus@northpole.xx", true},
+		{"z", "spaced@x", "Spaced   Name", "spaced@x", true},
+		{"q", "unknown@x", "q", "unknown@x", false},
+		{"bad line", "unterminated", "bad line", "unterminated", false},
+	} {
+		name, email, mapped := m.Map(t.name, t.email)
+		c.Assert(name, Equals, t.properName)
+		c.Assert(email, Equals, t.proper)
+		c.Assert(mapped, Equals, t.mapped)
+	}
+}
+
+func (s *MailmapSuite) TestParseOverrides(c *C) {
+	m := New()
+	c.Assert(m.Parse(strings.NewReader("Foo <foo@foo>\n")), IsNil)
+	c.Assert(m.Parse(strings.NewReader("<bar@bar> <foo@foo>\nBaz <baz@baz> Qux <foo@foo>\n")), IsNil)
+
+	name, email, _ := m.Map("foo", "foo@foo")
+	c.Assert(name, Equals, "Foo")
+	c.Assert(email, Equals, "bar@bar")
+
+	name, email, _ = m.Map("qux", "FOO@foo")
+	c.Assert(name, Equals, "Baz")
+	c.Assert(email, Equals, "baz@baz")
+}
+
+func (s *MailmapSuite) TestAdd(c *C) {
+	m := New()
+	m.Add("Foo", "", "", "foo@foo")
+
+	name, email, mapped := m.Map("bar", "foo@foo")
+	c.Assert(name, Equals, "Foo")
+	c.Assert(email, Equals, "foo@foo")
+	c.Assert(mapped, Equals, true)
+}
+
+func (s *MailmapSuite) TestNil(c *C) {
+	var m *Mailmap
+	name, email, mapped := m.Map("foo", "foo@foo")
+	c.Assert(name, Equals, "foo")
+	c.Assert(email, Equals, "foo@foo")
+	c.Assert(mapped, Equals, false)
+}
+
+func (s *MailmapSuite) TestSignatureAndCommit(c *C) {
+	m := New()
+	m.Add("Foo", "foo@foo", "", "bar@bar")
+
+	when := time.Unix(1257894000, 0)
+	sig := object.Signature{Name: "bar", Email: "bar@bar", When: when}
+	c.Assert(m.Signature(sig), Equals, object.Signature{Name: "Foo", Email: "foo@foo", When: when})
+
+	commit := &object.Commit{Author: sig, Committer: sig, Message: "foo"}
+	mapped := m.Commit(commit)
+	c.Assert(mapped, Not(Equals), commit)
+	c.Assert(mapped.Author.Name, Equals, "Foo")
+	c.Assert(mapped.Committer.Email, Equals, "foo@foo")
+	c.Assert(mapped.Message, Equals, "foo")
+	c.Assert(commit.Author.Name, Equals, "bar")
+
+	other := &object.Commit{Author: object.Signature{Name: "baz", Email: "baz@baz"}}
+	c.Assert(m.Commit(other), Equals, other)
+}
@@ -0,0 +1,306 @@
+package mbox
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+var (
+	// ErrMissingAuthor is returned by Decode when an email has no valid
+	// From header.
+	ErrMissingAuthor = errors.New("patch email without author")
+
+	// fromLineRegexp matches the "From " lines separating the emails of an
+	// mbox, which are followed by a date.
+	fromLineRegexp = regexp.MustCompile(`^From (\S+) +(Mon|Tue|Wed|Thu|Fri|Sat|Sun) `)
+
+	// patchNumberRegexp matches the position of a patch in the subject
+	// prefix.
+	patchNumberRegexp = regexp.MustCompile(`(\d+)/(\d+)$`)
+
+	// inBodyHeaders are the headers which can start the body of an email,
+	// overriding the ones of the email, as when it's sent by someone else
+	// than the author of the patch.
+	inBodyHeaders = []string{"From", "Date", "Subject"}
+)
+
+// Decoder reads the patch emails of an mbox, as written by an Encoder or git
+// format-patch.
+type Decoder struct {
+	r *bufio.Reader
+
+	// next is the "From " line of the next email, already read.
+	next string
+	eof  bool
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next email and stores its patch in m. It returns io.EOF
+// when there are no more emails.
+func (d *Decoder) Decode(m *Message) error {
+	fromLine, text, err := d.readEmail()
+	if err != nil {
+		return err
+	}
+
+	*m = Message{}
+	if match := fromLineRegexp.FindStringSubmatch(fromLine); match != nil {
+		if h, ok := parseHash(match[1]); ok {
+			m.Hash = h
+		}
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(text))
+	if err != nil {
+		return err
+	}
+
+	body, err := decodeBody(msg)
+	if err != nil {
+		return err
+	}
+
+	header, body := parseInBodyHeaders(msg.Header, body)
+	if err := decodeHeader(m, header); err != nil {
+		return err
+	}
+
+	// The position of the patch is the one of the subject of the email, even
+	// if the body overrides it.
+	subject, err := (&mime.WordDecoder{}).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		return err
+	}
+
+	_, m.Number, m.Total = parseSubject(subject)
+
+	commitBody, diff := splitBody(body)
+	m.Body = trimBody(commitBody)
+
+	p := &fdiff.ParsedPatch{}
+	if err := fdiff.NewDecoder(strings.NewReader(diff)).Decode(p); err != nil {
+		return err
+	}
+
+	m.Patch = p
+	return nil
+}
+
+// readEmail returns the "From " line and the rest of the next email.
+func (d *Decoder) readEmail() (fromLine, text string, err error) {
+	fromLine = d.next
+	d.next = ""
+
+	var sb strings.Builder
+	for !d.eof {
+		line, err := d.r.ReadString('\n')
+		if err == io.EOF {
+			d.eof = true
+		} else if err != nil {
+			return "", "", err
+		}
+
+		if fromLineRegexp.MatchString(line) {
+			if fromLine == "" && sb.Len() == 0 {
+				fromLine = line
+				continue
+			}
+
+			d.next = line
+			break
+		}
+
+		sb.WriteString(line)
+	}
+
+	if fromLine == "" && strings.TrimSpace(sb.String()) == "" {
+		return "", "", io.EOF
+	}
+
+	return fromLine, sb.String(), nil
+}
+
+func parseHash(s string) (plumbing.Hash, bool) {
+	if len(s) != len(plumbing.ZeroHash)*2 {
+		return plumbing.ZeroHash, false
+	}
+
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return plumbing.ZeroHash, false
+		}
+	}
+
+	return plumbing.NewHash(s), true
+}
+
+// decodeBody returns the body of msg, decoding its transfer encoding.
+func decodeBody(msg *mail.Message) (string, error) {
+	r := msg.Body
+	switch strings.ToLower(msg.Header.Get("Content-Transfer-Encoding")) {
+	case "quoted-printable":
+		r = quotedprintable.NewReader(r)
+	case "base64":
+		r = base64.NewDecoder(base64.StdEncoding, newlineStripper{r})
+	}
+
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Replace(string(body), "\r\n", "\n", -1), nil
+}
+
+// newlineStripper removes the newlines of base64 data.
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (s newlineStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	j := 0
+	for _, c := range p[:n] {
+		if c != '\n' && c != '\r' {
+			p[j] = c
+			j++
+		}
+	}
+
+	return j, err
+}
+
+// parseInBodyHeaders returns the headers of the email overridden by the ones
+// starting its body, and the rest of the body.
+func parseInBodyHeaders(header mail.Header, body string) (mail.Header, string) {
+	merged := mail.Header{}
+	for k, v := range header {
+		merged[k] = v
+	}
+
+	rest := body
+	found := false
+	for {
+		i := strings.IndexByte(rest, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := rest[:i]
+		matched := false
+		for _, name := range inBodyHeaders {
+			if strings.HasPrefix(line, name+": ") {
+				merged[name] = []string{strings.TrimSpace(line[len(name)+2:])}
+				matched = true
+			}
+		}
+
+		if !matched {
+			break
+		}
+
+		found = true
+		rest = rest[i+1:]
+	}
+
+	if !found {
+		return header, body
+	}
+
+	return merged, strings.TrimLeft(rest, "\n")
+}
+
+func decodeHeader(m *Message, header mail.Header) error {
+	from, err := header.AddressList("From")
+	if err != nil || len(from) == 0 {
+		return ErrMissingAuthor
+	}
+
+	m.Author = object.Signature{Name: from[0].Name, Email: from[0].Address}
+	if m.Author.Name == "" {
+		m.Author.Name = from[0].Address
+	}
+
+	if header.Get("Date") != "" {
+		if m.Author.When, err = header.Date(); err != nil {
+			return err
+		}
+	}
+
+	dec := &mime.WordDecoder{}
+	subject, err := dec.DecodeHeader(header.Get("Subject"))
+	if err != nil {
+		return err
+	}
+
+	m.Subject, _, _ = parseSubject(subject)
+	return nil
+}
+
+// parseSubject removes the "Re:" and bracketed prefixes of a subject, as git
+// mailinfo does, returning the position of the patch found in them.
+func parseSubject(subject string) (s string, number, total int) {
+	s = strings.TrimSpace(subject)
+	for {
+		switch {
+		case strings.HasPrefix(strings.ToLower(s), "re:"):
+			s = strings.TrimSpace(s[3:])
+		case strings.HasPrefix(s, "["):
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return s, number, total
+			}
+
+			if m := patchNumberRegexp.FindStringSubmatch(s[1:end]); m != nil {
+				number, _ = strconv.Atoi(m[1])
+				total, _ = strconv.Atoi(m[2])
+			}
+
+			s = strings.TrimSpace(s[end+1:])
+		default:
+			return strings.Join(strings.Fields(s), " "), number, total
+		}
+	}
+}
+
+// splitBody splits the body of an email at the "---" line or the first diff,
+// returning the end of the commit message and the patch.
+func splitBody(body string) (commit, diff string) {
+	for pos := 0; pos < len(body); {
+		end := strings.IndexByte(body[pos:], '\n')
+		if end < 0 {
+			end = len(body)
+		} else {
+			end += pos + 1
+		}
+
+		line := strings.TrimRight(body[pos:end], "\r\n")
+		switch {
+		case line == "---":
+			return body[:pos], body[end:]
+		case strings.HasPrefix(line, "diff --git "), strings.HasPrefix(line, "Index: "):
+			return body[:pos], body[pos:]
+		}
+
+		pos = end
+	}
+
+	return body, ""
+}
@@ -0,0 +1,154 @@
+package mbox
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type DecoderSuite struct{}
+
+var _ = Suite(&DecoderSuite{})
+
+const series = `From ff2be909362981a228f6c55f2a36ff057a870da3 Mon Sep 17 00:00:00 2001
+From: =?UTF-8?q?J=C3=B6s=C3=A9=20Dev?= <jose@example.com>
+Date: Wed, 14 Oct 2020 12:00:00 +0200
+Subject: [PATCH 1/2] Change things
+ on two lines
+
+Some body text.
+
+From the body.
+---
+ a.txt | 2 +-
+ bin   | Bin 5 -> 6 bytes
+ 2 files changed, 1 insertion(+), 1 deletion(-)
+
+diff --git a/a.txt b/a.txt
+index 2019eda..b91241c 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,2 +1,2 @@
+ one
+-two
++2
+diff --git a/bin b/bin
+index 88768efdf77ec78c9a995f94881793be6a41752b..7e0b112495bc3ee327e8d333557467775a85739c 100644
+GIT binary patch
+literal 6
+NcmZQzO3KXp4*&$N0y_Wz
+
+literal 5
+McmZQzOv=my00M6TI{*Lx
+
+-- 
+2.39.5
+
+From 0000000000000000000000000000000000000000 Mon Sep 17 00:00:00 2001
+From: Sender <sender@example.com>
+Date: Thu, 15 Oct 2020 08:30:00 -0700
+Subject: [RFC PATCH v2 2/2] Re: Something else
+MIME-Version: 1.0
+Content-Type: text/plain; charset=UTF-8
+Content-Transfer-Encoding: quoted-printable
+
+From: Author <author@example.com>
+Subject: Caf=C3=A9
+
+Body with a long line split by the encoding which is longer than sevent=
+y six chars.
+diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1 +1 @@
+-one
++caf=C3=A9
+`
+
+func (s *DecoderSuite) TestDecodeSeries(c *C) {
+	d := NewDecoder(strings.NewReader(series))
+
+	var m Message
+	c.Assert(d.Decode(&m), IsNil)
+	c.Assert(m.Hash, Equals, plumbing.NewHash("ff2be909362981a228f6c55f2a36ff057a870da3"))
+	c.Assert(m.Author.Name, Equals, "Jösé Dev")
+	c.Assert(m.Author.Email, Equals, "jose@example.com")
+	c.Assert(m.Author.When.Equal(time.Date(2020, 10, 14, 10, 0, 0, 0, time.UTC)), Equals, true)
+	_, offset := m.Author.When.Zone()
+	c.Assert(offset, Equals, 2*60*60)
+	c.Assert(m.Subject, Equals, "Change things on two lines")
+	c.Assert(m.Body, Equals, "Some body text.\n\nFrom the body.\n")
+	c.Assert(m.CommitMessage(), Equals, "Change things on two lines\n\nSome body text.\n\nFrom the body.\n")
+	c.Assert(m.Number, Equals, 1)
+	c.Assert(m.Total, Equals, 2)
+
+	p := m.Patch.(*fdiff.ParsedPatch)
+	c.Assert(p.Files, HasLen, 2)
+	c.Assert(p.Files[0].To.Path(), Equals, "a.txt")
+	c.Assert(p.Files[1].BinaryPatch.Forward.Data, DeepEquals, []byte("\x00\x02bin\xff"))
+
+	c.Assert(d.Decode(&m), IsNil)
+	c.Assert(m.Hash, Equals, plumbing.ZeroHash)
+	c.Assert(m.Author.Name, Equals, "Author")
+	c.Assert(m.Author.Email, Equals, "author@example.com")
+	c.Assert(m.Subject, Equals, "Café")
+	c.Assert(m.Body, Equals, "Body with a long line split by the encoding which is longer than seventy six chars.\n")
+	c.Assert(m.Number, Equals, 2)
+	c.Assert(m.Total, Equals, 2)
+
+	p = m.Patch.(*fdiff.ParsedPatch)
+	c.Assert(p.Files, HasLen, 1)
+	c.Assert(p.Files[0].Hunks[0].Lines[1].Text, Equals, "café\n")
+
+	c.Assert(d.Decode(&m), Equals, io.EOF)
+}
+
+func (s *DecoderSuite) TestDecodeWithoutFromLine(c *C) {
+	d := NewDecoder(strings.NewReader(`From: A <a@example.com>
+Subject: [PATCH] Fix
+
+---
+`))
+
+	var m Message
+	c.Assert(d.Decode(&m), IsNil)
+	c.Assert(m.Author.Email, Equals, "a@example.com")
+	c.Assert(m.Author.When.IsZero(), Equals, true)
+	c.Assert(m.Subject, Equals, "Fix")
+	c.Assert(m.Patch.FilePatches(), HasLen, 0)
+
+	c.Assert(d.Decode(&m), Equals, io.EOF)
+}
+
+func (s *DecoderSuite) TestDecodeMissingAuthor(c *C) {
+	var m Message
+	err := NewDecoder(strings.NewReader("Subject: [PATCH] Fix\n\n")).Decode(&m)
+	c.Assert(err, Equals, ErrMissingAuthor)
+}
+
+func (s *DecoderSuite) TestParseSubject(c *C) {
+	for _, t := range []struct {
+		subject       string
+		expected      string
+		number, total int
+	}{
+		{"Fix", "Fix", 0, 0},
+		{"[PATCH] Fix", "Fix", 0, 0},
+		{"Re: [PATCH 3/12] Fix  it", "Fix it", 3, 12},
+		{"[PATCH v3 01/10] [net] Fix", "Fix", 1, 10},
+		{"[PATCH Fix", "[PATCH Fix", 0, 0},
+	} {
+		subject, number, total := parseSubject(t.subject)
+		c.Assert(subject, Equals, t.expected)
+		c.Assert(number, Equals, t.number)
+		c.Assert(total, Equals, t.total)
+	}
+}
@@ -0,0 +1,3 @@
+// Package mbox implements encoding and decoding of patches as emails, in the
+// mbox format written by git format-patch and read by git am.
+package mbox
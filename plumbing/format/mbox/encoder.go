@@ -0,0 +1,181 @@
+package mbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+)
+
+const (
+	// DefaultSubjectPrefix is the default prefix of the subject of the
+	// emails.
+	DefaultSubjectPrefix = "PATCH"
+
+	// fromLineDate is the fixed date of the "From " lines of the emails,
+	// which git uses to recognize its own output.
+	fromLineDate = "Mon Sep 17 00:00:00 2001"
+
+	// statWidth is the width of the diffstat of the emails.
+	statWidth = 72
+
+	// rfc2822Date is the format of the Date header.
+	rfc2822Date = "Mon, 2 Jan 2006 15:04:05 -0700"
+)
+
+// Encoder writes patches as emails of an mbox, in the format of git
+// format-patch. The diffs include the full patches of the binary files when
+// their content is available.
+type Encoder struct {
+	w io.Writer
+
+	subjectPrefix string
+	signature     string
+	contextLines  int
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		w:             w,
+		subjectPrefix: DefaultSubjectPrefix,
+		contextLines:  fdiff.DefaultContextLines,
+	}
+}
+
+// SetSubjectPrefix sets the text between the brackets starting the subjects,
+// "PATCH" by default, and returns e.
+func (e *Encoder) SetSubjectPrefix(prefix string) *Encoder {
+	e.subjectPrefix = prefix
+	return e
+}
+
+// SetSignature sets the signature written after the diffs, none by default,
+// and returns e.
+func (e *Encoder) SetSignature(signature string) *Encoder {
+	e.signature = signature
+	return e
+}
+
+// SetContextLines sets the number of context lines of the diffs and returns
+// e.
+func (e *Encoder) SetContextLines(n int) *Encoder {
+	e.contextLines = n
+	return e
+}
+
+// Encode writes m as an email.
+func (e *Encoder) Encode(m *Message) error {
+	diff := bytes.NewBuffer(nil)
+	if m.Patch != nil && len(m.Patch.FilePatches()) > 0 {
+		if err := fdiff.NewStatEncoder(diff, fdiff.Stat).SetWidth(statWidth).Encode(m.Patch); err != nil {
+			return err
+		}
+
+		writeSummary(diff, m.Patch)
+		diff.WriteByte('\n')
+
+		ue := fdiff.NewUnifiedEncoder(diff, e.contextLines).SetBinary(true)
+		if err := ue.Encode(m.Patch); err != nil {
+			return err
+		}
+	}
+
+	sb := &strings.Builder{}
+	fmt.Fprintf(sb, "From %s %s\n", m.Hash, fromLineDate)
+	fmt.Fprintf(sb, "From: %s <%s>\n", encodeName(m.Author.Name), m.Author.Email)
+	fmt.Fprintf(sb, "Date: %s\n", m.Author.When.Format(rfc2822Date))
+	fmt.Fprintf(sb, "Subject: %s%s\n", e.subjectPrefixOf(m), encodeHeader(m.Subject))
+	if !isASCII(m.Subject+m.Body) || !isASCII(diff.String()) {
+		sb.WriteString("MIME-Version: 1.0\n")
+		sb.WriteString("Content-Type: text/plain; charset=UTF-8\n")
+		sb.WriteString("Content-Transfer-Encoding: 8bit\n")
+	}
+
+	sb.WriteByte('\n')
+	sb.WriteString(m.Body)
+	sb.WriteString("---\n")
+	sb.WriteString(diff.String())
+
+	if e.signature != "" {
+		fmt.Fprintf(sb, "-- \n%s\n", strings.TrimRight(e.signature, "\n"))
+	}
+
+	sb.WriteByte('\n')
+	_, err := io.WriteString(e.w, sb.String())
+	return err
+}
+
+func (e *Encoder) subjectPrefixOf(m *Message) string {
+	prefix := e.subjectPrefix
+	if m.Total > 1 {
+		prefix = strings.TrimSpace(fmt.Sprintf("%s %d/%d", prefix, m.Number, m.Total))
+	}
+
+	if prefix == "" {
+		return ""
+	}
+
+	return "[" + prefix + "] "
+}
+
+// writeSummary writes the creations, deletions and mode changes of the files,
+// as the --summary option of git diff.
+func writeSummary(w io.Writer, patch fdiff.Patch) {
+	for _, fp := range patch.FilePatches() {
+		from, to := fp.Files()
+		switch {
+		case from == nil && to != nil:
+			fmt.Fprintf(w, " create mode %o %s\n", to.Mode(), to.Path())
+		case to == nil && from != nil:
+			fmt.Fprintf(w, " delete mode %o %s\n", from.Mode(), from.Path())
+		case from != nil && from.Mode() != to.Mode():
+			fmt.Fprintf(w, " mode change %o => %o %s\n", from.Mode(), to.Mode(), to.Path())
+		}
+	}
+}
+
+// encodeHeader encodes the text of a header as RFC 2047 words if it isn't
+// only made of ASCII chars.
+func encodeHeader(s string) string {
+	if isASCII(s) {
+		return s
+	}
+
+	return qEncode(s)
+}
+
+// encodeName encodes the name of an address, quoting it if it contains
+// special chars.
+func encodeName(name string) string {
+	if !isASCII(name) {
+		return qEncode(name)
+	}
+
+	if strings.ContainsAny(name, `()<>[]:;@\,."`) {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+	}
+
+	return name
+}
+
+// qEncode encodes s as RFC 2047 Q encoded words, writing the spaces as "=20"
+// like git does.
+func qEncode(s string) string {
+	// The underscores of s are encoded, the remaining ones are spaces.
+	return strings.Replace(mime.QEncoding.Encode("UTF-8", s), "_", "=20", -1)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
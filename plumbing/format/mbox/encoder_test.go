@@ -0,0 +1,133 @@
+package mbox
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type EncoderSuite struct {
+	fixtures.Suite
+	Storer *filesystem.Storage
+}
+
+var _ = Suite(&EncoderSuite{})
+
+func (s *EncoderSuite) SetUpSuite(c *C) {
+	s.Storer = filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+}
+
+func (s *EncoderSuite) message(c *C, h string) *Message {
+	commit, err := object.GetCommit(s.Storer, plumbing.NewHash(h))
+	c.Assert(err, IsNil)
+
+	m, err := NewMessage(commit)
+	c.Assert(err, IsNil)
+	return m
+}
+
+func (s *EncoderSuite) TestEncode(c *C) {
+	buf := bytes.NewBuffer(nil)
+	m := s.message(c, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	err := NewEncoder(buf).SetSignature("2.39.5").Encode(m)
+	c.Assert(err, IsNil)
+
+	// Same as git format-patch --full-index.
+	c.Assert(buf.String(), Equals, `From 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 Mon Sep 17 00:00:00 2001
+From: =?UTF-8?q?M=C3=A1ximo=20Cuadros=20Ortiz?= <mcuadros@gmail.com>
+Date: Sun, 5 Apr 2015 23:30:47 +0200
+Subject: [PATCH] vendor stuff
+
+---
+ vendor/foo.go | 7 +++++++
+ 1 file changed, 7 insertions(+)
+ create mode 100644 vendor/foo.go
+
+diff --git a/vendor/foo.go b/vendor/foo.go
+new file mode 100644
+index 0000000000000000000000000000000000000000..9dea2395f5403188298c1dabe8bdafe562c491e3
+--- /dev/null
++++ b/vendor/foo.go
+@@ -0,0 +1,7 @@
++package main
++
++import "fmt"
++
++func main() {
++	fmt.Println("Hello, playground")
++}
+-- 
+2.39.5
+
+`)
+}
+
+func (s *EncoderSuite) TestEncodeDecodeBinary(c *C) {
+	buf := bytes.NewBuffer(nil)
+	m := s.message(c, "35e85108805c84807bc66a02d91535e1e24b38b9")
+	m.Number, m.Total = 2, 3
+	err := NewEncoder(buf).SetSubjectPrefix("RFC").Encode(m)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(buf.String(), "Subject: [RFC 2/3] binary file\n"), Equals, true)
+	c.Assert(strings.Contains(buf.String(), " binary.jpg | Bin 0 -> 76110 bytes\n"), Equals, true)
+
+	var decoded Message
+	err = NewDecoder(buf).Decode(&decoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded.Hash, Equals, m.Hash)
+	c.Assert(decoded.Author.Name, Equals, m.Author.Name)
+	c.Assert(decoded.Author.Email, Equals, m.Author.Email)
+	c.Assert(decoded.Author.When.Equal(m.Author.When), Equals, true)
+	c.Assert(decoded.CommitMessage(), Equals, "binary file\n")
+	c.Assert(decoded.Number, Equals, 2)
+	c.Assert(decoded.Total, Equals, 3)
+
+	p := decoded.Patch.(*fdiff.ParsedPatch)
+	c.Assert(p.Files, HasLen, 1)
+	c.Assert(p.Files[0].From, IsNil)
+	c.Assert(p.Files[0].To.Hash(), Equals, plumbing.NewHash("d5c0f4ab811897cadf03aec358ae60d21f91c50d"))
+	c.Assert(p.Files[0].BinaryPatch.Forward.Data, HasLen, 76110)
+}
+
+func (s *EncoderSuite) TestEncodeNonASCII(c *C) {
+	buf := bytes.NewBuffer(nil)
+	err := NewEncoder(buf).Encode(&Message{
+		Author: object.Signature{
+			Name:  "Dev, Jr.",
+			Email: "dev@example.com",
+			When:  time.Date(2020, 10, 14, 12, 0, 0, 0, time.FixedZone("", -3*60*60)),
+		},
+		Subject: "Café_au lait",
+		Body:    "Crème.\n",
+		Patch:   &fdiff.ParsedPatch{},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `From 0000000000000000000000000000000000000000 Mon Sep 17 00:00:00 2001
+From: "Dev, Jr." <dev@example.com>
+Date: Wed, 14 Oct 2020 12:00:00 -0300
+Subject: [PATCH] =?UTF-8?q?Caf=C3=A9=5Fau=20lait?=
+MIME-Version: 1.0
+Content-Type: text/plain; charset=UTF-8
+Content-Transfer-Encoding: 8bit
+
+Crème.
+---
+
+`)
+
+	var decoded Message
+	err = NewDecoder(buf).Decode(&decoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded.Author.Name, Equals, "Dev, Jr.")
+	c.Assert(decoded.Subject, Equals, "Café_au lait")
+	c.Assert(decoded.Body, Equals, "Crème.\n")
+}
@@ -0,0 +1,117 @@
+package mbox
+
+import (
+	"context"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// Message is a patch email.
+type Message struct {
+	// Hash is the hash of the commit the patch comes from, plumbing.ZeroHash
+	// if unknown.
+	Hash plumbing.Hash
+	// Author is the author of the patch, its date being the one of the
+	// email.
+	Author object.Signature
+	// Subject is the first paragraph of the commit message, without the
+	// "[PATCH]" prefix of the email subject.
+	Subject string
+	// Body is the rest of the commit message.
+	Body string
+	// Number is the position of the patch in its series, starting at one,
+	// and Total the number of patches of the series. A patch sent alone has
+	// a zero Total.
+	Number, Total int
+	// Patch is the diff of the patch. The Decoder sets it to a
+	// *fdiff.ParsedPatch.
+	Patch fdiff.Patch
+}
+
+// NewMessage returns the message of the patch introduced by c, the diff
+// between c and its first parent, with renames detected.
+func NewMessage(c *object.Commit) (*Message, error) {
+	to, err := c.Tree()
+	if err != nil {
+		return nil, err
+	}
+
+	from := &object.Tree{}
+	if c.NumParents() > 0 {
+		parent, err := c.Parent(0)
+		if err != nil {
+			return nil, err
+		}
+
+		from, err = parent.Tree()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	changes, err := object.DiffTreeWithOptions(context.Background(), from, to, object.DefaultDiffTreeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := changes.Patch()
+	if err != nil {
+		return nil, err
+	}
+
+	subject, body := splitCommitMessage(c.Message)
+	return &Message{
+		Hash:    c.Hash,
+		Author:  c.Author,
+		Subject: subject,
+		Body:    body,
+		Patch:   patch,
+	}, nil
+}
+
+// CommitMessage returns the commit message of the patch.
+func (m *Message) CommitMessage() string {
+	if m.Body == "" {
+		return m.Subject + "\n"
+	}
+
+	return m.Subject + "\n\n" + strings.TrimRight(m.Body, "\n") + "\n"
+}
+
+// splitCommitMessage returns the first paragraph of a commit message joined
+// in a single line, and the rest of the message.
+func splitCommitMessage(msg string) (subject, body string) {
+	msg = strings.TrimLeft(msg, "\n")
+	var lines []string
+	for msg != "" {
+		var line string
+		if i := strings.IndexByte(msg, '\n'); i >= 0 {
+			line, msg = msg[:i], msg[i+1:]
+		} else {
+			line, msg = msg, ""
+		}
+
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+
+		lines = append(lines, strings.TrimSpace(line))
+	}
+
+	return strings.Join(lines, " "), trimBody(msg)
+}
+
+// trimBody removes the leading and trailing blank lines of a body, ending it
+// with a single newline if it isn't empty.
+func trimBody(body string) string {
+	body = strings.TrimLeft(body, "\n")
+	body = strings.TrimRight(body, " \t\n")
+	if body != "" {
+		body += "\n"
+	}
+
+	return body
+}
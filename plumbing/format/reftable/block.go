@@ -0,0 +1,211 @@
+package reftable
+
+import (
+	"bytes"
+	"compress/zlib"
+	"sort"
+)
+
+// blockWriter builds a single block. The buffer starts with headerOff bytes
+// reserved for the file header, when writing the first block of a table,
+// followed by the 4-byte block header.
+type blockWriter struct {
+	typ       byte
+	blockSize int
+	headerOff int
+
+	buf      []byte
+	lastKey  []byte
+	restarts []uint32
+	entries  int
+}
+
+func newBlockWriter(typ byte, blockSize, headerOff int) *blockWriter {
+	return &blockWriter{
+		typ:       typ,
+		blockSize: blockSize,
+		headerOff: headerOff,
+		buf:       make([]byte, headerOff+4),
+	}
+}
+
+// add appends a record to the block, returning false if it doesn't fit. A
+// record is always accepted by an empty block.
+func (w *blockWriter) add(key []byte, valueType byte, value []byte) bool {
+	restart := w.entries%restartInterval == 0
+	prefix := 0
+	if !restart {
+		prefix = commonPrefix(w.lastKey, key)
+	}
+
+	rec := putVarInt(nil, uint64(prefix))
+	rec = putVarInt(rec, uint64(len(key)-prefix)<<3|uint64(valueType))
+	rec = append(rec, key[prefix:]...)
+	rec = append(rec, value...)
+
+	restarts := len(w.restarts)
+	if restart {
+		restarts++
+	}
+
+	if w.entries > 0 && len(w.buf)+len(rec)+3*restarts+2 > w.blockSize {
+		return false
+	}
+
+	if restart {
+		w.restarts = append(w.restarts, uint32(len(w.buf)))
+	}
+
+	w.buf = append(w.buf, rec...)
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.entries++
+	return true
+}
+
+// finish returns the encoded block. Log blocks are compressed, except for
+// their headers.
+func (w *blockWriter) finish() ([]byte, error) {
+	for _, r := range w.restarts {
+		w.buf = appendUint24(w.buf, r)
+	}
+
+	w.buf = append(w.buf, byte(len(w.restarts)>>8), byte(len(w.restarts)))
+
+	w.buf[w.headerOff] = w.typ
+	putUint24(w.buf[w.headerOff+1:], uint32(len(w.buf)))
+
+	if w.typ != blockTypeLog {
+		return w.buf, nil
+	}
+
+	out := bytes.NewBuffer(nil)
+	out.Write(w.buf[:w.headerOff+4])
+	zw := zlib.NewWriter(out)
+	if _, err := zw.Write(w.buf[w.headerOff+4:]); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// block is a decoded block. data holds the whole block, including the file
+// header for the first block, and records are stored between recordsStart
+// and restartsStart.
+type block struct {
+	typ           byte
+	data          []byte
+	recordsStart  int
+	restartsStart int
+	restarts      []int
+}
+
+func newBlock(data []byte, headerOff int) (*block, error) {
+	if len(data) < headerOff+6 {
+		return nil, ErrMalformedTable
+	}
+
+	b := &block{
+		typ:          data[headerOff],
+		data:         data,
+		recordsStart: headerOff + 4,
+	}
+
+	count := int(data[len(data)-2])<<8 | int(data[len(data)-1])
+	b.restartsStart = len(data) - 2 - 3*count
+	if b.restartsStart < b.recordsStart {
+		return nil, ErrMalformedTable
+	}
+
+	for i := 0; i < count; i++ {
+		off := int(getUint24(data[b.restartsStart+3*i:]))
+		if off < b.recordsStart || off >= b.restartsStart {
+			return nil, ErrMalformedTable
+		}
+
+		b.restarts = append(b.restarts, off)
+	}
+
+	return b, nil
+}
+
+// blockIter iterates over the records of a block.
+type blockIter struct {
+	b   *block
+	pos int
+	key []byte
+}
+
+func (b *block) iter(pos int) *blockIter {
+	return &blockIter{b: b, pos: pos}
+}
+
+// next decodes the next record, returning its key, value type and value. The
+// returned value extends up to the end of the records, the caller must
+// report how much of it was consumed with skip.
+func (it *blockIter) next() (key []byte, valueType byte, value []byte, ok bool, err error) {
+	if it.pos >= it.b.restartsStart {
+		return nil, 0, nil, false, nil
+	}
+
+	data := it.b.data[it.pos:it.b.restartsStart]
+	prefix, n := getVarInt(data)
+	if n == 0 {
+		return nil, 0, nil, false, ErrMalformedTable
+	}
+
+	data = data[n:]
+	it.pos += n
+
+	suffix, n := getVarInt(data)
+	if n == 0 {
+		return nil, 0, nil, false, ErrMalformedTable
+	}
+
+	data = data[n:]
+	it.pos += n
+
+	valueType = byte(suffix & 0x7)
+	suffixLen := int(suffix >> 3)
+	if int(prefix) > len(it.key) || suffixLen > len(data) {
+		return nil, 0, nil, false, ErrMalformedTable
+	}
+
+	it.key = append(it.key[:prefix], data[:suffixLen]...)
+	it.pos += suffixLen
+
+	return it.key, valueType, data[suffixLen:], true, nil
+}
+
+func (it *blockIter) skip(n int) {
+	it.pos += n
+}
+
+// seek returns an iterator positioned on the last restart point whose key is
+// lower or equal than key, so a linear scan from it finds key if present.
+func (b *block) seek(key []byte) (*blockIter, error) {
+	var err error
+	i := sort.Search(len(b.restarts), func(i int) bool {
+		it := b.iter(b.restarts[i])
+		k, _, _, _, e := it.next()
+		if e != nil {
+			err = e
+			return true
+		}
+
+		return bytes.Compare(k, key) > 0
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if i == 0 {
+		return b.iter(b.recordsStart), nil
+	}
+
+	return b.iter(b.restarts[i-1]), nil
+}
@@ -0,0 +1,30 @@
+// Package reftable implements encoding and decoding of reftable files.
+//
+// Git reftable format
+// ===================
+//
+// The reftable format is a binary format storing references and their logs,
+// used by git as an alternative to loose references and packed-refs. A
+// repository keeps a stack of reftable files in `.git/reftable`, listed from
+// the oldest to the newest in `.git/reftable/tables.list`. Newer tables
+// override the records of older ones, including deletion records.
+//
+// Every table starts with a header:
+//
+//   - The 4-byte magic 'REFT'.
+//   - A 1-byte version, 1 or 2.
+//   - A 3-byte block size.
+//   - The 8-byte minimum and maximum update index of the records.
+//   - Version 2 only: a 4-byte hash function identifier.
+//
+// Followed by a section of ref blocks, optionally indexed, an optional
+// section of object blocks and a section of zlib compressed log blocks.
+// Blocks store records sorted by key, prefix compressed against the previous
+// record, with a table of restart points at the end of the block.
+//
+// Every table ends with a footer holding a copy of the header, the position
+// of each section and a CRC-32 of the footer.
+//
+// The format is described in depth at
+// https://git-scm.com/docs/reftable
+package reftable
@@ -0,0 +1,570 @@
+package reftable
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Reader reads the records of a reftable file.
+type Reader struct {
+	r    io.ReaderAt
+	size int64
+
+	version    byte
+	headerSize int
+	footerPos  int64
+	blockSize  uint32
+
+	minUpdateIndex uint64
+	maxUpdateIndex uint64
+	refIndexPos    uint64
+	logPos         uint64
+
+	hasRefs bool
+	hasLogs bool
+}
+
+// NewReader returns a Reader for the reftable of the given size read from r.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	t := &Reader{r: r, size: size}
+	if err := t.readHeader(); err != nil {
+		return nil, err
+	}
+
+	if err := t.readFooter(); err != nil {
+		return nil, err
+	}
+
+	if t.footerPos > int64(t.headerSize) {
+		typ := make([]byte, 1)
+		if _, err := r.ReadAt(typ, int64(t.headerSize)); err != nil {
+			return nil, err
+		}
+
+		t.hasRefs = typ[0] == blockTypeRef
+		t.hasLogs = typ[0] == blockTypeLog || t.logPos > 0
+	}
+
+	return t, nil
+}
+
+func (t *Reader) readHeader() error {
+	h := make([]byte, headerSizeV1)
+	if _, err := t.r.ReadAt(h, 0); err != nil {
+		return ErrMalformedTable
+	}
+
+	if !bytes.Equal(h[:4], magic) {
+		return ErrMalformedTable
+	}
+
+	t.version = h[4]
+	switch t.version {
+	case 1:
+		t.headerSize = headerSizeV1
+		t.footerPos = t.size - footerSizeV1
+	case 2:
+		t.headerSize = headerSizeV2
+		t.footerPos = t.size - footerSizeV2
+	default:
+		return ErrUnsupportedVersion
+	}
+
+	if t.footerPos < int64(t.headerSize) {
+		return ErrMalformedTable
+	}
+
+	t.blockSize = getUint24(h[5:])
+	t.minUpdateIndex = binary.BigEndian.Uint64(h[8:])
+	t.maxUpdateIndex = binary.BigEndian.Uint64(h[16:])
+	return nil
+}
+
+func (t *Reader) readFooter() error {
+	f := make([]byte, t.size-t.footerPos)
+	if _, err := t.r.ReadAt(f, t.footerPos); err != nil {
+		return err
+	}
+
+	header := make([]byte, t.headerSize)
+	if _, err := t.r.ReadAt(header, 0); err != nil {
+		return err
+	}
+
+	if !bytes.Equal(f[:t.headerSize], header) {
+		return ErrMalformedTable
+	}
+
+	if t.version == 2 && binary.BigEndian.Uint32(header[24:]) != hashIDSHA1 {
+		return ErrUnsupportedHash
+	}
+
+	crc := binary.BigEndian.Uint32(f[len(f)-4:])
+	if crc32.ChecksumIEEE(f[:len(f)-4]) != crc {
+		return ErrMalformedTable
+	}
+
+	f = f[t.headerSize:]
+	t.refIndexPos = binary.BigEndian.Uint64(f[0:])
+	t.logPos = binary.BigEndian.Uint64(f[24:])
+	return nil
+}
+
+// MinUpdateIndex returns the minimum update index of the records.
+func (t *Reader) MinUpdateIndex() uint64 {
+	return t.minUpdateIndex
+}
+
+// MaxUpdateIndex returns the maximum update index of the records.
+func (t *Reader) MaxUpdateIndex() uint64 {
+	return t.maxUpdateIndex
+}
+
+// Size returns the size in bytes of the table.
+func (t *Reader) Size() int64 {
+	return t.size
+}
+
+// readBlock reads the ref or index block at pos, returning nil if there is
+// no such block at pos. It also returns the position of the next block,
+// skipping the padding if any.
+func (t *Reader) readBlock(pos int64) (*block, int64, error) {
+	headerOff := 0
+	if pos == 0 {
+		headerOff = t.headerSize
+	}
+
+	if pos+int64(headerOff)+4 > t.footerPos {
+		return nil, 0, nil
+	}
+
+	h := make([]byte, 4)
+	if _, err := t.r.ReadAt(h, pos+int64(headerOff)); err != nil {
+		return nil, 0, err
+	}
+
+	if h[0] != blockTypeRef && h[0] != blockTypeIndex {
+		return nil, 0, nil
+	}
+
+	blockLen := int64(getUint24(h[1:]))
+	if blockLen < int64(headerOff)+4 || pos+blockLen > t.footerPos {
+		return nil, 0, ErrMalformedTable
+	}
+
+	data := make([]byte, blockLen)
+	if _, err := t.r.ReadAt(data, pos); err != nil {
+		return nil, 0, err
+	}
+
+	b, err := newBlock(data, headerOff)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	next := pos + blockLen
+	if blockLen < int64(t.blockSize) && next < t.footerPos {
+		// The block is either padded up to the block size, or the next
+		// block follows it immediately.
+		peek := make([]byte, 1)
+		if _, err := t.r.ReadAt(peek, next); err != nil {
+			return nil, 0, err
+		}
+
+		if peek[0] == 0 {
+			next = pos + int64(t.blockSize)
+		}
+	}
+
+	return b, next, nil
+}
+
+// Ref returns the record of the named reference, or
+// plumbing.ErrReferenceNotFound if the table doesn't contain it. A returned
+// record may be a deletion.
+func (t *Reader) Ref(name string) (*RefRecord, error) {
+	if !t.hasRefs {
+		return nil, plumbing.ErrReferenceNotFound
+	}
+
+	key := []byte(name)
+	pos := int64(0)
+	if t.refIndexPos > 0 {
+		var err error
+		pos, err = t.seekIndex(int64(t.refIndexPos), key)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for pos >= 0 {
+		b, next, err := t.readBlock(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		if b == nil || b.typ != blockTypeRef {
+			break
+		}
+
+		it, err := b.seek(key)
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			rec, ok, err := t.nextRef(it)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				break
+			}
+
+			switch c := bytes.Compare([]byte(rec.Name), key); {
+			case c == 0:
+				return rec, nil
+			case c > 0:
+				return nil, plumbing.ErrReferenceNotFound
+			}
+		}
+
+		if t.refIndexPos > 0 {
+			break
+		}
+
+		pos = next
+	}
+
+	return nil, plumbing.ErrReferenceNotFound
+}
+
+// seekIndex walks down the ref index starting at pos, returning the position
+// of the ref block which may contain key, or -1 if none can.
+func (t *Reader) seekIndex(pos int64, key []byte) (int64, error) {
+	for {
+		b, _, err := t.readBlock(pos)
+		if err != nil {
+			return 0, err
+		}
+
+		if b == nil {
+			return 0, ErrMalformedTable
+		}
+
+		if b.typ == blockTypeRef {
+			return pos, nil
+		}
+
+		it, err := b.seek(key)
+		if err != nil {
+			return 0, err
+		}
+
+		found := false
+		for {
+			k, _, value, ok, err := it.next()
+			if err != nil {
+				return 0, err
+			}
+
+			if !ok {
+				break
+			}
+
+			v, n := getVarInt(value)
+			if n == 0 {
+				return 0, ErrMalformedTable
+			}
+
+			it.skip(n)
+			if bytes.Compare(k, key) >= 0 {
+				pos, found = int64(v), true
+				break
+			}
+		}
+
+		if !found {
+			return -1, nil
+		}
+	}
+}
+
+// Refs returns all the ref records of the table, sorted by name.
+func (t *Reader) Refs() ([]*RefRecord, error) {
+	if !t.hasRefs {
+		return nil, nil
+	}
+
+	var refs []*RefRecord
+	pos := int64(0)
+	for {
+		b, next, err := t.readBlock(pos)
+		if err != nil {
+			return nil, err
+		}
+
+		if b == nil || b.typ != blockTypeRef {
+			return refs, nil
+		}
+
+		it := b.iter(b.recordsStart)
+		for {
+			rec, ok, err := t.nextRef(it)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				break
+			}
+
+			refs = append(refs, rec)
+		}
+
+		pos = next
+	}
+}
+
+func (t *Reader) nextRef(it *blockIter) (*RefRecord, bool, error) {
+	key, valueType, value, ok, err := it.next()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	rec := &RefRecord{Name: string(key)}
+	delta, n := getVarInt(value)
+	if n == 0 {
+		return nil, false, ErrMalformedTable
+	}
+
+	rec.UpdateIndex = t.minUpdateIndex + delta
+	value = value[n:]
+	switch valueType {
+	case refValueDeletion:
+		rec.Deleted = true
+	case refValueHash:
+		if len(value) < hashSize {
+			return nil, false, ErrMalformedTable
+		}
+
+		copy(rec.Hash[:], value)
+		n += hashSize
+	case refValuePeeled:
+		if len(value) < 2*hashSize {
+			return nil, false, ErrMalformedTable
+		}
+
+		copy(rec.Hash[:], value)
+		copy(rec.Peeled[:], value[hashSize:])
+		n += 2 * hashSize
+	case refValueSymbolic:
+		l, m := getVarInt(value)
+		if m == 0 || int(l) > len(value)-m {
+			return nil, false, ErrMalformedTable
+		}
+
+		rec.Target = string(value[m : m+int(l)])
+		n += m + int(l)
+	default:
+		return nil, false, ErrMalformedTable
+	}
+
+	it.skip(n)
+	return rec, true, nil
+}
+
+// Logs returns all the log records of the table, sorted by name and by
+// decreasing update index.
+func (t *Reader) Logs() ([]*LogRecord, error) {
+	if !t.hasLogs {
+		return nil, nil
+	}
+
+	var logs []*LogRecord
+	pos := int64(t.logPos)
+	for pos+4 <= t.footerPos {
+		headerOff := 0
+		if pos == 0 {
+			headerOff = t.headerSize
+		}
+
+		h := make([]byte, headerOff+4)
+		if _, err := t.r.ReadAt(h, pos); err != nil {
+			return nil, err
+		}
+
+		if h[headerOff] != blockTypeLog {
+			break
+		}
+
+		blockLen := int(getUint24(h[headerOff+1:]))
+		if blockLen < len(h) {
+			return nil, ErrMalformedTable
+		}
+
+		start := pos + int64(len(h))
+		cr := &countingReader{r: bufio.NewReader(io.NewSectionReader(t.r, start, t.footerPos-start))}
+		zr, err := zlib.NewReader(cr)
+		if err != nil {
+			return nil, ErrMalformedTable
+		}
+
+		data := make([]byte, blockLen)
+		copy(data, h)
+		if _, err := io.ReadFull(zr, data[len(h):]); err != nil {
+			return nil, ErrMalformedTable
+		}
+
+		// Read up to the end of the stream so the checksum is consumed.
+		if _, err := io.Copy(io.Discard, zr); err != nil {
+			return nil, ErrMalformedTable
+		}
+
+		b, err := newBlock(data, headerOff)
+		if err != nil {
+			return nil, err
+		}
+
+		it := b.iter(b.recordsStart)
+		for {
+			l, ok, err := t.nextLog(it)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				break
+			}
+
+			logs = append(logs, l)
+		}
+
+		pos = start + cr.n
+	}
+
+	return logs, nil
+}
+
+// LogsFor returns the log records of the named reference, from the newest to
+// the oldest.
+func (t *Reader) LogsFor(name string) ([]*LogRecord, error) {
+	logs, err := t.Logs()
+	if err != nil {
+		return nil, err
+	}
+
+	i := sort.Search(len(logs), func(i int) bool { return logs[i].Name >= name })
+	j := i
+	for j < len(logs) && logs[j].Name == name {
+		j++
+	}
+
+	return logs[i:j], nil
+}
+
+func (t *Reader) nextLog(it *blockIter) (*LogRecord, bool, error) {
+	key, valueType, value, ok, err := it.next()
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	if len(key) < 9 || key[len(key)-9] != 0 {
+		return nil, false, ErrMalformedTable
+	}
+
+	l := &LogRecord{
+		Name:        string(key[:len(key)-9]),
+		UpdateIndex: ^binary.BigEndian.Uint64(key[len(key)-8:]),
+	}
+
+	if valueType == logValueDeletion {
+		l.Deleted = true
+		return l, true, nil
+	}
+
+	if valueType != logValueUpdate {
+		return nil, false, ErrMalformedTable
+	}
+
+	d := &logDecoder{data: value}
+	copy(l.Old[:], d.bytes(hashSize))
+	copy(l.New[:], d.bytes(hashSize))
+	l.CommitterName = string(d.bytes(int(d.varInt())))
+	l.CommitterEmail = string(d.bytes(int(d.varInt())))
+	seconds := d.varInt()
+	tz := int16(binary.BigEndian.Uint16(d.bytes(2)))
+	l.Message = string(d.bytes(int(d.varInt())))
+	if d.err {
+		return nil, false, ErrMalformedTable
+	}
+
+	l.When = time.Unix(int64(seconds), 0).In(time.FixedZone("", int(tz)*60))
+	it.skip(d.pos)
+	return l, true, nil
+}
+
+// logDecoder decodes the fields of a log record, recording whether the data
+// was too short instead of failing on each field.
+type logDecoder struct {
+	data []byte
+	pos  int
+	err  bool
+}
+
+func (d *logDecoder) bytes(n int) []byte {
+	if d.err || n < 0 || d.pos+n > len(d.data) {
+		d.err = true
+		return make([]byte, n&0xffff)
+	}
+
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *logDecoder) varInt() uint64 {
+	if d.err {
+		return 0
+	}
+
+	v, n := getVarInt(d.data[d.pos:])
+	if n == 0 {
+		d.err = true
+		return 0
+	}
+
+	d.pos += n
+	return v
+}
+
+// countingReader counts the bytes read through it. It implements
+// io.ByteReader so the decompressor doesn't read ahead.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+
+	return b, err
+}
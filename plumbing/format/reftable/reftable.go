@@ -0,0 +1,218 @@
+package reftable
+
+import (
+	"errors"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+var (
+	// ErrMalformedTable is returned when a reftable file is corrupted.
+	ErrMalformedTable = errors.New("malformed reftable")
+	// ErrUnsupportedVersion is returned when the reftable version is not
+	// supported.
+	ErrUnsupportedVersion = errors.New("unsupported reftable version")
+	// ErrUnsupportedHash is returned when the reftable hash function is not
+	// supported. Currently only SHA-1 is supported.
+	ErrUnsupportedHash = errors.New("unsupported reftable hash algorithm")
+	// ErrUnsortedRecords is returned by the Writer when the records are not
+	// added in order.
+	ErrUnsortedRecords = errors.New("reftable records must be added in order")
+	// ErrRecordTooLarge is returned by the Writer when a ref record doesn't
+	// fit in a block.
+	ErrRecordTooLarge = errors.New("reftable record is larger than the block size")
+)
+
+const (
+	// DefaultBlockSize is the block size used by the Writer when none is
+	// given.
+	DefaultBlockSize = 4096
+
+	// Version is the reftable version written by the Writer.
+	Version = 1
+
+	blockTypeRef   = 'r'
+	blockTypeObj   = 'o'
+	blockTypeLog   = 'g'
+	blockTypeIndex = 'i'
+
+	headerSizeV1 = 24
+	headerSizeV2 = 28
+	footerSizeV1 = 68
+	footerSizeV2 = 72
+
+	// restartInterval is the number of records between two restart points.
+	restartInterval = 16
+	maxBlockSize    = 1<<24 - 1
+
+	refValueDeletion = 0x0
+	refValueHash     = 0x1
+	refValuePeeled   = 0x2
+	refValueSymbolic = 0x3
+
+	logValueDeletion = 0x0
+	logValueUpdate   = 0x1
+
+	hashSize   = len(plumbing.ZeroHash)
+	hashIDSHA1 = 0x73686131 // "sha1"
+)
+
+var magic = []byte{'R', 'E', 'F', 'T'}
+
+// RefRecord is a reference stored in a reftable.
+type RefRecord struct {
+	// Name is the full name of the reference.
+	Name string
+	// UpdateIndex is the update index of the transaction which wrote the
+	// record.
+	UpdateIndex uint64
+	// Deleted is true if the record marks the deletion of the reference.
+	Deleted bool
+	// Hash is the object the reference points to.
+	Hash plumbing.Hash
+	// Peeled is the object an annotated tag peels to, if known.
+	Peeled plumbing.Hash
+	// Target is the target of a symbolic reference.
+	Target string
+}
+
+// NewRefRecord returns the RefRecord of a reference.
+func NewRefRecord(r *plumbing.Reference, updateIndex uint64) *RefRecord {
+	rec := &RefRecord{Name: r.Name().String(), UpdateIndex: updateIndex}
+	if r.Type() == plumbing.SymbolicReference {
+		rec.Target = r.Target().String()
+	} else {
+		rec.Hash = r.Hash()
+	}
+
+	return rec
+}
+
+// Reference returns the reference stored in the record, nil if the record
+// is a deletion.
+func (r *RefRecord) Reference() *plumbing.Reference {
+	if r.Deleted {
+		return nil
+	}
+
+	if r.Target != "" {
+		return plumbing.NewSymbolicReference(
+			plumbing.ReferenceName(r.Name), plumbing.ReferenceName(r.Target),
+		)
+	}
+
+	return plumbing.NewHashReference(plumbing.ReferenceName(r.Name), r.Hash)
+}
+
+func (r *RefRecord) valueType() byte {
+	switch {
+	case r.Deleted:
+		return refValueDeletion
+	case r.Target != "":
+		return refValueSymbolic
+	case !r.Peeled.IsZero():
+		return refValuePeeled
+	default:
+		return refValueHash
+	}
+}
+
+// LogRecord is a reflog entry stored in a reftable.
+type LogRecord struct {
+	// Name is the full name of the reference.
+	Name string
+	// UpdateIndex is the update index of the transaction which wrote the
+	// record.
+	UpdateIndex uint64
+	// Deleted is true if the record marks the deletion of the log entry.
+	Deleted bool
+	// Old and New are the values of the reference before and after the
+	// update.
+	Old plumbing.Hash
+	New plumbing.Hash
+	// Name and email of the committer who did the update.
+	CommitterName  string
+	CommitterEmail string
+	// When is the moment of the update, with the committer timezone.
+	When time.Time
+	// Message is the reflog message.
+	Message string
+}
+
+// key returns the key of the record: the reference name followed by a NUL
+// byte and the reversed update index, so the newest entries come first.
+func (l *LogRecord) key() []byte {
+	key := make([]byte, 0, len(l.Name)+9)
+	key = append(key, l.Name...)
+	key = append(key, 0)
+	return appendUint64(key, ^l.UpdateIndex)
+}
+
+// putVarInt appends the git reftable variable length encoding of v to b. It
+// is the same encoding used for the offsets of ofs-delta objects.
+func putVarInt(b []byte, v uint64) []byte {
+	var buf [10]byte
+	i := len(buf) - 1
+	buf[i] = byte(v & 0x7f)
+	for v >>= 7; v != 0; v >>= 7 {
+		v--
+		i--
+		buf[i] = 0x80 | byte(v&0x7f)
+	}
+
+	return append(b, buf[i:]...)
+}
+
+// getVarInt decodes a variable length integer, returning it and the number
+// of bytes read, or 0 if the buffer is too short.
+func getVarInt(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+
+	v := uint64(b[0] & 0x7f)
+	n := 1
+	for b[n-1]&0x80 != 0 {
+		if n >= len(b) || n >= 10 {
+			return 0, 0
+		}
+
+		v = ((v + 1) << 7) | uint64(b[n]&0x7f)
+		n++
+	}
+
+	return v, n
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b,
+		byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v),
+	)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint24(b []byte, v uint32) []byte {
+	return append(b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0], b[1], b[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+func getUint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
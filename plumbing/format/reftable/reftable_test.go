@@ -0,0 +1,212 @@
+package reftable
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ReftableSuite struct{}
+
+var _ = Suite(&ReftableSuite{})
+
+var (
+	hashA = plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hashB = plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881")
+)
+
+func (s *ReftableSuite) write(c *C, o WriterOptions, refs []*RefRecord, logs []*LogRecord) *Reader {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf, o)
+	for _, r := range refs {
+		c.Assert(w.AddRef(r), IsNil)
+	}
+
+	for _, l := range logs {
+		c.Assert(w.AddLog(l), IsNil)
+	}
+
+	c.Assert(w.Close(), IsNil)
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	c.Assert(err, IsNil)
+	return r
+}
+
+func (s *ReftableSuite) TestVarInt(c *C) {
+	for _, v := range []uint64{0, 1, 127, 128, 16511, 16512, 1 << 32, 1<<63 + 5} {
+		b := putVarInt(nil, v)
+		got, n := getVarInt(b)
+		c.Assert(n, Equals, len(b))
+		c.Assert(got, Equals, v)
+	}
+
+	_, n := getVarInt([]byte{0x80})
+	c.Assert(n, Equals, 0)
+}
+
+func (s *ReftableSuite) TestEmpty(c *C) {
+	r := s.write(c, WriterOptions{MinUpdateIndex: 1, MaxUpdateIndex: 1}, nil, nil)
+	c.Assert(r.MinUpdateIndex(), Equals, uint64(1))
+	c.Assert(r.MaxUpdateIndex(), Equals, uint64(1))
+
+	refs, err := r.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+
+	_, err = r.Ref("HEAD")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReftableSuite) TestRefs(c *C) {
+	refs := []*RefRecord{
+		{Name: "HEAD", UpdateIndex: 1, Target: "refs/heads/master"},
+		{Name: "refs/heads/master", UpdateIndex: 2, Hash: hashA},
+		{Name: "refs/heads/removed", UpdateIndex: 3, Deleted: true},
+		{Name: "refs/tags/v1.0.0", UpdateIndex: 3, Hash: hashA, Peeled: hashB},
+	}
+
+	r := s.write(c, WriterOptions{MinUpdateIndex: 1, MaxUpdateIndex: 3}, refs, nil)
+
+	got, err := r.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, refs)
+
+	for _, ref := range refs {
+		rec, err := r.Ref(ref.Name)
+		c.Assert(err, IsNil)
+		c.Assert(rec, DeepEquals, ref)
+	}
+
+	_, err = r.Ref("refs/heads/foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	c.Assert(refs[0].Reference(), DeepEquals,
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master))
+	c.Assert(refs[1].Reference(), DeepEquals,
+		plumbing.NewHashReference(plumbing.Master, hashA))
+	c.Assert(refs[2].Reference(), IsNil)
+}
+
+func (s *ReftableSuite) TestRefsIndexed(c *C) {
+	var refs []*RefRecord
+	for i := 0; i < 5000; i++ {
+		refs = append(refs, &RefRecord{
+			Name:        fmt.Sprintf("refs/heads/branch-%05d", i),
+			UpdateIndex: 1,
+			Hash:        hashA,
+		})
+	}
+
+	r := s.write(c, WriterOptions{BlockSize: 256, MinUpdateIndex: 1, MaxUpdateIndex: 1}, refs, nil)
+	c.Assert(r.refIndexPos, Not(Equals), uint64(0))
+
+	got, err := r.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, refs)
+
+	for _, i := range []int{0, 1, 15, 16, 17, 1234, 4999} {
+		rec, err := r.Ref(refs[i].Name)
+		c.Assert(err, IsNil)
+		c.Assert(rec, DeepEquals, refs[i])
+	}
+
+	_, err = r.Ref("refs/heads/branch-99999")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+	_, err = r.Ref("refs/heads/a")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReftableSuite) TestLogs(c *C) {
+	when := time.Unix(1600000000, 0).In(time.FixedZone("", -7*3600))
+	refs := []*RefRecord{
+		{Name: "refs/heads/master", UpdateIndex: 2, Hash: hashB},
+	}
+
+	var logs []*LogRecord
+	for i := 2000; i > 0; i-- {
+		logs = append(logs, &LogRecord{
+			Name:           "refs/heads/master",
+			UpdateIndex:    uint64(i),
+			Old:            hashA,
+			New:            hashB,
+			CommitterName:  "John Doe",
+			CommitterEmail: "john@doe.com",
+			When:           when,
+			Message:        fmt.Sprintf("commit: change %d", i),
+		})
+	}
+
+	logs = append(logs, &LogRecord{Name: "refs/heads/other", UpdateIndex: 1, Deleted: true})
+
+	r := s.write(c, WriterOptions{MinUpdateIndex: 1, MaxUpdateIndex: 2000}, refs, logs)
+
+	got, err := r.Logs()
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, len(logs))
+	for i, l := range got[:len(got)-1] {
+		c.Assert(l.When.Equal(logs[i].When), Equals, true)
+		_, offset := l.When.Zone()
+		c.Assert(offset, Equals, -7*3600)
+		l.When = logs[i].When
+		c.Assert(l, DeepEquals, logs[i])
+	}
+
+	c.Assert(got[len(got)-1], DeepEquals, logs[len(logs)-1])
+
+	got, err = r.LogsFor("refs/heads/other")
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 1)
+	c.Assert(got[0].Deleted, Equals, true)
+
+	rec, err := r.Ref("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(rec, DeepEquals, refs[0])
+}
+
+func (s *ReftableSuite) TestLogsOnly(c *C) {
+	logs := []*LogRecord{{
+		Name:        "HEAD",
+		UpdateIndex: 1,
+		New:         hashA,
+		When:        time.Unix(1600000000, 0).UTC(),
+	}}
+
+	r := s.write(c, WriterOptions{MinUpdateIndex: 1, MaxUpdateIndex: 1}, nil, logs)
+
+	got, err := r.LogsFor("HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 1)
+	c.Assert(got[0].New, Equals, hashA)
+
+	_, err = r.Ref("HEAD")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReftableSuite) TestUnsorted(c *C) {
+	w := NewWriter(bytes.NewBuffer(nil), WriterOptions{MaxUpdateIndex: 1})
+	c.Assert(w.AddRef(&RefRecord{Name: "refs/heads/b", Hash: hashA}), IsNil)
+	c.Assert(w.AddRef(&RefRecord{Name: "refs/heads/a", Hash: hashA}), Equals, ErrUnsortedRecords)
+}
+
+func (s *ReftableSuite) TestCorrupted(c *C) {
+	buf := bytes.NewBuffer(nil)
+	w := NewWriter(buf, WriterOptions{MinUpdateIndex: 1, MaxUpdateIndex: 1})
+	c.Assert(w.AddRef(&RefRecord{Name: "HEAD", UpdateIndex: 1, Hash: hashA}), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	data := buf.Bytes()
+	data[len(data)-10] ^= 0xff
+	_, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	c.Assert(err, Equals, ErrMalformedTable)
+
+	_, err = NewReader(bytes.NewReader([]byte("foo")), 3)
+	c.Assert(err, Equals, ErrMalformedTable)
+}
@@ -0,0 +1,297 @@
+package reftable
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+)
+
+// WriterOptions holds the configuration of a Writer.
+type WriterOptions struct {
+	// BlockSize is the size of the ref blocks, DefaultBlockSize if zero.
+	BlockSize uint32
+	// MinUpdateIndex and MaxUpdateIndex are the range of update indexes of
+	// the records of the table.
+	MinUpdateIndex uint64
+	MaxUpdateIndex uint64
+}
+
+type indexEntry struct {
+	key []byte
+	pos uint64
+}
+
+// Writer writes a reftable file. Refs must be added in order, followed by
+// logs in order, and the table is completed by Close.
+type Writer struct {
+	w    io.Writer
+	opts WriterOptions
+	pos  uint64
+
+	block     *blockWriter
+	lastKey   []byte
+	refBlocks []indexEntry
+
+	refsDone       bool
+	refIndexPos    uint64
+	logPos         uint64
+	logPosRecorded bool
+	closed         bool
+}
+
+// NewWriter returns a new Writer writing to w.
+func NewWriter(w io.Writer, o WriterOptions) *Writer {
+	if o.BlockSize == 0 {
+		o.BlockSize = DefaultBlockSize
+	}
+
+	return &Writer{w: w, opts: o}
+}
+
+// AddRef adds a ref record. Records must be sorted by name, and can't be
+// added after a log record.
+func (w *Writer) AddRef(r *RefRecord) error {
+	if w.refsDone || (w.lastKey != nil && r.Name <= string(w.lastKey)) {
+		return ErrUnsortedRecords
+	}
+
+	if r.UpdateIndex < w.opts.MinUpdateIndex || r.UpdateIndex > w.opts.MaxUpdateIndex {
+		return ErrMalformedTable
+	}
+
+	value := putVarInt(nil, r.UpdateIndex-w.opts.MinUpdateIndex)
+	switch r.valueType() {
+	case refValueHash:
+		value = append(value, r.Hash[:]...)
+	case refValuePeeled:
+		value = append(value, r.Hash[:]...)
+		value = append(value, r.Peeled[:]...)
+	case refValueSymbolic:
+		value = putVarInt(value, uint64(len(r.Target)))
+		value = append(value, r.Target...)
+	}
+
+	if err := w.add(blockTypeRef, []byte(r.Name), r.valueType(), value); err != nil {
+		return err
+	}
+
+	w.lastKey = append(w.lastKey[:0], r.Name...)
+	return nil
+}
+
+// AddLog adds a log record. Records must be sorted by name and by
+// decreasing update index.
+func (w *Writer) AddLog(l *LogRecord) error {
+	if !w.refsDone {
+		if err := w.finishRefs(); err != nil {
+			return err
+		}
+
+		w.lastKey = nil
+	}
+
+	key := l.key()
+	if w.lastKey != nil && bytes.Compare(key, w.lastKey) <= 0 {
+		return ErrUnsortedRecords
+	}
+
+	var value []byte
+	valueType := byte(logValueDeletion)
+	if !l.Deleted {
+		valueType = logValueUpdate
+		value = append(value, l.Old[:]...)
+		value = append(value, l.New[:]...)
+		value = putVarInt(value, uint64(len(l.CommitterName)))
+		value = append(value, l.CommitterName...)
+		value = putVarInt(value, uint64(len(l.CommitterEmail)))
+		value = append(value, l.CommitterEmail...)
+		value = putVarInt(value, uint64(l.When.Unix()))
+		_, offset := l.When.Zone()
+		tz := int16(offset / 60)
+		value = append(value, byte(uint16(tz)>>8), byte(uint16(tz)))
+		value = putVarInt(value, uint64(len(l.Message)))
+		value = append(value, l.Message...)
+	}
+
+	if err := w.add(blockTypeLog, key, valueType, value); err != nil {
+		return err
+	}
+
+	w.lastKey = append(w.lastKey[:0], key...)
+	return nil
+}
+
+func (w *Writer) add(typ byte, key []byte, valueType byte, value []byte) error {
+	if w.block == nil {
+		w.newBlock(typ)
+	}
+
+	if w.block.add(key, valueType, value) {
+		return nil
+	}
+
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	w.newBlock(typ)
+	if !w.block.add(key, valueType, value) {
+		return ErrRecordTooLarge
+	}
+
+	if typ != blockTypeLog && len(w.block.buf)+5 > w.block.blockSize {
+		return ErrRecordTooLarge
+	}
+
+	return nil
+}
+
+func (w *Writer) newBlock(typ byte) {
+	headerOff := 0
+	if w.pos == 0 {
+		headerOff = headerSizeV1
+	}
+
+	w.block = newBlockWriter(typ, int(w.opts.BlockSize), headerOff)
+	if typ == blockTypeLog && !w.logPosRecorded {
+		w.logPos = w.pos
+		w.logPosRecorded = true
+	}
+}
+
+// flushBlock writes the current block, padding ref and index blocks to the
+// block size, and returns its position.
+func (w *Writer) flushBlock() error {
+	b := w.block
+	w.block = nil
+
+	data, err := b.finish()
+	if err != nil {
+		return err
+	}
+
+	if b.headerOff > 0 {
+		copy(data, w.header())
+	}
+
+	pos := w.pos
+	if b.typ == blockTypeRef {
+		w.refBlocks = append(w.refBlocks, indexEntry{
+			key: append([]byte(nil), b.lastKey...),
+			pos: pos,
+		})
+	}
+
+	if b.typ != blockTypeLog && len(data) < b.blockSize {
+		data = append(data, make([]byte, b.blockSize-len(data))...)
+	}
+
+	return w.write(data)
+}
+
+func (w *Writer) write(data []byte) error {
+	n, err := w.w.Write(data)
+	w.pos += uint64(n)
+	return err
+}
+
+// finishRefs flushes the last ref block and writes the ref index when the
+// refs span more than one block.
+func (w *Writer) finishRefs() error {
+	w.refsDone = true
+	if w.block != nil {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+
+	entries := w.refBlocks
+	for len(entries) > 1 {
+		var next []indexEntry
+		for _, e := range entries {
+			if w.block == nil {
+				w.newBlock(blockTypeIndex)
+			}
+
+			value := putVarInt(nil, e.pos)
+			if w.block.add(e.key, 0, value) {
+				continue
+			}
+
+			pos := w.pos
+			key := append([]byte(nil), w.block.lastKey...)
+			if err := w.flushBlock(); err != nil {
+				return err
+			}
+
+			next = append(next, indexEntry{key: key, pos: pos})
+			w.newBlock(blockTypeIndex)
+			w.block.add(e.key, 0, value)
+		}
+
+		pos := w.pos
+		key := append([]byte(nil), w.block.lastKey...)
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+
+		next = append(next, indexEntry{key: key, pos: pos})
+		if len(next) == 1 {
+			w.refIndexPos = pos
+		}
+
+		entries = next
+	}
+
+	return nil
+}
+
+func (w *Writer) header() []byte {
+	h := append([]byte(nil), magic...)
+	h = append(h, Version)
+	h = appendUint24(h, w.opts.BlockSize)
+	h = appendUint64(h, w.opts.MinUpdateIndex)
+	return appendUint64(h, w.opts.MaxUpdateIndex)
+}
+
+// Close flushes the pending records and writes the footer. It doesn't close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+	if !w.refsDone {
+		if err := w.finishRefs(); err != nil {
+			return err
+		}
+	}
+
+	if w.block != nil {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+
+	if w.pos == 0 {
+		if err := w.write(w.header()); err != nil {
+			return err
+		}
+	}
+
+	f := w.header()
+	f = appendUint64(f, w.refIndexPos)
+	f = appendUint64(f, 0) // no object blocks
+	f = appendUint64(f, 0)
+	if w.logPosRecorded {
+		f = appendUint64(f, w.logPos)
+	} else {
+		f = appendUint64(f, 0)
+	}
+
+	f = appendUint64(f, 0) // no log index
+	f = appendUint32(f, crc32.ChecksumIEEE(f))
+
+	return w.write(f)
+}
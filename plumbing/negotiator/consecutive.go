@@ -0,0 +1,142 @@
+package negotiator
+
+import (
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// consecutive is the Consecutive negotiator, as the default one of git.
+type consecutive struct {
+	graph
+}
+
+func (n *consecutive) AddTip(h plumbing.Hash) error {
+	c, err := n.tip(h)
+	if err != nil || c == nil {
+		return err
+	}
+
+	return n.pushCommit(c, seen)
+}
+
+func (n *consecutive) KnownCommon(h plumbing.Hash) error {
+	c, err := n.tip(h)
+	if err != nil || c == nil {
+		return err
+	}
+
+	if c.flags&seen != 0 {
+		return nil
+	}
+
+	if err := n.pushCommit(c, commonRef|seen); err != nil {
+		return err
+	}
+
+	return n.markCommon(c, true, true)
+}
+
+func (n *consecutive) Ack(h plumbing.Hash) (bool, error) {
+	c := n.lookup(h)
+	known := c.flags&common != 0
+	return known, n.markCommon(c, false, true)
+}
+
+// Next returns the newest commit not known to be common, walking the
+// history of the tips. The ancestors of the common commits are not sent.
+func (n *consecutive) Next() (plumbing.Hash, error) {
+	for {
+		if n.queue.Len() == 0 || n.nonCommon == 0 {
+			return plumbing.ZeroHash, io.EOF
+		}
+
+		c := n.pop().commit
+		c.flags |= popped
+		if c.flags&common == 0 {
+			n.nonCommon--
+		}
+
+		send := true
+		var mark flag
+		switch {
+		case c.flags&common != 0:
+			send = false
+			mark = common | seen
+		case c.flags&commonRef != 0:
+			mark = common | seen
+		default:
+			mark = seen
+		}
+
+		for _, p := range c.parents {
+			if p.flags&seen == 0 {
+				if err := n.pushCommit(p, mark); err != nil {
+					return plumbing.ZeroHash, err
+				}
+			}
+
+			if mark&common != 0 {
+				if err := n.markCommon(p, true, false); err != nil {
+					return plumbing.ZeroHash, err
+				}
+			}
+		}
+
+		if send {
+			return c.hash, nil
+		}
+	}
+}
+
+func (n *consecutive) pushCommit(c *commit, mark flag) error {
+	if c.flags&mark != 0 {
+		return nil
+	}
+
+	c.flags |= mark
+	ok, err := n.parse(c)
+	if err != nil || !ok {
+		return err
+	}
+
+	n.push(c)
+	if c.flags&common == 0 {
+		n.nonCommon++
+	}
+
+	return nil
+}
+
+// markCommon marks a commit, or only its ancestors, as common.
+func (n *consecutive) markCommon(c *commit, ancestorsOnly, dontParse bool) error {
+	if c.flags&common != 0 {
+		return nil
+	}
+
+	if !ancestorsOnly {
+		c.flags |= common
+	}
+
+	if c.flags&seen == 0 {
+		return n.pushCommit(c, seen)
+	}
+
+	if !ancestorsOnly && c.flags&popped == 0 {
+		n.nonCommon--
+	}
+
+	if !c.parsed && !dontParse {
+		if ok, err := n.parse(c); err != nil || !ok {
+			return err
+		}
+	}
+
+	for _, p := range c.parents {
+		if err := n.markCommon(p, false, dontParse); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+// Package negotiator implements the algorithms choosing the commits sent as
+// haves to the server during a fetch, as the fetch.negotiationAlgorithm of
+// git.
+package negotiator
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+const (
+	// Consecutive walks the history of the tips one commit at a time, newest
+	// first. It's the default algorithm.
+	Consecutive = "consecutive"
+	// Skipping skips commits exponentially farther apart while walking the
+	// history of the tips, converging faster but possibly fetching objects
+	// not needed.
+	Skipping = "skipping"
+	// Noop sends no commit at all.
+	Noop = "noop"
+)
+
+// ErrUnknownAlgorithm is returned by New for an unknown algorithm.
+var ErrUnknownAlgorithm = errors.New("unknown negotiation algorithm")
+
+// Negotiator is a transport.Negotiator walking the history of the local
+// references.
+type Negotiator interface {
+	transport.Negotiator
+	// KnownCommon adds a commit known to be common, as the server advertises
+	// a reference to it.
+	KnownCommon(plumbing.Hash) error
+	// AddTip adds a commit whose history is negotiated, as the commit of a
+	// local reference.
+	AddTip(plumbing.Hash) error
+}
+
+// New returns a negotiator using the given algorithm, reading the commits
+// from s. An empty algorithm, or "default", is Consecutive.
+func New(algorithm string, s storer.EncodedObjectStorer) (Negotiator, error) {
+	g := newGraph(s)
+	switch algorithm {
+	case "", "default", Consecutive:
+		return &consecutive{graph: g}, nil
+	case Skipping:
+		return &skipping{graph: g}, nil
+	case Noop:
+		return noop{}, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, algorithm)
+}
+
+type flag uint8
+
+const (
+	common flag = 1 << iota
+	commonRef
+	advertised
+	seen
+	popped
+)
+
+// commit is a commit of the graph, whose parents are only known once parsed.
+type commit struct {
+	hash    plumbing.Hash
+	when    time.Time
+	parents []*commit
+	flags   flag
+
+	parsed, missing bool
+	// entry is the entry of the commit in the queue, if any.
+	entry *entry
+}
+
+// graph is the graph of the commits read from a storer, on demand.
+type graph struct {
+	s       storer.EncodedObjectStorer
+	commits map[plumbing.Hash]*commit
+
+	queue     queue
+	nonCommon int
+}
+
+func newGraph(s storer.EncodedObjectStorer) graph {
+	return graph{s: s, commits: make(map[plumbing.Hash]*commit)}
+}
+
+func (g *graph) lookup(h plumbing.Hash) *commit {
+	c, ok := g.commits[h]
+	if !ok {
+		c = &commit{hash: h}
+		g.commits[h] = c
+	}
+
+	return c
+}
+
+// parse reads a commit, returning false if it's not in the storer, as the
+// parents of the shallow commits.
+func (g *graph) parse(c *commit) (bool, error) {
+	if c.parsed || c.missing {
+		return c.parsed, nil
+	}
+
+	o, err := object.GetCommit(g.s, c.hash)
+	if err == plumbing.ErrObjectNotFound {
+		c.missing = true
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	c.parsed = true
+	c.when = o.Committer.When
+	for _, h := range o.ParentHashes {
+		c.parents = append(c.parents, g.lookup(h))
+	}
+
+	return true, nil
+}
+
+// tip returns the commit of a tip, peeling the tags. Nil is returned if it's
+// not a commit, or not in the storer.
+func (g *graph) tip(h plumbing.Hash) (*commit, error) {
+	for {
+		o, err := g.s.EncodedObject(plumbing.AnyObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			return nil, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch o.Type() {
+		case plumbing.CommitObject:
+			return g.lookup(h), nil
+		case plumbing.TagObject:
+			t, err := object.DecodeTag(g.s, o)
+			if err != nil {
+				return nil, err
+			}
+
+			h = t.Target
+		default:
+			return nil, nil
+		}
+	}
+}
+
+// entry is an entry of the queue of commits to walk.
+type entry struct {
+	commit *commit
+	// ttl is the number of commits to skip before sending one, as the
+	// skipping algorithm does.
+	originalTTL, ttl uint16
+
+	seq int
+}
+
+// queue is a priority queue of commits, the newest first, or the first
+// pushed for the same commit time.
+type queue struct {
+	entries []*entry
+	seq     int
+}
+
+func (q *queue) Len() int { return len(q.entries) }
+
+func (q *queue) Less(i, j int) bool {
+	a, b := q.entries[i], q.entries[j]
+	if !a.commit.when.Equal(b.commit.when) {
+		return a.commit.when.After(b.commit.when)
+	}
+
+	return a.seq < b.seq
+}
+
+func (q *queue) Swap(i, j int) { q.entries[i], q.entries[j] = q.entries[j], q.entries[i] }
+
+func (q *queue) Push(x interface{}) {
+	e := x.(*entry)
+	e.seq = q.seq
+	q.seq++
+	q.entries = append(q.entries, e)
+}
+
+func (q *queue) Pop() interface{} {
+	n := len(q.entries) - 1
+	e := q.entries[n]
+	q.entries[n] = nil
+	q.entries = q.entries[:n]
+	return e
+}
+
+func (g *graph) push(c *commit) *entry {
+	e := &entry{commit: c}
+	heap.Push(&g.queue, e)
+	c.entry = e
+	return e
+}
+
+func (g *graph) pop() *entry {
+	e := heap.Pop(&g.queue).(*entry)
+	e.commit.entry = nil
+	return e
+}
+
+// noop is the Noop negotiator.
+type noop struct{}
+
+func (noop) Next() (plumbing.Hash, error)    { return plumbing.ZeroHash, io.EOF }
+func (noop) Ack(plumbing.Hash) (bool, error) { return false, nil }
+func (noop) KnownCommon(plumbing.Hash) error { return nil }
+func (noop) AddTip(plumbing.Hash) error      { return nil }
@@ -0,0 +1,249 @@
+package negotiator
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type NegotiatorSuite struct {
+	s *memory.Storage
+	// commits are the commits by name.
+	commits map[string]plumbing.Hash
+	names   map[plumbing.Hash]string
+	when    time.Time
+}
+
+var _ = Suite(&NegotiatorSuite{})
+
+func (s *NegotiatorSuite) SetUpTest(c *C) {
+	s.s = memory.NewStorage()
+	s.commits = make(map[string]plumbing.Hash)
+	s.names = make(map[plumbing.Hash]string)
+	s.when = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// commit adds a commit, newer than the previous ones.
+func (s *NegotiatorSuite) commit(c *C, name string, parents ...string) plumbing.Hash {
+	s.when = s.when.Add(time.Minute)
+	sig := object.Signature{Name: "foo", Email: "foo@foo.com", When: s.when}
+	commit := &object.Commit{
+		Author:    sig,
+		Committer: sig,
+		Message:   name,
+		TreeHash:  plumbing.NewHash("4b825dc642cb6eb9a060e54bf8d69288fbee4904"),
+	}
+
+	for _, p := range parents {
+		h, ok := s.commits[p]
+		if !ok {
+			h = plumbing.ComputeHash(plumbing.CommitObject, []byte(p))
+		}
+
+		commit.ParentHashes = append(commit.ParentHashes, h)
+	}
+
+	o := s.s.NewEncodedObject()
+	c.Assert(commit.Encode(o), IsNil)
+
+	h, err := s.s.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+
+	s.commits[name] = h
+	s.names[h] = name
+	return h
+}
+
+// line adds a line of commits, named prefix1 to prefixN, the last being the
+// newest.
+func (s *NegotiatorSuite) line(c *C, prefix string, n int, parents ...string) {
+	for i := 1; i <= n; i++ {
+		s.commit(c, fmt.Sprintf("%s%d", prefix, i), parents...)
+		parents = []string{fmt.Sprintf("%s%d", prefix, i)}
+	}
+}
+
+// negotiate returns the names of the commits sent as haves, the ones in
+// common being acknowledged once sent.
+func (s *NegotiatorSuite) negotiate(c *C, n Negotiator, common ...string) []string {
+	isCommon := make(map[string]bool)
+	for _, name := range common {
+		isCommon[name] = true
+	}
+
+	var sent []string
+	for {
+		h, err := n.Next()
+		if err == io.EOF {
+			return sent
+		}
+
+		c.Assert(err, IsNil)
+
+		name := s.names[h]
+		sent = append(sent, name)
+		c.Assert(len(sent) < 100, Equals, true)
+
+		if isCommon[name] {
+			_, err := n.Ack(h)
+			c.Assert(err, IsNil)
+		}
+	}
+}
+
+func (s *NegotiatorSuite) newNegotiator(c *C, algorithm string, tips ...string) Negotiator {
+	n, err := New(algorithm, s.s)
+	c.Assert(err, IsNil)
+
+	for _, tip := range tips {
+		c.Assert(n.AddTip(s.commits[tip]), IsNil)
+	}
+
+	return n
+}
+
+func (s *NegotiatorSuite) TestNew(c *C) {
+	for _, algorithm := range []string{"", "default", Consecutive} {
+		n, err := New(algorithm, s.s)
+		c.Assert(err, IsNil)
+		c.Assert(n, FitsTypeOf, &consecutive{})
+	}
+
+	_, err := New("unknown", s.s)
+	c.Assert(errors.Is(err, ErrUnknownAlgorithm), Equals, true)
+}
+
+func (s *NegotiatorSuite) TestConsecutive(c *C) {
+	s.line(c, "a", 10)
+
+	n := s.newNegotiator(c, Consecutive, "a10")
+	c.Assert(s.negotiate(c, n, "a5"), DeepEquals, []string{
+		"a10", "a9", "a8", "a7", "a6", "a5",
+	})
+}
+
+func (s *NegotiatorSuite) TestConsecutiveNoCommon(c *C) {
+	s.line(c, "a", 3)
+	s.line(c, "b", 2, "a2")
+
+	n := s.newNegotiator(c, Consecutive, "a3", "b2")
+	c.Assert(s.negotiate(c, n), DeepEquals, []string{
+		"b2", "b1", "a3", "a2", "a1",
+	})
+}
+
+func (s *NegotiatorSuite) TestConsecutiveKnownCommon(c *C) {
+	s.line(c, "a", 5)
+	s.line(c, "b", 2, "a5")
+
+	n := s.newNegotiator(c, Consecutive, "b2")
+	c.Assert(n.KnownCommon(s.commits["a5"]), IsNil)
+
+	c.Assert(s.negotiate(c, n), DeepEquals, []string{"b2", "b1", "a5"})
+}
+
+func (s *NegotiatorSuite) TestConsecutiveAckOnOtherBranch(c *C) {
+	s.line(c, "a", 4)
+	s.line(c, "b", 4, "a2")
+	s.line(c, "c", 1, "b4", "a4")
+
+	n := s.newNegotiator(c, Consecutive, "c1")
+	c.Assert(s.negotiate(c, n, "a4", "b2"), DeepEquals, []string{
+		"c1", "b4", "b3", "b2", "a4",
+	})
+}
+
+func (s *NegotiatorSuite) TestSkipping(c *C) {
+	s.line(c, "a", 20)
+
+	n := s.newNegotiator(c, Skipping, "a20")
+	c.Assert(s.negotiate(c, n), DeepEquals, []string{
+		"a20", "a18", "a15", "a10", "a2", "a1",
+	})
+}
+
+func (s *NegotiatorSuite) TestSkippingAck(c *C) {
+	s.line(c, "a", 20)
+
+	n := s.newNegotiator(c, Skipping, "a20")
+	c.Assert(s.negotiate(c, n, "a15"), DeepEquals, []string{
+		"a20", "a18", "a15",
+	})
+}
+
+func (s *NegotiatorSuite) TestSkippingKnownCommon(c *C) {
+	s.line(c, "a", 10)
+	s.line(c, "b", 3, "a10")
+
+	n := s.newNegotiator(c, Skipping, "b3")
+	c.Assert(n.KnownCommon(s.commits["a10"]), IsNil)
+
+	c.Assert(s.negotiate(c, n), DeepEquals, []string{"b3", "b1"})
+}
+
+func (s *NegotiatorSuite) TestSkippingAckNotSent(c *C) {
+	s.line(c, "a", 2)
+
+	n := s.newNegotiator(c, Skipping, "a2")
+	_, err := n.Ack(s.commits["a1"])
+	c.Assert(err, ErrorMatches, "received ack for commit .* not sent as have")
+}
+
+func (s *NegotiatorSuite) TestNoop(c *C) {
+	s.line(c, "a", 2)
+
+	n := s.newNegotiator(c, Noop, "a2")
+	c.Assert(s.negotiate(c, n), HasLen, 0)
+}
+
+func (s *NegotiatorSuite) TestTips(c *C) {
+	s.line(c, "a", 2)
+
+	tag := &object.Tag{
+		Name:       "v1",
+		Tagger:     object.Signature{Name: "foo", Email: "foo@foo.com", When: s.when},
+		TargetType: plumbing.CommitObject,
+		Target:     s.commits["a1"],
+	}
+
+	o := s.s.NewEncodedObject()
+	c.Assert(tag.Encode(o), IsNil)
+	tagHash, err := s.s.SetEncodedObject(o)
+	c.Assert(err, IsNil)
+
+	blob := s.s.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	blobHash, err := s.s.SetEncodedObject(blob)
+	c.Assert(err, IsNil)
+
+	for _, algorithm := range []string{Consecutive, Skipping} {
+		n, err := New(algorithm, s.s)
+		c.Assert(err, IsNil)
+
+		c.Assert(n.AddTip(tagHash), IsNil)
+		c.Assert(n.AddTip(blobHash), IsNil)
+		c.Assert(n.AddTip(plumbing.NewHash("0000000000000000000000000000000000000001")), IsNil)
+		c.Assert(s.negotiate(c, n), DeepEquals, []string{"a1"})
+	}
+}
+
+func (s *NegotiatorSuite) TestShallow(c *C) {
+	s.commit(c, "a1", "missing")
+	s.line(c, "b", 2, "a1")
+
+	n := s.newNegotiator(c, Consecutive, "b2")
+	c.Assert(s.negotiate(c, n), DeepEquals, []string{"b2", "b1", "a1"})
+
+	n = s.newNegotiator(c, Skipping, "b2")
+	c.Assert(s.negotiate(c, n), DeepEquals, []string{"b2", "a1"})
+}
@@ -0,0 +1,168 @@
+package negotiator
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// skipping is the Skipping negotiator, as the skipping one of git: while no
+// common commit is found, the number of commits skipped between the ones sent
+// grows by a half each time.
+type skipping struct {
+	graph
+}
+
+func (n *skipping) AddTip(h plumbing.Hash) error {
+	c, err := n.tip(h)
+	if err != nil || c == nil || c.flags&seen != 0 {
+		return err
+	}
+
+	_, err = n.pushCommit(c, 0)
+	return err
+}
+
+func (n *skipping) KnownCommon(h plumbing.Hash) error {
+	c, err := n.tip(h)
+	if err != nil || c == nil || c.flags&seen != 0 {
+		return err
+	}
+
+	_, err = n.pushCommit(c, advertised)
+	return err
+}
+
+func (n *skipping) Ack(h plumbing.Hash) (bool, error) {
+	c := n.lookup(h)
+	if c.flags&seen == 0 {
+		return false, fmt.Errorf("received ack for commit %s not sent as have", h)
+	}
+
+	known := c.flags&common != 0
+	n.markCommon(c)
+	return known, nil
+}
+
+// Next returns the next commit not known to be common whose entry has no
+// commit left to skip, or with no parent to walk through.
+func (n *skipping) Next() (plumbing.Hash, error) {
+	for {
+		if n.queue.Len() == 0 || n.nonCommon == 0 {
+			return plumbing.ZeroHash, io.EOF
+		}
+
+		e := n.pop()
+		c := e.commit
+		c.flags |= popped
+		if c.flags&common == 0 {
+			n.nonCommon--
+		}
+
+		send := c.flags&common == 0 && e.ttl == 0
+
+		var parentPushed bool
+		for _, p := range c.parents {
+			pushed, err := n.pushParent(e, p)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+
+			parentPushed = parentPushed || pushed
+		}
+
+		// The commit has no parent, or they were all popped already due to
+		// clock skew, so it's sent anyway.
+		if c.flags&common == 0 && !parentPushed {
+			send = true
+		}
+
+		if send {
+			return c.hash, nil
+		}
+	}
+}
+
+// pushCommit pushes a commit not seen yet, returning its entry. Nil is
+// returned if it's not in the storer.
+func (n *skipping) pushCommit(c *commit, mark flag) (*entry, error) {
+	ok, err := n.parse(c)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	c.flags |= mark | seen
+	e := n.push(c)
+	if mark&common == 0 {
+		n.nonCommon++
+	}
+
+	return e, nil
+}
+
+// pushParent ensures the parent of the commit of an entry is queued, with
+// the right flags and ttl. False is returned if it was already popped.
+func (n *skipping) pushParent(e *entry, p *commit) (bool, error) {
+	pe := p.entry
+	if p.flags&seen != 0 {
+		// The parent was already popped due to clock skew, it's skipped.
+		if p.flags&popped != 0 {
+			return false, nil
+		}
+	} else {
+		var err error
+		if pe, err = n.pushCommit(p, 0); err != nil || pe == nil {
+			return false, err
+		}
+	}
+
+	if e.commit.flags&(common|advertised) != 0 {
+		n.markCommon(p)
+		return true, nil
+	}
+
+	originalTTL := e.originalTTL*3/2 + 1
+	ttl := originalTTL
+	if e.ttl != 0 {
+		originalTTL = e.originalTTL
+		ttl = e.ttl - 1
+	}
+
+	if pe.originalTTL < originalTTL {
+		pe.originalTTL = originalTTL
+		pe.ttl = ttl
+	}
+
+	return true, nil
+}
+
+// markCommon marks a seen commit and all its parsed seen ancestors as
+// common.
+func (n *skipping) markCommon(c *commit) {
+	if c.flags&common != 0 {
+		return
+	}
+
+	c.flags |= common
+	pending := []*commit{c}
+	for len(pending) > 0 {
+		c := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+
+		if c.flags&popped == 0 {
+			n.nonCommon--
+		}
+
+		if !c.parsed {
+			continue
+		}
+
+		for _, p := range c.parents {
+			if p.flags&seen != 0 && p.flags&common == 0 {
+				p.flags |= common
+				pending = append(pending, p)
+			}
+		}
+	}
+}
@@ -82,6 +82,14 @@ func (c *Commit) Tree() (*Tree, error) {
 // NOTE: Since version 5.1.0 the renames are correctly handled, the settings
 // used are the recommended options DefaultDiffTreeOptions.
 func (c *Commit) PatchContext(ctx context.Context, to *Commit) (*Patch, error) {
+	return c.PatchWithOptions(ctx, to, nil)
+}
+
+// PatchWithOptions returns the Patch between the actual commit and the
+// provided one, computing the changes of the files with the given diff
+// options. If no options are passed, the default ones are used. Provided
+// context must be non-nil.
+func (c *Commit) PatchWithOptions(ctx context.Context, to *Commit, o *DiffOptions) (*Patch, error) {
 	fromTree, err := c.Tree()
 	if err != nil {
 		return nil, err
@@ -95,7 +103,7 @@ func (c *Commit) PatchContext(ctx context.Context, to *Commit) (*Patch, error) {
 		}
 	}
 
-	return fromTree.PatchContext(ctx, toTree)
+	return fromTree.PatchWithOptions(ctx, toTree, o)
 }
 
 // Patch returns the Patch between the actual commit and the provided one.
@@ -21,12 +21,31 @@ var (
 	ErrCanceled = errors.New("operation canceled")
 )
 
+// DiffOptions are the options used to compute the content changes of a
+// patch.
+type DiffOptions struct {
+	// Algorithm is the diff algorithm, diff.Myers by default.
+	Algorithm diff.Algorithm
+	// IgnoreAllSpace ignores whitespace when comparing lines, like -w.
+	IgnoreAllSpace bool
+	// IgnoreSpaceChange ignores changes in the amount of whitespace, and the
+	// whitespace at the end of the lines, like -b.
+	IgnoreSpaceChange bool
+	// IgnoreBlankLines ignores the changes whose lines are all blank, unless
+	// they are near other changes, like --ignore-blank-lines.
+	IgnoreBlankLines bool
+}
+
 func getPatch(message string, changes ...*Change) (*Patch, error) {
 	ctx := context.Background()
 	return getPatchContext(ctx, message, changes...)
 }
 
 func getPatchContext(ctx context.Context, message string, changes ...*Change) (*Patch, error) {
+	return getPatchWithOptions(ctx, message, nil, changes...)
+}
+
+func getPatchWithOptions(ctx context.Context, message string, o *DiffOptions, changes ...*Change) (*Patch, error) {
 	var filePatches []fdiff.FilePatch
 	for _, c := range changes {
 		select {
@@ -35,7 +54,7 @@ func getPatchContext(ctx context.Context, message string, changes ...*Change) (*
 		default:
 		}
 
-		fp, err := filePatchWithContext(ctx, c)
+		fp, err := filePatchWithOptions(ctx, c, o)
 		if err != nil {
 			return nil, err
 		}
@@ -46,7 +65,7 @@ func getPatchContext(ctx context.Context, message string, changes ...*Change) (*
 	return &Patch{message, filePatches}, nil
 }
 
-func filePatchWithContext(ctx context.Context, c *Change) (fdiff.FilePatch, error) {
+func filePatchWithOptions(ctx context.Context, c *Change, o *DiffOptions) (fdiff.FilePatch, error) {
 	from, to, err := c.Files()
 	if err != nil {
 		return nil, err
@@ -65,7 +84,16 @@ func filePatchWithContext(ctx context.Context, c *Change) (fdiff.FilePatch, erro
 		return &textFilePatch{from: c.From, to: c.To}, nil
 	}
 
-	diffs := diff.Do(fromContent, toContent)
+	var diffs []dmp.Diff
+	if o == nil {
+		diffs = diff.Do(fromContent, toContent)
+	} else {
+		diffs = diff.DoWithOptions(fromContent, toContent, diff.Options{
+			Algorithm:         o.Algorithm,
+			IgnoreAllSpace:    o.IgnoreAllSpace,
+			IgnoreSpaceChange: o.IgnoreSpaceChange,
+		})
+	}
 
 	var chunks []fdiff.Chunk
 	for _, d := range diffs {
@@ -85,7 +113,11 @@ func filePatchWithContext(ctx context.Context, c *Change) (fdiff.FilePatch, erro
 			op = fdiff.Add
 		}
 
-		chunks = append(chunks, &textChunk{d.Text, op})
+		chunks = append(chunks, &textChunk{content: d.Text, op: op})
+	}
+
+	if o != nil && o.IgnoreBlankLines {
+		markBlankChunks(chunks)
 	}
 
 	return &textFilePatch{
@@ -96,6 +128,26 @@ func filePatchWithContext(ctx context.Context, c *Change) (fdiff.FilePatch, erro
 
 }
 
+// markBlankChunks marks as ignorable the chunks of the groups of consecutive
+// changes made only of blank lines.
+func markBlankChunks(chunks []fdiff.Chunk) {
+	for i := 0; i < len(chunks); {
+		if chunks[i].Type() == fdiff.Equal {
+			i++
+			continue
+		}
+
+		j, blank := i, true
+		for ; j < len(chunks) && chunks[j].Type() != fdiff.Equal; j++ {
+			blank = blank && strings.TrimSpace(chunks[j].Content()) == ""
+		}
+
+		for ; i < j; i++ {
+			chunks[i].(*textChunk).ignorable = blank
+		}
+	}
+}
+
 func fileContent(f *File) (content string, isBinary bool, err error) {
 	if f == nil {
 		return
@@ -169,6 +221,28 @@ func (f *changeEntryWrapper) Path() string {
 	return f.ce.Name
 }
 
+// Content returns the content of the blob of the entry.
+func (f *changeEntryWrapper) Content() ([]byte, error) {
+	b, err := GetBlob(f.ce.Tree.s, f.ce.TreeEntry.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := b.Reader()
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	buf := bytes.NewBuffer(nil)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
 func (f *changeEntryWrapper) Empty() bool {
 	return !f.ce.TreeEntry.Mode.IsFile()
 }
@@ -202,10 +276,11 @@ func (tf *textFilePatch) Chunks() []fdiff.Chunk {
 	return tf.chunks
 }
 
-// textChunk is an implementation of fdiff.Chunk interface
+// textChunk is an implementation of fdiff.IgnorableChunk interface
 type textChunk struct {
-	content string
-	op      fdiff.Operation
+	content   string
+	op        fdiff.Operation
+	ignorable bool
 }
 
 func (t *textChunk) Content() string {
@@ -216,6 +291,10 @@ func (t *textChunk) Type() fdiff.Operation {
 	return t.op
 }
 
+func (t *textChunk) Ignorable() bool {
+	return t.ignorable
+}
+
 // FileStat stores the status of changes in content of a file.
 type FileStat struct {
 	Name     string
@@ -1,9 +1,17 @@
 package object
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/utils/diff"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
@@ -45,3 +53,73 @@ func (s *PatchSuite) TestStatsWithSubmodules(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(p, NotNil)
 }
+
+func (s *PatchSuite) TestPatchWithOptions(c *C) {
+	from := makeFile(c, "foo", filemode.Regular, "a\nb\nc\n{\n}\nd\n{\n}\ne\n")
+	to := makeFile(c, "foo", filemode.Regular, "a\nb\n\n{\n}\nc\n{\n}\nd\ne\n")
+	ch := makeChange(c, from, to)
+
+	p, err := ch.PatchWithOptions(context.Background(), &DiffOptions{
+		Algorithm:        diff.Patience,
+		IgnoreBlankLines: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(p.String(), Equals, fmt.Sprintf("diff --git a/foo b/foo\n"+
+		"index %s..%s 100644\n--- a/foo\n+++ b/foo\n", from.Hash, to.Hash)+
+		"@@ -1,9 +1,10 @@\n a\n b\n+\n+{\n+}\n c\n {\n }\n d\n-{\n-}\n e\n")
+
+	stats := p.Stats()
+	c.Assert(stats, HasLen, 1)
+	c.Assert(stats[0].Addition, Equals, 3)
+	c.Assert(stats[0].Deletion, Equals, 2)
+}
+
+func (s *PatchSuite) TestPatchWithOptionsWhitespace(c *C) {
+	from := makeFile(c, "foo", filemode.Regular, "a b\n\nc\n")
+	to := makeFile(c, "foo", filemode.Regular, "a  b \nc\n")
+	ch := makeChange(c, from, to)
+
+	for _, t := range []struct {
+		opts               *DiffOptions
+		addition, deletion int
+	}{
+		{nil, 1, 2},
+		{&DiffOptions{IgnoreSpaceChange: true}, 0, 1},
+		{&DiffOptions{IgnoreAllSpace: true}, 0, 1},
+	} {
+		p, err := ch.PatchWithOptions(context.Background(), t.opts)
+		c.Assert(err, IsNil)
+
+		stats := p.Stats()
+		c.Assert(stats, HasLen, 1)
+		c.Assert(stats[0].Addition, Equals, t.addition)
+		c.Assert(stats[0].Deletion, Equals, t.deletion)
+	}
+
+	p, err := ch.PatchWithOptions(context.Background(), &DiffOptions{
+		IgnoreSpaceChange: true,
+		IgnoreBlankLines:  true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(strings.Contains(p.String(), "@@"), Equals, false)
+}
+
+func (s *PatchSuite) TestPatchBinaryContent(c *C) {
+	from := makeFile(c, "bin", filemode.Regular, "\x00\x01bin")
+	to := makeFile(c, "bin", filemode.Regular, "\x00\x02bin\xff")
+	ch := makeChange(c, from, to)
+
+	p, err := ch.Patch()
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	err = fdiff.NewUnifiedEncoder(buf, fdiff.DefaultContextLines).SetBinary(true).Encode(p)
+	c.Assert(err, IsNil)
+
+	var decoded fdiff.ParsedPatch
+	err = fdiff.NewDecoder(buf).Decode(&decoded)
+	c.Assert(err, IsNil)
+	c.Assert(decoded.Files, HasLen, 1)
+	c.Assert(decoded.Files[0].BinaryPatch.Forward.Data, DeepEquals, []byte("\x00\x02bin\xff"))
+	c.Assert(decoded.Files[0].BinaryPatch.Reverse.Data, DeepEquals, []byte("\x00\x01bin"))
+}
@@ -0,0 +1,688 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+const (
+	trailerSection = "trailer"
+
+	trailerSeparatorsKey = "separators"
+	trailerWhereKey      = "where"
+	trailerIfExistsKey   = "ifexists"
+	trailerIfMissingKey  = "ifmissing"
+	trailerKeyKey        = "key"
+
+	// DefaultTrailerSeparators are the default characters separating the
+	// tokens of the trailers from their values.
+	DefaultTrailerSeparators = ":"
+
+	trailerCommentChar = '#'
+)
+
+// ErrInvalidTrailerConfig is returned when the trailer.* config has an
+// invalid value.
+var ErrInvalidTrailerConfig = errors.New("invalid trailer config")
+
+// trailerGeneratedPrefixes are the prefixes of the lines generated by git,
+// which make a paragraph recognized as trailers.
+var trailerGeneratedPrefixes = []string{"Signed-off-by: ", "(cherry picked from commit "}
+
+// Trailer is a "Token: value" line ending a commit message, as the
+// Signed-off-by and Co-authored-by ones.
+type Trailer struct {
+	Token string
+	Value string
+}
+
+// String returns the trailer as written in a commit message.
+func (t Trailer) String() string {
+	return formatTrailer(t.Token, t.Value, DefaultTrailerSeparators)
+}
+
+// TrailerWhere is the position where a trailer is added.
+type TrailerWhere string
+
+const (
+	// TrailerEnd adds the trailer after the other trailers.
+	TrailerEnd TrailerWhere = "end"
+	// TrailerStart adds the trailer before the other trailers.
+	TrailerStart TrailerWhere = "start"
+	// TrailerAfter adds the trailer after the last trailer with the same
+	// token, or at the end.
+	TrailerAfter TrailerWhere = "after"
+	// TrailerBefore adds the trailer before the first trailer with the same
+	// token, or at the start.
+	TrailerBefore TrailerWhere = "before"
+)
+
+// TrailerAction is what is done when adding a trailer, depending on whether
+// a trailer with the same token exists.
+type TrailerAction string
+
+const (
+	// TrailerAddIfDifferentNeighbor adds the trailer unless the trailer next
+	// to where it would be added has the same token and value. This is the
+	// default action when the token exists.
+	TrailerAddIfDifferentNeighbor TrailerAction = "addIfDifferentNeighbor"
+	// TrailerAddIfDifferent adds the trailer unless a trailer with the same
+	// token and value exists.
+	TrailerAddIfDifferent TrailerAction = "addIfDifferent"
+	// TrailerAdd always adds the trailer. This is the default action when
+	// the token is missing.
+	TrailerAdd TrailerAction = "add"
+	// TrailerReplace replaces a trailer with the same token.
+	TrailerReplace TrailerAction = "replace"
+	// TrailerDoNothing doesn't add the trailer.
+	TrailerDoNothing TrailerAction = "doNothing"
+)
+
+// TrailerToken configures the trailers with a token, as the
+// trailer.<name>.* config does.
+type TrailerToken struct {
+	// Name is the name of the token. The trailers with a token which is a
+	// prefix of Name or Key, ignoring the case, use this configuration.
+	Name string
+	// Key is the token written for the trailers, Name if empty. It may end
+	// with a separator and a space.
+	Key string
+	// Where, IfExists and IfMissing override the ones of the TrailerOptions
+	// when not empty.
+	Where     TrailerWhere
+	IfExists  TrailerAction
+	IfMissing TrailerAction
+}
+
+// TrailerOptions describes how trailers are parsed and added, as the
+// trailer.* config does for git interpret-trailers.
+type TrailerOptions struct {
+	// Separators are the characters separating the tokens from the values,
+	// DefaultTrailerSeparators if empty. The first one is used to write the
+	// trailers.
+	Separators string
+	// Where is the position where the trailers are added, TrailerEnd by
+	// default.
+	Where TrailerWhere
+	// IfExists is the action done when a trailer with the same token
+	// exists, TrailerAddIfDifferentNeighbor by default.
+	IfExists TrailerAction
+	// IfMissing is the action done when no trailer with the same token
+	// exists, TrailerAdd by default. Only TrailerAdd and TrailerDoNothing
+	// are valid.
+	IfMissing TrailerAction
+	// Tokens configure specific tokens, the first matching one being used.
+	Tokens []TrailerToken
+}
+
+// NewTrailerOptions returns the TrailerOptions set by the trailer section of
+// a config.
+func NewTrailerOptions(cfg *config.Config) (*TrailerOptions, error) {
+	o := &TrailerOptions{}
+	if !cfg.HasSection(trailerSection) {
+		return o, nil
+	}
+
+	s := cfg.Section(trailerSection)
+	o.Separators = s.Options.Get(trailerSeparatorsKey)
+
+	var err error
+	if o.Where, err = parseTrailerWhere(s.Options.Get(trailerWhereKey)); err != nil {
+		return nil, err
+	}
+
+	if o.IfExists, err = parseTrailerAction(s.Options.Get(trailerIfExistsKey), false); err != nil {
+		return nil, err
+	}
+
+	if o.IfMissing, err = parseTrailerAction(s.Options.Get(trailerIfMissingKey), true); err != nil {
+		return nil, err
+	}
+
+	for _, sub := range s.Subsections {
+		t := TrailerToken{Name: sub.Name, Key: sub.Options.Get(trailerKeyKey)}
+		if t.Where, err = parseTrailerWhere(sub.Options.Get(trailerWhereKey)); err != nil {
+			return nil, err
+		}
+
+		if t.IfExists, err = parseTrailerAction(sub.Options.Get(trailerIfExistsKey), false); err != nil {
+			return nil, err
+		}
+
+		if t.IfMissing, err = parseTrailerAction(sub.Options.Get(trailerIfMissingKey), true); err != nil {
+			return nil, err
+		}
+
+		o.Tokens = append(o.Tokens, t)
+	}
+
+	return o, nil
+}
+
+func parseTrailerWhere(s string) (TrailerWhere, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	for _, w := range []TrailerWhere{TrailerEnd, TrailerStart, TrailerAfter, TrailerBefore} {
+		if strings.EqualFold(s, string(w)) {
+			return w, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: where = %s", ErrInvalidTrailerConfig, s)
+}
+
+func parseTrailerAction(s string, missing bool) (TrailerAction, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	actions := []TrailerAction{
+		TrailerAddIfDifferentNeighbor, TrailerAddIfDifferent, TrailerAdd,
+		TrailerReplace, TrailerDoNothing,
+	}
+
+	if missing {
+		actions = []TrailerAction{TrailerAdd, TrailerDoNothing}
+	}
+
+	for _, a := range actions {
+		if strings.EqualFold(s, string(a)) {
+			return a, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrInvalidTrailerConfig, s)
+}
+
+func (o *TrailerOptions) separators() string {
+	if o == nil || o.Separators == "" {
+		return DefaultTrailerSeparators
+	}
+
+	return o.Separators
+}
+
+// token returns the configuration of the trailers with the given token, nil
+// if none.
+func (o *TrailerOptions) token(token string) *TrailerToken {
+	if o == nil || token == "" {
+		return nil
+	}
+
+	for i, t := range o.Tokens {
+		if hasPrefixFold(t.Name, token) || (t.Key != "" && hasPrefixFold(t.Key, token)) {
+			return &o.Tokens[i]
+		}
+	}
+
+	return nil
+}
+
+// rules returns the position and the actions used for a trailer.
+func (o *TrailerOptions) rules(t *TrailerToken) (where TrailerWhere, ifExists, ifMissing TrailerAction) {
+	where, ifExists, ifMissing = TrailerEnd, TrailerAddIfDifferentNeighbor, TrailerAdd
+	if o != nil {
+		where = TrailerWhere(orDefault(string(o.Where), string(where)))
+		ifExists = TrailerAction(orDefault(string(o.IfExists), string(ifExists)))
+		ifMissing = TrailerAction(orDefault(string(o.IfMissing), string(ifMissing)))
+	}
+
+	if t != nil {
+		where = TrailerWhere(orDefault(string(t.Where), string(where)))
+		ifExists = TrailerAction(orDefault(string(t.IfExists), string(ifExists)))
+		ifMissing = TrailerAction(orDefault(string(t.IfMissing), string(ifMissing)))
+	}
+
+	return where, ifExists, ifMissing
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+
+	return s
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// Trailers returns the trailers of the message of c.
+func (c *Commit) Trailers() []Trailer {
+	return ParseTrailers(c.Message, nil)
+}
+
+// ParseTrailers returns the trailers of a commit message, as git
+// interpret-trailers --parse does. The trailers are the lines of the last
+// paragraph, which must not be the first one, made of a token, a separator
+// and a value. The paragraph must only contain trailers, or at least a
+// quarter of its lines must be trailers, one of them generated by git, as
+// Signed-off-by, or configured in o. The continuation lines of the values,
+// starting with whitespace, are unfolded.
+func ParseTrailers(message string, o *TrailerOptions) []Trailer {
+	info := parseTrailerBlock(message, o)
+
+	var trailers []Trailer
+	for _, item := range info.items {
+		if item.token == "" {
+			continue
+		}
+
+		trailers = append(trailers, Trailer{
+			Token: trimTrailerToken(item.token, o.separators()),
+			Value: unfoldTrailerValue(item.value),
+		})
+	}
+
+	return trailers
+}
+
+// AddTrailers returns message with the given trailers added to its trailers,
+// as git interpret-trailers --trailer does. Where the trailers are added and
+// whether they replace or are skipped because of the existing ones depends
+// on the options, the trailers being added at the end unless the trailer
+// before has the same token and value by default. The existing trailers are
+// rewritten as "Token: value" lines.
+func AddTrailers(message string, trailers []Trailer, o *TrailerOptions) string {
+	if message != "" && !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+
+	info := parseTrailerBlock(message, o)
+	items := info.items
+	for _, t := range trailers {
+		items = addTrailer(items, t, o)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(message[:info.start])
+	if !endsWithBlankLine(message[:info.start]) {
+		sb.WriteByte('\n')
+	}
+
+	for _, item := range items {
+		if item.token == "" {
+			sb.WriteString(item.value)
+		} else {
+			sb.WriteString(formatTrailer(item.token, item.value, o.separators()))
+		}
+
+		sb.WriteByte('\n')
+	}
+
+	sb.WriteString(message[info.end:])
+	return sb.String()
+}
+
+// trailerItem is a line of a trailer block, with its continuation lines. The
+// token is empty for the lines which aren't trailers.
+type trailerItem struct {
+	token, value string
+
+	// rules of the added trailers.
+	where               TrailerWhere
+	ifExists, ifMissing TrailerAction
+}
+
+type trailerBlock struct {
+	// start and end are the bounds of the trailer block in the message, both
+	// at the end of the message if there is no block.
+	start, end int
+	items      []*trailerItem
+}
+
+// parseTrailerBlock returns the trailer block of a message, following
+// trailer.c of git.
+func parseTrailerBlock(message string, o *TrailerOptions) *trailerBlock {
+	end := trailerMessageEnd(message)
+	start := trailerBlockStart(message[:end], o)
+
+	b := &trailerBlock{start: start, end: end}
+	seps := o.separators()
+
+	var last *trailerItem
+	for _, line := range splitTrailerLines(message[start:end]) {
+		if line == "" || line[0] == trailerCommentChar {
+			continue
+		}
+
+		if last != nil && isTrailerSpace(line[0]) {
+			last.value += "\n" + line
+			continue
+		}
+
+		pos := trailerSeparator(line, seps)
+		if pos < 1 {
+			b.items = append(b.items, &trailerItem{value: line})
+			last = nil
+			continue
+		}
+
+		last = &trailerItem{
+			token: strings.TrimSpace(line[:pos]),
+			value: strings.TrimSpace(line[pos+1:]),
+		}
+
+		if t := o.token(last.token); t != nil && t.Key != "" {
+			last.token = t.Key
+		}
+
+		b.items = append(b.items, last)
+	}
+
+	return b
+}
+
+// trailerMessageEnd returns the end of the part of message which may contain
+// trailers, before the patch following a "---" line and the trailing
+// comments and blank lines.
+func trailerMessageEnd(message string) int {
+	end := len(message)
+	for pos := 0; pos < len(message); {
+		next := lineEnd(message, pos)
+		line := message[pos:next]
+		if strings.HasPrefix(line, "---") && (len(line) == 3 || isTrailerSpace(line[3])) {
+			end = pos
+			break
+		}
+
+		pos = next
+	}
+
+	for end > 0 {
+		start := lineStart(message, end)
+		line := strings.TrimRight(message[start:end], "\n")
+		if strings.TrimSpace(line) != "" && line[0] != trailerCommentChar {
+			break
+		}
+
+		end = start
+	}
+
+	return end
+}
+
+// trailerBlockStart returns the start of the last paragraph of message if
+// it's a trailer block, or the end of message.
+func trailerBlockStart(message string, o *TrailerOptions) int {
+	// The first paragraph is the title, which can't contain trailers.
+	titleEnd := len(message)
+	for pos := 0; pos < len(message); {
+		next := lineEnd(message, pos)
+		if strings.TrimSpace(message[pos:next]) == "" {
+			titleEnd = pos
+			break
+		}
+
+		pos = next
+	}
+
+	seps := o.separators()
+	recognized := false
+	trailerLines, nonTrailerLines, continuationLines := 0, 0, 0
+	for end := len(message); end > titleEnd; {
+		start := lineStart(message, end)
+		line := strings.TrimRight(message[start:end], "\n")
+		next := end
+		end = start
+
+		switch {
+		case line != "" && line[0] == trailerCommentChar:
+			continue
+		case strings.TrimSpace(line) == "":
+			if (recognized && trailerLines*3 >= nonTrailerLines) ||
+				(trailerLines > 0 && nonTrailerLines == 0) {
+				return next
+			}
+
+			return len(message)
+		}
+
+		generated := false
+		for _, p := range trailerGeneratedPrefixes {
+			if strings.HasPrefix(line, p) {
+				generated = true
+			}
+		}
+
+		if generated {
+			trailerLines++
+			continuationLines = 0
+			recognized = true
+			continue
+		}
+
+		pos := trailerSeparator(line, seps)
+		switch {
+		case pos >= 1 && !isTrailerSpace(line[0]):
+			trailerLines++
+			continuationLines = 0
+			if !recognized && o.token(strings.TrimSpace(line[:pos])) != nil {
+				recognized = true
+			}
+		case isTrailerSpace(line[0]):
+			continuationLines++
+		default:
+			nonTrailerLines += 1 + continuationLines
+			continuationLines = 0
+		}
+	}
+
+	return len(message)
+}
+
+// trailerSeparator returns the position of the separator of a trailer line,
+// after a token made of alphanumeric chars and dashes, optionally followed
+// by whitespace, or -1.
+func trailerSeparator(line, separators string) int {
+	whitespace := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.IndexByte(separators, c) >= 0:
+			return i
+		case !whitespace && (isAlnum(c) || c == '-'):
+		case i > 0 && (c == ' ' || c == '\t'):
+			whitespace = true
+		default:
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// addTrailer adds t to the items of a trailer block, as git does in
+// process_trailers_lists.
+func addTrailer(items []*trailerItem, t Trailer, o *TrailerOptions) []*trailerItem {
+	conf := o.token(t.Token)
+	arg := &trailerItem{token: t.Token, value: strings.TrimSpace(t.Value)}
+	if conf != nil {
+		arg.token = conf.Key
+		if arg.token == "" {
+			arg.token = conf.Name
+		}
+	}
+
+	arg.where, arg.ifExists, arg.ifMissing = o.rules(conf)
+
+	seps := o.separators()
+	backwards := arg.where == TrailerEnd || arg.where == TrailerAfter
+	middle := arg.where == TrailerAfter || arg.where == TrailerBefore
+
+	for n := range items {
+		in := n
+		if backwards {
+			in = len(items) - 1 - n
+		}
+
+		if items[in].token == "" || !sameTrailerToken(items[in], arg, seps) {
+			continue
+		}
+
+		on := in
+		if !middle && backwards {
+			on = len(items) - 1
+		} else if !middle {
+			on = 0
+		}
+
+		switch arg.ifExists {
+		case TrailerDoNothing:
+			return items
+		case TrailerReplace:
+			replaced := items[in]
+			items = insertTrailerItem(items, on, arg, backwards)
+			return removeTrailerItem(items, replaced)
+		case TrailerAddIfDifferent:
+			if !trailerDiffers(items, in, arg, backwards, true, seps) {
+				return items
+			}
+		case TrailerAddIfDifferentNeighbor:
+			if !trailerDiffers(items, on, arg, backwards, false, seps) {
+				return items
+			}
+		}
+
+		return insertTrailerItem(items, on, arg, backwards)
+	}
+
+	if arg.ifMissing == TrailerDoNothing {
+		return items
+	}
+
+	if backwards {
+		return append(items, arg)
+	}
+
+	return append([]*trailerItem{arg}, items...)
+}
+
+// trailerDiffers returns false if the item at i, or with all, any trailer
+// before it, going backwards, or after it, is the same as arg.
+func trailerDiffers(items []*trailerItem, i int, arg *trailerItem, backwards, all bool, seps string) bool {
+	for i >= 0 && i < len(items) {
+		if items[i].token != "" && sameTrailerToken(items[i], arg, seps) &&
+			strings.EqualFold(items[i].value, arg.value) {
+			return false
+		}
+
+		if !all {
+			return true
+		}
+
+		if backwards {
+			i--
+		} else {
+			i++
+		}
+	}
+
+	return true
+}
+
+func insertTrailerItem(items []*trailerItem, on int, item *trailerItem, after bool) []*trailerItem {
+	if after {
+		on++
+	}
+
+	items = append(items, nil)
+	copy(items[on+1:], items[on:])
+	items[on] = item
+	return items
+}
+
+func removeTrailerItem(items []*trailerItem, item *trailerItem) []*trailerItem {
+	for i, it := range items {
+		if it == item {
+			return append(items[:i], items[i+1:]...)
+		}
+	}
+
+	return items
+}
+
+// sameTrailerToken returns true if the token of a is a prefix of the one of
+// b, or the other way around, ignoring the case and the separators.
+func sameTrailerToken(a, b *trailerItem, seps string) bool {
+	ta, tb := trimTrailerToken(a.token, seps), trimTrailerToken(b.token, seps)
+	n := len(ta)
+	if len(tb) < n {
+		n = len(tb)
+	}
+
+	return strings.EqualFold(ta[:n], tb[:n])
+}
+
+// trimTrailerToken removes the separator and the whitespace ending a token.
+func trimTrailerToken(token, seps string) string {
+	token = strings.TrimRight(token, " \t")
+	if token != "" && strings.IndexByte(seps, token[len(token)-1]) >= 0 {
+		token = strings.TrimRight(token[:len(token)-1], " \t")
+	}
+
+	return token
+}
+
+// formatTrailer returns a trailer line, the first separator being added
+// unless the token already ends with one.
+func formatTrailer(token, value, seps string) string {
+	trimmed := strings.TrimRight(token, " \t")
+	if trimmed != "" && strings.IndexByte(seps, trimmed[len(trimmed)-1]) >= 0 {
+		return token + value
+	}
+
+	return token + seps[:1] + " " + value
+}
+
+// unfoldTrailerValue joins the continuation lines of a value with spaces.
+func unfoldTrailerValue(value string) string {
+	lines := strings.Split(value, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSpace(l)
+	}
+
+	return strings.Join(lines, " ")
+}
+
+func splitTrailerLines(s string) []string {
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func endsWithBlankLine(s string) bool {
+	if !strings.HasSuffix(s, "\n") {
+		return false
+	}
+
+	start := lineStart(s, len(s))
+	return strings.TrimSpace(s[start:]) == ""
+}
+
+// lineEnd returns the position after the end of the line starting at pos.
+func lineEnd(s string, pos int) int {
+	if i := strings.IndexByte(s[pos:], '\n'); i >= 0 {
+		return pos + i + 1
+	}
+
+	return len(s)
+}
+
+// lineStart returns the start of the line ending at end, the newline
+// included.
+func lineStart(s string, end int) int {
+	return strings.LastIndexByte(s[:end-1], '\n') + 1
+}
+
+func isTrailerSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
+
+func isAlnum(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
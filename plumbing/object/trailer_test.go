@@ -0,0 +1,152 @@
+package object
+
+import (
+	"errors"
+
+	"github.com/go-git/go-git/v5/plumbing/format/config"
+
+	. "gopkg.in/check.v1"
+)
+
+type TrailerSuite struct{}
+
+var _ = Suite(&TrailerSuite{})
+
+func (s *TrailerSuite) TestParseTrailers(c *C) {
+	for _, t := range []struct {
+		message  string
+		trailers []Trailer
+	}{
+		{"", nil},
+		{"Foo: bar\n", nil},
+		{"Subject\n\nbody\n", nil},
+		{"Subject\n\nFoo: bar\nBaz= qux\n", nil},
+		{
+			"Subject\n\nbody\n\nFoo: bar\nBaz: qux\n  continued\n\n# comment\n",
+			[]Trailer{{"Foo", "bar"}, {"Baz", "qux continued"}},
+		},
+		{"Subject\n\nsome text\nmore text\nFoo: bar\n", nil},
+		{
+			"Subject\n\nsome text\nmore text\nSigned-off-by: A <a@b>\n",
+			[]Trailer{{"Signed-off-by", "A <a@b>"}},
+		},
+		{"Subject\n\nFoo: bar\n---\n file | 1 +\n\nBar: baz\n", []Trailer{{"Foo", "bar"}}},
+	} {
+		c.Assert(ParseTrailers(t.message, nil), DeepEquals, t.trailers, Commentf("%q", t.message))
+	}
+}
+
+func (s *TrailerSuite) TestParseTrailersOptions(c *C) {
+	o := &TrailerOptions{Separators: ":=", Tokens: []TrailerToken{
+		{Name: "ack", Key: "Acked-by"},
+	}}
+
+	trailers := ParseTrailers("Subject\n\nsome text\nmore text\nack= me\n", o)
+	c.Assert(trailers, DeepEquals, []Trailer{{"Acked-by", "me"}})
+}
+
+func (s *TrailerSuite) TestCommitTrailers(c *C) {
+	commit := &Commit{Message: "Subject\n\nCo-authored-by: A <a@b>\n"}
+	c.Assert(commit.Trailers(), DeepEquals, []Trailer{{"Co-authored-by", "A <a@b>"}})
+}
+
+func (s *TrailerSuite) TestAddTrailers(c *C) {
+	for _, t := range []struct {
+		message  string
+		trailers []Trailer
+		opts     *TrailerOptions
+		expected string
+	}{
+		{"", []Trailer{{"Foo", "bar"}}, nil, "\nFoo: bar\n"},
+		{"Subject", []Trailer{{"Foo", "bar"}}, nil, "Subject\n\nFoo: bar\n"},
+		{"Subject\n\nbody\n", []Trailer{{"Foo", "bar"}}, nil, "Subject\n\nbody\n\nFoo: bar\n"},
+		{"Subject\n\nFoo:bar\n", []Trailer{{"Baz", "qux"}}, nil, "Subject\n\nFoo: bar\nBaz: qux\n"},
+		{"Subject\n\nFoo: bar\n", []Trailer{{"Foo", "bar"}}, nil, "Subject\n\nFoo: bar\n"},
+		{
+			"Subject\n\nbody\n\n# comment\n", []Trailer{{"x", "y"}}, nil,
+			"Subject\n\nbody\n\nx: y\n\n# comment\n",
+		},
+		{
+			"Subject\n\nFoo: bar\n---\n file | 1 +\n", []Trailer{{"x", "y"}}, nil,
+			"Subject\n\nFoo: bar\nx: y\n---\n file | 1 +\n",
+		},
+		{
+			"Subject\n\nFoo: a\nBar: b\n", []Trailer{{"Baz", "c"}},
+			&TrailerOptions{Where: TrailerStart},
+			"Subject\n\nBaz: c\nFoo: a\nBar: b\n",
+		},
+		{
+			"Subject\n\nFoo: a\nBar: b\nFoo: c\n", []Trailer{{"Foo", "d"}},
+			&TrailerOptions{Where: TrailerBefore},
+			"Subject\n\nFoo: d\nFoo: a\nBar: b\nFoo: c\n",
+		},
+		{
+			"Subject\n\nFoo: a\nBar: b\nFoo: c\n", []Trailer{{"Bar", "d"}},
+			&TrailerOptions{Where: TrailerAfter},
+			"Subject\n\nFoo: a\nBar: b\nBar: d\nFoo: c\n",
+		},
+		{
+			"Subject\n\nFoo: a\nBar: b\n", []Trailer{{"Foo", "a"}},
+			nil,
+			"Subject\n\nFoo: a\nBar: b\nFoo: a\n",
+		},
+		{
+			"Subject\n\nFoo: a\nBar: b\n", []Trailer{{"Foo", "A"}},
+			&TrailerOptions{IfExists: TrailerAddIfDifferent},
+			"Subject\n\nFoo: a\nBar: b\n",
+		},
+		{
+			"Subject\n\nFoo: a\nBar: b\n", []Trailer{{"Foo", "c"}},
+			&TrailerOptions{IfExists: TrailerReplace},
+			"Subject\n\nBar: b\nFoo: c\n",
+		},
+		{
+			"Subject\n\nFoo: a\n", []Trailer{{"Foo", "c"}},
+			&TrailerOptions{IfExists: TrailerDoNothing},
+			"Subject\n\nFoo: a\n",
+		},
+		{
+			"Subject\n\nFoo: a\n", []Trailer{{"Bar", "c"}},
+			&TrailerOptions{IfMissing: TrailerDoNothing},
+			"Subject\n\nFoo: a\n",
+		},
+		{
+			"Subject\n\nsome text\nmore text\nAcked-by: x\n", []Trailer{{"ack", "me"}},
+			&TrailerOptions{Tokens: []TrailerToken{{Name: "ack", Key: "Acked-by: ", Where: TrailerStart}}},
+			"Subject\n\nAcked-by: me\nsome text\nmore text\nAcked-by: x\n",
+		},
+		{
+			"Subject\n\nRef# 1\n", []Trailer{{"Ref", "2"}},
+			&TrailerOptions{Separators: "#"},
+			"Subject\n\nRef# 1\nRef# 2\n",
+		},
+	} {
+		c.Assert(AddTrailers(t.message, t.trailers, t.opts), Equals, t.expected, Commentf("%q", t.message))
+	}
+}
+
+func (s *TrailerSuite) TestNewTrailerOptions(c *C) {
+	cfg := config.New()
+	cfg.Section("trailer").
+		SetOption("separators", ":#").
+		SetOption("where", "Start").
+		SetOption("ifexists", "addifdifferent")
+	cfg.Section("trailer").Subsection("ack").
+		SetOption("key", "Acked-by").
+		SetOption("ifMissing", "doNothing")
+
+	o, err := NewTrailerOptions(cfg)
+	c.Assert(err, IsNil)
+	c.Assert(o, DeepEquals, &TrailerOptions{
+		Separators: ":#",
+		Where:      TrailerStart,
+		IfExists:   TrailerAddIfDifferent,
+		Tokens: []TrailerToken{
+			{Name: "ack", Key: "Acked-by", IfMissing: TrailerDoNothing},
+		},
+	})
+
+	cfg.Section("trailer").Subsection("ack").SetOption("ifmissing", "replace")
+	_, err = NewTrailerOptions(cfg)
+	c.Assert(errors.Is(err, ErrInvalidTrailerConfig), Equals, true)
+}
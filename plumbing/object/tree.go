@@ -339,6 +339,18 @@ func (t *Tree) PatchContext(ctx context.Context, to *Tree) (*Patch, error) {
 	return changes.PatchContext(ctx)
 }
 
+// PatchWithOptions returns the Patch between the trees, computing the changes
+// of the files with the given diff options. If no options are passed, the
+// default ones are used. Provided context must be non-nil.
+func (t *Tree) PatchWithOptions(ctx context.Context, to *Tree, o *DiffOptions) (*Patch, error) {
+	changes, err := t.DiffContext(ctx, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return changes.PatchWithOptions(ctx, o)
+}
+
 // treeEntryIter facilitates iterating through the TreeEntry objects in a Tree.
 type treeEntryIter struct {
 	t   *Tree
@@ -36,9 +36,16 @@ var (
 	// shallow-update
 	unshallow = []byte("unshallow ")
 
+	// update-request
+	pushCert    = []byte("push-cert")
+	pushCertEnd = []byte("push-cert-end")
+
 	// server-response
-	ack = []byte("ACK")
-	nak = []byte("NAK")
+	ack         = []byte("ACK")
+	nak         = []byte("NAK")
+	ackContinue = []byte("continue")
+	ackCommon   = []byte("common")
+	ackReady    = []byte("ready")
 
 	// updreq
 	shallowNoSp = []byte("shallow")
@@ -0,0 +1,228 @@
+package packp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+const (
+	// PushCertVersion is the version of the push certificates.
+	PushCertVersion = "0.1"
+
+	pgpSignatureBegin = "-----BEGIN PGP SIGNATURE-----"
+)
+
+var (
+	// ErrMalformedPushCert is returned when a push certificate can't be
+	// decoded.
+	ErrMalformedPushCert = errors.New("malformed push certificate")
+	// ErrUnsignedPushCert is returned when verifying a push certificate
+	// without signature.
+	ErrUnsignedPushCert = errors.New("push certificate is not signed")
+)
+
+// PushCert is a push certificate, signing the commands of a push to record
+// who pushed what: see "Push Certificate" in the package documentation.
+type PushCert struct {
+	// Version is the version of the certificate, PushCertVersion.
+	Version string
+	// Pusher is the identity of the signing key followed by the time of the
+	// push, as in "Name <email> 1433954361 -0700".
+	Pusher string
+	// Pushee is the URL of the repository pushed to, without credentials.
+	Pushee string
+	// Nonce is the nonce advertised by the server with the push-cert
+	// capability.
+	Nonce string
+	// Options are the push options of the push.
+	Options []string
+	// Commands are the commands of the push.
+	Commands []*Command
+	// Signature is the armored detached signature of the certificate, without
+	// itself.
+	Signature string
+
+	// payload is the certificate without its signature as decoded, the
+	// signature being verified against these bytes and not an encoding of
+	// the fields.
+	payload []byte
+}
+
+// EncodeWithoutSignature writes the certificate without its signature, the
+// payload being signed.
+func (c *PushCert) EncodeWithoutSignature(w io.Writer) error {
+	if c.Version == "" || c.Pusher == "" {
+		return ErrMalformedPushCert
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "certificate version %s\n", c.Version)
+	fmt.Fprintf(&b, "pusher %s\n", c.Pusher)
+	if c.Pushee != "" {
+		fmt.Fprintf(&b, "pushee %s\n", c.Pushee)
+	}
+
+	fmt.Fprintf(&b, "nonce %s\n", c.Nonce)
+	for _, o := range c.Options {
+		fmt.Fprintf(&b, "push-option %s\n", o)
+	}
+
+	b.WriteString("\n")
+	for _, cmd := range c.Commands {
+		fmt.Fprintf(&b, "%s\n", formatCommand(cmd))
+	}
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// Encode writes the certificate, followed by its signature.
+func (c *PushCert) Encode(w io.Writer) error {
+	if err := c.EncodeWithoutSignature(w); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, c.Signature)
+	return err
+}
+
+// Payload returns the certificate without its signature, the bytes signed.
+// It's the payload as read by Decode, if the certificate was decoded, its
+// encoding by EncodeWithoutSignature otherwise.
+func (c *PushCert) Payload() ([]byte, error) {
+	if c.payload != nil {
+		return c.payload, nil
+	}
+
+	var b bytes.Buffer
+	if err := c.EncodeWithoutSignature(&b); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// Decode reads a certificate, as written by Encode. The payload read is kept
+// as is, see Payload.
+func (c *PushCert) Decode(r io.Reader) error {
+	*c = PushCert{}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	payload := data
+	if i := signatureIndex(data); i != -1 {
+		payload = data[:i]
+		c.Signature = string(data[i:])
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(payload))
+	headers := true
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case headers && line == "":
+			headers = false
+		case headers:
+			if err := c.decodeHeader(line); err != nil {
+				return err
+			}
+		default:
+			cmd, err := parseCommand([]byte(line))
+			if err != nil {
+				return err
+			}
+
+			c.Commands = append(c.Commands, cmd)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	if headers || c.Version == "" || c.Pusher == "" {
+		return ErrMalformedPushCert
+	}
+
+	c.payload = payload
+	return nil
+}
+
+// signatureIndex returns the index of the line starting the signature of a
+// certificate, -1 if it has none.
+func signatureIndex(data []byte) int {
+	if bytes.HasPrefix(data, []byte(pgpSignatureBegin)) {
+		return 0
+	}
+
+	i := bytes.Index(data, []byte("\n"+pgpSignatureBegin))
+	if i == -1 {
+		return -1
+	}
+
+	return i + 1
+}
+
+func (c *PushCert) decodeHeader(line string) error {
+	i := strings.IndexByte(line, ' ')
+	if i == -1 {
+		return fmt.Errorf("%w: invalid header %q", ErrMalformedPushCert, line)
+	}
+
+	value := line[i+1:]
+	switch line[:i] {
+	case "certificate":
+		c.Version = strings.TrimPrefix(value, "version ")
+		if c.Version != PushCertVersion {
+			return fmt.Errorf("%w: unknown version %q", ErrMalformedPushCert, c.Version)
+		}
+	case "pusher":
+		c.Pusher = value
+	case "pushee":
+		c.Pushee = value
+	case "nonce":
+		c.Nonce = value
+	case "push-option":
+		c.Options = append(c.Options, value)
+	}
+
+	return nil
+}
+
+// Verify checks the signature of the certificate with the given armored
+// keyring, returning the entity which signed it.
+func (c *PushCert) Verify(armoredKeyRing string) (*openpgp.Entity, error) {
+	keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armoredKeyRing))
+	if err != nil {
+		return nil, err
+	}
+
+	return c.VerifyKeyRing(keyring)
+}
+
+// VerifyKeyRing checks the signature of the certificate with the given
+// keyring, returning the entity which signed it.
+func (c *PushCert) VerifyKeyRing(keyring openpgp.KeyRing) (*openpgp.Entity, error) {
+	if c.Signature == "" {
+		return nil, ErrUnsignedPushCert
+	}
+
+	payload, err := c.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	return openpgp.CheckArmoredDetachedSignature(
+		keyring, bytes.NewReader(payload), strings.NewReader(c.Signature), nil,
+	)
+}
@@ -0,0 +1,134 @@
+package packp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-git/v5/plumbing"
+
+	. "gopkg.in/check.v1"
+)
+
+type PushCertSuite struct{}
+
+var _ = Suite(&PushCertSuite{})
+
+func (s *PushCertSuite) cert() *PushCert {
+	return &PushCert{
+		Version: PushCertVersion,
+		Pusher:  "foo <foo@foo.com> 1433954361 -0700",
+		Pushee:  "https://example.com/foo.git",
+		Nonce:   "1433954361-bde756572d665bba81d8",
+		Options: []string{"foo", "bar=baz"},
+		Commands: []*Command{{
+			Name: "refs/heads/master",
+			New:  plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+		}},
+	}
+}
+
+func (s *PushCertSuite) TestEncodeDecode(c *C) {
+	cert := s.cert()
+	cert.Signature = "-----BEGIN PGP SIGNATURE-----\nfoo\n-----END PGP SIGNATURE-----\n"
+
+	var buf bytes.Buffer
+	c.Assert(cert.Encode(&buf), IsNil)
+	c.Assert(buf.String(), Equals, ""+
+		"certificate version 0.1\n"+
+		"pusher foo <foo@foo.com> 1433954361 -0700\n"+
+		"pushee https://example.com/foo.git\n"+
+		"nonce 1433954361-bde756572d665bba81d8\n"+
+		"push-option foo\n"+
+		"push-option bar=baz\n"+
+		"\n"+
+		"0000000000000000000000000000000000000000 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n"+
+		"-----BEGIN PGP SIGNATURE-----\nfoo\n-----END PGP SIGNATURE-----\n",
+	)
+
+	var payload bytes.Buffer
+	c.Assert(cert.EncodeWithoutSignature(&payload), IsNil)
+
+	decoded := &PushCert{}
+	c.Assert(decoded.Decode(&buf), IsNil)
+	c.Assert(decoded.payload, DeepEquals, payload.Bytes())
+
+	decoded.payload = nil
+	c.Assert(decoded, DeepEquals, cert)
+}
+
+func (s *PushCertSuite) TestDecodeMalformed(c *C) {
+	for _, input := range []string{
+		"",
+		"certificate version 0.1\npusher foo\n",
+		"certificate version 0.2\npusher foo\n\n",
+		"pusher foo\n\n",
+		"certificate\n\n",
+	} {
+		err := (&PushCert{}).Decode(bytes.NewBufferString(input))
+		c.Assert(errors.Is(err, ErrMalformedPushCert), Equals, true, Commentf("%q", input))
+	}
+}
+
+func (s *PushCertSuite) TestVerify(c *C) {
+	e, err := openpgp.NewEntity("foo", "", "foo@foo.com", nil)
+	c.Assert(err, IsNil)
+
+	cert := s.cert()
+	_, err = cert.VerifyKeyRing(openpgp.EntityList{e})
+	c.Assert(err, Equals, ErrUnsignedPushCert)
+
+	var payload, signature bytes.Buffer
+	c.Assert(cert.EncodeWithoutSignature(&payload), IsNil)
+	c.Assert(openpgp.ArmoredDetachSign(&signature, e, &payload, nil), IsNil)
+	cert.Signature = signature.String() + "\n"
+
+	var armored bytes.Buffer
+	w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+	c.Assert(err, IsNil)
+	c.Assert(e.Serialize(w), IsNil)
+	c.Assert(w.Close(), IsNil)
+
+	signer, err := cert.Verify(armored.String())
+	c.Assert(err, IsNil)
+	c.Assert(signer.PrimaryKey.KeyId, Equals, e.PrimaryKey.KeyId)
+
+	cert.Commands[0].Name = "refs/heads/foo"
+	_, err = cert.VerifyKeyRing(openpgp.EntityList{e})
+	c.Assert(err, NotNil)
+}
+
+func (s *PushCertSuite) TestVerifyDecoded(c *C) {
+	e, err := openpgp.NewEntity("foo", "", "foo@foo.com", nil)
+	c.Assert(err, IsNil)
+
+	// the unknown header is not decoded, but it's signed
+	payload := "" +
+		"certificate version 0.1\n" +
+		"pusher foo <foo@foo.com> 1433954361 -0700\n" +
+		"nonce 1433954361-bde756572d665bba81d8\n" +
+		"unknown header\n" +
+		"\n" +
+		"0000000000000000000000000000000000000000 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n"
+
+	var signature bytes.Buffer
+	c.Assert(openpgp.ArmoredDetachSign(&signature, e, bytes.NewBufferString(payload), nil), IsNil)
+
+	cert := &PushCert{}
+	c.Assert(cert.Decode(bytes.NewBufferString(payload+signature.String()+"\n")), IsNil)
+
+	p, err := cert.Payload()
+	c.Assert(err, IsNil)
+	c.Assert(string(p), Equals, payload)
+
+	signer, err := cert.VerifyKeyRing(openpgp.EntityList{e})
+	c.Assert(err, IsNil)
+	c.Assert(signer.PrimaryKey.KeyId, Equals, e.PrimaryKey.KeyId)
+
+	tampered := strings.Replace(payload, "refs/heads/master", "refs/heads/foo", 1)
+	c.Assert(cert.Decode(bytes.NewBufferString(tampered+signature.String()+"\n")), IsNil)
+	_, err = cert.VerifyKeyRing(openpgp.EntityList{e})
+	c.Assert(err, NotNil)
+}
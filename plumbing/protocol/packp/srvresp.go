@@ -20,13 +20,18 @@ type ServerResponse struct {
 
 // Decode decodes the response into the struct, isMultiACK should be true, if
 // the request was done with multi_ack or multi_ack_detailed capabilities.
+//
+// With them, the server acknowledges the common commits of the haves while
+// reading them, with the "continue", "common" or "ready" ACK statuses, before
+// the final ACK. Those are only meaningful when negotiating the haves, so
+// they are skipped, only the final ACKs are kept.
 func (r *ServerResponse) Decode(reader *bufio.Reader, isMultiACK bool) error {
 	s := pktline.NewScanner(reader)
 
 	for s.Scan() {
 		line := s.Bytes()
 
-		if err := r.decodeLine(line); err != nil {
+		if err := r.decodeLine(line, isMultiACK); err != nil {
 			return err
 		}
 
@@ -43,23 +48,7 @@ func (r *ServerResponse) Decode(reader *bufio.Reader, isMultiACK bool) error {
 		}
 	}
 
-	// isMultiACK is true when the remote server advertises the related
-	// capabilities when they are not in transport.UnsupportedCapabilities.
-	//
-	// Users may decide to remove multi_ack and multi_ack_detailed from the
-	// unsupported capabilities list, which allows them to do initial clones
-	// from Azure DevOps.
-	//
-	// Follow-up fetches may error, therefore errors are wrapped with additional
-	// information highlighting that this capabilities are not supported by go-git.
-	//
-	// TODO: Implement support for multi_ack or multi_ack_detailed responses.
-	err := s.Err()
-	if err != nil && isMultiACK {
-		return fmt.Errorf("multi_ack and multi_ack_detailed are not supported: %w", err)
-	}
-
-	return err
+	return s.Err()
 }
 
 // stopReading detects when a valid command such as ACK or NAK is found to be
@@ -96,13 +85,13 @@ func (r *ServerResponse) isValidCommand(b []byte) bool {
 	return false
 }
 
-func (r *ServerResponse) decodeLine(line []byte) error {
+func (r *ServerResponse) decodeLine(line []byte, isMultiACK bool) error {
 	if len(line) == 0 {
 		return fmt.Errorf("unexpected flush")
 	}
 
 	if bytes.Equal(line[0:3], ack) {
-		return r.decodeACKLine(line)
+		return r.decodeACKLine(line, isMultiACK)
 	}
 
 	if bytes.Equal(line[0:3], nak) {
@@ -112,22 +101,36 @@ func (r *ServerResponse) decodeLine(line []byte) error {
 	return fmt.Errorf("unexpected content %q", string(line))
 }
 
-func (r *ServerResponse) decodeACKLine(line []byte) error {
+func (r *ServerResponse) decodeACKLine(line []byte, isMultiACK bool) error {
 	if len(line) < ackLineLen {
 		return fmt.Errorf("malformed ACK %q", line)
 	}
 
 	sp := bytes.Index(line, []byte(" "))
 	h := plumbing.NewHash(string(line[sp+1 : sp+41]))
-	r.ACKs = append(r.ACKs, h)
+
+	status := bytes.TrimSpace(line[sp+41:])
+	switch {
+	case len(status) == 0:
+		r.ACKs = append(r.ACKs, h)
+	case !isMultiACK:
+		return fmt.Errorf("unexpected ACK status without multi_ack %q", line)
+	case bytes.Equal(status, ackContinue),
+		bytes.Equal(status, ackCommon),
+		bytes.Equal(status, ackReady):
+	default:
+		return fmt.Errorf("malformed ACK %q", line)
+	}
+
 	return nil
 }
 
-// Encode encodes the ServerResponse into a writer.
+// Encode encodes the ServerResponse into a writer. Only the final response
+// is encoded, the NAK or the ACK of the first common commit, since several
+// ACKs are only sent with multi_ack or multi_ack_detailed.
 func (r *ServerResponse) Encode(w io.Writer, isMultiACK bool) error {
 	if len(r.ACKs) > 1 && !isMultiACK {
-		// For further information, refer to comments in the Decode func above.
-		return errors.New("multi_ack and multi_ack_detailed are not supported")
+		return errors.New("several ACKs need multi_ack or multi_ack_detailed")
 	}
 
 	e := pktline.NewEncoder(w)
@@ -72,10 +72,6 @@ func (s *ServerResponseSuite) TestDecodeMalformed(c *C) {
 	c.Assert(err, NotNil)
 }
 
-// multi_ack isn't fully implemented, this ensures that Decode ignores that fact,
-// as in some circumstances that's OK to assume so.
-//
-// TODO: Review as part of multi_ack implementation.
 func (s *ServerResponseSuite) TestDecodeMultiACK(c *C) {
 	raw := "" +
 		"0031ACK 1111111111111111111111111111111111111111\n" +
@@ -90,3 +86,33 @@ func (s *ServerResponseSuite) TestDecodeMultiACK(c *C) {
 	c.Assert(sr.ACKs[0], Equals, plumbing.NewHash("1111111111111111111111111111111111111111"))
 	c.Assert(sr.ACKs[1], Equals, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
 }
+
+func (s *ServerResponseSuite) TestDecodeMultiACKDetailed(c *C) {
+	raw := "" +
+		"0038ACK 1111111111111111111111111111111111111111 common\n" +
+		"0037ACK 2222222222222222222222222222222222222222 ready\n" +
+		"003aACK 3333333333333333333333333333333333333333 continue\n" +
+		"0008NAK\n" +
+		"0031ACK 1111111111111111111111111111111111111111\n" +
+		"00080PACK\n"
+
+	sr := &ServerResponse{}
+	err := sr.Decode(bufio.NewReader(bytes.NewBufferString(raw)), true)
+	c.Assert(err, IsNil)
+
+	c.Assert(sr.ACKs, DeepEquals, []plumbing.Hash{plumbing.NewHash("1111111111111111111111111111111111111111")})
+}
+
+func (s *ServerResponseSuite) TestDecodeACKStatus(c *C) {
+	for _, t := range []struct {
+		raw        string
+		isMultiACK bool
+	}{
+		{"0038ACK 1111111111111111111111111111111111111111 common\n", false},
+		{"0037ACK 1111111111111111111111111111111111111111 other\n", true},
+	} {
+		sr := &ServerResponse{}
+		err := sr.Decode(bufio.NewReader(bytes.NewBufferString(t.raw)), t.isMultiACK)
+		c.Assert(err, NotNil, Commentf("%q", t.raw))
+	}
+}
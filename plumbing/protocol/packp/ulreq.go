@@ -20,7 +20,7 @@ type UploadRequest struct {
 }
 
 // Depth values stores the desired depth of the requested packfile: see
-// DepthCommit, DepthSince and DepthReference.
+// DepthCommit, DepthSince, DepthReference and Depths.
 type Depth interface {
 	isDepth()
 	IsZero() bool
@@ -55,6 +55,22 @@ func (d DepthReference) IsZero() bool {
 	return string(d) == ""
 }
 
+// Depths values combine several depths sent together, as a DepthSince and
+// some DepthReference.
+type Depths []Depth
+
+func (d Depths) isDepth() {}
+
+func (d Depths) IsZero() bool {
+	for _, depth := range d {
+		if !depth.IsZero() {
+			return false
+		}
+	}
+
+	return true
+}
+
 // NewUploadRequest returns a pointer to a new UploadRequest value, ready to be
 // used. It has no capabilities, wants or shallows and an infinite depth. Please
 // note that to encode an upload-request it has to have at least one wanted hash.
@@ -107,6 +123,7 @@ func NewUploadRequestFromCapabilities(adv *capability.List) *UploadRequest {
 //   - is a non-zero DepthCommits is given capability.Shallow MUST be present
 //   - is a DepthSince is given capability.Shallow MUST be present
 //   - is a DepthReference is given capability.DeepenNot MUST be present
+//   - is a Depths is given, the rules above apply to each of its depths
 //   - MUST contain only maximum of one of capability.Sideband and capability.Sideband64k
 //   - MUST contain only maximum of one of capability.MultiACK and capability.MultiACKDetailed
 func (req *UploadRequest) Validate() error {
@@ -132,9 +149,15 @@ func (req *UploadRequest) validateRequiredCapabilities() error {
 		return fmt.Errorf(msg, capability.Shallow)
 	}
 
-	switch req.Depth.(type) {
+	return req.validateDepth(req.Depth)
+}
+
+func (req *UploadRequest) validateDepth(depth Depth) error {
+	msg := "missing capability %s"
+
+	switch depth := depth.(type) {
 	case DepthCommits:
-		if req.Depth != DepthCommits(0) {
+		if depth != DepthCommits(0) {
 			if !req.Capabilities.Supports(capability.Shallow) {
 				return fmt.Errorf(msg, capability.Shallow)
 			}
@@ -147,6 +170,12 @@ func (req *UploadRequest) validateRequiredCapabilities() error {
 		if !req.Capabilities.Supports(capability.DeepenNot) {
 			return fmt.Errorf(msg, capability.DeepenNot)
 		}
+	case Depths:
+		for _, d := range depth {
+			if err := req.validateDepth(d); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -217,7 +217,7 @@ func (d *ulReqDecoder) decodeDeepenCommits() stateFn {
 		d.err = fmt.Errorf("negative depth")
 		return nil
 	}
-	d.data.Depth = DepthCommits(n)
+	d.addDepth(DepthCommits(n))
 
 	return d.decodeFlush
 }
@@ -231,7 +231,7 @@ func (d *ulReqDecoder) decodeDeepenSince() stateFn {
 		return nil
 	}
 	t := time.Unix(secs, 0).UTC()
-	d.data.Depth = DepthSince(t)
+	d.addDepth(DepthSince(t))
 
 	return d.decodeFlush
 }
@@ -239,16 +239,34 @@ func (d *ulReqDecoder) decodeDeepenSince() stateFn {
 func (d *ulReqDecoder) decodeDeepenReference() stateFn {
 	d.line = bytes.TrimPrefix(d.line, deepenReference)
 
-	d.data.Depth = DepthReference(string(d.line))
+	d.addDepth(DepthReference(string(d.line)))
 
 	return d.decodeFlush
 }
 
+// addDepth adds a decoded depth, several ones being combined as Depths.
+func (d *ulReqDecoder) addDepth(depth Depth) {
+	switch current := d.data.Depth.(type) {
+	case Depths:
+		d.data.Depth = append(current, depth)
+	default:
+		if current == nil || current.IsZero() {
+			d.data.Depth = depth
+		} else {
+			d.data.Depth = Depths{current, depth}
+		}
+	}
+}
+
 func (d *ulReqDecoder) decodeFlush() stateFn {
 	if ok := d.nextLine(); !ok {
 		return nil
 	}
 
+	if bytes.HasPrefix(d.line, deepen) {
+		return d.decodeDeepen
+	}
+
 	if len(d.line) != 0 {
 		d.err = fmt.Errorf("unexpected payload while expecting a flush-pkt: %q", d.line)
 	}
@@ -477,6 +477,24 @@ func (s *UlReqDecodeSuite) TestDeepenReference(c *C) {
 	c.Assert(string(reference), Equals, expected)
 }
 
+func (s *UlReqDecodeSuite) TestDeepenSeveral(c *C) {
+	payloads := []string{
+		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
+		"deepen-since 1420167845",
+		"deepen-not refs/heads/master",
+		"deepen-not refs/heads/branch",
+		pktline.FlushString,
+	}
+	ur := s.testDecodeOK(c, payloads)
+
+	since := time.Date(2015, time.January, 2, 3, 4, 5, 0, time.UTC)
+	c.Assert(ur.Depth, DeepEquals, Depths{
+		DepthSince(since),
+		DepthReference("refs/heads/master"),
+		DepthReference("refs/heads/branch"),
+	})
+}
+
 func (s *UlReqDecodeSuite) TestAll(c *C) {
 	payloads := []string{
 		"want 3333333333333333333333333333333333333333 ofs-delta multi_ack",
@@ -90,6 +90,21 @@ func (s *UlReqSuite) TestValidateDepthSince(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *UlReqSuite) TestValidateDepths(c *C) {
+	r := NewUploadRequest()
+	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
+	r.Depth = Depths{DepthSince(time.Now()), DepthReference("refs/heads/master")}
+	c.Assert(r.Depth.IsZero(), Equals, false)
+
+	r.Capabilities.Set(capability.DeepenSince)
+	err := r.Validate()
+	c.Assert(err, ErrorMatches, "missing capability deepen-not")
+
+	r.Capabilities.Set(capability.DeepenNot)
+	err = r.Validate()
+	c.Assert(err, IsNil)
+}
+
 func (s *UlReqSuite) TestValidateConflictSideband(c *C) {
 	r := NewUploadRequest()
 	r.Wants = append(r.Wants, plumbing.NewHash("1111111111111111111111111111111111111111"))
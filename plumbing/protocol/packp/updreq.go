@@ -12,6 +12,7 @@ import (
 var (
 	ErrEmptyCommands    = errors.New("commands cannot be empty")
 	ErrMalformedCommand = errors.New("malformed command")
+	ErrPushCertCommands = errors.New("push certificate commands don't match the request ones")
 )
 
 // ReferenceUpdateRequest values represent reference upload requests.
@@ -21,6 +22,9 @@ type ReferenceUpdateRequest struct {
 	Commands     []*Command
 	Options      []*Option
 	Shallow      *plumbing.Hash
+	// PushCert is the optional push certificate signing the commands, sent
+	// instead of them. Its commands must be the ones of the request.
+	PushCert *PushCert
 	// Packfile contains an optional packfile reader.
 	Packfile io.ReadCloser
 
@@ -31,7 +35,6 @@ type ReferenceUpdateRequest struct {
 // New returns a pointer to a new ReferenceUpdateRequest value.
 func NewReferenceUpdateRequest() *ReferenceUpdateRequest {
 	return &ReferenceUpdateRequest{
-		// TODO: Add support for push-cert
 		Capabilities: capability.NewList(),
 		Commands:     nil,
 	}
@@ -48,6 +51,7 @@ func NewReferenceUpdateRequest() *ReferenceUpdateRequest {
 //   - ofs-delta
 //   - ref-delta
 //   - delete-refs
+//
 // It leaves up to the user to add the following capabilities later:
 //   - atomic
 //   - ofs-delta
@@ -80,6 +84,19 @@ func (req *ReferenceUpdateRequest) validate() error {
 		}
 	}
 
+	if req.PushCert != nil {
+		if len(req.PushCert.Commands) != len(req.Commands) {
+			return ErrPushCertCommands
+		}
+
+		for i, c := range req.Commands {
+			cc := req.PushCert.Commands[i]
+			if c.Name != cc.Name || c.Old != cc.Old || c.New != cc.New {
+				return ErrPushCertCommands
+			}
+		}
+	}
+
 	return nil
 }
 
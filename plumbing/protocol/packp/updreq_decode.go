@@ -10,6 +10,7 @@ import (
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 )
 
 var (
@@ -101,6 +102,7 @@ func (d *updReqDecoder) Decode(req *ReferenceUpdateRequest) error {
 		d.decodeShallow,
 		d.decodeCommandAndCapabilities,
 		d.decodeCommands,
+		d.decodeOptions,
 		d.setPackfile,
 		req.validate,
 	}
@@ -167,8 +169,38 @@ func (d *updReqDecoder) decodeCommands() error {
 	}
 }
 
+// decodeOptions decodes the push options following the commands, sent if the
+// push-options capability is requested.
+func (d *updReqDecoder) decodeOptions() error {
+	if !d.req.Capabilities.Supports(capability.PushOptions) {
+		return nil
+	}
+
+	for {
+		if err := d.scanLine(); err != nil {
+			return d.scanErrorOr(errMalformedRequest("unexpected EOF in push options"))
+		}
+
+		b := d.s.Bytes()
+		if bytes.Equal(b, pktline.Flush) {
+			return nil
+		}
+
+		opt := &Option{Key: string(b)}
+		if i := bytes.IndexByte(b, '='); i != -1 {
+			opt.Key, opt.Value = string(b[:i]), string(b[i+1:])
+		}
+
+		d.req.Options = append(d.req.Options, opt)
+	}
+}
+
 func (d *updReqDecoder) decodeCommandAndCapabilities() error {
 	b := d.s.Bytes()
+	if bytes.HasPrefix(b, pushCert) && len(b) > len(pushCert) && b[len(pushCert)] == 0 {
+		return d.decodePushCert()
+	}
+
 	i := bytes.IndexByte(b, 0)
 	if i == -1 {
 		return errMissingCapabilitiesDelimiter
@@ -196,6 +228,37 @@ func (d *updReqDecoder) decodeCommandAndCapabilities() error {
 	return nil
 }
 
+// decodePushCert decodes the push certificate sent instead of the commands,
+// setting them from it. The scanner is left on the flush-pkt following it.
+func (d *updReqDecoder) decodePushCert() error {
+	b := bytes.TrimSuffix(d.s.Bytes()[len(pushCert)+1:], eol)
+	if err := d.req.Capabilities.Decode(b); err != nil {
+		return err
+	}
+
+	var cert bytes.Buffer
+	for {
+		if err := d.scanLine(); err != nil {
+			return d.scanErrorOr(errMalformedRequest("unexpected EOF in push certificate"))
+		}
+
+		line := d.s.Bytes()
+		if bytes.Equal(bytes.TrimSuffix(line, eol), pushCertEnd) {
+			break
+		}
+
+		cert.Write(line)
+	}
+
+	d.req.PushCert = &PushCert{}
+	if err := d.req.PushCert.Decode(&cert); err != nil {
+		return err
+	}
+
+	d.req.Commands = d.req.PushCert.Commands
+	return d.scanLine()
+}
+
 func (d *updReqDecoder) setPackfile() error {
 	d.req.Packfile = d.r
 
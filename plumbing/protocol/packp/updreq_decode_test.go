@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io"
 	"io/ioutil"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
@@ -237,6 +238,86 @@ func (s *UpdReqDecodeSuite) TestMultipleCommandsAndCapabilitiesShallow(c *C) {
 	s.testDecodeOkExpected(c, expected, payloads)
 }
 
+func (s *UpdReqDecodeSuite) TestPushOptions(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	expected := NewReferenceUpdateRequest()
+	expected.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref"), Old: hash1, New: hash2},
+	}
+	expected.Capabilities.Add("push-options")
+	expected.Options = []*Option{
+		{Key: "SomeKey", Value: "SomeValue"},
+		{Key: "ci.skip"},
+	}
+	expected.Packfile = ioutil.NopCloser(bytes.NewReader([]byte{}))
+
+	payloads := []string{
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref\x00push-options",
+		pktline.FlushString,
+		"SomeKey=SomeValue",
+		"ci.skip",
+		pktline.FlushString,
+	}
+
+	s.testDecodeOkExpected(c, expected, payloads)
+
+	payloads = payloads[:3]
+	s.testDecoderErrorMatches(c, toPktLines(c, payloads), "^malformed request: unexpected EOF in push options$")
+}
+
+func (s *UpdReqDecodeSuite) TestPushCert(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	expected := NewReferenceUpdateRequest()
+	expected.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref1"), Old: hash1, New: hash2},
+		{Name: plumbing.ReferenceName("myref2"), Old: plumbing.ZeroHash, New: hash2},
+	}
+	expected.Capabilities.Add("report-status")
+	expected.PushCert = &PushCert{
+		Version:   "0.1",
+		Pusher:    "foo <foo@foo.com> 1433954361 -0700",
+		Pushee:    "https://example.com/foo.git",
+		Nonce:     "1433954361-bde756572d665bba81d8",
+		Commands:  expected.Commands,
+		Signature: "-----BEGIN PGP SIGNATURE-----\nfoo\n-----END PGP SIGNATURE-----\n",
+	}
+	expected.Packfile = ioutil.NopCloser(bytes.NewReader([]byte{}))
+
+	payloads := []string{
+		"push-cert\x00report-status\n",
+		"certificate version 0.1\n",
+		"pusher foo <foo@foo.com> 1433954361 -0700\n",
+		"pushee https://example.com/foo.git\n",
+		"nonce 1433954361-bde756572d665bba81d8\n",
+		"\n",
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref1\n",
+		"0000000000000000000000000000000000000000 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref2\n",
+		"-----BEGIN PGP SIGNATURE-----\n",
+		"foo\n",
+		"-----END PGP SIGNATURE-----\n",
+		"push-cert-end\n",
+		pktline.FlushString,
+	}
+
+	// the payload is kept as received, to verify the signature
+	expected.PushCert.payload = []byte(strings.Join(payloads[1:8], ""))
+
+	s.testDecodeOkExpected(c, expected, payloads)
+}
+
+func (s *UpdReqDecodeSuite) TestPushCertUnterminated(c *C) {
+	payloads := []string{
+		"push-cert\x00report-status\n",
+		"certificate version 0.1\n",
+	}
+
+	s.testDecoderErrorMatches(c, toPktLines(c, payloads), "^malformed request: unexpected EOF in push certificate$")
+}
+
 func (s *UpdReqDecodeSuite) TestWithPackfile(c *C) {
 	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
 	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
@@ -1,8 +1,10 @@
 package packp
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
@@ -21,7 +23,11 @@ func (req *ReferenceUpdateRequest) Encode(w io.Writer) error {
 		return err
 	}
 
-	if err := req.encodeCommands(e, req.Commands, req.Capabilities); err != nil {
+	if req.PushCert != nil {
+		if err := req.encodePushCert(e, req.PushCert, req.Capabilities); err != nil {
+			return err
+		}
+	} else if err := req.encodeCommands(e, req.Commands, req.Capabilities); err != nil {
 		return err
 	}
 
@@ -70,6 +76,37 @@ func (req *ReferenceUpdateRequest) encodeCommands(e *pktline.Encoder,
 	return e.Flush()
 }
 
+// encodePushCert sends the certificate line by line, in place of the
+// commands, as git send-pack does.
+func (req *ReferenceUpdateRequest) encodePushCert(e *pktline.Encoder,
+	cert *PushCert, cap *capability.List) error {
+
+	if err := e.Encodef("%s\x00%s\n", pushCert, cap.String()); err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	if err := cert.Encode(&b); err != nil {
+		return err
+	}
+
+	for _, line := range strings.SplitAfter(b.String(), "\n") {
+		if line == "" {
+			continue
+		}
+
+		if err := e.EncodeString(line); err != nil {
+			return err
+		}
+	}
+
+	if err := e.Encodef("%s\n", pushCertEnd); err != nil {
+		return err
+	}
+
+	return e.Flush()
+}
+
 func formatCommand(cmd *Command) string {
 	o := cmd.Old.String()
 	n := cmd.New.String()
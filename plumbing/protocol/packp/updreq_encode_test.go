@@ -75,6 +75,53 @@ func (s *UpdReqEncodeSuite) TestMultipleCommands(c *C) {
 	s.testEncode(c, r, expected)
 }
 
+func (s *UpdReqEncodeSuite) TestPushCert(c *C) {
+	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	r := NewReferenceUpdateRequest()
+	r.Capabilities.Add(capability.ReportStatus)
+	r.Commands = []*Command{
+		{Name: plumbing.ReferenceName("myref"), Old: hash1, New: hash2},
+	}
+	r.PushCert = &PushCert{
+		Version:   PushCertVersion,
+		Pusher:    "foo <foo@foo.com> 1433954361 -0700",
+		Nonce:     "1433954361-bde756572d665bba81d8",
+		Options:   []string{"foo=bar"},
+		Commands:  r.Commands,
+		Signature: "-----BEGIN PGP SIGNATURE-----\nfoo\n-----END PGP SIGNATURE-----\n",
+	}
+
+	expected := pktlines(c,
+		"push-cert\x00report-status\n",
+		"certificate version 0.1\n",
+		"pusher foo <foo@foo.com> 1433954361 -0700\n",
+		"nonce 1433954361-bde756572d665bba81d8\n",
+		"push-option foo=bar\n",
+		"\n",
+		"1ecf0ef2c2dffb796033e5a02219af86ec6584e5 2ecf0ef2c2dffb796033e5a02219af86ec6584e5 myref\n",
+		"-----BEGIN PGP SIGNATURE-----\n",
+		"foo\n",
+		"-----END PGP SIGNATURE-----\n",
+		"push-cert-end\n",
+		pktline.FlushString,
+	)
+
+	s.testEncode(c, r, expected)
+
+	r.Commands = append(r.Commands, &Command{Name: "myref2", New: hash2})
+	var buf bytes.Buffer
+	c.Assert(r.Encode(&buf), Equals, ErrPushCertCommands)
+
+	// the commands must be the same, not only as many
+	r.Commands = []*Command{{Name: "myref", Old: hash1, New: hash1}}
+	c.Assert(r.Encode(&buf), Equals, ErrPushCertCommands)
+
+	r.Commands = []*Command{{Name: "otherref", Old: hash1, New: hash2}}
+	c.Assert(r.Encode(&buf), Equals, ErrPushCertCommands)
+}
+
 func (s *UpdReqEncodeSuite) TestMultipleCommandsAndCapabilities(c *C) {
 	hash1 := plumbing.NewHash("1ecf0ef2c2dffb796033e5a02219af86ec6584e5")
 	hash2 := plumbing.NewHash("2ecf0ef2c2dffb796033e5a02219af86ec6584e5")
@@ -87,6 +87,18 @@ type PackedObjectStorer interface {
 	DeleteOldObjectPackAndIndex(plumbing.Hash, time.Time) error
 }
 
+// PackedObjectInspector is an optional interface for storers implementing
+// PackedObjectStorer, giving details about each object pack.
+type PackedObjectInspector interface {
+	// ObjectPackHashes returns the hashes of the objects stored in a pack.
+	ObjectPackHashes(pack plumbing.Hash) ([]plumbing.Hash, error)
+	// ObjectPackTime returns the modification time of a pack.
+	ObjectPackTime(pack plumbing.Hash) (time.Time, error)
+	// IsObjectPackKept returns true if a pack is marked to be kept, such as
+	// with a ".keep" file, so it must be neither repacked nor deleted.
+	IsObjectPackKept(pack plumbing.Hash) (bool, error)
+}
+
 // PackfileWriter is an optional method for ObjectStorer, it enables directly writing
 // a packfile to storage.
 type PackfileWriter interface {
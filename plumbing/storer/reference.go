@@ -28,6 +28,35 @@ type ReferenceStorer interface {
 	PackRefs() error
 }
 
+// ReferenceTransactioner is an optional method for ReferenceStorer, it enables
+// updating several references atomically.
+type ReferenceTransactioner interface {
+	// BeginReferenceTransaction starts a reference transaction.
+	BeginReferenceTransaction() ReferenceTransaction
+}
+
+// ReferenceTransaction is an in-progress reference transaction. Updates are
+// queued with SetReference and RemoveReference, and are applied all together
+// by Commit. A transaction must end with a call to Commit or Abort.
+type ReferenceTransaction interface {
+	// SetReference queues the update of the reference `new`. If `old` is
+	// not nil, the current value of the reference must match it when the
+	// transaction is prepared. An `old` hash reference pointing to
+	// plumbing.ZeroHash requires the reference to not exist.
+	SetReference(new, old *plumbing.Reference) error
+	// RemoveReference queues the deletion of the named reference, with the
+	// same `old` semantics as SetReference.
+	RemoveReference(n plumbing.ReferenceName, old *plumbing.Reference) error
+	// Prepare locks and checks every queued reference. If it fails, no
+	// reference is modified and the transaction is aborted.
+	Prepare() error
+	// Commit applies every queued update, calling Prepare first if needed.
+	Commit() error
+	// Abort discards the queued updates and releases any lock held. It is a
+	// no-op on a transaction already committed or aborted.
+	Abort() error
+}
+
 // ReferenceIter is a generic closable interface for iterating over references.
 type ReferenceIter interface {
 	Next() (*plumbing.Reference, error)
@@ -21,11 +21,13 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	giturl "github.com/go-git/go-git/v5/internal/url"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
 var (
@@ -83,6 +85,45 @@ type UploadPackSession interface {
 	UploadPack(context.Context, *packp.UploadPackRequest) (*packp.UploadPackResponse, error)
 }
 
+// Negotiator chooses the commits sent as haves during the negotiation of the
+// common commits of a fetch with the server.
+type Negotiator interface {
+	// Next returns the next commit to send as have, or io.EOF if there's no
+	// commit left to send.
+	Next() (plumbing.Hash, error)
+	// Ack marks a commit acknowledged as common by the server, returning if
+	// it was already known to be common.
+	Ack(plumbing.Hash) (bool, error)
+}
+
+// NegotiatingUploadPackSession is an UploadPackSession able to negotiate the
+// haves of the request in several rounds, as git-fetch-pack does, instead of
+// sending them all at once.
+type NegotiatingUploadPackSession interface {
+	UploadPackSession
+	// NegotiatePack is as UploadPack, but the haves of the request are given
+	// by the negotiator, round by round, while the server doesn't know of
+	// enough common commits. The request must have the multi_ack_detailed
+	// capability.
+	NegotiatePack(context.Context, *packp.UploadPackRequest, Negotiator) (*packp.UploadPackResponse, error)
+}
+
+// ObjectFetcherSession is an UploadPackSession which may fetch the objects
+// itself, straight into a storer, instead of receiving them in a packfile, as
+// it's done with the servers only supporting the dumb HTTP protocol.
+type ObjectFetcherSession interface {
+	UploadPackSession
+	// FetchesObjects returns if the objects must be fetched with
+	// FetchObjects instead of UploadPack. It's only known once the
+	// references are advertised.
+	FetchesObjects() bool
+	// FetchObjects fetches into the storer the objects reachable from the
+	// wants of the request. The haves of the request are the local
+	// commits whose history is complete in the storer, the walk stops at
+	// them.
+	FetchObjects(context.Context, *packp.UploadPackRequest, storer.Storer) error
+}
+
 // ReceivePackSession represents a git-receive-pack session.
 // A git-receive-pack session has two steps: reference discovery
 // (AdvertisedReferences) and receiving pack (ReceivePack).
@@ -116,6 +157,89 @@ type Endpoint struct {
 	InsecureSkipTLS bool
 	// CaBundle specify additional ca bundle with system cert pool
 	CaBundle []byte
+	// ClientCert and ClientKey are the PEM encoded certificate and private
+	// key to authenticate with, if protocol is https
+	ClientCert []byte
+	ClientKey  []byte
+	// Proxy provides info required for connecting to a proxy.
+	Proxy ProxyOptions
+	// HTTP provides the options of the HTTP(S) requests.
+	HTTP HTTPOptions
+}
+
+// HTTPOptions are the options of the HTTP(S) requests, as the http.* config
+// of git.
+type HTTPOptions struct {
+	// ExtraHeaders are the headers added to the requests, as "Name: value".
+	ExtraHeaders []string
+	// Version is the HTTP version to use, "HTTP/1.1" or "HTTP/2". The
+	// default of the client is used if empty.
+	Version string
+	// PostBuffer is the maximum size of the requests sent with their length,
+	// the larger ones being sent with the chunked transfer encoding. The
+	// requests are always sent with their length if zero.
+	PostBuffer int
+	// LowSpeedLimit and LowSpeedTime abort the requests transferring less
+	// than LowSpeedLimit bytes per second for LowSpeedTime, if both are set.
+	LowSpeedLimit int
+	LowSpeedTime  time.Duration
+}
+
+// ProxyOptions are the options of the proxy to connect through: an HTTP(S)
+// proxy, reached with CONNECT for the protocols other than HTTP, or a SOCKS5
+// one.
+type ProxyOptions struct {
+	// URL is the URL of the proxy, as "http://proxy:3128" or
+	// "socks5://proxy:1080". No proxy is used if empty.
+	URL string
+	// Username and Password are the credentials of the proxy, overriding the
+	// ones of the URL, if any.
+	Username string
+	Password string
+}
+
+// Validate validates the proxy URL, if any.
+func (o *ProxyOptions) Validate() error {
+	if o.URL == "" {
+		return nil
+	}
+
+	_, err := o.FullURL()
+	return err
+}
+
+// FullURL returns the URL of the proxy, with the credentials. The scheme is
+// "http" if the URL doesn't have one, as for curl.
+func (o *ProxyOptions) FullURL() (*url.URL, error) {
+	raw := o.URL
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: %s", o.URL)
+	}
+
+	if o.Username != "" {
+		if o.Password != "" {
+			u.User = url.UserPassword(o.Username, o.Password)
+		} else {
+			u.User = url.User(o.Username)
+		}
+	}
+
+	return u, nil
 }
 
 var defaultPorts = map[string]int{
@@ -269,7 +393,6 @@ func parseFile(endpoint string) (*Endpoint, bool) {
 // implementation
 var UnsupportedCapabilities = []capability.Capability{
 	capability.MultiACK,
-	capability.MultiACKDetailed,
 	capability.ThinPack,
 }
 
@@ -0,0 +1,115 @@
+package credential
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheTimeout is the default time a credential is kept by a
+// CacheHelper, as for git-credential-cache.
+const DefaultCacheTimeout = 900 * time.Second
+
+// defaultCache is the cache shared by the helpers of the config.
+var defaultCache = &cache{}
+
+// CacheHelper is the equivalent of git-credential-cache: it keeps the
+// credentials in memory for a while. Unlike it, the credentials are only
+// kept by the process.
+type CacheHelper struct {
+	// Timeout is the time a credential is kept after being stored.
+	Timeout time.Duration
+
+	c *cache
+}
+
+// NewCacheHelper returns a CacheHelper keeping the credentials for the given
+// time, DefaultCacheTimeout if zero.
+func NewCacheHelper(timeout time.Duration) *CacheHelper {
+	return newCacheHelper(&cache{}, timeout)
+}
+
+func newCacheHelper(c *cache, timeout time.Duration) *CacheHelper {
+	if timeout == 0 {
+		timeout = DefaultCacheTimeout
+	}
+
+	return &CacheHelper{Timeout: timeout, c: c}
+}
+
+// Get returns the most recent unexpired credential matching q.
+func (h *CacheHelper) Get(q *Credential) (*Credential, bool, error) {
+	h.c.Lock()
+	defer h.c.Unlock()
+
+	h.c.expire()
+	for i := len(h.c.entries) - 1; i >= 0; i-- {
+		if e := h.c.entries[i]; e.c.Matches(q) {
+			c := e.c
+			return &c, false, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// Store keeps the credential until the timeout, replacing the ones it
+// matches.
+func (h *CacheHelper) Store(c *Credential) error {
+	if !c.IsComplete() {
+		return nil
+	}
+
+	h.c.Lock()
+	defer h.c.Unlock()
+
+	h.c.remove(c)
+	h.c.entries = append(h.c.entries, cacheEntry{
+		c:       *c,
+		expires: time.Now().Add(h.Timeout),
+	})
+
+	return nil
+}
+
+// Erase removes the credentials matching q.
+func (h *CacheHelper) Erase(q *Credential) error {
+	h.c.Lock()
+	defer h.c.Unlock()
+
+	h.c.remove(q)
+	return nil
+}
+
+type cache struct {
+	sync.Mutex
+	entries []cacheEntry
+}
+
+type cacheEntry struct {
+	c       Credential
+	expires time.Time
+}
+
+func (c *cache) remove(q *Credential) {
+	var kept []cacheEntry
+	for _, e := range c.entries {
+		if !e.c.Matches(q) {
+			kept = append(kept, e)
+		}
+	}
+
+	c.entries = kept
+}
+
+func (c *cache) expire() {
+	now := time.Now()
+
+	var kept []cacheEntry
+	for _, e := range c.entries {
+		if now.Before(e.expires) {
+			kept = append(kept, e)
+		}
+	}
+
+	c.entries = kept
+}
@@ -0,0 +1,52 @@
+package credential
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type CacheHelperSuite struct{}
+
+var _ = Suite(&CacheHelperSuite{})
+
+func (s *CacheHelperSuite) TestStoreGetErase(c *C) {
+	h := NewCacheHelper(0)
+	c.Assert(h.Timeout, Equals, DefaultCacheTimeout)
+
+	q := &Credential{Protocol: "https", Host: "example.com"}
+	cred, _, err := h.Get(q)
+	c.Assert(err, IsNil)
+	c.Assert(cred, IsNil)
+
+	err = h.Store(&Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "old"})
+	c.Assert(err, IsNil)
+	err = h.Store(&Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "new"})
+	c.Assert(err, IsNil)
+
+	cred, quit, err := h.Get(q)
+	c.Assert(err, IsNil)
+	c.Assert(quit, Equals, false)
+	c.Assert(cred.Password, Equals, "new")
+	c.Assert(h.c.entries, HasLen, 1)
+
+	err = h.Erase(q)
+	c.Assert(err, IsNil)
+
+	cred, _, err = h.Get(q)
+	c.Assert(err, IsNil)
+	c.Assert(cred, IsNil)
+}
+
+func (s *CacheHelperSuite) TestExpire(c *C) {
+	h := NewCacheHelper(time.Millisecond)
+
+	err := h.Store(&Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "pass"})
+	c.Assert(err, IsNil)
+
+	time.Sleep(10 * time.Millisecond)
+
+	cred, _, err := h.Get(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, IsNil)
+	c.Assert(cred, IsNil)
+}
@@ -0,0 +1,180 @@
+// Package credential implements the git credential helpers, which provide the
+// username and password of the servers requiring them.
+//
+// The helpers of the credential.helper and credential.<url>.helper config
+// are used, as git does: the external ones through the git credential helper
+// protocol, and the "store" and "cache" ones by their built-in equivalents.
+//
+// See: https://git-scm.com/docs/gitcredentials
+package credential
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// ErrInvalidCredential is returned when decoding an invalid credential.
+var ErrInvalidCredential = errors.New("invalid credential")
+
+// Credential is a credential, as exchanged with the helpers: the attributes
+// of the URL it's used for, and the username and password for it. The empty
+// attributes are unknown.
+type Credential struct {
+	// Protocol is the protocol of the URL, as https.
+	Protocol string
+	// Host is the host of the URL, with its port if any.
+	Host string
+	// Path is the path of the URL, without the leading slash. It's only
+	// set if credential.useHttpPath is.
+	Path string
+	// Username is the username.
+	Username string
+	// Password is the password.
+	Password string
+}
+
+// FromURL returns the credential of a URL: its protocol, host and username
+// and password if any. The path is only set if withPath is.
+func FromURL(rawURL string, withPath bool) (*Credential, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return nil, fmt.Errorf("invalid credential URL: %s", rawURL)
+	}
+
+	c := &Credential{
+		Protocol: u.Scheme,
+		Host:     u.Host,
+	}
+
+	if withPath {
+		c.Path = strings.TrimPrefix(u.Path, "/")
+	}
+
+	if u.User != nil {
+		c.Username = u.User.Username()
+		c.Password, _ = u.User.Password()
+	}
+
+	return c, nil
+}
+
+// URL returns the URL of the credential, with the username and password, as
+// stored by the store helper.
+func (c *Credential) URL() string {
+	u := &url.URL{
+		Scheme: c.Protocol,
+		Host:   c.Host,
+	}
+
+	if c.Path != "" {
+		u.Path = "/" + c.Path
+	}
+
+	switch {
+	case c.Password != "":
+		u.User = url.UserPassword(c.Username, c.Password)
+	case c.Username != "":
+		u.User = url.User(c.Username)
+	}
+
+	return u.String()
+}
+
+// IsComplete returns whether both the username and the password are known.
+func (c *Credential) IsComplete() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+// Matches returns whether the credential c can be used for the credential
+// query q: with the same protocol and host, and the same path and username
+// if q has any.
+func (c *Credential) Matches(q *Credential) bool {
+	if c.Protocol != q.Protocol || !strings.EqualFold(c.Host, q.Host) {
+		return false
+	}
+
+	if q.Path != "" && c.Path != q.Path {
+		return false
+	}
+
+	return q.Username == "" || c.Username == q.Username
+}
+
+// Encode writes the credential in the format of the credential helper
+// protocol: a "key=value" line for each known attribute.
+func (c *Credential) Encode(w io.Writer) error {
+	for _, attr := range []struct{ key, value string }{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+	} {
+		if attr.value == "" {
+			continue
+		}
+
+		if strings.ContainsAny(attr.value, "\n\x00") {
+			return fmt.Errorf("%w: %s contains a newline or NUL", ErrInvalidCredential, attr.key)
+		}
+
+		if _, err := fmt.Fprintf(w, "%s=%s\n", attr.key, attr.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Decode reads a credential in the format of the credential helper protocol,
+// until a blank line or the end of r, setting the attributes read. The
+// unknown attributes are ignored. quit is true if the helper asked to stop
+// looking for the credential.
+func (c *Credential) Decode(r io.Reader) (quit bool, err error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\r"))
+		if len(line) == 0 {
+			break
+		}
+
+		eq := bytes.IndexByte(line, '=')
+		if eq <= 0 {
+			return false, fmt.Errorf("%w: %q", ErrInvalidCredential, line)
+		}
+
+		value := string(line[eq+1:])
+		switch string(line[:eq]) {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "url":
+			u, err := FromURL(value, true)
+			if err != nil {
+				return false, err
+			}
+
+			*c = *u
+		case "quit":
+			quit = value == "1" || value == "true"
+		}
+	}
+
+	return quit, s.Err()
+}
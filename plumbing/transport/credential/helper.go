@@ -0,0 +1,100 @@
+package credential
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Helper is a credential helper.
+type Helper interface {
+	// Get returns the credential matching the query q, with the attributes
+	// it knows about, or nil if it has none. quit is true if no other helper
+	// must be asked.
+	Get(q *Credential) (c *Credential, quit bool, err error)
+	// Store stores a credential which was accepted by a server.
+	Store(c *Credential) error
+	// Erase erases the credentials matching the query q, after one was
+	// rejected by a server.
+	Erase(q *Credential) error
+}
+
+// ExternalHelper is an external credential helper, run through the shell as
+// git does for the value of credential.helper:
+//   - a name, as "osxkeychain", runs "git credential-<name>"
+//   - an absolute path runs the command at this path
+//   - a value starting with "!" runs the rest as a shell command
+//
+// In any case, the value may contain arguments, and the action is appended.
+type ExternalHelper struct {
+	Command string
+}
+
+// NewExternalHelper returns an ExternalHelper for a value of
+// credential.helper.
+func NewExternalHelper(command string) *ExternalHelper {
+	return &ExternalHelper{Command: command}
+}
+
+// Get runs the helper with the get action.
+func (h *ExternalHelper) Get(q *Credential) (*Credential, bool, error) {
+	out, err := h.run("get", q)
+	if err != nil {
+		return nil, false, err
+	}
+
+	c := *q
+	quit, err := c.Decode(bytes.NewReader(out))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if c == *q {
+		return nil, quit, nil
+	}
+
+	return &c, quit, nil
+}
+
+// Store runs the helper with the store action.
+func (h *ExternalHelper) Store(c *Credential) error {
+	_, err := h.run("store", c)
+	return err
+}
+
+// Erase runs the helper with the erase action.
+func (h *ExternalHelper) Erase(q *Credential) error {
+	_, err := h.run("erase", q)
+	return err
+}
+
+func (h *ExternalHelper) run(action string, c *Credential) ([]byte, error) {
+	command := h.Command
+	switch {
+	case strings.HasPrefix(command, "!"):
+		command = command[1:]
+	case filepath.IsAbs(command):
+	default:
+		command = "git credential-" + command
+	}
+
+	var stdin, stderr bytes.Buffer
+	if err := c.Encode(&stdin); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("sh", "-c", command+" "+action)
+	cmd.Stdin = &stdin
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("credential helper %q: %s: %s", h.Command, err,
+			strings.TrimSpace(stderr.String()),
+		)
+	}
+
+	return out, nil
+}
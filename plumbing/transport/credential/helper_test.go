@@ -0,0 +1,86 @@
+package credential
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+
+	. "gopkg.in/check.v1"
+)
+
+type ExternalHelperSuite struct {
+	dir string
+}
+
+var _ = Suite(&ExternalHelperSuite{})
+
+func (s *ExternalHelperSuite) SetUpSuite(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("the helper script requires a POSIX shell")
+	}
+}
+
+func (s *ExternalHelperSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+}
+
+// helper returns an ExternalHelper logging its actions and input to a file,
+// and answering the get action with the given output.
+func (s *ExternalHelperSuite) helper(c *C, output string) (*ExternalHelper, string) {
+	log := filepath.Join(s.dir, "log")
+	script := filepath.Join(s.dir, "helper.sh")
+	err := ioutil.WriteFile(script, []byte(`#!/bin/sh
+echo "action=$1" >> `+log+`
+cat >> `+log+`
+if [ "$1" = get ]; then
+	printf '`+output+`'
+fi
+`), 0700)
+	c.Assert(err, IsNil)
+
+	return NewExternalHelper("!sh " + script), log
+}
+
+func (s *ExternalHelperSuite) TestGet(c *C) {
+	h, log := s.helper(c, `username=user\npassword=pass\n`)
+
+	cred, quit, err := h.Get(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, IsNil)
+	c.Assert(quit, Equals, false)
+	c.Assert(cred, DeepEquals, &Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "pass"})
+
+	data, err := ioutil.ReadFile(log)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "action=get\nprotocol=https\nhost=example.com\n")
+}
+
+func (s *ExternalHelperSuite) TestGetNothing(c *C) {
+	h, _ := s.helper(c, `quit=true\n`)
+
+	cred, quit, err := h.Get(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, IsNil)
+	c.Assert(quit, Equals, true)
+	c.Assert(cred, IsNil)
+}
+
+func (s *ExternalHelperSuite) TestStoreAndErase(c *C) {
+	h, log := s.helper(c, "")
+
+	cred := &Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "pass"}
+	c.Assert(h.Store(cred), IsNil)
+	c.Assert(h.Erase(cred), IsNil)
+
+	data, err := ioutil.ReadFile(log)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, ""+
+		"action=store\nprotocol=https\nhost=example.com\nusername=user\npassword=pass\n"+
+		"action=erase\nprotocol=https\nhost=example.com\nusername=user\npassword=pass\n",
+	)
+}
+
+func (s *ExternalHelperSuite) TestFailure(c *C) {
+	h := NewExternalHelper("!echo oops >&2; exit 1;")
+
+	_, _, err := h.Get(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, ErrorMatches, `credential helper .*: exit status 1: oops`)
+}
@@ -0,0 +1,191 @@
+package credential
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+)
+
+// ErrCredentialNotFound is returned by Manager.Fill when no helper provides a
+// complete credential.
+var ErrCredentialNotFound = errors.New("credential not found")
+
+const (
+	credentialSection = "credential"
+	helperKey         = "helper"
+	usernameKey       = "username"
+	useHTTPPathKey    = "useHttpPath"
+)
+
+// Manager asks, approves and rejects the credentials of a URL with the
+// helpers, as git credential does.
+type Manager struct {
+	// Helpers are the helpers, asked in order.
+	Helpers []Helper
+	// Username is the default username, used if the URL has none.
+	Username string
+	// UseHTTPPath considers the path of the HTTP(S) URLs, the credentials
+	// being for a whole host otherwise.
+	UseHTTPPath bool
+}
+
+// NewManager returns the Manager of the credential.* and credential.<url>.*
+// options of the given configs, in increasing order of precedence, for a URL.
+//
+// As for git, the values of credential.helper are accumulated, an empty one
+// clearing the ones before. The "store" and "cache" helpers are the
+// StoreHelper and CacheHelper, with their --file and --timeout options, the
+// cache being shared by the managers. An error is returned if the files of a
+// StoreHelper can't be located.
+func NewManager(rawURL string, cfgs ...*format.Config) (*Manager, error) {
+	var opts format.Options
+	for _, cfg := range cfgs {
+		opts = append(opts, config.URLOptions(cfg.Section(credentialSection), rawURL)...)
+	}
+
+	m := &Manager{Username: opts.Get(usernameKey)}
+	m.UseHTTPPath, _ = strconv.ParseBool(opts.Get(useHTTPPathKey))
+
+	for _, value := range opts.GetAll(helperKey) {
+		if value == "" {
+			m.Helpers = nil
+			continue
+		}
+
+		h, err := newHelper(value)
+		if err != nil {
+			return nil, err
+		}
+
+		m.Helpers = append(m.Helpers, h)
+	}
+
+	return m, nil
+}
+
+func newHelper(value string) (Helper, error) {
+	fields := strings.Fields(value)
+	switch fields[0] {
+	case "store":
+		var path string
+		for i := 1; i < len(fields); i++ {
+			switch {
+			case strings.HasPrefix(fields[i], "--file="):
+				path = fields[i][len("--file="):]
+			case fields[i] == "--file" && i+1 < len(fields):
+				i++
+				path = fields[i]
+			}
+		}
+
+		return NewStoreHelper(path)
+	case "cache":
+		var timeout time.Duration
+		for i := 1; i < len(fields); i++ {
+			var seconds string
+			switch {
+			case strings.HasPrefix(fields[i], "--timeout="):
+				seconds = fields[i][len("--timeout="):]
+			case fields[i] == "--timeout" && i+1 < len(fields):
+				i++
+				seconds = fields[i]
+			}
+
+			if n, err := strconv.Atoi(seconds); err == nil {
+				timeout = time.Duration(n) * time.Second
+			}
+		}
+
+		return newCacheHelper(defaultCache, timeout), nil
+	}
+
+	return NewExternalHelper(value), nil
+}
+
+// Query returns the credential to ask the helpers for a URL.
+func (m *Manager) Query(rawURL string) (*Credential, error) {
+	q, err := FromURL(rawURL, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.UseHTTPPath || (q.Protocol != "http" && q.Protocol != "https") {
+		if q, err = FromURL(rawURL, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if q.Username == "" {
+		q.Username = m.Username
+	}
+
+	return q, nil
+}
+
+// Fill asks the helpers, in order, for the missing attributes of the query q
+// until its username and password are known, returning the complete
+// credential. ErrCredentialNotFound is returned if none provides it, unless a
+// helper failed, its error being returned.
+func (m *Manager) Fill(q *Credential) (*Credential, error) {
+	c := *q
+
+	var firstErr error
+	for _, h := range m.Helpers {
+		if c.IsComplete() {
+			break
+		}
+
+		got, quit, err := h.Get(&c)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		if got != nil {
+			c = *got
+		}
+
+		if quit {
+			break
+		}
+	}
+
+	if c.IsComplete() {
+		return &c, nil
+	}
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return nil, ErrCredentialNotFound
+}
+
+// Approve stores a credential accepted by a server with all the helpers.
+func (m *Manager) Approve(c *Credential) error {
+	for _, h := range m.Helpers {
+		if err := h.Store(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reject erases a credential rejected by a server from all the helpers.
+func (m *Manager) Reject(c *Credential) error {
+	for _, h := range m.Helpers {
+		if err := h.Erase(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package credential
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+
+	. "gopkg.in/check.v1"
+)
+
+type ManagerSuite struct{}
+
+var _ = Suite(&ManagerSuite{})
+
+func (s *ManagerSuite) TestNewManager(c *C) {
+	path := filepath.Join(c.MkDir(), "credentials")
+
+	global := format.New()
+	global.Section("credential").AddOption("helper", "osxkeychain")
+	global.Section("credential").AddOption("username", "default")
+
+	local := format.New()
+	local.Section("credential").AddOption("helper", "")
+	local.Section("credential").AddOption("helper", "store --file="+path)
+	local.Section("credential").Subsection("https://example.com").AddOption("helper", "cache --timeout 60")
+	local.Section("credential").Subsection("https://example.com").AddOption("useHttpPath", "true")
+	local.Section("credential").Subsection("https://example.org").AddOption("helper", "/usr/bin/helper --flag")
+
+	m, err := NewManager("https://example.com/repo.git", global, local)
+	c.Assert(err, IsNil)
+	c.Assert(m.Username, Equals, "default")
+	c.Assert(m.UseHTTPPath, Equals, true)
+	c.Assert(m.Helpers, HasLen, 2)
+	c.Assert(m.Helpers[0], DeepEquals, &StoreHelper{Paths: []string{path}})
+	c.Assert(m.Helpers[1].(*CacheHelper).Timeout, Equals, 60*time.Second)
+	c.Assert(m.Helpers[1].(*CacheHelper).c, Equals, defaultCache)
+
+	m, err = NewManager("https://example.org/repo.git", global, local)
+	c.Assert(err, IsNil)
+	c.Assert(m.UseHTTPPath, Equals, false)
+	c.Assert(m.Helpers, HasLen, 2)
+	c.Assert(m.Helpers[1], DeepEquals, NewExternalHelper("/usr/bin/helper --flag"))
+}
+
+func (s *ManagerSuite) TestNewManagerStoreError(c *C) {
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	c.Assert(os.Unsetenv("HOME"), IsNil)
+
+	cfg := format.New()
+	cfg.Section("credential").AddOption("helper", "store")
+
+	_, err := NewManager("https://example.com/repo.git", cfg)
+	c.Assert(err, NotNil)
+}
+
+func (s *ManagerSuite) TestQuery(c *C) {
+	m := &Manager{Username: "default"}
+
+	q, err := m.Query("https://example.com/repo.git")
+	c.Assert(err, IsNil)
+	c.Assert(q, DeepEquals, &Credential{Protocol: "https", Host: "example.com", Username: "default"})
+
+	q, err = m.Query("https://user@example.com/repo.git")
+	c.Assert(err, IsNil)
+	c.Assert(q.Username, Equals, "user")
+
+	m.UseHTTPPath = true
+	q, err = m.Query("https://example.com/repo.git")
+	c.Assert(err, IsNil)
+	c.Assert(q.Path, Equals, "repo.git")
+}
+
+func (s *ManagerSuite) TestFill(c *C) {
+	first := NewCacheHelper(0)
+	second := NewCacheHelper(0)
+	err := first.Store(&Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "first"})
+	c.Assert(err, IsNil)
+	err = second.Store(&Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "second"})
+	c.Assert(err, IsNil)
+	err = second.Store(&Credential{Protocol: "https", Host: "example.org", Username: "user", Password: "second"})
+	c.Assert(err, IsNil)
+
+	m := &Manager{Helpers: []Helper{first, second}}
+
+	cred, err := m.Fill(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, IsNil)
+	c.Assert(cred.Password, Equals, "first")
+
+	cred, err = m.Fill(&Credential{Protocol: "https", Host: "example.org"})
+	c.Assert(err, IsNil)
+	c.Assert(cred.Password, Equals, "second")
+
+	_, err = m.Fill(&Credential{Protocol: "https", Host: "example.net"})
+	c.Assert(err, Equals, ErrCredentialNotFound)
+}
+
+func (s *ManagerSuite) TestFillQuit(c *C) {
+	helper := NewCacheHelper(0)
+	err := helper.Store(&Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "pass"})
+	c.Assert(err, IsNil)
+
+	m := &Manager{Helpers: []Helper{&fixedHelper{quit: true}, helper}}
+
+	_, err = m.Fill(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, Equals, ErrCredentialNotFound)
+}
+
+func (s *ManagerSuite) TestFillError(c *C) {
+	m := &Manager{Helpers: []Helper{&fixedHelper{err: errors.New("foo")}}}
+
+	_, err := m.Fill(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, ErrorMatches, "foo")
+
+	helper := NewCacheHelper(0)
+	err = helper.Store(&Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "pass"})
+	c.Assert(err, IsNil)
+
+	m.Helpers = append(m.Helpers, helper)
+	cred, err := m.Fill(&Credential{Protocol: "https", Host: "example.com"})
+	c.Assert(err, IsNil)
+	c.Assert(cred.Password, Equals, "pass")
+}
+
+func (s *ManagerSuite) TestApproveAndReject(c *C) {
+	first := NewCacheHelper(0)
+	second := NewCacheHelper(0)
+	m := &Manager{Helpers: []Helper{first, second}}
+
+	cred := &Credential{Protocol: "https", Host: "example.com", Username: "user", Password: "pass"}
+	c.Assert(m.Approve(cred), IsNil)
+	c.Assert(first.c.entries, HasLen, 1)
+	c.Assert(second.c.entries, HasLen, 1)
+
+	c.Assert(m.Reject(cred), IsNil)
+	c.Assert(first.c.entries, HasLen, 0)
+	c.Assert(second.c.entries, HasLen, 0)
+}
+
+type fixedHelper struct {
+	c    *Credential
+	quit bool
+	err  error
+}
+
+func (h *fixedHelper) Get(*Credential) (*Credential, bool, error) { return h.c, h.quit, h.err }
+func (h *fixedHelper) Store(*Credential) error                    { return nil }
+func (h *fixedHelper) Erase(*Credential) error                    { return nil }
@@ -11,15 +11,22 @@ import (
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 	"golang.org/x/sys/execabs"
 )
 
-// DefaultClient is the default local client.
-var DefaultClient = NewClient(
-	transport.UploadPackServiceName,
-	transport.ReceivePackServiceName,
-)
+// DefaultClient is the default local client. It serves the sessions
+// in-process with the repositories of DefaultLoader, so no git binary is
+// needed, and runs their hook scripts as git receive-pack does. NewClient
+// returns a client running the git binaries instead.
+var DefaultClient = server.NewClientWithOptions(DefaultLoader, &server.Options{
+	HookScripts: true,
+})
+
+// DefaultLoader loads the repositories of the local file system, given the
+// path of their git directory or worktree.
+var DefaultLoader = server.DefaultLoader
 
 type runner struct {
 	UploadPackBin  string
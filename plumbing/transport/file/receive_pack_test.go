@@ -1,8 +1,19 @@
 package file
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
-
+	"path/filepath"
+	"runtime"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
 	"github.com/go-git/go-git/v5/plumbing/transport/test"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -18,7 +29,10 @@ var _ = Suite(&ReceivePackSuite{})
 
 func (s *ReceivePackSuite) SetUpSuite(c *C) {
 	s.CommonSuite.SetUpSuite(c)
-	s.ReceivePackSuite.Client = DefaultClient
+	s.ReceivePackSuite.Client = NewClient(
+		transport.UploadPackServiceName,
+		transport.ReceivePackServiceName,
+	)
 }
 
 func (s *ReceivePackSuite) SetUpTest(c *C) {
@@ -73,3 +87,81 @@ func (s *ReceivePackSuite) TestNonExistentCommand(c *C) {
 	c.Assert(err, ErrorMatches, ".*(no such file or directory.*|.*file does not exist)*.")
 	c.Assert(session, IsNil)
 }
+
+// InProcessReceivePackSuite runs the receive-pack tests with DefaultClient,
+// serving the sessions in-process.
+type InProcessReceivePackSuite struct {
+	fixtures.Suite
+	test.ReceivePackSuite
+}
+
+var _ = Suite(&InProcessReceivePackSuite{})
+
+func (s *InProcessReceivePackSuite) SetUpSuite(c *C) {
+	s.ReceivePackSuite.Client = DefaultClient
+}
+
+func (s *InProcessReceivePackSuite) SetUpTest(c *C) {
+	s.Endpoint = prepareRepo(c, fixtures.Basic().One().DotGit().Root())
+	s.EmptyEndpoint = prepareRepo(c, fixtures.ByTag("empty").One().DotGit().Root())
+	s.NonExistentEndpoint = prepareRepo(c, "/non-existent")
+}
+
+func (s *InProcessReceivePackSuite) TearDownTest(c *C) {
+	s.Suite.TearDownSuite(c)
+}
+
+// Overwritten, server returns error earlier.
+func (s *InProcessReceivePackSuite) TestAdvertisedReferencesNotExists(c *C) {
+	r, err := s.Client.NewReceivePackSession(s.NonExistentEndpoint, s.EmptyAuth)
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(r, IsNil)
+}
+
+func (s *InProcessReceivePackSuite) TestHooks(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	hooks := filepath.Join(s.Endpoint.Path, "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+
+	script := "#!/bin/sh\nprintf '%s '\ncat\n"
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "pre-receive"),
+		[]byte(fmt.Sprintf(script, "pre-receive")), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "update"),
+		[]byte("#!/bin/sh\ntest \"$1\" != refs/heads/rejected\n"), 0755), IsNil)
+
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	head := plumbing.NewHash(fixture.Head)
+
+	var progress bytes.Buffer
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Progress = &progress
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/accepted", Old: plumbing.ZeroHash, New: head},
+		{Name: "refs/heads/rejected", Old: plumbing.ZeroHash, New: head},
+	}
+
+	r, err := s.Client.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, server.ErrHookDeclined)
+	c.Assert(report, NotNil)
+	c.Assert(progress.String(), Equals, fmt.Sprintf(
+		"pre-receive %[1]s %[2]s refs/heads/accepted\n%[1]s %[2]s refs/heads/rejected\n",
+		plumbing.ZeroHash, head,
+	))
+
+	for _, cs := range report.CommandStatuses {
+		switch cs.ReferenceName {
+		case "refs/heads/accepted":
+			c.Assert(cs.Status, Equals, "ok")
+		case "refs/heads/rejected":
+			c.Assert(cs.Status, Equals, server.ErrHookDeclined.Error())
+		}
+	}
+}
@@ -46,6 +46,23 @@ func ServeReceivePack(path string) error {
 	return common.ServeReceivePack(srvCmd, s)
 }
 
+// ServeUploadArchive serves a git-upload-archive request using standard
+// output and input. This is meant to be used when implementing a
+// git-upload-archive command.
+func ServeUploadArchive(path string) error {
+	ep, err := transport.NewEndpoint(path)
+	if err != nil {
+		return err
+	}
+
+	s, err := server.DefaultLoader.Load(ep)
+	if err != nil {
+		return fmt.Errorf("error loading repository: %s", err)
+	}
+
+	return server.UploadArchive(s, srvCmd.Stdin, srvCmd.Stdout)
+}
+
 var srvCmd = common.ServerCommand{
 	Stdin:  os.Stdin,
 	Stdout: ioutil.WriteNopCloser(os.Stdout),
@@ -1,7 +1,9 @@
 package file
 
 import (
+	"io/ioutil"
 	"os"
+	"os/exec"
 
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/test"
@@ -20,7 +22,10 @@ var _ = Suite(&UploadPackSuite{})
 func (s *UploadPackSuite) SetUpSuite(c *C) {
 	s.CommonSuite.SetUpSuite(c)
 
-	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.Client = NewClient(
+		transport.UploadPackServiceName,
+		transport.ReceivePackServiceName,
+	)
 
 	fixture := fixtures.Basic().One()
 	path := fixture.DotGit().Root()
@@ -82,3 +87,64 @@ func (s *UploadPackSuite) TestUploadPackWithContextOnRead(c *C) {
 	// canceled context when the packfile is being read.
 	c.Skip("UploadPack has a race condition when we Close the session")
 }
+
+// InProcessUploadPackSuite runs the upload-pack tests with DefaultClient,
+// serving the sessions in-process.
+type InProcessUploadPackSuite struct {
+	fixtures.Suite
+	test.UploadPackSuite
+}
+
+var _ = Suite(&InProcessUploadPackSuite{})
+
+func (s *InProcessUploadPackSuite) SetUpSuite(c *C) {
+	s.UploadPackSuite.Client = DefaultClient
+
+	var err error
+	s.Endpoint, err = transport.NewEndpoint(fixtures.Basic().One().DotGit().Root())
+	c.Assert(err, IsNil)
+
+	s.EmptyEndpoint, err = transport.NewEndpoint(fixtures.ByTag("empty").One().DotGit().Root())
+	c.Assert(err, IsNil)
+
+	s.NonExistentEndpoint, err = transport.NewEndpoint("non-existent")
+	c.Assert(err, IsNil)
+}
+
+// Overwritten, server returns error earlier.
+func (s *InProcessUploadPackSuite) TestAdvertisedReferencesNotExists(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.NonExistentEndpoint, s.EmptyAuth)
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(r, IsNil)
+}
+
+func (s *InProcessUploadPackSuite) TestUploadPackWithContext(c *C) {
+	c.Skip("UploadPack cannot be canceled on server")
+}
+
+func (s *InProcessUploadPackSuite) TestUploadPackWithContextOnRead(c *C) {
+	c.Skip("UploadPack cannot be canceled on server")
+}
+
+func (s *InProcessUploadPackSuite) TestWorktreePath(c *C) {
+	dir, err := ioutil.TempDir("", "")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	cmd := exec.Command("git", "clone", "-q", s.Endpoint.Path, dir)
+	if err := cmd.Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	ep, err := transport.NewEndpoint(dir)
+	c.Assert(err, IsNil)
+
+	r, err := s.Client.NewUploadPackSession(ep, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Head, NotNil)
+	c.Assert(ar.Head.String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+}
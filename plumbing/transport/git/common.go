@@ -2,6 +2,7 @@
 package git
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
@@ -10,6 +11,8 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
 	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"golang.org/x/net/proxy"
 )
 
 // DefaultClient is the default git client.
@@ -53,7 +56,17 @@ func (c *command) connect() error {
 	}
 
 	var err error
-	c.conn, err = net.Dial("tcp", c.getHostWithPort())
+	if c.endpoint.Proxy.URL != "" {
+		var d proxy.ContextDialer
+		if d, err = common.NewProxyDialer(c.endpoint.Proxy); err != nil {
+			return err
+		}
+
+		c.conn, err = d.DialContext(context.Background(), "tcp", c.getHostWithPort())
+	} else {
+		c.conn, err = net.Dial("tcp", c.getHostWithPort())
+	}
+
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,507 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const (
+	// UploadArchiveServiceName is the name of the git-upload-archive service.
+	UploadArchiveServiceName = "git-upload-archive"
+
+	// ExportOKFile is the file a repository must contain to be served by a
+	// Daemon, unless Daemon.ExportAll is set.
+	ExportOKFile = "git-daemon-export-ok"
+
+	// DefaultInitTimeout is the default time a Daemon waits for the request
+	// of a client.
+	DefaultInitTimeout = 10 * time.Second
+)
+
+var (
+	// ErrDaemonClosed is returned by Daemon.Serve and Daemon.ListenAndServe
+	// after a call to Daemon.Shutdown or Daemon.Close.
+	ErrDaemonClosed = errors.New("git: daemon closed")
+	// ErrInvalidRequest is returned when the request of a client is not a
+	// valid git:// request.
+	ErrInvalidRequest = errors.New("git: invalid request")
+	// ErrDaemonNoLoader is returned by Daemon.Serve and
+	// Daemon.ListenAndServe when the daemon has no Loader.
+	ErrDaemonNoLoader = errors.New("git: daemon without loader")
+
+	errNotExported       = errors.New("access denied or repository not exported")
+	errServiceNotEnabled = errors.New("service not enabled")
+)
+
+// Daemon is a server of the git:// protocol, compatible with git daemon. The
+// repositories are loaded by a server.Loader from the path of the requests
+// and only the ones with an ExportOKFile are served, unless ExportAll is set.
+//
+// As git daemon does, only git-upload-pack is enabled by default, the other
+// services must be enabled explicitly.
+type Daemon struct {
+	// Loader loads the repositories of the requests. It is required, and
+	// should only give access to the repositories meant to be served, as the
+	// base path of git daemon does: server.NewFilesystemLoader resolves every
+	// path, absolute ones included, under its base filesystem. Using
+	// server.DefaultLoader serves every repository of the host.
+	Loader server.Loader
+	// ServerOptions are the options of the git server, as its hooks.
+	ServerOptions *server.Options
+	// ExportAll serves the repositories without an ExportOKFile. The
+	// repositories not stored in a filesystem are only served if set.
+	ExportAll bool
+	// ReceivePack enables the git-receive-pack service, allowing anonymous
+	// pushes.
+	ReceivePack bool
+	// UploadArchive enables the git-upload-archive service.
+	UploadArchive bool
+	// MaxConnections is the maximum number of connections served at the same
+	// time, the others not being accepted until one is closed. Unlimited if
+	// zero.
+	MaxConnections int
+	// InitTimeout is the time to wait for the request of a client,
+	// DefaultInitTimeout if zero.
+	InitTimeout time.Duration
+	// Timeout is the time a connection can wait for the client, reading or
+	// writing, once the request is received, as the --timeout option of git
+	// daemon. Unlimited if zero.
+	Timeout time.Duration
+	// ErrorLog is the logger of the errors serving the connections, they
+	// aren't logged if nil.
+	ErrorLog *log.Logger
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	inShutdown bool
+	wg         sync.WaitGroup
+
+	// slots limits the connections to MaxConnections, closing is closed on
+	// shutdown.
+	slots   chan struct{}
+	closing chan struct{}
+}
+
+// ListenAndServe listens on the TCP address addr, ":9418" if empty, and
+// serves the connections. It always returns a non-nil error, ErrDaemonClosed
+// after a call to Shutdown or Close.
+func (d *Daemon) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", DefaultPort)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return d.Serve(l)
+}
+
+// Serve accepts the connections of the listener l, serving each of them in
+// its own goroutine. It always returns a non-nil error, ErrDaemonClosed after
+// a call to Shutdown or Close, ErrDaemonNoLoader without a Loader. The
+// listener is closed on return.
+func (d *Daemon) Serve(l net.Listener) error {
+	if d.Loader == nil {
+		_ = l.Close()
+		return ErrDaemonNoLoader
+	}
+
+	if !d.trackListener(l, true) {
+		_ = l.Close()
+		return ErrDaemonClosed
+	}
+
+	defer d.trackListener(l, false)
+	defer l.Close()
+
+	for {
+		if !d.acquireSlot() {
+			return ErrDaemonClosed
+		}
+
+		conn, err := l.Accept()
+		if err != nil {
+			d.releaseSlot()
+			if d.shuttingDown() {
+				return ErrDaemonClosed
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		if !d.trackConn(conn, true) {
+			d.releaseSlot()
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			defer d.releaseSlot()
+			defer d.trackConn(conn, false)
+			defer conn.Close()
+
+			if err := d.serveConn(conn); err != nil {
+				d.logf("%s: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Shutdown stops the daemon gracefully: it closes the listeners and waits
+// for the connections being served to finish. If ctx is done before, the
+// connections are closed and its error is returned.
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	d.closeListeners()
+
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		d.closeConns()
+		return ctx.Err()
+	}
+}
+
+// Close stops the daemon immediately, closing the listeners and the
+// connections being served.
+func (d *Daemon) Close() error {
+	d.closeListeners()
+	d.closeConns()
+	return nil
+}
+
+func (d *Daemon) trackListener(l net.Listener, add bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.listeners == nil {
+		d.listeners = make(map[net.Listener]struct{})
+		d.closing = make(chan struct{})
+		if d.MaxConnections > 0 {
+			d.slots = make(chan struct{}, d.MaxConnections)
+		}
+	}
+
+	if !add {
+		delete(d.listeners, l)
+		return true
+	}
+
+	if d.inShutdown {
+		return false
+	}
+
+	d.listeners[l] = struct{}{}
+	return true
+}
+
+func (d *Daemon) trackConn(conn net.Conn, add bool) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.conns == nil {
+		d.conns = make(map[net.Conn]struct{})
+	}
+
+	if !add {
+		delete(d.conns, conn)
+		d.wg.Done()
+		return true
+	}
+
+	if d.inShutdown {
+		return false
+	}
+
+	d.conns[conn] = struct{}{}
+	d.wg.Add(1)
+	return true
+}
+
+// acquireSlot waits for a connection to be allowed by MaxConnections,
+// returning false if the daemon is shut down meanwhile.
+func (d *Daemon) acquireSlot() bool {
+	d.mu.Lock()
+	slots, closing := d.slots, d.closing
+	d.mu.Unlock()
+
+	if slots == nil {
+		return true
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-closing:
+		return false
+	}
+}
+
+func (d *Daemon) releaseSlot() {
+	if d.slots != nil {
+		<-d.slots
+	}
+}
+
+func (d *Daemon) shuttingDown() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inShutdown
+}
+
+func (d *Daemon) closeListeners() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.inShutdown && d.closing != nil {
+		close(d.closing)
+	}
+
+	d.inShutdown = true
+	for l := range d.listeners {
+		_ = l.Close()
+	}
+}
+
+func (d *Daemon) closeConns() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for conn := range d.conns {
+		_ = conn.Close()
+	}
+}
+
+func (d *Daemon) logf(format string, args ...interface{}) {
+	if d.ErrorLog != nil {
+		d.ErrorLog.Printf(format, args...)
+	}
+}
+
+func (d *Daemon) serveConn(conn net.Conn) error {
+	timeout := d.InitTimeout
+	if timeout == 0 {
+		timeout = DefaultInitTimeout
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	req, err := readDaemonRequest(conn)
+	if err != nil {
+		return err
+	}
+
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	var rw io.ReadWriter = conn
+	if d.Timeout > 0 {
+		rw = &timeoutConn{Conn: conn, timeout: d.Timeout}
+	}
+
+	st, err := d.load(req)
+	if err != nil {
+		// As git daemon does, the errors are sent to the client with the
+		// requested path, without telling whether the repository exists.
+		e := pktline.NewEncoder(rw)
+		_ = e.Encodef("ERR %s: %s", err, req.path)
+		return fmt.Errorf("%s %s: %s", req.service, req.path, err)
+	}
+
+	cmd := common.ServerCommand{
+		Stdin:  rw,
+		Stdout: ioutil.WriteNopCloser(rw),
+	}
+
+	ep := req.endpoint()
+	srv := server.NewServerWithOptions(server.MapLoader{ep.String(): st}, d.ServerOptions)
+	switch req.service {
+	case transport.UploadPackServiceName:
+		s, err := srv.NewUploadPackSession(ep, nil)
+		if err != nil {
+			return err
+		}
+
+		return common.ServeUploadPack(cmd, s)
+	case transport.ReceivePackServiceName:
+		s, err := srv.NewReceivePackSession(ep, nil)
+		if err != nil {
+			return err
+		}
+
+		return common.ServeReceivePack(cmd, s)
+	default:
+		return server.UploadArchive(st, rw, rw)
+	}
+}
+
+// timeoutConn is a connection whose reads and writes fail if they wait for
+// the client longer than timeout.
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Read(p)
+}
+
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+
+	return c.Conn.Write(p)
+}
+
+// load returns the repository of a request, if it's exported and the service
+// enabled.
+func (d *Daemon) load(req *daemonRequest) (storer.Storer, error) {
+	switch req.service {
+	case transport.UploadPackServiceName:
+	case transport.ReceivePackServiceName:
+		if !d.ReceivePack {
+			return nil, errServiceNotEnabled
+		}
+	case UploadArchiveServiceName:
+		if !d.UploadArchive {
+			return nil, errServiceNotEnabled
+		}
+	default:
+		return nil, errServiceNotEnabled
+	}
+
+	for _, elem := range strings.Split(req.path, "/") {
+		if elem == ".." {
+			return nil, errNotExported
+		}
+	}
+
+	st, err := d.Loader.Load(req.endpoint())
+	if err != nil {
+		if err != transport.ErrRepositoryNotFound {
+			d.logf("%s: %s", req.path, err)
+		}
+
+		return nil, errNotExported
+	}
+
+	if !d.ExportAll && !isExported(st) {
+		return nil, errNotExported
+	}
+
+	return st, nil
+}
+
+func isExported(st storer.Storer) bool {
+	type fsBased interface {
+		Filesystem() billy.Filesystem
+	}
+
+	fs, ok := st.(fsBased)
+	if !ok {
+		return false
+	}
+
+	_, err := fs.Filesystem().Stat(ExportOKFile)
+	return err == nil
+}
+
+// daemonRequest is the request sent by a client when connecting to a git://
+// server: "<service> <path>\0host=<host>\0", optionally followed by extra
+// parameters.
+type daemonRequest struct {
+	service string
+	path    string
+	host    string
+	port    int
+}
+
+func readDaemonRequest(r io.Reader) (*daemonRequest, error) {
+	s := pktline.NewScanner(r)
+	if !s.Scan() {
+		if err := s.Err(); err != nil {
+			return nil, err
+		}
+
+		return nil, ErrInvalidRequest
+	}
+
+	return parseDaemonRequest(s.Bytes())
+}
+
+func parseDaemonRequest(line []byte) (*daemonRequest, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	fields := bytes.Split(line, []byte{0})
+
+	sp := bytes.IndexByte(fields[0], ' ')
+	if sp <= 0 || sp == len(fields[0])-1 {
+		return nil, ErrInvalidRequest
+	}
+
+	req := &daemonRequest{
+		service: string(fields[0][:sp]),
+		path:    string(fields[0][sp+1:]),
+	}
+
+	for _, f := range fields[1:] {
+		if !bytes.HasPrefix(f, []byte("host=")) {
+			continue
+		}
+
+		req.host = string(f[len("host="):])
+		if host, port, err := net.SplitHostPort(req.host); err == nil {
+			req.host = host
+			req.port, _ = strconv.Atoi(port)
+		}
+	}
+
+	return req, nil
+}
+
+func (req *daemonRequest) endpoint() *transport.Endpoint {
+	return &transport.Endpoint{
+		Protocol: "git",
+		Host:     req.host,
+		Port:     req.port,
+		Path:     req.path,
+	}
+}
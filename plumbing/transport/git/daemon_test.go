@@ -0,0 +1,352 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/plumbing/transport/test"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+// BaseDaemonSuite runs a Daemon serving the repositories of a temporary
+// directory.
+type BaseDaemonSuite struct {
+	fixtures.Suite
+
+	base   string
+	addr   string
+	daemon *Daemon
+	served chan error
+}
+
+func (s *BaseDaemonSuite) SetUpTest(c *C) {
+	var err error
+	s.base, err = ioutil.TempDir("", "go-git-daemon")
+	c.Assert(err, IsNil)
+
+	s.startDaemon(c, &Daemon{})
+}
+
+func (s *BaseDaemonSuite) TearDownTest(c *C) {
+	c.Assert(s.daemon.Close(), IsNil)
+	c.Assert(<-s.served, Equals, ErrDaemonClosed)
+	c.Assert(os.RemoveAll(s.base), IsNil)
+	s.daemon = nil
+}
+
+func (s *BaseDaemonSuite) startDaemon(c *C, d *Daemon) {
+	if s.daemon != nil {
+		c.Assert(s.daemon.Close(), IsNil)
+		c.Assert(<-s.served, Equals, ErrDaemonClosed)
+	}
+
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	d.Loader = server.NewFilesystemLoader(osfs.New(s.base))
+	s.daemon = d
+	s.addr = l.Addr().String()
+	s.served = make(chan error, 1)
+	go func() { s.served <- d.Serve(l) }()
+}
+
+func (s *BaseDaemonSuite) newEndpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("git://%s/%s", s.addr, name))
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+func (s *BaseDaemonSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
+	fs := f.DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+	c.Assert(os.Rename(fs.Root(), filepath.Join(s.base, name)), IsNil)
+
+	return s.newEndpoint(c, name)
+}
+
+func (s *BaseDaemonSuite) exportRepository(c *C, name string) {
+	path := filepath.Join(s.base, name, ExportOKFile)
+	c.Assert(ioutil.WriteFile(path, nil, 0644), IsNil)
+}
+
+func (s *BaseDaemonSuite) advertisedReferences(ep *transport.Endpoint) error {
+	r, err := DefaultClient.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+	_, err = r.AdvertisedReferences()
+	return err
+}
+
+type DaemonSuite struct {
+	BaseDaemonSuite
+}
+
+var _ = Suite(&DaemonSuite{})
+
+func (s *DaemonSuite) TestExportOK(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+
+	s.exportRepository(c, "basic.git")
+	c.Assert(s.advertisedReferences(ep), IsNil)
+}
+
+func (s *DaemonSuite) TestExportAll(c *C) {
+	s.startDaemon(c, &Daemon{ExportAll: true})
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(ep), IsNil)
+	c.Assert(s.advertisedReferences(s.newEndpoint(c, "non-existent.git")),
+		Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *DaemonSuite) TestNoSuffix(c *C) {
+	s.startDaemon(c, &Daemon{ExportAll: true})
+
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(s.newEndpoint(c, "basic")), IsNil)
+}
+
+func (s *DaemonSuite) TestParentPath(c *C) {
+	s.startDaemon(c, &Daemon{ExportAll: true})
+
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(s.newEndpoint(c, "foo/../basic.git")),
+		Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *DaemonSuite) TestAbsolutePath(c *C) {
+	s.startDaemon(c, &Daemon{ExportAll: true})
+
+	// a repository outside the base path can't be requested by its absolute
+	// path, which is resolved under the base path
+	outside, err := ioutil.TempDir("", "go-git-daemon-outside")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(outside)
+
+	fs := fixtures.Basic().One().DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+	c.Assert(os.Rename(fs.Root(), filepath.Join(outside, "basic.git")), IsNil)
+
+	ep := s.newEndpoint(c, filepath.ToSlash(filepath.Join(outside, "basic.git"))[1:])
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+
+	// while the absolute path /basic.git is the one under the base path
+	ep = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(ep.Path, Equals, "/basic.git")
+	c.Assert(s.advertisedReferences(ep), IsNil)
+}
+
+func (s *DaemonSuite) TestNoLoader(c *C) {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	c.Assert((&Daemon{}).Serve(l), Equals, ErrDaemonNoLoader)
+}
+
+func (s *DaemonSuite) TestReceivePackNotEnabled(c *C) {
+	s.startDaemon(c, &Daemon{ExportAll: true})
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	r, err := DefaultClient.NewReceivePackSession(ep, nil)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, ErrorMatches, ".*service not enabled.*")
+}
+
+func (s *DaemonSuite) TestMaxConnections(c *C) {
+	s.startDaemon(c, &Daemon{ExportAll: true, MaxConnections: 1})
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	conn, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+
+	// The connection takes the only slot until it's closed, since it doesn't
+	// send any request.
+	done := make(chan error, 1)
+	go func() { done <- s.advertisedReferences(ep) }()
+
+	select {
+	case err := <-done:
+		c.Fatalf("connection served over the limit: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	c.Assert(conn.Close(), IsNil)
+	c.Assert(<-done, IsNil)
+}
+
+func (s *DaemonSuite) TestInitTimeout(c *C) {
+	s.startDaemon(c, &Daemon{InitTimeout: 10 * time.Millisecond})
+
+	conn, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	c.Assert(conn.SetReadDeadline(time.Now().Add(time.Second)), IsNil)
+	_, err = conn.Read(make([]byte, 1))
+	c.Assert(err, NotNil)
+	c.Assert(err, Not(FitsTypeOf), &net.OpError{})
+}
+
+func (s *DaemonSuite) TestTimeout(c *C) {
+	s.startDaemon(c, &Daemon{ExportAll: true, MaxConnections: 1, Timeout: 50 * time.Millisecond})
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	conn, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	e := pktline.NewEncoder(conn)
+	c.Assert(e.Encode([]byte("git-upload-pack /basic.git\x00host=localhost\x00")), IsNil)
+
+	// The client stalls after the advertised references, the connection is
+	// closed and its slot released.
+	c.Assert(conn.SetReadDeadline(time.Now().Add(time.Second)), IsNil)
+	_, err = io.Copy(ioutil.Discard, conn)
+	c.Assert(err, IsNil)
+
+	c.Assert(s.advertisedReferences(ep), IsNil)
+}
+
+func (s *DaemonSuite) TestShutdown(c *C) {
+	c.Assert(s.daemon.Shutdown(context.Background()), IsNil)
+	c.Assert(<-s.served, Equals, ErrDaemonClosed)
+
+	_, err := net.Dial("tcp", s.addr)
+	c.Assert(err, NotNil)
+
+	s.served <- ErrDaemonClosed
+}
+
+func (s *DaemonSuite) TestShutdownTimeout(c *C) {
+	conn, err := net.Dial("tcp", s.addr)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	c.Assert(s.daemon.Shutdown(ctx), Equals, context.DeadlineExceeded)
+	c.Assert(<-s.served, Equals, ErrDaemonClosed)
+
+	c.Assert(conn.SetReadDeadline(time.Now().Add(time.Second)), IsNil)
+	_, err = conn.Read(make([]byte, 1))
+	c.Assert(err, NotNil)
+	c.Assert(err, Not(FitsTypeOf), &net.OpError{})
+
+	s.served <- ErrDaemonClosed
+}
+
+func (s *DaemonSuite) TestGitLsRemote(c *C) {
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.exportRepository(c, "basic.git")
+
+	out, err := exec.Command("git", "ls-remote", ep.String(), "refs/heads/master").Output()
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5\trefs/heads/master\n")
+}
+
+func (s *DaemonSuite) TestProxy(c *C) {
+	proxy := test.NewHTTPProxy("", "")
+	defer proxy.Close()
+
+	s.startDaemon(c, &Daemon{ExportAll: true})
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	ep.Proxy = transport.ProxyOptions{URL: proxy.URL}
+
+	c.Assert(s.advertisedReferences(ep), IsNil)
+	c.Assert(proxy.Requests(), Equals, 1)
+}
+
+func (s *DaemonSuite) TestParseDaemonRequest(c *C) {
+	req, err := parseDaemonRequest([]byte("git-upload-pack /foo.git\x00host=example.com:1234\x00\x00version=2\x00"))
+	c.Assert(err, IsNil)
+	c.Assert(req, DeepEquals, &daemonRequest{
+		service: "git-upload-pack",
+		path:    "/foo.git",
+		host:    "example.com",
+		port:    1234,
+	})
+
+	req, err = parseDaemonRequest([]byte("git-receive-pack /foo.git\n"))
+	c.Assert(err, IsNil)
+	c.Assert(req, DeepEquals, &daemonRequest{
+		service: "git-receive-pack",
+		path:    "/foo.git",
+	})
+
+	for _, line := range []string{"", "git-upload-pack", "git-upload-pack ", " /foo.git"} {
+		_, err = parseDaemonRequest([]byte(line))
+		c.Assert(err, Equals, ErrInvalidRequest, Commentf("%q", line))
+	}
+}
+
+func (s *DaemonSuite) TestReadDaemonRequestInvalid(c *C) {
+	_, err := readDaemonRequest(strings.NewReader(string(pktline.FlushPkt)))
+	c.Assert(err, Equals, ErrInvalidRequest)
+}
+
+// DaemonUploadPackSuite runs the upload-pack tests against a Daemon.
+type DaemonUploadPackSuite struct {
+	test.UploadPackSuite
+	BaseDaemonSuite
+}
+
+var _ = Suite(&DaemonUploadPackSuite{})
+
+func (s *DaemonUploadPackSuite) SetUpTest(c *C) {
+	s.BaseDaemonSuite.SetUpTest(c)
+	s.startDaemon(c, &Daemon{ExportAll: true})
+
+	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+// DaemonReceivePackSuite runs the receive-pack tests against a Daemon.
+type DaemonReceivePackSuite struct {
+	test.ReceivePackSuite
+	BaseDaemonSuite
+}
+
+var _ = Suite(&DaemonReceivePackSuite{})
+
+func (s *DaemonReceivePackSuite) SetUpTest(c *C) {
+	s.BaseDaemonSuite.SetUpTest(c)
+	// Unless MaxConnections is limited to 1, a receive-pack without
+	// report-status might not be seen by a subsequent operation.
+	s.startDaemon(c, &Daemon{ExportAll: true, ReceivePack: true, MaxConnections: 1})
+
+	s.ReceivePackSuite.Client = DefaultClient
+	s.ReceivePackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.ReceivePackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.ReceivePackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
@@ -2,13 +2,18 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
@@ -46,7 +51,8 @@ func advertisedReferences(ctx context.Context, s *session, serviceName string) (
 
 	s.ApplyAuthToRequest(req)
 	applyHeadersToRequest(req, nil, s.endpoint.Host, serviceName)
-	res, err := s.client.Do(req.WithContext(ctx))
+	s.applyHTTPOptions(req, nil)
+	res, err := s.do(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -58,8 +64,24 @@ func advertisedReferences(ctx context.Context, s *session, serviceName string) (
 		return nil, err
 	}
 
+	body := bufio.NewReader(res.Body)
+	if !isSmartResponse(res, body, serviceName) {
+		if serviceName == transport.ReceivePackServiceName {
+			return nil, ErrDumbPushNotSupported
+		}
+
+		ar, err := dumbAdvertisedReferences(ctx, s, body)
+		if err != nil {
+			return nil, err
+		}
+
+		s.advRefs = ar
+		s.dumb = true
+		return ar, nil
+	}
+
 	ar := packp.NewAdvRefs()
-	if err = ar.Decode(res.Body); err != nil {
+	if err = ar.Decode(body); err != nil {
 		if err == packp.ErrEmptyAdvRefs {
 			err = transport.ErrEmptyRemoteRepository
 		}
@@ -75,6 +97,49 @@ func advertisedReferences(ctx context.Context, s *session, serviceName string) (
 
 type client struct {
 	c *http.Client
+
+	mu sync.Mutex
+	// derived are the copies of c with the TLS, proxy and HTTP version
+	// options of the endpoints, which reuse their connections as c does.
+	derived map[clientOptions]*http.Client
+}
+
+// clientOptions are the options of an endpoint requiring a copy of the
+// client, as a key of the derived clients.
+type clientOptions struct {
+	insecureSkipTLS bool
+	caBundle        string
+	clientCert      string
+	clientKey       string
+	proxy           string
+	version         string
+}
+
+func newClientOptions(ep *transport.Endpoint) (clientOptions, error) {
+	o := clientOptions{version: ep.HTTP.Version}
+	if ep.Protocol == "https" {
+		o.insecureSkipTLS = ep.InsecureSkipTLS
+		o.caBundle = string(ep.CaBundle)
+		o.clientCert = string(ep.ClientCert)
+		o.clientKey = string(ep.ClientKey)
+	}
+
+	if ep.Proxy.URL != "" {
+		proxyURL, err := ep.Proxy.FullURL()
+		if err != nil {
+			return o, err
+		}
+
+		// net/http resolves the host names through the SOCKS5 proxies, so it
+		// only knows about socks5.
+		if proxyURL.Scheme == "socks5h" {
+			proxyURL.Scheme = "socks5"
+		}
+
+		o.proxy = proxyURL.String()
+	}
+
+	return o, nil
 }
 
 // DefaultClient is the default HTTP client, which uses `http.DefaultClient`.
@@ -85,12 +150,15 @@ var DefaultClient = NewClient(nil)
 // Unless a properly initialized client is given, it will fall back into
 // `http.DefaultClient`.
 //
+// The TLS, proxy and HTTP version options of the endpoints are applied to
+// copies of its transport, which must be an *http.Transport for the proxies.
+//
 // Note that for HTTP client cannot distinguish between private repositories and
 // unexistent repositories on GitHub. So it returns `ErrAuthorizationRequired`
 // for both.
 func NewClient(c *http.Client) transport.Transport {
 	if c == nil {
-		return &client{http.DefaultClient}
+		return &client{c: http.DefaultClient}
 	}
 
 	return &client{
@@ -101,13 +169,141 @@ func NewClient(c *http.Client) transport.Transport {
 func (c *client) NewUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
 	transport.UploadPackSession, error) {
 
-	return newUploadPackSession(c.c, ep, auth)
+	hc, err := c.httpClient(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUploadPackSession(hc, ep, auth)
 }
 
 func (c *client) NewReceivePackSession(ep *transport.Endpoint, auth transport.AuthMethod) (
 	transport.ReceivePackSession, error) {
 
-	return newReceivePackSession(c.c, ep, auth)
+	hc, err := c.httpClient(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReceivePackSession(hc, ep, auth)
+}
+
+// httpClient returns the client for an endpoint: a copy of the client with
+// the TLS, proxy and HTTP version options of the endpoint, if any.
+func (c *client) httpClient(ep *transport.Endpoint) (*http.Client, error) {
+	o, err := newClientOptions(ep)
+	if err != nil {
+		return nil, err
+	}
+
+	if o == (clientOptions{}) {
+		return c.c, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hc, ok := c.derived[o]; ok {
+		return hc, nil
+	}
+
+	t, err := c.transport(o)
+	if err != nil {
+		return nil, err
+	}
+
+	hc := *c.c
+	hc.Transport = t
+
+	if c.derived == nil {
+		c.derived = make(map[clientOptions]*http.Client)
+	}
+
+	c.derived[o] = &hc
+	return &hc, nil
+}
+
+// transport returns a copy of the transport of the client with the given
+// options. Without a proxy, a copy of http.DefaultTransport is used if the
+// transport isn't an *http.Transport.
+func (c *client) transport(o clientOptions) (*http.Transport, error) {
+	var t *http.Transport
+	switch ct := c.c.Transport.(type) {
+	case *http.Transport:
+		t = ct.Clone()
+	default:
+		if ct != nil && o.proxy != "" {
+			return nil, fmt.Errorf("proxy not supported by the transport %T", ct)
+		}
+
+		t = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	if o.proxy != "" {
+		proxyURL, err := url.Parse(o.proxy)
+		if err != nil {
+			return nil, err
+		}
+
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if err := configureTLS(t, o); err != nil {
+		return nil, err
+	}
+
+	switch o.version {
+	case "":
+	case "HTTP/1.1":
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		if t.TLSClientConfig != nil {
+			// a transport already used for HTTP/2 announces it with ALPN
+			t.TLSClientConfig.NextProtos = nil
+		}
+	case "HTTP/2":
+		t.ForceAttemptHTTP2 = true
+	default:
+		return nil, fmt.Errorf("unsupported HTTP version %q", o.version)
+	}
+
+	return t, nil
+}
+
+func configureTLS(t *http.Transport, o clientOptions) error {
+	if !o.insecureSkipTLS && o.caBundle == "" && o.clientCert == "" {
+		return nil
+	}
+
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+
+	cfg := t.TLSClientConfig
+	if o.insecureSkipTLS {
+		cfg.InsecureSkipVerify = true
+	}
+
+	if o.caBundle != "" {
+		rootCAs, _ := x509.SystemCertPool()
+		if rootCAs == nil {
+			rootCAs = x509.NewCertPool()
+		}
+
+		rootCAs.AppendCertsFromPEM([]byte(o.caBundle))
+		cfg.RootCAs = rootCAs
+	}
+
+	if o.clientCert != "" {
+		cert, err := tls.X509KeyPair([]byte(o.clientCert), []byte(o.clientKey))
+		if err != nil {
+			return fmt.Errorf("invalid client certificate: %w", err)
+		}
+
+		cfg.Certificates = append(cfg.Certificates, cert)
+	}
+
+	return nil
 }
 
 type session struct {
@@ -115,6 +311,9 @@ type session struct {
 	client   *http.Client
 	endpoint *transport.Endpoint
 	advRefs  *packp.AdvRefs
+	headers  http.Header
+	// dumb is set if the server only supports the dumb HTTP protocol.
+	dumb bool
 }
 
 func newSession(c *http.Client, ep *transport.Endpoint, auth transport.AuthMethod) (*session, error) {
@@ -132,9 +331,29 @@ func newSession(c *http.Client, ep *transport.Endpoint, auth transport.AuthMetho
 		s.auth = a
 	}
 
+	var err error
+	if s.headers, err = parseExtraHeaders(ep.HTTP.ExtraHeaders); err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
+// parseExtraHeaders parses headers given as "Name: value".
+func parseExtraHeaders(headers []string) (http.Header, error) {
+	h := make(http.Header)
+	for _, header := range headers {
+		i := strings.IndexByte(header, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid extra header %q", header)
+		}
+
+		h.Add(strings.TrimSpace(header[:i]), strings.TrimSpace(header[i+1:]))
+	}
+
+	return h, nil
+}
+
 func (s *session) ApplyAuthToRequest(req *http.Request) {
 	if s.auth == nil {
 		return
@@ -143,6 +362,43 @@ func (s *session) ApplyAuthToRequest(req *http.Request) {
 	s.auth.SetAuth(req)
 }
 
+// applyHTTPOptions adds the extra headers to a request, and sends it with the
+// chunked transfer encoding if its content is larger than the post buffer.
+func (s *session) applyHTTPOptions(req *http.Request, content *bytes.Buffer) {
+	for name, values := range s.headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+
+	postBuffer := s.endpoint.HTTP.PostBuffer
+	if content != nil && postBuffer > 0 && content.Len() > postBuffer {
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+	}
+}
+
+// do sends a request, aborting it if it's slower than the low speed limit of
+// the endpoint.
+func (s *session) do(ctx context.Context, req *http.Request) (*http.Response, error) {
+	o := s.endpoint.HTTP
+	if o.LowSpeedLimit <= 0 || o.LowSpeedTime <= 0 {
+		return s.client.Do(req.WithContext(ctx))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	w := newLowSpeedWatchdog(o.LowSpeedLimit, o.LowSpeedTime, cancel)
+
+	res, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		w.Stop()
+		return nil, w.Err(err)
+	}
+
+	res.Body = w.Body(res.Body)
+	return res, nil
+}
+
 func (s *session) ModifyEndpointIfRedirect(res *http.Response) {
 	if res.Request == nil {
 		return
@@ -0,0 +1,552 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	stdioutil "io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/idxfile"
+	"github.com/go-git/go-git/v5/plumbing/format/objfile"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/emirpasic/gods/trees/binaryheap"
+)
+
+var (
+	// ErrDumbPushNotSupported is returned when pushing to a server which
+	// only supports the dumb HTTP protocol.
+	ErrDumbPushNotSupported = errors.New("push not supported by dumb HTTP servers")
+	// ErrDumbShallowNotSupported is returned when fetching with a depth from
+	// a server which only supports the dumb HTTP protocol.
+	ErrDumbShallowNotSupported = errors.New("shallow fetch not supported by dumb HTTP servers")
+	// ErrDumbPackfileNotSupported is returned by UploadPack and NegotiatePack
+	// on a server which only supports the dumb HTTP protocol, its objects
+	// being fetched with FetchObjects instead.
+	ErrDumbPackfileNotSupported = errors.New("packfiles not supported by dumb HTTP servers")
+
+	errDumbNotFound = errors.New("not found")
+	errNotDumb      = errors.New("objects only fetched from dumb HTTP servers")
+)
+
+// isSmartResponse returns if the response to the info/refs request of a
+// service is from a smart server, by its content type as git does, or by its
+// content starting with the "# service=" pkt-line.
+func isSmartResponse(res *http.Response, r *bufio.Reader, serviceName string) bool {
+	contentType := fmt.Sprintf("application/x-%s-advertisement", serviceName)
+	if res.Header.Get("Content-Type") == contentType {
+		return true
+	}
+
+	prefix, _ := r.Peek(5)
+	if len(prefix) < 5 || prefix[4] != '#' {
+		return false
+	}
+
+	for _, b := range prefix[:4] {
+		if !isHexDigit(b) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHexDigit(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'f' || b >= 'A' && b <= 'F'
+}
+
+// dumbAdvertisedReferences returns the references of a dumb server, read from
+// the info/refs file and the HEAD file.
+func dumbAdvertisedReferences(ctx context.Context, s *session, r io.Reader) (*packp.AdvRefs, error) {
+	ar := packp.NewAdvRefs()
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+
+		chunks := strings.Split(line, "\t")
+		if len(chunks) != 2 || !plumbing.IsHash(chunks[0]) {
+			return nil, fmt.Errorf("invalid info/refs line: %q", line)
+		}
+
+		hash, name := plumbing.NewHash(chunks[0]), chunks[1]
+		if strings.HasSuffix(name, "^{}") {
+			ar.Peeled[strings.TrimSuffix(name, "^{}")] = hash
+			continue
+		}
+
+		ar.References[name] = hash
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(ar.References) == 0 {
+		return nil, transport.ErrEmptyRemoteRepository
+	}
+
+	if err := readDumbHead(ctx, s, ar); err != nil {
+		return nil, err
+	}
+
+	return ar, nil
+}
+
+// readDumbHead sets the HEAD of the references from the HEAD file, if any.
+func readDumbHead(ctx context.Context, s *session, ar *packp.AdvRefs) (err error) {
+	res, err := s.get(ctx, "HEAD")
+	if err == errDumbNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	content, err := stdioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	if err != nil {
+		return err
+	}
+
+	head := strings.TrimSpace(string(content))
+	if strings.HasPrefix(head, "ref: ") {
+		target := plumbing.ReferenceName(strings.TrimPrefix(head, "ref: "))
+		if err := ar.AddReference(plumbing.NewSymbolicReference(plumbing.HEAD, target)); err != nil {
+			return err
+		}
+
+		if hash, ok := ar.References[target.String()]; ok {
+			ar.Head = &hash
+		}
+
+		return nil
+	}
+
+	if plumbing.IsHash(head) {
+		hash := plumbing.NewHash(head)
+		ar.Head = &hash
+	}
+
+	return nil
+}
+
+// get requests a file of the repository, returning errDumbNotFound if it
+// doesn't exist.
+func (s *session) get(ctx context.Context, path string) (*http.Response, error) {
+	url := fmt.Sprintf("%s/%s", s.endpoint.String(), path)
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ApplyAuthToRequest(req)
+	applyHeadersToRequest(req, nil, s.endpoint.Host, transport.UploadPackServiceName)
+	s.applyHTTPOptions(req, nil)
+	res, err := s.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		_ = res.Body.Close()
+		return nil, errDumbNotFound
+	}
+
+	if err := NewErr(res); err != nil {
+		_ = res.Body.Close()
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// dumbFetchObjects fetches the objects requested from a dumb server straight
+// into the storer.
+func dumbFetchObjects(ctx context.Context, s *session, req *packp.UploadPackRequest, sto storer.Storer) error {
+	if !req.Depth.IsZero() {
+		return ErrDumbShallowNotSupported
+	}
+
+	f := &dumbFetcher{s: s, ctx: ctx, storer: sto}
+	return f.fetch(req.Wants, req.Haves)
+}
+
+// dumbFetcher fetches the objects of a dumb server into a storer, as loose
+// objects or with the packs containing them.
+type dumbFetcher struct {
+	s      *session
+	ctx    context.Context
+	storer storer.Storer
+
+	// packs are the packs of the server, nil until objects/info/packs
+	// is read.
+	packs []*dumbPack
+}
+
+type dumbPack struct {
+	// name is the name of the pack, without extension.
+	name    string
+	index   *idxfile.MemoryIndex
+	fetched bool
+}
+
+// fetch fetches the objects reachable from the wants and missing in the
+// storer.
+//
+// As git's walker does, the walk stops at the complete commits, the haves and
+// their ancestors. The ancestors are marked complete as the walk goes, in
+// date order, down to the date of the commits walked, so the local history
+// is only read as far as needed.
+func (f *dumbFetcher) fetch(wants, haves []plumbing.Hash) error {
+	w := &dumbWalk{
+		fetcher:  f,
+		seen:     make(map[plumbing.Hash]bool),
+		complete: make(map[plumbing.Hash]bool),
+		queue: binaryheap.NewWith(func(a, b interface{}) int {
+			if a.(*object.Commit).Committer.When.Before(b.(*object.Commit).Committer.When) {
+				return 1
+			}
+
+			return -1
+		}),
+	}
+
+	for _, h := range haves {
+		if err := w.markComplete(h); err != nil {
+			return err
+		}
+	}
+
+	pending := append([]plumbing.Hash(nil), wants...)
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if w.seen[h] {
+			continue
+		}
+
+		w.seen[h] = true
+		o, err := f.object(h)
+		if err != nil {
+			return err
+		}
+
+		switch o := o.(type) {
+		case *object.Commit:
+			if err := w.popComplete(o.Committer.When); err != nil {
+				return err
+			}
+
+			if w.complete[h] {
+				continue
+			}
+
+			if err := w.walkTree(o.TreeHash); err != nil {
+				return err
+			}
+
+			pending = append(pending, o.ParentHashes...)
+		case *object.Tag:
+			pending = append(pending, o.Target)
+		case *object.Tree:
+			delete(w.seen, h)
+			if err := w.walkTree(h); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type dumbWalk struct {
+	fetcher  *dumbFetcher
+	seen     map[plumbing.Hash]bool
+	complete map[plumbing.Hash]bool
+	// queue holds the complete commits whose parents are not marked
+	// complete yet, the most recent first.
+	queue *binaryheap.Heap
+}
+
+// markComplete marks a commit of the storer as complete, peeling the tags.
+// The objects missing in the storer are ignored.
+func (w *dumbWalk) markComplete(h plumbing.Hash) error {
+	for {
+		if w.complete[h] {
+			return nil
+		}
+
+		o, err := w.fetcher.storer.EncodedObject(plumbing.AnyObject, h)
+		if err == plumbing.ErrObjectNotFound {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch o.Type() {
+		case plumbing.TagObject:
+			t, err := object.DecodeTag(w.fetcher.storer, o)
+			if err != nil {
+				return err
+			}
+
+			h = t.Target
+		case plumbing.CommitObject:
+			c, err := object.DecodeCommit(w.fetcher.storer, o)
+			if err != nil {
+				return err
+			}
+
+			w.complete[h] = true
+			w.queue.Push(c)
+			return nil
+		default:
+			return nil
+		}
+	}
+}
+
+// popComplete marks complete the parents of the complete commits not older
+// than the given date.
+func (w *dumbWalk) popComplete(when time.Time) error {
+	for {
+		top, ok := w.queue.Peek()
+		if !ok || top.(*object.Commit).Committer.When.Before(when) {
+			return nil
+		}
+
+		w.queue.Pop()
+		for _, p := range top.(*object.Commit).ParentHashes {
+			if err := w.markComplete(p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// walkTree fetches a tree and all its entries not seen yet.
+func (w *dumbWalk) walkTree(h plumbing.Hash) error {
+	if w.seen[h] {
+		return nil
+	}
+
+	w.seen[h] = true
+	o, err := w.fetcher.object(h)
+	if err != nil {
+		return err
+	}
+
+	t, ok := o.(*object.Tree)
+	if !ok {
+		return nil
+	}
+
+	for _, e := range t.Entries {
+		switch e.Mode {
+		case filemode.Dir:
+			if err := w.walkTree(e.Hash); err != nil {
+				return err
+			}
+		case filemode.Submodule:
+		default:
+			if w.seen[e.Hash] {
+				continue
+			}
+
+			w.seen[e.Hash] = true
+			if err := w.fetcher.fetchObject(e.Hash); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// object returns the decoded object with the given hash, fetching it if
+// missing in the storer.
+func (f *dumbFetcher) object(h plumbing.Hash) (object.Object, error) {
+	if err := f.fetchObject(h); err != nil {
+		return nil, err
+	}
+
+	o, err := f.storer.EncodedObject(plumbing.AnyObject, h)
+	if err != nil {
+		return nil, err
+	}
+
+	return object.DecodeObject(f.storer, o)
+}
+
+// fetchObject fetches the object with the given hash as a loose object, or
+// with the pack containing it, if missing in the storer.
+func (f *dumbFetcher) fetchObject(h plumbing.Hash) error {
+	if f.storer.HasEncodedObject(h) == nil {
+		return nil
+	}
+
+	err := f.fetchLooseObject(h)
+	if err == errDumbNotFound {
+		err = f.fetchPackContaining(h)
+	}
+
+	return err
+}
+
+func (f *dumbFetcher) fetchLooseObject(h plumbing.Hash) (err error) {
+	hex := h.String()
+	res, err := f.s.get(f.ctx, fmt.Sprintf("objects/%s/%s", hex[:2], hex[2:]))
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	r, err := objfile.NewReader(res.Body)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(r, &err)
+
+	t, size, err := r.Header()
+	if err != nil {
+		return err
+	}
+
+	o := f.storer.NewEncodedObject()
+	o.SetType(t)
+	o.SetSize(size)
+
+	w, err := o.Writer()
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(w, r); err != nil {
+		return err
+	}
+
+	if o.Hash() != h {
+		return fmt.Errorf("corrupted loose object %s", h)
+	}
+
+	_, err = f.storer.SetEncodedObject(o)
+	return err
+}
+
+// fetchPackContaining fetches the pack containing an object, reading the
+// indexes of the packs not fetched yet.
+func (f *dumbFetcher) fetchPackContaining(h plumbing.Hash) error {
+	if f.packs == nil {
+		if err := f.readPacks(); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range f.packs {
+		if p.fetched {
+			continue
+		}
+
+		if p.index == nil {
+			if err := f.fetchIndex(p); err != nil {
+				return err
+			}
+		}
+
+		ok, err := p.index.Contains(h)
+		if err != nil {
+			return err
+		}
+
+		if ok {
+			return f.fetchPack(p)
+		}
+	}
+
+	return fmt.Errorf("%w: %s", plumbing.ErrObjectNotFound, h)
+}
+
+// readPacks reads the list of packs in objects/info/packs.
+func (f *dumbFetcher) readPacks() (err error) {
+	f.packs = []*dumbPack{}
+
+	res, err := f.s.get(f.ctx, "objects/info/packs")
+	if err == errDumbNotFound {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "P ") || !strings.HasSuffix(line, ".pack") {
+			continue
+		}
+
+		name := strings.TrimSuffix(strings.TrimPrefix(line, "P "), ".pack")
+		f.packs = append(f.packs, &dumbPack{name: name})
+	}
+
+	return scanner.Err()
+}
+
+func (f *dumbFetcher) fetchIndex(p *dumbPack) (err error) {
+	res, err := f.s.get(f.ctx, fmt.Sprintf("objects/pack/%s.idx", p.name))
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	idx := idxfile.NewMemoryIndex()
+	if err := idxfile.NewDecoder(res.Body).Decode(idx); err != nil {
+		return err
+	}
+
+	p.index = idx
+	return nil
+}
+
+func (f *dumbFetcher) fetchPack(p *dumbPack) (err error) {
+	res, err := f.s.get(f.ctx, fmt.Sprintf("objects/pack/%s.pack", p.name))
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(res.Body, &err)
+
+	if err := packfile.UpdateObjectStorage(f.storer, res.Body); err != nil {
+		return err
+	}
+
+	p.fetched = true
+	return nil
+}
@@ -0,0 +1,249 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type DumbSuite struct {
+	fixtures.Suite
+
+	base   string
+	server *httptest.Server
+
+	// master and previous are the commits of master, the former only
+	// being a loose object.
+	master, previous plumbing.Hash
+	// packs counts the requests of packs.
+	packs int32
+}
+
+var _ = Suite(&DumbSuite{})
+
+func (s *DumbSuite) SetUpSuite(c *C) {
+	base, err := ioutil.TempDir("", "go-git-http-dumb")
+	c.Assert(err, IsNil)
+	s.base = base
+
+	fs := fixtures.Basic().One().DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+
+	path := filepath.Join(s.base, "basic.git")
+	c.Assert(os.Rename(fs.Root(), path), IsNil)
+
+	work := filepath.Join(s.base, "work")
+	s.git(c, s.base, "clone", path, work)
+	c.Assert(ioutil.WriteFile(filepath.Join(work, "dumb"), []byte("dumb"), 0644), IsNil)
+	s.git(c, work, "add", "dumb")
+	s.git(c, work, "commit", "-m", "dumb")
+	s.git(c, work, "push", "origin", "HEAD:master")
+	s.git(c, path, "update-server-info")
+
+	s.master = plumbing.NewHash(s.git(c, path, "rev-parse", "master"))
+	s.previous = plumbing.NewHash(s.git(c, path, "rev-parse", "master^"))
+
+	empty := filepath.Join(s.base, "empty.git", "info")
+	c.Assert(os.MkdirAll(empty, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(empty, "refs"), nil, 0644), IsNil)
+
+	files := http.FileServer(http.Dir(s.base))
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, ".pack") {
+			atomic.AddInt32(&s.packs, 1)
+		}
+
+		files.ServeHTTP(w, r)
+	}))
+}
+
+func (s *DumbSuite) TearDownSuite(c *C) {
+	s.server.Close()
+	c.Assert(os.RemoveAll(s.base), IsNil)
+}
+
+func (s *DumbSuite) git(c *C, dir string, args ...string) string {
+	args = append([]string{"-c", "user.name=foo", "-c", "user.email=foo@foo.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+
+	out, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+	return strings.TrimSpace(string(out))
+}
+
+func (s *DumbSuite) endpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(s.server.URL + "/" + name)
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+func (s *DumbSuite) uploadPackSession(c *C) (transport.UploadPackSession, *packp.AdvRefs) {
+	r, err := DefaultClient.NewUploadPackSession(s.endpoint(c, "basic.git"), nil)
+	c.Assert(err, IsNil)
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	return r, ar
+}
+
+func (s *DumbSuite) TestAdvertisedReferences(c *C) {
+	r, ar := s.uploadPackSession(c)
+	defer r.Close()
+
+	c.Assert(ar.References["refs/heads/master"], Equals, s.master)
+	c.Assert(ar.References["refs/heads/branch"], Equals,
+		plumbing.NewHash("e8d3ffab552895c19b9fcf7aa264d277cde33881"))
+	c.Assert(*ar.Head, Equals, s.master)
+
+	refs, err := ar.AllReferences()
+	c.Assert(err, IsNil)
+
+	head, err := refs.Reference(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+}
+
+func (s *DumbSuite) TestAdvertisedReferencesEmpty(c *C) {
+	r, err := DefaultClient.NewUploadPackSession(s.endpoint(c, "empty.git"), nil)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrEmptyRemoteRepository)
+}
+
+func (s *DumbSuite) TestAdvertisedReferencesNotExists(c *C) {
+	r, err := DefaultClient.NewUploadPackSession(s.endpoint(c, "non-existent.git"), nil)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *DumbSuite) TestFetchObjects(c *C) {
+	r, _ := s.uploadPackSession(c)
+	defer r.Close()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, s.master)
+
+	sto := memory.NewStorage()
+	s.fetchObjects(c, r, req, sto)
+	s.assertObjects(c, sto, s.revList(c, "master"))
+}
+
+func (s *DumbSuite) TestFetchObjectsHaves(c *C) {
+	r, _ := s.uploadPackSession(c)
+	defer r.Close()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, s.previous)
+
+	sto := memory.NewStorage()
+	s.fetchObjects(c, r, req, sto)
+	s.assertObjects(c, sto, s.revList(c, "master^"))
+
+	before := len(sto.Objects)
+	atomic.StoreInt32(&s.packs, 0)
+
+	req = packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, s.master)
+	req.Haves = append(req.Haves, s.previous)
+	s.fetchObjects(c, r, req, sto)
+
+	// only the loose objects of the last commit are fetched
+	added := s.revList(c, "master", "^master^")
+	s.assertObjects(c, sto, added)
+	c.Assert(sto.Objects, HasLen, before+len(added))
+	c.Assert(atomic.LoadInt32(&s.packs), Equals, int32(0))
+}
+
+func (s *DumbSuite) TestFetchObjectsShallow(c *C) {
+	r, _ := s.uploadPackSession(c)
+	defer r.Close()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, s.master)
+	req.Depth = packp.DepthCommits(1)
+	c.Assert(req.Capabilities.Set(capability.Shallow), IsNil)
+
+	err := r.(transport.ObjectFetcherSession).FetchObjects(context.Background(), req, memory.NewStorage())
+	c.Assert(err, Equals, ErrDumbShallowNotSupported)
+}
+
+func (s *DumbSuite) TestFetchObjectsNotFound(c *C) {
+	r, _ := s.uploadPackSession(c)
+	defer r.Close()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("0000000000000000000000000000000000000001"))
+
+	err := r.(transport.ObjectFetcherSession).FetchObjects(context.Background(), req, memory.NewStorage())
+	c.Assert(err, ErrorMatches, "object not found.*")
+}
+
+func (s *DumbSuite) TestUploadPack(c *C) {
+	r, _ := s.uploadPackSession(c)
+	defer r.Close()
+
+	c.Assert(r.(transport.ObjectFetcherSession).FetchesObjects(), Equals, true)
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, s.master)
+
+	_, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, Equals, ErrDumbPackfileNotSupported)
+}
+
+func (s *DumbSuite) TestReceivePack(c *C) {
+	r, err := DefaultClient.NewReceivePackSession(s.endpoint(c, "basic.git"), nil)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, ErrDumbPushNotSupported)
+}
+
+func (s *DumbSuite) fetchObjects(c *C, r transport.UploadPackSession, req *packp.UploadPackRequest, sto *memory.Storage) {
+	err := r.(transport.ObjectFetcherSession).FetchObjects(context.Background(), req, sto)
+	c.Assert(err, IsNil)
+}
+
+// assertObjects asserts the storage has the given objects, it may have more as
+// the packs are fetched whole.
+func (s *DumbSuite) assertObjects(c *C, sto *memory.Storage, hashes map[plumbing.Hash]bool) {
+	for h := range hashes {
+		c.Assert(sto.HasEncodedObject(h), IsNil, Commentf("%s", h))
+	}
+}
+
+// revList returns the hashes of the objects listed by git rev-list.
+func (s *DumbSuite) revList(c *C, revs ...string) map[plumbing.Hash]bool {
+	args := append([]string{"rev-list", "--objects"}, revs...)
+	out := s.git(c, filepath.Join(s.base, "basic.git"), args...)
+
+	hashes := make(map[plumbing.Hash]bool)
+	for _, line := range strings.Split(out, "\n") {
+		hashes[plumbing.NewHash(line[:40])] = true
+	}
+
+	return hashes
+}
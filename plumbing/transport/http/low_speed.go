@@ -0,0 +1,115 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrLowSpeed is returned when a request is aborted for being slower than the
+// low speed limit of its endpoint.
+var ErrLowSpeed = errors.New("transfer too slow")
+
+// lowSpeedWatchdog cancels a request transferring less than limit bytes per
+// second during period, as the low speed limit of curl.
+type lowSpeedWatchdog struct {
+	limit  int64
+	period time.Duration
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	n       int64
+	timer   *time.Timer
+	stopped bool
+	tripped bool
+}
+
+func newLowSpeedWatchdog(limit int, period time.Duration, cancel context.CancelFunc) *lowSpeedWatchdog {
+	w := &lowSpeedWatchdog{
+		limit:  int64(limit),
+		period: period,
+		cancel: cancel,
+	}
+
+	w.timer = time.AfterFunc(period, w.check)
+	return w
+}
+
+func (w *lowSpeedWatchdog) check() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stopped {
+		return
+	}
+
+	if float64(w.n) < float64(w.limit)*w.period.Seconds() {
+		w.tripped = true
+		w.stopped = true
+		w.cancel()
+		return
+	}
+
+	w.n = 0
+	w.timer.Reset(w.period)
+}
+
+func (w *lowSpeedWatchdog) add(n int) {
+	w.mu.Lock()
+	w.n += int64(n)
+	w.mu.Unlock()
+}
+
+// Stop stops the watchdog, and cancels the request.
+func (w *lowSpeedWatchdog) Stop() {
+	w.mu.Lock()
+	w.stopped = true
+	w.timer.Stop()
+	w.mu.Unlock()
+
+	w.cancel()
+}
+
+// Err returns an ErrLowSpeed error if the request was aborted by the
+// watchdog, err otherwise.
+func (w *lowSpeedWatchdog) Err(err error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.tripped {
+		return err
+	}
+
+	return fmt.Errorf("%w: less than %d bytes/sec transferred the last %s",
+		ErrLowSpeed, w.limit, w.period,
+	)
+}
+
+// Body returns the body of the response of the request, counting the bytes
+// read, and stopping the watchdog when closed.
+func (w *lowSpeedWatchdog) Body(body io.ReadCloser) io.ReadCloser {
+	return &lowSpeedBody{ReadCloser: body, w: w}
+}
+
+type lowSpeedBody struct {
+	io.ReadCloser
+	w *lowSpeedWatchdog
+}
+
+func (b *lowSpeedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.w.add(n)
+	if err != nil && err != io.EOF {
+		err = b.w.Err(err)
+	}
+
+	return n, err
+}
+
+func (b *lowSpeedBody) Close() error {
+	b.w.Stop()
+	return b.ReadCloser.Close()
+}
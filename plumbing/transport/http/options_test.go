@@ -0,0 +1,317 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	. "gopkg.in/check.v1"
+)
+
+type HTTPOptionsSuite struct {
+	server *httptest.Server
+
+	mu       sync.Mutex
+	requests []*http.Request
+	delay    time.Duration
+}
+
+var _ = Suite(&HTTPOptionsSuite{})
+
+func (s *HTTPOptionsSuite) SetUpTest(c *C) {
+	s.requests = nil
+	s.delay = 0
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	s.server.EnableHTTP2 = true
+}
+
+func (s *HTTPOptionsSuite) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+// handle records the requests, and answers them with a 404 after the delay.
+func (s *HTTPOptionsSuite) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r)
+	delay := s.delay
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+}
+
+func (s *HTTPOptionsSuite) setDelay(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delay = d
+}
+
+func (s *HTTPOptionsSuite) lastRequest(c *C) *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.Assert(s.requests, Not(HasLen), 0)
+	return s.requests[len(s.requests)-1]
+}
+
+func (s *HTTPOptionsSuite) endpoint(c *C) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(s.server.URL + "/repository.git")
+	c.Assert(err, IsNil)
+
+	if strings.HasPrefix(s.server.URL, "https") {
+		ep.CaBundle = pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: s.server.Certificate().Raw,
+		})
+	}
+
+	return ep
+}
+
+func (s *HTTPOptionsSuite) advertisedReferences(ep *transport.Endpoint) error {
+	r, err := DefaultClient.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+	_, err = r.AdvertisedReferences()
+	return err
+}
+
+func (s *HTTPOptionsSuite) TestCaBundle(c *C) {
+	s.server.StartTLS()
+
+	ep := s.endpoint(c)
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+
+	ep.CaBundle = nil
+	c.Assert(s.advertisedReferences(ep), ErrorMatches, ".*certificate.*")
+
+	ep.InsecureSkipTLS = true
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *HTTPOptionsSuite) TestClientCert(c *C) {
+	cert, key := generateCertificate(c)
+
+	pool := x509.NewCertPool()
+	c.Assert(pool.AppendCertsFromPEM(cert), Equals, true)
+	s.server.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  pool,
+	}
+
+	s.server.StartTLS()
+
+	ep := s.endpoint(c)
+	c.Assert(s.advertisedReferences(ep), NotNil)
+
+	ep.ClientCert = cert
+	ep.ClientKey = key
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+	c.Assert(s.lastRequest(c).TLS.PeerCertificates, HasLen, 1)
+
+	ep.ClientKey = nil
+	c.Assert(s.advertisedReferences(ep), ErrorMatches, "invalid client certificate.*")
+}
+
+func (s *HTTPOptionsSuite) TestReuseClient(c *C) {
+	s.server.StartTLS()
+
+	ep := s.endpoint(c)
+	cl := DefaultClient.(*client)
+
+	hc, err := cl.httpClient(ep)
+	c.Assert(err, IsNil)
+	c.Assert(hc, Not(Equals), cl.c)
+
+	other, err := cl.httpClient(s.endpoint(c))
+	c.Assert(err, IsNil)
+	c.Assert(other, Equals, hc)
+
+	ep.HTTP.Version = "HTTP/1.1"
+	other, err = cl.httpClient(ep)
+	c.Assert(err, IsNil)
+	c.Assert(other, Not(Equals), hc)
+
+	ep, err = transport.NewEndpoint("http://example.com/repository.git")
+	c.Assert(err, IsNil)
+	ep.InsecureSkipTLS = true
+
+	hc, err = cl.httpClient(ep)
+	c.Assert(err, IsNil)
+	c.Assert(hc, Equals, cl.c)
+}
+
+func (s *HTTPOptionsSuite) TestVersion(c *C) {
+	s.server.StartTLS()
+
+	ep := s.endpoint(c)
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+	c.Assert(s.lastRequest(c).ProtoMajor, Equals, 2)
+
+	ep.HTTP.Version = "HTTP/1.1"
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+	c.Assert(s.lastRequest(c).ProtoMajor, Equals, 1)
+
+	ep.HTTP.Version = "HTTP/3"
+	c.Assert(s.advertisedReferences(ep), ErrorMatches, `unsupported HTTP version "HTTP/3"`)
+}
+
+func (s *HTTPOptionsSuite) TestExtraHeaders(c *C) {
+	s.server.Start()
+
+	ep := s.endpoint(c)
+	ep.HTTP.ExtraHeaders = []string{
+		"Authorization: Bearer token",
+		"X-Custom:  first ",
+		"X-Custom: second",
+	}
+
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+
+	r := s.lastRequest(c)
+	c.Assert(r.Header.Get("Authorization"), Equals, "Bearer token")
+	c.Assert(r.Header["X-Custom"], DeepEquals, []string{"first", "second"})
+
+	ep.HTTP.ExtraHeaders = []string{"invalid"}
+	c.Assert(s.advertisedReferences(ep), ErrorMatches, `invalid extra header "invalid"`)
+}
+
+func (s *HTTPOptionsSuite) TestPostBuffer(c *C) {
+	s.server.Start()
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+
+	ep := s.endpoint(c)
+	s.uploadPack(c, ep, req)
+	c.Assert(s.lastRequest(c).ContentLength, Not(Equals), int64(-1))
+	c.Assert(s.lastRequest(c).TransferEncoding, HasLen, 0)
+
+	ep.HTTP.PostBuffer = 16
+	s.uploadPack(c, ep, req)
+	c.Assert(s.lastRequest(c).ContentLength, Equals, int64(-1))
+	c.Assert(s.lastRequest(c).TransferEncoding, DeepEquals, []string{"chunked"})
+}
+
+func (s *HTTPOptionsSuite) uploadPack(c *C, ep *transport.Endpoint, req *packp.UploadPackRequest) {
+	r, err := DefaultClient.NewUploadPackSession(ep, nil)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.UploadPack(context.Background(), req)
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *HTTPOptionsSuite) TestLowSpeedLimit(c *C) {
+	s.server.Start()
+	s.setDelay(time.Second)
+
+	ep := s.endpoint(c)
+	ep.HTTP.LowSpeedLimit = 1000
+	ep.HTTP.LowSpeedTime = 50 * time.Millisecond
+
+	err := s.advertisedReferences(ep)
+	c.Assert(errors.Is(err, ErrLowSpeed), Equals, true, Commentf("%v", err))
+
+	s.setDelay(0)
+	c.Assert(s.advertisedReferences(ep), Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *HTTPOptionsSuite) TestLowSpeedWatchdog(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := newLowSpeedWatchdog(10, 20*time.Millisecond, cancel)
+
+	body := w.Body(&slowBody{n: 100, delay: time.Millisecond})
+	buf := make([]byte, 1)
+	for i := 0; i < 30; i++ {
+		_, err := body.Read(buf)
+		c.Assert(err, IsNil)
+	}
+
+	c.Assert(ctx.Err(), IsNil)
+
+	body.(*lowSpeedBody).ReadCloser = &slowBody{n: 100, delay: 100 * time.Millisecond, ctx: ctx}
+	_, err := body.Read(buf)
+	c.Assert(errors.Is(err, ErrLowSpeed), Equals, true)
+	c.Assert(ctx.Err(), NotNil)
+	c.Assert(body.Close(), IsNil)
+}
+
+// slowBody returns n bytes, one by one after a delay, unless ctx is done.
+type slowBody struct {
+	n     int
+	delay time.Duration
+	ctx   context.Context
+}
+
+func (b *slowBody) Read(p []byte) (int, error) {
+	var done <-chan struct{}
+	if b.ctx != nil {
+		done = b.ctx.Done()
+	}
+
+	select {
+	case <-time.After(b.delay):
+	case <-done:
+		return 0, b.ctx.Err()
+	}
+
+	b.n--
+	p[0] = 'x'
+	return 1, nil
+}
+
+func (b *slowBody) Close() error {
+	return nil
+}
+
+// generateCertificate returns a self-signed client certificate and its key.
+func generateCertificate(c *C) (cert, key []byte) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "client"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	c.Assert(err, IsNil)
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	c.Assert(err, IsNil)
+
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return cert, key
+}
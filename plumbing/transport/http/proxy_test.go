@@ -0,0 +1,95 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/test"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type ProxySuite struct {
+	BaseSuite
+
+	proxy *test.HTTPProxy
+}
+
+var _ = Suite(&ProxySuite{})
+
+func (s *ProxySuite) SetUpTest(c *C) {
+	s.BaseSuite.SetUpTest(c)
+	s.proxy = test.NewHTTPProxy("user", "secret")
+}
+
+func (s *ProxySuite) TearDownTest(c *C) {
+	s.proxy.Close()
+	s.BaseSuite.TearDownTest(c)
+}
+
+func (s *ProxySuite) advertisedReferences(ep *transport.Endpoint) error {
+	r, err := DefaultClient.NewUploadPackSession(ep, nil)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+	_, err = r.AdvertisedReferences()
+	return err
+}
+
+func (s *ProxySuite) TestProxy(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	ep.Proxy = transport.ProxyOptions{
+		URL:      s.proxy.URL,
+		Username: "user",
+		Password: "secret",
+	}
+
+	c.Assert(s.advertisedReferences(ep), IsNil)
+	c.Assert(s.proxy.Requests(), Equals, 1)
+}
+
+func (s *ProxySuite) TestProxyWrongCredentials(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	ep.Proxy = transport.ProxyOptions{URL: s.proxy.URL, Username: "user"}
+
+	err := s.advertisedReferences(ep)
+	c.Assert(err, ErrorMatches, ".*status code: 407")
+	c.Assert(s.proxy.Requests(), Equals, 0)
+}
+
+func (s *ProxySuite) TestProxyClientReused(c *C) {
+	cl := NewClient(nil).(*client)
+	ep := s.newEndpoint(c, "basic.git")
+	ep.Proxy = transport.ProxyOptions{URL: s.proxy.URL}
+
+	hc, err := cl.httpClient(ep)
+	c.Assert(err, IsNil)
+	c.Assert(hc, Not(Equals), http.DefaultClient)
+
+	other, err := cl.httpClient(ep)
+	c.Assert(err, IsNil)
+	c.Assert(other, Equals, hc)
+
+	ep.Proxy = transport.ProxyOptions{}
+	hc, err = cl.httpClient(ep)
+	c.Assert(err, IsNil)
+	c.Assert(hc, Equals, http.DefaultClient)
+}
+
+func (s *ProxySuite) TestProxyUnsupportedTransport(c *C) {
+	cl := NewClient(&http.Client{Transport: roundTripperFunc(nil)}).(*client)
+	ep := s.newEndpoint(c, "basic.git")
+	ep.Proxy = transport.ProxyOptions{URL: s.proxy.URL}
+
+	_, err := cl.httpClient(ep)
+	c.Assert(err, ErrorMatches, "proxy not supported by the transport .*")
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
@@ -95,8 +95,9 @@ func (s *rpSession) doRequest(
 
 	applyHeadersToRequest(req, content, s.endpoint.Host, transport.ReceivePackServiceName)
 	s.ApplyAuthToRequest(req)
+	s.applyHTTPOptions(req, content)
 
-	res, err := s.client.Do(req.WithContext(ctx))
+	res, err := s.do(ctx, req)
 	if err != nil {
 		return nil, plumbing.NewUnexpectedError(err)
 	}
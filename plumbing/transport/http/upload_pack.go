@@ -10,6 +10,8 @@ import (
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
 	"github.com/go-git/go-git/v5/utils/ioutil"
@@ -44,6 +46,10 @@ func (s *upSession) UploadPack(
 		return nil, err
 	}
 
+	if s.dumb {
+		return nil, ErrDumbPackfileNotSupported
+	}
+
 	url := fmt.Sprintf(
 		"%s/%s",
 		s.endpoint.String(), transport.UploadPackServiceName,
@@ -72,6 +78,114 @@ func (s *upSession) UploadPack(
 	return common.DecodeUploadPackResponse(rc, req)
 }
 
+// NegotiatePack performs an upload-pack request negotiating its haves with
+// the server, each round being a request of its own, as HTTP is stateless.
+// The last round doesn't need to send done if the server supports no-done.
+func (s *upSession) NegotiatePack(
+	ctx context.Context, req *packp.UploadPackRequest, n transport.Negotiator,
+) (*packp.UploadPackResponse, error) {
+
+	if req.IsEmpty() {
+		return nil, transport.ErrEmptyUploadPackRequest
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	if s.dumb {
+		return nil, ErrDumbPackfileNotSupported
+	}
+
+	if s.advRefs != nil && s.advRefs.Capabilities.Supports(capability.NoDone) {
+		if err := req.Capabilities.Set(capability.NoDone); err != nil {
+			return nil, err
+		}
+	}
+
+	url := fmt.Sprintf(
+		"%s/%s",
+		s.endpoint.String(), transport.UploadPackServiceName,
+	)
+
+	g := common.NewNegotiation(n, true)
+	for {
+		haves, last, err := g.Haves()
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := negotiationRequestToReader(req, append(g.Common, haves...), last)
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := s.doRequest(ctx, http.MethodPost, url, content)
+		if err != nil {
+			return nil, err
+		}
+
+		if last {
+			r, err := ioutil.NonEmptyReader(res.Body)
+			if err != nil {
+				_ = res.Body.Close()
+				return nil, err
+			}
+
+			return common.DecodeUploadPackResponse(ioutil.NewReadCloser(r, res.Body), req)
+		}
+
+		var su *packp.ShallowUpdate
+		if !req.Depth.IsZero() {
+			su = &packp.ShallowUpdate{}
+			if err := su.Decode(res.Body); err != nil {
+				_ = res.Body.Close()
+				return nil, fmt.Errorf("error decoding shallow update: %s", err)
+			}
+		}
+
+		if err := g.ReadACKs(res.Body); err != nil {
+			_ = res.Body.Close()
+			return nil, fmt.Errorf("error decoding acks: %s", err)
+		}
+
+		// With no-done, the pack follows the ACKs once the server is ready.
+		if g.Ready() && req.Capabilities.Supports(capability.NoDone) {
+			return common.DecodeNegotiatedResponse(res.Body, req, su)
+		}
+
+		_ = res.Body.Close()
+	}
+}
+
+// FetchesObjects returns if the server only supports the dumb HTTP protocol,
+// its objects being fetched with FetchObjects.
+func (s *upSession) FetchesObjects() bool {
+	return s.dumb
+}
+
+// FetchObjects fetches the objects requested from a server which only
+// supports the dumb HTTP protocol straight into the storer, as loose objects
+// or with the packs containing them.
+func (s *upSession) FetchObjects(
+	ctx context.Context, req *packp.UploadPackRequest, sto storer.Storer,
+) error {
+
+	if !s.dumb {
+		return errNotDumb
+	}
+
+	if req.IsEmpty() {
+		return transport.ErrEmptyUploadPackRequest
+	}
+
+	if err := req.Validate(); err != nil {
+		return err
+	}
+
+	return dumbFetchObjects(ctx, s.session, req, sto)
+}
+
 // Close does nothing.
 func (s *upSession) Close() error {
 	return nil
@@ -93,8 +207,9 @@ func (s *upSession) doRequest(
 
 	applyHeadersToRequest(req, content, s.endpoint.Host, transport.UploadPackServiceName)
 	s.ApplyAuthToRequest(req)
+	s.applyHTTPOptions(req, content)
 
-	res, err := s.client.Do(req.WithContext(ctx))
+	res, err := s.do(ctx, req)
 	if err != nil {
 		return nil, plumbing.NewUnexpectedError(err)
 	}
@@ -125,3 +240,28 @@ func uploadPackRequestToReader(req *packp.UploadPackRequest) (*bytes.Buffer, err
 
 	return buf, nil
 }
+
+// negotiationRequestToReader encodes a round of a negotiation, ending with
+// done if it's the last one.
+func negotiationRequestToReader(req *packp.UploadPackRequest, haves []plumbing.Hash, last bool) (*bytes.Buffer, error) {
+	buf := bytes.NewBuffer(nil)
+	e := pktline.NewEncoder(buf)
+
+	if err := req.UploadRequest.Encode(buf); err != nil {
+		return nil, fmt.Errorf("sending upload-req message: %s", err)
+	}
+
+	if err := common.EncodeHaves(buf, haves); err != nil {
+		return nil, err
+	}
+
+	if last {
+		if err := e.EncodeString("done\n"); err != nil {
+			return nil, err
+		}
+	} else if err := e.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
@@ -311,7 +311,12 @@ func (s *session) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateReq
 
 	if !req.Capabilities.Supports(capability.ReportStatus) {
 		// If we don't have report-status, we can only
-		// check return value error.
+		// check return value error. The server closes its output once the
+		// references are updated, so it's waited for before closing.
+		if _, err := io.Copy(stdioutil.Discard, s.StdoutContext(ctx)); err != nil {
+			return nil, err
+		}
+
 		return nil, s.Command.Close()
 	}
 
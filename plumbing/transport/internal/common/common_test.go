@@ -1,9 +1,21 @@
 package common
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	stdioutil "io/ioutil"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
 )
 
@@ -90,3 +102,102 @@ func (s *CommonSuite) TestCheckNotFoundError(c *C) {
 
 	c.Assert(err, IsNil)
 }
+
+type rejectingReceivePackSession struct {
+	transport.ReceivePackSession
+	req *packp.ReferenceUpdateRequest
+}
+
+func (s *rejectingReceivePackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return packp.NewAdvRefs(), nil
+}
+
+func (s *rejectingReceivePackSession) ReceivePack(_ context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
+	s.req = req
+	return nil, errors.New("rejected")
+}
+
+func (s *CommonSuite) TestServeReceivePackUnreadPackfile(c *C) {
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{{
+		Name: "refs/heads/master",
+		New:  plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	}}
+
+	var in bytes.Buffer
+	c.Assert(req.Encode(&in), IsNil)
+	pack, err := stdioutil.ReadAll(fixtures.Basic().One().Packfile())
+	c.Assert(err, IsNil)
+	in.Write(pack)
+
+	session := &rejectingReceivePackSession{}
+	err = ServeReceivePack(ServerCommand{
+		Stdin:  &in,
+		Stdout: ioutil.WriteNopCloser(&bytes.Buffer{}),
+	}, session)
+	c.Assert(err, ErrorMatches, ".*rejected")
+
+	// the goroutine copying the packfile ends although it was never read
+	select {
+	case <-session.req.Packfile.(*packfileReader).done:
+	case <-time.After(time.Second):
+		c.Fatal("packfile goroutine leaked")
+	}
+}
+
+type countingUploadPackSession struct {
+	transport.UploadPackSession
+	reqs []*packp.UploadPackRequest
+}
+
+func (s *countingUploadPackSession) AdvertisedReferences() (*packp.AdvRefs, error) {
+	return packp.NewAdvRefs(), nil
+}
+
+func (s *countingUploadPackSession) UploadPack(_ context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
+	s.reqs = append(s.reqs, req)
+	return packp.NewUploadPackResponseWithPackfile(req, stdioutil.NopCloser(bytes.NewBufferString("PACK"))), nil
+}
+
+type shallowUploadPackSession struct {
+	countingUploadPackSession
+	update *packp.ShallowUpdate
+}
+
+func (s *shallowUploadPackSession) ShallowUpdate(context.Context, *packp.UploadPackRequest) (*packp.ShallowUpdate, error) {
+	return s.update, nil
+}
+
+func (s *CommonSuite) TestServeUploadPackShallow(c *C) {
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	have := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+
+	input := func() *bytes.Buffer {
+		req := packp.NewUploadRequest()
+		req.Wants = []plumbing.Hash{head}
+		req.Depth = packp.DepthCommits(1)
+
+		var in bytes.Buffer
+		c.Assert(req.Encode(&in), IsNil)
+		c.Assert(EncodeHaves(&in, []plumbing.Hash{have}), IsNil)
+		in.WriteString("0009done\n")
+		return &in
+	}
+
+	session := &shallowUploadPackSession{update: &packp.ShallowUpdate{Shallows: []plumbing.Hash{head}}}
+	var out bytes.Buffer
+	err := ServeUploadPack(ServerCommand{Stdin: input(), Stdout: ioutil.WriteNopCloser(&out)}, session)
+	c.Assert(err, IsNil)
+
+	// the shallow update is sent before the haves are read, and the packfile
+	// only computed once
+	c.Assert(session.reqs, HasLen, 1)
+	c.Assert(session.reqs[0].Haves, DeepEquals, []plumbing.Hash{have})
+	c.Assert(strings.HasSuffix(out.String(), "shallow "+head.String()+"\n00000008NAK\nPACK"), Equals, true)
+
+	err = ServeUploadPack(ServerCommand{
+		Stdin:  input(),
+		Stdout: ioutil.WriteNopCloser(&bytes.Buffer{}),
+	}, &countingUploadPackSession{})
+	c.Assert(err, Equals, ErrShallowUpdateNotSupported)
+}
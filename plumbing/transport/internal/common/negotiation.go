@@ -0,0 +1,268 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const (
+	// initialFlush is the number of haves sent in the first round.
+	initialFlush = 16
+	// pipeSafeFlush is the largest number of haves sent in a round by the
+	// stateful transports.
+	pipeSafeFlush = 32
+	// largeFlush is the number of haves from which the rounds of the
+	// stateless transports grow slower.
+	largeFlush = 16384
+	// maxInVain is the number of haves sent since the last ACK from which the
+	// negotiation is given up, once the server found a common commit.
+	maxInVain = 256
+)
+
+// Negotiation is the negotiation of the haves of an upload-pack request with
+// a server supporting multi_ack_detailed, as git-fetch-pack does: the haves
+// given by the negotiator are sent in rounds of growing size, the server
+// acknowledging the common ones after each of them.
+type Negotiation struct {
+	n         transport.Negotiator
+	stateless bool
+
+	count, flushAt, inVain int
+	gotContinue, gotReady  bool
+
+	// Common are the commits acknowledged as common by the server, which must
+	// be sent again in each round by the stateless transports.
+	Common []plumbing.Hash
+}
+
+// NewNegotiation returns a new Negotiation using the given negotiator. A
+// stateless negotiation is the one of a transport starting a new session for
+// each round, as HTTP.
+func NewNegotiation(n transport.Negotiator, stateless bool) *Negotiation {
+	return &Negotiation{n: n, stateless: stateless, flushAt: initialFlush}
+}
+
+// Haves returns the haves of the next round. True is returned if it's the
+// last round, the negotiator having no commit left, the server being ready
+// or too many commits having been sent in vain.
+func (g *Negotiation) Haves() ([]plumbing.Hash, bool, error) {
+	if g.gotReady || (g.gotContinue && g.inVain > maxInVain) {
+		return nil, true, nil
+	}
+
+	var haves []plumbing.Hash
+	for g.count < g.flushAt {
+		h, err := g.n.Next()
+		if err == io.EOF {
+			return haves, true, nil
+		}
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		haves = append(haves, h)
+		g.count++
+		g.inVain++
+	}
+
+	g.flushAt = g.nextFlush()
+	return haves, false, nil
+}
+
+func (g *Negotiation) nextFlush() int {
+	if g.stateless {
+		if g.count < largeFlush {
+			return g.count * 2
+		}
+
+		return g.count * 11 / 10
+	}
+
+	if g.count < pipeSafeFlush {
+		return g.count * 2
+	}
+
+	return g.count + pipeSafeFlush
+}
+
+// Ready returns true if the server told it has found enough common commits.
+func (g *Negotiation) Ready() bool {
+	return g.gotReady
+}
+
+// ReadACKs reads the ACKs sent by the server for a round, up to its NAK.
+func (g *Negotiation) ReadACKs(r io.Reader) error {
+	s := pktline.NewScanner(r)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\n"))
+		if bytes.Equal(line, []byte("NAK")) {
+			return nil
+		}
+
+		if err := g.readACK(line); err != nil {
+			return err
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return err
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
+func (g *Negotiation) readACK(line []byte) error {
+	if bytes.HasPrefix(line, []byte("ERR ")) {
+		return fmt.Errorf("remote error: %s", line[4:])
+	}
+
+	fields := bytes.Fields(line)
+	if len(fields) != 3 || !bytes.Equal(fields[0], []byte("ACK")) || len(fields[1]) != 40 {
+		return fmt.Errorf("unexpected negotiation line %q", line)
+	}
+
+	h := plumbing.NewHash(string(fields[1]))
+	status := string(fields[2])
+	switch status {
+	case "common", "ready", "continue":
+	default:
+		return fmt.Errorf("unexpected negotiation line %q", line)
+	}
+
+	known, err := g.n.Ack(h)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case g.stateless && status == "common" && !known:
+		g.Common = append(g.Common, h)
+		g.inVain = 0
+	case !g.stateless || status != "common":
+		g.inVain = 0
+	}
+
+	g.gotContinue = true
+	if status == "ready" {
+		g.gotReady = true
+	}
+
+	return nil
+}
+
+// EncodeHaves encodes the have lines of the given commits, in order.
+func EncodeHaves(w io.Writer, haves []plumbing.Hash) error {
+	e := pktline.NewEncoder(w)
+	for _, h := range haves {
+		if err := e.Encodef("have %s\n", h); err != nil {
+			return fmt.Errorf("sending haves message: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// DecodeNegotiatedResponse decodes the response following the negotiation of
+// req, whose shallow update was read already, if any.
+func DecodeNegotiatedResponse(r io.ReadCloser, req *packp.UploadPackRequest, su *packp.ShallowUpdate) (
+	*packp.UploadPackResponse, error,
+) {
+	if su == nil {
+		return DecodeUploadPackResponse(r, req)
+	}
+
+	shallowless := *req
+	shallowless.Depth = packp.DepthCommits(0)
+
+	res, err := DecodeUploadPackResponse(r, &shallowless)
+	if err != nil {
+		return nil, err
+	}
+
+	res.ShallowUpdate = *su
+	return res, nil
+}
+
+// NegotiatePack performs an upload-pack request negotiating its haves with
+// the server, in several rounds in the same session.
+func (s *session) NegotiatePack(ctx context.Context, req *packp.UploadPackRequest, n transport.Negotiator) (
+	*packp.UploadPackResponse, error,
+) {
+	if len(req.Wants) == 0 {
+		return s.UploadPack(ctx, req)
+	}
+
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.AdvertisedReferencesContext(ctx); err != nil {
+		return nil, err
+	}
+
+	s.packRun = true
+
+	in := s.StdinContext(ctx)
+	out := s.StdoutContext(ctx)
+
+	if err := req.UploadRequest.Encode(in); err != nil {
+		return nil, fmt.Errorf("sending upload-req message: %s", err)
+	}
+
+	// The server sends the shallow update before reading the haves.
+	var su *packp.ShallowUpdate
+	if !req.Depth.IsZero() {
+		su = &packp.ShallowUpdate{}
+		if err := su.Decode(out); err != nil {
+			return nil, fmt.Errorf("error decoding shallow update: %s", err)
+		}
+	}
+
+	g := NewNegotiation(n, false)
+	for {
+		haves, last, err := g.Haves()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := EncodeHaves(in, haves); err != nil {
+			return nil, err
+		}
+
+		if last {
+			break
+		}
+
+		if err := pktline.NewEncoder(in).Flush(); err != nil {
+			return nil, fmt.Errorf("sending flush-pkt after haves: %s", err)
+		}
+
+		if err := g.ReadACKs(out); err != nil {
+			return nil, fmt.Errorf("error decoding acks: %s", err)
+		}
+	}
+
+	if err := sendDone(in); err != nil {
+		return nil, fmt.Errorf("sending done message: %s", err)
+	}
+
+	if err := in.Close(); err != nil {
+		return nil, fmt.Errorf("closing input: %s", err)
+	}
+
+	r, err := ioutil.NonEmptyReader(out)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeNegotiatedResponse(ioutil.NewReadCloser(r, s), req, su)
+}
@@ -0,0 +1,131 @@
+package common
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+
+	. "gopkg.in/check.v1"
+)
+
+type NegotiationSuite struct{}
+
+var _ = Suite(&NegotiationSuite{})
+
+// countNegotiator sends n commits, recording the ACKs.
+type countNegotiator struct {
+	n    int
+	acks map[plumbing.Hash]bool
+}
+
+func (n *countNegotiator) Next() (plumbing.Hash, error) {
+	if n.n == 0 {
+		return plumbing.ZeroHash, io.EOF
+	}
+
+	n.n--
+	return plumbing.ComputeHash(plumbing.CommitObject, []byte{byte(n.n)}), nil
+}
+
+func (n *countNegotiator) Ack(h plumbing.Hash) (bool, error) {
+	if n.acks == nil {
+		n.acks = make(map[plumbing.Hash]bool)
+	}
+
+	known := n.acks[h]
+	n.acks[h] = true
+	return known, nil
+}
+
+func (s *NegotiationSuite) rounds(c *C, g *Negotiation) []int {
+	var rounds []int
+	for {
+		haves, last, err := g.Haves()
+		c.Assert(err, IsNil)
+
+		rounds = append(rounds, len(haves))
+		if last {
+			return rounds
+		}
+	}
+}
+
+func (s *NegotiationSuite) TestHavesStateful(c *C) {
+	g := NewNegotiation(&countNegotiator{n: 150}, false)
+	c.Assert(s.rounds(c, g), DeepEquals, []int{16, 16, 32, 32, 32, 22})
+}
+
+func (s *NegotiationSuite) TestHavesStateless(c *C) {
+	g := NewNegotiation(&countNegotiator{n: 150}, true)
+	c.Assert(s.rounds(c, g), DeepEquals, []int{16, 16, 32, 64, 22})
+}
+
+func (s *NegotiationSuite) TestHavesInVain(c *C) {
+	g := NewNegotiation(&countNegotiator{n: 1000}, false)
+
+	haves, last, err := g.Haves()
+	c.Assert(err, IsNil)
+	c.Assert(last, Equals, false)
+
+	in := acks("ACK " + haves[0].String() + " common")
+	c.Assert(g.ReadACKs(in), IsNil)
+
+	// The negotiation is given up once more than 256 haves are sent in vain.
+	c.Assert(s.rounds(c, g), DeepEquals, []int{16, 32, 32, 32, 32, 32, 32, 32, 32, 0})
+}
+
+func (s *NegotiationSuite) TestReadACKs(c *C) {
+	n := &countNegotiator{n: 16}
+	g := NewNegotiation(n, true)
+
+	haves, _, err := g.Haves()
+	c.Assert(err, IsNil)
+
+	in := acks(
+		"ACK "+haves[0].String()+" common",
+		"ACK "+haves[1].String()+" continue",
+		"ACK "+haves[0].String()+" common",
+	)
+
+	c.Assert(g.ReadACKs(in), IsNil)
+	c.Assert(g.Common, DeepEquals, []plumbing.Hash{haves[0]})
+	c.Assert(g.Ready(), Equals, false)
+	c.Assert(n.acks, HasLen, 2)
+
+	in = acks("ACK " + haves[2].String() + " ready")
+	c.Assert(g.ReadACKs(in), IsNil)
+	c.Assert(g.Ready(), Equals, true)
+
+	haves, last, err := g.Haves()
+	c.Assert(err, IsNil)
+	c.Assert(haves, HasLen, 0)
+	c.Assert(last, Equals, true)
+}
+
+func (s *NegotiationSuite) TestReadACKsErrors(c *C) {
+	g := NewNegotiation(&countNegotiator{}, false)
+
+	c.Assert(g.ReadACKs(acks("ERR foo")), ErrorMatches, "remote error: foo")
+	c.Assert(g.ReadACKs(acks("ACK foo common")), ErrorMatches, "unexpected negotiation line.*")
+	c.Assert(g.ReadACKs(acks("ACK "+plumbing.ZeroHash.String())), ErrorMatches, "unexpected negotiation line.*")
+	c.Assert(g.ReadACKs(acks("ACK "+plumbing.ZeroHash.String()+" foo")), ErrorMatches, "unexpected negotiation line.*")
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(pktline.NewEncoder(buf).EncodeString("ACK "+plumbing.ZeroHash.String()+" common\n"), IsNil)
+	c.Assert(g.ReadACKs(buf), Equals, io.ErrUnexpectedEOF)
+}
+
+// acks returns the given lines, followed by a NAK.
+func acks(lines ...string) io.Reader {
+	buf := bytes.NewBuffer(nil)
+	e := pktline.NewEncoder(buf)
+	for _, l := range append(lines, "NAK") {
+		if err := e.EncodeString(l + "\n"); err != nil {
+			panic(err)
+		}
+	}
+
+	return buf
+}
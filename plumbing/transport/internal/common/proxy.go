@@ -0,0 +1,150 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"golang.org/x/net/proxy"
+)
+
+// NewProxyDialer returns a dialer connecting through the proxy of the given
+// options: with CONNECT for an HTTP(S) proxy, or SOCKS5.
+func NewProxyDialer(opts transport.ProxyOptions) (proxy.ContextDialer, error) {
+	u, err := opts.FullURL()
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &connectDialer{proxy: u}, nil
+	}
+
+	var auth *proxy.Auth
+	if u.User != nil {
+		password, _ := u.User.Password()
+		auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+
+	d, err := proxy.SOCKS5("tcp", hostWithDefaultPort(u, "1080"), auth, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported proxy dialer: %T", d)
+	}
+
+	return cd, nil
+}
+
+func hostWithDefaultPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// connectDialer connects through an HTTP(S) proxy, tunneling the connection
+// with a CONNECT request.
+type connectDialer struct {
+	proxy *url.URL
+}
+
+func (d *connectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	port := "80"
+	if d.proxy.Scheme == "https" {
+		port = "443"
+	}
+
+	var nd net.Dialer
+	conn, err := nd.DialContext(ctx, "tcp", hostWithDefaultPort(d.proxy, port))
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	if d.proxy.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: d.proxy.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+
+		conn = tc
+	}
+
+	br, err := d.connect(conn, addr)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return &bufferedConn{Conn: conn, r: br}, nil
+}
+
+// connect sends the CONNECT request, returning the reader of the tunneled
+// connection since the response may be read along with the first bytes sent
+// by the server.
+func (d *connectDialer) connect(conn net.Conn, addr string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+
+	if u := d.proxy.User; u != nil {
+		password, _ := u.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// The body isn't read, the connection being either tunneled or closed.
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("proxy: CONNECT %s: %s", addr, res.Status)
+	}
+
+	return br, nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -1,15 +1,33 @@
 package common
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	stdioutil "io/ioutil"
 
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 )
 
+// ErrShallowUpdateNotSupported is returned by ServeUploadPack when the request
+// has a depth and the session can't compute its shallow update.
+var ErrShallowUpdateNotSupported = errors.New("shallow update not supported by the session")
+
+// shallowUpdater is implemented by the upload-pack sessions computing the
+// shallow update of a request apart from its packfile.
+type shallowUpdater interface {
+	ShallowUpdate(context.Context, *packp.UploadPackRequest) (*packp.ShallowUpdate, error)
+}
+
 // ServerCommand is used for a single server command execution.
 type ServerCommand struct {
 	Stderr io.Writer
@@ -34,13 +52,83 @@ func ServeUploadPack(cmd ServerCommand, s transport.UploadPackSession) (err erro
 		return err
 	}
 
-	var resp *packp.UploadPackResponse
-	resp, err = s.UploadPack(context.TODO(), req)
+	// git doesn't request the shallow capability along with a depth or its
+	// shallow commits, it's implied by them.
+	if (!req.Depth.IsZero() || len(req.Shallows) != 0) &&
+		!req.Capabilities.Supports(capability.Shallow) {
+		if err := req.Capabilities.Set(capability.Shallow); err != nil {
+			return err
+		}
+	}
+
+	if !req.Depth.IsZero() {
+		// The client waits for the shallow update before sending its haves,
+		// and it doesn't depend on them.
+		su, ok := s.(shallowUpdater)
+		if !ok {
+			return ErrShallowUpdateNotSupported
+		}
+
+		update, err := su.ShallowUpdate(context.TODO(), req)
+		if err != nil {
+			return err
+		}
+
+		if err := update.Encode(cmd.Stdout); err != nil {
+			return err
+		}
+	}
+
+	if err := decodeHaves(cmd.Stdin, cmd.Stdout, req); err != nil {
+		return err
+	}
+
+	resp, err := s.UploadPack(context.TODO(), req)
 	if err != nil {
 		return err
 	}
 
-	return resp.Encode(cmd.Stdout)
+	if req.Depth.IsZero() {
+		return resp.Encode(cmd.Stdout)
+	}
+
+	defer ioutil.CheckClose(resp, &err)
+	if err := resp.ServerResponse.Encode(cmd.Stdout, false); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(cmd.Stdout, resp)
+	return err
+}
+
+// decodeHaves reads the haves of the client until it's done. No common
+// object is acknowledged, so a NAK is sent on each flush, as git upload-pack
+// does until it finds one.
+func decodeHaves(r io.Reader, w io.Writer, req *packp.UploadPackRequest) error {
+	s := pktline.NewScanner(r)
+	e := pktline.NewEncoder(w)
+	for s.Scan() {
+		line := bytes.TrimSuffix(s.Bytes(), []byte("\n"))
+		switch {
+		case len(line) == 0:
+			if err := e.Encodef("NAK\n"); err != nil {
+				return err
+			}
+		case bytes.HasPrefix(line, []byte("have ")):
+			h := line[len("have "):]
+			if !plumbing.IsHash(string(h)) {
+				return fmt.Errorf("invalid have line: %q", line)
+			}
+
+			req.Haves = append(req.Haves, plumbing.NewHash(string(h)))
+		case bytes.Equal(line, []byte("done")):
+			return nil
+		default:
+			return fmt.Errorf("unexpected line: %q", line)
+		}
+	}
+
+	return s.Err()
 }
 
 func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
@@ -58,9 +146,43 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 		return fmt.Errorf("error decoding: %s", err)
 	}
 
+	// The packfile is only sent when a reference is created or updated, and
+	// the client may not close its side of the connection after sending it.
+	req.Packfile = nil
+	for _, c := range req.Commands {
+		if c.Action() != packp.Delete {
+			pack := newPackfileReader(cmd.Stdin)
+			// The session may not read the packfile, if it rejects the
+			// request, closing it stops the goroutine copying it.
+			defer pack.Close()
+			req.Packfile = pack
+			break
+		}
+	}
+
+	var out io.Writer = cmd.Stdout
+	var mux *sideband.Muxer
+	switch {
+	case req.Capabilities.Supports(capability.Sideband64k):
+		mux = sideband.NewMuxer(sideband.Sideband64k, cmd.Stdout)
+	case req.Capabilities.Supports(capability.Sideband):
+		mux = sideband.NewMuxer(sideband.Sideband, cmd.Stdout)
+	}
+
+	if mux != nil {
+		out = mux
+		req.Progress = &progressWriter{mux}
+	}
+
 	rs, err := s.ReceivePack(context.TODO(), req)
 	if rs != nil {
-		if err := rs.Encode(cmd.Stdout); err != nil {
+		if err := rs.Encode(out); err != nil {
+			return fmt.Errorf("error in encoding report status %s", err)
+		}
+	}
+
+	if mux != nil {
+		if _, err := cmd.Stdout.Write(pktline.FlushPkt); err != nil {
 			return fmt.Errorf("error in encoding report status %s", err)
 		}
 	}
@@ -71,3 +193,55 @@ func ServeReceivePack(cmd ServerCommand, s transport.ReceivePackSession) error {
 
 	return nil
 }
+
+// packfileReader is a reader of the packfile read from a connection, copied
+// by a goroutine until its checksum. Closing it makes the goroutine stop at its
+// next write, done is closed once it has.
+type packfileReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+// newPackfileReader returns a reader of the packfile read from r, ending with
+// its checksum instead of waiting for the end of r.
+func newPackfileReader(r io.Reader) *packfileReader {
+	pr, pw := io.Pipe()
+	p := &packfileReader{PipeReader: pr, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		pw.CloseWithError(scanPackfile(io.TeeReader(r, pw)))
+	}()
+
+	return p
+}
+
+func scanPackfile(r io.Reader) error {
+	s := packfile.NewScanner(r)
+	_, objects, err := s.Header()
+	if err != nil {
+		return err
+	}
+
+	for i := uint32(0); i < objects; i++ {
+		if _, err := s.NextObjectHeader(); err != nil {
+			return err
+		}
+
+		if _, _, err := s.NextObject(stdioutil.Discard); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.Checksum()
+	return err
+}
+
+// progressWriter writes the progress messages of a receive-pack in the
+// progress channel of a sideband.
+type progressWriter struct {
+	m *sideband.Muxer
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	return w.m.WriteChannel(sideband.ProgressMessage, p)
+}
@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const hooksPath = "hooks"
+
+// Push is a push received by a server, given to its hooks.
+type Push struct {
+	// Commands are the reference updates of the push. Those given to a
+	// PostReceiveHook are the applied ones.
+	Commands []*packp.Command
+	// Options are the push options sent by the client.
+	Options []*packp.Option
+	// PushCert is the push certificate sent by the client, if any.
+	PushCert *PushCert
+	// Storer is the storage of the repository. During the PreReceiveHook,
+	// the objects of the push are quarantined in it: they can be read, but
+	// they're discarded if the push is rejected.
+	Storer storer.Storer
+	// Progress is the output of the hooks, sent to the client along with the
+	// progress of the push.
+	Progress io.Writer
+}
+
+// PreReceiveHook is run once the objects of a push are received, before any
+// reference is updated. The whole push is rejected if it returns an error,
+// the error being the status of every command.
+type PreReceiveHook interface {
+	PreReceive(ctx context.Context, p *Push) error
+}
+
+// PreReceiveHookFunc is a function implementing PreReceiveHook.
+type PreReceiveHookFunc func(ctx context.Context, p *Push) error
+
+// PreReceive calls f(ctx, p).
+func (f PreReceiveHookFunc) PreReceive(ctx context.Context, p *Push) error {
+	return f(ctx, p)
+}
+
+// UpdateHook is run for every command of a push before its reference is
+// updated. The command is rejected if it returns an error, the error being
+// its status.
+type UpdateHook interface {
+	Update(ctx context.Context, p *Push, cmd *packp.Command) error
+}
+
+// UpdateHookFunc is a function implementing UpdateHook.
+type UpdateHookFunc func(ctx context.Context, p *Push, cmd *packp.Command) error
+
+// Update calls f(ctx, p, cmd).
+func (f UpdateHookFunc) Update(ctx context.Context, p *Push, cmd *packp.Command) error {
+	return f(ctx, p, cmd)
+}
+
+// PostReceiveHook is run once the references of a push are updated, with the
+// applied commands. It isn't run if none was applied.
+type PostReceiveHook interface {
+	PostReceive(ctx context.Context, p *Push)
+}
+
+// PostReceiveHookFunc is a function implementing PostReceiveHook.
+type PostReceiveHookFunc func(ctx context.Context, p *Push)
+
+// PostReceive calls f(ctx, p).
+func (f PostReceiveHookFunc) PostReceive(ctx context.Context, p *Push) {
+	f(ctx, p)
+}
+
+// receiveHooks runs the pre-receive, update and post-receive hook scripts of
+// a repository stored in the filesystem of the operating system, as git
+// receive-pack does. Their output is written to the progress of the push.
+type receiveHooks struct {
+	// fs is the filesystem of the repository, nil if it isn't stored in a
+	// filesystem or the hook scripts are disabled.
+	fs       billy.Filesystem
+	push     *Push
+	progress io.Writer
+	// withOptions is set if the client sent push options.
+	withOptions bool
+	// quarantine gives the quarantined objects of the push to the hooks run
+	// before they're migrated.
+	quarantine []string
+}
+
+// newReceiveHooks returns the hooks of the repository for a push, none if
+// enabled is false, the push-options capability telling whether the client
+// sent options.
+func newReceiveHooks(s storer.Storer, p *Push, enabled, withOptions bool) *receiveHooks {
+	h := &receiveHooks{push: p, progress: p.Progress, withOptions: withOptions}
+	if h.progress == nil {
+		h.progress = ioutil.Discard
+	}
+
+	if !enabled {
+		return h
+	}
+
+	type fsBased interface {
+		Filesystem() billy.Filesystem
+	}
+
+	if fs, ok := s.(fsBased); ok {
+		h.fs = fs.Filesystem()
+	}
+
+	return h
+}
+
+// path returns the path of the hook with the given name, if it's installed.
+// The hook must exist in both the repository and the operating system
+// filesystems, since the storage may not be an OS one.
+func (h *receiveHooks) path(name string) (string, bool) {
+	if h.fs == nil {
+		return "", false
+	}
+
+	if _, err := h.fs.Stat(h.fs.Join(hooksPath, name)); err != nil {
+		return "", false
+	}
+
+	path := filepath.Join(h.fs.Root(), hooksPath, name)
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return "", false
+	}
+
+	return path, true
+}
+
+// has returns if the hook with the given name is installed.
+func (h *receiveHooks) has(name string) bool {
+	_, ok := h.path(name)
+	return ok
+}
+
+// env returns the environment variables describing the push certificate and
+// options, if any, and the quarantine.
+func (h *receiveHooks) env() []string {
+	var env []string
+	if h.push.PushCert != nil {
+		env = h.push.PushCert.env()
+	}
+
+	if h.withOptions {
+		env = append(env, optionsEnv(h.push.Options)...)
+	}
+
+	return append(env, h.quarantine...)
+}
+
+// run runs the hook with the given name, if it's installed, killing it if
+// ctx is done before it exits.
+func (h *receiveHooks) run(ctx context.Context, name string, stdin io.Reader, args ...string) (bool, error) {
+	path, ok := h.path(name)
+	if !ok {
+		return false, nil
+	}
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Dir = h.fs.Root()
+	cmd.Env = append(append(os.Environ(), "GIT_DIR=."), h.env()...)
+	cmd.Stdin = stdin
+	cmd.Stdout = h.progress
+	cmd.Stderr = h.progress
+
+	return true, cmd.Run()
+}
+
+// preReceive runs the pre-receive hook with the commands, returning
+// ErrPreReceiveDeclined if the hook fails.
+func (h *receiveHooks) preReceive(ctx context.Context, cmds []*packp.Command) error {
+	ran, err := h.run(ctx, "pre-receive", commandsInput(cmds))
+	if ran && err != nil {
+		return ErrPreReceiveDeclined
+	}
+
+	return nil
+}
+
+// update runs the update hook for a command, returning ErrHookDeclined if the
+// hook fails.
+func (h *receiveHooks) update(ctx context.Context, cmd *packp.Command) error {
+	ran, err := h.run(ctx, "update", nil,
+		cmd.Name.String(), cmd.Old.String(), cmd.New.String(),
+	)
+
+	if ran && err != nil {
+		return ErrHookDeclined
+	}
+
+	return nil
+}
+
+// postReceive runs the post-receive hook with the applied commands, its
+// result being ignored.
+func (h *receiveHooks) postReceive(ctx context.Context, cmds []*packp.Command) {
+	if len(cmds) == 0 {
+		return
+	}
+
+	_, _ = h.run(ctx, "post-receive", commandsInput(cmds))
+}
+
+func commandsInput(cmds []*packp.Command) io.Reader {
+	buf := bytes.NewBuffer(nil)
+	for _, cmd := range cmds {
+		fmt.Fprintf(buf, "%s %s %s\n", cmd.Old, cmd.New, cmd.Name)
+	}
+
+	return buf
+}
+
+// optionsEnv returns the environment variables describing the push options,
+// given to the hook scripts.
+func optionsEnv(opts []*packp.Option) []string {
+	env := []string{fmt.Sprintf("GIT_PUSH_OPTION_COUNT=%d", len(opts))}
+	for i, opt := range opts {
+		v := opt.Key
+		if opt.Value != "" {
+			v += "=" + opt.Value
+		}
+
+		env = append(env, fmt.Sprintf("GIT_PUSH_OPTION_%d=%s", i, v))
+	}
+
+	return env
+}
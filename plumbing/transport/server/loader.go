@@ -1,6 +1,11 @@
 package server
 
 import (
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
 	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
@@ -10,6 +15,11 @@ import (
 	"github.com/go-git/go-billy/v5/osfs"
 )
 
+const (
+	gitDirPrefix      = "gitdir:"
+	maxGitDirFileSize = 4096
+)
+
 // DefaultLoader is a filesystem loader ignoring host and resolving paths to /.
 var DefaultLoader = NewFilesystemLoader(osfs.New(""))
 
@@ -32,19 +42,80 @@ func NewFilesystemLoader(base billy.Filesystem) Loader {
 }
 
 // Load looks up the endpoint's path in the base file system and returns a
-// storer for it. Returns transport.ErrRepositoryNotFound if a repository does
-// not exist in the given path.
+// storer for it. As git does, the path may be the one of the git directory or
+// of the worktree, and the ".git" suffix may be omitted. Returns
+// transport.ErrRepositoryNotFound if a repository does not exist in the given
+// path.
 func (l *fsLoader) Load(ep *transport.Endpoint) (storer.Storer, error) {
-	fs, err := l.base.Chroot(ep.Path)
+	for _, suffix := range gitDirSuffixes {
+		fs, err := l.gitDir(ep.Path + suffix)
+		if err != nil {
+			return nil, err
+		}
+
+		if fs != nil {
+			return filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil
+		}
+	}
+
+	return nil, transport.ErrRepositoryNotFound
+}
+
+// gitDirSuffixes are the suffixes tried in order to find the git directory
+// of a path.
+var gitDirSuffixes = []string{"/.git", "", ".git/.git", ".git"}
+
+// gitDir returns the git directory at path, following a gitdir file, or nil
+// if there is none.
+func (l *fsLoader) gitDir(path string) (billy.Filesystem, error) {
+	fi, err := l.base.Stat(path)
+	if err != nil {
+		return nil, nil
+	}
+
+	if !fi.IsDir() {
+		if path, err = l.readGitDirFile(path); err != nil || path == "" {
+			return nil, err
+		}
+	}
+
+	fs, err := l.base.Chroot(path)
 	if err != nil {
 		return nil, err
 	}
 
 	if _, err := fs.Stat("config"); err != nil {
-		return nil, transport.ErrRepositoryNotFound
+		return nil, nil
+	}
+
+	return fs, nil
+}
+
+// readGitDirFile returns the path of the git directory of a "gitdir: <path>"
+// file, relative to the directory of the file, or empty if it isn't one.
+func (l *fsLoader) readGitDirFile(path string) (string, error) {
+	f, err := l.base.Open(path)
+	if err != nil {
+		return "", nil
+	}
+
+	defer f.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(f, maxGitDirFileSize))
+	if err != nil {
+		return "", err
+	}
+
+	line := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(line, gitDirPrefix) {
+		return "", nil
+	}
+
+	dir := strings.TrimSpace(line[len(gitDirPrefix):])
+	if !filepath.IsAbs(dir) {
+		dir = l.base.Join(filepath.Dir(path), dir)
 	}
 
-	return filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil
+	return dir, nil
 }
 
 // MapLoader is a Loader that uses a lookup map of storer.Storer by
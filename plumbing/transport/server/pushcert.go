@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const (
+	receiveSection   = "receive"
+	certNonceSeedKey = "certNonceSeed"
+	certNonceSlopKey = "certNonceSlop"
+)
+
+// PushCertStatus is the status of the signature of a push certificate, as
+// the GIT_PUSH_CERT_STATUS given to the git hooks.
+type PushCertStatus string
+
+const (
+	// PushCertGood is the status of a good signature.
+	PushCertGood PushCertStatus = "G"
+	// PushCertBad is the status of a bad signature.
+	PushCertBad PushCertStatus = "B"
+	// PushCertUnchecked is the status of a signature which can't be checked,
+	// its key not being known.
+	PushCertUnchecked PushCertStatus = "E"
+	// PushCertUnsigned is the status of a certificate without signature.
+	PushCertUnsigned PushCertStatus = "N"
+)
+
+// NonceStatus is the status of the nonce of a push certificate, as the
+// GIT_PUSH_CERT_NONCE_STATUS given to the git hooks.
+type NonceStatus string
+
+const (
+	// NonceOK is the status of the nonce advertised by the server, or of
+	// one issued by it less than receive.certNonceSlop seconds before.
+	NonceOK NonceStatus = "OK"
+	// NonceSlop is the status of a nonce issued by the server at another
+	// time than the push, see PushCert.NonceSlop.
+	NonceSlop NonceStatus = "SLOP"
+	// NonceBad is the status of a nonce not issued by the server.
+	NonceBad NonceStatus = "BAD"
+	// NonceMissing is the status of a certificate without nonce.
+	NonceMissing NonceStatus = "MISSING"
+	// NonceUnsolicited is the status of a nonce sent to a server not asking
+	// for one, receive.certNonceSeed being unset.
+	NonceUnsolicited NonceStatus = "UNSOLICITED"
+)
+
+// PushCert is a push certificate received by a server, with the result of
+// its verification. It's given to the hooks, which decide whether the push
+// is accepted, as in git.
+type PushCert struct {
+	*packp.PushCert
+	// Hash is the hash of the blob the certificate is stored in, keeping a
+	// record of the push.
+	Hash plumbing.Hash
+	// Status is the status of the signature.
+	Status PushCertStatus
+	// Signer is the entity which signed the certificate, if its status is
+	// PushCertGood.
+	Signer *openpgp.Entity
+	// NonceStatus is the status of the nonce.
+	NonceStatus NonceStatus
+	// NonceSlop is the number of seconds between the time the server issued
+	// the nonce and the time of the push.
+	NonceSlop int64
+}
+
+// env returns the environment variables describing the certificate, given to
+// the hook scripts.
+func (c *PushCert) env() []string {
+	env := []string{
+		"GIT_PUSH_CERT=" + c.Hash.String(),
+		"GIT_PUSH_CERT_STATUS=" + string(c.Status),
+	}
+
+	if c.Signer != nil {
+		if id := c.Signer.PrimaryIdentity(); id != nil {
+			env = append(env, "GIT_PUSH_CERT_SIGNER="+id.Name)
+		}
+
+		env = append(env, "GIT_PUSH_CERT_KEY="+c.Signer.PrimaryKey.KeyIdString())
+	}
+
+	if c.Nonce != "" {
+		env = append(env, "GIT_PUSH_CERT_NONCE="+c.Nonce)
+	}
+
+	env = append(env, "GIT_PUSH_CERT_NONCE_STATUS="+string(c.NonceStatus))
+	if c.NonceSlop != 0 {
+		env = append(env, fmt.Sprintf("GIT_PUSH_CERT_NONCE_SLOP=%d", c.NonceSlop))
+	}
+
+	return env
+}
+
+// pushCertNonce returns the nonce issued at the given time, signed with the
+// seed so the server can check it was issued by it.
+func pushCertNonce(seed string, stamp int64) string {
+	mac := hmac.New(sha1.New, []byte(seed))
+	fmt.Fprintf(mac, "%d", stamp)
+	return fmt.Sprintf("%d-%x", stamp, mac.Sum(nil))
+}
+
+func nonceStamp(nonce string) (int64, bool) {
+	i := strings.IndexByte(nonce, '-')
+	if i == -1 {
+		return 0, false
+	}
+
+	stamp, err := strconv.ParseInt(nonce[:i], 10, 64)
+	return stamp, err == nil
+}
+
+// pushCertConfig is the configuration of the push certificates of a
+// repository.
+type pushCertConfig struct {
+	seed string
+	slop int64
+}
+
+// loadPushCertConfig loads the configuration from the repository, if its
+// storage has one.
+func loadPushCertConfig(s storer.Storer) (*pushCertConfig, error) {
+	cs, ok := s.(config.ConfigStorer)
+	if !ok {
+		return &pushCertConfig{}, nil
+	}
+
+	cfg, err := cs.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	section := cfg.Raw.Section(receiveSection)
+	c := &pushCertConfig{seed: section.Option(certNonceSeedKey)}
+	if slop := section.Option(certNonceSlopKey); slop != "" {
+		if c.slop, err = strconv.ParseInt(slop, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s.%s: %s", receiveSection, certNonceSlopKey, err)
+		}
+	}
+
+	return c, nil
+}
+
+// newNonce returns a new nonce, empty if the push certificates aren't asked
+// for.
+func (c *pushCertConfig) newNonce() string {
+	if c.seed == "" {
+		return ""
+	}
+
+	return pushCertNonce(c.seed, time.Now().Unix())
+}
+
+// checkNonce returns the status and slop of the nonce of a certificate, the
+// server having advertised the given one. A stateless server, as the HTTP
+// ones, can't compare them since it advertised the nonce to the client in
+// another session: it accepts its own nonces issued within the slop.
+func (c *pushCertConfig) checkNonce(nonce, advertised string, stateless bool) (NonceStatus, int64) {
+	switch {
+	case advertised == "":
+		return NonceUnsolicited, 0
+	case nonce == "":
+		return NonceMissing, 0
+	case nonce == advertised:
+		return NonceOK, 0
+	case !stateless:
+		return NonceBad, 0
+	}
+
+	stamp, ok := nonceStamp(nonce)
+	if !ok || !hmac.Equal([]byte(pushCertNonce(c.seed, stamp)), []byte(nonce)) {
+		return NonceBad, 0
+	}
+
+	now, _ := nonceStamp(advertised)
+	slop := now - stamp
+	if c.slop != 0 && slop <= c.slop && -slop <= c.slop {
+		return NonceOK, slop
+	}
+
+	return NonceSlop, slop
+}
+
+// receivePushCert stores the certificate of a push as a blob, as received,
+// and verifies it with the keyring, which may be nil.
+func receivePushCert(s storer.EncodedObjectStorer, cert *packp.PushCert, keyring openpgp.KeyRing) (*PushCert, error) {
+	payload, err := cert.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	// The certificate is stored as received, its signature is still valid.
+	var b bytes.Buffer
+	b.Write(payload)
+	b.WriteString(cert.Signature)
+
+	obj := s.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(b.Len()))
+	w, err := obj.Writer()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	h, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &PushCert{PushCert: cert, Hash: h}
+	switch {
+	case cert.Signature == "":
+		c.Status = PushCertUnsigned
+	case keyring == nil:
+		c.Status = PushCertUnchecked
+	default:
+		signer, err := cert.VerifyKeyRing(keyring)
+		switch {
+		case err == nil:
+			c.Status, c.Signer = PushCertGood, signer
+		case errors.Is(err, pgperrors.ErrUnknownIssuer):
+			c.Status = PushCertUnchecked
+		default:
+			c.Status = PushCertBad
+		}
+	}
+
+	return c, nil
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	. "gopkg.in/check.v1"
+)
+
+type PushCertSuite struct{}
+
+var _ = Suite(&PushCertSuite{})
+
+func (s *PushCertSuite) TestCheckNonce(c *C) {
+	cfg := &pushCertConfig{seed: "foo"}
+	advertised := pushCertNonce("foo", 1000)
+
+	for _, t := range []struct {
+		nonce, advertised string
+		stateless         bool
+		status            NonceStatus
+		slop              int64
+	}{
+		{nonce: advertised, status: NonceUnsolicited},
+		{advertised: advertised, status: NonceMissing},
+		{nonce: advertised, advertised: advertised, status: NonceOK},
+		{nonce: pushCertNonce("foo", 990), advertised: advertised, status: NonceBad},
+		{nonce: pushCertNonce("foo", 990), advertised: advertised, stateless: true, status: NonceSlop, slop: 10},
+		{nonce: pushCertNonce("bar", 990), advertised: advertised, stateless: true, status: NonceBad},
+		{nonce: "990-foo", advertised: advertised, stateless: true, status: NonceBad},
+		{nonce: "foo", advertised: advertised, stateless: true, status: NonceBad},
+	} {
+		status, slop := cfg.checkNonce(t.nonce, t.advertised, t.stateless)
+		comment := Commentf("%+v", t)
+		c.Assert(status, Equals, t.status, comment)
+		c.Assert(slop, Equals, t.slop, comment)
+	}
+
+	cfg.slop = 10
+	status, slop := cfg.checkNonce(pushCertNonce("foo", 990), advertised, true)
+	c.Assert(status, Equals, NonceOK)
+	c.Assert(slop, Equals, int64(10))
+
+	status, _ = cfg.checkNonce(pushCertNonce("foo", 1011), advertised, true)
+	c.Assert(status, Equals, NonceSlop)
+}
+
+func (s *PushCertSuite) TestPushCertNonce(c *C) {
+	nonce := pushCertNonce("foo", 1000)
+	c.Assert(nonce, Matches, "1000-[0-9a-f]{40}")
+	c.Assert(pushCertNonce("bar", 1000), Not(Equals), nonce)
+
+	stamp, ok := nonceStamp(nonce)
+	c.Assert(ok, Equals, true)
+	c.Assert(stamp, Equals, int64(1000))
+
+	_, ok = nonceStamp("foo")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *PushCertSuite) TestReceivePushCert(c *C) {
+	key, err := openpgp.NewEntity("foo", "", "foo@foo.com", nil)
+	c.Assert(err, IsNil)
+	other, err := openpgp.NewEntity("bar", "", "bar@bar.com", nil)
+	c.Assert(err, IsNil)
+
+	sign := func(cert *packp.PushCert) *packp.PushCert {
+		var payload, signature bytes.Buffer
+		c.Assert(cert.EncodeWithoutSignature(&payload), IsNil)
+		c.Assert(openpgp.ArmoredDetachSign(&signature, key, &payload, nil), IsNil)
+		cert.Signature = signature.String() + "\n"
+		return cert
+	}
+
+	newCert := func() *packp.PushCert {
+		return &packp.PushCert{
+			Version: packp.PushCertVersion,
+			Pusher:  "foo <foo@foo.com> 1433954361 -0700",
+			Commands: []*packp.Command{{
+				Name: "refs/heads/foo",
+				New:  plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+			}},
+		}
+	}
+
+	st := memory.NewStorage()
+	cert, err := receivePushCert(st, sign(newCert()), openpgp.EntityList{key})
+	c.Assert(err, IsNil)
+	c.Assert(cert.Status, Equals, PushCertGood)
+	c.Assert(cert.Signer, Equals, key)
+	c.Assert(st.HasEncodedObject(cert.Hash), IsNil)
+
+	cert, err = receivePushCert(st, sign(newCert()), nil)
+	c.Assert(err, IsNil)
+	c.Assert(cert.Status, Equals, PushCertUnchecked)
+
+	cert, err = receivePushCert(st, sign(newCert()), openpgp.EntityList{other})
+	c.Assert(err, IsNil)
+	c.Assert(cert.Status, Equals, PushCertUnchecked)
+	c.Assert(cert.Signer, IsNil)
+
+	tampered := sign(newCert())
+	tampered.Pusher = "bar <bar@bar.com> 1433954361 -0700"
+	cert, err = receivePushCert(st, tampered, openpgp.EntityList{key})
+	c.Assert(err, IsNil)
+	c.Assert(cert.Status, Equals, PushCertBad)
+	c.Assert(cert.Signer, IsNil)
+
+	cert, err = receivePushCert(st, newCert(), openpgp.EntityList{key})
+	c.Assert(err, IsNil)
+	c.Assert(cert.Status, Equals, PushCertUnsigned)
+
+	// a decoded certificate is verified and stored as received
+	raw := "" +
+		"certificate version 0.1\n" +
+		"pusher foo <foo@foo.com> 1433954361 -0700\n" +
+		"unknown header\n" +
+		"\n" +
+		"0000000000000000000000000000000000000000 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/foo\n"
+
+	var signature bytes.Buffer
+	c.Assert(openpgp.ArmoredDetachSign(&signature, key, bytes.NewBufferString(raw), nil), IsNil)
+	raw += signature.String() + "\n"
+
+	decoded := &packp.PushCert{}
+	c.Assert(decoded.Decode(bytes.NewBufferString(raw)), IsNil)
+	cert, err = receivePushCert(st, decoded, openpgp.EntityList{key})
+	c.Assert(err, IsNil)
+	c.Assert(cert.Status, Equals, PushCertGood)
+	c.Assert(cert.Hash, Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte(raw)))
+}
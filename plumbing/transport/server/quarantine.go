@@ -0,0 +1,164 @@
+package server
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/storage/transactional"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+const (
+	quarantineDir    = "objects"
+	quarantinePrefix = "incoming-"
+	quarantinePacks  = "objects/pack"
+)
+
+// quarantine is a storage holding the objects of a push apart from the
+// repository until the pre-receive hooks accept it, as git does. The objects
+// of the repository are read through it.
+//
+// The objects of a repository stored in a filesystem are quarantined in an
+// "objects/incoming-*" directory of it, as in git, so the hook scripts can
+// read them too. They are kept in memory otherwise.
+type quarantine struct {
+	*transactional.ObjectStorage
+	storer.ReferenceStorer
+
+	base storer.Storer
+	// fs is the filesystem of the repository and dir the directory of the
+	// quarantine in it, holding the objects in its objects directory. fs is
+	// nil if the objects are kept in memory.
+	fs  billy.Filesystem
+	dir string
+	// temp is the storage of the quarantine directory.
+	temp *filesystem.Storage
+}
+
+func newQuarantine(base storer.Storer) (*quarantine, error) {
+	q := &quarantine{ReferenceStorer: base, base: base}
+
+	type fsBased interface {
+		Filesystem() billy.Filesystem
+	}
+
+	fs, ok := base.(fsBased)
+	if !ok {
+		q.ObjectStorage = transactional.NewObjectStorage(base, memory.NewStorage())
+		return q, nil
+	}
+
+	q.fs = fs.Filesystem()
+	dir, err := util.TempDir(q.fs, quarantineDir, quarantinePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	q.dir = dir
+	chroot, err := q.fs.Chroot(dir)
+	if err != nil {
+		_ = q.discard()
+		return nil, err
+	}
+
+	q.temp = filesystem.NewStorage(chroot, cache.NewObjectLRUDefault())
+	q.ObjectStorage = transactional.NewObjectStorage(base, q.temp)
+	return q, nil
+}
+
+// receive writes the packfile into the quarantine, as a pack if it's in the
+// filesystem.
+func (q *quarantine) receive(r io.Reader) error {
+	if q.temp != nil {
+		return packfile.UpdateObjectStorage(q.temp, r)
+	}
+
+	return packfile.UpdateObjectStorage(q, r)
+}
+
+// env returns the environment variables giving the quarantine to the hook
+// scripts, empty if it isn't in the filesystem.
+func (q *quarantine) env() ([]string, error) {
+	if q.fs == nil {
+		return nil, nil
+	}
+
+	objects, err := filepath.Abs(filepath.Join(q.fs.Root(), q.dir, quarantineDir))
+	if err != nil {
+		return nil, err
+	}
+
+	alternate, err := filepath.Abs(filepath.Join(q.fs.Root(), quarantineDir))
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{
+		"GIT_QUARANTINE_PATH=" + objects,
+		"GIT_OBJECT_DIRECTORY=" + objects,
+		"GIT_ALTERNATE_OBJECT_DIRECTORIES=" + alternate,
+	}, nil
+}
+
+// migrate writes the received objects into the repository. The packs of a
+// quarantine in the filesystem are streamed from it, through the storage of
+// the repository so it knows of them.
+func (q *quarantine) migrate() error {
+	if q.fs == nil {
+		return q.ObjectStorage.Commit()
+	}
+
+	files, err := q.fs.ReadDir(q.fs.Join(q.dir, quarantinePacks))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range files {
+		if !strings.HasSuffix(fi.Name(), ".pack") {
+			continue
+		}
+
+		if err := q.migratePack(q.fs.Join(q.dir, quarantinePacks, fi.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (q *quarantine) migratePack(path string) (err error) {
+	f, err := q.fs.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return packfile.UpdateObjectStorage(q.base, f)
+}
+
+// discard removes the quarantine from the filesystem, its objects being
+// migrated or rejected.
+func (q *quarantine) discard() error {
+	if q.fs == nil {
+		return nil
+	}
+
+	if q.temp != nil {
+		_ = q.temp.Close()
+	}
+
+	return util.RemoveAll(q.fs, q.dir)
+}
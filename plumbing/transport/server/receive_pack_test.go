@@ -1,11 +1,25 @@
 package server_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/storage/filesystem"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
@@ -62,3 +76,545 @@ func (s *ReceivePackSuite) TestReceivePackWithNilPackfile(c *C) {
 	c.Assert(report, IsNil, comment)
 	c.Assert(err, NotNil, comment)
 }
+
+func (s *ReceivePackSuite) TestReceivePackAtomic(c *C) {
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	head := plumbing.NewHash(fixture.Head)
+
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	c.Assert(req.Capabilities.Set(capability.Atomic), IsNil)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/newbranch", Old: plumbing.ZeroHash, New: head},
+		{Name: "refs/heads/master", Old: plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"), New: head},
+	}
+
+	r, err := s.Client.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, NotNil)
+	c.Assert(report, NotNil)
+
+	for _, cs := range report.CommandStatuses {
+		switch cs.ReferenceName {
+		case "refs/heads/newbranch":
+			c.Assert(cs.Status, Equals, server.ErrAtomicUpdate.Error())
+		case "refs/heads/master":
+			c.Assert(cs.Status, Equals, storage.ErrReferenceHasChanged.Error())
+		}
+	}
+
+	_, err = s.loader[s.Endpoint.String()].Reference("refs/heads/newbranch")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReceivePackSuite) TestReceivePackNonAtomic(c *C) {
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	head := plumbing.NewHash(fixture.Head)
+
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/newbranch", Old: plumbing.ZeroHash, New: head},
+		{Name: "refs/heads/master", Old: plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"), New: head},
+	}
+
+	r, err := s.Client.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, storage.ErrReferenceHasChanged)
+
+	ref, err := s.loader[s.Endpoint.String()].Reference("refs/heads/newbranch")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, head)
+}
+
+func (s *ReceivePackSuite) TestReceivePackPushCert(c *C) {
+	key, err := openpgp.NewEntity("foo", "", "foo@foo.com", nil)
+	c.Assert(err, IsNil)
+
+	st := s.loader[s.Endpoint.String()].(*filesystem.Storage)
+	cfg, err := st.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("receive").SetOption("certNonceSeed", "foo")
+	c.Assert(st.SetConfig(cfg), IsNil)
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{
+		PushCertKeyRing: openpgp.EntityList{key},
+	})
+
+	r, err := srv.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	advertised := ar.Capabilities.Get(capability.PushCert)
+	c.Assert(advertised, HasLen, 1)
+
+	head := plumbing.NewHash(fixtures.Basic().ByTag("packfile").One().Head)
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{{Name: "refs/heads/signed", New: head}}
+	req.PushCert = &packp.PushCert{
+		Version:  packp.PushCertVersion,
+		Pusher:   "foo <foo@foo.com> 1433954361 -0700",
+		Nonce:    advertised[0],
+		Commands: req.Commands,
+	}
+
+	var payload, signature bytes.Buffer
+	c.Assert(req.PushCert.EncodeWithoutSignature(&payload), IsNil)
+	c.Assert(openpgp.ArmoredDetachSign(&signature, key, &payload, nil), IsNil)
+	req.PushCert.Signature = signature.String() + "\n"
+
+	_, err = r.ReceivePack(context.Background(), req)
+	c.Assert(err, IsNil)
+
+	// The certificate is kept as a blob, recording the push.
+	var encoded bytes.Buffer
+	c.Assert(req.PushCert.Encode(&encoded), IsNil)
+	h := plumbing.ComputeHash(plumbing.BlobObject, encoded.Bytes())
+	blob, err := st.EncodedObject(plumbing.BlobObject, h)
+	c.Assert(err, IsNil)
+	rd, err := blob.Reader()
+	c.Assert(err, IsNil)
+	cert := &packp.PushCert{}
+	c.Assert(cert.Decode(rd), IsNil)
+	c.Assert(cert.Commands[0].Name, Equals, plumbing.ReferenceName("refs/heads/signed"))
+
+	ref, err := st.Reference("refs/heads/signed")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, head)
+}
+
+func (s *ReceivePackSuite) TestReceivePackPushCertEnv(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	key, err := openpgp.NewEntity("foo", "", "foo@foo.com", nil)
+	c.Assert(err, IsNil)
+
+	st := s.loader[s.Endpoint.String()].(*filesystem.Storage)
+	cfg, err := st.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("receive").SetOption("certNonceSeed", "foo")
+	c.Assert(st.SetConfig(cfg), IsNil)
+
+	hooks := st.Filesystem().Join(st.Filesystem().Root(), "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "pre-receive"),
+		[]byte("#!/bin/sh\nenv | grep ^GIT_PUSH_CERT | sort\n"), 0755), IsNil)
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{
+		PushCertKeyRing: openpgp.EntityList{key},
+		HookScripts:     true,
+	})
+
+	head := plumbing.NewHash(fixtures.Basic().ByTag("packfile").One().Head)
+	push := func(ref plumbing.ReferenceName, nonce func(string) string) map[string]string {
+		r, err := srv.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+		c.Assert(err, IsNil)
+		defer func() { c.Assert(r.Close(), IsNil) }()
+
+		ar, err := r.AdvertisedReferences()
+		c.Assert(err, IsNil)
+		advertised := ar.Capabilities.Get(capability.PushCert)
+		c.Assert(advertised, HasLen, 1)
+
+		var progress bytes.Buffer
+		req := packp.NewReferenceUpdateRequest()
+		req.Progress = &progress
+		req.Commands = []*packp.Command{{Name: ref, New: head}}
+		req.PushCert = &packp.PushCert{
+			Version:  packp.PushCertVersion,
+			Pusher:   "foo <foo@foo.com> 1433954361 -0700",
+			Nonce:    nonce(advertised[0]),
+			Commands: req.Commands,
+		}
+
+		var payload, signature bytes.Buffer
+		c.Assert(req.PushCert.EncodeWithoutSignature(&payload), IsNil)
+		c.Assert(openpgp.ArmoredDetachSign(&signature, key, &payload, nil), IsNil)
+		req.PushCert.Signature = signature.String() + "\n"
+
+		_, err = r.ReceivePack(context.Background(), req)
+		c.Assert(err, IsNil)
+
+		env := make(map[string]string)
+		for _, line := range strings.Split(strings.TrimSpace(progress.String()), "\n") {
+			kv := strings.SplitN(line, "=", 2)
+			env[kv[0]] = kv[1]
+		}
+
+		return env
+	}
+
+	env := push("refs/heads/signed", func(nonce string) string { return nonce })
+	c.Assert(env["GIT_PUSH_CERT_STATUS"], Equals, "G")
+	c.Assert(env["GIT_PUSH_CERT_SIGNER"], Equals, "foo <foo@foo.com>")
+	c.Assert(env["GIT_PUSH_CERT_KEY"], Equals, key.PrimaryKey.KeyIdString())
+	c.Assert(env["GIT_PUSH_CERT_NONCE_STATUS"], Equals, "OK")
+
+	blob, err := st.EncodedObject(plumbing.BlobObject, plumbing.NewHash(env["GIT_PUSH_CERT"]))
+	c.Assert(err, IsNil)
+	rd, err := blob.Reader()
+	c.Assert(err, IsNil)
+	cert := &packp.PushCert{}
+	c.Assert(cert.Decode(rd), IsNil)
+	c.Assert(cert.Commands[0].Name, Equals, plumbing.ReferenceName("refs/heads/signed"))
+
+	env = push("refs/heads/bad-nonce", func(string) string { return "1-foo" })
+	c.Assert(env["GIT_PUSH_CERT_STATUS"], Equals, "G")
+	c.Assert(env["GIT_PUSH_CERT_NONCE_STATUS"], Equals, "BAD")
+}
+
+func (s *ReceivePackSuite) TestReceivePackPreReceiveHook(c *C) {
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	head := plumbing.NewHash(fixture.Head)
+	st := s.loader[s.EmptyEndpoint.String()]
+
+	var accept bool
+	srv := server.NewServerWithOptions(s.loader, &server.Options{
+		PreReceiveHook: server.PreReceiveHookFunc(func(ctx context.Context, p *server.Push) error {
+			// The objects of the push are readable, but not yet in the
+			// repository.
+			c.Assert(p.Storer.HasEncodedObject(head), IsNil)
+			c.Assert(st.HasEncodedObject(head), Equals, plumbing.ErrObjectNotFound)
+			if accept {
+				return nil
+			}
+
+			fmt.Fprintln(p.Progress, "no pushes today")
+			return errors.New("rejected")
+		}),
+	})
+
+	push := func() (*packp.ReportStatus, string, error) {
+		r, err := srv.NewReceivePackSession(s.EmptyEndpoint, s.EmptyAuth)
+		c.Assert(err, IsNil)
+		defer func() { c.Assert(r.Close(), IsNil) }()
+
+		var progress bytes.Buffer
+		req := packp.NewReferenceUpdateRequest()
+		c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+		req.Commands = []*packp.Command{{Name: "refs/heads/master", New: head}}
+		req.Packfile = fixture.Packfile()
+		req.Progress = &progress
+
+		report, err := r.ReceivePack(context.Background(), req)
+		return report, progress.String(), err
+	}
+
+	report, progress, err := push()
+	c.Assert(err, ErrorMatches, "rejected")
+	c.Assert(progress, Equals, "no pushes today\n")
+	c.Assert(report.CommandStatuses, HasLen, 1)
+	c.Assert(report.CommandStatuses[0].Status, Equals, "rejected")
+	c.Assert(st.HasEncodedObject(head), Equals, plumbing.ErrObjectNotFound)
+	_, err = st.Reference("refs/heads/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	accept = true
+	_, _, err = push()
+	c.Assert(err, IsNil)
+	c.Assert(st.HasEncodedObject(head), IsNil)
+	ref, err := st.Reference("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, head)
+}
+
+func (s *ReceivePackSuite) TestReceivePackPreReceiveScriptQuarantine(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	// the objects of this fixture are not in the repository
+	fixture := fixtures.ByURL("https://github.com/git-fixtures/tags.git").One()
+	head := plumbing.NewHash(fixture.Head)
+
+	st := s.loader[s.Endpoint.String()].(*filesystem.Storage)
+	root, err := filepath.Abs(st.Filesystem().Root())
+	c.Assert(err, IsNil)
+
+	hooks := filepath.Join(root, "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "pre-receive"), []byte(""+
+		"#!/bin/sh\n"+
+		"echo \"$GIT_QUARANTINE_PATH\"\n"+
+		"read old new ref\n"+
+		"git cat-file -t $new\n"+
+		"env -u GIT_OBJECT_DIRECTORY -u GIT_ALTERNATE_OBJECT_DIRECTORIES git cat-file -e $new 2>/dev/null && echo migrated\n"+
+		"test $ref != refs/heads/rejected\n",
+	), 0755), IsNil)
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{HookScripts: true})
+	push := func(ref plumbing.ReferenceName) ([]string, error) {
+		r, err := srv.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+		c.Assert(err, IsNil)
+		defer func() { c.Assert(r.Close(), IsNil) }()
+
+		var progress bytes.Buffer
+		req := packp.NewReferenceUpdateRequest()
+		c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+		req.Commands = []*packp.Command{{Name: ref, New: head}}
+		req.Packfile = fixture.Packfile()
+		req.Progress = &progress
+
+		_, err = r.ReceivePack(context.Background(), req)
+		return strings.Split(strings.TrimSpace(progress.String()), "\n"), err
+	}
+
+	assertNoQuarantine := func() {
+		dirs, err := filepath.Glob(filepath.Join(root, "objects", "incoming-*"))
+		c.Assert(err, IsNil)
+		c.Assert(dirs, HasLen, 0)
+	}
+
+	// the hook reads the quarantined objects, not yet in the repository
+	out, err := push("refs/heads/rejected")
+	c.Assert(err, Equals, server.ErrPreReceiveDeclined)
+	c.Assert(out, HasLen, 2)
+	c.Assert(strings.HasPrefix(out[0], filepath.Join(root, "objects", "incoming-")), Equals, true)
+	c.Assert(out[1], Equals, "commit")
+	c.Assert(st.HasEncodedObject(head), Equals, plumbing.ErrObjectNotFound)
+	assertNoQuarantine()
+
+	out, err = push("refs/heads/accepted")
+	c.Assert(err, IsNil)
+	c.Assert(out, HasLen, 2)
+	c.Assert(out[1], Equals, "commit")
+	c.Assert(st.HasEncodedObject(head), IsNil)
+	assertNoQuarantine()
+
+	ref, err := st.Reference("refs/heads/accepted")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, head)
+}
+
+func (s *ReceivePackSuite) TestReceivePackUpdateHook(c *C) {
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	head := plumbing.NewHash(fixture.Head)
+	parent := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+
+	var updated []plumbing.ReferenceName
+	srv := server.NewServerWithOptions(s.loader, &server.Options{
+		UpdateHook: server.UpdateHookFunc(func(ctx context.Context, p *server.Push, cmd *packp.Command) error {
+			updated = append(updated, cmd.Name)
+			if cmd.Name == plumbing.Master {
+				return errors.New("protected branch")
+			}
+
+			return nil
+		}),
+	})
+
+	r, err := srv.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/newbranch", New: parent},
+		{Name: plumbing.Master, Old: head, New: parent},
+	}
+
+	_, err = r.ReceivePack(context.Background(), req)
+	c.Assert(err, ErrorMatches, "protected branch")
+	c.Assert(updated, DeepEquals, []plumbing.ReferenceName{"refs/heads/newbranch", plumbing.Master})
+
+	st := s.loader[s.Endpoint.String()]
+	ref, err := st.Reference(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, head)
+
+	ref, err = st.Reference("refs/heads/newbranch")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash(), Equals, parent)
+}
+
+func (s *ReceivePackSuite) TestReceivePackPostReceiveHook(c *C) {
+	fixture := fixtures.Basic().ByTag("packfile").One()
+	head := plumbing.NewHash(fixture.Head)
+
+	var received *server.Push
+	srv := server.NewServerWithOptions(s.loader, &server.Options{
+		PostReceiveHook: server.PostReceiveHookFunc(func(ctx context.Context, p *server.Push) {
+			received = p
+		}),
+	})
+
+	r, err := srv.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	ar, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Capabilities.Supports(capability.PushOptions), Equals, true)
+
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.ReportStatus), IsNil)
+	c.Assert(req.Capabilities.Set(capability.PushOptions), IsNil)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/newbranch", New: head},
+		{Name: plumbing.Master, Old: plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"), New: head},
+	}
+	req.Options = []*packp.Option{{Key: "ci.skip"}}
+
+	_, err = r.ReceivePack(context.Background(), req)
+	c.Assert(err, Equals, storage.ErrReferenceHasChanged)
+
+	c.Assert(received, NotNil)
+	c.Assert(received.Commands, DeepEquals, req.Commands[:1])
+	c.Assert(received.Options, DeepEquals, req.Options)
+	c.Assert(received.Storer, Equals, s.loader[s.Endpoint.String()])
+}
+
+func (s *ReceivePackSuite) TestReceivePackPushOptionsEnv(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	st := s.loader[s.Endpoint.String()].(*filesystem.Storage)
+	hooks := st.Filesystem().Join(st.Filesystem().Root(), "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "pre-receive"),
+		[]byte("#!/bin/sh\nenv | grep ^GIT_PUSH_OPTION | sort\n"), 0755), IsNil)
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{HookScripts: true})
+	r, err := srv.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	var progress bytes.Buffer
+	req := packp.NewReferenceUpdateRequest()
+	c.Assert(req.Capabilities.Set(capability.PushOptions), IsNil)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/newbranch", New: plumbing.NewHash(fixtures.Basic().One().Head)},
+	}
+	req.Options = []*packp.Option{{Key: "ci.skip"}, {Key: "foo", Value: "bar"}}
+	req.Progress = &progress
+
+	_, err = r.ReceivePack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(progress.String(), Equals, "GIT_PUSH_OPTION_0=ci.skip\n"+
+		"GIT_PUSH_OPTION_1=foo=bar\n"+
+		"GIT_PUSH_OPTION_COUNT=2\n")
+}
+
+func (s *ReceivePackSuite) TestReceivePackHookScripts(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	st := s.loader[s.Endpoint.String()].(*filesystem.Storage)
+	hooks := st.Filesystem().Join(st.Filesystem().Root(), "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "update"),
+		[]byte("#!/bin/sh\necho declined\nexit 1\n"), 0755), IsNil)
+
+	head := plumbing.NewHash(fixtures.Basic().One().Head)
+	push := func(t transport.Transport, ref plumbing.ReferenceName) (string, error) {
+		r, err := t.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+		c.Assert(err, IsNil)
+		defer func() { c.Assert(r.Close(), IsNil) }()
+
+		var progress bytes.Buffer
+		req := packp.NewReferenceUpdateRequest()
+		req.Commands = []*packp.Command{{Name: ref, New: head}}
+		req.Progress = &progress
+
+		_, err = r.ReceivePack(context.Background(), req)
+		return progress.String(), err
+	}
+
+	// the scripts of the repositories are only run if enabled
+	out, err := push(s.Client, "refs/heads/disabled")
+	c.Assert(err, IsNil)
+	c.Assert(out, Equals, "")
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{HookScripts: true})
+	out, err = push(srv, "refs/heads/enabled")
+	c.Assert(err, Equals, server.ErrHookDeclined)
+	c.Assert(out, Equals, "declined\n")
+
+	_, err = st.Reference("refs/heads/enabled")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *ReceivePackSuite) TestReceivePackHookScriptContext(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	st := s.loader[s.Endpoint.String()].(*filesystem.Storage)
+	hooks := st.Filesystem().Join(st.Filesystem().Root(), "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "update"),
+		[]byte("#!/bin/sh\nexec sleep 60\n"), 0755), IsNil)
+
+	srv := server.NewServerWithOptions(s.loader, &server.Options{HookScripts: true})
+	r, err := srv.NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	req := packp.NewReferenceUpdateRequest()
+	req.Commands = []*packp.Command{{Name: "refs/heads/foo", New: plumbing.NewHash(fixtures.Basic().One().Head)}}
+
+	// the hook is killed once the context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = r.ReceivePack(ctx, req)
+	c.Assert(err, Equals, server.ErrHookDeclined)
+	c.Assert(time.Since(start) < 10*time.Second, Equals, true)
+}
+
+func (s *ReceivePackSuite) TestReceivePackPushCertError(c *C) {
+	st := s.loader[s.Endpoint.String()].(*filesystem.Storage)
+	cfg, err := st.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("receive").SetOption("certNonceSeed", "foo")
+	c.Assert(st.SetConfig(cfg), IsNil)
+
+	r, err := server.NewServer(s.loader).NewReceivePackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	// the certificate can't be checked once the config is broken
+	cfg.Raw.Section("receive").SetOption("certNonceSlop", "invalid")
+	c.Assert(st.SetConfig(cfg), IsNil)
+
+	head := plumbing.NewHash(fixtures.Basic().ByTag("packfile").One().Head)
+	req := packp.NewReferenceUpdateRequest()
+	req.Capabilities.Set(capability.ReportStatus)
+	req.Commands = []*packp.Command{
+		{Name: "refs/heads/signed", New: head},
+		{Name: "refs/heads/signed2", New: head},
+	}
+	req.PushCert = &packp.PushCert{
+		Version:  packp.PushCertVersion,
+		Pusher:   "foo <foo@foo.com> 1433954361 -0700",
+		Commands: req.Commands,
+	}
+
+	report, err := r.ReceivePack(context.Background(), req)
+	c.Assert(err, ErrorMatches, ".*certNonceSlop.*")
+	c.Assert(report, NotNil)
+	c.Assert(report.CommandStatuses, HasLen, 2)
+	for _, cs := range report.CommandStatuses {
+		c.Assert(cs.Error(), NotNil)
+	}
+
+	_, err = st.Reference("refs/heads/signed")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
@@ -7,14 +7,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	stdioutil "io/ioutil"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
-	"github.com/go-git/go-git/v5/plumbing/revlist"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/utils/ioutil"
 )
 
@@ -25,21 +27,60 @@ type server struct {
 	handler *handler
 }
 
+// Options are the options of a server.
+type Options struct {
+	// PushCertKeyRing is the keyring verifying the signatures of the push
+	// certificates, which can't be checked if nil. The certificates are
+	// asked for by the repositories with a receive.certNonceSeed config.
+	PushCertKeyRing openpgp.KeyRing
+	// PreReceiveHook, UpdateHook and PostReceiveHook are run on the pushes,
+	// before the hook scripts of the repositories. Their output is sent to
+	// the client.
+	PreReceiveHook  PreReceiveHook
+	UpdateHook      UpdateHook
+	PostReceiveHook PostReceiveHook
+	// HookScripts runs the pre-receive, update and post-receive scripts of
+	// the hooks directory of the repositories stored in the filesystem of
+	// the operating system, as git receive-pack does. It's disabled by
+	// default, since those scripts can run any command on the server.
+	HookScripts bool
+}
+
 // NewServer returns a transport.Transport implementing a git server,
 // independent of transport. Each transport must wrap this.
 func NewServer(loader Loader) transport.Transport {
+	return NewServerWithOptions(loader, nil)
+}
+
+// NewServerWithOptions returns a transport.Transport implementing a git
+// server with the given options, as NewServer does.
+func NewServerWithOptions(loader Loader, o *Options) transport.Transport {
+	if o == nil {
+		o = &Options{}
+	}
+
 	return &server{
 		loader,
-		&handler{asClient: false},
+		&handler{asClient: false, options: o},
 	}
 }
 
 // NewClient returns a transport.Transport implementing a client with an
 // embedded server.
 func NewClient(loader Loader) transport.Transport {
+	return NewClientWithOptions(loader, nil)
+}
+
+// NewClientWithOptions returns a transport.Transport implementing a client
+// with an embedded server with the given options, as NewClient does.
+func NewClientWithOptions(loader Loader, o *Options) transport.Transport {
+	if o == nil {
+		o = &Options{}
+	}
+
 	return &server{
 		loader,
-		&handler{asClient: true},
+		&handler{asClient: true, options: o},
 	}
 }
 
@@ -63,6 +104,7 @@ func (s *server) NewReceivePackSession(ep *transport.Endpoint, auth transport.Au
 
 type handler struct {
 	asClient bool
+	options  *Options
 }
 
 func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSession, error) {
@@ -74,6 +116,7 @@ func (h *handler) NewUploadPackSession(s storer.Storer) (transport.UploadPackSes
 func (h *handler) NewReceivePackSession(s storer.Storer) (transport.ReceivePackSession, error) {
 	return &rpSession{
 		session:   session{storer: s, asClient: h.asClient},
+		options:   h.options,
 		cmdStatus: map[plumbing.ReferenceName]error{},
 	}, nil
 }
@@ -136,56 +179,68 @@ func (s *upSession) AdvertisedReferencesContext(ctx context.Context) (*packp.Adv
 }
 
 func (s *upSession) UploadPack(ctx context.Context, req *packp.UploadPackRequest) (*packp.UploadPackResponse, error) {
-	if req.IsEmpty() {
-		return nil, transport.ErrEmptyUploadPackRequest
+	up, err := s.newUploadPack(req)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := req.Validate(); err != nil {
+	objs, err := up.objects()
+	if err != nil {
 		return nil, err
 	}
 
-	if s.caps == nil {
-		s.caps = capability.NewList()
-		if err := s.setSupportedCapabilities(s.caps); err != nil {
-			return nil, err
-		}
+	pr, pw := ioutil.Pipe()
+	go func() {
+		pw.CloseWithError(up.encode(pw, objs))
+	}()
+
+	resp := packp.NewUploadPackResponseWithPackfile(req,
+		ioutil.NewContextReadCloser(ctx, pr),
+	)
+
+	resp.ShallowUpdate = up.shallowUpdate()
+	return resp, nil
+}
+
+// ShallowUpdate returns the shallow commits to update on the client for a
+// request with a depth, sent before its haves. As they don't depend on the
+// haves, only the history of the wants is walked, no packfile is encoded.
+func (s *upSession) ShallowUpdate(ctx context.Context, req *packp.UploadPackRequest) (*packp.ShallowUpdate, error) {
+	up, err := s.newUploadPack(req)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.checkSupportedCapabilities(req.Capabilities); err != nil {
+	if err := up.walkShallow(false); err != nil {
 		return nil, err
 	}
 
-	s.caps = req.Capabilities
+	su := up.shallowUpdate()
+	return &su, nil
+}
 
-	if len(req.Shallows) > 0 {
-		return nil, fmt.Errorf("shallow not supported")
+func (s *upSession) newUploadPack(req *packp.UploadPackRequest) (*uploadPack, error) {
+	if req.IsEmpty() && len(req.Shallows) == 0 {
+		return nil, transport.ErrEmptyUploadPackRequest
 	}
 
-	objs, err := s.objectsToUpload(req)
-	if err != nil {
+	if err := req.Validate(); err != nil {
 		return nil, err
 	}
 
-	pr, pw := ioutil.Pipe()
-	e := packfile.NewEncoder(pw, s.storer, false)
-	go func() {
-		// TODO: plumb through a pack window.
-		_, err := e.Encode(objs, 10)
-		pw.CloseWithError(err)
-	}()
-
-	return packp.NewUploadPackResponseWithPackfile(req,
-		ioutil.NewContextReadCloser(ctx, pr),
-	), nil
-}
+	if s.caps == nil {
+		s.caps = capability.NewList()
+		if err := s.setSupportedCapabilities(s.caps); err != nil {
+			return nil, err
+		}
+	}
 
-func (s *upSession) objectsToUpload(req *packp.UploadPackRequest) ([]plumbing.Hash, error) {
-	haves, err := revlist.Objects(s.storer, req.Haves, nil)
-	if err != nil {
+	if err := s.checkSupportedCapabilities(req.Capabilities); err != nil {
 		return nil, err
 	}
 
-	return revlist.Objects(s.storer, req.Wants, haves)
+	s.caps = req.Capabilities
+	return newUploadPack(s.storer, req)
 }
 
 func (*upSession) setSupportedCapabilities(c *capability.List) error {
@@ -193,8 +248,17 @@ func (*upSession) setSupportedCapabilities(c *capability.List) error {
 		return err
 	}
 
-	if err := c.Set(capability.OFSDelta); err != nil {
-		return err
+	for _, cap := range []capability.Capability{
+		capability.OFSDelta,
+		capability.Shallow,
+		capability.IncludeTag,
+		capability.Sideband,
+		capability.Sideband64k,
+		capability.NoProgress,
+	} {
+		if err := c.Set(cap); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -202,6 +266,9 @@ func (*upSession) setSupportedCapabilities(c *capability.List) error {
 
 type rpSession struct {
 	session
+	options *Options
+	// nonce is the nonce of the push certificates advertised to the client.
+	nonce     string
 	cmdStatus map[plumbing.ReferenceName]error
 	firstErr  error
 	unpackErr error
@@ -233,10 +300,21 @@ func (s *rpSession) AdvertisedReferencesContext(ctx context.Context) (*packp.Adv
 
 var (
 	ErrUpdateReference = errors.New("failed to update ref")
+	// ErrAtomicUpdate is the status of the commands not applied because
+	// another command of an atomic push failed.
+	ErrAtomicUpdate = errors.New("atomic push failed")
+	// ErrPreReceiveDeclined is the status of the commands of a push rejected
+	// by the pre-receive hook.
+	ErrPreReceiveDeclined = errors.New("pre-receive hook declined")
+	// ErrHookDeclined is the status of a command rejected by the update hook.
+	ErrHookDeclined = errors.New("hook declined")
 )
 
 func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateRequest) (*packp.ReportStatus, error) {
-	if s.caps == nil {
+	// The references weren't advertised in this session by a stateless
+	// transport.
+	stateless := s.caps == nil
+	if stateless {
 		s.caps = capability.NewList()
 		if err := s.setSupportedCapabilities(s.caps); err != nil {
 			return nil, err
@@ -249,66 +327,217 @@ func (s *rpSession) ReceivePack(ctx context.Context, req *packp.ReferenceUpdateR
 
 	s.caps = req.Capabilities
 
-	//TODO: Implement 'atomic' update of references.
+	push := &Push{
+		Commands: req.Commands,
+		Options:  req.Options,
+		Storer:   s.storer,
+		Progress: req.Progress,
+	}
+
+	if push.Progress == nil {
+		push.Progress = stdioutil.Discard
+	}
+
+	hooks := newReceiveHooks(s.storer, push, s.options.HookScripts, s.caps.Supports(capability.PushOptions))
+
+	// The objects are quarantined only if a pre-receive hook can read them
+	// before they're written to the repository.
+	var q *quarantine
+	if s.options.PreReceiveHook != nil || hooks.has("pre-receive") {
+		var err error
+		if q, err = newQuarantine(s.storer); err != nil {
+			s.unpackErr = err
+			s.firstErr = err
+			return s.reportStatus(), err
+		}
+
+		defer func() { _ = q.discard() }()
+		push.Storer = q
+	}
 
 	if req.Packfile != nil {
 		r := ioutil.NewContextReadCloser(ctx, req.Packfile)
-		if err := s.writePackfile(r); err != nil {
+		if err := s.writePackfile(q, r); err != nil {
 			s.unpackErr = err
 			s.firstErr = err
 			return s.reportStatus(), err
 		}
 	}
 
-	s.updateReferences(req)
+	if req.PushCert != nil {
+		var err error
+		if push.PushCert, err = s.receivePushCert(req.PushCert, stateless); err != nil {
+			return s.decline(req.Commands, err), s.firstErr
+		}
+	}
+
+	if q != nil {
+		if err := s.preReceive(ctx, push, hooks, q); err != nil {
+			return s.decline(req.Commands, err), s.firstErr
+		}
+
+		if err := q.migrate(); err != nil {
+			s.unpackErr = err
+			s.firstErr = err
+			return s.reportStatus(), err
+		}
+
+		push.Storer = s.storer
+	}
+
+	s.updateReferences(ctx, push, hooks)
+
+	var applied []*packp.Command
+	for _, cmd := range req.Commands {
+		if err, ok := s.cmdStatus[cmd.Name]; ok && err == nil {
+			applied = append(applied, cmd)
+		}
+	}
+
+	if len(applied) != 0 && s.options.PostReceiveHook != nil {
+		p := *push
+		p.Commands = applied
+		s.options.PostReceiveHook.PostReceive(ctx, &p)
+	}
+
+	hooks.postReceive(ctx, applied)
 	return s.reportStatus(), s.firstErr
 }
 
-func (s *rpSession) updateReferences(req *packp.ReferenceUpdateRequest) {
-	for _, cmd := range req.Commands {
-		exists, err := referenceExists(s.storer, cmd.Name)
+// preReceive runs the pre-receive hook of the server, then the one of the
+// repository, while the objects of the push are quarantined.
+func (s *rpSession) preReceive(ctx context.Context, push *Push, hooks *receiveHooks, q *quarantine) error {
+	if s.options.PreReceiveHook != nil {
+		if err := s.options.PreReceiveHook.PreReceive(ctx, push); err != nil {
+			return err
+		}
+	}
+
+	env, err := q.env()
+	if err != nil {
+		return err
+	}
+
+	hooks.quarantine = env
+	defer func() { hooks.quarantine = nil }()
+
+	return hooks.preReceive(ctx, push.Commands)
+}
+
+// decline rejects all the commands of a push with the given status.
+func (s *rpSession) decline(cmds []*packp.Command, err error) *packp.ReportStatus {
+	for _, cmd := range cmds {
+		s.setStatus(cmd.Name, err)
+	}
+
+	return s.reportStatus()
+}
+
+// receivePushCert stores and verifies the push certificate of the request.
+func (s *rpSession) receivePushCert(cert *packp.PushCert, stateless bool) (*PushCert, error) {
+	cfg, err := loadPushCertConfig(s.storer)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := receivePushCert(s.storer, cert, s.options.PushCertKeyRing)
+	if err != nil {
+		return nil, err
+	}
+
+	c.NonceStatus, c.NonceSlop = cfg.checkNonce(cert.Nonce, s.nonce, stateless)
+	return c, nil
+}
+
+func (s *rpSession) updateReferences(ctx context.Context, push *Push, hooks *receiveHooks) {
+	// All the references are updated in a single transaction, so a failure
+	// doesn't leave them half-updated.
+	tx := storage.NewReferenceTransaction(s.storer)
+	var queued []plumbing.ReferenceName
+	for _, cmd := range push.Commands {
+		cur, err := currentReference(s.storer, cmd.Name)
 		if err != nil {
 			s.setStatus(cmd.Name, err)
 			continue
 		}
 
+		old := plumbing.NewHashReference(cmd.Name, cmd.Old)
 		switch cmd.Action() {
 		case packp.Create:
-			if exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
+			if cur != nil {
+				err = ErrUpdateReference
 			}
-
-			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
-			s.setStatus(cmd.Name, err)
-		case packp.Delete:
-			if !exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
+		case packp.Delete, packp.Update:
+			if cur == nil {
+				err = ErrUpdateReference
+			} else if !storage.ReferenceMatches(cur, old) {
+				err = storage.ErrReferenceHasChanged
 			}
+		}
 
-			err := s.storer.RemoveReference(cmd.Name)
-			s.setStatus(cmd.Name, err)
-		case packp.Update:
-			if !exists {
-				s.setStatus(cmd.Name, ErrUpdateReference)
-				continue
+		if err == nil {
+			err = s.updateHooks(ctx, push, hooks, cmd)
+		}
+
+		if err == nil {
+			if cmd.Action() == packp.Delete {
+				err = tx.RemoveReference(cmd.Name, old)
+			} else {
+				err = tx.SetReference(plumbing.NewHashReference(cmd.Name, cmd.New), old)
 			}
+		}
 
-			ref := plumbing.NewHashReference(cmd.Name, cmd.New)
-			err := s.storer.SetReference(ref)
+		if err != nil {
 			s.setStatus(cmd.Name, err)
+			continue
+		}
+
+		queued = append(queued, cmd.Name)
+	}
+
+	if s.firstErr != nil && s.caps.Supports(capability.Atomic) {
+		_ = tx.Abort()
+		for _, name := range queued {
+			s.setStatus(name, ErrAtomicUpdate)
 		}
+
+		return
+	}
+
+	err := tx.Commit()
+	for _, name := range queued {
+		s.setStatus(name, err)
 	}
 }
 
-func (s *rpSession) writePackfile(r io.ReadCloser) error {
+// updateHooks runs the update hook of the server, then the one of the
+// repository, on a command.
+func (s *rpSession) updateHooks(ctx context.Context, push *Push, hooks *receiveHooks, cmd *packp.Command) error {
+	if s.options.UpdateHook != nil {
+		if err := s.options.UpdateHook.Update(ctx, push, cmd); err != nil {
+			return err
+		}
+	}
+
+	return hooks.update(ctx, cmd)
+}
+
+// writePackfile writes the packfile into the quarantine, if not nil, or into
+// the repository.
+func (s *rpSession) writePackfile(q *quarantine, r io.ReadCloser) error {
 	if r == nil {
 		return nil
 	}
 
-	if err := packfile.UpdateObjectStorage(s.storer, r); err != nil {
+	write := func(r io.Reader) error {
+		return packfile.UpdateObjectStorage(s.storer, r)
+	}
+
+	if q != nil {
+		write = q.receive
+	}
+
+	if err := write(r); err != nil {
 		_ = r.Close()
 		return err
 	}
@@ -354,11 +583,22 @@ func (s *rpSession) reportStatus() *packp.ReportStatus {
 	return rs
 }
 
-func (*rpSession) setSupportedCapabilities(c *capability.List) error {
+func (s *rpSession) setSupportedCapabilities(c *capability.List) error {
 	if err := c.Set(capability.Agent, capability.DefaultAgent()); err != nil {
 		return err
 	}
 
+	cfg, err := loadPushCertConfig(s.storer)
+	if err != nil {
+		return err
+	}
+
+	if s.nonce = cfg.newNonce(); s.nonce != "" {
+		if err := c.Set(capability.PushCert, s.nonce); err != nil {
+			return err
+		}
+	}
+
 	if err := c.Set(capability.OFSDelta); err != nil {
 		return err
 	}
@@ -367,6 +607,18 @@ func (*rpSession) setSupportedCapabilities(c *capability.List) error {
 		return err
 	}
 
+	if err := c.Set(capability.Atomic); err != nil {
+		return err
+	}
+
+	if err := c.Set(capability.Sideband64k); err != nil {
+		return err
+	}
+
+	if err := c.Set(capability.PushOptions); err != nil {
+		return err
+	}
+
 	return c.Set(capability.ReportStatus)
 }
 
@@ -422,11 +674,12 @@ func setReferences(s storer.Storer, ar *packp.AdvRefs) error {
 	})
 }
 
-func referenceExists(s storer.ReferenceStorer, n plumbing.ReferenceName) (bool, error) {
-	_, err := s.Reference(n)
+// currentReference returns the named reference, or nil if it doesn't exist.
+func currentReference(s storer.ReferenceStorer, n plumbing.ReferenceName) (*plumbing.Reference, error) {
+	ref, err := s.Reference(n)
 	if err == plumbing.ErrReferenceNotFound {
-		return false, nil
+		return nil, nil
 	}
 
-	return err == nil, err
+	return ref, err
 }
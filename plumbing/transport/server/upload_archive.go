@@ -0,0 +1,208 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/archive"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+const (
+	uploadArchiveArgument = "argument "
+	uploadArchiveACK      = "ACK"
+	uploadArchiveNACK     = "NACK "
+)
+
+var (
+	// ErrInvalidArchiveArgument is returned when a git-upload-archive
+	// request contains an invalid argument.
+	ErrInvalidArchiveArgument = errors.New("invalid archive argument")
+	// ErrArchiveTreeishNotAllowed is returned when the treeish of a
+	// git-upload-archive request isn't a reference.
+	ErrArchiveTreeishNotAllowed = errors.New("archive treeish must be a reference")
+)
+
+// UploadArchive serves a git-upload-archive request on the repository s, as
+// sent by git archive --remote. It reads the arguments of the archive from r
+// and writes the archive to w, in the side-band channel of the protocol. As
+// git does by default, the treeish must be a reference, optionally followed
+// by a colon and a path.
+func UploadArchive(s storer.Storer, r io.Reader, w io.Writer) error {
+	args, err := readArchiveArguments(r)
+	if err != nil {
+		return err
+	}
+
+	e := pktline.NewEncoder(w)
+	o, treeish, err := parseArchiveArguments(args)
+	var tree *object.Tree
+	var commit *object.Commit
+	if err == nil {
+		tree, commit, err = resolveArchiveTreeish(s, treeish)
+	}
+
+	if err != nil {
+		if err := e.Encodef("%s%s\n", uploadArchiveNACK, err); err != nil {
+			return err
+		}
+
+		return err
+	}
+
+	if err := e.Encodef("%s\n", uploadArchiveACK); err != nil {
+		return err
+	}
+
+	if err := e.Flush(); err != nil {
+		return err
+	}
+
+	mux := sideband.NewMuxer(sideband.Sideband64k, w)
+	if err := archive.NewEncoder(mux, o).Encode(tree, commit); err != nil {
+		if _, werr := mux.WriteChannel(sideband.ErrorMessage, []byte(err.Error()+"\n")); werr != nil {
+			return werr
+		}
+
+		return err
+	}
+
+	return e.Flush()
+}
+
+func readArchiveArguments(r io.Reader) ([]string, error) {
+	var args []string
+	scanner := pktline.NewScanner(r)
+	for scanner.Scan() {
+		line := string(scanner.Bytes())
+		if line == "" {
+			return args, nil
+		}
+
+		if !strings.HasPrefix(line, uploadArchiveArgument) {
+			return nil, fmt.Errorf("%w: 'argument' token or flush expected", ErrInvalidArchiveArgument)
+		}
+
+		args = append(args, strings.TrimSuffix(line[len(uploadArchiveArgument):], "\n"))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, io.ErrUnexpectedEOF
+}
+
+// parseArchiveArguments returns the options and the treeish of the
+// arguments of git archive.
+func parseArchiveArguments(args []string) (*archive.Options, string, error) {
+	o := &archive.Options{}
+	i := 0
+loop:
+	for ; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			i++
+			break loop
+		case !strings.HasPrefix(arg, "-"):
+			break loop
+		case strings.HasPrefix(arg, "--format="):
+			f, err := archive.FormatFromName(arg[len("--format="):])
+			if err != nil {
+				return nil, "", err
+			}
+
+			o.Format = f
+		case strings.HasPrefix(arg, "--prefix="):
+			o.Prefix = arg[len("--prefix="):]
+		case arg == "--worktree-attributes", arg == "-v", arg == "--verbose":
+		case len(arg) == 2 && arg[1] >= '0' && arg[1] <= '9':
+			o.CompressionLevel, _ = strconv.Atoi(arg[1:])
+		default:
+			return nil, "", fmt.Errorf("%w: %s", ErrInvalidArchiveArgument, arg)
+		}
+	}
+
+	if i >= len(args) {
+		return nil, "", fmt.Errorf("%w: missing treeish", ErrInvalidArchiveArgument)
+	}
+
+	o.Paths = args[i+1:]
+	return o, args[i], o.Validate()
+}
+
+// resolveArchiveTreeish returns the tree of a reference, or of a path of it
+// after a colon, and the commit it comes from.
+func resolveArchiveTreeish(s storer.Storer, treeish string) (*object.Tree, *object.Commit, error) {
+	name, dir := treeish, ""
+	i := strings.IndexByte(treeish, ':')
+	if i >= 0 {
+		name, dir = treeish[:i], treeish[i+1:]
+	}
+
+	ref, err := resolveShortReference(s, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrArchiveTreeishNotAllowed, name)
+	}
+
+	obj, err := object.GetObject(s, ref.Hash())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for {
+		t, ok := obj.(*object.Tag)
+		if !ok {
+			break
+		}
+
+		if obj, err = t.Object(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var tree *object.Tree
+	var commit *object.Commit
+	switch o := obj.(type) {
+	case *object.Commit:
+		commit = o
+		if tree, err = o.Tree(); err != nil {
+			return nil, nil, err
+		}
+	case *object.Tree:
+		tree = o
+	default:
+		return nil, nil, fmt.Errorf("%w: %s", ErrArchiveTreeishNotAllowed, name)
+	}
+
+	if i < 0 {
+		return tree, commit, nil
+	}
+
+	if dir = strings.Trim(dir, "/"); dir == "" {
+		return tree, nil, nil
+	}
+
+	tree, err = tree.Tree(dir)
+	return tree, nil, err
+}
+
+// resolveShortReference resolves a reference by its full or short name.
+func resolveShortReference(s storer.ReferenceStorer, name string) (*plumbing.Reference, error) {
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		ref, err := storer.ResolveReference(s, plumbing.ReferenceName(fmt.Sprintf(rule, name)))
+		if err == nil {
+			return ref, nil
+		}
+	}
+
+	return nil, plumbing.ErrReferenceNotFound
+}
@@ -0,0 +1,84 @@
+package server_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	. "gopkg.in/check.v1"
+)
+
+type UploadArchiveSuite struct {
+	fixtures.Suite
+	storer *filesystem.Storage
+}
+
+var _ = Suite(&UploadArchiveSuite{})
+
+func (s *UploadArchiveSuite) SetUpTest(c *C) {
+	s.storer = filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+}
+
+func (s *UploadArchiveSuite) request(c *C, args ...string) io.Reader {
+	buf := bytes.NewBuffer(nil)
+	e := pktline.NewEncoder(buf)
+	for _, arg := range args {
+		c.Assert(e.Encodef("argument %s\n", arg), IsNil)
+	}
+
+	c.Assert(e.Flush(), IsNil)
+	return buf
+}
+
+func (s *UploadArchiveSuite) TestUploadArchive(c *C) {
+	out := bytes.NewBuffer(nil)
+	err := server.UploadArchive(s.storer, s.request(c, "--format=tar", "--prefix=basic/", "master", "go"), out)
+	c.Assert(err, IsNil)
+
+	scanner := pktline.NewScanner(out)
+	c.Assert(scanner.Scan(), Equals, true)
+	c.Assert(string(scanner.Bytes()), Equals, "ACK\n")
+	c.Assert(scanner.Scan(), Equals, true)
+	c.Assert(scanner.Bytes(), HasLen, 0)
+
+	tr := tar.NewReader(sideband.NewDemuxer(sideband.Sideband64k, out))
+	var names []string
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		c.Assert(err, IsNil)
+		names = append(names, h.Name)
+	}
+
+	c.Assert(names, DeepEquals, []string{"pax_global_header", "basic/", "basic/go/", "basic/go/example.go"})
+}
+
+func (s *UploadArchiveSuite) TestUploadArchiveNACK(c *C) {
+	for _, t := range []struct {
+		args []string
+		err  error
+	}{
+		{[]string{"6ecf0ef2c2dffb796033e5a02219af86ec6584e5"}, server.ErrArchiveTreeishNotAllowed},
+		{[]string{"--remote=foo", "HEAD"}, server.ErrInvalidArchiveArgument},
+		{[]string{"--format=tar"}, server.ErrInvalidArchiveArgument},
+	} {
+		out := bytes.NewBuffer(nil)
+		err := server.UploadArchive(s.storer, s.request(c, t.args...), out)
+		c.Assert(errors.Is(err, t.err), Equals, true)
+
+		scanner := pktline.NewScanner(out)
+		c.Assert(scanner.Scan(), Equals, true)
+		c.Assert(string(scanner.Bytes()), Matches, "NACK .*\n")
+	}
+}
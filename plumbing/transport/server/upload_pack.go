@@ -0,0 +1,386 @@
+package server
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/format/pktline"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/sideband"
+	"github.com/go-git/go-git/v5/plumbing/revlist"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// uploadPack holds the state of an upload-pack request: the objects to send
+// and the shallow commits of the client to update.
+type uploadPack struct {
+	s   storer.Storer
+	req *packp.UploadPackRequest
+
+	// depth is the maximum number of commits sent from the wants, 0 if
+	// unlimited.
+	depth int
+	// clientShallows are the shallow commits of the client.
+	clientShallows map[plumbing.Hash]bool
+	// have are the objects the client has.
+	have map[plumbing.Hash]bool
+	// send are the objects to send.
+	send map[plumbing.Hash]bool
+	// seen are the objects either had by the client or sent.
+	seen map[plumbing.Hash]bool
+
+	shallows, unshallows []plumbing.Hash
+}
+
+func newUploadPack(s storer.Storer, req *packp.UploadPackRequest) (*uploadPack, error) {
+	up := &uploadPack{
+		s:              s,
+		req:            req,
+		clientShallows: make(map[plumbing.Hash]bool, len(req.Shallows)),
+		have:           make(map[plumbing.Hash]bool),
+		send:           make(map[plumbing.Hash]bool),
+		seen:           make(map[plumbing.Hash]bool),
+	}
+
+	switch d := req.Depth.(type) {
+	case nil:
+	case packp.DepthCommits:
+		up.depth = int(d)
+	default:
+		return nil, fmt.Errorf("unsupported depth: %T", req.Depth)
+	}
+
+	for _, h := range req.Shallows {
+		up.clientShallows[h] = true
+	}
+
+	return up, nil
+}
+
+// haves returns the haves of the request which exist in the storer, the
+// others being unknown to the server.
+func (up *uploadPack) haves() []plumbing.Hash {
+	var haves []plumbing.Hash
+	for _, h := range up.req.Haves {
+		if up.s.HasEncodedObject(h) == nil {
+			haves = append(haves, h)
+		}
+	}
+
+	return haves
+}
+
+// objects returns the objects to send.
+func (up *uploadPack) objects() ([]plumbing.Hash, error) {
+	haves := up.haves()
+	if up.depth == 0 && len(up.clientShallows) == 0 {
+		objs, err := revlist.Objects(up.s, up.req.Wants, haves)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, h := range objs {
+			up.send[h] = true
+		}
+	} else if err := up.shallowObjects(haves); err != nil {
+		return nil, err
+	}
+
+	if up.req.Capabilities.Supports(capability.IncludeTag) {
+		if err := up.includeTags(); err != nil {
+			return nil, err
+		}
+	}
+
+	objs := make([]plumbing.Hash, 0, len(up.send))
+	for h := range up.send {
+		objs = append(objs, h)
+	}
+
+	return objs, nil
+}
+
+// shallowObjects walks the history of the wants, not further than the depth
+// or, without a depth, the commits the client has. The commits reachable from
+// the haves are walked through but not sent, so the new shallow boundary is
+// computed from the wants, as git does.
+func (up *uploadPack) shallowObjects(haves []plumbing.Hash) error {
+	if err := up.walkHaves(haves); err != nil {
+		return err
+	}
+
+	for h := range up.have {
+		up.seen[h] = true
+	}
+
+	return up.walkShallow(true)
+}
+
+// walkShallow walks the history of the wants, computing the shallow commits
+// to update on the client. The objects walked are only added to the objects
+// to send if addObjects is set.
+func (up *uploadPack) walkShallow(addObjects bool) error {
+	type pending struct {
+		h     plumbing.Hash
+		depth int
+	}
+
+	var queue []pending
+	for _, h := range up.req.Wants {
+		queue = append(queue, pending{h, 1})
+	}
+
+	visited := make(map[plumbing.Hash]bool)
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		if visited[p.h] {
+			continue
+		}
+
+		visited[p.h] = true
+		o, err := object.GetObject(up.s, p.h)
+		if err != nil {
+			return err
+		}
+
+		c, ok := o.(*object.Commit)
+		if !ok {
+			if t, ok := o.(*object.Tag); ok {
+				if addObjects {
+					up.add(t.Hash)
+				}
+
+				queue = append(queue, pending{t.Target, p.depth})
+				continue
+			}
+
+			if !addObjects {
+				continue
+			}
+
+			if err := up.addObject(o); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if up.depth == 0 && (up.have[c.Hash] || up.clientShallows[c.Hash]) {
+			continue
+		}
+
+		if addObjects {
+			if err := up.addObject(c); err != nil {
+				return err
+			}
+		}
+
+		if len(c.ParentHashes) == 0 {
+			continue
+		}
+
+		if up.depth > 0 && p.depth >= up.depth {
+			if !up.clientShallows[c.Hash] {
+				up.shallows = append(up.shallows, c.Hash)
+			}
+
+			continue
+		}
+
+		if up.clientShallows[c.Hash] {
+			up.unshallows = append(up.unshallows, c.Hash)
+		}
+
+		for _, parent := range c.ParentHashes {
+			queue = append(queue, pending{parent, p.depth + 1})
+		}
+	}
+
+	return nil
+}
+
+// walkHaves marks the objects reachable from the haves as had by the client,
+// without going through its shallow commits.
+func (up *uploadPack) walkHaves(haves []plumbing.Hash) error {
+	queue := append([]plumbing.Hash(nil), haves...)
+	for len(queue) > 0 {
+		h := queue[0]
+		queue = queue[1:]
+		if up.have[h] {
+			continue
+		}
+
+		o, err := object.GetObject(up.s, h)
+		if err != nil {
+			return err
+		}
+
+		switch o := o.(type) {
+		case *object.Commit:
+			up.have[h] = true
+			tree, err := o.Tree()
+			if err != nil {
+				return err
+			}
+
+			if err := walkTree(tree, up.have, func(plumbing.Hash) {}); err != nil {
+				return err
+			}
+
+			if !up.clientShallows[h] {
+				queue = append(queue, o.ParentHashes...)
+			}
+		case *object.Tag:
+			up.have[h] = true
+			queue = append(queue, o.Target)
+		case *object.Tree:
+			if err := walkTree(o, up.have, func(plumbing.Hash) {}); err != nil {
+				return err
+			}
+		default:
+			up.have[h] = true
+		}
+	}
+
+	return nil
+}
+
+// addObject adds an object to send, with its tree if it's a commit.
+func (up *uploadPack) addObject(o object.Object) error {
+	switch o := o.(type) {
+	case *object.Commit:
+		up.add(o.Hash)
+		tree, err := o.Tree()
+		if err != nil {
+			return err
+		}
+
+		return up.addTree(tree)
+	case *object.Tree:
+		return up.addTree(o)
+	default:
+		up.add(o.ID())
+		return nil
+	}
+}
+
+func (up *uploadPack) addTree(t *object.Tree) error {
+	return walkTree(t, up.seen, up.add)
+}
+
+func (up *uploadPack) add(h plumbing.Hash) {
+	if !up.have[h] {
+		up.send[h] = true
+	}
+}
+
+// walkTree calls cb with the tree and the objects it contains, skipping the
+// ones already seen, and marks them as seen.
+func walkTree(t *object.Tree, seen map[plumbing.Hash]bool, cb func(plumbing.Hash)) error {
+	if seen[t.Hash] {
+		return nil
+	}
+
+	seen[t.Hash] = true
+	cb(t.Hash)
+
+	w := object.NewTreeWalker(t, true, seen)
+	defer w.Close()
+	for {
+		_, e, err := w.Next()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if e.Mode == filemode.Submodule || seen[e.Hash] {
+			continue
+		}
+
+		seen[e.Hash] = true
+		cb(e.Hash)
+	}
+}
+
+// includeTags adds the annotated tags of the references pointing to objects
+// being sent, as the include-tag capability asks.
+func (up *uploadPack) includeTags() error {
+	iter, err := up.s.IterReferences()
+	if err != nil {
+		return err
+	}
+
+	return iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !ref.Name().IsTag() {
+			return nil
+		}
+
+		var chain []plumbing.Hash
+		h := ref.Hash()
+		for {
+			tag, err := object.GetTag(up.s, h)
+			if err != nil {
+				break
+			}
+
+			chain = append(chain, tag.Hash)
+			h = tag.Target
+		}
+
+		if len(chain) == 0 || !up.send[h] {
+			return nil
+		}
+
+		for _, t := range chain {
+			up.add(t)
+		}
+
+		return nil
+	})
+}
+
+// shallowUpdate returns the shallow commits to update on the client.
+func (up *uploadPack) shallowUpdate() packp.ShallowUpdate {
+	return packp.ShallowUpdate{Shallows: up.shallows, Unshallows: up.unshallows}
+}
+
+// encode writes the packfile of the objects to w, multiplexed with the
+// progress messages and the errors if the client asked for a sideband.
+func (up *uploadPack) encode(w io.WriteCloser, objs []plumbing.Hash) error {
+	var t sideband.Type
+	switch {
+	case up.req.Capabilities.Supports(capability.Sideband64k):
+		t = sideband.Sideband64k
+	case up.req.Capabilities.Supports(capability.Sideband):
+		t = sideband.Sideband
+	default:
+		// TODO: plumb through a pack window.
+		_, err := packfile.NewEncoder(w, up.s, false).Encode(objs, 10)
+		return err
+	}
+
+	m := sideband.NewMuxer(t, w)
+	if !up.req.Capabilities.Supports(capability.NoProgress) {
+		msg := fmt.Sprintf("Enumerating objects: %d, done.\n", len(objs))
+		if _, err := m.WriteChannel(sideband.ProgressMessage, []byte(msg)); err != nil {
+			return err
+		}
+	}
+
+	if _, err := packfile.NewEncoder(m, up.s, false).Encode(objs, 10); err != nil {
+		_, _ = m.WriteChannel(sideband.ErrorMessage, []byte(err.Error()+"\n"))
+		return err
+	}
+
+	_, err := w.Write(pktline.FlushPkt)
+	return err
+}
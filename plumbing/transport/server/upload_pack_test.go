@@ -1,6 +1,11 @@
 package server_test
 
 import (
+	"context"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 
 	. "gopkg.in/check.v1"
@@ -48,3 +53,48 @@ func (s *ClientLikeUploadPackSuite) SetUpSuite(c *C) {
 func (s *ClientLikeUploadPackSuite) TestAdvertisedReferencesEmpty(c *C) {
 	s.UploadPackSuite.TestAdvertisedReferencesEmpty(c)
 }
+
+type shallowUpdater interface {
+	ShallowUpdate(context.Context, *packp.UploadPackRequest) (*packp.ShallowUpdate, error)
+}
+
+func (s *UploadPackSuite) TestUploadPackShallow(c *C) {
+	head := plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	parent := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+
+	uploadPack := func(req *packp.UploadPackRequest) *packp.UploadPackResponse {
+		r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+		c.Assert(err, IsNil)
+		defer func() { c.Assert(r.Close(), IsNil) }()
+
+		_, err = r.AdvertisedReferences()
+		c.Assert(err, IsNil)
+
+		// the shallow update sent before the haves is the same
+		su, err := r.(shallowUpdater).ShallowUpdate(context.Background(), req)
+		c.Assert(err, IsNil)
+
+		resp, err := r.UploadPack(context.Background(), req)
+		c.Assert(err, IsNil)
+		c.Assert(resp.Close(), IsNil)
+		c.Assert(*su, DeepEquals, resp.ShallowUpdate)
+		return resp
+	}
+
+	req := packp.NewUploadPackRequest()
+	c.Assert(req.Capabilities.Set(capability.Shallow), IsNil)
+	req.Wants = []plumbing.Hash{head}
+	req.Depth = packp.DepthCommits(1)
+
+	resp := uploadPack(req)
+	c.Assert(resp.ShallowUpdate.Shallows, DeepEquals, []plumbing.Hash{head})
+	c.Assert(resp.ShallowUpdate.Unshallows, HasLen, 0)
+
+	req.Shallows = []plumbing.Hash{head}
+	req.Haves = []plumbing.Hash{head}
+	req.Depth = packp.DepthCommits(2)
+
+	resp = uploadPack(req)
+	c.Assert(resp.ShallowUpdate.Unshallows, DeepEquals, []plumbing.Hash{head})
+	c.Assert(resp.ShallowUpdate.Shallows, DeepEquals, []plumbing.Hash{parent})
+}
@@ -4,6 +4,7 @@ package ssh
 import (
 	"context"
 	"fmt"
+	"net"
 	"reflect"
 	"strconv"
 	"strings"
@@ -22,6 +23,11 @@ var DefaultClient = NewClient(nil)
 
 // DefaultSSHConfig is the reader used to access parameters stored in the
 // system's ssh_config files. If nil all the ssh_config are ignored.
+//
+// The Hostname, Port, User, IdentityFile, ProxyJump, ProxyCommand,
+// HostKeyAlgorithms, UserKnownHostsFile, GlobalKnownHostsFile,
+// StrictHostKeyChecking and ConnectTimeout keys are used. IdentityFile is
+// only used if DefaultAuthBuilder fails, as without an SSH agent.
 var DefaultSSHConfig sshConfig = ssh_config.DefaultUserSettings
 
 type sshConfig interface {
@@ -105,41 +111,32 @@ func (c *command) Close() error {
 // connect connects to the SSH server, unless a AuthMethod was set with
 // SetAuth method, by default uses an auth method based on PublicKeysCallback,
 // it connects to a SSH agent, using the address stored in the SSH_AUTH_SOCK
-// environment var.
+// environment var, or on the IdentityFile of ssh_config if there's no agent.
 func (c *command) connect() error {
 	if c.connected {
 		return transport.ErrAlreadyConnected
 	}
 
+	hostWithPort := c.getHostWithPort()
 	if c.auth == nil {
-		if err := c.setAuthFromEndpoint(); err != nil {
+		if err := c.setAuthFromEndpoint(hostWithPort); err != nil {
 			return err
 		}
 	}
 
-	var err error
-	config, err := c.auth.ClientConfig()
+	config, err := c.clientConfig(hostWithPort)
 	if err != nil {
 		return err
 	}
-	hostWithPort := c.getHostWithPort()
-	if config.HostKeyCallback == nil {
-		kh, err := newKnownHosts()
-		if err != nil {
-			return err
-		}
-		config.HostKeyCallback = kh.HostKeyCallback()
-		config.HostKeyAlgorithms = kh.HostKeyAlgorithms(hostWithPort)
-	} else if len(config.HostKeyAlgorithms) == 0 {
-		// Set the HostKeyAlgorithms based on HostKeyCallback.
-		// For background see https://github.com/go-git/go-git/issues/411 as well as
-		// https://github.com/golang/go/issues/29286 for root cause.
-		config.HostKeyAlgorithms = knownhosts.HostKeyAlgorithms(config.HostKeyCallback, hostWithPort)
-	}
 
 	overrideConfig(c.config, config)
 
-	c.client, err = dial("tcp", hostWithPort, config)
+	if c.endpoint.Proxy.URL != "" {
+		c.client, err = dial("tcp", hostWithPort, c.endpoint.Proxy, config)
+	} else {
+		c.client, err = dialSSHConfig(c.endpoint.Host, hostWithPort, config, 0)
+	}
+
 	if err != nil {
 		return err
 	}
@@ -154,7 +151,44 @@ func (c *command) connect() error {
 	return nil
 }
 
-func dial(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+// clientConfig returns the ssh.ClientConfig of the auth method for the
+// server at addr, completed by the ssh_config of the host. Its known_hosts
+// files and StrictHostKeyChecking are used unless the auth method has its own
+// HostKeyCallback. The auth method itself is never modified, it may be shared
+// by concurrent connections.
+func (c *command) clientConfig(addr string) (*ssh.ClientConfig, error) {
+	var config *ssh.ClientConfig
+	var err error
+	if a, ok := c.auth.(interface {
+		hostKeyCallbackHelper() *HostKeyCallbackHelper
+		clientConfig() *ssh.ClientConfig
+	}); ok && a.hostKeyCallbackHelper().HostKeyCallback == nil {
+		config = a.clientConfig()
+		if config.HostKeyCallback, config.HostKeyAlgorithms, err = newHostKeyCallback(c.endpoint.Host, addr); err != nil {
+			return nil, err
+		}
+	} else {
+		if config, err = c.auth.ClientConfig(); err != nil {
+			return nil, err
+		}
+
+		if config.HostKeyCallback == nil {
+			if config.HostKeyCallback, config.HostKeyAlgorithms, err = newHostKeyCallback(c.endpoint.Host, addr); err != nil {
+				return nil, err
+			}
+		} else if len(config.HostKeyAlgorithms) == 0 {
+			// Set the HostKeyAlgorithms based on HostKeyCallback.
+			// For background see https://github.com/go-git/go-git/issues/411 as well as
+			// https://github.com/golang/go/issues/29286 for root cause.
+			config.HostKeyAlgorithms = knownhosts.HostKeyAlgorithms(config.HostKeyCallback, addr)
+		}
+	}
+
+	applySSHConfig(c.endpoint.Host, config)
+	return config, nil
+}
+
+func dial(network, addr string, proxyOpts transport.ProxyOptions, config *ssh.ClientConfig) (*ssh.Client, error) {
 	var (
 		ctx    = context.Background()
 		cancel context.CancelFunc
@@ -166,24 +200,47 @@ func dial(network, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
 	}
 	defer cancel()
 
-	conn, err := proxy.Dial(ctx, network, addr)
+	var conn net.Conn
+	var err error
+	if proxyOpts.URL != "" {
+		var d proxy.ContextDialer
+		if d, err = common.NewProxyDialer(proxyOpts); err != nil {
+			return nil, err
+		}
+
+		conn, err = d.DialContext(ctx, network, addr)
+	} else {
+		conn, err = proxy.Dial(ctx, network, addr)
+	}
+
 	if err != nil {
 		return nil, err
 	}
+
+	return newClient(conn, addr, config)
+}
+
+// newClient returns an SSH client over conn, which is closed on error.
+func newClient(conn net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
 	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
 	if err != nil {
+		_ = conn.Close()
 		return nil, err
 	}
+
 	return ssh.NewClient(c, chans, reqs), nil
 }
 
 func (c *command) getHostWithPort() string {
-	if addr, found := c.doGetHostWithPortFromSSHConfig(); found {
+	return hostWithPort(c.endpoint.Host, c.endpoint.Port)
+}
+
+// hostWithPort returns the address of a host, following its ssh_config.
+func hostWithPort(host string, port int) string {
+	if addr, found := doGetHostWithPortFromSSHConfig(host, port); found {
 		return addr
 	}
 
-	host := c.endpoint.Host
-	port := c.endpoint.Port
 	if port <= 0 {
 		port = DefaultPort
 	}
@@ -191,15 +248,14 @@ func (c *command) getHostWithPort() string {
 	return fmt.Sprintf("%s:%d", host, port)
 }
 
-func (c *command) doGetHostWithPortFromSSHConfig() (addr string, found bool) {
+func doGetHostWithPortFromSSHConfig(alias string, port int) (addr string, found bool) {
 	if DefaultSSHConfig == nil {
 		return
 	}
 
-	host := c.endpoint.Host
-	port := c.endpoint.Port
+	host := alias
 
-	configHost := DefaultSSHConfig.Get(c.endpoint.Host, "Hostname")
+	configHost := DefaultSSHConfig.Get(alias, "Hostname")
 	if configHost != "" {
 		host = configHost
 		found = true
@@ -209,7 +265,7 @@ func (c *command) doGetHostWithPortFromSSHConfig() (addr string, found bool) {
 		return
 	}
 
-	configPort := DefaultSSHConfig.Get(c.endpoint.Host, "Port")
+	configPort := DefaultSSHConfig.Get(alias, "Port")
 	if configPort != "" {
 		if i, err := strconv.Atoi(configPort); err == nil {
 			port = i
@@ -220,10 +276,28 @@ func (c *command) doGetHostWithPortFromSSHConfig() (addr string, found bool) {
 	return
 }
 
-func (c *command) setAuthFromEndpoint() error {
+// setAuthFromEndpoint sets the auth method built by DefaultAuthBuilder for
+// the user of the endpoint, or of ssh_config. If it fails, as without an SSH
+// agent, the IdentityFile of ssh_config are used if any can be read.
+func (c *command) setAuthFromEndpoint(addr string) error {
+	user := c.endpoint.User
+	if user == "" {
+		user = sshConfigValue(c.endpoint.Host, "User")
+	}
+
 	var err error
-	c.auth, err = DefaultAuthBuilder(c.endpoint.User)
-	return err
+	c.auth, err = DefaultAuthBuilder(user)
+	if err == nil {
+		return nil
+	}
+
+	auth, ierr := newIdentityFilesAuth(c.endpoint.Host, addr, user)
+	if ierr != nil {
+		return err
+	}
+
+	c.auth = auth
+	return nil
 }
 
 func endpointToCommand(cmd string, ep *transport.Endpoint) string {
@@ -0,0 +1,68 @@
+package ssh
+
+import (
+	"io"
+	"net"
+	"os/exec"
+	"time"
+)
+
+// proxyCommandConn is a connection over the standard input and output of a
+// ProxyCommand.
+type proxyCommandConn struct {
+	io.Reader
+	io.WriteCloser
+
+	cmd  *exec.Cmd
+	addr proxyCommandAddr
+}
+
+// dialProxyCommand runs a ProxyCommand through the shell, as OpenSSH does,
+// returning the connection to the server it provides.
+func dialProxyCommand(command string) (net.Conn, error) {
+	cmd := exec.Command("sh", "-c", "exec "+command)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &proxyCommandConn{
+		Reader:      stdout,
+		WriteCloser: stdin,
+		cmd:         cmd,
+		addr:        proxyCommandAddr(command),
+	}, nil
+}
+
+// Close closes the standard input of the command and kills it.
+func (c *proxyCommandConn) Close() error {
+	_ = c.WriteCloser.Close()
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait()
+
+	return nil
+}
+
+func (c *proxyCommandConn) LocalAddr() net.Addr  { return c.addr }
+func (c *proxyCommandConn) RemoteAddr() net.Addr { return c.addr }
+
+// The deadlines aren't supported, the SSH client doesn't use them.
+func (c *proxyCommandConn) SetDeadline(time.Time) error      { return nil }
+func (c *proxyCommandConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *proxyCommandConn) SetWriteDeadline(time.Time) error { return nil }
+
+// proxyCommandAddr is the address of a proxyCommandConn: its command.
+type proxyCommandAddr string
+
+func (a proxyCommandAddr) Network() string { return "proxy-command" }
+func (a proxyCommandAddr) String() string  { return string(a) }
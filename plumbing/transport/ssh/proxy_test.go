@@ -1,12 +1,18 @@
 package ssh
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net"
 	"os"
+	"sync/atomic"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/test"
 
 	"github.com/armon/go-socks5"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
 	. "gopkg.in/check.v1"
 )
 
@@ -34,3 +40,66 @@ func (s *ProxySuite) SetUpSuite(c *C) {
 		log.Fatal(server.Serve(l))
 	}()
 }
+
+type ProxyOptionsSuite struct {
+	BaseServerSuite
+}
+
+var _ = Suite(&ProxyOptionsSuite{})
+
+func (s *ProxyOptionsSuite) TestSOCKS5(c *C) {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	var requests int32
+	server, err := socks5.New(&socks5.Config{
+		Credentials: socks5.StaticCredentials{"user": "secret"},
+		Rules:       countingRuleSet{&requests},
+	})
+
+	c.Assert(err, IsNil)
+	go func() { _ = server.Serve(l) }()
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	ep.Proxy = transport.ProxyOptions{
+		URL:      fmt.Sprintf("socks5://%s", l.Addr()),
+		Username: "user",
+		Password: "secret",
+	}
+
+	c.Assert(s.advertisedReferences(ep, s.auth), IsNil)
+	c.Assert(atomic.LoadInt32(&requests), Equals, int32(1))
+
+	ep.Proxy.Password = "wrong"
+	c.Assert(s.advertisedReferences(ep, s.auth), NotNil)
+}
+
+func (s *ProxyOptionsSuite) TestHTTPConnect(c *C) {
+	proxy := test.NewHTTPProxy("user", "secret")
+	defer proxy.Close()
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	ep.Proxy = transport.ProxyOptions{
+		URL:      proxy.URL,
+		Username: "user",
+		Password: "secret",
+	}
+
+	c.Assert(s.advertisedReferences(ep, s.auth), IsNil)
+	c.Assert(proxy.Requests(), Equals, 1)
+
+	ep.Proxy.Password = "wrong"
+	err := s.advertisedReferences(ep, s.auth)
+	c.Assert(err, ErrorMatches, ".*407 Proxy Authentication Required")
+}
+
+// countingRuleSet allows all the requests, counting them.
+type countingRuleSet struct {
+	requests *int32
+}
+
+func (r countingRuleSet) Allow(ctx context.Context, req *socks5.Request) (context.Context, bool) {
+	atomic.AddInt32(r.requests, 1)
+	return ctx, true
+}
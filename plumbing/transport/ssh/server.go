@@ -0,0 +1,524 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/internal/common"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultHandshakeTimeout is the default time a Server waits for a client to
+// complete the SSH handshake.
+const DefaultHandshakeTimeout = 30 * time.Second
+
+var (
+	// ErrServerClosed is returned by Server.Serve and Server.ListenAndServe
+	// after a call to Server.Shutdown or Server.Close.
+	ErrServerClosed = errors.New("ssh: server closed")
+	// ErrNoHostKeys is returned by Server.Serve if the server has no host key.
+	ErrNoHostKeys = errors.New("ssh: no host keys")
+	// ErrNoLoader is returned by Server.Serve if the server has no Loader.
+	ErrNoLoader = errors.New("ssh: server without loader")
+	// ErrUnknownKey is returned by the callbacks of NewAuthorizedKeysCallback
+	// for the keys not authorized.
+	ErrUnknownKey = errors.New("ssh: unknown public key")
+
+	errInvalidCommand = errors.New("invalid command")
+	errReadOnly       = errors.New("read-only access")
+)
+
+// publicKeyExtension is the extension of the ssh.Permissions of a connection
+// holding the public key the client authenticated with.
+const publicKeyExtension = "go-git-public-key"
+
+// Server is an SSH server of the git-upload-pack and git-receive-pack
+// services, as OpenSSH with git-shell does. The clients are authenticated by
+// their public key, and each service request is authorized before loading
+// the repository of its path.
+type Server struct {
+	// Loader loads the repositories of the requests. It is required, and
+	// should only give access to the repositories meant to be served:
+	// server.NewFilesystemLoader resolves every path, absolute ones included,
+	// under its base filesystem.
+	Loader server.Loader
+	// ServerOptions are the options of the git server, as its hooks.
+	ServerOptions *server.Options
+	// HostKeys are the private keys of the server, at least one is required.
+	HostKeys []ssh.Signer
+	// PublicKeyCallback authenticates a client by the user and public key it
+	// presents, returning an error to reject it. It's required, no client
+	// being authenticated if nil.
+	PublicKeyCallback func(user string, key ssh.PublicKey) error
+	// AuthorizeCallback authorizes an authenticated client to run a service,
+	// transport.UploadPackServiceName or transport.ReceivePackServiceName, on
+	// the repository of an endpoint. The client is told the repository
+	// doesn't exist if it returns an error. If nil, every authenticated
+	// client has read-only access: git-upload-pack is allowed on all the
+	// repositories, git-receive-pack on none.
+	AuthorizeCallback func(user string, key ssh.PublicKey, service string, ep *transport.Endpoint) error
+	// HandshakeTimeout is the time to wait for a client to complete the
+	// handshake, DefaultHandshakeTimeout if zero.
+	HandshakeTimeout time.Duration
+	// ErrorLog is the logger of the errors serving the connections, they
+	// aren't logged if nil.
+	ErrorLog *log.Logger
+
+	mu         sync.Mutex
+	listeners  map[net.Listener]struct{}
+	conns      map[net.Conn]struct{}
+	inShutdown bool
+	wg         sync.WaitGroup
+}
+
+// ListenAndServe listens on the TCP address addr, ":22" if empty, and serves
+// the connections. It always returns a non-nil error, ErrServerClosed after a
+// call to Shutdown or Close.
+func (s *Server) ListenAndServe(addr string) error {
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", DefaultPort)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts the connections of the listener l, serving each of them in
+// its own goroutine. It always returns a non-nil error, ErrServerClosed after
+// a call to Shutdown or Close. The listener is closed on return.
+func (s *Server) Serve(l net.Listener) error {
+	defer l.Close()
+	if len(s.HostKeys) == 0 {
+		return ErrNoHostKeys
+	}
+
+	if s.Loader == nil {
+		return ErrNoLoader
+	}
+
+	if !s.trackListener(l, true) {
+		return ErrServerClosed
+	}
+
+	defer s.trackListener(l, false)
+
+	config := s.config()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		if !s.trackConn(conn, true) {
+			_ = conn.Close()
+			continue
+		}
+
+		go func() {
+			defer s.trackConn(conn, false)
+			defer conn.Close()
+
+			if err := s.serveConn(conn, config); err != nil {
+				s.logf("%s: %s", conn.RemoteAddr(), err)
+			}
+		}()
+	}
+}
+
+// Shutdown stops the server gracefully: it closes the listeners and waits
+// for the connections being served to finish. If ctx is done before, the
+// connections are closed and its error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeListeners()
+
+	finished := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+}
+
+// Close stops the server immediately, closing the listeners and the
+// connections being served.
+func (s *Server) Close() error {
+	s.closeListeners()
+	s.closeConns()
+	return nil
+}
+
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+	}
+
+	if !add {
+		delete(s.listeners, l)
+		return true
+	}
+
+	if s.inShutdown {
+		return false
+	}
+
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) trackConn(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		s.conns = make(map[net.Conn]struct{})
+	}
+
+	if !add {
+		delete(s.conns, conn)
+		s.wg.Done()
+		return true
+	}
+
+	if s.inShutdown {
+		return false
+	}
+
+	s.conns[conn] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inShutdown
+}
+
+func (s *Server) closeListeners() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inShutdown = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+}
+
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	}
+}
+
+func (s *Server) config() *ssh.ServerConfig {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if s.PublicKeyCallback == nil {
+				return nil, ErrUnknownKey
+			}
+
+			if err := s.PublicKeyCallback(conn.User(), key); err != nil {
+				return nil, err
+			}
+
+			return &ssh.Permissions{
+				Extensions: map[string]string{
+					publicKeyExtension: string(key.Marshal()),
+				},
+			}, nil
+		},
+	}
+
+	for _, k := range s.HostKeys {
+		config.AddHostKey(k)
+	}
+
+	return config
+}
+
+func (s *Server) serveConn(conn net.Conn, config *ssh.ServerConfig) error {
+	timeout := s.HandshakeTimeout
+	if timeout == 0 {
+		timeout = DefaultHandshakeTimeout
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return err
+	}
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return err
+	}
+
+	defer sconn.Close()
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	key, err := ssh.ParsePublicKey([]byte(sconn.Permissions.Extensions[publicKeyExtension]))
+	if err != nil {
+		return err
+	}
+
+	go ssh.DiscardRequests(reqs)
+
+	var wg sync.WaitGroup
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			s.logf("%s: %s", conn.RemoteAddr(), err)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveSession(sconn.User(), key, ch, reqs)
+		}()
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// serveSession runs the command of the first exec request of a session,
+// the other requests, as the ones of a shell, being rejected.
+func (s *Server) serveSession(user string, key ssh.PublicKey, ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+
+	for req := range reqs {
+		var payload struct{ Command string }
+		if req.Type != "exec" || ssh.Unmarshal(req.Payload, &payload) != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+
+		_ = req.Reply(true, nil)
+		go ssh.DiscardRequests(reqs)
+
+		status := s.exec(user, key, ch, payload.Command)
+		_, _ = ch.SendRequest("exit-status", false,
+			ssh.Marshal(struct{ Status uint32 }{status}),
+		)
+
+		return
+	}
+}
+
+// exec runs a git command on the channel, returning its exit status.
+func (s *Server) exec(user string, key ssh.PublicKey, ch ssh.Channel, command string) uint32 {
+	service, path, err := parseCommand(command)
+	if err != nil {
+		fmt.Fprintf(ch.Stderr(), "fatal: %s: %s\n", err, command)
+		return 128
+	}
+
+	ep := &transport.Endpoint{Protocol: "ssh", User: user, Path: path}
+	if err := s.authorize(user, key, service, ep); err != nil {
+		// As for a repository not found, the client isn't told whether the
+		// repository exists.
+		s.logf("%s %s %s: %s", user, service, path, err)
+		fmt.Fprintf(ch.Stderr(), "fatal: '%s' does not appear to be a git repository\n", path)
+		return 128
+	}
+
+	cmd := common.ServerCommand{
+		Stdin:  ch,
+		Stdout: ioutil.WriteNopCloser(ch),
+		Stderr: ch.Stderr(),
+	}
+
+	srv := server.NewServerWithOptions(s.Loader, s.ServerOptions)
+	switch service {
+	case transport.UploadPackServiceName:
+		var sess transport.UploadPackSession
+		sess, err = srv.NewUploadPackSession(ep, nil)
+		if err == nil {
+			err = common.ServeUploadPack(cmd, sess)
+		}
+	default:
+		var sess transport.ReceivePackSession
+		sess, err = srv.NewReceivePackSession(ep, nil)
+		if err == nil {
+			err = common.ServeReceivePack(cmd, sess)
+		}
+	}
+
+	switch {
+	case err == transport.ErrRepositoryNotFound:
+		fmt.Fprintf(ch.Stderr(), "fatal: '%s' does not appear to be a git repository\n", path)
+		return 128
+	case err != nil:
+		s.logf("%s %s %s: %s", user, service, path, err)
+		fmt.Fprintf(ch.Stderr(), "fatal: %s\n", err)
+		return 128
+	}
+
+	return 0
+}
+
+func (s *Server) authorize(user string, key ssh.PublicKey, service string, ep *transport.Endpoint) error {
+	for _, elem := range strings.Split(ep.Path, "/") {
+		if elem == ".." {
+			return transport.ErrRepositoryNotFound
+		}
+	}
+
+	if s.AuthorizeCallback == nil {
+		if service == transport.ReceivePackServiceName {
+			return errReadOnly
+		}
+
+		return nil
+	}
+
+	return s.AuthorizeCallback(user, key, service, ep)
+}
+
+// parseCommand parses the command of an exec request, as
+// "git-upload-pack '/path'" or "git upload-pack '/path'", returning the
+// service and the unquoted path.
+func parseCommand(command string) (service, path string, err error) {
+	fields := strings.SplitN(command, " ", 2)
+	if len(fields) != 2 {
+		return "", "", errInvalidCommand
+	}
+
+	service, arg := fields[0], fields[1]
+	if service == "git" {
+		fields = strings.SplitN(arg, " ", 2)
+		if len(fields) != 2 {
+			return "", "", errInvalidCommand
+		}
+
+		service, arg = "git-"+fields[0], fields[1]
+	}
+
+	switch service {
+	case transport.UploadPackServiceName, transport.ReceivePackServiceName:
+	default:
+		return "", "", errInvalidCommand
+	}
+
+	path, err = unquotePath(arg)
+	if err != nil {
+		return "", "", err
+	}
+
+	return service, path, nil
+}
+
+// unquotePath unquotes a path quoted as git does for the shell: in single
+// quotes, each single quote or exclamation mark inside ending the quoting to
+// be escaped with a backslash. A path without quotes is returned as is.
+func unquotePath(s string) (string, error) {
+	if !strings.HasPrefix(s, "'") {
+		if s == "" || strings.ContainsAny(s, "' ") {
+			return "", errInvalidCommand
+		}
+
+		return s, nil
+	}
+
+	var buf bytes.Buffer
+	for {
+		s = s[1:]
+		end := strings.IndexByte(s, '\'')
+		if end < 0 {
+			return "", errInvalidCommand
+		}
+
+		buf.WriteString(s[:end])
+		s = s[end+1:]
+		switch {
+		case s == "":
+			if buf.Len() == 0 {
+				return "", errInvalidCommand
+			}
+
+			return buf.String(), nil
+		case len(s) >= 3 && s[0] == '\\' && (s[1] == '\'' || s[1] == '!') && s[2] == '\'':
+			buf.WriteByte(s[1])
+			s = s[2:]
+		default:
+			return "", errInvalidCommand
+		}
+	}
+}
+
+// NewAuthorizedKeysCallback returns a Server.PublicKeyCallback accepting the
+// keys of an authorized_keys file, whatever the user. The options of the
+// keys are ignored.
+func NewAuthorizedKeysCallback(authorizedKeys []byte) (func(string, ssh.PublicKey) error, error) {
+	keys := make(map[string]bool)
+	for _, line := range bytes.Split(authorizedKeys, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[string(key.Marshal())] = true
+	}
+
+	return func(_ string, key ssh.PublicKey) error {
+		if !keys[string(key.Marshal())] {
+			return ErrUnknownKey
+		}
+
+		return nil
+	}, nil
+}
@@ -0,0 +1,382 @@
+package ssh
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/go-git/go-billy/v5/osfs"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/server"
+	"github.com/go-git/go-git/v5/plumbing/transport/test"
+
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	stdssh "golang.org/x/crypto/ssh"
+	. "gopkg.in/check.v1"
+)
+
+// BaseServerSuite runs a Server serving the repositories of a temporary
+// directory, to a client authenticated with a generated key.
+type BaseServerSuite struct {
+	fixtures.Suite
+
+	base    string
+	addr    string
+	server  *Server
+	served  chan error
+	hostKey stdssh.Signer
+	key     stdssh.Signer
+	auth    *PublicKeys
+}
+
+func (s *BaseServerSuite) SetUpTest(c *C) {
+	if runtime.GOOS == "js" {
+		c.Skip("tcp connections are not available in wasm")
+	}
+
+	var err error
+	s.base, err = ioutil.TempDir("", "go-git-ssh-server")
+	c.Assert(err, IsNil)
+
+	s.hostKey = generateSigner(c)
+	s.key = generateSigner(c)
+	s.auth = &PublicKeys{User: "git", Signer: s.key}
+	s.auth.HostKeyCallback = stdssh.FixedHostKey(s.hostKey.PublicKey())
+
+	s.startServer(c, &Server{})
+}
+
+func (s *BaseServerSuite) TearDownTest(c *C) {
+	c.Assert(s.server.Close(), IsNil)
+	c.Assert(<-s.served, Equals, ErrServerClosed)
+	c.Assert(os.RemoveAll(s.base), IsNil)
+	s.server = nil
+}
+
+// startServer serves the repositories with srv, its loader, host key and
+// public key callback being set unless given.
+func (s *BaseServerSuite) startServer(c *C, srv *Server) {
+	if s.server != nil {
+		c.Assert(s.server.Close(), IsNil)
+		c.Assert(<-s.served, Equals, ErrServerClosed)
+	}
+
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	if srv.Loader == nil {
+		srv.Loader = server.NewFilesystemLoader(osfs.New(s.base))
+	}
+
+	if srv.HostKeys == nil {
+		srv.HostKeys = []stdssh.Signer{s.hostKey}
+	}
+
+	if srv.PublicKeyCallback == nil {
+		srv.PublicKeyCallback, err = NewAuthorizedKeysCallback(
+			stdssh.MarshalAuthorizedKey(s.key.PublicKey()),
+		)
+
+		c.Assert(err, IsNil)
+	}
+
+	s.server = srv
+	s.addr = l.Addr().String()
+	s.served = make(chan error, 1)
+	go func() { s.served <- srv.Serve(l) }()
+}
+
+func (s *BaseServerSuite) newEndpoint(c *C, name string) *transport.Endpoint {
+	ep, err := transport.NewEndpoint(fmt.Sprintf("ssh://git@%s/%s", s.addr, name))
+	c.Assert(err, IsNil)
+
+	return ep
+}
+
+func (s *BaseServerSuite) prepareRepository(c *C, f *fixtures.Fixture, name string) *transport.Endpoint {
+	fs := f.DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+	c.Assert(os.Rename(fs.Root(), filepath.Join(s.base, name)), IsNil)
+
+	return s.newEndpoint(c, name)
+}
+
+func (s *BaseServerSuite) advertisedReferences(ep *transport.Endpoint, auth transport.AuthMethod) error {
+	r, err := DefaultClient.NewUploadPackSession(ep, auth)
+	if err != nil {
+		return err
+	}
+
+	defer r.Close()
+	_, err = r.AdvertisedReferences()
+	return err
+}
+
+func generateSigner(c *C) stdssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	c.Assert(err, IsNil)
+
+	signer, err := stdssh.NewSignerFromKey(key)
+	c.Assert(err, IsNil)
+
+	return signer
+}
+
+type ServerSuite struct {
+	BaseServerSuite
+}
+
+var _ = Suite(&ServerSuite{})
+
+func (s *ServerSuite) TestAdvertisedReferences(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(ep, s.auth), IsNil)
+	c.Assert(s.advertisedReferences(s.newEndpoint(c, "basic"), s.auth), IsNil)
+	c.Assert(s.advertisedReferences(s.newEndpoint(c, "non-existent.git"), s.auth),
+		Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *ServerSuite) TestUnknownKey(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+
+	auth := &PublicKeys{User: "git", Signer: generateSigner(c)}
+	auth.HostKeyCallback = stdssh.FixedHostKey(s.hostKey.PublicKey())
+
+	err := s.advertisedReferences(ep, auth)
+	c.Assert(err, ErrorMatches, ".*unable to authenticate.*")
+}
+
+func (s *ServerSuite) TestNoPublicKeyCallback(c *C) {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	srv := &Server{
+		Loader:   server.NewFilesystemLoader(osfs.New(s.base)),
+		HostKeys: []stdssh.Signer{s.hostKey},
+	}
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+	defer func() {
+		c.Assert(srv.Close(), IsNil)
+		c.Assert(<-served, Equals, ErrServerClosed)
+	}()
+
+	ep, err := transport.NewEndpoint(fmt.Sprintf("ssh://git@%s/basic.git", l.Addr()))
+	c.Assert(err, IsNil)
+
+	err = s.advertisedReferences(ep, s.auth)
+	c.Assert(err, ErrorMatches, ".*unable to authenticate.*")
+}
+
+func (s *ServerSuite) TestAuthorizeCallback(c *C) {
+	var calls []string
+	s.startServer(c, &Server{
+		AuthorizeCallback: func(user string, key stdssh.PublicKey, service string, ep *transport.Endpoint) error {
+			c.Assert(user, Equals, "git")
+			c.Assert(key.Marshal(), DeepEquals, s.key.PublicKey().Marshal())
+			calls = append(calls, service+" "+ep.Path)
+
+			if service == transport.ReceivePackServiceName {
+				return errors.New("read-only")
+			}
+
+			return nil
+		},
+	})
+
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(ep, s.auth), IsNil)
+
+	r, err := DefaultClient.NewReceivePackSession(ep, s.auth)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+	c.Assert(calls, DeepEquals, []string{
+		"git-upload-pack /basic.git",
+		"git-receive-pack /basic.git",
+	})
+}
+
+func (s *ServerSuite) TestReadOnlyByDefault(c *C) {
+	ep := s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(ep, s.auth), IsNil)
+
+	r, err := DefaultClient.NewReceivePackSession(ep, s.auth)
+	c.Assert(err, IsNil)
+	defer r.Close()
+
+	_, err = r.AdvertisedReferences()
+	c.Assert(err, Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *ServerSuite) TestParentPath(c *C) {
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	c.Assert(s.advertisedReferences(s.newEndpoint(c, "foo/../basic.git"), s.auth),
+		Equals, transport.ErrRepositoryNotFound)
+}
+
+func (s *ServerSuite) TestNoHostKeys(c *C) {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	srv := &Server{}
+	c.Assert(srv.Serve(l), Equals, ErrNoHostKeys)
+}
+
+func (s *ServerSuite) TestNoLoader(c *C) {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	srv := &Server{HostKeys: []stdssh.Signer{s.hostKey}}
+	c.Assert(srv.Serve(l), Equals, ErrNoLoader)
+}
+
+func (s *ServerSuite) TestShutdown(c *C) {
+	c.Assert(s.server.Shutdown(context.Background()), IsNil)
+	c.Assert(<-s.served, Equals, ErrServerClosed)
+
+	_, err := net.Dial("tcp", s.addr)
+	c.Assert(err, NotNil)
+
+	s.served <- ErrServerClosed
+}
+
+func (s *ServerSuite) TestGitClone(c *C) {
+	if err := exec.Command("git", "--version").Run(); err != nil {
+		c.Skip("git command not found")
+	}
+
+	if err := exec.Command("ssh-keygen", "-?").Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			c.Skip("ssh-keygen command not found")
+		}
+	}
+
+	keyPath := filepath.Join(s.base, "id_ed25519")
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", keyPath).CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	pub, err := ioutil.ReadFile(keyPath + ".pub")
+	c.Assert(err, IsNil)
+
+	callback, err := NewAuthorizedKeysCallback(pub)
+	c.Assert(err, IsNil)
+	s.startServer(c, &Server{PublicKeyCallback: callback})
+
+	s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	host, port, err := net.SplitHostPort(s.addr)
+	c.Assert(err, IsNil)
+
+	cmd := exec.Command("git", "clone", "--bare",
+		fmt.Sprintf("git@%s:basic.git", host),
+		filepath.Join(s.base, "clone.git"),
+	)
+
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GIT_SSH_COMMAND=ssh -F /dev/null -i %s -p %s "+
+		"-o IdentitiesOnly=yes -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null",
+		keyPath, port,
+	))
+
+	out, err = cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", out))
+
+	out, err = exec.Command("git", "-C", filepath.Join(s.base, "clone.git"),
+		"rev-parse", "refs/heads/master",
+	).Output()
+
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5\n")
+}
+
+func (s *ServerSuite) TestParseCommand(c *C) {
+	for command, expected := range map[string][2]string{
+		"git-upload-pack '/foo.git'":         {"git-upload-pack", "/foo.git"},
+		"git-receive-pack 'foo.git'":         {"git-receive-pack", "foo.git"},
+		"git upload-pack '/foo.git'":         {"git-upload-pack", "/foo.git"},
+		"git-upload-pack /foo.git":           {"git-upload-pack", "/foo.git"},
+		`git-upload-pack '/it'\''s'\!'.git'`: {"git-upload-pack", "/it's!.git"},
+	} {
+		service, path, err := parseCommand(command)
+		c.Assert(err, IsNil, Commentf("%q", command))
+		c.Assert([2]string{service, path}, Equals, expected)
+	}
+
+	for _, command := range []string{
+		"",
+		"git-upload-pack",
+		"git-upload-pack ''",
+		"git-upload-pack '/foo.git",
+		"git-upload-pack '/foo.git' bar",
+		"git-upload-archive '/foo.git'",
+		"git upload-pack",
+		"sh -c 'rm -rf /'",
+	} {
+		_, _, err := parseCommand(command)
+		c.Assert(err, Equals, errInvalidCommand, Commentf("%q", command))
+	}
+}
+
+func (s *ServerSuite) TestNewAuthorizedKeysCallback(c *C) {
+	other := generateSigner(c)
+	data := append([]byte("# comment\n\n"), stdssh.MarshalAuthorizedKey(s.key.PublicKey())...)
+
+	callback, err := NewAuthorizedKeysCallback(data)
+	c.Assert(err, IsNil)
+	c.Assert(callback("git", s.key.PublicKey()), IsNil)
+	c.Assert(callback("git", other.PublicKey()), Equals, ErrUnknownKey)
+
+	_, err = NewAuthorizedKeysCallback([]byte("invalid"))
+	c.Assert(err, NotNil)
+}
+
+// ServerUploadPackSuite runs the upload-pack tests against a Server.
+type ServerUploadPackSuite struct {
+	test.UploadPackSuite
+	BaseServerSuite
+}
+
+var _ = Suite(&ServerUploadPackSuite{})
+
+func (s *ServerUploadPackSuite) SetUpTest(c *C) {
+	s.BaseServerSuite.SetUpTest(c)
+
+	s.UploadPackSuite.Client = DefaultClient
+	s.UploadPackSuite.EmptyAuth = s.auth
+	s.UploadPackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.UploadPackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.UploadPackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
+
+// ServerReceivePackSuite runs the receive-pack tests against a Server.
+type ServerReceivePackSuite struct {
+	test.ReceivePackSuite
+	BaseServerSuite
+}
+
+var _ = Suite(&ServerReceivePackSuite{})
+
+func (s *ServerReceivePackSuite) SetUpTest(c *C) {
+	s.BaseServerSuite.SetUpTest(c)
+	s.startServer(c, &Server{
+		AuthorizeCallback: func(string, stdssh.PublicKey, string, *transport.Endpoint) error {
+			return nil
+		},
+	})
+
+	s.ReceivePackSuite.Client = DefaultClient
+	s.ReceivePackSuite.EmptyAuth = s.auth
+	s.ReceivePackSuite.Endpoint = s.prepareRepository(c, fixtures.Basic().One(), "basic.git")
+	s.ReceivePackSuite.EmptyEndpoint = s.prepareRepository(c, fixtures.ByTag("empty").One(), "empty.git")
+	s.ReceivePackSuite.NonExistentEndpoint = s.newEndpoint(c, "non-existent.git")
+}
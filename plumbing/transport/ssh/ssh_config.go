@@ -0,0 +1,570 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/skeema/knownhosts"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxProxyJumps is the maximum number of hosts connected to, through the
+// ProxyJump of the hosts, to reach a server.
+const maxProxyJumps = 16
+
+var (
+	errTooManyProxyJumps = errors.New("too many proxy jumps")
+	errNoIdentityFile    = errors.New("no usable identity file")
+	errInvalidHostname   = errors.New("hostname contains invalid characters")
+	errInvalidUser       = errors.New("remote username contains invalid characters")
+)
+
+// defaultHostKeyAlgorithms are the host key algorithms used by x/crypto/ssh
+// by default, in order of preference.
+var defaultHostKeyAlgorithms = []string{
+	ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+	ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01,
+	ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+
+	ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	ssh.KeyAlgoRSA, ssh.KeyAlgoDSA,
+
+	ssh.KeyAlgoED25519,
+}
+
+// defaultIdentityFiles are the private keys used when IdentityFile isn't set,
+// as for OpenSSH.
+var defaultIdentityFiles = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_dsa",
+}
+
+// sshConfigValue returns the value of a key of the ssh_config of a host, or
+// an empty string if it's not set or DefaultSSHConfig is nil. The defaults of
+// ssh_config are ignored, the transport having its own.
+func sshConfigValue(alias, key string) string {
+	if DefaultSSHConfig == nil {
+		return ""
+	}
+
+	value := DefaultSSHConfig.Get(alias, key)
+	if value == ssh_config.Default(key) {
+		return ""
+	}
+
+	return value
+}
+
+// sshConfigValues is as sshConfigValue for the keys which may be repeated, as
+// IdentityFile. Only the first value is returned if DefaultSSHConfig doesn't
+// implement GetAll.
+func sshConfigValues(alias, key string) []string {
+	c, ok := DefaultSSHConfig.(interface {
+		GetAll(alias, key string) []string
+	})
+
+	if !ok {
+		if value := sshConfigValue(alias, key); value != "" {
+			return []string{value}
+		}
+
+		return nil
+	}
+
+	var values []string
+	for _, value := range c.GetAll(alias, key) {
+		if value != "" && value != ssh_config.Default(key) {
+			values = append(values, value)
+		}
+	}
+
+	return values
+}
+
+// tokens are the values of the tokens of ssh_config for a connection.
+type tokens struct {
+	// alias is the host as given, %n.
+	alias string
+	// host is the host name, after the Hostname of ssh_config, %h.
+	host string
+	// port is the port, %p.
+	port string
+	// user is the remote user, %r.
+	user string
+}
+
+// expand expands the tokens of s, and the leading ~ of a path. The local user
+// and home directory are %u and %d, and %% is a literal %.
+func (t tokens) expand(s string) string {
+	home, _ := os.UserHomeDir()
+	if s == "~" || strings.HasPrefix(s, "~/") {
+		s = home + s[1:]
+	}
+
+	if !strings.Contains(s, "%") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteString(t.alias)
+		case 'h':
+			b.WriteString(t.host)
+		case 'p':
+			b.WriteString(t.port)
+		case 'r':
+			b.WriteString(t.user)
+		case 'u':
+			u, _ := username()
+			b.WriteString(u)
+		case 'd':
+			b.WriteString(home)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+func newTokens(alias, addr, user string) tokens {
+	t := tokens{alias: alias, host: addr, user: user}
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		t.host, t.port = host, port
+	}
+
+	return t
+}
+
+// validate checks that the hosts and the user can't be interpreted by the
+// shell once expanded in a ProxyCommand, rejecting the shell metacharacters
+// and control characters as OpenSSH does.
+func (t tokens) validate() error {
+	for _, host := range []string{t.alias, t.host} {
+		if strings.HasPrefix(host, "-") || strings.ContainsAny(host, "'`\"$\\;&<>|(){}") ||
+			strings.IndexFunc(host, isSpaceOrControl) >= 0 {
+			return errInvalidHostname
+		}
+	}
+
+	if strings.HasPrefix(t.user, "-") || strings.ContainsAny(t.user, "'`\";&<>|(){}") ||
+		strings.HasSuffix(t.user, "\\") || strings.IndexFunc(t.user, unicode.IsControl) >= 0 {
+		return errInvalidUser
+	}
+
+	for i := 0; i < len(t.user)-1; i++ {
+		if unicode.IsSpace(rune(t.user[i])) && t.user[i+1] == '-' {
+			return errInvalidUser
+		}
+	}
+
+	return nil
+}
+
+func isSpaceOrControl(r rune) bool {
+	return unicode.IsSpace(r) || unicode.IsControl(r)
+}
+
+// newIdentityFilesAuth returns an AuthMethod with the unencrypted private keys
+// of the IdentityFile of the ssh_config of a host, or of the default ones if
+// not set. The keys which can't be read are skipped.
+func newIdentityFilesAuth(alias, addr, user string) (*PublicKeysCallback, error) {
+	var err error
+	if user == "" {
+		if user, err = username(); err != nil {
+			return nil, err
+		}
+	}
+
+	files := sshConfigValues(alias, "IdentityFile")
+	if len(files) == 0 {
+		files = defaultIdentityFiles
+	}
+
+	t := newTokens(alias, addr, user)
+
+	var signers []ssh.Signer
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(t.expand(file))
+		if err != nil {
+			continue
+		}
+
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			continue
+		}
+
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 {
+		return nil, errNoIdentityFile
+	}
+
+	return &PublicKeysCallback{
+		User: user,
+		Callback: func() ([]ssh.Signer, error) {
+			return signers, nil
+		},
+	}, nil
+}
+
+// newHostKeyCallback returns the host key callback for the server at addr,
+// and its host key algorithms, following the UserKnownHostsFile,
+// GlobalKnownHostsFile and StrictHostKeyChecking of the ssh_config of the
+// host.
+//
+// As no prompt is possible, the "ask" StrictHostKeyChecking is the same as
+// "yes". With "accept-new", the keys of the unknown hosts are added to the
+// first known_hosts file, and with "no" the changed keys are also accepted.
+func newHostKeyCallback(alias, addr string) (ssh.HostKeyCallback, []string, error) {
+	files, err := knownHostsFiles(alias, addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	strict := strings.ToLower(sshConfigValue(alias, "StrictHostKeyChecking"))
+	acceptNew := strict == "accept-new" || strict == "no" || strict == "off"
+
+	kh, err := newKnownHosts(files...)
+	if err != nil {
+		if !acceptNew {
+			return nil, nil, err
+		}
+
+		if kh, err = knownhosts.New(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	cb := kh.HostKeyCallback()
+	if acceptNew {
+		cb = acceptHostKeys(cb, files[0], strict != "accept-new")
+	}
+
+	return cb, kh.HostKeyAlgorithms(addr), nil
+}
+
+// knownHostsFiles returns the known_hosts files of a host: the ones of
+// SSH_KNOWN_HOSTS if set, otherwise the UserKnownHostsFile and
+// GlobalKnownHostsFile of its ssh_config, or the defaults.
+func knownHostsFiles(alias, addr string) ([]string, error) {
+	files, err := getDefaultKnownHostsFiles()
+	if err != nil || os.Getenv("SSH_KNOWN_HOSTS") != "" {
+		return files, err
+	}
+
+	user := strings.Fields(sshConfigValue(alias, "UserKnownHostsFile"))
+	global := strings.Fields(sshConfigValue(alias, "GlobalKnownHostsFile"))
+	if len(user) == 0 && len(global) == 0 {
+		return files, nil
+	}
+
+	if len(user) == 0 {
+		user = files[:1]
+	}
+
+	if len(global) == 0 {
+		global = files[1:]
+	}
+
+	t := newTokens(alias, addr, "")
+
+	files = nil
+	for _, file := range append(user, global...) {
+		files = append(files, t.expand(file))
+	}
+
+	return files, nil
+}
+
+// acceptHostKeys returns a host key callback accepting the keys of the hosts
+// unknown to cb, adding them to a known_hosts file, and the changed ones if
+// acceptChanged.
+func acceptHostKeys(cb ssh.HostKeyCallback, file string, acceptChanged bool) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := cb(hostname, remote, key)
+		switch {
+		case knownhosts.IsHostUnknown(err):
+			return addKnownHost(file, hostname, remote, key)
+		case acceptChanged && knownhosts.IsHostKeyChanged(err):
+			return nil
+		}
+
+		return err
+	}
+}
+
+func addKnownHost(file, hostname string, remote net.Addr, key ssh.PublicKey) (err error) {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return knownhosts.WriteKnownHost(f, hostname, remote, key)
+}
+
+// applySSHConfig sets the HostKeyAlgorithms and Timeout of config from the
+// HostKeyAlgorithms and ConnectTimeout of the ssh_config of a host. The
+// timeout is only set if config has none.
+func applySSHConfig(alias string, config *ssh.ClientConfig) {
+	if value := sshConfigValue(alias, "HostKeyAlgorithms"); value != "" {
+		config.HostKeyAlgorithms = hostKeyAlgorithms(value, config.HostKeyAlgorithms)
+	}
+
+	if config.Timeout == 0 {
+		if seconds, err := strconv.Atoi(sshConfigValue(alias, "ConnectTimeout")); err == nil && seconds > 0 {
+			config.Timeout = time.Duration(seconds) * time.Second
+		}
+	}
+}
+
+// hostKeyAlgorithms returns the host key algorithms of a HostKeyAlgorithms
+// value: the comma-separated ones given or, if prefixed by "+", "-" or "^",
+// the current ones with the given ones appended, removed or prepended. The
+// removed ones may be patterns, as "ssh-rsa*". The current ones are the
+// default ones if empty.
+func hostKeyAlgorithms(value string, current []string) []string {
+	if len(current) == 0 {
+		current = defaultHostKeyAlgorithms
+	}
+
+	op := value[0]
+	if op == '+' || op == '-' || op == '^' {
+		value = value[1:]
+	}
+
+	given := strings.Split(value, ",")
+
+	var algos []string
+	switch op {
+	case '+':
+		algos = append(algos, current...)
+		algos = append(algos, given...)
+	case '^':
+		algos = append(algos, given...)
+		algos = append(algos, current...)
+	case '-':
+		for _, algo := range current {
+			if !matchesAny(algo, given) {
+				algos = append(algos, algo)
+			}
+		}
+	default:
+		return given
+	}
+
+	return dedupe(algos)
+}
+
+func matchesAny(s string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, s); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]bool, len(values))
+
+	var out []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+// dialSSHConfig connects to the SSH server at addr following the ssh_config
+// of its host: through its ProxyJump hosts, its ProxyCommand or, if none,
+// directly. jumps is the number of jump hosts already connected to.
+func dialSSHConfig(alias, addr string, config *ssh.ClientConfig, jumps int) (*ssh.Client, error) {
+	if jump := sshConfigValue(alias, "ProxyJump"); jump != "" && jump != "none" {
+		return dialProxyJump(strings.Split(jump, ","), addr, config, jumps)
+	}
+
+	if command := sshConfigValue(alias, "ProxyCommand"); command != "" && command != "none" {
+		t := newTokens(alias, addr, config.User)
+		if err := t.validate(); err != nil {
+			return nil, err
+		}
+
+		conn, err := dialProxyCommand(t.expand(command))
+		if err != nil {
+			return nil, err
+		}
+
+		return newClient(conn, addr, config)
+	}
+
+	return dial("tcp", addr, transport.ProxyOptions{}, config)
+}
+
+// dialProxyJump connects to the SSH server at addr through the jump hosts
+// given as "[user@]host[:port]", each one being reached through the previous
+// one, and the first one following its ssh_config. The jump hosts are
+// authenticated as the server, and their keys checked with their ssh_config.
+func dialProxyJump(hosts []string, addr string, config *ssh.ClientConfig, jumps int) (*ssh.Client, error) {
+	var client *ssh.Client
+	for _, host := range hosts {
+		jumps++
+		if jumps > maxProxyJumps {
+			return nil, closeOnError(client, errTooManyProxyJumps)
+		}
+
+		user, alias, port, err := parseProxyJump(host)
+		if err != nil {
+			return nil, closeOnError(client, err)
+		}
+
+		jumpAddr := hostWithPort(alias, port)
+		jumpConfig, err := newJumpClientConfig(alias, jumpAddr, user, config)
+		if err != nil {
+			return nil, closeOnError(client, err)
+		}
+
+		if client == nil {
+			client, err = dialSSHConfig(alias, jumpAddr, jumpConfig, jumps)
+		} else {
+			client, err = dialThrough(client, jumpAddr, jumpConfig)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return dialThrough(client, addr, config)
+}
+
+func closeOnError(client *ssh.Client, err error) error {
+	if client != nil {
+		_ = client.Close()
+	}
+
+	return err
+}
+
+// parseProxyJump parses a host of ProxyJump, as "[user@]host[:port]" or
+// "ssh://[user@]host[:port]".
+func parseProxyJump(s string) (user, host string, port int, err error) {
+	host = strings.TrimPrefix(strings.TrimSpace(s), "ssh://")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		user, host = host[:i], host[i+1:]
+	}
+
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		if port, err = strconv.Atoi(p); err != nil {
+			return "", "", 0, fmt.Errorf("invalid proxy jump %q: %s", s, err)
+		}
+
+		host = h
+	}
+
+	if host == "" {
+		return "", "", 0, fmt.Errorf("invalid proxy jump %q", s)
+	}
+
+	return user, host, port, nil
+}
+
+// newJumpClientConfig returns the ssh.ClientConfig of a jump host, with the
+// auth methods of the config of the server.
+func newJumpClientConfig(alias, addr, user string, config *ssh.ClientConfig) (*ssh.ClientConfig, error) {
+	var err error
+	if user == "" {
+		user = sshConfigValue(alias, "User")
+	}
+
+	if user == "" {
+		if user, err = username(); err != nil {
+			return nil, err
+		}
+	}
+
+	cb, algos, err := newHostKeyCallback(alias, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	jumpConfig := &ssh.ClientConfig{
+		Config:            config.Config,
+		User:              user,
+		Auth:              config.Auth,
+		HostKeyCallback:   cb,
+		HostKeyAlgorithms: algos,
+	}
+
+	applySSHConfig(alias, jumpConfig)
+	return jumpConfig, nil
+}
+
+// dialThrough connects to the SSH server at addr through the SSH client c,
+// which is closed along with the returned one.
+func dialThrough(c *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	conn, err := c.Dial("tcp", addr)
+	if err != nil {
+		return nil, closeOnError(c, err)
+	}
+
+	return newClient(&chainedConn{Conn: conn, prev: c}, addr, config)
+}
+
+// chainedConn is a connection through an SSH client, closing the client when
+// closed.
+type chainedConn struct {
+	net.Conn
+	prev *ssh.Client
+}
+
+func (c *chainedConn) Close() error {
+	err := c.Conn.Close()
+	if perr := c.prev.Close(); err == nil {
+		err = perr
+	}
+
+	return err
+}
@@ -0,0 +1,497 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+
+	"github.com/gliderlabs/ssh"
+	fixtures "github.com/go-git/go-git-fixtures/v4"
+	stdssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/testdata"
+	. "gopkg.in/check.v1"
+)
+
+type SSHConfigSuite struct {
+	dir          string
+	config       sshConfig
+	authBuilder  func(string) (AuthMethod, error)
+	knownHosts   string
+	hasKnownHost bool
+	servers      []*ssh.Server
+}
+
+var _ = Suite(&SSHConfigSuite{})
+
+func (s *SSHConfigSuite) SetUpTest(c *C) {
+	s.dir = c.MkDir()
+	s.config = DefaultSSHConfig
+	s.authBuilder = DefaultAuthBuilder
+	s.knownHosts, s.hasKnownHost = os.LookupEnv("SSH_KNOWN_HOSTS")
+	os.Unsetenv("SSH_KNOWN_HOSTS")
+}
+
+func (s *SSHConfigSuite) TearDownTest(c *C) {
+	DefaultSSHConfig = s.config
+	DefaultAuthBuilder = s.authBuilder
+	for _, server := range s.servers {
+		server.Close()
+	}
+
+	s.servers = nil
+	if s.hasKnownHost {
+		os.Setenv("SSH_KNOWN_HOSTS", s.knownHosts)
+	}
+}
+
+func (s *SSHConfigSuite) TestExpandTokens(c *C) {
+	home, err := os.UserHomeDir()
+	c.Assert(err, IsNil)
+
+	t := newTokens("alias", "example.com:2222", "git")
+	c.Assert(t.expand("~/.ssh/%n_%h_%p_%r"), Equals, home+"/.ssh/alias_example.com_2222_git")
+	c.Assert(t.expand("%d/100%%"), Equals, home+"/100%")
+	c.Assert(t.expand("%x%"), Equals, "%x%")
+	c.Assert(t.expand("/tmp/~"), Equals, "/tmp/~")
+}
+
+func (s *SSHConfigSuite) TestParseProxyJump(c *C) {
+	for _, t := range []struct {
+		value, user, host string
+		port              int
+	}{
+		{"bastion", "", "bastion", 0},
+		{"user@bastion:2222", "user", "bastion", 2222},
+		{"ssh://user@bastion:2222", "user", "bastion", 2222},
+		{"[::1]:2222", "", "::1", 2222},
+		{" bastion ", "", "bastion", 0},
+	} {
+		user, host, port, err := parseProxyJump(t.value)
+		c.Assert(err, IsNil)
+		c.Assert(user, Equals, t.user, Commentf("%s", t.value))
+		c.Assert(host, Equals, t.host, Commentf("%s", t.value))
+		c.Assert(port, Equals, t.port, Commentf("%s", t.value))
+	}
+
+	_, _, _, err := parseProxyJump("user@")
+	c.Assert(err, ErrorMatches, "invalid proxy jump.*")
+	_, _, _, err = parseProxyJump("bastion:port")
+	c.Assert(err, ErrorMatches, "invalid proxy jump.*")
+}
+
+func (s *SSHConfigSuite) TestHostKeyAlgorithms(c *C) {
+	current := []string{"ssh-ed25519", "rsa-sha2-512", "ssh-rsa"}
+
+	c.Assert(hostKeyAlgorithms("ssh-ed25519,ecdsa-sha2-nistp256", current), DeepEquals,
+		[]string{"ssh-ed25519", "ecdsa-sha2-nistp256"})
+	c.Assert(hostKeyAlgorithms("+ssh-dss,ssh-rsa", current), DeepEquals,
+		[]string{"ssh-ed25519", "rsa-sha2-512", "ssh-rsa", "ssh-dss"})
+	c.Assert(hostKeyAlgorithms("^ssh-rsa", current), DeepEquals,
+		[]string{"ssh-rsa", "ssh-ed25519", "rsa-sha2-512"})
+	c.Assert(hostKeyAlgorithms("-rsa-*,ssh-rsa", current), DeepEquals,
+		[]string{"ssh-ed25519"})
+
+	algos := hostKeyAlgorithms("-ssh-rsa*,ssh-dss*", nil)
+	c.Assert(algos, Not(HasLen), 0)
+	for _, algo := range algos {
+		c.Assert(strings.HasPrefix(algo, "ssh-rsa"), Equals, false)
+		c.Assert(strings.HasPrefix(algo, "ssh-dss"), Equals, false)
+	}
+}
+
+func (s *SSHConfigSuite) TestApplySSHConfig(c *C) {
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {
+			"HostKeyAlgorithms": "ssh-ed25519",
+			"ConnectTimeout":    "7",
+		},
+	}}
+
+	config := &stdssh.ClientConfig{HostKeyAlgorithms: []string{"ssh-rsa"}}
+	applySSHConfig("github.com", config)
+	c.Assert(config.HostKeyAlgorithms, DeepEquals, []string{"ssh-ed25519"})
+	c.Assert(config.Timeout, Equals, 7*time.Second)
+
+	config = &stdssh.ClientConfig{Timeout: time.Second}
+	applySSHConfig("github.com", config)
+	c.Assert(config.Timeout, Equals, time.Second)
+
+	config = &stdssh.ClientConfig{}
+	applySSHConfig("gitlab.com", config)
+	c.Assert(config.HostKeyAlgorithms, HasLen, 0)
+	c.Assert(config.Timeout, Equals, time.Duration(0))
+}
+
+func (s *SSHConfigSuite) TestKnownHostsFiles(c *C) {
+	home, err := os.UserHomeDir()
+	c.Assert(err, IsNil)
+
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {
+			"UserKnownHostsFile": "~/.ssh/known_hosts_%h /tmp/known_hosts",
+		},
+		"gitlab.com": {
+			"GlobalKnownHostsFile": "/etc/known_hosts",
+		},
+	}}
+
+	files, err := knownHostsFiles("github.com", "github.com:22")
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{
+		filepath.Join(home, ".ssh/known_hosts_github.com"),
+		"/tmp/known_hosts",
+		"/etc/ssh/ssh_known_hosts",
+	})
+
+	files, err = knownHostsFiles("gitlab.com", "gitlab.com:22")
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{filepath.Join(home, ".ssh/known_hosts"), "/etc/known_hosts"})
+
+	os.Setenv("SSH_KNOWN_HOSTS", "/tmp/env_known_hosts")
+	defer os.Unsetenv("SSH_KNOWN_HOSTS")
+
+	files, err = knownHostsFiles("github.com", "github.com:22")
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{"/tmp/env_known_hosts"})
+}
+
+func (s *SSHConfigSuite) TestHostKeyCallbackStrict(c *C) {
+	knownHosts := filepath.Join(s.dir, "known_hosts")
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {
+			"UserKnownHostsFile":   knownHosts,
+			"GlobalKnownHostsFile": filepath.Join(s.dir, "global_known_hosts"),
+		},
+	}}
+
+	_, _, err := newHostKeyCallback("github.com", "github.com:22")
+	c.Assert(err, ErrorMatches, "unable to find any valid known_hosts file.*")
+
+	key := s.publicKey(c, "ed25519")
+	c.Assert(addKnownHost(knownHosts, "github.com:22", s.addr(), key), IsNil)
+
+	cb, algos, err := newHostKeyCallback("github.com", "github.com:22")
+	c.Assert(err, IsNil)
+	c.Assert(algos, DeepEquals, []string{stdssh.KeyAlgoED25519})
+	c.Assert(cb("github.com:22", s.addr(), key), IsNil)
+	c.Assert(cb("github.com:22", s.addr(), s.publicKey(c, "rsa")), NotNil)
+	c.Assert(cb("gitlab.com:22", s.addr(), key), NotNil)
+}
+
+func (s *SSHConfigSuite) TestHostKeyCallbackAcceptNew(c *C) {
+	knownHosts := filepath.Join(s.dir, "ssh", "known_hosts")
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {
+			"UserKnownHostsFile":    knownHosts,
+			"StrictHostKeyChecking": "accept-new",
+		},
+	}}
+
+	cb, _, err := newHostKeyCallback("github.com", "github.com:22")
+	c.Assert(err, IsNil)
+
+	key := s.publicKey(c, "ed25519")
+	c.Assert(cb("github.com:22", s.addr(), key), IsNil)
+
+	data, err := ioutil.ReadFile(knownHosts)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, "github.com,127.0.0.1 ssh-ed25519 .*\n")
+
+	cb, _, err = newHostKeyCallback("github.com", "github.com:22")
+	c.Assert(err, IsNil)
+	c.Assert(cb("github.com:22", s.addr(), key), IsNil)
+	c.Assert(cb("github.com:22", s.addr(), s.publicKey(c, "rsa")), NotNil)
+}
+
+func (s *SSHConfigSuite) TestHostKeyCallbackNo(c *C) {
+	knownHosts := filepath.Join(s.dir, "known_hosts")
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {
+			"UserKnownHostsFile":    knownHosts,
+			"StrictHostKeyChecking": "no",
+		},
+	}}
+
+	c.Assert(addKnownHost(knownHosts, "github.com:22", s.addr(), s.publicKey(c, "ed25519")), IsNil)
+
+	cb, _, err := newHostKeyCallback("github.com", "github.com:22")
+	c.Assert(err, IsNil)
+	c.Assert(cb("github.com:22", s.addr(), s.publicKey(c, "rsa")), IsNil)
+}
+
+func (s *SSHConfigSuite) TestClientConfigKeepsAuth(c *C) {
+	knownHosts := filepath.Join(s.dir, "known_hosts")
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {
+			"UserKnownHostsFile":    knownHosts,
+			"StrictHostKeyChecking": "no",
+		},
+	}}
+
+	auth := &Password{User: "git", Password: "secret"}
+	cmd := &command{endpoint: &transport.Endpoint{Host: "github.com", Port: 22}, auth: auth}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			config, err := cmd.clientConfig("github.com:22")
+			c.Check(err, IsNil)
+			c.Check(config.HostKeyCallback, NotNil)
+			c.Check(auth.HostKeyCallback, IsNil)
+		}()
+	}
+
+	wg.Wait()
+	c.Assert(auth.HostKeyCallback, IsNil)
+}
+
+func (s *SSHConfigSuite) TestIdentityFile(c *C) {
+	key := filepath.Join(s.dir, "id_github")
+	encrypted := filepath.Join(s.dir, "id_encrypted")
+	c.Assert(ioutil.WriteFile(key, testdata.PEMBytes["ed25519"], 0600), IsNil)
+	c.Assert(ioutil.WriteFile(encrypted, testdata.PEMEncryptedKeys[0].PEMBytes, 0600), IsNil)
+
+	DefaultAuthBuilder = func(string) (AuthMethod, error) {
+		return nil, errors.New("no agent")
+	}
+
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {
+			"User":         "alice",
+			"IdentityFile": encrypted,
+		},
+		"gitlab.com": {
+			"IdentityFile": filepath.Join(s.dir, "id_%n"),
+		},
+	}}
+
+	ep, err := transport.NewEndpoint("ssh://github.com/foo/bar.git")
+	c.Assert(err, IsNil)
+
+	cmd := &command{endpoint: ep}
+	c.Assert(cmd.setAuthFromEndpoint("github.com:22"), ErrorMatches, "no agent")
+
+	DefaultSSHConfig.(*mockSSHConfig).Values["github.com"]["IdentityFile"] = key
+	c.Assert(cmd.setAuthFromEndpoint("github.com:22"), IsNil)
+
+	auth, ok := cmd.auth.(*PublicKeysCallback)
+	c.Assert(ok, Equals, true)
+	c.Assert(auth.User, Equals, "alice")
+
+	signers, err := auth.Callback()
+	c.Assert(err, IsNil)
+	c.Assert(signers, HasLen, 1)
+	c.Assert(signers[0].PublicKey().Type(), Equals, stdssh.KeyAlgoED25519)
+
+	ep, err = transport.NewEndpoint("git@gitlab.com:foo/bar.git")
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(s.dir, "id_gitlab.com"), testdata.PEMBytes["rsa"], 0600), IsNil)
+
+	cmd = &command{endpoint: ep}
+	c.Assert(cmd.setAuthFromEndpoint("gitlab.com:22"), IsNil)
+	c.Assert(cmd.auth.(*PublicKeysCallback).User, Equals, "git")
+}
+
+func (s *SSHConfigSuite) TestUser(c *C) {
+	var user string
+	DefaultAuthBuilder = func(u string) (AuthMethod, error) {
+		user = u
+		return &Password{User: u}, nil
+	}
+
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"github.com": {"User": "alice"},
+	}}
+
+	ep, err := transport.NewEndpoint("ssh://github.com/foo/bar.git")
+	c.Assert(err, IsNil)
+
+	c.Assert((&command{endpoint: ep}).setAuthFromEndpoint("github.com:22"), IsNil)
+	c.Assert(user, Equals, "alice")
+
+	ep, err = transport.NewEndpoint("git@github.com:foo/bar.git")
+	c.Assert(err, IsNil)
+
+	c.Assert((&command{endpoint: ep}).setAuthFromEndpoint("github.com:22"), IsNil)
+	c.Assert(user, Equals, "git")
+}
+
+func (s *SSHConfigSuite) TestProxyJump(c *C) {
+	target, path := s.startGitServer(c)
+	bastion, forwards := s.startJumpServer(c)
+	knownHosts := filepath.Join(s.dir, "known_hosts")
+
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"target": {
+			"Hostname":              "localhost",
+			"Port":                  fmt.Sprint(target),
+			"ProxyJump":             "first,jumper@second",
+			"UserKnownHostsFile":    knownHosts,
+			"StrictHostKeyChecking": "accept-new",
+		},
+		"first": {
+			"Hostname":              "localhost",
+			"Port":                  fmt.Sprint(bastion),
+			"UserKnownHostsFile":    knownHosts,
+			"StrictHostKeyChecking": "accept-new",
+		},
+		"second": {
+			"Hostname":              "127.0.0.1",
+			"Port":                  fmt.Sprint(bastion),
+			"UserKnownHostsFile":    knownHosts,
+			"StrictHostKeyChecking": "accept-new",
+		},
+	}}
+
+	s.assertAdvertisedReferences(c, "ssh://git@target"+path)
+	c.Assert(atomic.LoadInt32(forwards), Equals, int32(2))
+
+	// The second jump host, the same server by its IP, is already known.
+	data, err := ioutil.ReadFile(knownHosts)
+	c.Assert(err, IsNil)
+	c.Assert(strings.Count(string(data), "\n"), Equals, 2)
+}
+
+func (s *SSHConfigSuite) TestProxyJumpLoop(c *C) {
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"target": {"ProxyJump": "target", "StrictHostKeyChecking": "no"},
+	}}
+
+	config := &stdssh.ClientConfig{User: "git"}
+	_, err := dialSSHConfig("target", "target:22", config, 0)
+	c.Assert(err, Equals, errTooManyProxyJumps)
+}
+
+func (s *SSHConfigSuite) TestProxyCommand(c *C) {
+	if _, err := exec.LookPath("ssh"); err != nil || runtime.GOOS == "windows" {
+		c.Skip("the ProxyCommand requires OpenSSH")
+	}
+
+	target, path := s.startGitServer(c)
+	bastion, forwards := s.startJumpServer(c)
+
+	DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+		"target": {
+			"Hostname": "localhost",
+			"Port":     fmt.Sprint(target),
+			"ProxyCommand": fmt.Sprintf("ssh -W %%h:%%p -p %d -o BatchMode=yes "+
+				"-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o LogLevel=ERROR localhost", bastion),
+			"UserKnownHostsFile":    filepath.Join(s.dir, "known_hosts"),
+			"StrictHostKeyChecking": "accept-new",
+		},
+	}}
+
+	s.assertAdvertisedReferences(c, "ssh://git@target"+path)
+	c.Assert(atomic.LoadInt32(forwards), Equals, int32(1))
+}
+
+func (s *SSHConfigSuite) TestProxyCommandInjection(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("the ProxyCommand is run by sh")
+	}
+
+	pwned := filepath.Join(s.dir, "pwned")
+	for _, t := range []struct {
+		alias, user string
+		err         error
+	}{
+		{"a;touch " + pwned, "git", errInvalidHostname},
+		{"$(touch " + pwned + ")", "git", errInvalidHostname},
+		{"-oProxyCommand=touch", "git", errInvalidHostname},
+		{"a\ntouch " + pwned, "git", errInvalidHostname},
+		{"target", "git`touch " + pwned + "`", errInvalidUser},
+		{"target", "git -oProxyCommand", errInvalidUser},
+	} {
+		DefaultSSHConfig = &mockSSHConfig{Values: map[string]map[string]string{
+			t.alias: {"ProxyCommand": "echo %n %h %r", "StrictHostKeyChecking": "no"},
+		}}
+
+		config := &stdssh.ClientConfig{User: t.user}
+		_, err := dialSSHConfig(t.alias, net.JoinHostPort(t.alias, "22"), config, 0)
+		c.Assert(err, Equals, t.err, Commentf("%q %q", t.alias, t.user))
+	}
+
+	_, err := os.Stat(pwned)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SSHConfigSuite) assertAdvertisedReferences(c *C, url string) {
+	ep, err := transport.NewEndpoint(url)
+	c.Assert(err, IsNil)
+
+	session, err := DefaultClient.NewUploadPackSession(ep, &Password{User: "git"})
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(session.Close(), IsNil) }()
+
+	ar, err := session.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	c.Assert(ar.Head, NotNil)
+}
+
+// startGitServer starts an SSH server running git, returning its port and
+// the path of a repository.
+func (s *SSHConfigSuite) startGitServer(c *C) (int, string) {
+	if runtime.GOOS == "js" {
+		c.Skip("tcp connections are not available in wasm")
+	}
+
+	fs := fixtures.Basic().One().DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+
+	server := &ssh.Server{Handler: handlerSSH}
+	c.Assert(server.SetOption(ssh.HostKeyPEM(testdata.PEMBytes["ed25519"])), IsNil)
+
+	return s.serve(c, server), filepath.ToSlash(fs.Root())
+}
+
+// startJumpServer starts an SSH server forwarding connections, returning its
+// port and the number of connections forwarded.
+func (s *SSHConfigSuite) startJumpServer(c *C) (int, *int32) {
+	var forwards int32
+	server := &ssh.Server{
+		LocalPortForwardingCallback: func(ctx ssh.Context, host string, port uint32) bool {
+			atomic.AddInt32(&forwards, 1)
+			return true
+		},
+		ChannelHandlers: map[string]ssh.ChannelHandler{
+			"session":      ssh.DefaultSessionHandler,
+			"direct-tcpip": ssh.DirectTCPIPHandler,
+		},
+	}
+
+	c.Assert(server.SetOption(ssh.HostKeyPEM(testdata.PEMBytes["ecdsa"])), IsNil)
+	return s.serve(c, server), &forwards
+}
+
+func (s *SSHConfigSuite) serve(c *C, server *ssh.Server) int {
+	l, err := net.Listen("tcp", "localhost:0")
+	c.Assert(err, IsNil)
+
+	s.servers = append(s.servers, server)
+	go server.Serve(l)
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func (s *SSHConfigSuite) publicKey(c *C, name string) stdssh.PublicKey {
+	signer, err := stdssh.ParsePrivateKey(testdata.PEMBytes[name])
+	c.Assert(err, IsNil)
+
+	return signer.PublicKey()
+}
+
+func (s *SSHConfigSuite) addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+}
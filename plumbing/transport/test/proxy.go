@@ -0,0 +1,125 @@
+package test
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+)
+
+// HTTPProxy is an HTTP proxy tunneling the connections requested with
+// CONNECT and forwarding the plain HTTP requests, requiring basic
+// credentials if a username is set.
+type HTTPProxy struct {
+	// URL is the URL of the proxy, without the credentials.
+	URL string
+
+	server             *httptest.Server
+	username, password string
+	requests           int32
+}
+
+// NewHTTPProxy starts an HTTP proxy requiring the given credentials, if a
+// username is given.
+func NewHTTPProxy(username, password string) *HTTPProxy {
+	p := &HTTPProxy{username: username, password: password}
+	p.server = httptest.NewServer(http.HandlerFunc(p.serveHTTP))
+	p.URL = p.server.URL
+	return p
+}
+
+// Requests returns the number of requests authorized by the proxy.
+func (p *HTTPProxy) Requests() int {
+	return int(atomic.LoadInt32(&p.requests))
+}
+
+// Close stops the proxy.
+func (p *HTTPProxy) Close() {
+	p.server.CloseClientConnections()
+	p.server.Close()
+}
+
+func (p *HTTPProxy) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="proxy"`)
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	atomic.AddInt32(&p.requests, 1)
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	r.RequestURI = ""
+	r.Header.Del("Proxy-Authorization")
+	res, err := http.DefaultTransport.RoundTrip(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	defer res.Body.Close()
+	for k, v := range res.Header {
+		w.Header()[k] = v
+	}
+
+	w.WriteHeader(res.StatusCode)
+	_, _ = io.Copy(w, res.Body)
+}
+
+func (p *HTTPProxy) authorized(r *http.Request) bool {
+	if p.username == "" {
+		return true
+	}
+
+	auth := r.Header.Get("Proxy-Authorization")
+	if !strings.HasPrefix(auth, "Basic ") {
+		return false
+	}
+
+	credentials, err := base64.StdEncoding.DecodeString(auth[len("Basic "):])
+	return err == nil && string(credentials) == p.username+":"+p.password
+}
+
+func (p *HTTPProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	dst, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+
+	defer dst.Close()
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	src, buf, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+
+	defer src.Close()
+	if _, err := src.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(dst, buf)
+		done <- struct{}{}
+	}()
+
+	go func() {
+		_, _ = io.Copy(src, dst)
+		done <- struct{}{}
+	}()
+
+	<-done
+}
@@ -254,6 +254,123 @@ func (s *UploadPackSuite) TestFetchError(c *C) {
 	//     different errors if a previous error was found.
 }
 
+func (s *UploadPackSuite) TestNegotiatePack(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	nr, ok := r.(transport.NegotiatingUploadPackSession)
+	if !ok || !info.Capabilities.Supports(capability.MultiACKDetailed) {
+		c.Skip("negotiation not supported")
+	}
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(req.Capabilities.Set(capability.MultiACKDetailed), IsNil)
+
+	common := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	n := &negotiator{}
+	for i := 0; i < 40; i++ {
+		if i == 20 {
+			n.haves = append(n.haves, common)
+		}
+
+		n.haves = append(n.haves, plumbing.ComputeHash(plumbing.CommitObject, []byte{byte(i)}))
+	}
+
+	reader, err := nr.NegotiatePack(context.Background(), req, n)
+	c.Assert(err, IsNil)
+	// Once a common commit is found, the server tells to continue on the
+	// following unknown ones.
+	c.Assert(n.acks[0], Equals, common)
+
+	s.checkObjectNumber(c, reader, 4)
+}
+
+func (s *UploadPackSuite) TestNegotiatePackNoHaves(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+
+	nr, ok := r.(transport.NegotiatingUploadPackSession)
+	if !ok || !info.Capabilities.Supports(capability.MultiACKDetailed) {
+		c.Skip("negotiation not supported")
+	}
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(req.Capabilities.Set(capability.MultiACKDetailed), IsNil)
+
+	reader, err := nr.NegotiatePack(context.Background(), req, &negotiator{})
+	c.Assert(err, IsNil)
+
+	s.checkObjectNumber(c, reader, 28)
+}
+
+func (s *UploadPackSuite) TestUploadPackMultiACKDetailed(c *C) {
+	r, err := s.Client.NewUploadPackSession(s.Endpoint, s.EmptyAuth)
+	c.Assert(err, IsNil)
+	defer func() { c.Assert(r.Close(), IsNil) }()
+
+	info, err := r.AdvertisedReferences()
+	c.Assert(err, IsNil)
+	if !info.Capabilities.Supports(capability.MultiACKDetailed) {
+		c.Skip("multi_ack_detailed not supported")
+	}
+
+	req := packp.NewUploadPackRequest()
+	req.Wants = append(req.Wants, plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"))
+	c.Assert(req.Capabilities.Set(capability.MultiACKDetailed), IsNil)
+
+	// All the haves are sent at once, the server acknowledging the common
+	// ones before the final ACK.
+	common := plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294")
+	req.Haves = []plumbing.Hash{
+		plumbing.ComputeHash(plumbing.CommitObject, []byte{1}),
+		common,
+		plumbing.ComputeHash(plumbing.CommitObject, []byte{2}),
+	}
+
+	reader, err := r.UploadPack(context.Background(), req)
+	c.Assert(err, IsNil)
+	c.Assert(reader.ACKs, DeepEquals, []plumbing.Hash{common})
+
+	s.checkObjectNumber(c, reader, 4)
+}
+
+// negotiator is a transport.Negotiator sending the given haves, in order.
+type negotiator struct {
+	haves []plumbing.Hash
+	acks  []plumbing.Hash
+}
+
+func (n *negotiator) Next() (plumbing.Hash, error) {
+	if len(n.haves) == 0 {
+		return plumbing.ZeroHash, io.EOF
+	}
+
+	h := n.haves[0]
+	n.haves = n.haves[1:]
+	return h, nil
+}
+
+func (n *negotiator) Ack(h plumbing.Hash) (bool, error) {
+	for _, ack := range n.acks {
+		if ack == h {
+			return true, nil
+		}
+	}
+
+	n.acks = append(n.acks, h)
+	return false, nil
+}
+
 func (s *UploadPackSuite) checkObjectNumber(c *C, r io.Reader, n int) {
 	b, err := ioutil.ReadAll(r)
 	c.Assert(err, IsNil)
@@ -1,19 +1,26 @@
 package git
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	stdioutil "io/ioutil"
+	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/internal/url"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
 	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/negotiator"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
@@ -22,6 +29,8 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/client"
+	"github.com/go-git/go-git/v5/plumbing/transport/credential"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
 	"github.com/go-git/go-git/v5/storage/memory"
@@ -33,6 +42,30 @@ var (
 	ErrDeleteRefNotSupported = errors.New("server does not support delete-refs")
 	ErrForceNeeded           = errors.New("some refs were not updated")
 	ErrExactSHA1NotSupported = errors.New("server does not support exact SHA1 refspec")
+	ErrShallowNotSupported   = errors.New("server does not support the shallow option")
+	ErrPushCertNotSupported  = errors.New("server does not support signed pushes")
+	ErrUnshallowComplete     = errors.New("unshallow on a complete repository")
+)
+
+const (
+	httpSection      = "http"
+	proxyKey         = "proxy"
+	sslVerifyKey     = "sslVerify"
+	sslCAInfoKey     = "sslCAInfo"
+	sslCertKey       = "sslCert"
+	sslKeyKey        = "sslKey"
+	extraHeaderKey   = "extraHeader"
+	versionKey       = "version"
+	postBufferKey    = "postBuffer"
+	lowSpeedLimitKey = "lowSpeedLimit"
+	lowSpeedTimeKey  = "lowSpeedTime"
+
+	// infiniteDepth is the depth requested to unshallow a repository, as git
+	// does.
+	infiniteDepth = 0x7fffffff
+
+	fetchSection            = "fetch"
+	negotiationAlgorithmKey = "negotiationAlgorithm"
 )
 
 type NoMatchingRefSpecError struct {
@@ -109,18 +142,18 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 		o.RemoteURL = r.c.URLs[0]
 	}
 
-	s, err := newSendPackSession(o.RemoteURL, o.Auth, o.InsecureSkipTLS, o.CABundle)
+	ep, err := r.newEndpoint(o.RemoteURL, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions)
 	if err != nil {
 		return err
 	}
 
-	defer ioutil.CheckClose(s, &err)
-
-	ar, err := s.AdvertisedReferencesContext(ctx)
+	s, ar, err := r.openSendPackSession(ctx, ep, o.Auth)
 	if err != nil {
 		return err
 	}
 
+	defer ioutil.CheckClose(s, &err)
+
 	remoteRefs, err := ar.AllReferences()
 	if err != nil {
 		return err
@@ -170,6 +203,10 @@ func (r *Remote) PushContext(ctx context.Context, o *PushOptions) (err error) {
 		return NoErrAlreadyUpToDate
 	}
 
+	if err := r.signPush(req, o, ep, ar); err != nil {
+		return err
+	}
+
 	objects := objectsToPush(req.Commands)
 
 	haves, err := referencesToHashes(remoteRefs)
@@ -341,11 +378,85 @@ func (r *Remote) newReferenceUpdateRequest(
 	return req, nil
 }
 
+// signPush adds to the request a push certificate of its commands signed
+// with the nonce advertised by the server, as git push --signed does.
+func (r *Remote) signPush(
+	req *packp.ReferenceUpdateRequest,
+	o *PushOptions,
+	ep *transport.Endpoint,
+	ar *packp.AdvRefs,
+) error {
+	if o.Sign == PushSignNever {
+		return nil
+	}
+
+	nonce := ar.Capabilities.Get(capability.PushCert)
+	if len(nonce) == 0 {
+		if o.Sign == PushSignIfAsked {
+			return nil
+		}
+
+		return ErrPushCertNotSupported
+	}
+
+	pushee := *ep
+	pushee.User = ""
+	pushee.Password = ""
+
+	cert := &packp.PushCert{
+		Version:  packp.PushCertVersion,
+		Pusher:   pusherIdent(o.SignKey),
+		Pushee:   pushee.String(),
+		Nonce:    nonce[0],
+		Commands: req.Commands,
+	}
+
+	for _, opt := range req.Options {
+		cert.Options = append(cert.Options, fmt.Sprintf("%s=%s", opt.Key, opt.Value))
+	}
+
+	var payload, signature bytes.Buffer
+	if err := cert.EncodeWithoutSignature(&payload); err != nil {
+		return err
+	}
+
+	if err := openpgp.ArmoredDetachSign(&signature, o.SignKey, &payload, nil); err != nil {
+		return err
+	}
+
+	cert.Signature = signature.String()
+	if !strings.HasSuffix(cert.Signature, "\n") {
+		cert.Signature += "\n"
+	}
+
+	req.PushCert = cert
+	return nil
+}
+
+// pusherIdent returns the identity of the key with the current time, as the
+// pusher of a push certificate.
+func pusherIdent(key *openpgp.Entity) string {
+	sig := &object.Signature{When: time.Now()}
+	if id := key.PrimaryIdentity(); id != nil {
+		sig.Name = id.Name
+		if id.UserId != nil {
+			sig.Name, sig.Email = id.UserId.Name, id.UserId.Email
+		}
+	}
+
+	var b bytes.Buffer
+	_ = sig.Encode(&b)
+	return b.String()
+}
+
 func (r *Remote) updateRemoteReferenceStorage(
 	req *packp.ReferenceUpdateRequest,
 	result *packp.ReportStatus,
 ) error {
+	tx := storage.NewReferenceTransaction(r.s)
+	defer func() { _ = tx.Abort() }()
 
+	queued := make(map[plumbing.ReferenceName]bool)
 	for _, spec := range r.c.Fetch {
 		for _, c := range req.Commands {
 			if !spec.Match(c.Name) {
@@ -353,21 +464,26 @@ func (r *Remote) updateRemoteReferenceStorage(
 			}
 
 			local := spec.Dst(c.Name)
+			if queued[local] {
+				continue
+			}
+
+			queued[local] = true
 			ref := plumbing.NewHashReference(local, c.New)
 			switch c.Action() {
 			case packp.Create, packp.Update:
-				if err := r.s.SetReference(ref); err != nil {
+				if err := tx.SetReference(ref, nil); err != nil {
 					return err
 				}
 			case packp.Delete:
-				if err := r.s.RemoveReference(local); err != nil {
+				if err := tx.RemoveReference(local, nil); err != nil {
 					return err
 				}
 			}
 		}
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 // FetchContext fetches references along with the objects necessary to complete
@@ -410,18 +526,18 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 		o.RemoteURL = r.c.URLs[0]
 	}
 
-	s, err := newUploadPackSession(o.RemoteURL, o.Auth, o.InsecureSkipTLS, o.CABundle)
+	ep, err := r.newEndpoint(o.RemoteURL, o.InsecureSkipTLS, o.CABundle, o.ProxyOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	defer ioutil.CheckClose(s, &err)
-
-	ar, err := s.AdvertisedReferencesContext(ctx)
+	s, ar, err := r.openUploadPackSession(ctx, ep, o.Auth)
 	if err != nil {
 		return nil, err
 	}
 
+	defer ioutil.CheckClose(s, &err)
+
 	req, err := r.newUploadPackRequest(o, ar)
 	if err != nil {
 		return nil, err
@@ -454,13 +570,32 @@ func (r *Remote) fetch(ctx context.Context, o *FetchOptions) (sto storer.Referen
 	}
 
 	req.Wants, err = getWants(r.s, refs)
-	if len(req.Wants) > 0 {
-		req.Haves, err = getHaves(localRefs, remoteRefs, r.s)
+	if f, ok := s.(transport.ObjectFetcherSession); ok && f.FetchesObjects() && len(req.Wants) > 0 {
+		// The objects are fetched one by one, so the walk stops at the
+		// local references, whose history is complete.
+		for _, ref := range localRefs {
+			if ref.Type() == plumbing.HashReference {
+				req.Haves = append(req.Haves, ref.Hash())
+			}
+		}
+
+		if err = f.FetchObjects(ctx, req, r.s); err != nil {
+			return nil, err
+		}
+	} else if len(req.Wants) > 0 {
+		var n negotiator.Negotiator
+		if _, ok := s.(transport.NegotiatingUploadPackSession); ok &&
+			req.Capabilities.Supports(capability.MultiACKDetailed) {
+			n, err = r.newNegotiator(localRefs, remoteRefs)
+		} else {
+			req.Haves, err = getHaves(localRefs, remoteRefs, r.s)
+		}
+
 		if err != nil {
 			return nil, err
 		}
 
-		if err = r.fetchPack(ctx, o, s, req); err != nil {
+		if err = r.fetchPack(ctx, o, s, req, n); err != nil {
 			return nil, err
 		}
 	}
@@ -507,8 +642,101 @@ func depthChanged(before []plumbing.Hash, s storage.Storer) (bool, error) {
 	return false, nil
 }
 
-func newUploadPackSession(url string, auth transport.AuthMethod, insecure bool, cabundle []byte) (transport.UploadPackSession, error) {
-	c, ep, err := newClient(url, auth, insecure, cabundle)
+// openUploadPackSession starts an upload-pack session and gets its advertised
+// references, with the credentials of the helpers if needed.
+func (r *Remote) openUploadPackSession(ctx context.Context, ep *transport.Endpoint, auth transport.AuthMethod) (
+	s transport.UploadPackSession, ar *packp.AdvRefs, err error) {
+
+	err = r.withCredentials(ep.String(), auth, func(auth transport.AuthMethod) error {
+		var err error
+		if s, err = newUploadPackSession(ep, auth); err != nil {
+			return err
+		}
+
+		if ar, err = s.AdvertisedReferencesContext(ctx); err != nil {
+			_ = s.Close()
+		}
+
+		return err
+	})
+
+	return s, ar, err
+}
+
+// openSendPackSession starts a receive-pack session and gets its advertised
+// references, with the credentials of the helpers if needed.
+func (r *Remote) openSendPackSession(ctx context.Context, ep *transport.Endpoint, auth transport.AuthMethod) (
+	s transport.ReceivePackSession, ar *packp.AdvRefs, err error) {
+
+	err = r.withCredentials(ep.String(), auth, func(auth transport.AuthMethod) error {
+		var err error
+		if s, err = newSendPackSession(ep, auth); err != nil {
+			return err
+		}
+
+		if ar, err = s.AdvertisedReferencesContext(ctx); err != nil {
+			_ = s.Close()
+		}
+
+		return err
+	})
+
+	return s, ar, err
+}
+
+// withCredentials calls open with auth or, if it's nil and the server of an
+// HTTP(S) URL requires an authentication, with the credential provided by the
+// credential helpers. The credential is then approved or rejected, depending
+// on the server accepting it.
+func (r *Remote) withCredentials(url string, auth transport.AuthMethod, open func(transport.AuthMethod) error) error {
+	err := open(auth)
+	if err != transport.ErrAuthenticationRequired || auth != nil || !isHTTPURL(url) {
+		return err
+	}
+
+	cfgs, cerr := r.configs()
+	if cerr != nil {
+		return cerr
+	}
+
+	m, cerr := credential.NewManager(url, cfgs...)
+	if cerr != nil {
+		return cerr
+	}
+
+	q, cerr := m.Query(url)
+	if cerr != nil || q.IsComplete() {
+		return err
+	}
+
+	c, cerr := m.Fill(q)
+	if cerr == credential.ErrCredentialNotFound {
+		return err
+	}
+
+	if cerr != nil {
+		return cerr
+	}
+
+	// As for git, a helper failing to store or erase the credential doesn't
+	// change the result of the operation.
+	err = open(&githttp.BasicAuth{Username: c.Username, Password: c.Password})
+	switch err {
+	case nil:
+		_ = m.Approve(c)
+	case transport.ErrAuthenticationRequired:
+		_ = m.Reject(c)
+	}
+
+	return err
+}
+
+func isHTTPURL(url string) bool {
+	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")
+}
+
+func newUploadPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.UploadPackSession, error) {
+	c, err := client.NewClient(ep)
 	if err != nil {
 		return nil, err
 	}
@@ -516,8 +744,8 @@ func newUploadPackSession(url string, auth transport.AuthMethod, insecure bool,
 	return c.NewUploadPackSession(ep, auth)
 }
 
-func newSendPackSession(url string, auth transport.AuthMethod, insecure bool, cabundle []byte) (transport.ReceivePackSession, error) {
-	c, ep, err := newClient(url, auth, insecure, cabundle)
+func newSendPackSession(ep *transport.Endpoint, auth transport.AuthMethod) (transport.ReceivePackSession, error) {
+	c, err := client.NewClient(ep)
 	if err != nil {
 		return nil, err
 	}
@@ -525,33 +753,200 @@ func newSendPackSession(url string, auth transport.AuthMethod, insecure bool, ca
 	return c.NewReceivePackSession(ep, auth)
 }
 
-func newClient(url string, auth transport.AuthMethod, insecure bool, cabundle []byte) (transport.Transport, *transport.Endpoint, error) {
+// newEndpoint returns the endpoint of a URL with the given options. For the
+// HTTP(S) URLs, the http.* and http.<url>.* options of the local, global or
+// system config are used as git does, unless overridden by the given ones:
+// proxy, sslVerify, sslCAInfo, sslCert, sslKey, extraHeader, version,
+// postBuffer, lowSpeedLimit and lowSpeedTime.
+func (r *Remote) newEndpoint(url string, insecure bool, cabundle []byte, proxyOpts transport.ProxyOptions) (*transport.Endpoint, error) {
 	ep, err := transport.NewEndpoint(url)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+
 	ep.InsecureSkipTLS = insecure
 	ep.CaBundle = cabundle
+	ep.Proxy = proxyOpts
 
-	c, err := client.NewClient(ep)
+	if !isHTTPURL(url) {
+		return ep, nil
+	}
+
+	cfgs, err := r.configs()
+	if err != nil {
+		return nil, err
+	}
+
+	var opts format.Options
+	for _, cfg := range cfgs {
+		opts = append(opts, config.URLOptions(cfg.Section(httpSection), url)...)
+	}
+
+	if err := applyHTTPConfig(ep, opts); err != nil {
+		return nil, err
+	}
+
+	return ep, nil
+}
+
+func applyHTTPConfig(ep *transport.Endpoint, opts format.Options) error {
+	if ep.Proxy.URL == "" {
+		ep.Proxy.URL = opts.Get(proxyKey)
+	}
+
+	if verify := opts.Get(sslVerifyKey); verify != "" && !ep.InsecureSkipTLS {
+		ok, err := strconv.ParseBool(verify)
+		if err != nil {
+			return fmt.Errorf("invalid http.%s: %s", sslVerifyKey, verify)
+		}
+
+		ep.InsecureSkipTLS = !ok
+	}
+
+	var err error
+	if path := opts.Get(sslCAInfoKey); path != "" && len(ep.CaBundle) == 0 {
+		if ep.CaBundle, err = readConfigFile(path); err != nil {
+			return err
+		}
+	}
+
+	if path := opts.Get(sslCertKey); path != "" {
+		if ep.ClientCert, err = readConfigFile(path); err != nil {
+			return err
+		}
+
+		// The key may be in the certificate file, as for curl.
+		ep.ClientKey = ep.ClientCert
+		if path := opts.Get(sslKeyKey); path != "" {
+			if ep.ClientKey, err = readConfigFile(path); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, header := range opts.GetAll(extraHeaderKey) {
+		if header == "" {
+			ep.HTTP.ExtraHeaders = nil
+			continue
+		}
+
+		ep.HTTP.ExtraHeaders = append(ep.HTTP.ExtraHeaders, header)
+	}
+
+	if version := opts.Get(versionKey); version != "" {
+		if version != "HTTP/1.1" && version != "HTTP/2" {
+			return fmt.Errorf("invalid http.%s: %s", versionKey, version)
+		}
+
+		ep.HTTP.Version = version
+	}
+
+	for key, value := range map[string]*int{
+		postBufferKey:    &ep.HTTP.PostBuffer,
+		lowSpeedLimitKey: &ep.HTTP.LowSpeedLimit,
+	} {
+		if s := opts.Get(key); s != "" {
+			if *value, err = parseConfigSize(s); err != nil {
+				return fmt.Errorf("invalid http.%s: %s", key, s)
+			}
+		}
+	}
+
+	if s := opts.Get(lowSpeedTimeKey); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid http.%s: %s", lowSpeedTimeKey, s)
+		}
+
+		ep.HTTP.LowSpeedTime = time.Duration(seconds) * time.Second
+	}
+
+	return nil
+}
+
+// readConfigFile reads a file of a pathname option, expanding its leading ~.
+func readConfigFile(path string) ([]byte, error) {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+
+		path = home + path[1:]
+	}
+
+	return stdioutil.ReadFile(path)
+}
+
+// parseConfigSize parses an integer option, with an optional k, m or g unit
+// suffix, as git does.
+func parseConfigSize(s string) (int, error) {
+	unit := 1
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		unit = 1 << 10
+	case 'm', 'M':
+		unit = 1 << 20
+	case 'g', 'G':
+		unit = 1 << 30
+	}
+
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.Atoi(s)
 	if err != nil {
-		return nil, nil, err
+		return 0, err
 	}
 
-	return c, ep, err
+	return n * unit, nil
 }
 
+// configs returns the raw system, global and local configs, in increasing
+// order of precedence.
+func (r *Remote) configs() ([]*format.Config, error) {
+	var cfgs []*format.Config
+	for _, scope := range []config.Scope{config.SystemScope, config.GlobalScope} {
+		cfg, err := config.LoadConfig(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		cfgs = append(cfgs, cfg.Raw)
+	}
+
+	if r.s != nil {
+		cfg, err := r.s.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		cfgs = append(cfgs, cfg.Raw)
+	}
+
+	return cfgs, nil
+}
+
+// fetchPack fetches the packfile of req, negotiating its haves with n if
+// it's not nil.
 func (r *Remote) fetchPack(ctx context.Context, o *FetchOptions, s transport.UploadPackSession,
-	req *packp.UploadPackRequest) (err error) {
+	req *packp.UploadPackRequest, n negotiator.Negotiator) (err error) {
+
+	var reader *packp.UploadPackResponse
+	if n != nil {
+		reader, err = s.(transport.NegotiatingUploadPackSession).NegotiatePack(ctx, req, n)
+	} else {
+		reader, err = s.UploadPack(ctx, req)
+	}
 
-	reader, err := s.UploadPack(ctx, req)
 	if err != nil {
 		return err
 	}
 
 	defer ioutil.CheckClose(reader, &err)
 
-	if err = r.updateShallow(o, reader); err != nil {
+	if err = r.updateShallow(reader); err != nil {
 		return err
 	}
 
@@ -825,6 +1220,62 @@ func getRemoteRefsFromStorer(remoteRefStorer storer.ReferenceStorer) (
 	return remoteRefs, nil
 }
 
+// newNegotiator returns the negotiator of the fetch.negotiationAlgorithm
+// config, whose tips are the local references, the commits of the remote
+// references found locally being known to be common.
+func (r *Remote) newNegotiator(localRefs []*plumbing.Reference, remoteRefs storer.ReferenceStorer) (
+	negotiator.Negotiator, error) {
+
+	cfgs, err := r.configs()
+	if err != nil {
+		return nil, err
+	}
+
+	var algorithm string
+	for _, cfg := range cfgs {
+		if s := cfg.Section(fetchSection); s.HasOption(negotiationAlgorithmKey) {
+			algorithm = s.Option(negotiationAlgorithmKey)
+		}
+	}
+
+	n, err := negotiator.New(algorithm, r.s)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := remoteRefs.IterReferences()
+	if err != nil {
+		return nil, err
+	}
+
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+
+		if r.s.HasEncodedObject(ref.Hash()) != nil {
+			return nil
+		}
+
+		return n.KnownCommon(ref.Hash())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ref := range localRefs {
+		if ref.Type() != plumbing.HashReference {
+			continue
+		}
+
+		if err := n.AddTip(ref.Hash()); err != nil {
+			return nil, err
+		}
+	}
+
+	return n, nil
+}
+
 // getHavesFromRef populates the given `haves` map with the given
 // reference, and up to `maxHavesToVisitPerRef` ancestor commits.
 func getHavesFromRef(
@@ -1068,11 +1519,8 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 
 	req := packp.NewUploadPackRequestFromCapabilities(ar.Capabilities)
 
-	if o.Depth != 0 {
-		req.Depth = packp.DepthCommits(o.Depth)
-		if err := req.Capabilities.Set(capability.Shallow); err != nil {
-			return nil, err
-		}
+	if err := r.setDepth(req, o, ar); err != nil {
+		return nil, err
 	}
 
 	if o.Progress == nil && ar.Capabilities.Supports(capability.NoProgress) {
@@ -1098,6 +1546,65 @@ func (r *Remote) newUploadPackRequest(o *FetchOptions,
 	return req, nil
 }
 
+// setDepth sets the depth of the request from the shallow options, with the
+// capabilities they require.
+func (r *Remote) setDepth(req *packp.UploadPackRequest, o *FetchOptions, ar *packp.AdvRefs) error {
+	var depths packp.Depths
+	caps := []capability.Capability{capability.Shallow}
+	switch {
+	case o.Depth != 0:
+		depths = append(depths, packp.DepthCommits(o.Depth))
+	case o.Deepen != 0:
+		depths = append(depths, packp.DepthCommits(o.Deepen))
+		caps = append(caps, capability.DeepenRelative)
+	case o.Unshallow:
+		shallows, err := r.s.Shallow()
+		if err != nil {
+			return err
+		}
+
+		if len(shallows) == 0 {
+			return ErrUnshallowComplete
+		}
+
+		depths = append(depths, packp.DepthCommits(infiniteDepth))
+	}
+
+	if !o.ShallowSince.IsZero() {
+		depths = append(depths, packp.DepthSince(o.ShallowSince))
+		caps = append(caps, capability.DeepenSince)
+	}
+
+	for _, ref := range o.ShallowExclude {
+		depths = append(depths, packp.DepthReference(ref))
+	}
+
+	if len(o.ShallowExclude) != 0 {
+		caps = append(caps, capability.DeepenNot)
+	}
+
+	if len(depths) == 0 {
+		return nil
+	}
+
+	for _, c := range caps {
+		if !ar.Capabilities.Supports(c) {
+			return fmt.Errorf("%w: %s", ErrShallowNotSupported, c)
+		}
+
+		if err := req.Capabilities.Set(c); err != nil {
+			return err
+		}
+	}
+
+	req.Depth = depths
+	if len(depths) == 1 {
+		req.Depth = depths[0]
+	}
+
+	return nil
+}
+
 func (r *Remote) isSupportedRefSpec(refs []config.RefSpec, ar *packp.AdvRefs) error {
 	var containsIsExact bool
 	for _, ref := range refs {
@@ -1145,6 +1652,12 @@ func (r *Remote) updateLocalReferenceStorage(
 	isWildcard := true
 	forceNeeded := false
 
+	// All the references are updated at once, so a failure doesn't leave
+	// them half-updated.
+	tx := storage.NewReferenceTransaction(r.s)
+	defer func() { _ = tx.Abort() }()
+
+	queued := make(map[plumbing.ReferenceName]bool)
 	for _, spec := range specs {
 		if !spec.IsWildcard() {
 			isWildcard = false
@@ -1177,7 +1690,7 @@ func (r *Remote) updateLocalReferenceStorage(
 				}
 			}
 
-			refUpdated, err := checkAndUpdateReferenceStorerIfNeeded(r.s, new, old)
+			refUpdated, err := queueReferenceUpdateIfNeeded(r.s, tx, queued, new, old)
 			if err != nil {
 				return updated, err
 			}
@@ -1188,21 +1701,24 @@ func (r *Remote) updateLocalReferenceStorage(
 		}
 	}
 
-	if tagMode == NoTags {
-		return updated, nil
-	}
+	if tagMode != NoTags {
+		tags := fetchedRefs
+		if isWildcard {
+			tags = remoteRefs
+		}
 
-	tags := fetchedRefs
-	if isWildcard {
-		tags = remoteRefs
-	}
-	tagUpdated, err := r.buildFetchedTags(tags)
-	if err != nil {
-		return updated, err
+		tagUpdated, err := r.buildFetchedTags(tx, queued, tags)
+		if err != nil {
+			return updated, err
+		}
+
+		if tagUpdated {
+			updated = true
+		}
 	}
 
-	if tagUpdated {
-		updated = true
+	if err = tx.Commit(); err != nil {
+		return false, err
 	}
 
 	if forceNeeded {
@@ -1212,7 +1728,39 @@ func (r *Remote) updateLocalReferenceStorage(
 	return
 }
 
-func (r *Remote) buildFetchedTags(refs memory.ReferenceStorage) (updated bool, err error) {
+// queueReferenceUpdateIfNeeded queues the update of r in the transaction tx
+// if its value in s is different. References already queued are skipped.
+func queueReferenceUpdateIfNeeded(
+	s storer.ReferenceStorer, tx storer.ReferenceTransaction,
+	queued map[plumbing.ReferenceName]bool, r, old *plumbing.Reference) (
+	updated bool, err error) {
+	if queued[r.Name()] {
+		return false, nil
+	}
+
+	p, err := s.Reference(r.Name())
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return false, err
+	}
+
+	// we use the string method to compare references, is the easiest way
+	if err == nil && r.String() == p.String() {
+		return false, nil
+	}
+
+	if err := tx.SetReference(r, old); err != nil {
+		return false, err
+	}
+
+	queued[r.Name()] = true
+	return true, nil
+}
+
+func (r *Remote) buildFetchedTags(
+	tx storer.ReferenceTransaction,
+	queued map[plumbing.ReferenceName]bool,
+	refs memory.ReferenceStorage,
+) (updated bool, err error) {
 	for _, ref := range refs {
 		if !ref.Name().IsTag() {
 			continue
@@ -1227,7 +1775,7 @@ func (r *Remote) buildFetchedTags(refs memory.ReferenceStorage) (updated bool, e
 			return false, err
 		}
 
-		refUpdated, err := updateReferenceStorerIfNeeded(r.s, ref)
+		refUpdated, err := queueReferenceUpdateIfNeeded(r.s, tx, queued, ref, nil)
 		if err != nil {
 			return updated, err
 		}
@@ -1259,18 +1807,18 @@ func (r *Remote) List(o *ListOptions) (rfs []*plumbing.Reference, err error) {
 }
 
 func (r *Remote) list(ctx context.Context, o *ListOptions) (rfs []*plumbing.Reference, err error) {
-	s, err := newUploadPackSession(r.c.URLs[0], o.Auth, o.InsecureSkipTLS, o.CABundle)
+	ep, err := r.newEndpoint(r.c.URLs[0], o.InsecureSkipTLS, o.CABundle, o.ProxyOptions)
 	if err != nil {
 		return nil, err
 	}
 
-	defer ioutil.CheckClose(s, &err)
-
-	ar, err := s.AdvertisedReferencesContext(ctx)
+	s, ar, err := r.openUploadPackSession(ctx, ep, o.Auth)
 	if err != nil {
 		return nil, err
 	}
 
+	defer ioutil.CheckClose(s, &err)
+
 	allRefs, err := ar.AllReferences()
 	if err != nil {
 		return nil, err
@@ -1376,8 +1924,10 @@ func pushHashes(
 	return rs, nil
 }
 
-func (r *Remote) updateShallow(o *FetchOptions, resp *packp.UploadPackResponse) error {
-	if o.Depth == 0 || len(resp.Shallows) == 0 {
+// updateShallow updates the shallow commits of the repository with the
+// shallow update of the response, removing the ones unshallowed by the server.
+func (r *Remote) updateShallow(resp *packp.UploadPackResponse) error {
+	if len(resp.Shallows) == 0 && len(resp.Unshallows) == 0 {
 		return nil
 	}
 
@@ -1386,17 +1936,29 @@ func (r *Remote) updateShallow(o *FetchOptions, resp *packp.UploadPackResponse)
 		return err
 	}
 
+	unshallows := make(map[plumbing.Hash]bool, len(resp.Unshallows))
+	for _, h := range resp.Unshallows {
+		unshallows[h] = true
+	}
+
+	var updated []plumbing.Hash
+	for _, h := range shallows {
+		if !unshallows[h] {
+			updated = append(updated, h)
+		}
+	}
+
 outer:
 	for _, s := range resp.Shallows {
-		for _, oldS := range shallows {
+		for _, oldS := range updated {
 			if s == oldS {
 				continue outer
 			}
 		}
-		shallows = append(shallows, s)
+		updated = append(updated, s)
 	}
 
-	return r.s.SetShallow(shallows)
+	return r.s.SetShallow(updated)
 }
 
 func (r *Remote) checkRequireRemoteRefs(requires []config.RefSpec, remoteRefs storer.ReferenceStorer) error {
@@ -6,20 +6,33 @@ import (
 	"errors"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/cache"
+	format "github.com/go-git/go-git/v5/plumbing/format/config"
+	"github.com/go-git/go-git/v5/plumbing/negotiator"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp"
 	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
 	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/test"
 	"github.com/go-git/go-git/v5/storage"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
 	"github.com/go-git/go-git/v5/storage/memory"
 
 	fixtures "github.com/go-git/go-git-fixtures/v4"
@@ -79,6 +92,30 @@ func (s *RemoteSuite) TestFetchWildcard(c *C) {
 	})
 }
 
+func (s *RemoteSuite) TestFetchLockedReference(c *C) {
+	fs := memfs.New()
+	sto := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	f, err := fs.Create("refs/remotes/origin/branch.lock")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	err = r.Fetch(&FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec("+refs/heads/*:refs/remotes/origin/*"),
+		},
+	})
+	c.Assert(errors.Is(err, dotgit.ErrReferenceLocked), Equals, true)
+
+	_, err = sto.Reference("refs/remotes/origin/master")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+	_, err = sto.Reference("refs/tags/v1.0.0")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
 func (s *RemoteSuite) TestFetchExactSHA1(c *C) {
 	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
 		URLs: []string{"https://github.com/git-fixtures/basic.git"},
@@ -459,6 +496,180 @@ func (s *RemoteSuite) TestFetchFastForwardFS(c *C) {
 	s.testFetchFastForward(c, fss)
 }
 
+// serveBasic serves the basic fixture with git-http-backend, returning its
+// URL.
+// serveBasic serves the basic fixture with git-http-backend, supporting all
+// the shallow fetch options.
+func serveBasic(c *C) (url string, close func()) {
+	if runtime.GOOS == "windows" {
+		c.Skip("git-http-backend is not run on windows")
+	}
+
+	out, err := exec.Command("git", "--exec-path").Output()
+	c.Assert(err, IsNil)
+
+	fs := fixtures.Basic().One().DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+
+	server := httptest.NewServer(&cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(out)), "git-http-backend"),
+		Env:  []string{"GIT_HTTP_EXPORT_ALL=true", "GIT_PROJECT_ROOT=" + filepath.Dir(fs.Root())},
+	})
+
+	return server.URL + "/" + filepath.Base(fs.Root()), server.Close
+}
+
+func (s *RemoteSuite) TestFetchNegotiation(c *C) {
+	url, close := serveBasic(c)
+	defer close()
+
+	// The commit of branch is a child of the parent of master, so only the
+	// commit of master, its tree and two blobs are needed.
+	for algorithm, objects := range map[string]int{
+		"":            4,
+		"consecutive": 4,
+		"skipping":    4,
+		"noop":        28,
+	} {
+		sto := &countingStorage{Storage: memory.NewStorage()}
+		cfg, err := sto.Config()
+		c.Assert(err, IsNil)
+		cfg.Raw.Section("fetch").SetOption("negotiationAlgorithm", algorithm)
+		c.Assert(sto.SetConfig(cfg), IsNil)
+
+		r := NewRemote(sto, &config.RemoteConfig{URLs: []string{url}})
+		err = r.Fetch(&FetchOptions{
+			RefSpecs: []config.RefSpec{"refs/heads/branch:refs/heads/branch"},
+		})
+		c.Assert(err, IsNil)
+
+		sto.objects = 0
+		err = r.Fetch(&FetchOptions{
+			RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		})
+		c.Assert(err, IsNil)
+		c.Assert(sto.objects, Equals, objects, Commentf("algorithm %q", algorithm))
+	}
+
+	sto := memory.NewStorage()
+	cfg, err := sto.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("fetch").SetOption("negotiationAlgorithm", "foo")
+	c.Assert(sto.SetConfig(cfg), IsNil)
+
+	r := NewRemote(sto, &config.RemoteConfig{URLs: []string{url}})
+	err = r.Fetch(&FetchOptions{
+		RefSpecs: []config.RefSpec{"refs/heads/master:refs/heads/master"},
+	})
+	c.Assert(errors.Is(err, negotiator.ErrUnknownAlgorithm), Equals, true)
+}
+
+func (s *RemoteSuite) TestFetchDeepenAndUnshallow(c *C) {
+	url, close := serveBasic(c)
+	defer close()
+
+	sto := memory.NewStorage()
+	r := NewRemote(sto, &config.RemoteConfig{URLs: []string{url}})
+	refspecs := []config.RefSpec{"refs/heads/master:refs/heads/master"}
+
+	err := r.Fetch(&FetchOptions{RefSpecs: refspecs, Unshallow: true})
+	c.Assert(err, Equals, ErrUnshallowComplete)
+
+	err = r.Fetch(&FetchOptions{RefSpecs: refspecs, Depth: 1})
+	c.Assert(err, IsNil)
+	c.Assert(sto.Commits, HasLen, 1)
+
+	shallow, err := sto.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+
+	err = r.Fetch(&FetchOptions{RefSpecs: refspecs, Deepen: 1})
+	c.Assert(err, IsNil)
+	c.Assert(sto.Commits, HasLen, 2)
+
+	shallow, err = sto.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("918c48b83bd081e863dbe1b80f8998f058cd8294"),
+	})
+
+	err = r.Fetch(&FetchOptions{RefSpecs: refspecs, Unshallow: true})
+	c.Assert(err, IsNil)
+	c.Assert(sto.Commits, HasLen, 8)
+
+	shallow, err = sto.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, HasLen, 0)
+}
+
+func (s *RemoteSuite) TestFetchShallowExclude(c *C) {
+	url, close := serveBasic(c)
+	defer close()
+
+	sto := memory.NewStorage()
+	r := NewRemote(sto, &config.RemoteConfig{URLs: []string{url}})
+
+	// master and branch share all of their history but their last commits.
+	err := r.Fetch(&FetchOptions{
+		RefSpecs:       []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		ShallowExclude: []string{"refs/heads/branch"},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(sto.Commits, HasLen, 1)
+
+	shallow, err := sto.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	})
+}
+
+func (s *RemoteSuite) TestFetchShallowSince(c *C) {
+	url, close := serveBasic(c)
+	defer close()
+
+	sto := memory.NewStorage()
+	r := NewRemote(sto, &config.RemoteConfig{URLs: []string{url}})
+
+	// Only the commit of master is newer than the one of branch.
+	since := time.Date(2015, 4, 5, 21, 30, 0, 0, time.UTC)
+	err := r.Fetch(&FetchOptions{
+		RefSpecs:     []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		ShallowSince: since,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(sto.Commits, HasLen, 1)
+
+	shallow, err := sto.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, HasLen, 1)
+}
+
+func (s *RemoteSuite) TestFetchShallowNotSupported(c *C) {
+	r := NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		URLs: []string{s.GetBasicLocalRepositoryURL()},
+	})
+
+	err := r.Fetch(&FetchOptions{
+		RefSpecs:     []config.RefSpec{"refs/heads/master:refs/heads/master"},
+		ShallowSince: time.Now(),
+	})
+	c.Assert(errors.Is(err, ErrShallowNotSupported), Equals, true)
+}
+
+// countingStorage is a memory.Storage counting the objects written.
+type countingStorage struct {
+	*memory.Storage
+	objects int
+}
+
+func (s *countingStorage) SetEncodedObject(o plumbing.EncodedObject) (plumbing.Hash, error) {
+	s.objects++
+	return s.Storage.SetEncodedObject(o)
+}
+
 func (s *RemoteSuite) TestString(c *C) {
 	r := NewRemote(nil, &config.RemoteConfig{
 		Name: "foo",
@@ -510,6 +721,80 @@ func (s *RemoteSuite) TestPushToEmptyRepository(c *C) {
 
 }
 
+func (s *RemoteSuite) TestPushSign(c *C) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		c.Skip("hooks are shell scripts")
+	}
+
+	url, clean := s.TemporalDir()
+	defer clean()
+
+	server, err := PlainInit(url, true)
+	c.Assert(err, IsNil)
+
+	key, err := openpgp.NewEntity("foo", "", "foo@foo.com", nil)
+	c.Assert(err, IsNil)
+
+	sto := filesystem.NewStorage(fixtures.Basic().One().DotGit(), cache.NewObjectLRUDefault())
+	r := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{url},
+	})
+
+	push := func(ref string, sign PushSignMode) (string, error) {
+		var progress bytes.Buffer
+		err := r.Push(&PushOptions{
+			RefSpecs: []config.RefSpec{config.RefSpec("refs/heads/master:" + ref)},
+			Progress: &progress,
+			Sign:     sign,
+			SignKey:  key,
+		})
+
+		return progress.String(), err
+	}
+
+	err = r.Push(&PushOptions{Sign: PushSignAlways})
+	c.Assert(err, Equals, ErrMissingSignKey)
+
+	_, err = push("refs/heads/always", PushSignAlways)
+	c.Assert(err, Equals, ErrPushCertNotSupported)
+
+	_, err = push("refs/heads/if-asked", PushSignIfAsked)
+	c.Assert(err, IsNil)
+
+	cfg, err := server.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("receive").SetOption("certNonceSeed", "foo")
+	c.Assert(server.SetConfig(cfg), IsNil)
+
+	hooks := filepath.Join(url, "hooks")
+	c.Assert(os.MkdirAll(hooks, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(hooks, "pre-receive"),
+		[]byte("#!/bin/sh\nprintf '%s\\n' \"$GIT_PUSH_CERT\" \"$GIT_PUSH_CERT_NONCE_STATUS\"\n"), 0755), IsNil)
+
+	out, err := push("refs/heads/signed", PushSignAlways)
+	c.Assert(err, IsNil)
+
+	lines := strings.Split(out, "\n")
+	c.Assert(lines, HasLen, 3)
+	c.Assert(lines[1], Equals, "OK")
+
+	blob, err := server.BlobObject(plumbing.NewHash(lines[0]))
+	c.Assert(err, IsNil)
+	rd, err := blob.Reader()
+	c.Assert(err, IsNil)
+
+	cert := &packp.PushCert{}
+	c.Assert(cert.Decode(rd), IsNil)
+	c.Assert(cert.Pusher, Matches, "foo <foo@foo.com> [0-9]+ [+-][0-9]{4}")
+	c.Assert(cert.Pushee, Equals, "file://"+url)
+	c.Assert(cert.Commands, HasLen, 1)
+	c.Assert(cert.Commands[0].Name, Equals, plumbing.ReferenceName("refs/heads/signed"))
+
+	_, err = cert.VerifyKeyRing(openpgp.EntityList{key})
+	c.Assert(err, IsNil)
+}
+
 func (s *RemoteSuite) TestPushContext(c *C) {
 	url, clean := s.TemporalDir()
 	defer clean()
@@ -1221,6 +1506,205 @@ func (s *RemoteSuite) TestListTimeout(c *C) {
 	c.Assert(err, NotNil)
 }
 
+func (s *RemoteSuite) TestListProxy(c *C) {
+	proxy := test.NewHTTPProxy("", "")
+	defer proxy.Close()
+
+	sto := memory.NewStorage()
+	remote := NewRemote(sto, &config.RemoteConfig{
+		Name: DefaultRemoteName,
+		URLs: []string{"http://localhost:1/repository.git"},
+	})
+
+	_, err := remote.List(&ListOptions{
+		ProxyOptions: transport.ProxyOptions{URL: proxy.URL},
+	})
+
+	c.Assert(err, NotNil)
+	c.Assert(proxy.Requests(), Equals, 1)
+
+	cfg, err := sto.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("http").Subsection("http://localhost:1").SetOption("proxy", proxy.URL)
+	c.Assert(sto.SetConfig(cfg), IsNil)
+
+	_, err = remote.List(&ListOptions{})
+	c.Assert(err, NotNil)
+	c.Assert(proxy.Requests(), Equals, 2)
+}
+
+func (s *RemoteSuite) TestListCredentialHelper(c *C) {
+	if runtime.GOOS == "windows" {
+		c.Skip("git-http-backend is not run on windows")
+	}
+
+	out, err := exec.Command("git", "--exec-path").Output()
+	c.Assert(err, IsNil)
+
+	fs := fixtures.Basic().One().DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+
+	backend := &cgi.Handler{
+		Path: filepath.Join(strings.TrimSpace(string(out)), "git-http-backend"),
+		Env:  []string{"GIT_HTTP_EXPORT_ALL=true", "GIT_PROJECT_ROOT=" + filepath.Dir(fs.Root())},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		backend.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	url := server.URL + "/" + filepath.Base(fs.Root())
+	store := filepath.Join(c.MkDir(), "credentials")
+
+	sto := memory.NewStorage()
+	cfg, err := sto.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("credential").AddOption("helper", "store --file="+store)
+	c.Assert(sto.SetConfig(cfg), IsNil)
+
+	remote := NewRemote(sto, &config.RemoteConfig{Name: DefaultRemoteName, URLs: []string{url}})
+
+	_, err = remote.List(&ListOptions{})
+	c.Assert(err, Equals, transport.ErrAuthenticationRequired)
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	err = ioutil.WriteFile(store, []byte("http://user:wrong@"+host+"\n"), 0600)
+	c.Assert(err, IsNil)
+
+	_, err = remote.List(&ListOptions{})
+	c.Assert(err, Equals, transport.ErrAuthenticationRequired)
+
+	data, err := ioutil.ReadFile(store)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "")
+
+	err = ioutil.WriteFile(store, []byte("http://user:secret@"+host+"\n"), 0600)
+	c.Assert(err, IsNil)
+
+	refs, err := remote.List(&ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(refs, Not(HasLen), 0)
+
+	data, err = ioutil.ReadFile(store)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "http://user:secret@"+host+"\n")
+
+	_, err = remote.List(&ListOptions{Auth: &githttp.BasicAuth{Username: "user", Password: "wrong"}})
+	c.Assert(err, Equals, transport.ErrAuthenticationRequired)
+
+	// a helper failing to store the credential doesn't fail the operation
+	cfg.Raw.Section("credential").AddOption("helper", "store --file="+filepath.Join(store, "credentials"))
+	c.Assert(sto.SetConfig(cfg), IsNil)
+
+	refs, err = remote.List(&ListOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(refs, Not(HasLen), 0)
+}
+
+func (s *RemoteSuite) TestProxyOptions(c *C) {
+	sto := memory.NewStorage()
+	cfg, err := sto.Config()
+	c.Assert(err, IsNil)
+
+	cfg.Raw.Section("http").SetOption("proxy", "proxy.example.com:3128")
+	cfg.Raw.Section("http").Subsection("https://example.com/internal").SetOption("proxy", "")
+	c.Assert(sto.SetConfig(cfg), IsNil)
+
+	remote := NewRemote(sto, &config.RemoteConfig{Name: DefaultRemoteName})
+	for _, t := range []struct {
+		url      string
+		opts     transport.ProxyOptions
+		expected string
+	}{
+		{"https://example.com/repository.git", transport.ProxyOptions{}, "proxy.example.com:3128"},
+		{"https://example.com/internal/repository.git", transport.ProxyOptions{}, ""},
+		{"ssh://example.com/repository.git", transport.ProxyOptions{}, ""},
+		{"https://example.com/repository.git", transport.ProxyOptions{URL: "socks5://localhost"}, "socks5://localhost"},
+	} {
+		ep, err := remote.newEndpoint(t.url, false, nil, t.opts)
+		c.Assert(err, IsNil)
+		c.Assert(ep.Proxy.URL, Equals, t.expected, Commentf("%s", t.url))
+	}
+}
+
+func (s *RemoteSuite) TestHTTPConfig(c *C) {
+	dir, err := ioutil.TempDir("", "TestHTTPConfig")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	cert := filepath.Join(dir, "cert.pem")
+	c.Assert(ioutil.WriteFile(cert, []byte("certificate"), 0600), IsNil)
+
+	sto := memory.NewStorage()
+	cfg, err := sto.Config()
+	c.Assert(err, IsNil)
+
+	section := cfg.Raw.Section("http")
+	section.AddOption("extraHeader", "X-Global: true")
+	section.SetOption("sslVerify", "false")
+	section.SetOption("postBuffer", "1k")
+	section.SetOption("lowSpeedLimit", "100")
+	section.SetOption("lowSpeedTime", "10")
+
+	internal := section.Subsection("https://example.com/internal")
+	internal.AddOption("extraHeader", "")
+	internal.AddOption("extraHeader", "Authorization: Bearer token")
+	internal.SetOption("sslVerify", "true")
+	internal.SetOption("sslCAInfo", cert)
+	internal.SetOption("sslCert", cert)
+	internal.SetOption("version", "HTTP/1.1")
+	c.Assert(sto.SetConfig(cfg), IsNil)
+
+	remote := NewRemote(sto, &config.RemoteConfig{Name: DefaultRemoteName})
+
+	ep, err := remote.newEndpoint("https://example.com/repository.git", false, nil, transport.ProxyOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(ep.InsecureSkipTLS, Equals, true)
+	c.Assert(ep.CaBundle, HasLen, 0)
+	c.Assert(ep.HTTP, DeepEquals, transport.HTTPOptions{
+		ExtraHeaders:  []string{"X-Global: true"},
+		PostBuffer:    1024,
+		LowSpeedLimit: 100,
+		LowSpeedTime:  10 * time.Second,
+	})
+
+	ep, err = remote.newEndpoint("https://example.com/internal/repository.git", false, nil, transport.ProxyOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(ep.InsecureSkipTLS, Equals, false)
+	c.Assert(string(ep.CaBundle), Equals, "certificate")
+	c.Assert(string(ep.ClientCert), Equals, "certificate")
+	c.Assert(string(ep.ClientKey), Equals, "certificate")
+	c.Assert(ep.HTTP.ExtraHeaders, DeepEquals, []string{"Authorization: Bearer token"})
+	c.Assert(ep.HTTP.Version, Equals, "HTTP/1.1")
+
+	ep, err = remote.newEndpoint("https://example.com/internal/repository.git", true, []byte("bundle"), transport.ProxyOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(ep.InsecureSkipTLS, Equals, true)
+	c.Assert(string(ep.CaBundle), Equals, "bundle")
+
+	ep, err = remote.newEndpoint("ssh://example.com/repository.git", false, nil, transport.ProxyOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(ep.HTTP, DeepEquals, transport.HTTPOptions{})
+
+	for key, value := range map[string]string{
+		"sslVerify":    "maybe",
+		"version":      "HTTP/3",
+		"postBuffer":   "1x",
+		"lowSpeedTime": "1s",
+	} {
+		ep := &transport.Endpoint{}
+		opts := format.Options{{Key: key, Value: value}}
+		c.Assert(applyHTTPConfig(ep, opts), ErrorMatches, "invalid http."+key+": "+value)
+	}
+}
+
 func (s *RemoteSuite) TestUpdateShallows(c *C) {
 	hashes := []plumbing.Hash{
 		plumbing.NewHash("0000000000000000000000000000000000000001"),
@@ -1256,13 +1740,10 @@ func (s *RemoteSuite) TestUpdateShallows(c *C) {
 	c.Assert(len(shallows), Equals, 0)
 
 	resp := new(packp.UploadPackResponse)
-	o := &FetchOptions{
-		Depth: 1,
-	}
 
 	for _, t := range tests {
 		resp.Shallows = t.hashes
-		err = remote.updateShallow(o, resp)
+		err = remote.updateShallow(resp)
 		c.Assert(err, IsNil)
 
 		shallow, err := remote.s.Shallow()
@@ -1270,6 +1751,22 @@ func (s *RemoteSuite) TestUpdateShallows(c *C) {
 		c.Assert(len(shallow), Equals, len(t.result))
 		c.Assert(shallow, DeepEquals, t.result)
 	}
+
+	resp.Shallows = hashes[5:6]
+	resp.Unshallows = hashes[1:6]
+	c.Assert(remote.updateShallow(resp), IsNil)
+
+	shallow, err := remote.s.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, DeepEquals, []plumbing.Hash{hashes[0], hashes[5]})
+
+	resp.Shallows = nil
+	resp.Unshallows = hashes
+	c.Assert(remote.updateShallow(resp), IsNil)
+
+	shallow, err = remote.s.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, HasLen, 0)
 }
 
 func (s *RemoteSuite) TestUseRefDeltas(c *C) {
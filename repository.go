@@ -826,12 +826,15 @@ func (r *Repository) clone(ctx context.Context, o *CloneOptions) error {
 	ref, err := r.fetchAndUpdateReferences(ctx, &FetchOptions{
 		RefSpecs:        c.Fetch,
 		Depth:           o.Depth,
+		ShallowSince:    o.ShallowSince,
+		ShallowExclude:  o.ShallowExclude,
 		Auth:            o.Auth,
 		Progress:        o.Progress,
 		Tags:            o.Tags,
 		RemoteName:      o.RemoteName,
 		InsecureSkipTLS: o.InsecureSkipTLS,
 		CABundle:        o.CABundle,
+		ProxyOptions:    o.ProxyOptions,
 	}, o.ReferenceName)
 	if err != nil {
 		return err
@@ -1162,6 +1165,10 @@ func (r *Repository) Log(o *LogOptions) (object.CommitIter, error) {
 		it = r.logWithLimit(it, limitOptions)
 	}
 
+	if o.Mailmap != nil {
+		it = &mailmapCommitIter{CommitIter: it, m: o.Mailmap}
+	}
+
 	return it, nil
 }
 
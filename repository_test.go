@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -926,6 +928,60 @@ func (s *RepositorySuite) TestCloneWithProgress(c *C) {
 	c.Assert(buf.Len(), Not(Equals), 0)
 }
 
+func (s *RepositorySuite) TestCloneDumbHTTP(c *C) {
+	fs := fixtures.ByURL("https://github.com/git-fixtures/tags.git").One().DotGit()
+	c.Assert(fixtures.EnsureIsBare(fs), IsNil)
+
+	git := func(dir string, args ...string) {
+		args = append([]string{"-c", "user.name=foo", "-c", "user.email=foo@foo.com"}, args...)
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+
+		out, err := cmd.CombinedOutput()
+		c.Assert(err, IsNil, Commentf("%s", out))
+	}
+
+	git(fs.Root(), "update-server-info")
+
+	server := httptest.NewServer(http.FileServer(http.Dir(filepath.Dir(fs.Root()))))
+	defer server.Close()
+
+	r, err := Clone(memory.NewStorage(), memfs.New(), &CloneOptions{
+		URL: server.URL + "/" + filepath.Base(fs.Root()),
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Name(), Equals, plumbing.Master)
+	c.Assert(head.Hash().String(), Equals, "f7b877701fbf855b44c0a9e86f3fdce2c298b07f")
+
+	tag, err := r.TagObject(plumbing.NewHash("b742a2a9fa0afcfa9a6fad080980fbc26b007c69"))
+	c.Assert(err, IsNil)
+	c.Assert(tag.Name, Equals, "annotated-tag")
+
+	work := c.MkDir()
+	git(work, "clone", fs.Root(), ".")
+	c.Assert(ioutil.WriteFile(filepath.Join(work, "dumb"), []byte("dumb"), 0644), IsNil)
+	git(work, "add", "dumb")
+	git(work, "commit", "-m", "dumb")
+	git(work, "push", "origin", "HEAD:master")
+	git(fs.Root(), "update-server-info")
+
+	c.Assert(r.Fetch(&FetchOptions{}), IsNil)
+
+	ref, err := r.Reference(plumbing.NewRemoteReferenceName(DefaultRemoteName, "master"), true)
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(ref.Hash())
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "dumb\n")
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{head.Hash()})
+
+	_, err = commit.File("dumb")
+	c.Assert(err, IsNil)
+}
+
 func (s *RepositorySuite) TestCloneDeep(c *C) {
 	fs := memfs.New()
 	r, _ := Init(memory.NewStorage(), fs)
@@ -1181,6 +1237,72 @@ func (s *RepositorySuite) TestCloneDetachedHEADAndShallow(c *C) {
 	c.Assert(count, Equals, 15)
 }
 
+func (s *RepositorySuite) TestCloneShallowExclude(c *C) {
+	url, close := serveBasic(c)
+	defer close()
+
+	// master and branch share all of their history but their last commits.
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:            url,
+		ReferenceName:  plumbing.Master,
+		SingleBranch:   true,
+		Tags:           NoTags,
+		ShallowExclude: []string{"refs/heads/branch"},
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	commits, err := r.CommitObjects()
+	c.Assert(err, IsNil)
+	count := 0
+	commits.ForEach(func(*object.Commit) error { count++; return nil })
+	c.Assert(count, Equals, 1)
+
+	shallow, err := r.Storer.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, DeepEquals, []plumbing.Hash{head.Hash()})
+}
+
+func (s *RepositorySuite) TestCloneShallowSince(c *C) {
+	url, close := serveBasic(c)
+	defer close()
+
+	// Only the commit of master is newer than the one of branch.
+	r, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:           url,
+		ReferenceName: plumbing.Master,
+		SingleBranch:  true,
+		Tags:          NoTags,
+		ShallowSince:  time.Date(2015, 4, 5, 21, 30, 0, 0, time.UTC),
+	})
+	c.Assert(err, IsNil)
+
+	head, err := r.Head()
+	c.Assert(err, IsNil)
+	c.Assert(head.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	commits, err := r.CommitObjects()
+	c.Assert(err, IsNil)
+	count := 0
+	commits.ForEach(func(*object.Commit) error { count++; return nil })
+	c.Assert(count, Equals, 1)
+
+	shallow, err := r.Storer.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(shallow, DeepEquals, []plumbing.Hash{head.Hash()})
+}
+
+func (s *RepositorySuite) TestCloneShallowNotSupported(c *C) {
+	_, err := Clone(memory.NewStorage(), nil, &CloneOptions{
+		URL:            s.GetBasicLocalRepositoryURL(),
+		ShallowExclude: []string{"refs/heads/branch"},
+	})
+	c.Assert(errors.Is(err, ErrShallowNotSupported), Equals, true)
+}
+
 func (s *RepositorySuite) TestCloneDetachedHEADAnnotatedTag(c *C) {
 	r, _ := Init(memory.NewStorage(), nil)
 	err := r.clone(context.Background(), &CloneOptions{
@@ -2925,9 +3047,14 @@ func (s *RepositorySuite) TestBrokenMultipleShallowFetch(c *C) {
 		RefSpecs: []config.RefSpec{config.RefSpec("refs/heads/*:refs/heads/*")},
 	}), IsNil)
 
+	// The previous shallow commit is unshallowed by the server, now that its
+	// parents were fetched.
 	shallows, err = r.Storer.Shallow()
 	c.Assert(err, IsNil)
-	c.Assert(len(shallows), Equals, 3)
+	c.Assert(shallows, DeepEquals, []plumbing.Hash{
+		plumbing.NewHash("35e85108805c84807bc66a02d91535e1e24b38b9"),
+		plumbing.NewHash("a5b8b09e2f8fcb0bb99d3ccb0958157b40890d69"),
+	})
 
 	ref, err = r.Reference("refs/heads/master", true)
 	c.Assert(err, IsNil)
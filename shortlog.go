@@ -0,0 +1,109 @@
+package git
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const shortlogEmptySubject = "<none>"
+
+// ShortlogGroup is a group of commits with the same author, or committer, as
+// listed by git shortlog.
+type ShortlogGroup struct {
+	// Name is the name of the author or committer.
+	Name string
+	// Email is the email of the author or committer, only set if
+	// ShortlogOptions.Email is.
+	Email string
+	// Subjects are the subjects of the commits, the oldest first.
+	Subjects []string
+}
+
+// Shortlog groups the commits by author, or committer, with their identities
+// mapped by the mailmap of the repository, as git shortlog does. The groups
+// are sorted by name, or by number of commits.
+func (r *Repository) Shortlog(o *ShortlogOptions) ([]*ShortlogGroup, error) {
+	if err := o.Validate(r); err != nil {
+		return nil, err
+	}
+
+	lo := *o.Log
+	lo.Mailmap = o.Mailmap
+	it, err := r.Log(&lo)
+	if err != nil {
+		return nil, err
+	}
+
+	type groupKey struct{ name, email string }
+	groups := make(map[groupKey]*ShortlogGroup)
+	err = it.ForEach(func(c *object.Commit) error {
+		sig := c.Author
+		if o.Committer {
+			sig = c.Committer
+		}
+
+		key := groupKey{name: sig.Name}
+		if o.Email {
+			key.email = sig.Email
+		}
+
+		g, ok := groups[key]
+		if !ok {
+			g = &ShortlogGroup{Name: key.name, Email: key.email}
+			groups[key] = g
+		}
+
+		g.Subjects = append(g.Subjects, commitSubject(c.Message))
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*ShortlogGroup, 0, len(groups))
+	for _, g := range groups {
+		for i, j := 0, len(g.Subjects)-1; i < j; i, j = i+1, j-1 {
+			g.Subjects[i], g.Subjects[j] = g.Subjects[j], g.Subjects[i]
+		}
+
+		result = append(result, g)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+
+		return result[i].Email < result[j].Email
+	})
+
+	if o.Numbered {
+		sort.SliceStable(result, func(i, j int) bool {
+			return len(result[i].Subjects) > len(result[j].Subjects)
+		})
+	}
+
+	return result, nil
+}
+
+// commitSubject returns the first paragraph of a commit message, joined in a
+// single line.
+func commitSubject(msg string) string {
+	var lines []string
+	for _, l := range strings.Split(strings.TrimLeft(msg, "\n"), "\n") {
+		if strings.TrimSpace(l) == "" {
+			break
+		}
+
+		lines = append(lines, strings.TrimSpace(l))
+	}
+
+	if len(lines) == 0 {
+		return shortlogEmptySubject
+	}
+
+	return strings.Join(lines, " ")
+}
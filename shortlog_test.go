@@ -0,0 +1,81 @@
+package git
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/format/mailmap"
+
+	. "gopkg.in/check.v1"
+)
+
+type ShortlogSuite struct {
+	BaseSuite
+}
+
+var _ = Suite(&ShortlogSuite{})
+
+func (s *ShortlogSuite) TestShortlog(c *C) {
+	groups, err := s.Repository.Shortlog(&ShortlogOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(groups, DeepEquals, []*ShortlogGroup{
+		{Name: "Daniel Ripolles", Subjects: []string{"Creating changelog"}},
+		{Name: "Máximo Cuadros", Subjects: []string{
+			"Initial commit",
+			"Merge pull request #1 from dripolles/feature",
+		}},
+		{Name: "Máximo Cuadros Ortiz", Subjects: []string{
+			"binary file",
+			"Merge branch 'master' of github.com:tyba/git-fixture",
+			"some json",
+			"some code",
+			"vendor stuff",
+		}},
+	})
+}
+
+func (s *ShortlogSuite) TestShortlogMailmap(c *C) {
+	m, err := mailmap.Read(strings.NewReader(basicMailmap))
+	c.Assert(err, IsNil)
+
+	groups, err := s.Repository.Shortlog(&ShortlogOptions{
+		Mailmap:  m,
+		Numbered: true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(groups, DeepEquals, []*ShortlogGroup{
+		{Name: "Máximo Cuadros Ortiz", Subjects: []string{
+			"Initial commit",
+			"binary file",
+			"Merge pull request #1 from dripolles/feature",
+			"Merge branch 'master' of github.com:tyba/git-fixture",
+			"some json",
+			"some code",
+			"vendor stuff",
+		}},
+		{Name: "Daniel Ripolles", Subjects: []string{"Creating changelog"}},
+	})
+}
+
+func (s *ShortlogSuite) TestShortlogCommitterEmail(c *C) {
+	m, err := mailmap.Read(strings.NewReader(basicMailmap))
+	c.Assert(err, IsNil)
+
+	groups, err := s.Repository.Shortlog(&ShortlogOptions{
+		Mailmap:   m,
+		Committer: true,
+		Email:     true,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(groups, HasLen, 2)
+	c.Assert(groups[0].Name, Equals, "Daniel Ripolles")
+	c.Assert(groups[0].Email, Equals, "daniel@lordran.local")
+	c.Assert(groups[1].Name, Equals, "Máximo Cuadros Ortiz")
+	c.Assert(groups[1].Email, Equals, "mcuadros@gmail.com")
+	c.Assert(groups[1].Subjects, HasLen, 7)
+}
+
+func (s *ShortlogSuite) TestCommitSubject(c *C) {
+	c.Assert(commitSubject("foo\nbar\n\nbaz\n"), Equals, "foo bar")
+	c.Assert(commitSubject("\n\nfoo  \n"), Equals, "foo")
+	c.Assert(commitSubject(""), Equals, "<none>")
+}
@@ -44,6 +44,7 @@ const (
 	packPrefix = "pack-"
 	packExt    = ".pack"
 	idxExt     = ".idx"
+	lockExt    = ".lock"
 )
 
 var (
@@ -68,6 +69,10 @@ var (
 	// ErrIsDir is returned when a reference file is attempting to be read,
 	// but the path specified is a directory.
 	ErrIsDir = errors.New("reference path is a directory")
+	// ErrReferenceLocked is returned by a reference transaction when the
+	// lock file of a reference already exists, usually because another
+	// process is updating it.
+	ErrReferenceLocked = errors.New("reference is locked")
 )
 
 // Options holds configuration for the storage.
@@ -78,6 +83,9 @@ type Options struct {
 	// KeepDescriptors makes the file descriptors to be reused but they will
 	// need to be manually closed calling Close().
 	KeepDescriptors bool
+	// Reftable makes Initialize create a repository storing its references
+	// in the reftable format.
+	Reftable bool
 }
 
 // The DotGit type represents a local git repository on disk. This
@@ -96,6 +104,16 @@ type DotGit struct {
 	packMap    map[plumbing.Hash]struct{}
 
 	files map[plumbing.Hash]billy.File
+
+	// reftable is the stack of reftables, nil if the repository uses loose
+	// references.
+	reftable *reftableStack
+	// refStorageChecked is set once the extensions.refStorage config
+	// option has been read.
+	refStorageChecked bool
+	// reftableChecked is set once the repository is known not to use the
+	// reftable format.
+	reftableChecked bool
 }
 
 // New returns a DotGit value ready to be used. The path argument must
@@ -119,8 +137,13 @@ func (d *DotGit) Initialize() error {
 	mustExists := []string{
 		d.fs.Join("objects", "info"),
 		d.fs.Join("objects", "pack"),
-		d.fs.Join("refs", "heads"),
-		d.fs.Join("refs", "tags"),
+	}
+
+	if !d.options.Reftable {
+		mustExists = append(mustExists,
+			d.fs.Join("refs", "heads"),
+			d.fs.Join("refs", "tags"),
+		)
 	}
 
 	for _, path := range mustExists {
@@ -138,6 +161,10 @@ func (d *DotGit) Initialize() error {
 		}
 	}
 
+	if d.options.Reftable {
+		return d.initializeReftable()
+	}
+
 	return nil
 }
 
@@ -188,6 +215,16 @@ func (d *DotGit) ShallowWriter() (billy.File, error) {
 	return d.fs.Create(shallowPath)
 }
 
+// RemoveShallow removes the shallow file, if any.
+func (d *DotGit) RemoveShallow() error {
+	err := d.fs.Remove(shallowPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
+
 // Shallow returns a file pointer for read to the shallow file
 func (d *DotGit) Shallow() (billy.File, error) {
 	f, err := d.fs.Open(shallowPath)
@@ -310,6 +347,22 @@ func (d *DotGit) ObjectPackIdx(hash plumbing.Hash) (billy.File, error) {
 	return d.objectPackOpen(hash, `idx`)
 }
 
+// ObjectPackStat returns the os.FileInfo of the given packfile.
+func (d *DotGit) ObjectPackStat(hash plumbing.Hash) (os.FileInfo, error) {
+	return d.fs.Stat(d.objectPackPath(hash, `pack`))
+}
+
+// ObjectPackKept returns true if the given packfile has a ".keep" file next
+// to it, meaning it must be neither repacked nor deleted.
+func (d *DotGit) ObjectPackKept(hash plumbing.Hash) (bool, error) {
+	_, err := d.fs.Stat(d.objectPackPath(hash, `keep`))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
 func (d *DotGit) DeleteOldObjectPackAndIndex(hash plumbing.Hash, t time.Time) error {
 	d.cleanPackList()
 
@@ -673,22 +726,36 @@ func (d *DotGit) checkReferenceAndTruncate(f billy.File, old *plumbing.Reference
 }
 
 func (d *DotGit) SetRef(r, old *plumbing.Reference) error {
-	var content string
+	if d.IsReftable() {
+		return d.commitReftableUpdate(func(t *ReftableTransaction) error {
+			return t.SetReference(r, old)
+		})
+	}
+
+	fileName := r.Name().String()
+
+	return d.setRef(fileName, refContent(r), old)
+}
+
+// refContent returns the content of the loose reference file for r.
+func refContent(r *plumbing.Reference) string {
 	switch r.Type() {
 	case plumbing.SymbolicReference:
-		content = fmt.Sprintf("ref: %s\n", r.Target())
+		return fmt.Sprintf("ref: %s\n", r.Target())
 	case plumbing.HashReference:
-		content = fmt.Sprintln(r.Hash().String())
+		return fmt.Sprintln(r.Hash().String())
 	}
 
-	fileName := r.Name().String()
-
-	return d.setRef(fileName, content, old)
+	return ""
 }
 
 // Refs scans the git directory collecting references, which it returns.
 // Symbolic references are resolved and included in the output.
 func (d *DotGit) Refs() ([]*plumbing.Reference, error) {
+	if d.IsReftable() {
+		return d.reftable.refs()
+	}
+
 	var refs []*plumbing.Reference
 	var seen = make(map[plumbing.ReferenceName]bool)
 	if err := d.addRefsFromRefDir(&refs, seen); err != nil {
@@ -708,6 +775,10 @@ func (d *DotGit) Refs() ([]*plumbing.Reference, error) {
 
 // Ref returns the reference for a given reference name.
 func (d *DotGit) Ref(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	if d.IsReftable() {
+		return d.reftable.ref(name)
+	}
+
 	ref, err := d.readReferenceFile(".", name.String())
 	if err == nil {
 		return ref, nil
@@ -763,6 +834,12 @@ func (d *DotGit) packedRef(name plumbing.ReferenceName) (*plumbing.Reference, er
 
 // RemoveRef removes a reference by name.
 func (d *DotGit) RemoveRef(name plumbing.ReferenceName) error {
+	if d.IsReftable() {
+		return d.commitReftableUpdate(func(t *ReftableTransaction) error {
+			return t.RemoveReference(name, nil)
+		})
+	}
+
 	path := d.fs.Join(".", name.String())
 	_, err := d.fs.Stat(path)
 	if err == nil {
@@ -872,6 +949,13 @@ func (d *DotGit) rewritePackedRefsWithoutRef(name plumbing.ReferenceName) (err e
 	}
 	defer ioutil.CheckClose(pr, &err)
 
+	return d.rewritePackedRefsWithoutRefs(pr, map[plumbing.ReferenceName]bool{name: true})
+}
+
+// rewritePackedRefsWithoutRefs rewrites the already locked packed-refs file
+// pr, dropping the given references. The file is left untouched if none of
+// them is packed.
+func (d *DotGit) rewritePackedRefsWithoutRefs(pr billy.File, names map[plumbing.ReferenceName]bool) (err error) {
 	// Creating the temp file in the same directory as the target file
 	// improves our chances for rename operation to be atomic.
 	tmp, err := d.fs.TempFile("", tmpPackedRefsPrefix)
@@ -893,7 +977,7 @@ func (d *DotGit) rewritePackedRefsWithoutRef(name plumbing.ReferenceName) (err e
 			return err
 		}
 
-		if ref != nil && ref.Name() == name {
+		if ref != nil && names[ref.Name()] {
 			found = true
 			continue
 		}
@@ -960,6 +1044,11 @@ func (d *DotGit) walkReferencesTree(refs *[]*plumbing.Reference, relPath []strin
 			continue
 		}
 
+		if strings.HasSuffix(f.Name(), lockExt) {
+			// a reference transaction is holding this lock
+			continue
+		}
+
 		ref, err := d.readReferenceFile(".", strings.Join(newRelPath, "/"))
 		if os.IsNotExist(err) {
 			// a race happened, and our file is gone now
@@ -1012,6 +1101,10 @@ func (d *DotGit) readReferenceFile(path, name string) (ref *plumbing.Reference,
 }
 
 func (d *DotGit) CountLooseRefs() (int, error) {
+	if d.IsReftable() {
+		return 0, nil
+	}
+
 	var refs []*plumbing.Reference
 	var seen = make(map[plumbing.ReferenceName]bool)
 	if err := d.addRefsFromRefDir(&refs, seen); err != nil {
@@ -1021,7 +1114,8 @@ func (d *DotGit) CountLooseRefs() (int, error) {
 	return len(refs), nil
 }
 
-// PackRefs packs all loose refs into the packed-refs file.
+// PackRefs packs all loose refs into the packed-refs file. On reftable
+// repositories the whole stack is compacted into a single table instead.
 //
 // This implementation only works under the assumption that the view
 // of the file system won't be updated during this operation.  This
@@ -1037,6 +1131,10 @@ func (d *DotGit) CountLooseRefs() (int, error) {
 // When `all` is false, it would only pack refs that have already been
 // packed, plus all tags.
 func (d *DotGit) PackRefs() (err error) {
+	if d.IsReftable() {
+		return d.reftable.packRefs()
+	}
+
 	// Lock packed-refs, and create it if it doesn't exist yet.
 	f, err := d.openAndLockPackedRefs(true)
 	if err != nil {
@@ -1050,6 +1148,14 @@ func (d *DotGit) PackRefs() (err error) {
 	if err = d.addRefsFromRefDir(&refs, seen); err != nil {
 		return err
 	}
+	// Symbolic references can't be packed, they stay loose.
+	loose := refs[:0]
+	for _, ref := range refs {
+		if ref.Type() == plumbing.HashReference {
+			loose = append(loose, ref)
+		}
+	}
+	refs = loose
 	if len(refs) == 0 {
 		// Nothing to do!
 		return nil
@@ -0,0 +1,282 @@
+package dotgit
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+// RefTransaction implements storer.ReferenceTransaction for loose references
+// and the packed-refs file. Each reference is locked by creating a
+// "<ref>.lock" file next to it, the same way git does, and the new value is
+// written to the lock file and renamed into place on Commit.
+type RefTransaction struct {
+	d       *DotGit
+	updates []*refUpdate
+	// packed is the locked packed-refs file, only opened when references
+	// are being removed.
+	packed billy.File
+
+	prepared bool
+	closed   bool
+}
+
+type refUpdate struct {
+	name plumbing.ReferenceName
+	// new is nil when the reference is being removed.
+	new *plumbing.Reference
+	old *plumbing.Reference
+	// cur is the value of the reference when it was locked, used to roll
+	// it back if Commit fails partway.
+	cur    *plumbing.Reference
+	locked bool
+}
+
+func (u *refUpdate) lockPath() string {
+	return u.name.String() + lockExt
+}
+
+// BeginRefTransaction starts a new reference transaction, a
+// ReftableTransaction if the repository uses the reftable format, a
+// RefTransaction otherwise.
+func (d *DotGit) BeginRefTransaction() storer.ReferenceTransaction {
+	if d.IsReftable() {
+		return &ReftableTransaction{d: d, s: d.reftable}
+	}
+
+	return &RefTransaction{d: d}
+}
+
+// SetReference honors the storer.ReferenceTransaction interface.
+func (t *RefTransaction) SetReference(r, old *plumbing.Reference) error {
+	if r == nil {
+		return nil
+	}
+
+	return t.queue(&refUpdate{name: r.Name(), new: r, old: old})
+}
+
+// RemoveReference honors the storer.ReferenceTransaction interface.
+func (t *RefTransaction) RemoveReference(n plumbing.ReferenceName, old *plumbing.Reference) error {
+	return t.queue(&refUpdate{name: n, old: old})
+}
+
+func (t *RefTransaction) queue(u *refUpdate) error {
+	if t.closed || t.prepared {
+		return storage.ErrReferenceTransactionClosed
+	}
+
+	for _, q := range t.updates {
+		if q.name == u.name {
+			return fmt.Errorf("%w: %s", storage.ErrReferenceUpdateDuplicated, u.name)
+		}
+	}
+
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+// Prepare honors the storer.ReferenceTransaction interface. It takes the lock
+// of every queued reference and checks its current value.
+func (t *RefTransaction) Prepare() (err error) {
+	if t.closed {
+		return storage.ErrReferenceTransactionClosed
+	}
+
+	if t.prepared {
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			_ = t.Abort()
+		}
+	}()
+
+	removing := false
+	for _, u := range t.updates {
+		if err := t.lock(u); err != nil {
+			return err
+		}
+
+		if u.new == nil {
+			removing = true
+		}
+	}
+
+	if removing {
+		t.packed, err = t.d.openAndLockPackedRefs(false)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.prepared = true
+	return nil
+}
+
+func (t *RefTransaction) lock(u *refUpdate) (err error) {
+	f, err := t.d.fs.OpenFile(u.lockPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%w: %s", ErrReferenceLocked, u.name)
+		}
+
+		return err
+	}
+
+	u.locked = true
+	defer ioutil.CheckClose(f, &err)
+
+	cur, err := t.d.Ref(u.name)
+	if err == plumbing.ErrReferenceNotFound {
+		cur, err = nil, nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !storage.ReferenceMatches(cur, u.old) {
+		return storage.ErrReferenceHasChanged
+	}
+
+	u.cur = cur
+
+	if u.new == nil {
+		return nil
+	}
+
+	_, err = f.Write([]byte(refContent(u.new)))
+	return err
+}
+
+// Commit honors the storer.ReferenceTransaction interface. Removed references
+// are dropped from packed-refs first, so a stale packed value never shows up,
+// then the loose files are removed and the lock files renamed into place.
+//
+// If any of these steps fails, every reference already modified is written
+// back as a loose reference with the value it had when it was locked. The
+// filesystem gives no atomic rename of several files, so a crash in the middle
+// of Commit can still leave the references half applied.
+func (t *RefTransaction) Commit() (err error) {
+	if err := t.Prepare(); err != nil {
+		return err
+	}
+
+	var touched []*refUpdate
+	defer func() {
+		if err != nil {
+			_ = t.rollback(touched)
+		}
+
+		if aerr := t.Abort(); err == nil {
+			err = aerr
+		}
+	}()
+
+	removed := make(map[plumbing.ReferenceName]bool)
+	for _, u := range t.updates {
+		if u.new == nil {
+			removed[u.name] = true
+		}
+	}
+
+	if len(removed) > 0 && t.packed != nil {
+		if err := t.d.rewritePackedRefsWithoutRefs(t.packed, removed); err != nil {
+			return err
+		}
+
+		// the packed values of the removed references are gone from now on
+		for _, u := range t.updates {
+			if u.new == nil {
+				touched = append(touched, u)
+			}
+		}
+	}
+
+	for _, u := range t.updates {
+		if u.new == nil {
+			err := t.d.fs.Remove(u.name.String())
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			continue
+		}
+
+		if err := t.d.fs.Rename(u.lockPath(), u.name.String()); err != nil {
+			return err
+		}
+
+		u.locked = false
+		touched = append(touched, u)
+	}
+
+	return nil
+}
+
+// rollback restores the given references to the value they had when they
+// were locked, writing them as loose references, or removing them if they
+// did not exist.
+func (t *RefTransaction) rollback(updates []*refUpdate) error {
+	var firstErr error
+	for _, u := range updates {
+		var err error
+		if u.cur == nil {
+			err = t.d.fs.Remove(u.name.String())
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		} else {
+			err = util.WriteFile(t.d.fs, u.name.String(), []byte(refContent(u.cur)), 0666)
+		}
+
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Abort honors the storer.ReferenceTransaction interface. It is a no-op on a
+// transaction already committed or aborted.
+func (t *RefTransaction) Abort() error {
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+
+	var firstErr error
+	for _, u := range t.updates {
+		if !u.locked {
+			continue
+		}
+
+		err := t.d.fs.Remove(u.lockPath())
+		if err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+
+		u.locked = false
+	}
+
+	if t.packed != nil {
+		if err := t.packed.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+
+		t.packed = nil
+	}
+
+	return firstErr
+}
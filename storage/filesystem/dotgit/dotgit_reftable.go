@@ -0,0 +1,917 @@
+package dotgit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/reftable"
+	"github.com/go-git/go-git/v5/storage"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+)
+
+const (
+	reftablePath   = "reftable"
+	tablesListName = "tables.list"
+	reftableExt    = ".ref"
+
+	// RefStorageReftable is the value of the extensions.refStorage config
+	// option of the repositories using the reftable format.
+	RefStorageReftable = "reftable"
+
+	// reftableInvalidHead is the content of the HEAD file of a reftable
+	// repository, so older git versions still recognize it as a repository
+	// but can't use it.
+	reftableInvalidHead = "ref: refs/heads/.invalid\n"
+	reftableRefsHeads   = "this repository uses the reftable format\n"
+
+	// reftableCompactionFactor is the minimum size ratio between a table and
+	// the sum of the newer tables above which the stack is not compacted.
+	reftableCompactionFactor = 2
+)
+
+// reftableStack is the stack of reftable files of a repository. Tables are
+// cached by name, since once written they never change, and the whole stack
+// is cached until tables.list changes.
+type reftableStack struct {
+	fs     billy.Filesystem
+	tables map[string]*reftable.Reader
+
+	// names and stack are the tables last read from tables.list, valid while
+	// its size and modification time are listSize and listModTime.
+	names       []string
+	stack       []*reftable.Reader
+	listSize    int64
+	listModTime time.Time
+	listRead    time.Time
+}
+
+// IsReftable returns true if the references of the repository are stored in
+// the reftable format, either because the extensions.refStorage config option
+// says so or because the reftable directory has a tables.list file. The
+// answer is cached once the config has been read.
+func (d *DotGit) IsReftable() bool {
+	if d.reftable != nil {
+		return true
+	}
+
+	if d.reftableChecked {
+		return false
+	}
+
+	if _, err := d.fs.Stat(d.fs.Join(reftablePath, tablesListName)); err != nil && !d.hasReftableExtension() {
+		d.reftableChecked = d.refStorageChecked
+		return false
+	}
+
+	d.useReftable()
+	return true
+}
+
+func (d *DotGit) useReftable() {
+	d.reftable = &reftableStack{fs: d.fs, tables: make(map[string]*reftable.Reader)}
+}
+
+// hasReftableExtension returns true if extensions.refStorage is set to
+// reftable in the repository config. The config is only read once, since the
+// reference format of a repository doesn't change once it's created.
+func (d *DotGit) hasReftableExtension() bool {
+	if d.refStorageChecked {
+		return false
+	}
+
+	f, err := d.Config()
+	if err != nil {
+		return false
+	}
+
+	defer f.Close()
+	cfg, err := config.ReadConfig(f)
+	if err != nil {
+		return false
+	}
+
+	d.refStorageChecked = true
+	return cfg.Extensions.RefStorage == RefStorageReftable
+}
+
+// initializeReftable creates the scaffolding of a reftable repository: an
+// empty stack and the HEAD and refs/heads placeholders.
+func (d *DotGit) initializeReftable() error {
+	if err := d.fs.MkdirAll(reftablePath, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := d.fs.MkdirAll(refsPath, os.ModeDir|os.ModePerm); err != nil {
+		return err
+	}
+
+	files := []struct{ path, content string }{
+		{d.fs.Join(reftablePath, tablesListName), ""},
+		{"HEAD", reftableInvalidHead},
+		{d.fs.Join(refsPath, "heads"), reftableRefsHeads},
+	}
+
+	for _, f := range files {
+		if _, err := d.fs.Stat(f.path); err == nil {
+			continue
+		}
+
+		if err := util.WriteFile(d.fs, f.path, []byte(f.content), 0666); err != nil {
+			return err
+		}
+	}
+
+	d.useReftable()
+	return nil
+}
+
+func (s *reftableStack) listPath() string {
+	return s.fs.Join(reftablePath, tablesListName)
+}
+
+func (s *reftableStack) lockPath() string {
+	return s.listPath() + lockExt
+}
+
+// load reads the list of tables, from the oldest to the newest. A table can
+// be removed by a concurrent compaction between reading the list and
+// opening it, in which case the list is read again.
+func (s *reftableStack) load() (names []string, tables []*reftable.Reader, err error) {
+	for retries := 0; ; retries++ {
+		names, tables, err = s.loadOnce()
+		if err == nil || !os.IsNotExist(err) || retries >= 3 {
+			return names, tables, err
+		}
+	}
+}
+
+func (s *reftableStack) loadOnce() ([]string, []*reftable.Reader, error) {
+	fi, err := s.fs.Stat(s.listPath())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if s.isCached(fi) {
+		return s.names, s.stack, nil
+	}
+
+	read := time.Now()
+	f, err := s.fs.Open(s.listPath())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if name := strings.TrimSpace(sc.Text()); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		_ = f.Close()
+		return nil, nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	tables := make([]*reftable.Reader, len(names))
+	cache := make(map[string]*reftable.Reader, len(names))
+	for i, name := range names {
+		t, ok := s.tables[name]
+		if !ok {
+			t, err = s.open(name)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		tables[i] = t
+		cache[name] = t
+	}
+
+	s.tables = cache
+	s.names, s.stack = names, tables
+	s.listSize, s.listModTime, s.listRead = fi.Size(), fi.ModTime(), read
+	return names, tables, nil
+}
+
+// isCached returns true if tables.list is unchanged since the stack was last
+// read. As with the git index, a list modified less than a second before it
+// was read may have been modified again without its modification time
+// changing, so it is never considered unchanged.
+func (s *reftableStack) isCached(fi os.FileInfo) bool {
+	if s.listRead.IsZero() {
+		return false
+	}
+
+	if fi.Size() != s.listSize || !fi.ModTime().Equal(s.listModTime) {
+		return false
+	}
+
+	return s.listModTime.Before(s.listRead.Add(-time.Second))
+}
+
+// open reads a whole table into memory, so it can be removed by a concurrent
+// compaction while being read.
+func (s *reftableStack) open(name string) (*reftable.Reader, error) {
+	data, err := util.ReadFile(s.fs, s.fs.Join(reftablePath, name))
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := reftable.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return t, nil
+}
+
+func (s *reftableStack) ref(name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	_, tables, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return refFromTables(tables, name)
+}
+
+func refFromTables(tables []*reftable.Reader, name plumbing.ReferenceName) (*plumbing.Reference, error) {
+	for i := len(tables) - 1; i >= 0; i-- {
+		rec, err := tables[i].Ref(name.String())
+		if err == plumbing.ErrReferenceNotFound {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if rec.Deleted {
+			break
+		}
+
+		return rec.Reference(), nil
+	}
+
+	return nil, plumbing.ErrReferenceNotFound
+}
+
+func (s *reftableStack) refs() ([]*plumbing.Reference, error) {
+	_, tables, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := mergeRefRecords(tables, true)
+	if err != nil {
+		return nil, err
+	}
+
+	refs := make([]*plumbing.Reference, 0, len(records))
+	for _, rec := range records {
+		refs = append(refs, rec.Reference())
+	}
+
+	return refs, nil
+}
+
+// mergeRefRecords returns the ref records of the given tables sorted by name,
+// newer tables overriding older ones. Deletions are dropped if dropDeleted.
+func mergeRefRecords(tables []*reftable.Reader, dropDeleted bool) ([]*reftable.RefRecord, error) {
+	merged := make(map[string]*reftable.RefRecord)
+	for _, t := range tables {
+		records, err := t.Refs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range records {
+			merged[rec.Name] = rec
+		}
+	}
+
+	records := make([]*reftable.RefRecord, 0, len(merged))
+	for _, rec := range merged {
+		if rec.Deleted && dropDeleted {
+			continue
+		}
+
+		records = append(records, rec)
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+	return records, nil
+}
+
+// mergeLogRecords returns the log records of the given tables sorted by name
+// and by decreasing update index, newer tables overriding older ones.
+// Deletions are dropped if dropDeleted.
+func mergeLogRecords(tables []*reftable.Reader, dropDeleted bool) ([]*reftable.LogRecord, error) {
+	type logKey struct {
+		name  string
+		index uint64
+	}
+
+	merged := make(map[logKey]*reftable.LogRecord)
+	for _, t := range tables {
+		records, err := t.Logs()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range records {
+			merged[logKey{rec.Name, rec.UpdateIndex}] = rec
+		}
+	}
+
+	records := make([]*reftable.LogRecord, 0, len(merged))
+	for _, rec := range merged {
+		if rec.Deleted && dropDeleted {
+			continue
+		}
+
+		records = append(records, rec)
+	}
+
+	sortLogRecords(records)
+	return records, nil
+}
+
+func sortLogRecords(records []*reftable.LogRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Name != records[j].Name {
+			return records[i].Name < records[j].Name
+		}
+
+		return records[i].UpdateIndex > records[j].UpdateIndex
+	})
+}
+
+func (s *reftableStack) logs(name plumbing.ReferenceName) ([]*reftable.LogRecord, error) {
+	_, tables, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint64]bool)
+	var logs []*reftable.LogRecord
+	for i := len(tables) - 1; i >= 0; i-- {
+		records, err := tables[i].LogsFor(name.String())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, rec := range records {
+			if seen[rec.UpdateIndex] {
+				continue
+			}
+
+			seen[rec.UpdateIndex] = true
+			if !rec.Deleted {
+				logs = append(logs, rec)
+			}
+		}
+	}
+
+	sortLogRecords(logs)
+	return logs, nil
+}
+
+// lock takes the lock of the stack.
+func (s *reftableStack) lock() (billy.File, error) {
+	f, err := s.fs.OpenFile(s.lockPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrReferenceLocked, s.listPath())
+	}
+
+	return f, err
+}
+
+// writeTable writes a new table with the given records, and returns its name.
+func (s *reftableStack) writeTable(
+	min, max uint64, refs []*reftable.RefRecord, logs []*reftable.LogRecord,
+) (name string, err error) {
+	tmp, err := s.fs.TempFile(reftablePath, "tmp_")
+	if err != nil {
+		return "", err
+	}
+
+	tmpName := tmp.Name()
+	defer func() {
+		if err != nil {
+			_ = s.fs.Remove(tmpName)
+		}
+	}()
+
+	bw := bufio.NewWriter(tmp)
+	w := reftable.NewWriter(bw, reftable.WriterOptions{
+		MinUpdateIndex: min,
+		MaxUpdateIndex: max,
+	})
+
+	for _, rec := range refs {
+		if err := w.AddRef(rec); err != nil {
+			_ = tmp.Close()
+			return "", err
+		}
+	}
+
+	for _, rec := range logs {
+		if err := w.AddLog(rec); err != nil {
+			_ = tmp.Close()
+			return "", err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		_ = tmp.Close()
+		return "", err
+	}
+
+	if err := bw.Flush(); err != nil {
+		_ = tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	name = fmt.Sprintf("0x%012x-0x%012x-%08x%s", min, max, rand.Uint32(), reftableExt)
+	return name, s.fs.Rename(tmpName, s.fs.Join(reftablePath, name))
+}
+
+// commitList writes the new list of tables into the lock file and renames it
+// into place, releasing the lock. Tables which are no longer listed are
+// removed afterwards.
+func (s *reftableStack) commitList(lock billy.File, old, names []string) error {
+	var buf bytes.Buffer
+	for _, name := range names {
+		buf.WriteString(name + "\n")
+	}
+
+	if _, err := lock.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	if err := lock.Close(); err != nil {
+		return err
+	}
+
+	if err := s.fs.Rename(s.lockPath(), s.listPath()); err != nil {
+		return err
+	}
+
+	listed := make(map[string]bool, len(names))
+	for _, name := range names {
+		listed[name] = true
+	}
+
+	for _, name := range old {
+		if !listed[name] {
+			_ = s.fs.Remove(s.fs.Join(reftablePath, name))
+		}
+	}
+
+	return nil
+}
+
+// compact merges the tables from start up to the newest one into a single
+// table, returning the new list of tables. Deletions are only dropped when
+// the whole stack is compacted, since otherwise they hide records of older
+// tables.
+func (s *reftableStack) compact(names []string, tables []*reftable.Reader, start int) ([]string, error) {
+	if len(tables)-start < 2 {
+		return names, nil
+	}
+
+	dropDeleted := start == 0
+	refs, err := mergeRefRecords(tables[start:], dropDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := mergeLogRecords(tables[start:], dropDeleted)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := s.writeTable(
+		tables[start].MinUpdateIndex(), tables[len(tables)-1].MaxUpdateIndex(), refs, logs,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(append([]string(nil), names[:start]...), name), nil
+}
+
+// compactionStart returns the index of the oldest table to compact, so every
+// table of the stack is at least reftableCompactionFactor times as large as
+// all the newer tables together.
+func compactionStart(tables []*reftable.Reader) int {
+	start := len(tables) - 1
+	if start < 0 {
+		return 0
+	}
+
+	total := tables[start].Size()
+	for start > 0 && tables[start-1].Size() < reftableCompactionFactor*total {
+		start--
+		total += tables[start].Size()
+	}
+
+	return start
+}
+
+// packRefs compacts the whole stack into a single table.
+func (s *reftableStack) packRefs() (err error) {
+	lock, err := s.lock()
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = lock.Close()
+			_ = s.fs.Remove(s.lockPath())
+		}
+	}()
+
+	names, tables, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if len(tables) < 2 {
+		_ = lock.Close()
+		return s.fs.Remove(s.lockPath())
+	}
+
+	compacted, err := s.compact(names, tables, 0)
+	if err != nil {
+		return err
+	}
+
+	return s.commitList(lock, names, compacted)
+}
+
+// ReftableTransaction implements storer.ReferenceTransaction for repositories
+// using the reftable format. The stack is locked on Prepare, and all the
+// updates are written to a single new table on Commit, along with the log
+// records of the updated references.
+type ReftableTransaction struct {
+	d       *DotGit
+	s       *reftableStack
+	updates []*refUpdate
+
+	lockFile billy.File
+	names    []string
+	tables   []*reftable.Reader
+	current  map[plumbing.ReferenceName]*plumbing.Reference
+
+	prepared bool
+	closed   bool
+}
+
+// SetReference honors the storer.ReferenceTransaction interface.
+func (t *ReftableTransaction) SetReference(r, old *plumbing.Reference) error {
+	if r == nil {
+		return nil
+	}
+
+	return t.queue(&refUpdate{name: r.Name(), new: r, old: old})
+}
+
+// RemoveReference honors the storer.ReferenceTransaction interface.
+func (t *ReftableTransaction) RemoveReference(n plumbing.ReferenceName, old *plumbing.Reference) error {
+	return t.queue(&refUpdate{name: n, old: old})
+}
+
+func (t *ReftableTransaction) queue(u *refUpdate) error {
+	if t.closed || t.prepared {
+		return storage.ErrReferenceTransactionClosed
+	}
+
+	for _, q := range t.updates {
+		if q.name == u.name {
+			return fmt.Errorf("%w: %s", storage.ErrReferenceUpdateDuplicated, u.name)
+		}
+	}
+
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+// Prepare honors the storer.ReferenceTransaction interface. It locks the
+// stack and checks the current value of every queued reference.
+func (t *ReftableTransaction) Prepare() (err error) {
+	if t.closed {
+		return storage.ErrReferenceTransactionClosed
+	}
+
+	if t.prepared {
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			_ = t.Abort()
+		}
+	}()
+
+	t.lockFile, err = t.s.lock()
+	if err != nil {
+		return err
+	}
+
+	t.names, t.tables, err = t.s.load()
+	if err != nil {
+		return err
+	}
+
+	t.current = make(map[plumbing.ReferenceName]*plumbing.Reference)
+	for _, u := range t.updates {
+		cur, err := refFromTables(t.tables, u.name)
+		if err == plumbing.ErrReferenceNotFound {
+			cur, err = nil, nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !storage.ReferenceMatches(cur, u.old) {
+			return storage.ErrReferenceHasChanged
+		}
+
+		t.current[u.name] = cur
+	}
+
+	t.prepared = true
+	return nil
+}
+
+// Commit honors the storer.ReferenceTransaction interface. The new table is
+// added on top of the stack, which is then compacted if needed.
+func (t *ReftableTransaction) Commit() (err error) {
+	if err := t.Prepare(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if aerr := t.Abort(); err == nil {
+			err = aerr
+		}
+	}()
+
+	index := uint64(1)
+	if len(t.tables) > 0 {
+		index = t.tables[len(t.tables)-1].MaxUpdateIndex() + 1
+	}
+
+	name, email := t.d.committer()
+	now := time.Now()
+
+	var refs []*reftable.RefRecord
+	var logs []*reftable.LogRecord
+	for _, u := range t.updates {
+		if u.new == nil {
+			if t.current[u.name] == nil {
+				continue
+			}
+
+			refs = append(refs, &reftable.RefRecord{
+				Name: u.name.String(), UpdateIndex: index, Deleted: true,
+			})
+
+			continue
+		}
+
+		refs = append(refs, reftable.NewRefRecord(u.new, index))
+		if u.new.Type() != plumbing.HashReference {
+			continue
+		}
+
+		l := &reftable.LogRecord{
+			Name:           u.name.String(),
+			UpdateIndex:    index,
+			New:            u.new.Hash(),
+			CommitterName:  name,
+			CommitterEmail: email,
+			When:           now,
+		}
+
+		if cur := t.current[u.name]; cur != nil && cur.Type() == plumbing.HashReference {
+			l.Old = cur.Hash()
+		}
+
+		logs = append(logs, l)
+	}
+
+	if len(refs) == 0 {
+		return nil
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	sortLogRecords(logs)
+
+	table, err := t.s.writeTable(index, index, refs, logs)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = t.s.fs.Remove(t.s.fs.Join(reftablePath, table))
+		}
+	}()
+
+	names := append(append([]string(nil), t.names...), table)
+	added, err := t.s.open(table)
+	if err != nil {
+		return err
+	}
+
+	tables := append(append([]*reftable.Reader(nil), t.tables...), added)
+	if start := compactionStart(tables); start < len(tables)-1 {
+		compacted, err := t.s.compact(names, tables, start)
+		if err != nil {
+			return err
+		}
+
+		names = compacted
+	}
+
+	if err := t.s.commitList(t.lockFile, t.names, names); err != nil {
+		return err
+	}
+
+	if names[len(names)-1] != table {
+		_ = t.s.fs.Remove(t.s.fs.Join(reftablePath, table))
+	}
+
+	t.lockFile = nil
+	return nil
+}
+
+// Abort honors the storer.ReferenceTransaction interface. It is a no-op on a
+// transaction already committed or aborted.
+func (t *ReftableTransaction) Abort() error {
+	if t.closed {
+		return nil
+	}
+
+	t.closed = true
+	if t.lockFile == nil {
+		return nil
+	}
+
+	_ = t.lockFile.Close()
+	t.lockFile = nil
+
+	err := t.s.fs.Remove(t.s.lockPath())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// commitReftableUpdate applies the updates queued by fn in a single
+// transaction.
+func (d *DotGit) commitReftableUpdate(fn func(*ReftableTransaction) error) error {
+	t := &ReftableTransaction{d: d, s: d.reftable}
+	if err := fn(t); err != nil {
+		return err
+	}
+
+	return t.Commit()
+}
+
+// committer returns the identity recorded in the reflog entries, read from
+// the repository config.
+func (d *DotGit) committer() (name, email string) {
+	f, err := d.Config()
+	if err != nil {
+		return "", ""
+	}
+
+	defer f.Close()
+	cfg, err := config.ReadConfig(f)
+	if err != nil {
+		return "", ""
+	}
+
+	if cfg.Committer.Name != "" {
+		return cfg.Committer.Name, cfg.Committer.Email
+	}
+
+	return cfg.User.Name, cfg.User.Email
+}
+
+// RefLog returns the reflog entries of the given reference, from the newest
+// to the oldest. Entries are read from the log records of the reftable
+// stack, or from the logs directory for loose references.
+func (d *DotGit) RefLog(name plumbing.ReferenceName) ([]*reftable.LogRecord, error) {
+	if d.IsReftable() {
+		return d.reftable.logs(name)
+	}
+
+	f, err := d.fs.Open(d.fs.Join(logsPath, name.String()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+	return readLooseRefLog(f, name)
+}
+
+// readLooseRefLog decodes a reflog file, each line having the format
+// "<old> <new> <name> <<email>> <timestamp> <tz>\t<message>".
+func readLooseRefLog(r io.Reader, name plumbing.ReferenceName) ([]*reftable.LogRecord, error) {
+	var logs []*reftable.LogRecord
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+
+		l, err := parseLooseRefLogLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		l.Name = name.String()
+		logs = append([]*reftable.LogRecord{l}, logs...)
+	}
+
+	return logs, s.Err()
+}
+
+func parseLooseRefLogLine(line string) (*reftable.LogRecord, error) {
+	l := &reftable.LogRecord{}
+	header := line
+	if i := strings.IndexByte(line, '\t'); i >= 0 {
+		header, l.Message = line[:i], line[i+1:]
+	}
+
+	fields := strings.SplitN(header, " ", 3)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("malformed reflog entry: %q", line)
+	}
+
+	l.Old = plumbing.NewHash(fields[0])
+	l.New = plumbing.NewHash(fields[1])
+
+	ident := fields[2]
+	start, end := strings.IndexByte(ident, '<'), strings.LastIndexByte(ident, '>')
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("malformed reflog entry: %q", line)
+	}
+
+	l.CommitterName = strings.TrimSpace(ident[:start])
+	l.CommitterEmail = ident[start+1 : end]
+
+	when := strings.Fields(ident[end+1:])
+	if len(when) != 2 {
+		return nil, fmt.Errorf("malformed reflog entry: %q", line)
+	}
+
+	sec, err := strconv.ParseInt(when[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed reflog entry: %q", line)
+	}
+
+	tz, err := strconv.Atoi(when[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed reflog entry: %q", line)
+	}
+
+	offset := (tz/100*60 + tz%100) * 60
+	l.When = time.Unix(sec, 0).In(time.FixedZone("", offset))
+	return l, nil
+}
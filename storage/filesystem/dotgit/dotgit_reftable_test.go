@@ -0,0 +1,333 @@
+package dotgit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage"
+	. "gopkg.in/check.v1"
+)
+
+func newReftableDotGit(c *C) *DotGit {
+	dir := NewWithOptions(memfs.New(), Options{Reftable: true})
+	c.Assert(dir.Initialize(), IsNil)
+	c.Assert(dir.IsReftable(), Equals, true)
+	return dir
+}
+
+func reftableNames(c *C, dir *DotGit) []string {
+	b, err := util.ReadFile(dir.fs, dir.fs.Join(reftablePath, tablesListName))
+	c.Assert(err, IsNil)
+	return strings.Fields(string(b))
+}
+
+func (s *SuiteDotGit) TestReftableInitialize(c *C) {
+	dir := newReftableDotGit(c)
+
+	b, err := util.ReadFile(dir.fs, "HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, reftableInvalidHead)
+
+	_, err = dir.Ref(plumbing.HEAD)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 0)
+
+	c.Assert(New(memfs.New()).IsReftable(), Equals, false)
+}
+
+func (s *SuiteDotGit) TestReftableExtension(c *C) {
+	fs := memfs.New()
+	err := util.WriteFile(fs, "config", []byte(""+
+		"[core]\n\trepositoryformatversion = 1\n"+
+		"[extensions]\n\trefStorage = reftable\n"), 0666)
+	c.Assert(err, IsNil)
+
+	// the config says so even before the reftable directory is created
+	dir := New(fs)
+	c.Assert(dir.IsReftable(), Equals, true)
+
+	_, err = dir.Ref(plumbing.HEAD)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SuiteDotGit) TestReftableNotUsed(c *C) {
+	fs := memfs.New()
+	err := util.WriteFile(fs, "config", []byte("[core]\n\trepositoryformatversion = 0\n"), 0666)
+	c.Assert(err, IsNil)
+
+	dir := New(fs)
+	c.Assert(dir.IsReftable(), Equals, false)
+
+	// the answer is cached, the format of a repository doesn't change
+	c.Assert(util.WriteFile(fs, fs.Join(reftablePath, tablesListName), nil, 0666), IsNil)
+	c.Assert(dir.IsReftable(), Equals, false)
+	c.Assert(New(fs).IsReftable(), Equals, true)
+}
+
+// TestReftableGit checks the tables are compatible with the ones of git, which
+// supports the reftable format since 2.45.
+func (s *SuiteDotGit) TestReftableGit(c *C) {
+	fs, clean := s.TemporalFilesystem()
+	defer clean()
+
+	run := func(args ...string) ([]byte, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = fs.Root()
+		cmd.Env = append(os.Environ(),
+			"GIT_CONFIG_NOSYSTEM=1",
+			"GIT_CONFIG_GLOBAL="+os.DevNull,
+			"GIT_AUTHOR_NAME=foo", "GIT_AUTHOR_EMAIL=foo@foo.foo",
+			"GIT_COMMITTER_NAME=foo", "GIT_COMMITTER_EMAIL=foo@foo.foo",
+		)
+
+		return cmd.CombinedOutput()
+	}
+
+	git := func(args ...string) string {
+		out, err := run(args...)
+		c.Assert(err, IsNil, Commentf("git %s: %s", strings.Join(args, " "), out))
+		return strings.TrimSpace(string(out))
+	}
+
+	if _, err := run("init", "--bare", "--ref-format=reftable", "--initial-branch=master", "."); err != nil {
+		c.Skip("git doesn't support the reftable format")
+	}
+
+	tree := git("hash-object", "-w", "-t", "tree", os.DevNull)
+	commit := git("commit-tree", "-m", "foo", tree)
+	git("-c", "core.logAllRefUpdates=always", "update-ref", "-m", "create master", "refs/heads/master", commit)
+	git("tag", "-m", "foo", "v1.0", commit)
+	tag := git("rev-parse", "refs/tags/v1.0")
+
+	// references written by git
+	dir := New(fs)
+	c.Assert(dir.IsReftable(), Equals, true)
+
+	head, err := dir.Ref(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(head.Target(), Equals, plumbing.Master)
+
+	ref, err := dir.Ref(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, commit)
+
+	ref, err = dir.Ref("refs/tags/v1.0")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, tag)
+
+	logs, err := dir.RefLog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 1)
+	c.Assert(logs[0].New.String(), Equals, commit)
+	c.Assert(logs[0].Message, Equals, "create master")
+
+	// references written to a stack of git, read by git
+	c.Assert(dir.SetRef(plumbing.NewReferenceFromStrings("refs/heads/foo", commit), nil), IsNil)
+	c.Assert(dir.RemoveRef("refs/tags/v1.0"), IsNil)
+	c.Assert(git("for-each-ref", "--format=%(refname) %(objectname)"), Equals, ""+
+		"refs/heads/foo "+commit+"\n"+
+		"refs/heads/master "+commit)
+
+	c.Assert(dir.PackRefs(), IsNil)
+	c.Assert(reftableNames(c, dir), HasLen, 1)
+	c.Assert(git("rev-parse", "refs/heads/foo"), Equals, commit)
+	git("fsck", "--no-progress")
+}
+
+func (s *SuiteDotGit) TestReftableStackCache(c *C) {
+	fs, clean := s.TemporalFilesystem()
+	defer clean()
+
+	dir := NewWithOptions(fs, Options{Reftable: true})
+	c.Assert(dir.Initialize(), IsNil)
+	master := plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(dir.SetRef(master, nil), IsNil)
+
+	list := filepath.Join(fs.Root(), reftablePath, tablesListName)
+	old := time.Now().Add(-time.Hour)
+	c.Assert(os.Chtimes(list, old, old), IsNil)
+
+	ref, err := dir.Ref(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, master)
+
+	// an unchanged size and modification time means an unchanged stack
+	b, err := ioutil.ReadFile(list)
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(list, bytes.Repeat([]byte("x"), len(b)), 0666), IsNil)
+	c.Assert(os.Chtimes(list, old, old), IsNil)
+
+	ref, err = dir.Ref(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, master)
+
+	c.Assert(os.Chtimes(list, time.Now(), time.Now()), IsNil)
+	_, err = dir.Ref(plumbing.Master)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SuiteDotGit) TestReftableSetRef(c *C) {
+	dir := newReftableDotGit(c)
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.Master)
+	c.Assert(dir.SetRef(head, nil), IsNil)
+	master := plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(dir.SetRef(master, nil), IsNil)
+
+	ref, err := dir.Ref(plumbing.HEAD)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, head)
+
+	ref, err = dir.Ref(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(ref, DeepEquals, master)
+
+	updated := plumbing.NewReferenceFromStrings("refs/heads/master", "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	stale := plumbing.NewReferenceFromStrings("refs/heads/master", "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+	c.Assert(dir.SetRef(updated, stale), Equals, storage.ErrReferenceHasChanged)
+	c.Assert(dir.SetRef(updated, master), IsNil)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, DeepEquals, []*plumbing.Reference{head, updated})
+
+	c.Assert(dir.RemoveRef(plumbing.Master), IsNil)
+	_, err = dir.Ref(plumbing.Master)
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	refs, err = dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, DeepEquals, []*plumbing.Reference{head})
+
+	n, err := dir.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
+
+func (s *SuiteDotGit) TestReftableRefLog(c *C) {
+	dir := newReftableDotGit(c)
+
+	hashes := []string{
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+		"918c48b83bd081e863dbe1b80f8998f058cd8294",
+	}
+
+	for _, h := range hashes {
+		c.Assert(dir.SetRef(plumbing.NewReferenceFromStrings("refs/heads/master", h), nil), IsNil)
+	}
+
+	logs, err := dir.RefLog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 3)
+	c.Assert(logs[0].New.String(), Equals, hashes[2])
+	c.Assert(logs[0].Old.String(), Equals, hashes[1])
+	c.Assert(logs[2].New.String(), Equals, hashes[0])
+	c.Assert(logs[2].Old.IsZero(), Equals, true)
+
+	// Logs survive the compaction of the stack.
+	c.Assert(dir.PackRefs(), IsNil)
+	c.Assert(reftableNames(c, dir), HasLen, 1)
+
+	compacted, err := dir.RefLog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(compacted, HasLen, 3)
+	for i := range logs {
+		c.Assert(compacted[i].UpdateIndex, Equals, logs[i].UpdateIndex)
+		c.Assert(compacted[i].New, Equals, logs[i].New)
+	}
+}
+
+func (s *SuiteDotGit) TestReftableAutoCompaction(c *C) {
+	dir := newReftableDotGit(c)
+
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("refs/heads/branch-%03d", i)
+		ref := plumbing.NewReferenceFromStrings(name, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+		c.Assert(dir.SetRef(ref, nil), IsNil)
+	}
+
+	// The stack grows logarithmically with the number of updates.
+	names := reftableNames(c, dir)
+	c.Assert(len(names) < 10, Equals, true, Commentf("%d tables", len(names)))
+
+	fis, err := dir.fs.ReadDir(reftablePath)
+	c.Assert(err, IsNil)
+	c.Assert(fis, HasLen, len(names)+1)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 100)
+
+	c.Assert(dir.RemoveRef("refs/heads/branch-042"), IsNil)
+	c.Assert(dir.PackRefs(), IsNil)
+
+	refs, err = dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 99)
+
+	_, err = dir.Ref("refs/heads/branch-042")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *SuiteDotGit) TestReftableTransaction(c *C) {
+	dir := newReftableDotGit(c)
+
+	master := plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+	c.Assert(dir.SetRef(master, nil), IsNil)
+
+	tx := dir.BeginRefTransaction()
+	c.Assert(tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881"), nil,
+	), IsNil)
+	c.Assert(tx.RemoveReference(plumbing.Master, master), IsNil)
+	c.Assert(tx.Prepare(), IsNil)
+
+	// The stack is locked until the transaction ends.
+	err := dir.SetRef(plumbing.NewReferenceFromStrings("refs/heads/bar", "e8d3ffab552895c19b9fcf7aa264d277cde33881"), nil)
+	c.Assert(errors.Is(err, ErrReferenceLocked), Equals, true)
+
+	c.Assert(tx.Commit(), IsNil)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 1)
+	c.Assert(refs[0].Name(), Equals, plumbing.ReferenceName("refs/heads/foo"))
+}
+
+func (s *SuiteDotGit) TestLooseRefLog(c *C) {
+	fs := memfs.New()
+	err := util.WriteFile(fs, "logs/refs/heads/master", []byte(""+
+		"0000000000000000000000000000000000000000 6ecf0ef2c2dffb796033e5a02219af86ec6584e5 John Doe <john@doe.com> 1600000000 +0200\tclone: from foo\n"+
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 e8d3ffab552895c19b9fcf7aa264d277cde33881 John Doe <john@doe.com> 1600000100 -0130\tcommit: bar\n",
+	), 0644)
+	c.Assert(err, IsNil)
+
+	logs, err := New(fs).RefLog(plumbing.Master)
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 2)
+	c.Assert(logs[0].Message, Equals, "commit: bar")
+	c.Assert(logs[0].CommitterName, Equals, "John Doe")
+	c.Assert(logs[0].CommitterEmail, Equals, "john@doe.com")
+	c.Assert(logs[0].When.Unix(), Equals, int64(1600000100))
+	_, offset := logs[0].When.Zone()
+	c.Assert(offset, Equals, -90*60)
+	c.Assert(logs[1].Old.IsZero(), Equals, true)
+
+	logs, err = New(fs).RefLog("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(logs, HasLen, 0)
+}
@@ -3,6 +3,7 @@ package dotgit
 import (
 	"bufio"
 	"encoding/hex"
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
@@ -282,6 +283,116 @@ func (s *SuiteDotGit) TestRemoveRefFromReferenceFileAndPackedRefs(c *C) {
 	c.Assert(ref, IsNil)
 }
 
+func (s *SuiteDotGit) TestRefTransaction(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	dir := New(fs)
+
+	tx := dir.BeginRefTransaction()
+	err := tx.RemoveReference(
+		"refs/remotes/origin/master",
+		plumbing.NewReferenceFromStrings("refs/remotes/origin/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	)
+	c.Assert(err, IsNil)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/master", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		plumbing.NewReferenceFromStrings("refs/heads/master", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Commit(), IsNil)
+
+	b, err := util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, ""+
+		"# pack-refs with: peeled fully-peeled \n"+
+		"6ecf0ef2c2dffb796033e5a02219af86ec6584e5 refs/heads/master\n"+
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881 refs/remotes/origin/branch\n")
+
+	ref, err := dir.Ref("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "e8d3ffab552895c19b9fcf7aa264d277cde33881")
+
+	_, err = fs.Stat("refs/heads/master.lock")
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SuiteDotGit) TestRefTransactionLocked(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	dir := New(fs)
+
+	f, err := fs.Create("refs/heads/master.lock")
+	c.Assert(err, IsNil)
+	c.Assert(f.Close(), IsNil)
+
+	tx := dir.BeginRefTransaction()
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/master", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+
+	err = tx.Commit()
+	c.Assert(errors.Is(err, ErrReferenceLocked), Equals, true)
+
+	_, err = fs.Stat("refs/heads/foo.lock")
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = dir.Ref("refs/heads/foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	// lock files are not references
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(findReference(refs, "refs/heads/master.lock"), IsNil)
+}
+
+func (s *SuiteDotGit) TestRefTransactionRollback(c *C) {
+	fs := fixtures.Basic().ByTag(".git").One().DotGit()
+	dir := New(fs)
+
+	before, err := util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+
+	tx := dir.BeginRefTransaction()
+	err = tx.RemoveReference("refs/remotes/origin/master", nil)
+	c.Assert(err, IsNil)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/master", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "e8d3ffab552895c19b9fcf7aa264d277cde33881"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Prepare(), IsNil)
+
+	// a directory in place of refs/heads/foo makes its rename fail
+	c.Assert(fs.MkdirAll("refs/heads/foo/bar", 0755), IsNil)
+	c.Assert(tx.Commit(), NotNil)
+
+	after, err := util.ReadFile(fs, packedRefsPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(after), Not(Equals), string(before))
+
+	ref, err := dir.Ref("refs/remotes/origin/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	ref, err = dir.Ref("refs/heads/master")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	for _, name := range []string{"refs/remotes/origin/master", "refs/heads/master", "refs/heads/foo"} {
+		_, err = fs.Stat(name + ".lock")
+		c.Assert(os.IsNotExist(err), Equals, true)
+	}
+}
+
 func (s *SuiteDotGit) TestRemoveRefNonExistent(c *C) {
 	fs := fixtures.Basic().ByTag(".git").One().DotGit()
 	dir := New(fs)
@@ -438,6 +549,13 @@ func (s *SuiteDotGit) TestShallowWriteAndShallow(c *C) {
 	c.Assert(err, IsNil)
 
 	c.Assert(string(cnt), Equals, "foo")
+
+	c.Assert(dir.RemoveShallow(), IsNil)
+	f, err = dir.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(f, IsNil)
+
+	c.Assert(dir.RemoveShallow(), IsNil)
 }
 
 func findReference(refs []*plumbing.Reference, name string) *plumbing.Reference {
@@ -790,6 +908,38 @@ func (s *SuiteDotGit) TestPackRefs(c *C) {
 	c.Assert(ref.Hash().String(), Equals, "b8d3ffab552895c19b9fcf7aa264d277cde33881")
 }
 
+func (s *SuiteDotGit) TestPackRefsSymbolic(c *C) {
+	fs, clean := s.TemporalFilesystem()
+	defer clean()
+
+	dir := New(fs)
+	err := dir.SetRef(plumbing.NewReferenceFromStrings(
+		"refs/remotes/origin/master",
+		"e8d3ffab552895c19b9fcf7aa264d277cde33881",
+	), nil)
+	c.Assert(err, IsNil)
+	err = dir.SetRef(plumbing.NewSymbolicReference(
+		"refs/remotes/origin/HEAD",
+		"refs/remotes/origin/master",
+	), nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(dir.PackRefs(), IsNil)
+
+	// Symbolic references stay loose.
+	looseCount, err := dir.CountLooseRefs()
+	c.Assert(err, IsNil)
+	c.Assert(looseCount, Equals, 1)
+
+	refs, err := dir.Refs()
+	c.Assert(err, IsNil)
+	c.Assert(refs, HasLen, 2)
+
+	ref, err := dir.Ref("refs/remotes/origin/HEAD")
+	c.Assert(err, IsNil)
+	c.Assert(ref.Target(), Equals, plumbing.ReferenceName("refs/remotes/origin/master"))
+}
+
 func (s *SuiteDotGit) TestAlternates(c *C) {
 	fs, clean := s.TemporalFilesystem()
 	defer clean()
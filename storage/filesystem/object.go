@@ -865,5 +865,63 @@ func (s *ObjectStorage) ObjectPacks() ([]plumbing.Hash, error) {
 }
 
 func (s *ObjectStorage) DeleteOldObjectPackAndIndex(h plumbing.Hash, t time.Time) error {
-	return s.dir.DeleteOldObjectPackAndIndex(h, t)
+	if err := s.dir.DeleteOldObjectPackAndIndex(h, t); err != nil {
+		return err
+	}
+
+	// The pack may have been deleted, its index must not be used anymore.
+	s.Reindex()
+	return nil
+}
+
+// ObjectPackHashes returns the hashes of the objects stored in a pack.
+func (s *ObjectStorage) ObjectPackHashes(pack plumbing.Hash) ([]plumbing.Hash, error) {
+	if err := s.requireIndex(); err != nil {
+		return nil, err
+	}
+
+	idx, ok := s.index[pack]
+	if !ok {
+		if err := s.loadIdxFile(pack); err != nil {
+			return nil, err
+		}
+
+		idx = s.index[pack]
+	}
+
+	iter, err := idx.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	defer iter.Close()
+
+	var hashes []plumbing.Hash
+	for {
+		e, err := iter.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		hashes = append(hashes, e.Hash)
+	}
+}
+
+// ObjectPackTime returns the modification time of a pack.
+func (s *ObjectStorage) ObjectPackTime(pack plumbing.Hash) (time.Time, error) {
+	fi, err := s.dir.ObjectPackStat(pack)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return fi.ModTime(), nil
+}
+
+// IsObjectPackKept returns true if a pack has a ".keep" file.
+func (s *ObjectStorage) IsObjectPackKept(pack plumbing.Hash) (bool, error) {
+	return s.dir.ObjectPackKept(pack)
 }
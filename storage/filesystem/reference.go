@@ -2,6 +2,7 @@ package filesystem
 
 import (
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/reftable"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 	"github.com/go-git/go-git/v5/storage/filesystem/dotgit"
 )
@@ -18,6 +19,10 @@ func (r *ReferenceStorage) CheckAndSetReference(ref, old *plumbing.Reference) er
 	return r.dir.SetRef(ref, old)
 }
 
+func (r *ReferenceStorage) BeginReferenceTransaction() storer.ReferenceTransaction {
+	return r.dir.BeginRefTransaction()
+}
+
 func (r *ReferenceStorage) Reference(n plumbing.ReferenceName) (*plumbing.Reference, error) {
 	return r.dir.Ref(n)
 }
@@ -42,3 +47,9 @@ func (r *ReferenceStorage) CountLooseRefs() (int, error) {
 func (r *ReferenceStorage) PackRefs() error {
 	return r.dir.PackRefs()
 }
+
+// RefLog returns the reflog entries of a reference, from the newest to the
+// oldest.
+func (r *ReferenceStorage) RefLog(n plumbing.ReferenceName) ([]*reftable.LogRecord, error) {
+	return r.dir.RefLog(n)
+}
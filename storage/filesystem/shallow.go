@@ -17,8 +17,12 @@ type ShallowStorage struct {
 
 // SetShallow save the shallows in the shallow file in the .git folder as one
 // commit per line represented by 40-byte hexadecimal object terminated by a
-// newline.
+// newline. The file is removed if there's no shallow commit, as git does.
 func (s *ShallowStorage) SetShallow(commits []plumbing.Hash) error {
+	if len(commits) == 0 {
+		return s.dir.RemoveShallow()
+	}
+
 	f, err := s.dir.ShallowWriter()
 	if err != nil {
 		return err
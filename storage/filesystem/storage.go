@@ -12,8 +12,9 @@ import (
 // standard git format (this is, the .git directory). Zero values of this type
 // are not safe to use, see the NewStorage function below.
 type Storage struct {
-	fs  billy.Filesystem
-	dir *dotgit.DotGit
+	fs       billy.Filesystem
+	dir      *dotgit.DotGit
+	reftable bool
 
 	ObjectStorage
 	ReferenceStorage
@@ -37,6 +38,9 @@ type Options struct {
 	// LargeObjectThreshold maximum object size (in bytes) that will be read in to memory.
 	// If left unset or set to 0 there is no limit
 	LargeObjectThreshold int64
+	// Reftable makes Init create a repository storing its references in the
+	// reftable format instead of loose references and packed-refs.
+	Reftable bool
 }
 
 // NewStorage returns a new Storage backed by a given `fs.Filesystem` and cache.
@@ -49,12 +53,14 @@ func NewStorage(fs billy.Filesystem, cache cache.Object) *Storage {
 func NewStorageWithOptions(fs billy.Filesystem, cache cache.Object, ops Options) *Storage {
 	dirOps := dotgit.Options{
 		ExclusiveAccess: ops.ExclusiveAccess,
+		Reftable:        ops.Reftable,
 	}
 	dir := dotgit.NewWithOptions(fs, dirOps)
 
 	return &Storage{
-		fs:  fs,
-		dir: dir,
+		fs:       fs,
+		dir:      dir,
+		reftable: ops.Reftable,
 
 		ObjectStorage:    *NewObjectStorageWithOptions(dir, cache, ops),
 		ReferenceStorage: ReferenceStorage{dir: dir},
@@ -72,5 +78,19 @@ func (s *Storage) Filesystem() billy.Filesystem {
 
 // Init initializes .git directory
 func (s *Storage) Init() error {
-	return s.dir.Initialize()
+	if err := s.dir.Initialize(); err != nil {
+		return err
+	}
+
+	if !s.reftable {
+		return nil
+	}
+
+	cfg, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	cfg.Extensions.RefStorage = dotgit.RefStorageReftable
+	return s.SetConfig(cfg)
 }
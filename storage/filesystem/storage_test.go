@@ -80,3 +80,35 @@ func (s *StorageExclusiveSuite) SetUpTest(c *C) {
 
 	setUpTest(&s.StorageSuite, c, storage)
 }
+
+type StorageReftableSuite struct {
+	StorageSuite
+}
+
+var _ = Suite(&StorageReftableSuite{})
+
+func (s *StorageReftableSuite) SetUpTest(c *C) {
+	tmp, err := util.TempDir(osfs.Default, "", "go-git-filestystem-reftable")
+	c.Assert(err, IsNil)
+
+	s.dir = tmp
+	s.fs = osfs.New(s.dir)
+
+	storage := NewStorageWithOptions(
+		s.fs,
+		cache.NewObjectLRUDefault(),
+		Options{Reftable: true})
+
+	c.Assert(storage.Init(), IsNil)
+	setUpTest(&s.StorageSuite, c, storage)
+}
+
+func (s *StorageReftableSuite) TestNewStorageShouldNotAddAnyContentsToDir(c *C) {
+	_, err := s.fs.Stat("reftable/tables.list")
+	c.Assert(err, IsNil)
+
+	storage := NewStorage(s.fs, cache.NewObjectLRUDefault())
+	cfg, err := storage.Config()
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Extensions.RefStorage, Equals, "reftable")
+}
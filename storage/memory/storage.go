@@ -295,6 +295,103 @@ func (r ReferenceStorage) RemoveReference(n plumbing.ReferenceName) error {
 	return nil
 }
 
+// BeginReferenceTransaction starts a new TxReferenceStorage over r.
+func (r ReferenceStorage) BeginReferenceTransaction() storer.ReferenceTransaction {
+	return &TxReferenceStorage{Storage: r}
+}
+
+type txReferenceUpdate struct {
+	name plumbing.ReferenceName
+	new  *plumbing.Reference
+	old  *plumbing.Reference
+}
+
+// TxReferenceStorage implements storer.ReferenceTransaction for a
+// ReferenceStorage. The updates are queued in memory and applied to Storage
+// on Commit, which cannot fail halfway.
+type TxReferenceStorage struct {
+	Storage ReferenceStorage
+	updates []txReferenceUpdate
+
+	prepared bool
+	closed   bool
+}
+
+// SetReference honors the storer.ReferenceTransaction interface.
+func (tx *TxReferenceStorage) SetReference(ref, old *plumbing.Reference) error {
+	if ref == nil {
+		return nil
+	}
+
+	return tx.queue(txReferenceUpdate{name: ref.Name(), new: ref, old: old})
+}
+
+// RemoveReference honors the storer.ReferenceTransaction interface.
+func (tx *TxReferenceStorage) RemoveReference(n plumbing.ReferenceName, old *plumbing.Reference) error {
+	return tx.queue(txReferenceUpdate{name: n, old: old})
+}
+
+func (tx *TxReferenceStorage) queue(u txReferenceUpdate) error {
+	if tx.closed || tx.prepared {
+		return storage.ErrReferenceTransactionClosed
+	}
+
+	for _, q := range tx.updates {
+		if q.name == u.name {
+			return fmt.Errorf("%w: %s", storage.ErrReferenceUpdateDuplicated, u.name)
+		}
+	}
+
+	tx.updates = append(tx.updates, u)
+	return nil
+}
+
+// Prepare honors the storer.ReferenceTransaction interface. It checks the
+// current value of every queued reference, aborting the transaction if any
+// of them has changed.
+func (tx *TxReferenceStorage) Prepare() error {
+	if tx.closed {
+		return storage.ErrReferenceTransactionClosed
+	}
+
+	for _, u := range tx.updates {
+		if !storage.ReferenceMatches(tx.Storage[u.name], u.old) {
+			_ = tx.Abort()
+			return storage.ErrReferenceHasChanged
+		}
+	}
+
+	tx.prepared = true
+	return nil
+}
+
+// Commit honors the storer.ReferenceTransaction interface. It prepares the
+// transaction if needed and applies every queued update.
+func (tx *TxReferenceStorage) Commit() error {
+	if err := tx.Prepare(); err != nil {
+		return err
+	}
+
+	for _, u := range tx.updates {
+		if u.new == nil {
+			delete(tx.Storage, u.name)
+			continue
+		}
+
+		tx.Storage[u.name] = u.new
+	}
+
+	return tx.Abort()
+}
+
+// Abort honors the storer.ReferenceTransaction interface. It drops the queued
+// updates and closes the transaction.
+func (tx *TxReferenceStorage) Abort() error {
+	tx.closed = true
+	tx.updates = nil
+	return nil
+}
+
 type ShallowStorage []plumbing.Hash
 
 func (s *ShallowStorage) SetShallow(commits []plumbing.Hash) error {
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"fmt"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+)
+
+// NewReferenceTransaction returns a reference transaction for the given
+// storer. If the storer implements storer.ReferenceTransactioner its own
+// transaction is used. Otherwise the updates are applied one by one on
+// Commit, and the references already updated are restored if one fails.
+func NewReferenceTransaction(s storer.ReferenceStorer) storer.ReferenceTransaction {
+	if t, ok := s.(storer.ReferenceTransactioner); ok {
+		return t.BeginReferenceTransaction()
+	}
+
+	return &referenceTransaction{s: s}
+}
+
+type referenceUpdate struct {
+	name plumbing.ReferenceName
+	// new is nil when the reference is being removed.
+	new *plumbing.Reference
+	old *plumbing.Reference
+	// prev is the value of the reference when the transaction was prepared.
+	prev *plumbing.Reference
+}
+
+type referenceTransaction struct {
+	s       storer.ReferenceStorer
+	updates []*referenceUpdate
+
+	prepared bool
+	closed   bool
+}
+
+func (t *referenceTransaction) SetReference(r, old *plumbing.Reference) error {
+	if r == nil {
+		return nil
+	}
+
+	return t.queue(&referenceUpdate{name: r.Name(), new: r, old: old})
+}
+
+func (t *referenceTransaction) RemoveReference(n plumbing.ReferenceName, old *plumbing.Reference) error {
+	return t.queue(&referenceUpdate{name: n, old: old})
+}
+
+func (t *referenceTransaction) queue(u *referenceUpdate) error {
+	if t.closed || t.prepared {
+		return ErrReferenceTransactionClosed
+	}
+
+	for _, q := range t.updates {
+		if q.name == u.name {
+			return fmt.Errorf("%w: %s", ErrReferenceUpdateDuplicated, u.name)
+		}
+	}
+
+	t.updates = append(t.updates, u)
+	return nil
+}
+
+func (t *referenceTransaction) Prepare() error {
+	if t.closed {
+		return ErrReferenceTransactionClosed
+	}
+
+	if t.prepared {
+		return nil
+	}
+
+	for _, u := range t.updates {
+		cur, err := t.s.Reference(u.name)
+		if err == plumbing.ErrReferenceNotFound {
+			cur, err = nil, nil
+		}
+
+		if err == nil && !ReferenceMatches(cur, u.old) {
+			err = ErrReferenceHasChanged
+		}
+
+		if err != nil {
+			_ = t.Abort()
+			return err
+		}
+
+		u.prev = cur
+	}
+
+	t.prepared = true
+	return nil
+}
+
+func (t *referenceTransaction) Commit() error {
+	if err := t.Prepare(); err != nil {
+		return err
+	}
+
+	t.closed = true
+	for i, u := range t.updates {
+		if err := t.apply(u.name, u.new); err != nil {
+			t.rollback(t.updates[:i])
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *referenceTransaction) rollback(applied []*referenceUpdate) {
+	for i := len(applied) - 1; i >= 0; i-- {
+		_ = t.apply(applied[i].name, applied[i].prev)
+	}
+}
+
+func (t *referenceTransaction) apply(n plumbing.ReferenceName, r *plumbing.Reference) error {
+	if r == nil {
+		return t.s.RemoveReference(n)
+	}
+
+	return t.s.SetReference(r)
+}
+
+func (t *referenceTransaction) Abort() error {
+	t.closed = true
+	t.updates = nil
+	return nil
+}
+
+// ReferenceMatches returns true if the current value of a reference, nil if
+// it doesn't exist, matches the old value given to a reference transaction.
+// A nil old value matches anything, and a hash reference to
+// plumbing.ZeroHash only matches a missing reference.
+func ReferenceMatches(cur, old *plumbing.Reference) bool {
+	if old == nil {
+		return true
+	}
+
+	if old.Type() == plumbing.HashReference && old.Hash().IsZero() {
+		return cur == nil
+	}
+
+	if cur == nil || cur.Type() != old.Type() {
+		return false
+	}
+
+	if old.Type() == plumbing.SymbolicReference {
+		return cur.Target() == old.Target()
+	}
+
+	return cur.Hash() == old.Hash()
+}
@@ -7,7 +7,15 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
-var ErrReferenceHasChanged = errors.New("reference has changed concurrently")
+var (
+	ErrReferenceHasChanged = errors.New("reference has changed concurrently")
+	// ErrReferenceTransactionClosed is returned when a reference transaction
+	// is used after being prepared, committed or aborted.
+	ErrReferenceTransactionClosed = errors.New("reference transaction is closed")
+	// ErrReferenceUpdateDuplicated is returned when a reference transaction
+	// is given more than one update for the same reference.
+	ErrReferenceUpdateDuplicated = errors.New("multiple updates for the same reference")
+)
 
 // Storer is a generic storage of objects, references and any information
 // related to a particular repository. The package github.com/go-git/go-git/v5/storage
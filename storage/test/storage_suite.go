@@ -354,6 +354,116 @@ func (s *BaseStorageSuite) TestRemoveReferenceNonExistent(c *C) {
 	c.Assert(e.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
 }
 
+func (s *BaseStorageSuite) TestReferenceTransactionCommit(c *C) {
+	err := s.Storer.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	)
+	c.Assert(err, IsNil)
+	err = s.Storer.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/bar", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	)
+	c.Assert(err, IsNil)
+
+	tx := storage.NewReferenceTransaction(s.Storer)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	)
+	c.Assert(err, IsNil)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/qux", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		plumbing.NewHashReference("refs/heads/qux", plumbing.ZeroHash),
+	)
+	c.Assert(err, IsNil)
+	err = tx.RemoveReference("refs/heads/bar", nil)
+	c.Assert(err, IsNil)
+
+	c.Assert(tx.Commit(), IsNil)
+
+	e, err := s.Storer.Reference("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(e.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+
+	e, err = s.Storer.Reference("refs/heads/qux")
+	c.Assert(err, IsNil)
+	c.Assert(e.Hash().String(), Equals, "bc9968d75e48de59f0870ffb71f5e160bbbdcf52")
+
+	_, err = s.Storer.Reference("refs/heads/bar")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	refs, err := s.Storer.IterReferences()
+	c.Assert(err, IsNil)
+	err = refs.ForEach(func(r *plumbing.Reference) error {
+		c.Assert(r.Name(), Not(Equals), plumbing.ReferenceName("refs/heads/qux.lock"))
+		return nil
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *BaseStorageSuite) TestReferenceTransactionChanged(c *C) {
+	err := s.Storer.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "6ecf0ef2c2dffb796033e5a02219af86ec6584e5"),
+	)
+	c.Assert(err, IsNil)
+
+	tx := storage.NewReferenceTransaction(s.Storer)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/bar", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "c3f4688a08fd86f1bf8e055724c84b7a40a09733"),
+	)
+	c.Assert(err, IsNil)
+
+	c.Assert(tx.Commit(), Equals, storage.ErrReferenceHasChanged)
+
+	e, err := s.Storer.Reference("refs/heads/foo")
+	c.Assert(err, IsNil)
+	c.Assert(e.Hash().String(), Equals, "6ecf0ef2c2dffb796033e5a02219af86ec6584e5")
+
+	_, err = s.Storer.Reference("refs/heads/bar")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+
+	// the failed transaction must have released its locks
+	tx = storage.NewReferenceTransaction(s.Storer)
+	err = tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/bar", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Commit(), IsNil)
+}
+
+func (s *BaseStorageSuite) TestReferenceTransactionAbort(c *C) {
+	tx := storage.NewReferenceTransaction(s.Storer)
+	err := tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+	c.Assert(tx.Prepare(), IsNil)
+	c.Assert(tx.Abort(), IsNil)
+	c.Assert(tx.Commit(), Equals, storage.ErrReferenceTransactionClosed)
+
+	_, err = s.Storer.Reference("refs/heads/foo")
+	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
+}
+
+func (s *BaseStorageSuite) TestReferenceTransactionDuplicated(c *C) {
+	tx := storage.NewReferenceTransaction(s.Storer)
+	err := tx.SetReference(
+		plumbing.NewReferenceFromStrings("refs/heads/foo", "bc9968d75e48de59f0870ffb71f5e160bbbdcf52"),
+		nil,
+	)
+	c.Assert(err, IsNil)
+	err = tx.RemoveReference("refs/heads/foo", nil)
+	c.Assert(errors.Is(err, storage.ErrReferenceUpdateDuplicated), Equals, true)
+	c.Assert(tx.Abort(), IsNil)
+}
+
 func (s *BaseStorageSuite) TestGetReferenceNotFound(c *C) {
 	r, err := s.Storer.Reference(plumbing.ReferenceName("bar"))
 	c.Assert(err, Equals, plumbing.ErrReferenceNotFound)
@@ -391,6 +501,13 @@ func (s *BaseStorageSuite) TestSetShallowAndShallow(c *C) {
 	result, err := s.Storer.Shallow()
 	c.Assert(err, IsNil)
 	c.Assert(result, DeepEquals, expected)
+
+	err = s.Storer.SetShallow(nil)
+	c.Assert(err, IsNil)
+
+	result, err = s.Storer.Shallow()
+	c.Assert(err, IsNil)
+	c.Assert(result, HasLen, 0)
 }
 
 func (s *BaseStorageSuite) TestSetConfigAndConfig(c *C) {
@@ -0,0 +1,447 @@
+package diff
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// Algorithm is a line oriented diff algorithm.
+type Algorithm int
+
+const (
+	// Myers is the default algorithm, the one used by Do. Some heuristics
+	// speed it up on large inputs at the cost of a diff which may not be the
+	// smallest one.
+	Myers Algorithm = iota
+	// Minimal is the Myers algorithm without heuristics, it always produces
+	// the smallest diff.
+	Minimal
+	// Patience matches first the lines which are unique on both sides, which
+	// usually aligns better the blocks of code.
+	Patience
+	// Histogram extends Patience, matching first the lines which are the
+	// less frequent on both sides.
+	Histogram
+)
+
+// histogramMaxChain is the number of occurrences of a line above which it's
+// not considered by the histogram algorithm, as in git.
+const histogramMaxChain = 64
+
+func (a Algorithm) String() string {
+	switch a {
+	case Myers:
+		return "myers"
+	case Minimal:
+		return "minimal"
+	case Patience:
+		return "patience"
+	case Histogram:
+		return "histogram"
+	}
+
+	return "unknown"
+}
+
+// Options describes how a diff is computed.
+type Options struct {
+	// Algorithm is the diff algorithm, Myers by default.
+	Algorithm Algorithm
+	// IgnoreAllSpace ignores whitespace when comparing lines, like -w.
+	IgnoreAllSpace bool
+	// IgnoreSpaceChange ignores changes in the amount of whitespace, and the
+	// whitespace at the end of the lines, like -b.
+	IgnoreSpaceChange bool
+}
+
+// DoWithOptions computes the (line oriented) modifications needed to turn the
+// src string into the dst string, with the given algorithm and whitespace
+// handling. The lines considered equal because of the whitespace options are
+// returned with their src content.
+func DoWithOptions(src, dst string, o Options) (diffs []diffmatchpatch.Diff) {
+	a, b := splitLines(src), splitLines(dst)
+	ka, kb := lineKeys(a, b, o)
+
+	var matches []match
+	switch o.Algorithm {
+	case Minimal:
+		matches = myers(ka, kb, 0, len(ka), 0, len(kb), 0)
+	case Patience:
+		matches = patience(ka, kb, 0, len(ka), 0, len(kb))
+	case Histogram:
+		matches = histogram(ka, kb, 0, len(ka), 0, len(kb))
+	default:
+		matches = myers(ka, kb, 0, len(ka), 0, len(kb), time.Hour)
+	}
+
+	return buildDiffs(a, b, matches)
+}
+
+// match is a pair of equal lines of the src and dst inputs.
+type match struct {
+	a, b int
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// lineKeys maps every line to an integer, equal lines according to the
+// options having the same key.
+func lineKeys(a, b []string, o Options) (ka, kb []int) {
+	keys := make(map[string]int)
+	key := func(l string) int {
+		switch {
+		case o.IgnoreAllSpace:
+			l = strings.Map(func(r rune) rune {
+				if unicode.IsSpace(r) {
+					return -1
+				}
+
+				return r
+			}, l)
+		case o.IgnoreSpaceChange:
+			l = collapseSpace(l)
+		}
+
+		k, ok := keys[l]
+		if !ok {
+			k = len(keys)
+			keys[l] = k
+		}
+
+		return k
+	}
+
+	ka = make([]int, len(a))
+	for i, l := range a {
+		ka[i] = key(l)
+	}
+
+	kb = make([]int, len(b))
+	for i, l := range b {
+		kb[i] = key(l)
+	}
+
+	return ka, kb
+}
+
+// collapseSpace replaces every sequence of whitespace of a line by a single
+// space, removing the trailing one.
+func collapseSpace(l string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range strings.TrimRightFunc(l, unicode.IsSpace) {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+
+		if space {
+			sb.WriteByte(' ')
+			space = false
+		}
+
+		sb.WriteRune(r)
+	}
+
+	return sb.String()
+}
+
+// buildDiffs converts the matched lines into diffs.
+func buildDiffs(a, b []string, matches []match) []diffmatchpatch.Diff {
+	diffs := []diffmatchpatch.Diff{}
+	add := func(t diffmatchpatch.Operation, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+
+		text := strings.Join(lines, "")
+		if n := len(diffs); n > 0 && diffs[n-1].Type == t {
+			diffs[n-1].Text += text
+			return
+		}
+
+		diffs = append(diffs, diffmatchpatch.Diff{Type: t, Text: text})
+	}
+
+	i, j := 0, 0
+	for _, m := range append(matches, match{len(a), len(b)}) {
+		add(diffmatchpatch.DiffDelete, a[i:m.a])
+		add(diffmatchpatch.DiffInsert, b[j:m.b])
+		if m.a < len(a) {
+			add(diffmatchpatch.DiffEqual, a[m.a:m.a+1])
+		}
+
+		i, j = m.a+1, m.b+1
+	}
+
+	return diffs
+}
+
+// keyRune converts a line key to a rune, skipping the invalid ones.
+func keyRune(k int) rune {
+	r := rune(k + 1)
+	if r >= 0xD800 {
+		r += 0x800
+	}
+
+	return r
+}
+
+// myers matches the lines in the ranges with the go-diff implementation of
+// the Myers algorithm. A zero timeout disables its heuristics.
+func myers(a, b []int, alo, ahi, blo, bhi int, timeout time.Duration) []match {
+	ra := make([]rune, ahi-alo)
+	for i := range ra {
+		ra[i] = keyRune(a[alo+i])
+	}
+
+	rb := make([]rune, bhi-blo)
+	for i := range rb {
+		rb[i] = keyRune(b[blo+i])
+	}
+
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = timeout
+
+	var matches []match
+	i, j := alo, blo
+	for _, d := range dmp.DiffMainRunes(ra, rb, false) {
+		n := utf8.RuneCountInString(d.Text)
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			for k := 0; k < n; k++ {
+				matches = append(matches, match{i + k, j + k})
+			}
+
+			i += n
+			j += n
+		case diffmatchpatch.DiffDelete:
+			i += n
+		case diffmatchpatch.DiffInsert:
+			j += n
+		}
+	}
+
+	return matches
+}
+
+// patience matches the lines in the ranges with the patience algorithm: the
+// longest sequence of lines unique on both sides is matched, then the ranges
+// between them are diffed recursively.
+func patience(a, b []int, alo, ahi, blo, bhi int) []match {
+	if alo == ahi || blo == bhi {
+		return nil
+	}
+
+	type occurrence struct {
+		countA, countB int
+		posA, posB     int
+	}
+
+	occurrences := make(map[int]*occurrence)
+	for i := alo; i < ahi; i++ {
+		o, ok := occurrences[a[i]]
+		if !ok {
+			o = &occurrence{}
+			occurrences[a[i]] = o
+		}
+
+		o.countA++
+		o.posA = i
+	}
+
+	for j := blo; j < bhi; j++ {
+		if o, ok := occurrences[b[j]]; ok {
+			o.countB++
+			o.posB = j
+		}
+	}
+
+	var unique []match
+	for i := alo; i < ahi; i++ {
+		if o := occurrences[a[i]]; o.countA == 1 && o.countB == 1 {
+			unique = append(unique, match{o.posA, o.posB})
+		}
+	}
+
+	if len(unique) == 0 {
+		return myers(a, b, alo, ahi, blo, bhi, 0)
+	}
+
+	var matches []match
+	i, j := alo, blo
+	anchors := longestIncreasing(unique)
+	for k := 0; k < len(anchors); k++ {
+		// The equal lines preceding an anchor are matched too.
+		m := anchors[k]
+		ia, jb := m.a, m.b
+		for ia > i && jb > j && a[ia-1] == b[jb-1] {
+			ia--
+			jb--
+		}
+
+		matches = append(matches, patience(a, b, i, ia, j, jb)...)
+		for ; ia <= m.a; ia, jb = ia+1, jb+1 {
+			matches = append(matches, match{ia, jb})
+		}
+
+		for k+1 < len(anchors) && anchors[k+1].a == m.a+1 && anchors[k+1].b == m.b+1 {
+			k++
+			m = anchors[k]
+			matches = append(matches, m)
+		}
+
+		i, j = m.a+1, m.b+1
+	}
+
+	for i < ahi && j < bhi && a[i] == b[j] {
+		matches = append(matches, match{i, j})
+		i++
+		j++
+	}
+
+	return append(matches, patience(a, b, i, ahi, j, bhi)...)
+}
+
+// longestIncreasing returns the longest subsequence of matches, sorted by
+// their src line, whose dst lines are increasing, using patience sorting.
+func longestIncreasing(matches []match) []match {
+	// tails[k] is the index of the smallest last element of an increasing
+	// subsequence of length k+1.
+	var tails []int
+	prev := make([]int, len(matches))
+	for i, m := range matches {
+		lo, hi := 0, len(tails)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if matches[tails[mid]].b < m.b {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+
+		prev[i] = -1
+		if lo > 0 {
+			prev[i] = tails[lo-1]
+		}
+
+		if lo == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[lo] = i
+		}
+	}
+
+	result := make([]match, len(tails))
+	for k, i := len(tails)-1, tails[len(tails)-1]; k >= 0; k, i = k-1, prev[i] {
+		result[k] = matches[i]
+	}
+
+	return result
+}
+
+// histogram matches the lines in the ranges with the histogram algorithm: the
+// longest common region containing the lines with the lowest number of
+// occurrences is matched, then the ranges around it are diffed recursively.
+func histogram(a, b []int, alo, ahi, blo, bhi int) []match {
+	var matches []match
+	for alo < ahi && blo < bhi {
+		ra, rb, n, ok := histogramRegion(a, b, alo, ahi, blo, bhi)
+		if !ok {
+			return append(matches, myers(a, b, alo, ahi, blo, bhi, 0)...)
+		}
+
+		if n == 0 {
+			break
+		}
+
+		matches = append(matches, histogram(a, b, alo, ra, blo, rb)...)
+		for k := 0; k < n; k++ {
+			matches = append(matches, match{ra + k, rb + k})
+		}
+
+		alo, blo = ra+n, rb+n
+	}
+
+	return matches
+}
+
+// histogramRegion returns the start and the length of the common region of
+// the ranges to split the diff at, a zero length meaning that the ranges have
+// nothing in common. It returns false if every common line occurs too many
+// times.
+func histogramRegion(a, b []int, alo, ahi, blo, bhi int) (ra, rb, n int, ok bool) {
+	positions := make(map[int][]int)
+	for i := alo; i < ahi; i++ {
+		positions[a[i]] = append(positions[a[i]], i)
+	}
+
+	common := false
+	count := histogramMaxChain + 1
+	for j := blo; j < bhi; {
+		next := j + 1
+		occ := positions[b[j]]
+		if len(occ) > 0 {
+			common = true
+		}
+
+		if len(occ) > count {
+			j = next
+			continue
+		}
+
+		for k := 0; k < len(occ); {
+			as, bs := occ[k], j
+			ae, be := as, bs
+			rc := len(occ)
+			for as > alo && bs > blo && a[as-1] == b[bs-1] {
+				as--
+				bs--
+				if c := len(positions[a[as]]); c < rc {
+					rc = c
+				}
+			}
+
+			for ae+1 < ahi && be+1 < bhi && a[ae+1] == b[be+1] {
+				ae++
+				be++
+				if c := len(positions[a[ae]]); c < rc {
+					rc = c
+				}
+			}
+
+			if next <= be {
+				next = be + 1
+			}
+
+			if n-1 < ae-as || rc < count {
+				ra, rb, n = as, bs, ae-as+1
+				count = rc
+			}
+
+			for k < len(occ) && occ[k] <= ae {
+				k++
+			}
+		}
+
+		j = next
+	}
+
+	return ra, rb, n, !common || count <= histogramMaxChain
+}
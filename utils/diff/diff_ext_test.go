@@ -138,3 +138,65 @@ func (s *suiteCommon) TestDo(c *C) {
 		c.Assert(diffs, DeepEquals, t.exp, Commentf("subtest %d", i))
 	}
 }
+
+func (s *suiteCommon) TestDoWithOptionsAll(c *C) {
+	for _, alg := range []diff.Algorithm{diff.Myers, diff.Minimal, diff.Patience, diff.Histogram} {
+		for i, t := range diffTests {
+			diffs := diff.DoWithOptions(t.src, t.dst, diff.Options{Algorithm: alg})
+			c.Assert(diff.Src(diffs), Equals, t.src, Commentf("%s subtest %d, bad calculated src", alg, i))
+			c.Assert(diff.Dst(diffs), Equals, t.dst, Commentf("%s subtest %d, bad calculated dst", alg, i))
+		}
+
+		for i, t := range doTests {
+			diffs := diff.DoWithOptions(t.src, t.dst, diff.Options{Algorithm: alg})
+			c.Assert(diff.Src(diffs), Equals, t.src, Commentf("%s subtest %d, bad calculated src", alg, i))
+			c.Assert(diff.Dst(diffs), Equals, t.dst, Commentf("%s subtest %d, bad calculated dst", alg, i))
+		}
+	}
+}
+
+func (s *suiteCommon) TestDoWithOptionsAlgorithm(c *C) {
+	src := "a\nb\nc\n{\n}\nd\n{\n}\ne\n"
+	dst := "a\nb\n{\n}\nc\n{\n}\nd\ne\n"
+
+	// the unique lines c and d are matched first
+	exp := []diffmatchpatch.Diff{
+		{Type: 0, Text: "a\nb\n"},
+		{Type: 1, Text: "{\n}\n"},
+		{Type: 0, Text: "c\n{\n}\nd\n"},
+		{Type: -1, Text: "{\n}\n"},
+		{Type: 0, Text: "e\n"},
+	}
+
+	for _, alg := range []diff.Algorithm{diff.Patience, diff.Histogram} {
+		c.Assert(diff.DoWithOptions(src, dst, diff.Options{Algorithm: alg}), DeepEquals, exp, Commentf("%s", alg))
+	}
+
+	// without heuristics the diff is the smallest one
+	src = "x\ny\nz\nx\ny\nz\n"
+	dst = "x\ny\nz\n"
+	c.Assert(diff.DoWithOptions(src, dst, diff.Options{Algorithm: diff.Minimal}), DeepEquals, []diffmatchpatch.Diff{
+		{Type: 0, Text: dst},
+		{Type: -1, Text: dst},
+	})
+}
+
+func (s *suiteCommon) TestDoWithOptionsWhitespace(c *C) {
+	src := "a b\nc\td\ne\n"
+	dst := "a  b \nc d\n e\n"
+
+	c.Assert(diff.DoWithOptions(src, dst, diff.Options{}), DeepEquals, []diffmatchpatch.Diff{
+		{Type: -1, Text: src},
+		{Type: 1, Text: dst},
+	})
+
+	c.Assert(diff.DoWithOptions(src, dst, diff.Options{IgnoreSpaceChange: true}), DeepEquals, []diffmatchpatch.Diff{
+		{Type: 0, Text: "a b\nc\td\n"},
+		{Type: -1, Text: "e\n"},
+		{Type: 1, Text: " e\n"},
+	})
+
+	c.Assert(diff.DoWithOptions(src, "ab\ncd\ne\n", diff.Options{IgnoreAllSpace: true}), DeepEquals, []diffmatchpatch.Diff{
+		{Type: 0, Text: src},
+	})
+}
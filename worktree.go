@@ -74,11 +74,16 @@ func (w *Worktree) PullContext(ctx context.Context, o *PullOptions) error {
 		RemoteName:      o.RemoteName,
 		RemoteURL:       o.RemoteURL,
 		Depth:           o.Depth,
+		Deepen:          o.Deepen,
+		Unshallow:       o.Unshallow,
+		ShallowSince:    o.ShallowSince,
+		ShallowExclude:  o.ShallowExclude,
 		Auth:            o.Auth,
 		Progress:        o.Progress,
 		Force:           o.Force,
 		InsecureSkipTLS: o.InsecureSkipTLS,
 		CABundle:        o.CABundle,
+		ProxyOptions:    o.ProxyOptions,
 	})
 
 	updated := true
@@ -0,0 +1,294 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/format/mbox"
+	"github.com/go-git/go-git/v5/utils/ioutil"
+)
+
+// Apply applies patch to the worktree, as git apply does. With the Index
+// option the patch is applied to the index as well, and with the Cached one
+// to the index only. A *fdiff.ParsedPatch is applied as is, the other patches
+// are encoded first, so they need the content of their binary files. Nothing
+// is changed if a file patch doesn't apply, but with ThreeWay the merged
+// files are written, the conflicting ones being left unstaged.
+func (w *Worktree) Apply(patch fdiff.Patch, o *ApplyOptions) error {
+	if o == nil {
+		o = &ApplyOptions{}
+	}
+
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	p, err := toParsedPatch(patch, o)
+	if err != nil {
+		return err
+	}
+
+	var idx *index.Index
+	read := w.readWorktreeFile
+	if o.Index || o.Cached {
+		idx, err = w.r.Storer.Index()
+		if err != nil {
+			return err
+		}
+
+		read = w.indexFileReader(idx, o.Index)
+	}
+
+	a := newPatchApplier(read)
+	a.fuzz = o.Fuzz
+	if o.ThreeWay {
+		a.blob = w.r.blobByPrefix
+	}
+
+	if err := a.applyPatch(p); err != nil {
+		return err
+	}
+
+	conflicts := a.conflictsError()
+	if o.Check || (o.Cached && conflicts != nil) {
+		return conflicts
+	}
+
+	switch {
+	case o.Cached:
+		if err := writeAppliedIndex(w.r.Storer, idx, a.changes()); err != nil {
+			return err
+		}
+
+		err = w.r.Storer.SetIndex(idx)
+	case o.Index:
+		err = w.writeAppliedFiles(a.changes())
+	default:
+		err = w.writeAppliedWorktree(a.changes())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return conflicts
+}
+
+// ApplyMailbox applies the patches of the emails read from r, in the mbox
+// format written by git format-patch, committing each of them with the
+// author, the date and the message of its email, as git am does. It returns
+// the hashes of the created commits, the ones created before an error
+// included. The index must not contain staged changes.
+func (w *Worktree) ApplyMailbox(r io.Reader, o *ApplyMailboxOptions) ([]plumbing.Hash, error) {
+	if o == nil {
+		o = &ApplyMailboxOptions{}
+	}
+
+	if err := o.Validate(w.r); err != nil {
+		return nil, err
+	}
+
+	status, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range status {
+		if s.Staging != Unmodified && s.Staging != Untracked {
+			return nil, ErrWorktreeNotClean
+		}
+	}
+
+	var hashes []plumbing.Hash
+	d := mbox.NewDecoder(r)
+	for {
+		m := &mbox.Message{}
+		if err := d.Decode(m); err != nil {
+			if err == io.EOF {
+				return hashes, nil
+			}
+
+			return hashes, err
+		}
+
+		h, err := w.applyMessage(m, o)
+		if err != nil {
+			return hashes, err
+		}
+
+		hashes = append(hashes, h)
+	}
+}
+
+func (w *Worktree) applyMessage(m *mbox.Message, o *ApplyMailboxOptions) (plumbing.Hash, error) {
+	a := newPatchApplier(w.readWorktreeFile)
+	if err := a.applyPatch(m.Patch.(*fdiff.ParsedPatch)); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("applying %q: %w", m.Subject, err)
+	}
+
+	if err := w.writeAppliedFiles(a.changes()); err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	author := m.Author
+	if author.When.IsZero() {
+		author.When = time.Now()
+	}
+
+	return w.Commit(m.CommitMessage(), &CommitOptions{
+		Author:    &author,
+		Committer: o.Committer,
+		SignKey:   o.SignKey,
+	})
+}
+
+// readWorktreeFile returns the content and the mode of a file of the
+// worktree, the target of a symlink being its content.
+func (w *Worktree) readWorktreeFile(path string) (content []byte, mode filemode.FileMode, err error) {
+	fi, err := w.Filesystem.Lstat(path)
+	if err != nil {
+		return nil, filemode.Empty, err
+	}
+
+	mode, err = filemode.NewFromOSFileMode(fi.Mode())
+	if err != nil {
+		return nil, filemode.Empty, err
+	}
+
+	if fi.Mode()&os.ModeSymlink != 0 {
+		target, err := w.Filesystem.Readlink(path)
+		return []byte(target), mode, err
+	}
+
+	f, err := w.Filesystem.Open(path)
+	if err != nil {
+		return nil, filemode.Empty, err
+	}
+
+	defer ioutil.CheckClose(f, &err)
+
+	buf := bytes.NewBuffer(nil)
+	_, err = io.Copy(buf, f)
+	return buf.Bytes(), mode, err
+}
+
+// indexFileReader returns a function reading the files of idx. If
+// matchWorktree is true, the worktree files must match their index entries.
+func (w *Worktree) indexFileReader(idx *index.Index, matchWorktree bool) func(string) ([]byte, filemode.FileMode, error) {
+	return func(path string) ([]byte, filemode.FileMode, error) {
+		e, err := idx.Entry(path)
+		if err == index.ErrEntryNotFound {
+			if _, err := w.Filesystem.Lstat(path); matchWorktree && err == nil {
+				return nil, filemode.Empty, fmt.Errorf("%w: %s: already exists in working directory", ErrPatchDoesNotApply, path)
+			}
+
+			return nil, filemode.Empty, os.ErrNotExist
+		}
+
+		if err != nil {
+			return nil, filemode.Empty, err
+		}
+
+		b, err := w.r.BlobObject(e.Hash)
+		if err != nil {
+			return nil, filemode.Empty, err
+		}
+
+		content, err := readBlob(b)
+		if err != nil {
+			return nil, filemode.Empty, err
+		}
+
+		if matchWorktree {
+			wc, mode, err := w.readWorktreeFile(path)
+			if err != nil || mode != e.Mode || !bytes.Equal(wc, content) {
+				return nil, filemode.Empty, fmt.Errorf("%w: %s: does not match index", ErrPatchDoesNotApply, path)
+			}
+		}
+
+		return content, e.Mode, nil
+	}
+}
+
+// writeAppliedWorktree writes the changed files to the worktree, leaving the
+// index untouched.
+func (w *Worktree) writeAppliedWorktree(files []*appliedFile) error {
+	for _, f := range files {
+		if f.deleted {
+			if err := w.Filesystem.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+
+			continue
+		}
+
+		if err := w.writeWorktreeFile(f); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeAppliedFiles writes the changed files to the worktree and stages them,
+// except the ones merged with conflicts.
+func (w *Worktree) writeAppliedFiles(files []*appliedFile) error {
+	for _, f := range files {
+		if f.deleted {
+			if _, err := w.Remove(f.path); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if err := w.writeWorktreeFile(f); err != nil {
+			return err
+		}
+
+		if f.conflict {
+			continue
+		}
+
+		if _, err := w.Add(f.path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *Worktree) writeWorktreeFile(f *appliedFile) (err error) {
+	// The file is removed first to apply mode changes, billy doesn't
+	// implement chmod.
+	if _, err := w.Filesystem.Lstat(f.path); err == nil {
+		if err := w.Filesystem.Remove(f.path); err != nil {
+			return err
+		}
+	}
+
+	if f.mode == filemode.Symlink {
+		return w.Filesystem.Symlink(string(f.content), f.path)
+	}
+
+	mode, err := f.mode.ToOSFileMode()
+	if err != nil {
+		return err
+	}
+
+	to, err := w.Filesystem.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	defer ioutil.CheckClose(to, &err)
+	_, err = to.Write(f.content)
+	return err
+}
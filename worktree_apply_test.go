@@ -0,0 +1,412 @@
+package git
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/mbox"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	. "gopkg.in/check.v1"
+)
+
+func (s *WorktreeSuite) TestApplyMailbox(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	files := map[string]string{
+		"a.txt":      "one\ntwo\nthree\nfour\nfive\nsix\nseven\n",
+		"old.txt":    "keep\nme\n",
+		"script":     "#!/bin/sh\n",
+		"gone.txt":   "del\n",
+		"bin":        "\x00\x01bin",
+		"dir/nested": "nested\n",
+	}
+	for name, content := range files {
+		c.Assert(util.WriteFile(fs, name, []byte(content), 0644), IsNil)
+		_, err = w.Add(name)
+		c.Assert(err, IsNil)
+	}
+
+	base, err := w.Commit("Initial\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "a.txt", []byte("one\n2\nthree\nfour\nfive\nsix\nseven"), 0644), IsNil)
+	_, err = w.Move("old.txt", "new.txt")
+	c.Assert(err, IsNil)
+	c.Assert(fs.Remove("script"), IsNil)
+	c.Assert(util.WriteFile(fs, "script", []byte("#!/bin/sh\n"), 0755), IsNil)
+	_, err = w.Remove("gone.txt")
+	c.Assert(err, IsNil)
+	c.Assert(util.WriteFile(fs, "bin", []byte("\x00\x02bin\xff"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "dir/new", []byte("new\n"), 0644), IsNil)
+	c.Assert(w.AddWithOptions(&AddOptions{All: true}), IsNil)
+
+	author := &object.Signature{
+		Name:  "Jösé Dev",
+		Email: "jose@example.com",
+		When:  time.Date(2020, 10, 14, 12, 0, 0, 0, time.FixedZone("", 2*60*60)),
+	}
+	expected, err := w.Commit("Change things\n\nWith a body.\n", &CommitOptions{Author: author})
+	c.Assert(err, IsNil)
+
+	expectedCommit, err := r.CommitObject(expected)
+	c.Assert(err, IsNil)
+
+	m, err := mbox.NewMessage(expectedCommit)
+	c.Assert(err, IsNil)
+
+	buf := bytes.NewBuffer(nil)
+	c.Assert(mbox.NewEncoder(buf).Encode(m), IsNil)
+
+	c.Assert(w.Reset(&ResetOptions{Commit: base, Mode: HardReset}), IsNil)
+
+	committer := &object.Signature{Name: "Committer", Email: "c@example.com", When: time.Now()}
+	hashes, err := w.ApplyMailbox(buf, &ApplyMailboxOptions{Committer: committer})
+	c.Assert(err, IsNil)
+	c.Assert(hashes, HasLen, 1)
+
+	commit, err := r.CommitObject(hashes[0])
+	c.Assert(err, IsNil)
+	c.Assert(commit.TreeHash, Equals, expectedCommit.TreeHash)
+	c.Assert(commit.Message, Equals, expectedCommit.Message)
+	c.Assert(commit.Author.Name, Equals, author.Name)
+	c.Assert(commit.Author.Email, Equals, author.Email)
+	c.Assert(commit.Author.When.Equal(author.When), Equals, true)
+	c.Assert(commit.Committer.Name, Equals, "Committer")
+	c.Assert(commit.ParentHashes, DeepEquals, []plumbing.Hash{base})
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestApplyMailboxSeries(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nb\nc\n"), 0644), IsNil)
+	_, err = w.Add("file")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("Initial\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	// As written by git format-patch.
+	hashes, err := w.ApplyMailbox(strings.NewReader(`From 1111111111111111111111111111111111111111 Mon Sep 17 00:00:00 2001
+From: First <first@example.com>
+Date: Wed, 14 Oct 2020 12:00:00 +0200
+Subject: [PATCH 1/2] Change b
+
+---
+ file | 2 +-
+ 1 file changed, 1 insertion(+), 1 deletion(-)
+
+diff --git a/file b/file
+index de98044..4f9b7f9 100644
+--- a/file
++++ b/file
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+--
+2.39.5
+
+
+From 2222222222222222222222222222222222222222 Mon Sep 17 00:00:00 2001
+From: Second <second@example.com>
+Date: Thu, 15 Oct 2020 12:00:00 +0000
+Subject: [PATCH 2/2] Add d
+
+Because d was missing.
+---
+ file | 1 +
+ 1 file changed, 1 insertion(+)
+
+diff --git a/file b/file
+index 4f9b7f9..a8a9a23 100644
+--- a/file
++++ b/file
+@@ -1,3 +1,4 @@
+ a
+ B
+ c
++d
+--
+2.39.5
+
+`), nil)
+	c.Assert(err, IsNil)
+	c.Assert(hashes, HasLen, 2)
+
+	content, err := util.ReadFile(fs, "file")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "a\nB\nc\nd\n")
+
+	first, err := r.CommitObject(hashes[0])
+	c.Assert(err, IsNil)
+	c.Assert(first.Author.Name, Equals, "First")
+	c.Assert(first.Message, Equals, "Change b\n")
+
+	second, err := r.CommitObject(hashes[1])
+	c.Assert(err, IsNil)
+	c.Assert(second.Author.Name, Equals, "Second")
+	c.Assert(second.Message, Equals, "Add d\n\nBecause d was missing.\n")
+	c.Assert(second.ParentHashes, DeepEquals, []plumbing.Hash{hashes[0]})
+}
+
+func (s *WorktreeSuite) TestApplyMailboxDoesNotApply(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nx\nc\n"), 0644), IsNil)
+	_, err = w.Add("file")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("Initial\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	hashes, err := w.ApplyMailbox(strings.NewReader(`From: First <first@example.com>
+Subject: [PATCH] Change b
+
+---
+diff --git a/file b/file
+--- a/file
++++ b/file
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`), nil)
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+	c.Assert(hashes, HasLen, 0)
+
+	content, err := util.ReadFile(fs, "file")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "a\nx\nc\n")
+}
+
+func (s *WorktreeSuite) TestApplyMailboxStagedChanges(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\n"), 0644), IsNil)
+	_, err = w.Add("file")
+	c.Assert(err, IsNil)
+
+	_, err = w.ApplyMailbox(strings.NewReader(""), nil)
+	c.Assert(err, Equals, ErrWorktreeNotClean)
+}
+
+func (s *WorktreeSuite) TestApply(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nb\nc\n"), 0644), IsNil)
+	c.Assert(util.WriteFile(fs, "gone", []byte("x\n"), 0644), IsNil)
+	c.Assert(w.AddWithOptions(&AddOptions{All: true}), IsNil)
+	_, err = w.Commit("Initial\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	patch := decodePatch(c, `diff --git a/file b/file
+--- a/file
++++ b/file
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+diff --git a/gone b/gone
+deleted file mode 100644
+--- a/gone
++++ /dev/null
+@@ -1 +0,0 @@
+-x
+diff --git a/dir/new b/dir/new
+new file mode 100755
+--- /dev/null
++++ b/dir/new
+@@ -0,0 +1 @@
++new
+`)
+
+	c.Assert(w.Apply(patch, &ApplyOptions{Check: true}), IsNil)
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+
+	c.Assert(w.Apply(patch, nil), IsNil)
+
+	content, err := util.ReadFile(fs, "file")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "a\nB\nc\n")
+
+	_, err = fs.Lstat("gone")
+	c.Assert(err, NotNil)
+
+	fi, err := fs.Lstat("dir/new")
+	c.Assert(err, IsNil)
+	c.Assert(fi.Mode().Perm(), Equals, os.FileMode(0755))
+
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("file").Staging, Equals, Unmodified)
+	c.Assert(status.File("file").Worktree, Equals, Modified)
+	c.Assert(status.File("gone").Worktree, Equals, Deleted)
+	c.Assert(status.File("dir/new").Worktree, Equals, Untracked)
+
+	// Once applied, it doesn't apply anymore but can be reverted.
+	err = w.Apply(patch, nil)
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+
+	c.Assert(w.Apply(patch, &ApplyOptions{Reverse: true}), IsNil)
+	status, err = w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.IsClean(), Equals, true)
+}
+
+func (s *WorktreeSuite) TestApplyIndex(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nb\nc\n"), 0644), IsNil)
+	_, err = w.Add("file")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("Initial\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	patch := decodePatch(c, `--- a/file
++++ b/file
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`)
+
+	// The worktree must match the index.
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nb\nc\nd\n"), 0644), IsNil)
+	err = w.Apply(patch, &ApplyOptions{Index: true})
+	c.Assert(errors.Is(err, ErrPatchDoesNotApply), Equals, true)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nb\nc\n"), 0644), IsNil)
+	c.Assert(w.Apply(patch, &ApplyOptions{Index: true}), IsNil)
+
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("file").Staging, Equals, Modified)
+	c.Assert(status.File("file").Worktree, Equals, Unmodified)
+}
+
+func (s *WorktreeSuite) TestApplyCached(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nb\nc\n"), 0644), IsNil)
+	_, err = w.Add("file")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("Initial\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	// The worktree is ignored.
+	c.Assert(util.WriteFile(fs, "file", []byte("x\n"), 0644), IsNil)
+	c.Assert(w.Apply(decodePatch(c, `--- a/file
++++ b/file
+@@ -1,3 +1,3 @@
+ a
+-b
++B
+ c
+`), &ApplyOptions{Cached: true}), IsNil)
+
+	content, err := util.ReadFile(fs, "file")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "x\n")
+
+	idx, err := r.Storer.Index()
+	c.Assert(err, IsNil)
+
+	e, err := idx.Entry("file")
+	c.Assert(err, IsNil)
+	c.Assert(e.Hash, Equals, plumbing.ComputeHash(plumbing.BlobObject, []byte("a\nB\nc\n")))
+}
+
+func (s *WorktreeSuite) TestApplyThreeWay(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	base := "a\nb\nc\nd\n"
+	c.Assert(util.WriteFile(fs, "file", []byte(base), 0644), IsNil)
+	_, err = w.Add("file")
+	c.Assert(err, IsNil)
+	_, err = w.Commit("Initial\n", &CommitOptions{Author: defaultSignature()})
+	c.Assert(err, IsNil)
+
+	c.Assert(util.WriteFile(fs, "file", []byte("a\nX\nc\nd\n"), 0644), IsNil)
+	_, err = w.Add("file")
+	c.Assert(err, IsNil)
+
+	patch := decodePatch(c, `diff --git a/file b/file
+index `+plumbing.ComputeHash(plumbing.BlobObject, []byte(base)).String()+`..0000000 100644
+--- a/file
++++ b/file
+@@ -1,4 +1,4 @@
+ a
+-b
++Y
+ c
+ d
+`)
+
+	err = w.Apply(patch, &ApplyOptions{ThreeWay: true})
+	c.Assert(errors.Is(err, ErrPatchConflicts), Equals, true)
+
+	content, err := util.ReadFile(fs, "file")
+	c.Assert(err, IsNil)
+	c.Assert(string(content), Equals, "a\n<<<<<<< ours\nX\n=======\nY\n>>>>>>> theirs\nc\nd\n")
+
+	// The conflicting file isn't staged.
+	status, err := w.Status()
+	c.Assert(err, IsNil)
+	c.Assert(status.File("file").Worktree, Equals, Modified)
+}
@@ -100,6 +100,10 @@ func (w *Worktree) updateHEAD(commit plumbing.Hash) error {
 }
 
 func (w *Worktree) buildCommitObject(msg string, opts *CommitOptions, tree plumbing.Hash) (plumbing.Hash, error) {
+	if len(opts.Trailers) != 0 {
+		msg = object.AddTrailers(msg, opts.Trailers, opts.TrailerOptions)
+	}
+
 	commit := &object.Commit{
 		Author:       *opts.Author,
 		Committer:    *opts.Committer,
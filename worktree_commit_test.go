@@ -175,6 +175,42 @@ func (s *WorktreeSuite) TestRemoveAndCommitAll(c *C) {
 	assertStorageStatus(c, s.Repository, 13, 11, 11, expected)
 }
 
+func (s *WorktreeSuite) TestCommitTrailers(c *C) {
+	fs := memfs.New()
+	r, err := Init(memory.NewStorage(), fs)
+	c.Assert(err, IsNil)
+
+	cfg, err := r.Config()
+	c.Assert(err, IsNil)
+	cfg.Raw.Section("trailer").Subsection("co").SetOption("key", "Co-authored-by")
+	c.Assert(r.SetConfig(cfg), IsNil)
+
+	w, err := r.Worktree()
+	c.Assert(err, IsNil)
+
+	util.WriteFile(fs, "foo", []byte("foo"), 0644)
+
+	_, err = w.Add("foo")
+	c.Assert(err, IsNil)
+
+	hash, err := w.Commit("foo\n\nSigned-off-by: foo <foo@foo.foo>\n", &CommitOptions{
+		Author: defaultSignature(),
+		Trailers: []object.Trailer{
+			{Token: "co", Value: "bar <bar@bar.bar>"},
+			{Token: "Signed-off-by", Value: "foo <foo@foo.foo>"},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	commit, err := r.CommitObject(hash)
+	c.Assert(err, IsNil)
+	c.Assert(commit.Message, Equals, "foo\n\n"+
+		"Signed-off-by: foo <foo@foo.foo>\n"+
+		"Co-authored-by: bar <bar@bar.bar>\n"+
+		"Signed-off-by: foo <foo@foo.foo>\n")
+	c.Assert(commit.Trailers(), HasLen, 3)
+}
+
 func (s *WorktreeSuite) TestCommitSign(c *C) {
 	fs := memfs.New()
 	storage := memory.NewStorage()